@@ -0,0 +1,179 @@
+// Command attest records and verifies in-toto attestations for build steps.
+//
+// The run subcommand wraps a build step: it hashes the declared materials,
+// runs the command, hashes the declared products and writes
+// <out-dir>/<step>.link.json. The verify subcommand checks the recorded
+// steps against the files on disk and the digests in manifest.json.
+//
+// Usage:
+//
+//	go run ./cmd/attest run -step kernel-fetch-x86_64 -products build/vmlinux-x86_64 -out-dir build -- ./scripts/download-kernel.sh ...
+//	go run ./cmd/attest verify -build-dir build
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/attest"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	if len(os.Args) < 2 {
+		return fmt.Errorf("usage: attest <run|verify> [flags]")
+	}
+
+	switch os.Args[1] {
+	case "run":
+		return runStep(os.Args[2:])
+	case "verify":
+		return verify(os.Args[2:])
+	default:
+		return fmt.Errorf("unknown subcommand %q (expected run or verify)", os.Args[1])
+	}
+}
+
+func runStep(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var (
+		step      string
+		materials string
+		products  string
+		outDir    string
+	)
+	fs.StringVar(&step, "step", "", "Step name (e.g. kernel-fetch-x86_64)")
+	fs.StringVar(&materials, "materials", "", "Comma separated files consumed by the step")
+	fs.StringVar(&products, "products", "", "Comma separated files produced by the step")
+	fs.StringVar(&outDir, "out-dir", "build", "Directory to write the attestation to")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	st, err := attest.Run(context.Background(), attest.Step{
+		Name:      step,
+		Command:   fs.Args(),
+		Materials: splitList(materials),
+		Products:  splitList(products),
+	}, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return fmt.Errorf("creating %s: %w", outDir, err)
+	}
+	path, err := attest.Write(outDir, st)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Wrote attestation", "path", path)
+	return nil
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		buildDir       string
+		attestationDir string
+		manifestPath   string
+	)
+	fs.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	fs.StringVar(&attestationDir, "attestation-dir", "", "Directory holding the step attestations (default: <build-dir>)")
+	fs.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if attestationDir == "" {
+		attestationDir = buildDir
+	}
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+
+	sts, err := attest.ReadDir(attestationDir)
+	if err != nil {
+		return fmt.Errorf("loading attestations: %w", err)
+	}
+	if len(sts) == 0 {
+		return fmt.Errorf("no attestations found in %s", attestationDir)
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+
+	// Step products are recorded relative to the repository root, so the
+	// manifest files are looked up under the build dir.
+	expected := map[string]string{}
+	for _, a := range m.Artifacts {
+		for _, k := range a.Kernels() {
+			expected[filepath.ToSlash(filepath.Join(buildDir, k.File))] = k.SHA256
+			for _, img := range k.Images {
+				expected[filepath.ToSlash(filepath.Join(buildDir, img.File))] = img.SHA256
+			}
+		}
+		for _, r := range a.Rootfses() {
+			expected[filepath.ToSlash(filepath.Join(buildDir, r.File))] = r.SHA256
+			for _, img := range r.ExtraImages() {
+				expected[filepath.ToSlash(filepath.Join(buildDir, img.File))] = img.SHA256
+			}
+		}
+		if a.Initramfs != nil {
+			expected[filepath.ToSlash(filepath.Join(buildDir, a.Initramfs.File))] = a.Initramfs.SHA256
+		}
+		for _, b := range a.Firecracker.Binaries() {
+			expected[filepath.ToSlash(filepath.Join(buildDir, b.File))] = b.SHA256
+		}
+	}
+	for _, d := range m.Disks {
+		expected[filepath.ToSlash(filepath.Join(buildDir, d.File))] = d.SHA256
+	}
+
+	if err := attest.Verify(sts, "", expected); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(sts))
+	for _, st := range sts {
+		names = append(names, st.Predicate.Name)
+	}
+	sort.Strings(names)
+	slog.Info("Verified attested steps", "count", len(sts), "steps", strings.Join(names, ", "))
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,339 @@
+// Command boot-matrix boots each built kernel with a matrix of kernel cmdline
+// variations and records which combinations reach userspace.
+//
+// The matrix axes (console verbosity, root= forms, init= overrides...) come
+// from boot_test.matrix in config.yaml, falling back to a built-in matrix of
+// common variations. Every kernel flavor is booted with the rootfs (and the
+// initramfs when initramfs.enabled is set), results
+// are written to boot-matrix-<arch>.json (boot-matrix-<flavor>-<arch>.json for
+// named flavors) in the build dir. Requires KVM and a firecracker binary.
+//
+// Every architecture also gets a self check boot (sbx.selfcheck=1, with a
+// virtio-rng device) reporting whether the guest clock is synced and entropy
+// is available quickly, recorded as capability flags in the report and then
+// in the manifest.
+//
+// With -kernel-format bzimage the bzImage of the flavors packaging one
+// (kernel.bzimage) is booted instead of the vmlinux, other flavors are
+// skipped. With -profile the image of that rootfs profile is booted instead
+// of the default rootfs.profile image.
+//
+// Usage:
+//
+//	go run ./cmd/boot-matrix -config config.yaml -build-dir build -firecracker /usr/local/bin/firecracker
+//	go run ./cmd/boot-matrix -config config.yaml -build-dir build -kernel-format bzimage
+//	go run ./cmd/boot-matrix -config config.yaml -build-dir build -profile minimal
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/boot"
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// defaultMatrix covers the cmdline variations consumers commonly use.
+var defaultMatrix = []config.BootArgsAxis{
+	{Name: "console", Values: []string{"console=ttyS0", "console=ttyS0 quiet", "console=ttyS0 loglevel=8 debug"}},
+	{Name: "root", Values: []string{"root=/dev/vda", "root=/dev/vda rw", "root=/dev/vda ro rootfstype=ext4 rootwait"}},
+	{Name: "init", Values: []string{"", "init=/sbin/init", "init=/usr/sbin/sbx-init"}},
+}
+
+const defaultBaseArgs = "reboot=k panic=1 pci=off"
+
+// Report is the boot matrix result of a kernel flavor of an architecture.
+type Report struct {
+	Arch string `json:"arch"`
+	// Flavor is the booted kernel flavor, empty for the default kernel.
+	Flavor string `json:"flavor,omitempty"`
+	Kernel string `json:"kernel"`
+	// KernelFormat is the image format of Kernel.
+	KernelFormat string `json:"kernel_format,omitempty"`
+	Rootfs       string `json:"rootfs"`
+	// Profile is the rootfs profile of Rootfs.
+	Profile string `json:"profile,omitempty"`
+	// Initrd is the booted initramfs, when the release ships one.
+	Initrd  string   `json:"initrd,omitempty"`
+	Date    string   `json:"date"`
+	Results []Result `json:"results"`
+	// SelfCheck is the guest clock and entropy self check.
+	SelfCheck *boot.SelfCheck `json:"self_check,omitempty"`
+	// Capabilities are the self check results as flags.
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+}
+
+// Result is the outcome of booting a single cmdline combination.
+type Result struct {
+	BootArgs    string            `json:"boot_args"`
+	Axes        map[string]string `json:"axes"`
+	Booted      bool              `json:"booted"`
+	DurationMS  int64             `json:"duration_ms"`
+	Reason      string            `json:"reason,omitempty"`
+	ConsoleTail string            `json:"console_tail,omitempty"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath   string
+		buildDir     string
+		firecracker  string
+		kernelFormat string
+		profileName  string
+		strict       bool
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&firecracker, "firecracker", "firecracker", "Path to the firecracker binary")
+	flag.StringVar(&kernelFormat, "kernel-format", "", "Kernel image format booted (default: the vmlinux format of each architecture)")
+	flag.StringVar(&profileName, "profile", "", "Rootfs profile booted (default: rootfs.profile)")
+	flag.BoolVar(&strict, "strict", false, "Exit with an error when any combination fails to boot")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	profiles := cfg.RootfsProfiles()
+	profile := profiles[0]
+	if profileName != "" {
+		i := slices.IndexFunc(profiles, func(p config.RootfsProfile) bool { return p.Name == profileName })
+		if i < 0 {
+			return fmt.Errorf("unknown rootfs profile %q", profileName)
+		}
+		profile = profiles[i]
+	}
+
+	if err := boot.Available(firecracker); err != nil {
+		return err
+	}
+
+	axes := cfg.BootTest.Matrix
+	if len(axes) == 0 {
+		axes = defaultMatrix
+	}
+	baseArgs := cfg.BootTest.BaseArgs
+	if baseArgs == "" {
+		baseArgs = defaultBaseArgs
+	}
+	combos := expand(axes)
+
+	failed := 0
+	for _, arch := range cfg.Architectures {
+		for _, f := range cfg.KernelFlavors() {
+			if f.Hypervisor != manifest.HypervisorFirecracker {
+				slog.Info("Skipping flavor of another hypervisor", "flavor", f.Name, "arch", arch, "hypervisor", f.Hypervisor)
+				continue
+			}
+			format := cmp.Or(kernelFormat, manifest.KernelFormat(arch))
+			if !slices.Contains(f.ImageFormats(arch), format) {
+				slog.Info("Skipping flavor without kernel image", "flavor", f.Name, "arch", arch, "format", format)
+				continue
+			}
+
+			report := Report{
+				Arch:         arch,
+				Kernel:       manifest.KernelImageFile(format, f.Name, arch),
+				KernelFormat: format,
+				Rootfs:       fmt.Sprintf("rootfs-%s.ext4", profile.Stem(arch)),
+				Profile:      profile.Name,
+				Date:         time.Now().UTC().Format(time.RFC3339),
+			}
+			if f.Name != manifest.DefaultFlavor {
+				report.Flavor = f.Name
+			}
+			if cfg.Initramfs.Enabled {
+				report.Initrd = fmt.Sprintf("initramfs-%s.cpio.gz", arch)
+			}
+
+			for _, combo := range combos {
+				args := joinArgs(append(combo.fragments, baseArgs)...)
+				res, err := boot.Run(context.Background(), boot.Options{
+					Firecracker: firecracker,
+					Kernel:      filepath.Join(buildDir, report.Kernel),
+					Rootfs:      filepath.Join(buildDir, report.Rootfs),
+					Initrd:      initrdPath(buildDir, report),
+					BootArgs:    args,
+					Timeout:     cfg.BootTest.Timeout,
+				})
+				if err != nil {
+					return fmt.Errorf("booting %s with %q: %w", report.Kernel, args, err)
+				}
+
+				r := Result{
+					BootArgs:   args,
+					Axes:       combo.axes,
+					Booted:     res.Booted,
+					DurationMS: res.Duration.Milliseconds(),
+					Reason:     res.Reason,
+				}
+				if !res.Booted {
+					failed++
+					r.ConsoleTail = tail(string(res.Console), 20)
+				}
+				report.Results = append(report.Results, r)
+			}
+
+			sc, err := selfCheck(firecracker, buildDir, report, baseArgs, cfg.BootTest.Timeout)
+			if err != nil {
+				return fmt.Errorf("self check of %s: %w", report.Kernel, err)
+			}
+			report.SelfCheck = &sc
+			report.Capabilities = sc.Capabilities()
+			if !report.Capabilities["clock_synced"] || !report.Capabilities["entropy_ready"] {
+				failed++
+			}
+
+			path := filepath.Join(buildDir, f.ArtifactName("boot-matrix", arch)+".json")
+			if err := writeReport(path, report); err != nil {
+				return err
+			}
+			printReport(report)
+			slog.Info("Wrote boot matrix report", "path", path)
+		}
+	}
+
+	if strict && failed > 0 {
+		return fmt.Errorf("%d boot checks failed", failed)
+	}
+	return nil
+}
+
+// selfCheck boots the images with the sbx-selfcheck guest service enabled
+// and parses its clock and entropy report.
+func selfCheck(firecracker, buildDir string, r Report, baseArgs string, timeout time.Duration) (boot.SelfCheck, error) {
+	res, err := boot.Run(context.Background(), boot.Options{
+		Firecracker: firecracker,
+		Kernel:      filepath.Join(buildDir, r.Kernel),
+		Rootfs:      filepath.Join(buildDir, r.Rootfs),
+		Initrd:      initrdPath(buildDir, r),
+		BootArgs:    joinArgs("console=ttyS0", baseArgs, boot.SelfCheckArg),
+		Timeout:     timeout,
+		Ready:       boot.SelfCheckReadyPattern,
+		Entropy:     true,
+	})
+	if err != nil {
+		return boot.SelfCheck{}, err
+	}
+
+	host := res.ReadyAt
+	if host.IsZero() {
+		host = time.Now()
+	}
+	return boot.ParseSelfCheck(res.Console, host), nil
+}
+
+// initrdPath returns the path of the initramfs the report boots, empty
+// without one.
+func initrdPath(buildDir string, r Report) string {
+	if r.Initrd == "" {
+		return ""
+	}
+	return filepath.Join(buildDir, r.Initrd)
+}
+
+type combination struct {
+	fragments []string
+	axes      map[string]string
+}
+
+// expand returns the cartesian product of the axes values.
+func expand(axes []config.BootArgsAxis) []combination {
+	combos := []combination{{axes: map[string]string{}}}
+	for _, axis := range axes {
+		if len(axis.Values) == 0 {
+			continue
+		}
+
+		next := make([]combination, 0, len(combos)*len(axis.Values))
+		for _, c := range combos {
+			for _, v := range axis.Values {
+				axesCopy := maps.Clone(c.axes)
+				axesCopy[axis.Name] = v
+				next = append(next, combination{
+					fragments: append(append([]string{}, c.fragments...), v),
+					axes:      axesCopy,
+				})
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+func joinArgs(fragments ...string) string {
+	var parts []string
+	for _, f := range fragments {
+		if f = strings.TrimSpace(f); f != "" {
+			parts = append(parts, f)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func tail(s string, lines int) string {
+	all := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n")
+}
+
+func writeReport(path string, r Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
+
+func printReport(r Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ARCH\tRESULT\tTIME\tBOOT ARGS\n")
+	for _, res := range r.Results {
+		status := "ok"
+		if !res.Booted {
+			status = "FAIL (" + res.Reason + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%dms\t%s\n", r.Arch, status, res.DurationMS, res.BootArgs)
+	}
+	w.Flush()
+
+	if r.SelfCheck != nil {
+		var flags []string
+		for _, name := range slices.Sorted(maps.Keys(r.Capabilities)) {
+			flags = append(flags, fmt.Sprintf("%s=%t", name, r.Capabilities[name]))
+		}
+		fmt.Printf("%s self check: %s\n", r.Arch, strings.Join(flags, " "))
+	}
+}
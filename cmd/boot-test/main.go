@@ -0,0 +1,279 @@
+// Command boot-test smoke boots every built rootfs profile of the host
+// architecture under Firecracker, the release gate proving the images reach
+// userspace.
+//
+// Each profile image is booted read-only with the default kernel, the
+// initramfs when initramfs.enabled is set and the profile boot args
+// (boot_args in config.yaml). A boot passes once the serial console shows
+// the guest init (boot.DefaultReadyPattern) and, with -agent-port, the guest
+// agent accepts a connection on that vsock port. The console log is saved to
+// boot-test-<profile stem>.log and the result to boot-test-<profile
+// stem>.json in the build dir; the command fails when any boot didn't reach
+// userspace within the timeout (boot_test.timeout unless -timeout is set).
+//
+// Every image is booted boot_test.runs times (-runs), the report recording
+// the time to init and to the agent answering of each run and their p50 and
+// p95, published by cmd/manifest as the build boot_times. The console log is
+// the one of the last (or failed) run.
+//
+// Only the host architecture is booted. Without KVM or a firecracker binary
+// the boots are skipped with a warning, or fail with -require-kvm (CI
+// runners that must gate the release).
+//
+// Usage:
+//
+//	go run ./cmd/boot-test -config config.yaml -build-dir build -firecracker /usr/local/bin/firecracker
+//	go run ./cmd/boot-test -config config.yaml -build-dir build -agent-port 1024 -require-kvm
+//	go run ./cmd/boot-test -config config.yaml -build-dir build -profile minimal -timeout 1m
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/boot"
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/preflight"
+)
+
+// Report is the smoke boot result of a rootfs profile of an architecture.
+type Report struct {
+	Arch    string `json:"arch"`
+	Profile string `json:"profile"`
+	Kernel  string `json:"kernel"`
+	Rootfs  string `json:"rootfs"`
+	// Initrd is the booted initramfs, when the release ships one.
+	Initrd   string `json:"initrd,omitempty"`
+	BootArgs string `json:"boot_args"`
+	// AgentPort is the vsock port the guest agent was pinged on.
+	AgentPort uint32 `json:"agent_port,omitempty"`
+	Date      string `json:"date"`
+	// Booted is set when every run reached userspace.
+	Booted bool `json:"booted"`
+	Runs   int  `json:"runs"`
+	// InitMS are the times the guest took to start its init, per booted run.
+	InitMS []int64 `json:"init_ms"`
+	// AgentMS are the times the guest agent took to answer, per booted run
+	// with AgentPort.
+	AgentMS     []int64               `json:"agent_ms,omitempty"`
+	TimeToInit  manifest.Percentiles  `json:"time_to_init"`
+	TimeToAgent *manifest.Percentiles `json:"time_to_agent,omitempty"`
+	Reason      string                `json:"reason,omitempty"`
+	// Console is the console log file, in the build dir.
+	Console string `json:"console"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath  string
+		buildDir    string
+		firecracker string
+		profileName string
+		timeout     time.Duration
+		runs        int
+		agentPort   uint
+		requireKVM  bool
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&firecracker, "firecracker", "firecracker", "Path to the firecracker binary")
+	flag.StringVar(&profileName, "profile", "", "Rootfs profile booted (default: every profile)")
+	flag.DurationVar(&timeout, "timeout", 0, "Time a guest has to reach userspace (default: boot_test.timeout)")
+	flag.IntVar(&runs, "runs", 0, "Boots of each image the boot times are measured over (default: boot_test.runs)")
+	flag.UintVar(&agentPort, "agent-port", 0, "Guest agent vsock port pinged after init (default: the console marker only)")
+	flag.BoolVar(&requireKVM, "require-kvm", false, "Fail instead of skipping the boots when KVM or firecracker are unavailable")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if timeout == 0 {
+		timeout = cfg.BootTest.Timeout
+	}
+	if runs == 0 {
+		runs = cfg.BootTest.Runs
+	}
+	if runs < 1 {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("-runs %d must be positive", runs))
+	}
+
+	profiles := cfg.RootfsProfiles()
+	if profileName != "" {
+		i := slices.IndexFunc(profiles, func(p config.RootfsProfile) bool { return p.Name == profileName })
+		if i < 0 {
+			return exitcode.Wrap(exitcode.Usage, fmt.Errorf("unknown rootfs profile %q", profileName))
+		}
+		profiles = profiles[i : i+1]
+	}
+
+	kernel := cfg.KernelFlavors()[0]
+	if kernel.Hypervisor != manifest.HypervisorFirecracker {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("the default kernel targets %s, not Firecracker", kernel.Hypervisor))
+	}
+
+	if err := boot.Available(firecracker); err != nil {
+		if requireKVM {
+			return err
+		}
+		slog.Warn("Skipping boot test", "reason", err)
+		return nil
+	}
+
+	arch := preflight.HostArch()
+	if !slices.Contains(cfg.Architectures, arch) {
+		slog.Warn("Skipping boot test, the host architecture is not built", "arch", arch)
+		return nil
+	}
+	for _, other := range cfg.Architectures {
+		if other != arch {
+			slog.Info("Skipping architecture the host can't boot", "arch", other)
+		}
+	}
+
+	var reports []Report
+	failed := 0
+	for _, p := range profiles {
+		stem := p.Stem(arch)
+		r := Report{
+			Arch:      arch,
+			Profile:   p.Name,
+			Kernel:    manifest.KernelImageFile(manifest.KernelFormat(arch), kernel.Name, arch),
+			Rootfs:    fmt.Sprintf("rootfs-%s.ext4", stem),
+			BootArgs:  cfg.BootArgs.For(p.Name),
+			AgentPort: uint32(agentPort),
+			Date:      time.Now().UTC().Format(time.RFC3339),
+			Console:   fmt.Sprintf("boot-test-%s.log", stem),
+		}
+		if cfg.Initramfs.Enabled {
+			r.Initrd = fmt.Sprintf("initramfs-%s.cpio.gz", arch)
+		}
+		for _, f := range []string{r.Kernel, r.Rootfs, r.Initrd} {
+			if f == "" {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(buildDir, f)); err != nil {
+				return exitcode.Wrap(exitcode.MissingArtifact, err)
+			}
+		}
+
+		opts := boot.Options{
+			Firecracker: firecracker,
+			Kernel:      filepath.Join(buildDir, r.Kernel),
+			Rootfs:      filepath.Join(buildDir, r.Rootfs),
+			BootArgs:    r.BootArgs,
+			Timeout:     timeout,
+			AgentPort:   r.AgentPort,
+		}
+		if r.Initrd != "" {
+			opts.Initrd = filepath.Join(buildDir, r.Initrd)
+		}
+		slog.Info("Booting", "arch", arch, "profile", p.Name, "rootfs", r.Rootfs, "runs", runs)
+		var console []byte
+		r.Booted = true
+		for r.Runs < runs {
+			res, err := boot.Run(context.Background(), opts)
+			if err != nil {
+				return fmt.Errorf("booting %s: %w", r.Rootfs, err)
+			}
+			r.Runs++
+			console = res.Console
+			if !res.Booted {
+				r.Booted, r.Reason = false, fmt.Sprintf("run %d: %s", r.Runs, res.Reason)
+				break
+			}
+			r.InitMS = append(r.InitMS, res.Duration.Milliseconds())
+			if opts.AgentPort != 0 {
+				r.AgentMS = append(r.AgentMS, res.AgentDuration.Milliseconds())
+			}
+		}
+		if r.Booted {
+			r.TimeToInit = percentiles(r.InitMS)
+			if len(r.AgentMS) > 0 {
+				agent := percentiles(r.AgentMS)
+				r.TimeToAgent = &agent
+			}
+		} else {
+			failed++
+		}
+
+		if err := os.WriteFile(filepath.Join(buildDir, r.Console), console, 0o644); err != nil {
+			return fmt.Errorf("writing console log: %w", err)
+		}
+		path := filepath.Join(buildDir, fmt.Sprintf("boot-test-%s.json", stem))
+		if err := writeReport(path, r); err != nil {
+			return err
+		}
+		slog.Debug("Wrote boot test report", "path", path)
+		reports = append(reports, r)
+	}
+
+	printReports(reports)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed to boot, see the boot-test-*.log console logs", failed, len(reports))
+	}
+	return nil
+}
+
+// percentiles returns the p50 and p95 of samples, nearest rank.
+func percentiles(samples []int64) manifest.Percentiles {
+	sorted := slices.Sorted(slices.Values(samples))
+	rank := func(p int) int64 {
+		return sorted[max((p*len(sorted)+99)/100, 1)-1]
+	}
+	return manifest.Percentiles{P50MS: rank(50), P95MS: rank(95)}
+}
+
+func writeReport(path string, r Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
+
+func printReports(reports []Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ARCH\tPROFILE\tRESULT\tRUNS\tINIT P50/P95\tAGENT P50/P95\tCONSOLE\n")
+	for _, r := range reports {
+		status := "ok"
+		if !r.Booted {
+			status = "FAIL (" + r.Reason + ")"
+		}
+		toInit, toAgent := "-", "-"
+		if r.Booted {
+			toInit = fmt.Sprintf("%d/%dms", r.TimeToInit.P50MS, r.TimeToInit.P95MS)
+		}
+		if r.TimeToAgent != nil {
+			toAgent = fmt.Sprintf("%d/%dms", r.TimeToAgent.P50MS, r.TimeToAgent.P95MS)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", r.Arch, r.Profile, status, r.Runs, toInit, toAgent, r.Console)
+	}
+	w.Flush()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+func TestPercentiles(t *testing.T) {
+	for _, tc := range []struct {
+		samples []int64
+		want    manifest.Percentiles
+	}{
+		{[]int64{120}, manifest.Percentiles{P50MS: 120, P95MS: 120}},
+		{[]int64{300, 100, 200}, manifest.Percentiles{P50MS: 200, P95MS: 300}},
+		{[]int64{5, 1, 4, 2, 3}, manifest.Percentiles{P50MS: 3, P95MS: 5}},
+		{[]int64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, manifest.Percentiles{P50MS: 50, P95MS: 100}},
+	} {
+		if got := percentiles(tc.samples); got != tc.want {
+			t.Errorf("%v: got %+v, want %+v", tc.samples, got, tc.want)
+		}
+	}
+}
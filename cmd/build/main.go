@@ -0,0 +1,272 @@
+// Command build runs the release build pipeline of config.yaml (pkg/pipeline)
+// without make: the kernels, rootfs images of every profile and
+// architecture, initramfs, Firecracker binaries and data disks, then the
+// hooks, SBOMs, manifest and post-processing, under their step
+// attestations. The Makefile build targets run it with -only <kind>.
+//
+// Independent steps (architectures, profiles) run concurrently, up to -jobs
+// at a time (default: one per architecture), their output lines prefixed by
+// the step name. Each step is reported as it starts and ends, and no step is
+// started after a failed one.
+//
+// Builds are incremental: an attested step is skipped when the stamp file
+// of its last run (<build-dir>/.cache) has the hash of its current inputs
+// (command, config section, materials and SOURCE_DATE_EPOCH) and its
+// products are still there. -no-cache runs every step.
+//
+// -cache-s3 shares the cache through an S3 (or S3 compatible) bucket, e.g.
+// between CI runners, configured with the standard AWS environment
+// variables: missing steps are restored from the bucket, verified against
+// their stamp, and with -cache-mode read-write the steps run are stored
+// to it. Remote cache errors are reported and the steps run.
+//
+// -only and -skip select steps by kind (kernel, rootfs, convert, initramfs,
+// firecracker, disks, hooks, sbom, manifest, postprocess) or by step name
+// pattern, comma separated; -list prints the selected steps without running
+// them. Selecting only kinds without steps (e.g. -only initramfs without
+// initramfs.enabled) is not an error, the Makefile build targets being
+// -only <kind> runs. -report writes the step results as JSON.
+//
+// Building the rootfs images requires root, like make build-rootfs. The
+// rootfs images of a foreign architecture (e.g. aarch64 on x86_64) are set
+// up in a chroot through its qemu-user binfmt handler, checked before any
+// step starts (see cmd/doctor for every host prerequisite).
+//
+// Usage:
+//
+//	sudo go run ./cmd/build -version v0.1.0 -jobs 4
+//	go run ./cmd/build -only kernel,firecracker
+//	sudo go run ./cmd/build -only 'rootfs-*-x86_64' -skip postprocess -report build/build.json
+//	go run ./cmd/build -list -skip rootfs
+//	sudo go run ./cmd/build -cache-s3 s3://sbx-build-cache/main -cache-mode read-write
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/pipeline"
+	"github.com/slok/sbx-images/pkg/preflight"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath    string
+		buildDir      string
+		version       string
+		commit        string
+		sbomFormats   string
+		kernelSrc     string
+		runtime       string
+		debianRuntime string
+		aptProxy      string
+		bkCacheFrom   string
+		bkCacheTo     string
+		only          string
+		skip          string
+		list          bool
+		reportPath    string
+		jobs          int
+		noCache       bool
+		cacheS3       string
+		cacheMode     string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&version, "version", "dev", "Release version recorded in the SBOMs and manifest")
+	flag.StringVar(&commit, "commit", "", "Source commit recorded in the manifest (default: git HEAD)")
+	flag.StringVar(&sbomFormats, "sbom-formats", "spdx,cyclonedx", "Comma separated SBOM formats")
+	flag.StringVar(&kernelSrc, "kernel-src", "", "Kernel tree validating the merged kernel configs")
+	flag.StringVar(&runtime, "container-runtime", "", "Container runtime of the kernel source builds (default: container_runtime)")
+	flag.StringVar(&debianRuntime, "debian-runtime", "", `Container runtime of the Debian base rootfs builds, or "host" (default: container_runtime)`)
+	flag.StringVar(&aptProxy, "apt-proxy", "", "APT proxy of the Debian base rootfs builds")
+	flag.StringVar(&bkCacheFrom, "buildkit-cache-from", "", "BuildKit cache imported by the buildkit rootfs bootstrap")
+	flag.StringVar(&bkCacheTo, "buildkit-cache-to", "", "BuildKit cache exported by the buildkit rootfs bootstrap")
+	flag.StringVar(&only, "only", "", "Comma separated step kinds or name patterns to run (default: every step)")
+	flag.StringVar(&skip, "skip", "", "Comma separated step kinds or name patterns to skip")
+	flag.IntVar(&jobs, "jobs", 0, "Steps run concurrently (default: the number of architectures)")
+	flag.BoolVar(&noCache, "no-cache", false, "Run the steps whose products are up to date")
+	flag.StringVar(&cacheS3, "cache-s3", "", "S3 bucket sharing the build cache (s3://bucket/prefix)")
+	flag.StringVar(&cacheMode, "cache-mode", "read", "Remote build cache mode: read or read-write")
+	flag.BoolVar(&list, "list", false, "List the selected steps without running them")
+	flag.StringVar(&reportPath, "report", "", "Write the step results as JSON to this file")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	steps, err := pipeline.Plan(cfg, pipeline.Options{
+		ConfigPath:        configPath,
+		BuildDir:          buildDir,
+		ScriptsDir:        "scripts",
+		FilesDir:          "alpine/files",
+		ProfilesDir:       "alpine/profiles",
+		Version:           version,
+		Commit:            cmp.Or(commit, git("rev-parse", "--short", "HEAD"), "unknown"),
+		SBOMFormats:       sbomFormats,
+		KernelSrc:         kernelSrc,
+		ContainerRuntime:  runtime,
+		DebianRuntime:     debianRuntime,
+		APTProxy:          aptProxy,
+		BuildKitCacheFrom: bkCacheFrom,
+		BuildKitCacheTo:   bkCacheTo,
+	})
+	if err != nil {
+		return fmt.Errorf("planning build: %w", err)
+	}
+	steps, err = pipeline.Select(steps, splitList(only), splitList(skip))
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		// Kinds can be legitimately empty (e.g. -only initramfs without
+		// initramfs.enabled), name patterns matching nothing are mistakes.
+		if only := splitList(only); len(only) > 0 && !slices.ContainsFunc(only, func(k string) bool { return !slices.Contains(pipeline.Kinds, k) }) {
+			slog.Info("No steps of the selected kinds to run", "only", strings.Join(only, ","))
+			return nil
+		}
+		return fmt.Errorf("no steps selected")
+	}
+
+	if list {
+		for _, s := range steps {
+			fmt.Printf("%s|%s|%s\n", s.Kind, s.Name, s.Arch)
+		}
+		return nil
+	}
+
+	// Fail early rather than in the middle of the rootfs builds.
+	var binfmtErrs []error
+	for _, arch := range rootfsArches(steps) {
+		if c := preflight.Binfmt(arch); c.Status == preflight.StatusFail {
+			binfmtErrs = append(binfmtErrs, c)
+		}
+	}
+	if err := errors.Join(binfmtErrs...); err != nil {
+		return fmt.Errorf("cross-building the rootfs images: %w", err)
+	}
+
+	// Reproducible builds, as with make: the artifacts timestamps are the
+	// last commit date.
+	if os.Getenv("SOURCE_DATE_EPOCH") == "" {
+		if epoch := git("log", "-1", "--format=%ct"); epoch != "" {
+			os.Setenv("SOURCE_DATE_EPOCH", epoch)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	start := time.Now()
+	runner := pipeline.Runner{
+		BuildDir: buildDir,
+		Jobs:     cmp.Or(jobs, len(cfg.Architectures)),
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+	}
+	if !noCache {
+		runner.Cache = &pipeline.Cache{Dir: filepath.Join(buildDir, ".cache"), BuildDir: buildDir, ConfigFiles: cfg.Files}
+		if cacheS3 != "" {
+			if cacheMode != "read" && cacheMode != "read-write" {
+				return fmt.Errorf("invalid -cache-mode %q, expected read or read-write", cacheMode)
+			}
+			remote, err := pipeline.NewS3(cacheS3)
+			if err != nil {
+				return err
+			}
+			runner.Cache.Remote = remote
+			runner.Cache.ReadWrite = cacheMode == "read-write"
+		}
+	}
+	results, runErr := runner.Run(ctx, steps)
+	printSummary(results, time.Since(start))
+	if reportPath != "" {
+		if err := writeReport(reportPath, results); err != nil {
+			return err
+		}
+	}
+	return runErr
+}
+
+// rootfsArches returns the architectures of the rootfs steps.
+func rootfsArches(steps []pipeline.Step) []string {
+	var arches []string
+	for _, s := range steps {
+		if s.Kind == pipeline.KindRootfs && !slices.Contains(arches, s.Arch) {
+			arches = append(arches, s.Arch)
+		}
+	}
+	return arches
+}
+
+// git returns the trimmed output of a git command, empty on failure.
+func git(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func printSummary(results []pipeline.Result, total time.Duration) {
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tSTATUS\tDURATION")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Step, r.Status, r.Duration)
+	}
+	w.Flush()
+	fmt.Printf("\nTotal: %s\n", total.Round(time.Second))
+}
+
+func writeReport(path string, results []pipeline.Result) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	slog.Info("Wrote build report", "path", path)
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,164 @@
+// Command bump updates the version pins of config.yaml to newer upstream
+// versions.
+//
+// The stale pins come from a cmd/watch-upstream JSON report (-report) or are
+// looked up upstream. Each is bumped to the newest version the policy allows:
+// patch (same major.minor), minor (same major) or any. config.yaml, or the
+// included file a pin comes from, is edited in place, only the pinned values
+// change so comments and formatting are kept. The changelog of the bumps is
+// printed as a Markdown list for the PR body.
+//
+// Kernel versions are looked up in the pinned kernel.ci_version, bump them
+// in a second run after a ci_version bump.
+//
+// Usage:
+//
+//	go run ./cmd/bump -config config.yaml -policy minor
+//	go run ./cmd/watch-upstream -output upstream.json && go run ./cmd/bump -report upstream.json -dry-run
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/releases"
+	"github.com/slok/sbx-images/pkg/upstream"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		reportPath string
+		policy     string
+		dryRun     bool
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&reportPath, "report", "", "cmd/watch-upstream JSON report (default: look up the upstream versions)")
+	flag.StringVar(&policy, "policy", upstream.PolicyPatch, "Largest allowed version change ("+strings.Join(upstream.Policies, ", ")+")")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only print the changelog, don't edit config.yaml")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if !slices.Contains(upstream.Policies, policy) {
+		return fmt.Errorf("unknown policy %q (supported: %s)", policy, strings.Join(upstream.Policies, ", "))
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	var pins []upstream.Pin
+	if reportPath != "" {
+		data, err := os.ReadFile(reportPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &pins); err != nil {
+			return fmt.Errorf("parsing %s: %w", reportPath, err)
+		}
+	} else {
+		pins, err = upstream.Check(context.Background(), cfg, upstream.HTTPSources{GitHub: releases.Client{Token: githubToken()}})
+		if err != nil {
+			return err
+		}
+	}
+
+	bumps, err := upstream.Select(pins, policy)
+	if err != nil {
+		return err
+	}
+	if len(bumps) == 0 {
+		slog.Info("No version pins to bump", "policy", policy)
+		return nil
+	}
+
+	if !dryRun {
+		if err := apply(configPath, bumps); err != nil {
+			return err
+		}
+	}
+
+	fmt.Print(upstream.Changelog(bumps))
+	return nil
+}
+
+// apply edits the bumps into the files of the config at configPath their
+// pins come from, restoring the originals when the bumped config doesn't
+// load.
+func apply(configPath string, bumps []upstream.Bump) error {
+	var files []string
+	perFile := map[string][]upstream.Bump{}
+	for _, b := range bumps {
+		file, err := config.FieldFile(configPath, b.Field)
+		if err != nil {
+			return fmt.Errorf("%w (overridden from the environment?)", err)
+		}
+		if _, ok := perFile[file]; !ok {
+			files = append(files, file)
+		}
+		perFile[file] = append(perFile[file], b)
+	}
+
+	originals := map[string][]byte{}
+	restore := func() {
+		for file, data := range originals {
+			if err := os.WriteFile(file, data, 0o644); err != nil {
+				slog.Error("Restoring config", "file", file, "err", err)
+			}
+		}
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			restore()
+			return err
+		}
+		bumped, err := upstream.Apply(data, perFile[file])
+		if err != nil {
+			restore()
+			return fmt.Errorf("updating %s: %w", file, err)
+		}
+		originals[file] = data
+		if err := os.WriteFile(file, bumped, 0o644); err != nil {
+			restore()
+			return err
+		}
+		slog.Info("Bumped config file", "file", file, "pins", len(perFile[file]))
+	}
+
+	// The bumped config is validated as a whole, an included file alone
+	// isn't a config.
+	if _, err := config.Load(configPath); err != nil {
+		restore()
+		return fmt.Errorf("bumped config: %w", err)
+	}
+	return nil
+}
+
+func githubToken() string {
+	if t := os.Getenv("GH_TOKEN"); t != "" {
+		return t
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
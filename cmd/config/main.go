@@ -0,0 +1,280 @@
+// Command config checks config.yaml.
+//
+// The lint subcommand validates a config file against the config.yaml JSON
+// Schema (see the schema subcommand), loads it with its includes as every
+// command does, then checks the semantic rules of config.Lint (version
+// formats, empty or duplicated list entries) and the extension fields. Every
+// problem is reported at once and it exits with the config exit code when
+// there are any, as a pre-commit or CI gate.
+//
+// The get subcommand prints the value of a config field, named by its dotted
+// YAML path (kernel.version, rootfs.profile), as loaded by every command: with
+// the includes merged, the SBX_ environment overrides applied and the defaults
+// set. Lists are printed space separated and mappings as JSON. The Makefile
+// reads its build variables with it.
+//
+// The files subcommand prints the config file and the files it includes, one
+// per line, the config materials of the build steps.
+//
+// The schema subcommand prints the JSON Schema of config.yaml, generated from
+// the config parser, for editors (config.schema.json, regenerated with make
+// config-schema).
+//
+// Usage:
+//
+//	go run ./cmd/config lint -config config.yaml
+//	go run ./cmd/config lint -config config.yaml -o json
+//	go run ./cmd/config get -config config.yaml kernel.version
+//	go run ./cmd/config files -config config.yaml
+//	go run ./cmd/config schema > config.schema.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/output"
+)
+
+// LintResult is the JSON output of lint.
+type LintResult struct {
+	Config   string   `json:"config"`
+	Problems []string `json:"problems"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	if len(os.Args) < 2 {
+		return fmt.Errorf("usage: config <lint|get|files|schema> [flags]")
+	}
+
+	switch os.Args[1] {
+	case "lint":
+		return lint(os.Args[2:])
+	case "get":
+		return get(os.Args[2:])
+	case "files":
+		return files(os.Args[2:])
+	case "schema":
+		return schema(os.Args[2:])
+	default:
+		return fmt.Errorf("unknown subcommand %q (supported: lint, get, files, schema)", os.Args[1])
+	}
+}
+
+func lint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "Path to config.yaml")
+	logFlags := logging.AddFlags(fs)
+	outFlags := output.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *configPath, err)
+	}
+	problems, err := config.ValidateSchema(data)
+	if err != nil {
+		return exitcode.Wrap(exitcode.Config, fmt.Errorf("parsing %s: %w", *configPath, err))
+	}
+	// The schema violations would fail the loading again.
+	if len(problems) == 0 {
+		cfg, err := config.Load(*configPath)
+		switch {
+		case err != nil:
+			problems = append(problems, err.Error())
+		default:
+			problems = config.Lint(cfg)
+			if err := config.ValidateExtensions(cfg); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+
+	if outFlags.JSON() {
+		if err := output.Print(LintResult{Config: *configPath, Problems: append([]string{}, problems...)}); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", *configPath, p)
+		}
+	}
+	if len(problems) > 0 {
+		return exitcode.Wrap(exitcode.Config, fmt.Errorf("%d problem(s) in %s", len(problems), *configPath))
+	}
+	slog.Info("Config is valid", "path", *configPath)
+	return nil
+}
+
+func get(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "Path to config.yaml")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("usage: config get [flags] <field>"))
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	v, err := field(reflect.ValueOf(cfg), fs.Arg(0))
+	if err != nil {
+		return exitcode.Wrap(exitcode.Usage, err)
+	}
+	out, err := format(v)
+	if err != nil {
+		return err
+	}
+	fmt.Println(out)
+	return nil
+}
+
+func files(args []string) error {
+	fs := flag.NewFlagSet("files", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "Path to config.yaml")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	for _, f := range cfg.Files {
+		fmt.Println(f)
+	}
+	return nil
+}
+
+// field returns the field of v at the dotted YAML path.
+func field(v reflect.Value, path string) (reflect.Value, error) {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, nil
+			}
+			v = v.Elem()
+		}
+		var ok bool
+		switch v.Kind() {
+		case reflect.Struct:
+			v, ok = structField(v, name)
+		case reflect.Map:
+			if v.Type().Key().Kind() == reflect.String {
+				v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+				// A missing key is an empty value.
+				if !v.IsValid() {
+					return reflect.Value{}, nil
+				}
+				ok = true
+			}
+		}
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("unknown config field %q", path)
+		}
+	}
+	return v, nil
+}
+
+// structField returns the field of the struct v with the YAML name, looking
+// into the inline structs.
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := range t.NumField() {
+		tag, opts, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		switch {
+		case tag == "-" || !t.Field(i).IsExported():
+		case tag == "" && opts == "inline" && t.Field(i).Type.Kind() == reflect.Struct:
+			if f, ok := structField(v.Field(i), name); ok {
+				return f, true
+			}
+		case tag == name:
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// format returns the get output of v: scalars as is, lists of scalars space
+// separated, anything else as JSON.
+func format(v reflect.Value) (string, error) {
+	if !v.IsValid() {
+		return "", nil
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		var items []string
+		for i := range v.Len() {
+			e := v.Index(i)
+			if e.Kind() == reflect.Struct || e.Kind() == reflect.Map || e.Kind() == reflect.Slice {
+				return jsonValue(v)
+			}
+			items = append(items, fmt.Sprint(e.Interface()))
+		}
+		return strings.Join(items, " "), nil
+	case reflect.Struct, reflect.Map, reflect.Pointer, reflect.Interface:
+		return jsonValue(v)
+	default:
+		return fmt.Sprint(v.Interface()), nil
+	}
+}
+
+func jsonValue(v reflect.Value) (string, error) {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func schema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
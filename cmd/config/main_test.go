@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/config"
+)
+
+func TestGetField(t *testing.T) {
+	var cfg config.Config
+	cfg.Kernel.Version = "6.1.155"
+	cfg.Rootfs.Profile = "balanced"
+	cfg.Initramfs.Enabled = true
+	cfg.Architectures = []string{"x86_64", "aarch64"}
+	cfg.SizeBudgets = map[string]string{"rootfs": "512MiB"}
+
+	for path, want := range map[string]string{
+		"kernel.version":      "6.1.155",
+		"rootfs.profile":      "balanced",
+		"initramfs.enabled":   "true",
+		"architectures":       "x86_64 aarch64",
+		"size_budgets.rootfs": "512MiB",
+		"size_budgets.kernel": "",
+		"kernel.flavors":      "",
+	} {
+		v, err := field(reflect.ValueOf(cfg), path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		got, err := format(v)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q (%v), want %q", path, got, err, want)
+		}
+	}
+
+	for _, path := range []string{"kernel.versions", "files", "rootfs.profile.name"} {
+		if _, err := field(reflect.ValueOf(cfg), path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Command convert converts a disk image between the raw and qcow2 formats,
+// see pkg/diskimage, streaming it without qemu-img. The rootfs images are
+// converted to the rootfs.disk_formats of config.yaml by make
+// convert-images, listed in the manifest rootfs converted images.
+//
+// The input format is detected (qcow2 images start with the qcow2 magic,
+// anything else is raw). The output is written to -out, <in>.<format> by
+// default (e.g. rootfs-x86_64.ext4.qcow2). With -o json the Info of the
+// output is printed to stdout as a JSON document.
+//
+// Usage:
+//
+//	go run ./cmd/convert -in build/rootfs-x86_64.ext4 -format qcow2
+//	go run ./cmd/convert -in rootfs-x86_64.ext4.qcow2 -format raw -out rootfs.img
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/diskimage"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/progress"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		in     string
+		out    string
+		format string
+	)
+
+	flag.StringVar(&in, "in", "", "Disk image to convert (required)")
+	flag.StringVar(&out, "out", "", "Converted image path (default: <in>.<format>)")
+	flag.StringVar(&format, "format", manifest.DiskFormatQCOW2, "Output format ("+strings.Join(manifest.DiskFormats, ", ")+")")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	if in == "" {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("-in is required"))
+	}
+	if out == "" {
+		out = manifest.ConvertedFile(in, format)
+	}
+	if _, err := os.Stat(in); err != nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, err)
+	}
+
+	info, err := diskimage.Convert(in, out, format)
+	if err != nil {
+		return err
+	}
+	slog.Info("Converted image", "in", in, "out", out, "format", info.Format,
+		"virtual_size", progress.Bytes(info.VirtualSizeBytes), "size", progress.Bytes(info.SizeBytes))
+
+	if outFlags.JSON() {
+		return output.Print(info)
+	}
+	fmt.Println(out)
+	return nil
+}
@@ -0,0 +1,78 @@
+// Command disks lists the data disks from config.yaml (disks), for the
+// Makefile disk build loop.
+//
+// Each disk is rendered with the -format template (fields: Name, File,
+// Filesystem, SizeMiB, Label and MountPoint), one per line. Empty lines are
+// skipped, so templates can filter with {{if}}.
+//
+// Usage:
+//
+//	go run ./cmd/disks -config config.yaml
+//	go run ./cmd/disks -config config.yaml -format '{{.File}}'
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+)
+
+// defaultFormat is read by the Makefile with IFS='|'.
+const defaultFormat = "{{.Name}}|{{.File}}|{{.Filesystem}}|{{.SizeMiB}}|{{.Label}}"
+
+// entry is a data disk.
+type entry struct {
+	File string
+	config.Disk
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		format     string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&format, "format", defaultFormat, "Go template rendered per disk")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return fmt.Errorf("parsing -format: %w", err)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	for _, d := range cfg.Disks {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, entry{File: d.File(), Disk: d}); err != nil {
+			return fmt.Errorf("rendering %s disk: %w", d.Name, err)
+		}
+		if line := strings.TrimSpace(buf.String()); line != "" {
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Command doctor checks the host prerequisites of building config.yaml
+// (pkg/preflight): the tools run by the build scripts, the loop devices,
+// the container runtime or BuildKit client of the kernel source and base
+// rootfs builds, the qemu-user binfmt handlers of the foreign architectures
+// (e.g. aarch64 on x86_64) and KVM.
+//
+// Each check is printed with what was found and the fix of the failed and
+// warned ones. The command fails when a required prerequisite is missing;
+// warnings (KVM, not running as root) only concern some of the steps.
+//
+// Usage:
+//
+//	go run ./cmd/doctor
+//	go run ./cmd/doctor -arch aarch64 -o json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/preflight"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		archs      string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&archs, "arch", "", "Comma separated architectures to check (default: architectures)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	arches := cfg.Architectures
+	if archs != "" {
+		arches = strings.Split(archs, ",")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	checks := preflight.Host(ctx, cfg, arches)
+	if outFlags.JSON() {
+		if err := output.Print(checks); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+		for _, c := range checks {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Status, c.Detail)
+		}
+		w.Flush()
+		for _, c := range checks {
+			if c.Fix != "" {
+				fmt.Printf("\n%s (%s): %s\n", c.Name, c.Status, c.Fix)
+			}
+		}
+	}
+
+	if failed := preflight.Failed(checks); len(failed) > 0 {
+		return fmt.Errorf("%d of %d checks failed", len(failed), len(checks))
+	}
+	return nil
+}
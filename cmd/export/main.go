@@ -0,0 +1,130 @@
+// Command export lays out the release artifacts of an architecture for
+// another VM runtime or tool, see pkg/export.
+//
+// The kernel flavor and rootfs profile are the defaults of the manifest
+// unless -flavor and -profile select others. Formats:
+//
+//   - firecracker-containerd: default-vmlinux.bin and default-rootfs.img (the
+//     read-only root drive) to install to the runtime directory
+//     (-runtime-dir), the bundled firecracker binary, and the
+//     firecracker-runtime.json runtime configuration pointing to them, to
+//     install to /etc/containerd.
+//   - flintlock: the flintlock (Liquid Metal) kernel and root volume images,
+//     flintlock-kernel.tar and flintlock-rootfs.tar (the Ignite ones), and
+//     flintlock-microvm.json, the CreateMicroVM request booting them
+//     (-vcpus, -mem-mib) once pushed to the registry of the hosts.
+//   - ignite: the Weave Ignite OS and kernel images, ignite-rootfs.tar and
+//     ignite-kernel.tar (docker save archives) tagged
+//     <image-name>-rootfs:<version> and <image-name>-kernel:<version>.
+//   - kata: a kata-deploy style bundle to copy to the Kata Containers prefix
+//     (-kata-prefix): the kernel and rootfs image under share/kata-containers,
+//     the bundled firecracker binary and the configuration drop-in pointing
+//     the hypervisor of the kernel flavor to them.
+//
+// With -o json the written files are printed to stdout as a JSON document.
+//
+// Usage:
+//
+//	go run ./cmd/export -format firecracker-containerd -build-dir build -out-dir build/export/fc-containerd
+//	go run ./cmd/export -format firecracker-containerd -build-dir release -arch aarch64 -profile minimal
+//	go run ./cmd/export -format ignite -build-dir release -image-name ghcr.io/example/sbx
+//	go run ./cmd/export -format kata -build-dir release -profile minimal
+//	go run ./cmd/export -format flintlock -build-dir release -image-name ghcr.io/example/sbx -vcpus 4 -mem-mib 4096
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/export"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/preflight"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		buildDir     string
+		manifestPath string
+		format       string
+		arch         string
+		flavor       string
+		profile      string
+		outDir       string
+		runtimeDir   string
+		imageName    string
+		kataPrefix   string
+		vcpus        int
+		memMiB       int
+	)
+
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to the build (or release) directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	flag.StringVar(&format, "format", "", "Export format ("+strings.Join(export.Formats, ", ")+")")
+	flag.StringVar(&arch, "arch", preflight.HostArch(), "Architecture to export")
+	flag.StringVar(&flavor, "flavor", "", "Kernel flavor to export (default: the default kernel)")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile to export (default: the default rootfs)")
+	flag.StringVar(&outDir, "out-dir", "", "Output directory (default: <build-dir>/export/<format>-<arch>)")
+	flag.StringVar(&runtimeDir, "runtime-dir", export.DefaultFCContainerdRuntimeDir, "firecracker-containerd runtime directory the files are installed to")
+	flag.StringVar(&imageName, "image-name", export.DefaultImageName, "Name of the exported container images")
+	flag.StringVar(&kataPrefix, "kata-prefix", export.DefaultKataPrefix, "Kata Containers installation prefix the bundle is installed to")
+	flag.IntVar(&vcpus, "vcpus", export.DefaultVCPUs, "Guest vCPUs of the exported VM specs")
+	flag.IntVar(&memMiB, "mem-mib", export.DefaultMemMiB, "Guest memory (MiB) of the exported VM specs")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	exporter, err := export.New(format, export.Options{RuntimeDir: runtimeDir, ImageName: imageName, KataPrefix: kataPrefix, VCPUs: vcpus, MemMiB: memMiB})
+	if err != nil {
+		return exitcode.Wrap(exitcode.Usage, err)
+	}
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+	if outDir == "" {
+		outDir = filepath.Join(buildDir, "export", format+"-"+arch)
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+	src, err := export.Select(buildDir, m, arch, flavor, profile)
+	if err != nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, err)
+	}
+
+	res, err := exporter.Export(context.Background(), src, outDir)
+	if err != nil {
+		return fmt.Errorf("%s export: %w", format, err)
+	}
+	slog.Info("Exported release", "format", format, "arch", arch, "dir", outDir)
+
+	if outFlags.JSON() {
+		return output.Print(res)
+	}
+	for _, f := range res.Files {
+		fmt.Println(filepath.Join(outDir, f))
+	}
+	return nil
+}
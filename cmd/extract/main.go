@@ -0,0 +1,208 @@
+// Command extract reads files out of an ext4 rootfs image without root or
+// mounts (pkg/ext4), for post-build checks and debugging released images.
+//
+// The image paths are resolved inside the image, symlinks included. Without
+// -out each path is written to standard output, or listed with -list; with
+// -out the paths are extracted under the -out directory, directories
+// recursively, keeping their modes and symlinks (not their owners).
+//
+// Usage:
+//
+//	go run ./cmd/extract build/rootfs-x86_64.ext4 /etc/os-release
+//	go run ./cmd/extract -list build/rootfs-x86_64.ext4 /etc/systemd/system
+//	go run ./cmd/extract -out /tmp/rootfs build/rootfs-x86_64.ext4 /etc /usr/lib/os-release
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/ext4"
+	"github.com/slok/sbx-images/pkg/logging"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		out  string
+		list bool
+	)
+
+	flag.StringVar(&out, "out", "", "Directory to extract the paths to (default: write them to stdout)")
+	flag.BoolVar(&list, "list", false, "List the paths instead of extracting them")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if flag.NArg() < 2 {
+		return fmt.Errorf("usage: extract [-out dir | -list] <image> <path>...")
+	}
+	if list && out != "" {
+		return fmt.Errorf("-list and -out are mutually exclusive")
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fsys, err := ext4.New(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flag.Arg(0), err)
+	}
+
+	for _, p := range flag.Args()[1:] {
+		name := imagePath(p)
+		switch {
+		case list:
+			err = listPath(fsys, name)
+		case out != "":
+			err = extractPath(fsys, name, out)
+		default:
+			err = catPath(fsys, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imagePath returns the io/fs name of an absolute or relative image path.
+func imagePath(p string) string {
+	if p = strings.Trim(path.Clean("/"+p), "/"); p == "" {
+		return "."
+	}
+	return p
+}
+
+// catPath writes the contents of the file name to stdout.
+func catPath(fsys *ext4.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.IsDir() {
+		return fmt.Errorf("/%s is a directory, use -list or -out", name)
+	}
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+// listPath lists the directory name, ls -l style, or the file name itself.
+func listPath(fsys *ext4.FS, name string) error {
+	fi, err := fsys.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return listEntry(fsys, path.Dir(name), fi)
+	}
+	entries, err := fsys.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if err := listEntry(fsys, name, fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listEntry(fsys *ext4.FS, dir string, fi fs.FileInfo) error {
+	owner, _ := fi.Sys().(ext4.Owner)
+	line := fmt.Sprintf("%s %5d %5d %10d %s %s", fi.Mode(), owner.UID, owner.GID, fi.Size(), fi.ModTime().Format("2006-01-02 15:04"), fi.Name())
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		target, err := fsys.ReadLink(path.Join(dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		line += " -> " + target
+	}
+	fmt.Println(line)
+	return nil
+}
+
+// extractPath extracts the path name, recursively for directories, under
+// the out directory.
+func extractPath(fsys *ext4.FS, name, out string) error {
+	return fs.WalkDir(fsys, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(out, filepath.FromSlash(p))
+		fi, err := fsys.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			if err := os.MkdirAll(dst, 0o755); err != nil {
+				return err
+			}
+			return os.Chmod(dst, mode.Perm()|0o700)
+		case mode&fs.ModeSymlink != 0:
+			target, err := fsys.ReadLink(p)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case mode.IsRegular():
+			return extractFile(fsys, p, dst, mode.Perm())
+		default:
+			// Device nodes, pipes and sockets need root.
+			slog.Warn("Skipped special file", "path", "/"+p, "type", mode.Type())
+			return nil
+		}
+	})
+}
+
+func extractFile(fsys *ext4.FS, name, dst string, perm fs.FileMode) (err error) {
+	src, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(f, src)
+	return err
+}
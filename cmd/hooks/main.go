@@ -0,0 +1,125 @@
+// Command hooks runs the user provided build hooks from config.yaml.
+//
+// Each hook runs sandboxed: without network unless declared, and with
+// filesystem access restricted by landlock to the system directories plus
+// the paths the hook declares. The build directory is readable by every hook
+// and only writable when declared.
+//
+// Hooks get a minimal environment (see pkg/sandbox) plus SBX_BUILD_DIR, the
+// absolute build directory, SBX_HOOK_ARCHITECTURES, the space separated
+// architectures (not SBX_ARCHITECTURES, the comma separated config
+// override), and TMPDIR, a private temporary directory.
+//
+// Usage:
+//
+//	go run ./cmd/hooks -config config.yaml -build-dir build
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/sandbox"
+)
+
+func main() {
+	sandbox.Init()
+
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		buildDir   string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	absBuildDir, err := filepath.Abs(buildDir)
+	if err != nil {
+		return fmt.Errorf("resolving build dir: %w", err)
+	}
+
+	baseDir := filepath.Dir(configPath)
+	for _, h := range cfg.Hooks {
+		if err := runHook(context.Background(), h, baseDir, absBuildDir, cfg.Architectures); err != nil {
+			return fmt.Errorf("hook %s: %w", h.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func runHook(ctx context.Context, h config.Hook, baseDir, buildDir string, archs []string) error {
+	script, err := filepath.Abs(filepath.Join(baseDir, h.Script))
+	if err != nil {
+		return fmt.Errorf("resolving script: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "sbx-hook-*")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	policy := sandbox.Policy{
+		Network:    h.Network,
+		ReadPaths:  append([]string{script, buildDir}, resolvePaths(baseDir, h.ReadPaths)...),
+		WritePaths: append([]string{tmpDir}, resolvePaths(baseDir, h.WritePaths)...),
+	}
+
+	cmd, err := sandbox.Command(ctx, policy, script)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(cmd.Env,
+		"SBX_BUILD_DIR="+buildDir,
+		"SBX_HOOK_ARCHITECTURES="+strings.Join(archs, " "),
+		"TMPDIR="+tmpDir,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	slog.Info("Running hook", "hook", h.Name, "network", h.Network)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %w", h.Script, err)
+	}
+	return nil
+}
+
+func resolvePaths(baseDir string, paths []string) []string {
+	resolved := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(baseDir, p)
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		resolved = append(resolved, p)
+	}
+	return resolved
+}
@@ -0,0 +1,169 @@
+// Command inspect prints the internals of kernel and rootfs or disk image
+// files, without root or mounts (pkg/inspect): the architecture, format and
+// version banner of kernels, with their embedded config (CONFIG_IKCONFIG)
+// under -config, and the superblock of filesystem images (type, UUID, label,
+// geometry and the creation, last write and last mount times).
+//
+// The file type is detected from its contents. The text output is one
+// "key: value" block per file, the json one (-o json) an array of objects
+// with the file name and its "kernel" or "filesystem" details.
+//
+// Usage:
+//
+//	go run ./cmd/inspect build/vmlinux-x86_64 build/rootfs-x86_64.ext4
+//	go run ./cmd/inspect -config build/vmlinux-aarch64 | grep CONFIG_VIRTIO
+//	go run ./cmd/inspect -o json build/*.squashfs
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/inspect"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/output"
+)
+
+// Entry is an inspected file.
+type Entry struct {
+	File       string                  `json:"file"`
+	Kernel     *inspect.KernelInfo     `json:"kernel,omitempty"`
+	Filesystem *inspect.FilesystemInfo `json:"filesystem,omitempty"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	withConfig := flag.Bool("config", false, "Include the embedded kernel config")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	if flag.NArg() == 0 {
+		return fmt.Errorf("no files to inspect")
+	}
+
+	var entries []Entry
+	for _, path := range flag.Args() {
+		e, err := inspectFile(path)
+		if err != nil {
+			return err
+		}
+		if e.Kernel != nil && !*withConfig {
+			e.Kernel.Config = ""
+		}
+		entries = append(entries, e)
+	}
+
+	if outFlags.JSON() {
+		return output.Print(entries)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "file:\t%s\n", e.File)
+		if k := e.Kernel; k != nil {
+			fmt.Fprintf(tw, "format:\t%s\n", k.Format)
+			fmt.Fprintf(tw, "arch:\t%s\n", k.Arch)
+			fmt.Fprintf(tw, "version:\t%s\n", orNone(k.Version))
+			if k.Arch == "x86_64" && k.Format == manifest.KernelFormatELF {
+				fmt.Fprintf(tw, "pvh:\t%t\n", k.PVH)
+			}
+			switch {
+			case !*withConfig:
+			case k.Config == "":
+				fmt.Fprintf(tw, "config:\tnone\n")
+			default:
+				// The config follows the aligned block as is.
+				fmt.Fprintf(tw, "config:\n")
+				if err := tw.Flush(); err != nil {
+					return err
+				}
+				fmt.Print(k.Config)
+			}
+			continue
+		}
+		fs := e.Filesystem
+		fmt.Fprintf(tw, "type:\t%s\n", fs.Type)
+		fmt.Fprintf(tw, "uuid:\t%s\n", orNone(fs.UUID))
+		fmt.Fprintf(tw, "label:\t%s\n", orNone(fs.Label))
+		fmt.Fprintf(tw, "block size:\t%d\n", fs.BlockSize)
+		fmt.Fprintf(tw, "block count:\t%d\n", fs.BlockCount)
+		fmt.Fprintf(tw, "size:\t%d MiB\n", fs.SizeBytes>>20)
+		if fs.FreeBlocks != 0 {
+			fmt.Fprintf(tw, "free blocks:\t%d\n", fs.FreeBlocks)
+		}
+		if fs.Compression != "" {
+			fmt.Fprintf(tw, "compression:\t%s\n", fs.Compression)
+		}
+		fmt.Fprintf(tw, "created:\t%s\n", formatTime(fs.Created))
+		// Only the ext filesystems record their mounts.
+		if strings.HasPrefix(fs.Type, "ext") {
+			fmt.Fprintf(tw, "last write:\t%s\n", formatTime(fs.LastWrite))
+			fmt.Fprintf(tw, "last mount:\t%s\n", formatTime(fs.LastMount))
+			fmt.Fprintf(tw, "last mounted on:\t%s\n", orNone(fs.LastMountedOn))
+			fmt.Fprintf(tw, "mount count:\t%d\n", fs.MountCount)
+		}
+	}
+	return tw.Flush()
+}
+
+// inspectFile detects whether path is a filesystem or kernel image and
+// inspects it.
+func inspectFile(path string) (Entry, error) {
+	e := Entry{File: filepath.Base(path)}
+	fs, err := inspect.Filesystem(path)
+	if err == nil {
+		e.Filesystem = &fs
+		return e, nil
+	}
+	if !errors.Is(err, inspect.ErrUnknownFormat) {
+		return Entry{}, err
+	}
+	k, err := inspect.Kernel(path)
+	if err != nil {
+		if errors.Is(err, inspect.ErrUnknownFormat) {
+			return Entry{}, fmt.Errorf("%s is neither a kernel nor a filesystem image", path)
+		}
+		return Entry{}, err
+	}
+	e.Kernel = &k
+	return e, nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func orNone(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "none"
+	}
+	return s
+}
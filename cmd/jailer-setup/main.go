@@ -0,0 +1,201 @@
+// Command jailer-setup prepares the chroot the Firecracker jailer runs a VM
+// in, see pkg/jailer, and prints the jailer invocation booting it.
+//
+// The chroot, <chroot base>/<firecracker binary name>/<id>/root, gets the
+// release kernel, the rootfs drives and the initramfs of the selected kernel
+// flavor and rootfs profile (the defaults of the manifest unless -flavor and
+// -profile select others), and vm.json, the VM config of cmd/vmconfig with
+// the chroot paths. The read-only files are hard linked from the build
+// directory when possible, the writable drives (the ext4 root, or the copy
+// of the overlay template) copied and owned by -uid and -gid, kept when the
+// chroot already has them. The API and vsock sockets go to /run, owned by
+// the jailed user. The firecracker and jailer binaries are the ones bundled
+// with the release unless -firecracker and -jailer are set.
+//
+// It needs root (hard links and ownership of the chroot). Run the printed
+// command, also as root, to start the VM.
+//
+// Usage:
+//
+//	sudo go run ./cmd/jailer-setup -build-dir release -id vm1 -uid 1234 -gid 1234
+//	sudo go run ./cmd/jailer-setup -build-dir release -id vm2 -uid 1234 -gid 1234 -profile minimal -tap tap1 -vsock-cid 4
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/export"
+	"github.com/slok/sbx-images/pkg/jailer"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/preflight"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+// Chroot paths of the VM config, the API socket and the overlay disk copy.
+const (
+	configPath  = "/vm.json"
+	runDir      = "/run"
+	apiSocket   = "/run/firecracker.socket"
+	vsockSocket = "/run/vsock.sock"
+	overlayPath = "/overlay.ext4"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		buildDir     string
+		manifestPath string
+		arch         string
+		flavor       string
+		profile      string
+		firecracker  string
+		jailerBin    string
+		vsockCID     uint
+		chroot       jailer.Chroot
+		opts         vmconfig.Options
+	)
+
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to the build (or release) directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	flag.StringVar(&arch, "arch", preflight.HostArch(), "Architecture to boot")
+	flag.StringVar(&flavor, "flavor", "", "Kernel flavor to boot (default: the default kernel)")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile to boot (default: the default rootfs)")
+	flag.StringVar(&opts.Filesystem, "filesystem", "", "Rootfs image filesystem to boot (default: the overlay or verity root, ext4 otherwise)")
+	flag.StringVar(&chroot.ID, "id", "", "VM id, naming the chroot (required)")
+	flag.IntVar(&chroot.UID, "uid", 0, "User id Firecracker runs as (required)")
+	flag.IntVar(&chroot.GID, "gid", 0, "Group id Firecracker runs as (required)")
+	flag.StringVar(&chroot.Base, "chroot-base", jailer.DefaultChrootBase, "Jailer chroot base directory")
+	flag.StringVar(&firecracker, "firecracker", "", "Firecracker binary (default: the bundled one, or firecracker from PATH)")
+	flag.StringVar(&jailerBin, "jailer", "", "Jailer binary (default: the bundled one, or jailer from PATH)")
+	flag.IntVar(&opts.VCPUs, "vcpus", vmconfig.DefaultVCPUs, "Guest vCPUs")
+	flag.IntVar(&opts.MemMiB, "mem-mib", vmconfig.DefaultMemMiB, "Guest memory (MiB)")
+	flag.StringVar(&opts.TapDevice, "tap", "", "Host tap device of the guest network interface (default: no network)")
+	flag.StringVar(&opts.GuestMAC, "mac", "", "Guest MAC address of the network interface (default: Firecracker's)")
+	flag.UintVar(&vsockCID, "vsock-cid", 0, "Guest context ID of the vsock device, 3 or higher (default: no vsock)")
+	flag.BoolVar(&opts.Entropy, "entropy", false, "Attach a virtio-rng entropy device")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	opts.VsockCID = uint32(vsockCID)
+	opts.VsockUDSPath = vsockSocket
+
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+	src, err := export.Select(buildDir, m, arch, flavor, profile)
+	if err != nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, err)
+	}
+	a := m.Artifacts[arch]
+
+	var bundledFirecracker, bundledJailer string
+	if fc := a.Firecracker; fc != nil {
+		bundledFirecracker, bundledJailer = src.Path(fc.Firecracker.File), src.Path(fc.Jailer.File)
+	}
+	if chroot.ExecFile, err = binary("firecracker", cmp.Or(firecracker, bundledFirecracker)); err != nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, err)
+	}
+	if jailerBin, err = binary("jailer", cmp.Or(jailerBin, bundledJailer)); err != nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, err)
+	}
+	if err := chroot.Validate(); err != nil {
+		return exitcode.Wrap(exitcode.Usage, err)
+	}
+	if os.Geteuid() != 0 {
+		return exitcode.Wrap(exitcode.Usage, errors.New("the jailer chroot is prepared as root"))
+	}
+
+	// The config paths are the chroot ones.
+	if src.Rootfs.Overlay != nil {
+		opts.Overlay = overlayPath
+	}
+	cfg, err := vmconfig.ForRelease("/", a, arch, src.Kernel, src.Rootfs, opts)
+	if err != nil {
+		return exitcode.Wrap(exitcode.Usage, err)
+	}
+
+	if err := chroot.Create(); err != nil {
+		return fmt.Errorf("creating chroot: %w", err)
+	}
+	files := []string{cfg.BootSource.KernelImagePath}
+	if cfg.BootSource.InitrdPath != "" {
+		files = append(files, cfg.BootSource.InitrdPath)
+	}
+	for _, f := range files {
+		if err := chroot.Place(src.Path(f), f, false); err != nil {
+			return fmt.Errorf("placing %s: %w", f, err)
+		}
+	}
+	for _, d := range cfg.Drives {
+		file := src.Path(d.PathOnHost)
+		if d.PathOnHost == overlayPath {
+			file = src.Path(src.Rootfs.Overlay.File)
+		}
+		if err := chroot.Place(file, d.PathOnHost, !d.IsReadOnly); err != nil {
+			return fmt.Errorf("placing %s drive: %w", d.DriveID, err)
+		}
+	}
+	if err := chroot.Mkdir(runDir); err != nil {
+		return fmt.Errorf("creating %s: %w", runDir, err)
+	}
+	if err := vmconfig.Write(chroot.Path(configPath), cfg); err != nil {
+		return err
+	}
+	slog.Info("Prepared jailer chroot", "root", chroot.Root(), "kernel", cfg.BootSource.KernelImagePath,
+		"rootfs", cfg.Drives[0].PathOnHost, "api_socket", chroot.Path(apiSocket))
+
+	args := chroot.Command(jailerBin, "--api-sock", apiSocket, "--config-file", configPath)
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	fmt.Println(strings.Join(quoted, " "))
+	return nil
+}
+
+// binary returns the absolute path of the name binary at path, name from
+// PATH when empty.
+func binary(name, path string) (string, error) {
+	if path == "" {
+		p, err := exec.LookPath(name)
+		if err != nil {
+			return "", fmt.Errorf("no bundled %s binary in manifest and none in PATH, set -%s", name, name)
+		}
+		path = p
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// shellQuote quotes s for a POSIX shell when it holds other characters than
+// the safe ones.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=,./:") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,174 @@
+// Command kernel-config merges the firecracker-ci kernel config with the
+// kconfig fragments from config.yaml, for every kernel flavor.
+//
+// The base config is the vmlinux-<arch>.config (vmlinux-<flavor>-<arch>.config
+// for named flavors) fetched by make build-kernel into the build dir, or into
+// -base-dir for flavors built from source, unless the flavor has a
+// base_config.
+// Fragments (config_fragments) are applied in order, like the kernel's
+// merge_config.sh, and the merged config is written to kernel-<arch>.config
+// (kernel-<flavor>-<arch>.config) in the build dir for kernel builds.
+//
+// With -kernel-src the merged config is checked against the kernel tree:
+// symbols `make listnewconfig` reports as undecided are listed (and fail with
+// -strict), the config is resolved with `make olddefconfig`, and fragment
+// values dropped by unmet dependencies fail the merge. Use -flavor to merge a
+// single flavor, the one the kernel tree is checked out at.
+//
+// Usage:
+//
+//	go run ./cmd/kernel-config -config config.yaml -build-dir build
+//	go run ./cmd/kernel-config -config config.yaml -build-dir build -kernel-src ~/src/linux -strict
+//	go run ./cmd/kernel-config -config config.yaml -build-dir build -flavor full -kernel-src ~/src/linux-6.12
+//	go run ./cmd/kernel-config -config config.yaml -base-dir /tmp/firecracker-ci -build-dir build
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/kconfig"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		buildDir   string
+		baseDir    string
+		kernelSrc  string
+		strict     bool
+		flavor     string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&baseDir, "base-dir", "", "Directory with the base configs of flavors built from source (default: <build-dir>/firecracker-ci)")
+	flag.StringVar(&kernelSrc, "kernel-src", "", "Kernel source tree to validate and resolve the merged config with")
+	flag.BoolVar(&strict, "strict", false, "Fail when the merged config leaves symbols undecided (requires -kernel-src)")
+	flag.StringVar(&flavor, "flavor", "", "Only merge the config of this kernel flavor (default: every flavor)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if baseDir == "" {
+		baseDir = filepath.Join(buildDir, "firecracker-ci")
+	}
+	if strict && kernelSrc == "" {
+		return fmt.Errorf("-strict requires -kernel-src")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	flavors := cfg.KernelFlavors()
+	if flavor != "" {
+		flavors = slices.DeleteFunc(flavors, func(f config.KernelFlavor) bool { return f.Name != flavor })
+		if len(flavors) == 0 {
+			return fmt.Errorf("unknown kernel flavor %q", flavor)
+		}
+	}
+
+	ctx := context.Background()
+	for _, f := range flavors {
+		flavorBaseDir := buildDir
+		if f.BuildFromSource {
+			flavorBaseDir = baseDir
+		}
+		for _, arch := range cfg.Architectures {
+			// The fragments may be overridden per architecture.
+			f := f.ForArch(arch)
+			fragments := make([]kconfig.Fragment, 0, len(f.ConfigFragments))
+			for _, path := range f.ConfigFragments {
+				c, err := kconfig.Load(path)
+				if err != nil {
+					return fmt.Errorf("loading config fragment: %w", err)
+				}
+				fragments = append(fragments, kconfig.Fragment{Name: filepath.Base(path), Config: c})
+			}
+			if err := mergeArch(ctx, f, arch, flavorBaseDir, buildDir, kernelSrc, strict, fragments); err != nil {
+				return fmt.Errorf("kernel config of %s flavor for %s: %w", f.Name, arch, err)
+			}
+		}
+	}
+	return nil
+}
+
+func mergeArch(ctx context.Context, f config.KernelFlavor, arch, baseDir, buildDir, kernelSrc string, strict bool, fragments []kconfig.Fragment) error {
+	name := arch
+	if f.Name != manifest.DefaultFlavor {
+		name = fmt.Sprintf("%s/%s", arch, f.Name)
+	}
+
+	basePath := filepath.Join(baseDir, f.ArtifactName("vmlinux", arch)+".config")
+	if f.BaseConfig != "" {
+		basePath = f.BaseConfigPath(arch)
+	}
+	base, err := kconfig.Load(basePath)
+	if errors.Is(err, os.ErrNotExist) && f.BaseConfig != "" {
+		return exitcode.Wrap(exitcode.Config, fmt.Errorf("base_config %s not found", basePath))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return exitcode.Wrap(exitcode.MissingArtifact, fmt.Errorf("%s not found, run make build-kernel first", basePath))
+	}
+	if err != nil {
+		return err
+	}
+
+	merged, overrides := kconfig.Merge(base, fragments)
+	for _, o := range overrides {
+		slog.Info("Fragment redefined a symbol", "config", name, "symbol", o.Symbol, "fragment", o.Fragment, "old", o.Old, "new", o.New)
+	}
+	if errs := kconfig.Validate(merged, fragments); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if kernelSrc != "" {
+		resolved, newSymbols, err := kconfig.Resolve(ctx, kernelSrc, arch, merged)
+		if err != nil {
+			return err
+		}
+		if len(newSymbols) > 0 {
+			slog.Warn("Symbols left to their defaults", "config", name, "count", len(newSymbols), "symbols", strings.Join(newSymbols, ", "))
+			if strict {
+				return fmt.Errorf("%d undecided symbols", len(newSymbols))
+			}
+		}
+		if mismatches := kconfig.Mismatches(resolved, fragments); len(mismatches) > 0 {
+			errs := make([]error, 0, len(mismatches))
+			for _, m := range mismatches {
+				errs = append(errs, errors.New(m.String()))
+			}
+			return errors.Join(errs...)
+		}
+		merged = resolved
+	}
+
+	path := filepath.Join(buildDir, f.ArtifactName("kernel", arch)+".config")
+	if err := merged.WriteFile(path); err != nil {
+		return err
+	}
+	slog.Info("Wrote merged kernel config", "path", path, "fragments", len(fragments))
+	return nil
+}
@@ -0,0 +1,101 @@
+// Command kernel-flavors lists the kernel flavors from config.yaml for every
+// architecture, for the Makefile kernel and rootfs build loops.
+//
+// Each flavor and architecture pair is rendered with the -format template
+// (fields: Flavor, Arch, Stem, and the flavor's kernel definition such as
+// Version, CIVersion, BuildFromSource, SourceRepo, SourceRef, Modules,
+// ModulesURL, BzImage and BaseConfig), one per line. Stem is the per arch file name part, <arch> for
+// the default flavor and <flavor>-<arch> otherwise (vmlinux-<stem>).
+// ModulesURL and BaseConfig have {arch} replaced. Empty lines are skipped, so templates can
+// filter with {{if}}.
+//
+// Usage:
+//
+//	go run ./cmd/kernel-flavors -config config.yaml
+//	go run ./cmd/kernel-flavors -config config.yaml -arch x86_64 -format '{{if eq .Modules "rootfs"}}modules-{{.Stem}}.tar.zst{{end}}'
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// defaultFormat is read by the Makefile with IFS='|'.
+const defaultFormat = "{{.Flavor}}|{{.Arch}}|{{.Stem}}|{{.Version}}|{{.CIVersion}}|{{.BuildFromSource}}|{{.SourceRepo}}|{{.SourceRef}}|{{.Modules}}|{{.ModulesURL}}|{{.BzImage}}|{{.BaseConfig}}"
+
+// entry is a kernel flavor of an architecture.
+type entry struct {
+	Flavor string
+	Arch   string
+	Stem   string
+	config.Kernel
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		arch       string
+		format     string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&arch, "arch", "", "Only list this architecture (default: every architecture)")
+	flag.StringVar(&format, "format", defaultFormat, "Go template rendered per flavor and architecture")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return fmt.Errorf("parsing -format: %w", err)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	for _, f := range cfg.KernelFlavors() {
+		for _, a := range cfg.Architectures {
+			if arch != "" && a != arch {
+				continue
+			}
+			f := f.ForArch(a)
+
+			e := entry{Flavor: f.Name, Arch: a, Stem: a, Kernel: f.Kernel}
+			if f.Name != manifest.DefaultFlavor {
+				e.Stem = f.Name + "-" + a
+			}
+			e.ModulesURL = strings.ReplaceAll(e.ModulesURL, "{arch}", a)
+			e.BaseConfig = f.BaseConfigPath(a)
+
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, e); err != nil {
+				return fmt.Errorf("rendering %s flavor for %s: %w", f.Name, a, err)
+			}
+			if line := strings.TrimSpace(buf.String()); line != "" {
+				fmt.Println(line)
+			}
+		}
+	}
+	return nil
+}
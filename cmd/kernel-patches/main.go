@@ -0,0 +1,71 @@
+// Command kernel-patches prepares the kernel patch series from config.yaml.
+//
+// Every patches entry of a kernel flavor built from source is copied (file)
+// or downloaded (url), checked against its sha256 and written to the
+// flavor's directory of the patches directory (<patches-dir>/<flavor>) as
+// NNNN-<name>.patch, which build-kernel.sh applies in order before building
+// the kernel from source.
+//
+// Usage:
+//
+//	go run ./cmd/kernel-patches -config config.yaml -patches-dir build/kernel-patches
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/kpatch"
+	"github.com/slok/sbx-images/pkg/logging"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		patchesDir string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&patchesDir, "patches-dir", "build/kernel-patches", "Directory to write the patch series to")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	ctx := context.Background()
+	for _, f := range cfg.KernelFlavors() {
+		if !f.BuildFromSource {
+			continue
+		}
+
+		dir := filepath.Join(patchesDir, f.Name)
+		patches, err := kpatch.Prepare(ctx, f.Patches, dir)
+		if err != nil {
+			return fmt.Errorf("%s flavor: %w", f.Name, err)
+		}
+		for _, p := range patches {
+			slog.Info("Prepared kernel patch", "file", p.File, "source", p.Source, "sha256", p.SHA256)
+		}
+		slog.Info("Wrote kernel patch series", "dir", dir, "patches", len(patches))
+	}
+	return nil
+}
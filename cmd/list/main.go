@@ -0,0 +1,183 @@
+// Command list lists releases with their validation status.
+//
+// The status comes from the release status document (status.json) published
+// with each release: validated releases passed every required check,
+// incomplete ones skipped some, and releases without a status document (e.g.
+// emergency manual uploads) are listed as unverified.
+//
+// Releases are read either from a directory holding one subdirectory per
+// release (each with manifest.json and optional status.json), or from the
+// GitHub releases of a repository.
+//
+// Usage:
+//
+//	go run ./cmd/list -repo slok/sbx-images
+//	go run ./cmd/list -releases-dir releases -format json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/releases"
+	"github.com/slok/sbx-images/pkg/signer"
+	"github.com/slok/sbx-images/pkg/status"
+)
+
+// Entry is a listed release.
+type Entry struct {
+	Version string `json:"version"`
+	Date    string `json:"date"`
+	Status  string `json:"status"`
+	// Signed is set when the status document is signed.
+	Signed bool           `json:"signed"`
+	Checks []status.Check `json:"checks,omitempty"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	releasesDir := flag.String("releases-dir", "", "Directory with one subdirectory per release")
+	repo := flag.String("repo", "", "GitHub repository (owner/name) to read the releases from")
+	format := flag.String("format", "table", "Output format (table, json)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if (*releasesDir == "") == (*repo == "") {
+		return fmt.Errorf("exactly one of -releases-dir or -repo is required")
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q (supported: table, json)", *format)
+	}
+
+	var (
+		entries []Entry
+		err     error
+	)
+	if *releasesDir != "" {
+		entries, err = readReleasesDir(*releasesDir)
+	} else {
+		entries, err = readGitHubReleases(context.Background(), *repo)
+	}
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Date > entries[j].Date })
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	printEntries(entries)
+	return nil
+}
+
+// readReleasesDir reads every <dir>/<release>/manifest.json with its status
+// document.
+func readReleasesDir(dir string) ([]Entry, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "*", "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(manifests))
+	for _, mp := range manifests {
+		m, err := manifest.Read(mp)
+		if err != nil {
+			return nil, err
+		}
+		e := Entry{Version: m.Version, Date: m.Build.Date, Status: status.Unverified}
+
+		sp := filepath.Join(filepath.Dir(mp), status.FileName)
+		doc, err := status.Read(sp)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			e.Status, e.Checks = doc.Status, doc.Checks
+			if doc.Signing != nil {
+				_, err := os.Stat(signer.SignatureFile(doc.Signing.Backend, sp))
+				e.Signed = err == nil
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// readGitHubReleases reads the status document of every published release
+// of repo.
+func readGitHubReleases(ctx context.Context, repo string) ([]Entry, error) {
+	client := releases.Client{APIURL: os.Getenv("GITHUB_API_URL"), Token: githubToken()}
+	rels, err := client.List(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(rels))
+	for _, rel := range rels {
+		e := Entry{Version: rel.Tag, Date: rel.PublishedAt, Status: status.Unverified}
+
+		if a, ok := rel.Asset(status.FileName); ok {
+			var doc status.Document
+			if err := client.Fetch(ctx, a, &doc); err != nil {
+				return nil, fmt.Errorf("release %s: %w", rel.Tag, err)
+			}
+			e.Status, e.Checks = doc.Status, doc.Checks
+			if doc.Signing != nil {
+				_, e.Signed = rel.Asset(signer.SignatureFile(doc.Signing.Backend, status.FileName))
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func printEntries(entries []Entry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDATE\tSTATUS\tSIGNED\tNOTES")
+	for _, e := range entries {
+		// Notes list the checks that kept the release from being validated.
+		var notes []string
+		for _, c := range e.Checks {
+			if c.Result != status.Passed {
+				notes = append(notes, c.Name+" "+c.Result)
+			}
+		}
+		signed := "no"
+		if e.Signed {
+			signed = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Version, e.Date, e.Status, signed, strings.Join(notes, ", "))
+	}
+	w.Flush()
+}
+
+func githubToken() string {
+	if t := os.Getenv("GH_TOKEN"); t != "" {
+		return t
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
@@ -1,99 +1,74 @@
 // Command manifest generates a manifest.json from config.yaml and built artifacts.
 //
 // It reads the build configuration, scans the build directory for artifacts,
-// computes file sizes, and outputs a structured manifest for GitHub Releases.
+// computes file sizes and digests, writes a SLSA provenance statement per
+// artifact, and outputs a structured manifest for GitHub Releases. The kernel
+// headers and image superblocks are checked (pkg/inspect), failing on
+// swapped, truncated or corrupted files, and it fails listing the artifacts
+// over their config.yaml size_budgets.
+//
+// With -dry-run nothing is written: the provenance statements that would be
+// written are logged and the rendered manifest is printed to stdout.
 //
 // Usage:
 //
 //	go run ./cmd/manifest -version v0.1.0 -config config.yaml -build-dir build -commit abc123
+//	go run ./cmd/manifest -version v0.1.0 -dry-run | jq .artifacts
 package main
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	"gopkg.in/yaml.v3"
+	"github.com/slok/sbx-images/pkg/attest"
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/diskimage"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/inspect"
+	"github.com/slok/sbx-images/pkg/kpatch"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/provenance"
+	"github.com/slok/sbx-images/pkg/sbom"
+	"github.com/slok/sbx-images/pkg/sparse"
 )
 
-// Config represents the build configuration from config.yaml.
-type Config struct {
-	Kernel struct {
-		Version   string `yaml:"version"`
-		CIVersion string `yaml:"ci_version"`
-	} `yaml:"kernel"`
-	Firecracker struct {
-		Version string `yaml:"version"`
-	} `yaml:"firecracker"`
-	Rootfs struct {
-		Distro        string `yaml:"distro"`
-		DistroVersion string `yaml:"distro_version"`
-		Profile       string `yaml:"profile"`
-	} `yaml:"rootfs"`
-	Architectures []string `yaml:"architectures"`
-}
-
-// Manifest is the release manifest written to manifest.json.
-type Manifest struct {
-	SchemaVersion int                      `json:"schema_version"`
-	Version       string                   `json:"version"`
-	Artifacts     map[string]ArchArtifacts `json:"artifacts"`
-	Firecracker   ManifestFirecracker      `json:"firecracker"`
-	Build         ManifestBuild            `json:"build"`
-}
-
-// ArchArtifacts contains per-architecture artifact metadata.
-type ArchArtifacts struct {
-	Kernel KernelArtifact `json:"kernel"`
-	Rootfs RootfsArtifact `json:"rootfs"`
-}
-
-// KernelArtifact describes the kernel binary.
-type KernelArtifact struct {
-	File      string `json:"file"`
-	Version   string `json:"version"`
-	Source    string `json:"source"`
-	SizeBytes int64  `json:"size_bytes"`
-}
-
-// RootfsArtifact describes the rootfs image.
-type RootfsArtifact struct {
-	File          string `json:"file"`
-	Distro        string `json:"distro"`
-	DistroVersion string `json:"distro_version"`
-	Profile       string `json:"profile"`
-	SizeBytes     int64  `json:"size_bytes"`
-}
-
-// ManifestFirecracker describes the expected Firecracker version.
-type ManifestFirecracker struct {
-	Version string `json:"version"`
-	Source  string `json:"source"`
-}
-
-// ManifestBuild contains build metadata.
-type ManifestBuild struct {
-	Date   string `json:"date"`
-	Commit string `json:"commit"`
-}
-
 func main() {
 	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
 	}
 }
 
 func run() error {
 	var (
-		version    string
-		configPath string
-		buildDir   string
-		commit     string
-		outputPath string
+		version      string
+		configPath   string
+		buildDir     string
+		commit       string
+		outputPath   string
+		builderID    string
+		noProvenance bool
+		dryRun       bool
 	)
 
 	flag.StringVar(&version, "version", "", "Release version (e.g. v0.1.0)")
@@ -101,7 +76,14 @@ func run() error {
 	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
 	flag.StringVar(&commit, "commit", "", "Git commit SHA")
 	flag.StringVar(&outputPath, "output", "", "Output path for manifest.json (default: <build-dir>/manifest.json)")
+	flag.StringVar(&builderID, "builder-id", "", "SLSA builder ID (default: GitHub Actions workflow or \"local\")")
+	flag.BoolVar(&noProvenance, "no-provenance", false, "Skip writing SLSA provenance statements")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the manifest and the files that would be written without writing them")
+	logFlags := logging.AddFlags(flag.CommandLine)
 	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
 
 	if version == "" {
 		return fmt.Errorf("-version is required")
@@ -111,106 +93,1344 @@ func run() error {
 		outputPath = filepath.Join(buildDir, "manifest.json")
 	}
 
-	cfg, err := loadConfig(configPath)
+	cfg, err := config.Load(configPath)
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
 
-	manifest, err := buildManifest(cfg, version, buildDir, commit)
+	if err := config.ValidateExtensions(cfg); err != nil {
+		return fmt.Errorf("validating config: %w", err)
+	}
+
+	m, err := buildManifest(cfg, configPath, version, buildDir, commit)
 	if err != nil {
 		return fmt.Errorf("building manifest: %w", err)
 	}
 
-	data, err := json.MarshalIndent(manifest, "", "  ")
-	if err != nil {
-		return fmt.Errorf("marshaling manifest: %w", err)
+	if over := overBudget(cfg, m); len(over) > 0 {
+		for _, o := range over {
+			slog.Error("Artifact over its size budget", "detail", o)
+		}
+		return exitcode.Wrap(exitcode.Policy, fmt.Errorf("%d artifact(s) over their size budget", len(over)))
 	}
 
-	if err := os.WriteFile(outputPath, append(data, '\n'), 0o644); err != nil {
-		return fmt.Errorf("writing manifest: %w", err)
+	if !noProvenance {
+		if err := writeProvenance(&m, cfg, configPath, builderID, statementOut{dir: buildDir, dryRun: dryRun}); err != nil {
+			return fmt.Errorf("writing provenance: %w", err)
+		}
+	}
+
+	if dryRun {
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling manifest: %w", err)
+		}
+		fmt.Println(string(data))
+		slog.Info("Dry run, would write manifest", "path", outputPath)
+		return nil
+	}
+	if err := manifest.Write(outputPath, m); err != nil {
+		return err
 	}
 
-	fmt.Printf("Wrote manifest: %s\n", outputPath)
+	slog.Info("Wrote manifest", "path", outputPath)
 	return nil
 }
 
-func loadConfig(path string) (Config, error) {
-	data, err := os.ReadFile(path)
+// overBudget returns a report line per artifact larger than the size budget
+// of its kind.
+func overBudget(cfg config.Config, m manifest.Manifest) []string {
+	var over []string
+	check := func(kind, file string, size int64) {
+		budget, ok := cfg.SizeBudgetBytes[kind]
+		if ok && size > budget {
+			over = append(over, fmt.Sprintf("%s: %s of %.1f MiB exceeds the %.1f MiB budget by %.1f MiB",
+				file, kind, mib(size), mib(budget), mib(size-budget)))
+		}
+	}
+
+	for _, arch := range cfg.Architectures {
+		a := m.Artifacts[arch]
+		for _, k := range a.Kernels() {
+			check("kernel", k.File, k.SizeBytes)
+			for _, img := range k.Images {
+				check("kernel", img.File, img.SizeBytes)
+			}
+			if k.Modules.Shipped() {
+				check("modules", k.Modules.File, k.Modules.SizeBytes)
+			}
+		}
+		for _, r := range a.Rootfses() {
+			check("rootfs", r.File, r.SizeBytes)
+			for _, img := range r.Images {
+				check("rootfs", img.File, img.SizeBytes)
+			}
+		}
+		if a.Initramfs != nil {
+			check("initramfs", a.Initramfs.File, a.Initramfs.SizeBytes)
+		}
+	}
+	return over
+}
+
+// mib returns bytes in MiB.
+func mib(bytes int64) float64 {
+	return float64(bytes) / (1 << 20)
+}
+
+func buildManifest(cfg config.Config, configPath, version, buildDir, commit string) (manifest.Manifest, error) {
+	configDir := filepath.Dir(configPath)
+	artifacts := make(map[string]manifest.ArchArtifacts, len(cfg.Architectures))
+
+	var firstboot *manifest.Firstboot
+	profiles := cfg.RootfsProfiles()
+	if slices.ContainsFunc(profiles, func(p config.RootfsProfile) bool { return p.Definition.Firstboot }) {
+		v, err := firstbootVersion(filepath.Join(configDir, firstbootScript))
+		if err != nil {
+			return manifest.Manifest{}, fmt.Errorf("first boot service: %w", err)
+		}
+		firstboot = &manifest.Firstboot{Version: v}
+	}
+
+	// The applied patch series of a flavor is shared by every architecture.
+	flavors := cfg.KernelFlavors()
+	flavorPatches := make(map[string][]manifest.KernelPatch, len(flavors))
+	for _, f := range flavors {
+		patches, err := kernelPatches(f, buildDir)
+		if err != nil {
+			return manifest.Manifest{}, err
+		}
+		flavorPatches[f.Name] = patches
+	}
+
+	for _, arch := range cfg.Architectures {
+		kernels := make([]manifest.KernelArtifact, 0, len(flavors))
+		for _, f := range flavors {
+			k, err := kernelArtifact(f.ForArch(arch), arch, buildDir, flavorPatches[f.Name])
+			if err != nil {
+				return manifest.Manifest{}, err
+			}
+			kernels = append(kernels, k)
+		}
+
+		rootfses := make([]manifest.RootfsArtifact, 0, len(profiles))
+		for _, p := range profiles {
+			r, err := rootfsArtifact(cfg, p, configDir, arch, buildDir)
+			if err != nil {
+				return manifest.Manifest{}, err
+			}
+			if p.Definition.Firstboot {
+				r.Firstboot = firstboot
+			}
+			rootfses = append(rootfses, r)
+		}
+
+		capabilities, err := bootCapabilities(filepath.Join(buildDir, fmt.Sprintf("boot-matrix-%s.json", arch)))
+		if err != nil {
+			return manifest.Manifest{}, fmt.Errorf("boot report for %s: %w", arch, err)
+		}
+
+		a := manifest.ArchArtifacts{
+			Kernel:         kernels[0],
+			KernelFlavors:  kernels[1:],
+			Rootfs:         rootfses[0],
+			RootfsProfiles: rootfses[1:],
+			Capabilities:   capabilities,
+		}
+		if cfg.QEMU.Microvm && arch == "x86_64" {
+			if a.QEMUMicrovm, err = qemuMicrovm(kernels, buildDir); err != nil {
+				return manifest.Manifest{}, err
+			}
+		}
+
+		if cfg.Initramfs.Enabled {
+			a.Initramfs, err = initramfsArtifact(cfg.Initramfs, arch, buildDir)
+			if err != nil {
+				return manifest.Manifest{}, fmt.Errorf("initramfs artifact for %s: %w", arch, err)
+			}
+		}
+
+		if cfg.Firecracker.Bundle {
+			a.Firecracker, err = firecrackerArtifact(arch, buildDir)
+			if err != nil {
+				return manifest.Manifest{}, fmt.Errorf("firecracker binaries for %s: %w", arch, err)
+			}
+		}
+
+		if cfg.Agent.Enabled() {
+			a.Agent, err = agentArtifact(cfg.Agent, configDir, arch)
+			if err != nil {
+				return manifest.Manifest{}, fmt.Errorf("agent for %s: %w", arch, err)
+			}
+		}
+
+		artifacts[arch] = a
+	}
+
+	var disks []manifest.DiskArtifact
+	for _, d := range cfg.Disks {
+		disk, err := diskArtifact(d, buildDir)
+		if err != nil {
+			return manifest.Manifest{}, fmt.Errorf("data disk %s: %w", d.Name, err)
+		}
+		disks = append(disks, disk)
+	}
+
+	attestations, err := filepath.Glob(filepath.Join(buildDir, "*"+attest.FileSuffix))
 	if err != nil {
-		return Config{}, fmt.Errorf("reading %s: %w", path, err)
+		return manifest.Manifest{}, fmt.Errorf("listing attestations: %w", err)
+	}
+	for i, a := range attestations {
+		attestations[i] = filepath.Base(a)
 	}
+	sort.Strings(attestations)
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+	_, configDigest, err := digest.File(configPath)
+	if err != nil {
+		return manifest.Manifest{}, fmt.Errorf("config digest: %w", err)
 	}
 
-	if len(cfg.Architectures) == 0 {
-		return Config{}, fmt.Errorf("no architectures defined in %s", path)
+	var sourceDateEpoch int64
+	if v := os.Getenv("SOURCE_DATE_EPOCH"); v != "" {
+		sourceDateEpoch, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return manifest.Manifest{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", v, err)
+		}
 	}
-	if cfg.Kernel.Version == "" {
-		return Config{}, fmt.Errorf("kernel.version is required in %s", path)
+
+	toolchain, err := buildToolchain(cfg, buildDir)
+	if err != nil {
+		return manifest.Manifest{}, err
 	}
-	if cfg.Firecracker.Version == "" {
-		return Config{}, fmt.Errorf("firecracker.version is required in %s", path)
+
+	bootTimes, err := bootTimes(cfg, buildDir)
+	if err != nil {
+		return manifest.Manifest{}, err
 	}
 
-	return cfg, nil
+	return manifest.Manifest{
+		SchemaVersion: 1,
+		Version:       version,
+		Artifacts:     artifacts,
+		Firecracker: manifest.Firecracker{
+			Version:    cfg.Firecracker.Version,
+			Source:     "github.com/firecracker-microvm/firecracker",
+			MinVersion: cfg.Firecracker.MinVersion,
+			MaxVersion: cfg.Firecracker.MaxVersion,
+		},
+		Disks: disks,
+		Build: manifest.Build{
+			Date:            time.Now().UTC().Format(time.RFC3339),
+			Commit:          commit,
+			Attestations:    attestations,
+			SourceDateEpoch: sourceDateEpoch,
+			ConfigSHA256:    configDigest,
+			ConfigOverrides: cfg.Overrides,
+			Toolchain:       toolchain,
+			BootTimes:       bootTimes,
+		},
+		Extensions: cfg.Extensions,
+	}, nil
 }
 
-func buildManifest(cfg Config, version, buildDir, commit string) (Manifest, error) {
-	artifacts := make(map[string]ArchArtifacts, len(cfg.Architectures))
+// diskArtifact returns the data disk d built by build-disk.sh.
+func diskArtifact(d config.Disk, buildDir string) (manifest.DiskArtifact, error) {
+	disk := manifest.DiskArtifact{
+		Name:       d.Name,
+		File:       d.File(),
+		Filesystem: d.Filesystem,
+		Label:      d.Label,
+		MountPoint: d.MountPoint,
+	}
+	path := filepath.Join(buildDir, disk.File)
+	var err error
+	if disk.SizeBytes, disk.SHA256, err = digest.File(path); err != nil {
+		return disk, err
+	}
+	if err := checkFilesystem(path, disk.Filesystem, disk.SizeBytes); err != nil {
+		return disk, err
+	}
+	if disk.DiskUsageBytes, err = sparse.DiskUsage(path); err != nil {
+		return disk, err
+	}
+	opts, err := readSource(path + ".mkfs")
+	if err != nil {
+		return disk, err
+	}
+	disk.MkfsOptions = opts["mkfs_options"]
+	return disk, nil
+}
 
-	for _, arch := range cfg.Architectures {
-		kernelFile := fmt.Sprintf("vmlinux-%s", arch)
-		rootfsFile := fmt.Sprintf("rootfs-%s.ext4", arch)
+// kernelPatches returns the patch series of a flavor prepared by
+// cmd/kernel-patches.
+func kernelPatches(f config.KernelFlavor, buildDir string) ([]manifest.KernelPatch, error) {
+	var patches []manifest.KernelPatch
+	for i, p := range f.Patches {
+		_, sum, err := digest.File(filepath.Join(buildDir, "kernel-patches", f.Name, kpatch.FileName(i, p)))
+		if err != nil {
+			return nil, fmt.Errorf("kernel patch %s of flavor %s: %w", kpatch.Source(p), f.Name, err)
+		}
+		patches = append(patches, manifest.KernelPatch{Source: kpatch.Source(p), SHA256: sum})
+	}
+	return patches, nil
+}
+
+// kernelArtifact returns the kernel artifact of a flavor for arch.
+func kernelArtifact(f config.KernelFlavor, arch, buildDir string, patches []manifest.KernelPatch) (manifest.KernelArtifact, error) {
+	k := manifest.KernelArtifact{
+		File:    f.ArtifactName("vmlinux", arch),
+		Format:  manifest.KernelFormat(arch),
+		Version: f.Version,
+		Source:  fmt.Sprintf("firecracker-ci/%s", f.CIVersion),
+	}
+	where := arch
+	if f.Name != manifest.DefaultFlavor {
+		k.Flavor = f.Name
+		where = fmt.Sprintf("%s (%s flavor)", arch, f.Name)
+	}
+	if f.Hypervisor != manifest.HypervisorFirecracker {
+		k.Hypervisor = f.Hypervisor
+	}
 
-		kernelSize, err := fileSize(filepath.Join(buildDir, kernelFile))
+	var err error
+	k.SizeBytes, k.SHA256, err = digest.File(filepath.Join(buildDir, k.File))
+	if err != nil {
+		return k, fmt.Errorf("kernel artifact for %s: %w", where, err)
+	}
+	if err := checkKernel(filepath.Join(buildDir, k.File), k.Format, arch, k.TargetHypervisor()); err != nil {
+		return k, fmt.Errorf("kernel artifact for %s: %w", where, err)
+	}
+
+	// The kernel config is fetched by download-kernel.sh, older build
+	// directories may not have it.
+	k.Config = k.File + ".config"
+	_, k.ConfigSHA256, err = digest.File(filepath.Join(buildDir, k.Config))
+	if errors.Is(err, os.ErrNotExist) {
+		k.Config = ""
+	} else if err != nil {
+		return k, fmt.Errorf("kernel config for %s: %w", where, err)
+	}
+
+	for _, format := range f.ImageFormats(arch)[1:] {
+		img := manifest.KernelImage{Format: format, File: manifest.KernelImageFile(format, f.Name, arch)}
+		img.SizeBytes, img.SHA256, err = digest.File(filepath.Join(buildDir, img.File))
 		if err != nil {
-			return Manifest{}, fmt.Errorf("kernel artifact for %s: %w", arch, err)
+			return k, fmt.Errorf("kernel %s image for %s: %w", format, where, err)
+		}
+		if err := checkKernel(filepath.Join(buildDir, img.File), format, arch, k.TargetHypervisor()); err != nil {
+			return k, fmt.Errorf("kernel %s image for %s: %w", format, where, err)
 		}
+		k.Images = append(k.Images, img)
+	}
 
-		rootfsSize, err := fileSize(filepath.Join(buildDir, rootfsFile))
+	if f.BuildFromSource {
+		if err := addKernelSource(&k, filepath.Join(buildDir, k.File+".source")); err != nil {
+			return k, fmt.Errorf("kernel source for %s: %w", where, err)
+		}
+		k.Patches = patches
+	} else {
+		// Checked again in case the kernel changed after download-kernel.sh
+		// verified it.
+		upstream, err := readSource(filepath.Join(buildDir, k.File+".upstream"), "sha256")
 		if err != nil {
-			return Manifest{}, fmt.Errorf("rootfs artifact for %s: %w", arch, err)
+			return k, fmt.Errorf("kernel upstream checksum for %s: %w", where, err)
+		}
+		if upstream["sha256"] != k.SHA256 {
+			return k, fmt.Errorf("kernel artifact for %s: sha256 %s does not match the upstream %s", where, k.SHA256, upstream["sha256"])
 		}
+		k.UpstreamSHA256 = upstream["sha256"]
+	}
 
-		artifacts[arch] = ArchArtifacts{
-			Kernel: KernelArtifact{
-				File:      kernelFile,
-				Version:   cfg.Kernel.Version,
-				Source:    fmt.Sprintf("firecracker-ci/%s", cfg.Kernel.CIVersion),
-				SizeBytes: kernelSize,
-			},
-			Rootfs: RootfsArtifact{
-				File:          rootfsFile,
-				Distro:        cfg.Rootfs.Distro,
-				DistroVersion: cfg.Rootfs.DistroVersion,
-				Profile:       cfg.Rootfs.Profile,
-				SizeBytes:     rootfsSize,
-			},
+	if f.Modules != "" {
+		m := &manifest.ModulesArtifact{
+			File:      f.ArtifactName("modules", arch) + ".tar.zst",
+			Installed: f.Modules == config.ModulesRootfs,
 		}
+		m.SizeBytes, m.SHA256, err = digest.File(filepath.Join(buildDir, m.File))
+		if err != nil {
+			return k, fmt.Errorf("kernel modules for %s: %w", where, err)
+		}
+		k.Modules = m
 	}
+	return k, nil
+}
 
-	return Manifest{
-		SchemaVersion: 1,
-		Version:       version,
-		Artifacts:     artifacts,
-		Firecracker: ManifestFirecracker{
-			Version: cfg.Firecracker.Version,
-			Source:  "github.com/firecracker-microvm/firecracker",
+// rootfsArtifact returns the rootfs image of profile p for arch, with its
+// effective definition, SBOMs, vulnerability report and package inventory
+// when present. The boot args of images running Ignition carry
+// config.IgnitionBootArgs.
+func rootfsArtifact(cfg config.Config, p config.RootfsProfile, configDir, arch, buildDir string) (manifest.RootfsArtifact, error) {
+	stem := p.Stem(arch)
+	r := manifest.RootfsArtifact{
+		File:          manifest.RootfsImageFile(manifest.RootfsFilesystemExt4, stem),
+		Filesystem:    manifest.RootfsFilesystemExt4,
+		Distro:        cfg.Rootfs.Distro,
+		DistroVersion: cfg.Rootfs.DistroVersion,
+		Bootstrap:     cfg.Bootstrap(p),
+		Profile:       p.Name,
+		Definition:    &p.Definition,
+		BootArgs:      cfg.BootArgs.For(p.Name),
+	}
+	if len(p.Definition.FilesDirs) > 0 {
+		def := p.Definition
+		def.FilesDirs = make([]string, 0, len(p.Definition.FilesDirs))
+		for _, d := range p.Definition.FilesDirs {
+			if rel, err := filepath.Rel(configDir, d); err == nil {
+				d = rel
+			}
+			def.FilesDirs = append(def.FilesDirs, filepath.ToSlash(d))
+		}
+		r.Definition = &def
+	}
+	if size := cfg.RootfsSizeMiB(arch); size > 0 {
+		def := *r.Definition
+		def.SizeMiB = size
+		r.Definition = &def
+	}
+	if p.Definition.Ignition != nil {
+		def := *r.Definition
+		ign := *def.Ignition
+		ign.Version = cfg.Ignition.Version
+		def.Ignition = &ign
+		r.Definition = &def
+		r.BootArgs = strings.TrimSpace(r.BootArgs + " " + config.IgnitionBootArgs)
+	}
+	for hv, args := range cfg.HypervisorBootArgs(p.Name, arch) {
+		if r.HypervisorBootArgs == nil {
+			r.HypervisorBootArgs = map[string]string{}
+		}
+		if p.Definition.Ignition != nil {
+			args = strings.TrimSpace(args + " " + config.IgnitionBootArgs)
+		}
+		r.HypervisorBootArgs[hv] = args
+	}
+	where := arch
+	if !p.Default {
+		where = fmt.Sprintf("%s (%s profile)", arch, p.Name)
+	}
+
+	var err error
+	r.SizeBytes, r.SHA256, err = digest.File(filepath.Join(buildDir, r.File))
+	if err != nil {
+		return r, fmt.Errorf("rootfs artifact for %s: %w", where, err)
+	}
+	if r.DiskUsageBytes, err = sparse.DiskUsage(filepath.Join(buildDir, r.File)); err != nil {
+		return r, fmt.Errorf("rootfs artifact for %s: %w", where, err)
+	}
+	if err := checkFilesystem(filepath.Join(buildDir, r.File), r.Filesystem, r.SizeBytes); err != nil {
+		return r, fmt.Errorf("rootfs artifact for %s: %w", where, err)
+	}
+	// Nix builds its images itself.
+	if p.Definition.Nix == nil {
+		opts, err := readSource(filepath.Join(buildDir, r.File+".mkfs"))
+		if err != nil {
+			return r, fmt.Errorf("rootfs ext4 options for %s: %w", where, err)
+		}
+		r.MkfsOptions = opts["mkfs_options"]
+	}
+	for _, fs := range cfg.RootfsFilesystems(p)[1:] {
+		img := manifest.RootfsImage{Filesystem: fs, File: manifest.RootfsImageFile(fs, stem)}
+		img.SizeBytes, img.SHA256, err = digest.File(filepath.Join(buildDir, img.File))
+		if err != nil {
+			return r, fmt.Errorf("rootfs %s image for %s: %w", fs, where, err)
+		}
+		if img.DiskUsageBytes, err = sparse.DiskUsage(filepath.Join(buildDir, img.File)); err != nil {
+			return r, fmt.Errorf("rootfs %s image for %s: %w", fs, where, err)
+		}
+		if err := checkFilesystem(filepath.Join(buildDir, img.File), fs, img.SizeBytes); err != nil {
+			return r, fmt.Errorf("rootfs %s image for %s: %w", fs, where, err)
+		}
+		// The options are recorded by build-rootfs.sh next to the image.
+		opts, err := readSource(filepath.Join(buildDir, img.File+".mkfs"))
+		if err != nil {
+			return r, fmt.Errorf("rootfs %s image options for %s: %w", fs, where, err)
+		}
+		img.MkfsOptions, img.MountOptions = opts["mkfs_options"], opts["mount_options"]
+		img.BootArgs = strings.TrimSpace(fmt.Sprintf("%s rootfstype=%s", r.BootArgs, fs))
+		if img.MountOptions != "" {
+			img.BootArgs += " rootflags=" + img.MountOptions
+		}
+		if manifest.RootfsFilesystemReadOnly(fs) {
+			img.ReadOnly = true
+			img.BootArgs += " ro"
+		}
+		r.Images = append(r.Images, img)
+	}
+	if r.Converted, err = convertedImages(r, cfg.Rootfs.DiskFormats, buildDir); err != nil {
+		return r, fmt.Errorf("rootfs converted images for %s: %w", where, err)
+	}
+	// The overlay and verity root is booted read-only, the writes go to the
+	// copy of the template attached as the second drive.
+	root, _ := r.Image(cfg.ReadOnlyRoot(p))
+	rootArgs := root.BootArgs
+	if !root.ReadOnly {
+		rootArgs = strings.TrimSpace(fmt.Sprintf("%s rootfstype=%s ro", rootArgs, root.Filesystem))
+	}
+	if p.Definition.Verity {
+		if r.Verity, err = verityArtifact(root.File, buildDir, p.Definition.Overlay); err != nil {
+			return r, fmt.Errorf("rootfs verity hash tree for %s: %w", where, err)
+		}
+		v := r.Verity
+		target := fmt.Sprintf("0 %d verity 1 /dev/vda %s %d %d %d 1 %s %s %s",
+			v.DataBlocks*int64(v.DataBlockSize)/512, manifest.VerityDevice(p.Definition.Overlay),
+			v.DataBlockSize, v.HashBlockSize, v.DataBlocks, v.HashAlgorithm, v.RootHash, v.Salt)
+		rootArgs = fmt.Sprintf(`%s dm-mod.create="vroot,,,ro,%s" root=/dev/dm-0`, rootArgs, target)
+		v.BootArgs = rootArgs
+	}
+	if p.Definition.Overlay {
+		o := &manifest.RootfsOverlay{
+			RootfsImage: manifest.RootfsImage{Filesystem: manifest.RootfsFilesystemExt4, File: manifest.OverlayFile(stem)},
+			Root:        root.File,
+		}
+		o.SizeBytes, o.SHA256, err = digest.File(filepath.Join(buildDir, o.File))
+		if err != nil {
+			return r, fmt.Errorf("rootfs overlay template for %s: %w", where, err)
+		}
+		if o.DiskUsageBytes, err = sparse.DiskUsage(filepath.Join(buildDir, o.File)); err != nil {
+			return r, fmt.Errorf("rootfs overlay template for %s: %w", where, err)
+		}
+		if err := checkFilesystem(filepath.Join(buildDir, o.File), o.Filesystem, o.SizeBytes); err != nil {
+			return r, fmt.Errorf("rootfs overlay template for %s: %w", where, err)
+		}
+		opts, err := readSource(filepath.Join(buildDir, o.File+".mkfs"))
+		if err != nil {
+			return r, fmt.Errorf("rootfs overlay template options for %s: %w", where, err)
+		}
+		o.MkfsOptions = opts["mkfs_options"]
+		o.BootArgs = fmt.Sprintf("%s sbx.overlay=%s", rootArgs, manifest.OverlayDevice)
+		r.Overlay = o
+	}
+
+	// The pulled image is recorded by cmd/rootfs-oci next to the base
+	// rootfs archive.
+	if p.Definition.Image != "" {
+		fields, err := readSource(filepath.Join(buildDir, fmt.Sprintf("rootfs-base-%s.source", stem)), "image", "digest", "platform")
+		if err != nil {
+			return r, fmt.Errorf("source image for %s: %w", where, err)
+		}
+		r.SourceImage = &manifest.SourceImage{Image: fields["image"], Digest: fields["digest"], Platform: fields["platform"]}
+	}
+
+	sboms := map[string]string{}
+	for _, name := range sbom.FormatNames() {
+		f := fmt.Sprintf("rootfs-%s%s", stem, sbom.Formats[name].Extension)
+		if _, err := os.Stat(filepath.Join(buildDir, f)); err == nil {
+			sboms[name] = f
+		}
+	}
+	if len(sboms) > 0 {
+		r.SBOM = sboms["spdx"]
+		r.SBOMs = sboms
+	}
+
+	r.Vulnerabilities = fmt.Sprintf("rootfs-%s.vulns.json", stem)
+	if _, err := os.Stat(filepath.Join(buildDir, r.Vulnerabilities)); err != nil {
+		r.Vulnerabilities = ""
+	}
+
+	// The package database is exported by build-rootfs.sh, older build
+	// directories may not have it.
+	d, err := distro.Get(r.Distro)
+	if err != nil {
+		return r, err
+	}
+	err = addPackageInventory(&r, filepath.Join(buildDir, distro.PackageDB(d, stem)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return r, fmt.Errorf("package inventory for %s: %w", where, err)
+	}
+	return r, nil
+}
+
+// convertedImages returns the File and Images of r converted to formats by
+// make convert-images, checking each holds the disk of its source image.
+func convertedImages(r manifest.RootfsArtifact, formats []string, buildDir string) ([]manifest.RootfsImage, error) {
+	sources := []manifest.RootfsImage{{Filesystem: r.Filesystem, File: r.File, SizeBytes: r.SizeBytes, BootArgs: r.BootArgs}}
+	sources = append(sources, r.Images...)
+	var converted []manifest.RootfsImage
+	for _, src := range sources {
+		for _, format := range formats {
+			img := manifest.RootfsImage{
+				Filesystem: src.Filesystem,
+				File:       manifest.ConvertedFile(src.File, format),
+				ReadOnly:   src.ReadOnly,
+				BootArgs:   src.BootArgs,
+				Format:     format,
+				Source:     src.File,
+			}
+			path := filepath.Join(buildDir, img.File)
+			info, err := diskimage.Detect(path)
+			if err != nil {
+				return nil, err
+			}
+			if info.Format != format {
+				return nil, fmt.Errorf("%s is a %s image, expected %s", img.File, info.Format, format)
+			}
+			if info.VirtualSizeBytes != src.SizeBytes {
+				return nil, fmt.Errorf("%s holds a %d bytes disk, %s is %d bytes", img.File, info.VirtualSizeBytes, src.File, src.SizeBytes)
+			}
+			img.VirtualSizeBytes = info.VirtualSizeBytes
+			if img.SizeBytes, img.SHA256, err = digest.File(path); err != nil {
+				return nil, err
+			}
+			if img.DiskUsageBytes, err = sparse.DiskUsage(path); err != nil {
+				return nil, err
+			}
+			converted = append(converted, img)
+		}
+	}
+	return converted, nil
+}
+
+// verityArtifact returns the dm-verity hash tree of the image file root,
+// with the parameters recorded by build-rootfs.sh in
+// <hash tree>.info.
+func verityArtifact(root, buildDir string, overlay bool) (*manifest.RootfsVerity, error) {
+	v := &manifest.RootfsVerity{RootfsImage: manifest.RootfsImage{File: manifest.VerityFile(root)}, Root: root}
+
+	var err error
+	v.SizeBytes, v.SHA256, err = digest.File(filepath.Join(buildDir, v.File))
+	if err != nil {
+		return nil, err
+	}
+	fields, err := readSource(filepath.Join(buildDir, v.File+".info"), "root_hash", "salt", "hash_algorithm", "data_block_size", "hash_block_size", "data_blocks")
+	if err != nil {
+		return nil, err
+	}
+	v.RootHash, v.Salt, v.HashAlgorithm = fields["root_hash"], fields["salt"], fields["hash_algorithm"]
+	if v.DataBlockSize, err = strconv.Atoi(fields["data_block_size"]); err != nil {
+		return nil, fmt.Errorf("data_block_size: %w", err)
+	}
+	if v.HashBlockSize, err = strconv.Atoi(fields["hash_block_size"]); err != nil {
+		return nil, fmt.Errorf("hash_block_size: %w", err)
+	}
+	if v.DataBlocks, err = strconv.ParseInt(fields["data_blocks"], 10, 64); err != nil {
+		return nil, fmt.Errorf("data_blocks: %w", err)
+	}
+	return v, nil
+}
+
+// initramfsArtifact returns the initramfs artifact for arch, with the
+// busybox package recorded by build-initramfs.sh in initramfs-<arch>.source.
+func initramfsArtifact(cfg config.Initramfs, arch, buildDir string) (*manifest.InitramfsArtifact, error) {
+	a := &manifest.InitramfsArtifact{File: fmt.Sprintf("initramfs-%s.cpio.gz", arch)}
+
+	var err error
+	a.SizeBytes, a.SHA256, err = digest.File(filepath.Join(buildDir, a.File))
+	if err != nil {
+		return nil, err
+	}
+	if _, a.InitSHA256, err = digest.File(cfg.Init); err != nil {
+		return nil, fmt.Errorf("init script: %w", err)
+	}
+
+	fields, err := readSource(filepath.Join(buildDir, fmt.Sprintf("initramfs-%s.source", arch)), "version", "url")
+	if err != nil {
+		return nil, err
+	}
+	a.BusyboxVersion = fields["version"]
+	a.Source = fields["url"]
+	return a, nil
+}
+
+// firecrackerArtifact returns the bundled Firecracker binaries of arch, with
+// the upstream release archive recorded by download-firecracker.sh in
+// firecracker-<arch>.source.
+func firecrackerArtifact(arch, buildDir string) (*manifest.FirecrackerArtifact, error) {
+	fields, err := readSource(filepath.Join(buildDir, fmt.Sprintf("firecracker-%s.source", arch)), "version", "url", "sha256")
+	if err != nil {
+		return nil, err
+	}
+
+	a := &manifest.FirecrackerArtifact{
+		Version:      fields["version"],
+		Source:       fields["url"],
+		SourceSHA256: fields["sha256"],
+		Firecracker:  manifest.BinaryArtifact{File: "firecracker-" + arch},
+		Jailer:       manifest.BinaryArtifact{File: "jailer-" + arch},
+	}
+	for _, b := range a.Binaries() {
+		b.SizeBytes, b.SHA256, err = digest.File(filepath.Join(buildDir, b.File))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// agentArtifact returns the guest agent of arch. URL binaries are verified
+// against their pinned digest when staged, local ones are hashed.
+func agentArtifact(agent config.Agent, configDir, arch string) (*manifest.AgentArtifact, error) {
+	b := agent.Binaries[arch]
+	a := &manifest.AgentArtifact{
+		Name:     agent.Name,
+		Version:  agent.Version,
+		Protocol: agent.Protocol,
+		Path:     agent.Dest,
+		Source:   b.URL,
+		SHA256:   b.SHA256,
+	}
+	if b.File != "" {
+		_, sum, err := digest.File(b.File)
+		if err != nil {
+			return nil, err
+		}
+		a.SHA256 = sum
+		a.Source = b.File
+		if rel, err := filepath.Rel(configDir, b.File); err == nil {
+			a.Source = filepath.ToSlash(rel)
+		}
+	}
+	return a, nil
+}
+
+// readSource reads the key=value lines of a .source file written by the
+// build scripts, requiring the given keys.
+func readSource(path string, required ...string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			fields[key] = value
+		}
+	}
+	for _, key := range required {
+		if fields[key] == "" {
+			return nil, fmt.Errorf("no %s in %s", key, path)
+		}
+	}
+	return fields, nil
+}
+
+// firstbootScript is the first boot service installed by build-rootfs.sh,
+// relative to the config file.
+const firstbootScript = "alpine/files/usr/sbin/sbx-firstboot"
+
+var firstbootVersionRe = regexp.MustCompile(`(?m)^SBX_FIRSTBOOT_VERSION="([^"]+)"`)
+
+// kernelCompilerRe matches the compiler in the kernel banner, e.g.
+// "Linux version 6.1.155 (user@host) (gcc (GCC) 11.4.0, GNU ld 2.38) #1 SMP".
+var kernelCompilerRe = regexp.MustCompile(`Linux version \S+ \([^)]*\) \(((?:[^()]|\([^()]*\))+)\)`)
+
+// buildToolchain returns the tool versions recorded by build-rootfs.sh
+// (rootfs-<stem>.toolchain), the compiler embedded in the kernel banner and
+// the Go version of the manifest generator.
+func buildToolchain(cfg config.Config, buildDir string) (map[string]string, error) {
+	toolchain := map[string]string{"go": runtime.Version()}
+	for _, arch := range cfg.Architectures {
+		for _, p := range cfg.RootfsProfiles() {
+			data, err := os.ReadFile(filepath.Join(buildDir, fmt.Sprintf("rootfs-%s.toolchain", p.Stem(arch))))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("toolchain of %s: %w", p.Stem(arch), err)
+			}
+			for line := range strings.SplitSeq(string(data), "\n") {
+				if name, version, ok := strings.Cut(strings.TrimSpace(line), "="); ok && name != "" {
+					toolchain[name] = version
+				}
+			}
+		}
+
+		for _, f := range cfg.KernelFlavors() {
+			kernel, err := os.ReadFile(filepath.Join(buildDir, f.ArtifactName("vmlinux", arch)))
+			if err != nil {
+				return nil, fmt.Errorf("kernel artifact for %s: %w", arch, err)
+			}
+			if m := kernelCompilerRe.FindSubmatch(kernel); m != nil {
+				toolchain[f.ArtifactName("kernel-compiler", arch)] = string(m[1])
+			}
+		}
+	}
+	return toolchain, nil
+}
+
+// bootCapabilities returns the self check capability flags of a boot-matrix
+// report, nil when the images weren't boot tested.
+func bootCapabilities(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var report struct {
+		Capabilities map[string]bool `json:"capabilities"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return report.Capabilities, nil
+}
+
+// bootTimes returns the boot times measured by the boot-test reports
+// (boot-test-<profile stem>.json) of the images that booted, the profiles
+// not boot tested being left out.
+func bootTimes(cfg config.Config, buildDir string) ([]manifest.BootTime, error) {
+	var times []manifest.BootTime
+	for _, arch := range cfg.Architectures {
+		for _, p := range cfg.RootfsProfiles() {
+			path := filepath.Join(buildDir, fmt.Sprintf("boot-test-%s.json", p.Stem(arch)))
+			data, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			var report struct {
+				Booted      bool                  `json:"booted"`
+				Runs        int                   `json:"runs"`
+				TimeToInit  manifest.Percentiles  `json:"time_to_init"`
+				TimeToAgent *manifest.Percentiles `json:"time_to_agent"`
+			}
+			if err := json.Unmarshal(data, &report); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+			if !report.Booted {
+				slog.Warn("Boot test failed, no boot time recorded", "arch", arch, "profile", p.Name, "report", path)
+				continue
+			}
+			times = append(times, manifest.BootTime{
+				Arch:        arch,
+				Profile:     p.Name,
+				Runs:        report.Runs,
+				TimeToInit:  report.TimeToInit,
+				TimeToAgent: report.TimeToAgent,
+			})
+		}
+	}
+	return times, nil
+}
+
+// firstbootVersion reads the version declared by the first boot script.
+func firstbootVersion(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	match := firstbootVersionRe.FindSubmatch(data)
+	if match == nil {
+		return "", fmt.Errorf("no SBX_FIRSTBOOT_VERSION found in %s", path)
+	}
+	return string(match[1]), nil
+}
+
+// kernelParameters returns the kernel build parameters of a flavor recorded
+// in the provenance.
+func kernelParameters(f config.KernelFlavor) map[string]any {
+	params := map[string]any{
+		"version":    f.Version,
+		"ci_version": f.CIVersion,
+	}
+	if f.Name != manifest.DefaultFlavor {
+		params["flavor"] = f.Name
+	}
+	if f.BuildFromSource {
+		params["build_from_source"] = true
+		params["source_repo"] = f.SourceRepo
+		params["source_ref"] = f.SourceRef
+	}
+	if f.Modules != "" {
+		params["modules"] = f.Modules
+	}
+	return params
+}
+
+// writeProvenance writes a SLSA provenance statement next to each artifact
+// and references it from the manifest.
+func writeProvenance(m *manifest.Manifest, cfg config.Config, configPath, builderID string, out statementOut) error {
+	_, configDigest, err := digest.File(configPath)
+	if err != nil {
+		return fmt.Errorf("config digest: %w", err)
+	}
+
+	opts := provenance.Options{
+		BuilderID:    builderID,
+		InvocationID: githubInvocationID(),
+		FinishedOn:   time.Now(),
+		Parameters: map[string]any{
+			"version": m.Version,
+			"config":  filepath.Base(configPath),
+			"kernel":  kernelParameters(cfg.KernelFlavors()[0]),
+			"rootfs": map[string]any{
+				"distro":         cfg.Rootfs.Distro,
+				"distro_version": cfg.Rootfs.DistroVersion,
+				"bootstrap":      cfg.Rootfs.Bootstrap,
+				"profile":        cfg.Rootfs.Profile,
+			},
 		},
-		Build: ManifestBuild{
-			Date:   time.Now().UTC().Format(time.RFC3339),
-			Commit: commit,
+	}
+	if opts.BuilderID == "" {
+		opts.BuilderID = githubBuilderID()
+	}
+	if len(cfg.Overrides) > 0 {
+		opts.Parameters["config_overrides"] = cfg.Overrides
+	}
+
+	baseDeps := []provenance.ResourceDescriptor{
+		{URI: "git+https://github.com/slok/sbx-images", Digest: map[string]string{"gitCommit": m.Build.Commit}},
+		{URI: "file:" + filepath.Base(configPath), Digest: map[string]string{"sha256": configDigest}},
+	}
+	for _, inc := range cfg.Files[1:] {
+		_, sum, err := digest.File(inc)
+		if err != nil {
+			return fmt.Errorf("config include digest: %w", err)
+		}
+		rel, err := filepath.Rel(filepath.Dir(configPath), inc)
+		if err != nil {
+			rel = inc
+		}
+		baseDeps = append(baseDeps, provenance.ResourceDescriptor{URI: "file:" + filepath.ToSlash(rel), Digest: map[string]string{"sha256": sum}})
+	}
+
+	hooks, hookDeps, err := hookParameters(cfg, configPath)
+	if err != nil {
+		return err
+	}
+	if len(hooks) > 0 {
+		opts.Parameters["hooks"] = hooks
+		baseDeps = append(baseDeps, hookDeps...)
+	}
+
+	flavors := map[string]config.KernelFlavor{}
+	for _, f := range cfg.KernelFlavors() {
+		flavors[f.Name] = f
+	}
+
+	for arch, a := range m.Artifacts {
+		for _, k := range a.Kernels() {
+			if err := writeKernelProvenance(k, flavors[cmp.Or(k.Flavor, manifest.DefaultFlavor)].ForArch(arch), arch, out, opts, baseDeps); err != nil {
+				return err
+			}
+		}
+
+		for _, r := range a.Rootfses() {
+			if err := writeRootfsProvenance(r, a.Kernels(), a.Agent, cfg, configPath, arch, out, opts, baseDeps); err != nil {
+				return err
+			}
+		}
+
+		if a.Initramfs != nil {
+			if err := writeInitramfsProvenance(a.Initramfs, cfg, configPath, arch, out, opts, baseDeps); err != nil {
+				return err
+			}
+		}
+
+		if a.Firecracker != nil {
+			fcOpts := opts
+			fcOpts.Parameters = maps.Clone(opts.Parameters)
+			fcOpts.Parameters["firecracker"] = map[string]any{"version": a.Firecracker.Version}
+			fcOpts.ResolvedDependencies = append(slices.Clone(baseDeps), provenance.ResourceDescriptor{
+				URI:    a.Firecracker.Source,
+				Digest: map[string]string{"sha256": a.Firecracker.SourceSHA256},
+			})
+			fcOpts.InternalParameters = map[string]any{"arch": arch}
+			for _, b := range a.Firecracker.Binaries() {
+				b.Provenance, err = writeStatement(out, b.File, b.SHA256, fcOpts)
+				if err != nil {
+					return fmt.Errorf("firecracker binary %s: %w", b.File, err)
+				}
+			}
+		}
+
+		m.Artifacts[arch] = a
+	}
+
+	for i := range m.Disks {
+		d := &m.Disks[i]
+		diskOpts := opts
+		diskOpts.Parameters = maps.Clone(opts.Parameters)
+		diskOpts.Parameters["disk"] = map[string]any{
+			"name":       d.Name,
+			"filesystem": d.Filesystem,
+			"size_bytes": d.SizeBytes,
+			"label":      d.Label,
+		}
+		diskOpts.ResolvedDependencies = baseDeps
+		d.Provenance, err = writeStatement(out, d.File, d.SHA256, diskOpts)
+		if err != nil {
+			return fmt.Errorf("data disk %s: %w", d.File, err)
+		}
+	}
+
+	return nil
+}
+
+// writeRootfsProvenance writes the provenance statement of the rootfs image
+// r, built from the package lists of its profile extends chain, the
+// installed kernel modules, the embedded agent, the Ignition config and
+// binary and the source container image, or from the locked flake of a Nix
+// profile.
+func writeRootfsProvenance(r *manifest.RootfsArtifact, kernels []*manifest.KernelArtifact, agent *manifest.AgentArtifact, cfg config.Config, configPath, arch string, out statementOut, opts provenance.Options, baseDeps []provenance.ResourceDescriptor) error {
+	opts.Parameters = maps.Clone(opts.Parameters)
+	params := map[string]any{
+		"distro":         r.Distro,
+		"distro_version": r.DistroVersion,
+		"bootstrap":      r.Bootstrap,
+		"profile":        r.Profile,
+	}
+	if r.Definition != nil {
+		params["init"] = r.Definition.Init
+	}
+	chain := []string{r.Profile}
+	if r.Definition != nil && len(r.Definition.Extends) > 0 {
+		params["extends"] = r.Definition.Extends
+		chain = append(chain, r.Definition.Extends...)
+	}
+	opts.Parameters["rootfs"] = params
+	d, err := distro.Get(r.Distro)
+	if err != nil {
+		return err
+	}
+	opts.ResolvedDependencies = slices.Clone(baseDeps)
+	if r.Definition != nil && r.Definition.Nix != nil {
+		nix := r.Definition.Nix
+		params["nix"] = nix.Flake + "#" + nix.Package
+		opts.ResolvedDependencies = append(opts.ResolvedDependencies, provenance.ResourceDescriptor{
+			URI:    "file:" + path.Join(nix.Flake, "flake.lock"),
+			Digest: map[string]string{"sha256": nix.LockSHA256},
+		})
+	} else {
+		opts.ResolvedDependencies = append(opts.ResolvedDependencies, provenance.ResourceDescriptor{
+			URI: d.Repository(r.DistroVersion),
+		})
+	}
+	for _, name := range chain {
+		profileFile := filepath.Join(config.ProfilesDir, name+".txt")
+		if _, profileDigest, err := digest.File(filepath.Join(filepath.Dir(configPath), profileFile)); err == nil {
+			opts.ResolvedDependencies = append(opts.ResolvedDependencies, provenance.ResourceDescriptor{
+				URI:    "file:" + filepath.ToSlash(profileFile),
+				Digest: map[string]string{"sha256": profileDigest},
+			})
+		}
+	}
+	for _, k := range kernels {
+		if k.Modules != nil && k.Modules.Installed {
+			opts.ResolvedDependencies = append(opts.ResolvedDependencies, provenance.ResourceDescriptor{
+				URI:    "file:" + k.Modules.File,
+				Digest: map[string]string{"sha256": k.Modules.SHA256},
+			})
+		}
+	}
+	if agent != nil {
+		uri := agent.Source
+		if !strings.Contains(uri, "://") {
+			uri = "file:" + uri
+		}
+		opts.ResolvedDependencies = append(opts.ResolvedDependencies, provenance.ResourceDescriptor{
+			URI:    uri,
+			Digest: map[string]string{"sha256": agent.SHA256},
+		})
+	}
+	if img := r.SourceImage; img != nil {
+		algo, digest, _ := strings.Cut(img.Digest, ":")
+		opts.ResolvedDependencies = append(opts.ResolvedDependencies, provenance.ResourceDescriptor{
+			URI:    "oci://" + img.Image,
+			Digest: map[string]string{algo: digest},
+		})
+	}
+	if r.Definition != nil && r.Definition.Ignition != nil {
+		ign := r.Definition.Ignition
+		opts.ResolvedDependencies = append(opts.ResolvedDependencies, provenance.ResourceDescriptor{
+			URI:    "file:" + ign.Config,
+			Digest: map[string]string{"sha256": ign.SHA256},
+		})
+		b := cfg.Ignition.Binaries[arch]
+		dep := provenance.ResourceDescriptor{URI: b.URL, Digest: map[string]string{"sha256": b.SHA256}}
+		if b.File != "" {
+			_, sum, err := digest.File(b.File)
+			if err != nil {
+				return fmt.Errorf("ignition binary: %w", err)
+			}
+			dep = provenance.ResourceDescriptor{URI: "file:" + b.File, Digest: map[string]string{"sha256": sum}}
+			if rel, err := filepath.Rel(filepath.Dir(configPath), b.File); err == nil {
+				dep.URI = "file:" + filepath.ToSlash(rel)
+			}
+		}
+		opts.ResolvedDependencies = append(opts.ResolvedDependencies, dep)
+	}
+	opts.InternalParameters = map[string]any{"arch": arch}
+
+	r.Provenance, err = writeStatement(out, r.File, r.SHA256, opts)
+	if err != nil {
+		return fmt.Errorf("rootfs artifact %s: %w", r.File, err)
+	}
+	for _, img := range r.ExtraImages() {
+		params := maps.Clone(params)
+		if img.Filesystem != "" {
+			params["filesystem"] = img.Filesystem
+		}
+		if r.Overlay != nil && img == &r.Overlay.RootfsImage {
+			params["overlay_root"] = r.Overlay.Root
+		}
+		if r.Verity != nil && img == &r.Verity.RootfsImage {
+			params["verity_root"] = r.Verity.Root
+		}
+		if img.Format != "" {
+			params["disk_format"] = img.Format
+			params["disk_source"] = img.Source
+		}
+		opts.Parameters = maps.Clone(opts.Parameters)
+		opts.Parameters["rootfs"] = params
+		img.Provenance, err = writeStatement(out, img.File, img.SHA256, opts)
+		if err != nil {
+			return fmt.Errorf("rootfs artifact %s: %w", img.File, err)
+		}
+	}
+	return nil
+}
+
+// writeInitramfsProvenance writes the provenance statement of the initramfs
+// a, built from the busybox package and the init script.
+func writeInitramfsProvenance(a *manifest.InitramfsArtifact, cfg config.Config, configPath, arch string, out statementOut, opts provenance.Options, baseDeps []provenance.ResourceDescriptor) error {
+	init, err := filepath.Rel(filepath.Dir(configPath), cfg.Initramfs.Init)
+	if err != nil {
+		init = cfg.Initramfs.Init
+	}
+
+	opts.Parameters = maps.Clone(opts.Parameters)
+	opts.Parameters["initramfs"] = map[string]any{
+		"busybox_version": a.BusyboxVersion,
+		"init":            filepath.ToSlash(init),
+	}
+	opts.ResolvedDependencies = append(slices.Clone(baseDeps),
+		provenance.ResourceDescriptor{URI: a.Source},
+		provenance.ResourceDescriptor{URI: "file:" + filepath.ToSlash(init), Digest: map[string]string{"sha256": a.InitSHA256}},
+	)
+	opts.InternalParameters = map[string]any{"arch": arch}
+
+	a.Provenance, err = writeStatement(out, a.File, a.SHA256, opts)
+	if err != nil {
+		return fmt.Errorf("initramfs artifact %s: %w", a.File, err)
+	}
+	return nil
+}
+
+// writeKernelProvenance writes the provenance statement of the kernel k of
+// flavor f.
+func writeKernelProvenance(k *manifest.KernelArtifact, f config.KernelFlavor, arch string, out statementOut, opts provenance.Options, baseDeps []provenance.ResourceDescriptor) error {
+	opts.Parameters = maps.Clone(opts.Parameters)
+	opts.Parameters["kernel"] = kernelParameters(f)
+
+	kernelDep := provenance.ResourceDescriptor{
+		URI:    fmt.Sprintf("https://s3.amazonaws.com/spec.ccfc.min/firecracker-ci/%s/%s/vmlinux-%s", f.CIVersion, arch, f.Version),
+		Digest: map[string]string{"sha256": k.UpstreamSHA256},
+	}
+	if k.SourceCommit != "" {
+		kernelDep = provenance.ResourceDescriptor{
+			URI:    fmt.Sprintf("git+%s@%s", k.Source, k.SourceRef),
+			Digest: map[string]string{"gitCommit": k.SourceCommit},
+		}
+	}
+	opts.ResolvedDependencies = append(slices.Clone(baseDeps), kernelDep)
+	for _, p := range k.Patches {
+		uri := p.Source
+		if !strings.Contains(uri, "://") {
+			uri = "file:" + uri
+		}
+		opts.ResolvedDependencies = append(opts.ResolvedDependencies, provenance.ResourceDescriptor{
+			URI:    uri,
+			Digest: map[string]string{"sha256": p.SHA256},
+		})
+	}
+	opts.InternalParameters = map[string]any{"arch": arch}
+
+	var err error
+	k.Provenance, err = writeStatement(out, k.File, k.SHA256, opts)
+	if err != nil {
+		return fmt.Errorf("kernel artifact %s: %w", k.File, err)
+	}
+	for i, img := range k.Images {
+		params := kernelParameters(f)
+		params["format"] = img.Format
+		opts.Parameters = maps.Clone(opts.Parameters)
+		opts.Parameters["kernel"] = params
+		k.Images[i].Provenance, err = writeStatement(out, img.File, img.SHA256, opts)
+		if err != nil {
+			return fmt.Errorf("kernel artifact %s: %w", img.File, err)
+		}
+	}
+	return nil
+}
+
+// hookParameters returns the declared permissions of the build hooks and
+// their scripts as build materials.
+func hookParameters(cfg config.Config, configPath string) ([]map[string]any, []provenance.ResourceDescriptor, error) {
+	var (
+		params []map[string]any
+		deps   []provenance.ResourceDescriptor
+	)
+	for _, h := range cfg.Hooks {
+		_, sum, err := digest.File(filepath.Join(filepath.Dir(configPath), h.Script))
+		if err != nil {
+			return nil, nil, fmt.Errorf("hook %s: %w", h.Name, err)
+		}
+
+		params = append(params, map[string]any{
+			"name":        h.Name,
+			"script":      h.Script,
+			"network":     h.Network,
+			"read_paths":  h.ReadPaths,
+			"write_paths": h.WritePaths,
+		})
+		deps = append(deps, provenance.ResourceDescriptor{
+			URI:    "file:" + filepath.ToSlash(h.Script),
+			Name:   "hook:" + h.Name,
+			Digest: map[string]string{"sha256": sum},
+		})
+	}
+	return params, deps, nil
+}
+
+// statementOut is where the provenance statements are written.
+type statementOut struct {
+	dir string
+	// dryRun only logs the statements that would be written.
+	dryRun bool
+}
+
+// writeStatement writes the provenance of file and returns the statement file name.
+func writeStatement(out statementOut, file, digest string, opts provenance.Options) (string, error) {
+	name := file + ".intoto.json"
+	dst := filepath.Join(out.dir, name)
+	if out.dryRun {
+		slog.Info("Dry run, would write provenance", "path", dst)
+		return name, nil
+	}
+	st := provenance.New(provenance.Subject{Name: file, Digest: map[string]string{"sha256": digest}}, opts)
+	if err := provenance.Write(dst, st); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// githubBuilderID returns the workflow identity when running in GitHub Actions.
+func githubBuilderID() string {
+	if os.Getenv("GITHUB_ACTIONS") != "true" {
+		return "local"
+	}
+	return fmt.Sprintf("%s/%s", os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_WORKFLOW_REF"))
+}
+
+func githubInvocationID() string {
+	if os.Getenv("GITHUB_ACTIONS") != "true" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/actions/runs/%s/attempts/%s",
+		os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"),
+		os.Getenv("GITHUB_RUN_ID"), os.Getenv("GITHUB_RUN_ATTEMPT"))
+}
+
+// checkKernel checks the header of the kernel file at path against its
+// expected format and arch, catching swapped or corrupted files, and that
+// the x86_64 vmlinux of Cloud Hypervisor has the PVH entry point it boots.
+func checkKernel(path, format, arch, hypervisor string) error {
+	k, err := inspect.Kernel(path)
+	if err != nil {
+		return err
+	}
+	if k.Format != format || k.Arch != arch {
+		return fmt.Errorf("%s is a %s %s kernel, expected %s %s", filepath.Base(path), k.Arch, k.Format, arch, format)
+	}
+	if hypervisor == manifest.HypervisorCloudHypervisor && k.Arch == "x86_64" && k.Format == manifest.KernelFormatELF && !k.PVH {
+		return fmt.Errorf("%s has no PVH entry point for %s, enable CONFIG_PVH", filepath.Base(path), hypervisor)
+	}
+	return nil
+}
+
+// qemuMicrovm returns the QEMU microvm boot metadata of the x86_64 kernels,
+// checking the Firecracker ones have the PVH entry point QEMU boots vmlinux
+// ELFs through.
+func qemuMicrovm(kernels []manifest.KernelArtifact, buildDir string) (*manifest.QEMUMicrovm, error) {
+	for _, k := range kernels {
+		if k.TargetHypervisor() != manifest.HypervisorFirecracker {
+			continue
+		}
+		info, err := inspect.Kernel(filepath.Join(buildDir, k.File))
+		if err != nil {
+			return nil, err
+		}
+		if !info.PVH {
+			return nil, fmt.Errorf("qemu microvm: %s has no PVH entry point, enable CONFIG_PVH", k.File)
+		}
+	}
+	// The virtio-mmio devices are passed on the cmdline without ACPI, as
+	// Firecracker does.
+	machine := "microvm,acpi=off,x-option-roms=off,pit=off,pic=off,rtc=on"
+	return &manifest.QEMUMicrovm{
+		Binary:       "qemu-system-x86_64",
+		Machine:      machine,
+		KernelFormat: manifest.KernelFormatELF,
+		Args: []string{
+			"-machine", machine,
+			// KVM when available, emulated otherwise.
+			"-accel", "kvm", "-accel", "tcg",
+			"-cpu", "max", "-m", "256",
+			"-nodefaults", "-no-user-config", "-display", "none", "-no-reboot",
+			"-serial", "mon:stdio",
+			"-kernel", "{kernel}",
+			"-append", "{cmdline}",
+			// The writes go to a temporary file, the image is untouched.
+			"-drive", "id=root,file={rootfs},format=raw,if=none,snapshot=on",
+			"-device", "virtio-blk-device,drive=root",
 		},
 	}, nil
 }
 
-func fileSize(path string) (int64, error) {
-	info, err := os.Stat(path)
+// checkFilesystem checks the superblock of the image file at path against
+// its expected filesystem, and that the file of size holds the whole
+// filesystem, catching swapped or truncated files.
+func checkFilesystem(path, fs string, size int64) error {
+	info, err := inspect.Filesystem(path)
 	if err != nil {
-		return 0, fmt.Errorf("stat %s: %w", path, err)
+		return err
+	}
+	if info.Type != fs {
+		return fmt.Errorf("%s is a %s image, expected %s", filepath.Base(path), info.Type, fs)
 	}
-	return info.Size(), nil
+	if info.SizeBytes > size {
+		return fmt.Errorf("%s is truncated: %d bytes of a %d bytes %s filesystem", filepath.Base(path), size, info.SizeBytes, fs)
+	}
+	return nil
+}
+
+// addKernelSource records the repository, ref and commit of a kernel built
+// from source, from the vmlinux-<arch>.source file written by
+// build-kernel.sh.
+func addKernelSource(k *manifest.KernelArtifact, path string) error {
+	fields, err := readSource(path, "repo", "commit")
+	if err != nil {
+		return err
+	}
+
+	k.Source = fields["repo"]
+	k.SourceRef = fields["ref"]
+	k.SourceCommit = fields["commit"]
+	return nil
+}
+
+// addPackageInventory records the name, version and license of every
+// package in the package database at path, the inventory digest and the
+// license summary in the rootfs artifact.
+func addPackageInventory(a *manifest.RootfsArtifact, path string) error {
+	pkgs, err := sbom.ReadInstalled(a.Distro, path)
+	if err != nil {
+		return err
+	}
+
+	a.Packages = make([]manifest.Package, 0, len(pkgs))
+	h := sha256.New()
+	for _, p := range pkgs {
+		a.Packages = append(a.Packages, manifest.Package{Name: p.Name, Version: p.Version, License: p.License})
+		fmt.Fprintf(h, "%s %s\n", p.Name, p.Version)
+	}
+	a.PackagesSHA256 = hex.EncodeToString(h.Sum(nil))
+	a.Licenses = sbom.LicenseSummary(pkgs)
+	return nil
 }
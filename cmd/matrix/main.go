@@ -0,0 +1,190 @@
+// Command matrix prints the build matrix of config.yaml as GitHub Actions
+// strategy matrix JSON, so a workflow fans out one build job per
+// architecture, rootfs profile and kernel flavor without repeating the
+// config:
+//
+//	jobs:
+//	  matrix:
+//	    outputs:
+//	      matrix: ${{ steps.matrix.outputs.matrix }}
+//	    steps:
+//	      - id: matrix
+//	        run: echo "matrix=$(go run ./cmd/matrix)" >> "$GITHUB_OUTPUT"
+//	  build:
+//	    needs: matrix
+//	    runs-on: ${{ matrix.runner }}
+//	    strategy:
+//	      matrix: ${{ fromJSON(needs.matrix.outputs.matrix) }}
+//
+// The matrix is an include list, each entry with the arch, profile and
+// flavor, the kernel_stem and rootfs_stem of their file names
+// (vmlinux-<kernel_stem>, rootfs-<rootfs_stem>.ext4) and the runner label of
+// the architecture (-runners, default: ubuntu-latest). -arch, -profile and
+// -flavor limit the matrix to the given comma separated values.
+//
+// Usage:
+//
+//	go run ./cmd/matrix -config config.yaml
+//	go run ./cmd/matrix -runners aarch64=ubuntu-24.04-arm -profile dev -indent
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+)
+
+// defaultRunner is the runs-on label of the architectures without -runners
+// entry.
+const defaultRunner = "ubuntu-latest"
+
+// Entry is a build job of the matrix.
+type Entry struct {
+	Arch       string `json:"arch"`
+	Profile    string `json:"profile"`
+	Flavor     string `json:"flavor"`
+	KernelStem string `json:"kernel_stem"`
+	RootfsStem string `json:"rootfs_stem"`
+	Runner     string `json:"runner"`
+}
+
+// Matrix is a GitHub Actions strategy matrix.
+type Matrix struct {
+	Include []Entry `json:"include"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		archs      string
+		profiles   string
+		flavors    string
+		runners    string
+		indent     bool
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&archs, "arch", "", "Comma separated architectures (default: architectures)")
+	flag.StringVar(&profiles, "profile", "", "Comma separated rootfs profiles (default: every profile)")
+	flag.StringVar(&flavors, "flavor", "", "Comma separated kernel flavors (default: every flavor)")
+	flag.StringVar(&runners, "runners", "", "Comma separated arch=label runs-on labels (default: "+defaultRunner+")")
+	flag.BoolVar(&indent, "indent", false, "Indent the JSON output")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	labels := map[string]string{}
+	for _, kv := range split(runners) {
+		arch, label, ok := strings.Cut(kv, "=")
+		if !ok || label == "" {
+			return fmt.Errorf("-runners: %q is not arch=label", kv)
+		}
+		if !slices.Contains(cfg.Architectures, arch) {
+			return fmt.Errorf("-runners: %q is not in architectures", arch)
+		}
+		labels[arch] = label
+	}
+
+	var profileNames, flavorNames []string
+	for _, p := range cfg.RootfsProfiles() {
+		profileNames = append(profileNames, p.Name)
+	}
+	for _, f := range cfg.KernelFlavors() {
+		flavorNames = append(flavorNames, f.Name)
+	}
+	onlyArchs, err := only("architecture", archs, cfg.Architectures)
+	if err != nil {
+		return err
+	}
+	onlyProfiles, err := only("rootfs profile", profiles, profileNames)
+	if err != nil {
+		return err
+	}
+	onlyFlavors, err := only("kernel flavor", flavors, flavorNames)
+	if err != nil {
+		return err
+	}
+	selected := func(only []string, v string) bool {
+		return len(only) == 0 || slices.Contains(only, v)
+	}
+
+	m := Matrix{Include: []Entry{}}
+	for _, arch := range cfg.Architectures {
+		if !selected(onlyArchs, arch) {
+			continue
+		}
+		for _, p := range cfg.RootfsProfiles() {
+			if !selected(onlyProfiles, p.Name) {
+				continue
+			}
+			for _, f := range cfg.KernelFlavors() {
+				if !selected(onlyFlavors, f.Name) {
+					continue
+				}
+				m.Include = append(m.Include, Entry{
+					Arch:       arch,
+					Profile:    p.Name,
+					Flavor:     f.Name,
+					KernelStem: strings.TrimPrefix(f.ArtifactName("", arch), "-"),
+					RootfsStem: p.Stem(arch),
+					Runner:     cmp.Or(labels[arch], defaultRunner),
+				})
+			}
+		}
+	}
+
+	var data []byte
+	if indent {
+		data, err = json.MarshalIndent(m, "", "  ")
+	} else {
+		data, err = json.Marshal(m)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// only returns the values of the comma separated list s of kind, checked
+// against the known ones.
+func only(kind, s string, known []string) ([]string, error) {
+	values := split(s)
+	for _, v := range values {
+		if !slices.Contains(known, v) {
+			return nil, fmt.Errorf("unknown %s %q", kind, v)
+		}
+	}
+	return values, nil
+}
+
+// split returns the values of a comma separated list, none when empty.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,95 @@
+// Command nocloud-seed renders a cloud-init NoCloud seed image (a vfat or
+// ISO 9660 filesystem labelled cidata) from user-data, meta-data and
+// network-config files, to attach to a sandbox booting a cloud_init rootfs
+// profile as an extra read-only drive.
+//
+// Without -meta-data, a meta-data document is rendered from -instance-id and
+// -hostname. Without -user-data, an empty #cloud-config document is used.
+// Requires mkfs.vfat and mcopy (vfat) or genisoimage, mkisofs or xorriso
+// (iso).
+//
+// Usage:
+//
+//	go run ./cmd/nocloud-seed -user-data user-data.yaml -hostname sbx-1 -out build/seed.img
+//	go run ./cmd/nocloud-seed -user-data user-data.yaml -meta-data meta-data.yaml -network-config net.yaml -format iso -out build/seed.iso
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/nocloud"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		userData      string
+		metaData      string
+		networkConfig string
+		instanceID    string
+		hostname      string
+		format        string
+		out           string
+	)
+
+	flag.StringVar(&userData, "user-data", "", "user-data file (default: an empty #cloud-config)")
+	flag.StringVar(&metaData, "meta-data", "", "meta-data file (default: rendered from -instance-id and -hostname)")
+	flag.StringVar(&networkConfig, "network-config", "", "Optional network-config file")
+	flag.StringVar(&instanceID, "instance-id", "iid-sbx", "Instance ID of the rendered meta-data")
+	flag.StringVar(&hostname, "hostname", "", "Hostname of the rendered meta-data")
+	flag.StringVar(&format, "format", nocloud.FormatVFAT, "Seed image format ("+strings.Join(nocloud.Formats, ", ")+")")
+	flag.StringVar(&out, "out", "", "Seed image path")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	if !slices.Contains(nocloud.Formats, format) {
+		return fmt.Errorf("unknown format %q (supported: %s)", format, strings.Join(nocloud.Formats, ", "))
+	}
+
+	seed := nocloud.Seed{
+		UserData: []byte("#cloud-config\n{}\n"),
+		MetaData: nocloud.MetaData(instanceID, hostname),
+	}
+	var err error
+	if userData != "" {
+		if seed.UserData, err = os.ReadFile(userData); err != nil {
+			return err
+		}
+	}
+	if metaData != "" {
+		if seed.MetaData, err = os.ReadFile(metaData); err != nil {
+			return err
+		}
+	}
+	if networkConfig != "" {
+		if seed.NetworkConfig, err = os.ReadFile(networkConfig); err != nil {
+			return err
+		}
+	}
+
+	if err := nocloud.Build(context.Background(), seed, format, out); err != nil {
+		return fmt.Errorf("building seed image: %w", err)
+	}
+	slog.Info("Wrote NoCloud seed image", "path", out)
+	return nil
+}
@@ -0,0 +1,268 @@
+// Command oci distributes releases as OCI artifacts, see pkg/ociartifact.
+//
+// The push subcommand packages manifest.json, the kernels, rootfs images and
+// the other release files of the build directory as an artifact per
+// architecture (tagged <version>-<arch>) under an index tagged with the
+// release version, and pushes them to a registry repository with the docker
+// config credentials (docker login). The layers already in the registry are
+// not uploaded again. With -dry-run it prints the artifacts without pushing.
+//
+// The pull subcommand resolves a release index or artifact tag (or digest)
+// to the artifact of an architecture (the host one by default), optionally
+// verifies its cosign signature (-cosign-key, or -cosign-identity and
+// -cosign-issuer for keyless signatures) and writes its files to a directory
+// in the build directory layout, verifying every layer digest. The files
+// already there with the layer digest are not downloaded again.
+//
+// The containerd subcommand imports the release into the content store of a
+// local containerd with ctr, as images named <name>:<version> and
+// <name>:<version>-<arch> labeled with the release annotations, so hosts
+// running containerd manage the release files with its garbage collection
+// (ctr content fetch-blob, ctr images rm). With -archive it writes the OCI
+// archive ctr imports instead, for another host.
+//
+// With -o json the pushed or imported artifacts, with their digests, or the
+// pulled files are printed to stdout as a JSON document.
+//
+// Usage:
+//
+//	go run ./cmd/oci push -build-dir build -repository ghcr.io/slok/sbx-images -dry-run
+//	go run ./cmd/oci push -build-dir build -repository ghcr.io/slok/sbx-images
+//	go run ./cmd/oci push -build-dir build -repository localhost:5000/sbx-images -insecure -o json
+//	go run ./cmd/oci pull -ref ghcr.io/slok/sbx-images:v0.1.0 -out-dir release
+//	go run ./cmd/oci pull -ref ghcr.io/slok/sbx-images:v0.1.0 -arch aarch64 -cosign-key cosign.pub -out-dir release
+//	sudo go run ./cmd/oci containerd -build-dir build -namespace sbx -name localhost/sbx-images
+//	go run ./cmd/oci containerd -build-dir build -archive release.tar
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/ociartifact"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/preflight"
+	"github.com/slok/sbx-images/pkg/signer"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	if len(os.Args) < 2 {
+		return fmt.Errorf("usage: oci <push|pull|containerd> [flags]")
+	}
+
+	switch os.Args[1] {
+	case "push":
+		return push(os.Args[2:])
+	case "pull":
+		return pull(os.Args[2:])
+	case "containerd":
+		return containerd(os.Args[2:])
+	default:
+		return fmt.Errorf("unknown subcommand %q (expected push, pull or containerd)", os.Args[1])
+	}
+}
+
+func push(args []string) error {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	buildDir := fs.String("build-dir", "build", "Path to build output directory")
+	manifestPath := fs.String("manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	repository := fs.String("repository", os.Getenv("SBX_OCI_REPOSITORY"), "Registry repository to push to, e.g. ghcr.io/slok/sbx-images (default: SBX_OCI_REPOSITORY)")
+	tag := fs.String("tag", "", "Release tag (default: manifest version)")
+	insecure := fs.Bool("insecure", false, "Allow plain HTTP registries")
+	dryRun := fs.Bool("dry-run", false, "Print the artifacts without pushing")
+	logFlags := logging.AddFlags(fs)
+	outFlags := output.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	if *repository == "" {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("-repository is required"))
+	}
+	var nameOpts []name.Option
+	if *insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	repo, err := name.NewRepository(*repository, nameOpts...)
+	if err != nil {
+		return exitcode.Wrap(exitcode.Usage, err)
+	}
+	rel, err := planRelease(*buildDir, *manifestPath, *tag)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		slog.Info("Dry run, nothing was pushed")
+	} else {
+		ctx := context.Background()
+		if err := ociartifact.Push(ctx, repo, &rel, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+			return err
+		}
+		slog.Info("Pushed release", "ref", repo.Tag(rel.Tag).String(), "digest", rel.Digest)
+	}
+
+	if outFlags.JSON() {
+		return output.Print(rel)
+	}
+	printRelease(repo.String(), rel)
+	return nil
+}
+
+// planRelease returns the release of the build dir, tagged with the manifest
+// version by default.
+func planRelease(buildDir, manifestPath, tag string) (ociartifact.Release, error) {
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return ociartifact.Release{}, fmt.Errorf("loading manifest: %w", err)
+	}
+	return ociartifact.Plan(cmp.Or(tag, m.Version), m, manifestPath, buildDir)
+}
+
+// printRelease prints the artifacts of rel, named name:<tag>.
+func printRelease(name string, rel ociartifact.Release) {
+	for _, a := range rel.Artifacts {
+		fmt.Printf("%s:%s %s\n", name, a.Tag, a.Digest)
+		for _, f := range a.Files {
+			fmt.Printf("  %-32s %12d  %s\n", f.Name, f.Size, f.MediaType)
+		}
+	}
+	fmt.Printf("%s:%s %s\n", name, rel.Tag, rel.Digest)
+}
+
+func pull(args []string) error {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	ref := fs.String("ref", "", "Release index or artifact reference, e.g. ghcr.io/slok/sbx-images:v0.1.0")
+	arch := fs.String("arch", preflight.HostArch(), "Architecture artifact to pull from a release index")
+	outDir := fs.String("out-dir", "release", "Directory to write the artifact files to")
+	insecure := fs.Bool("insecure", false, "Allow plain HTTP registries")
+	cosignKey := fs.String("cosign-key", "", "Cosign public key to verify the artifact signature with")
+	cosignIdentity := fs.String("cosign-identity", "", "Expected certificate identity of a keyless cosign signature")
+	cosignIssuer := fs.String("cosign-issuer", "", "Expected OIDC issuer of a keyless cosign signature")
+	logFlags := logging.AddFlags(fs)
+	outFlags := output.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	if *ref == "" {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("-ref is required"))
+	}
+	var nameOpts []name.Option
+	if *insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	r, err := name.ParseReference(*ref, nameOpts...)
+	if err != nil {
+		return exitcode.Wrap(exitcode.Usage, err)
+	}
+
+	opts := ociartifact.PullOptions{
+		Arch:   *arch,
+		Remote: []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)},
+	}
+	if *cosignKey != "" || *cosignIdentity != "" {
+		opts.Cosign = &signer.CosignOptions{Key: *cosignKey, Identity: *cosignIdentity, Issuer: *cosignIssuer, Insecure: *insecure}
+	}
+	pulled, err := ociartifact.Pull(context.Background(), r, *outDir, opts)
+	if err != nil {
+		return err
+	}
+	slog.Info("Pulled artifact", "ref", pulled.Ref, "arch", pulled.Arch, "dir", *outDir)
+
+	if outFlags.JSON() {
+		return output.Print(pulled)
+	}
+	for _, f := range pulled.Files {
+		fmt.Printf("%-32s %12d  %s\n", f.Name, f.Size, f.Digest)
+	}
+	return nil
+}
+
+func containerd(args []string) error {
+	fs := flag.NewFlagSet("containerd", flag.ExitOnError)
+	buildDir := fs.String("build-dir", "build", "Path to build output directory")
+	manifestPath := fs.String("manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	tag := fs.String("tag", "", "Release tag (default: manifest version)")
+	address := fs.String("address", "", "containerd socket (default: the ctr default)")
+	namespace := fs.String("namespace", "sbx", "containerd namespace")
+	imageName := fs.String("name", "localhost/sbx-images", "Image name the release tags are appended to")
+	archive := fs.String("archive", "", "Write the OCI archive to this path instead of importing it")
+	logFlags := logging.AddFlags(fs)
+	outFlags := output.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	rel, err := planRelease(*buildDir, *manifestPath, *tag)
+	if err != nil {
+		return err
+	}
+
+	if *archive != "" {
+		f, err := os.Create(*archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := ociartifact.WriteArchive(&rel, f); err != nil {
+			return fmt.Errorf("writing %s: %w", *archive, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		slog.Info("Wrote OCI archive", "path", *archive, "digest", rel.Digest)
+	} else {
+		opts := ociartifact.ContainerdOptions{Address: *address, Namespace: *namespace, Name: *imageName}
+		if err := ociartifact.ImportContainerd(context.Background(), &rel, opts); err != nil {
+			return err
+		}
+		slog.Info("Imported release into containerd", "namespace", *namespace, "image", *imageName+":"+rel.Tag, "digest", rel.Digest)
+	}
+
+	if outFlags.JSON() {
+		return output.Print(rel)
+	}
+	printRelease(*imageName, rel)
+	return nil
+}
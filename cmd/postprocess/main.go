@@ -0,0 +1,171 @@
+// Command postprocess runs the post_process pipelines of config.yaml on the
+// built artifacts.
+//
+// Each artifact kind (kernel, rootfs) has an ordered list of stages, e.g.
+// compress → encrypt → split → sign. The original artifacts are kept and the
+// stages and produced files are recorded under post_process in manifest.json.
+// Each artifact pipeline is attested as the postprocess-<file> step
+// (pkg/attest), with the config files and the artifact as materials and the
+// produced files as products.
+//
+// Usage:
+//
+//	go run ./cmd/postprocess -config config.yaml -build-dir build
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/slok/sbx-images/pkg/attest"
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/postprocess"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath   string
+		buildDir     string
+		manifestPath string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if len(cfg.PostProcess) == 0 {
+		slog.Info("No post-processing configured")
+		return nil
+	}
+
+	pipelines := map[string]postprocess.Pipeline{}
+	for kind, stages := range cfg.PostProcess {
+		var p postprocess.Pipeline
+		for _, st := range stages {
+			stage, err := postprocess.New(st.Stage, st.Options)
+			if err != nil {
+				return fmt.Errorf("post_process.%s: %w", kind, err)
+			}
+			p = append(p, stage)
+		}
+		pipelines[kind] = p
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+
+	ctx := context.Background()
+	for _, arch := range cfg.Architectures {
+		a, ok := m.Artifacts[arch]
+		if !ok {
+			return fmt.Errorf("no %s artifacts in manifest", arch)
+		}
+
+		if p := pipelines["kernel"]; len(p) > 0 {
+			for _, k := range a.Kernels() {
+				if k.PostProcess, err = runPipeline(ctx, p, cfg, "kernel", buildDir, k.File); err != nil {
+					return fmt.Errorf("kernel artifact %s: %w", k.File, err)
+				}
+				m.Build.Attestations = append(m.Build.Attestations, "postprocess-"+k.File+attest.FileSuffix)
+			}
+		}
+		if p := pipelines["rootfs"]; len(p) > 0 {
+			for _, r := range a.Rootfses() {
+				if r.PostProcess, err = runPipeline(ctx, p, cfg, "rootfs", buildDir, r.File); err != nil {
+					return fmt.Errorf("rootfs artifact %s: %w", r.File, err)
+				}
+				m.Build.Attestations = append(m.Build.Attestations, "postprocess-"+r.File+attest.FileSuffix)
+			}
+		}
+		m.Artifacts[arch] = a
+	}
+
+	slices.Sort(m.Build.Attestations)
+	m.Build.Attestations = slices.Compact(m.Build.Attestations)
+
+	if err := manifest.Write(manifestPath, m); err != nil {
+		return err
+	}
+	slog.Info("Wrote manifest", "path", manifestPath)
+	return nil
+}
+
+func runPipeline(ctx context.Context, p postprocess.Pipeline, cfg config.Config, kind, buildDir, file string) (*manifest.PostProcess, error) {
+	path := filepath.Join(buildDir, file)
+	var out []postprocess.Output
+	st, err := attest.Func(attest.Step{
+		Name:      "postprocess-" + file,
+		Command:   append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...),
+		Materials: append(slices.Clone(cfg.Files), path),
+	}, func() ([]string, error) {
+		var err error
+		if out, err = p.Run(ctx, path); err != nil {
+			return nil, err
+		}
+		var products []string
+		for _, o := range out {
+			products = append(products, o.Path)
+			if o.Signature != "" {
+				products = append(products, o.Signature)
+			}
+		}
+		return products, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	attestation, err := attest.Write(buildDir, st)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Wrote attestation", "path", attestation)
+
+	pp := &manifest.PostProcess{}
+	for _, st := range cfg.PostProcess[kind] {
+		pp.Stages = append(pp.Stages, manifest.PostProcessStage{Stage: st.Stage, Options: st.Options})
+	}
+	for _, o := range out {
+		size, sum, err := digest.File(o.Path)
+		if err != nil {
+			return nil, err
+		}
+		f := manifest.ProcessedFile{File: filepath.Base(o.Path), SizeBytes: size, SHA256: sum}
+		if o.Signature != "" {
+			f.Signature = filepath.Base(o.Signature)
+		}
+		pp.Files = append(pp.Files, f)
+		slog.Info("Wrote post-processed file", "path", o.Path)
+	}
+	return pp, nil
+}
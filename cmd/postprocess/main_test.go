@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/attest"
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/postprocess"
+)
+
+func TestRunPipelineAttests(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte("architectures: [x86_64]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "vmlinux-x86_64"), []byte("kernel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stages := []config.PostProcessStage{{Stage: "compress", Options: map[string]string{"format": "gzip"}}}
+	stage, err := postprocess.New("compress", stages[0].Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{Files: []string{configPath}, PostProcess: map[string][]config.PostProcessStage{"kernel": stages}}
+
+	pp, err := runPipeline(context.Background(), postprocess.Pipeline{stage}, cfg, "kernel", dir, "vmlinux-x86_64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pp.Files) != 1 || pp.Files[0].File != "vmlinux-x86_64.gz" {
+		t.Fatalf("post-processed files = %+v, want vmlinux-x86_64.gz", pp.Files)
+	}
+
+	st, err := attest.Read(filepath.Join(dir, "postprocess-vmlinux-x86_64"+attest.FileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Predicate.Materials) != 2 || st.Predicate.Materials[1].URI != filepath.ToSlash(filepath.Join(dir, "vmlinux-x86_64")) {
+		t.Errorf("materials = %+v, want the config and the kernel", st.Predicate.Materials)
+	}
+	if len(st.Subject) != 1 || st.Subject[0].Digest["sha256"] != pp.Files[0].SHA256 {
+		t.Errorf("subjects = %+v, want the compressed kernel with digest %s", st.Subject, pp.Files[0].SHA256)
+	}
+	if err := attest.Verify([]attest.Statement{st}, "", nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,318 @@
+// Command provision derives a customized rootfs image from a released one,
+// with a derived manifest.json referencing the upstream release.
+//
+// The -arch ext4 image of the rootfs -profile (default: the release default
+// profile) is read from -release-dir, checked against the release
+// manifest.json digest, and provisioned by provision-rootfs.sh: the
+// -files-dirs copied over it, the -packages installed with the image
+// package manager in a chroot and the -ssh-key authorized keys given to
+// their users. Non-root users run the script as root of a user namespace,
+// with their /etc/subuid and /etc/subgid ranges mapped. The image is
+// rewritten -size-mib large (default: the upstream size plus 35%, at least
+// 256 MiB more, shrink it with cmd/shrink) to
+// <out-dir>/rootfs-<name>-<arch>.ext4.
+//
+// The derived <out-dir>/manifest.json is the upstream one for -arch, its
+// rootfs replaced by the derived image (profile <name>, extending the
+// upstream profile, with its package inventory) and the upstream release,
+// manifest digest, image and customizations recorded under "upstream". The
+// kernel and other artifacts are the upstream files. The derived image has
+// no signature, provenance, SBOM or other filesystem images.
+//
+// Usage:
+//
+//	go run ./cmd/provision -release-dir releases/v0.1.0 -arch x86_64 -name ci -packages git,make -ssh-key root=$HOME/.ssh/id_ed25519.pub
+//	sudo go run ./cmd/provision -release-dir releases/v0.1.0 -arch aarch64 -profile dev -name app -files-dirs app/files -size-mib 2048
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/sbom"
+	"github.com/slok/sbx-images/pkg/sparse"
+)
+
+// Size of the derived image without -size-mib, as build-rootfs.sh sizes
+// the images it builds.
+const (
+	overheadPercent = 35
+	minOverheadMiB  = 256
+)
+
+var nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		releaseDir string
+		arch       string
+		profile    string
+		name       string
+		version    string
+		packages   string
+		filesDirs  string
+		sizeMiB    int64
+		outDir     string
+		script     string
+		sshKeys    = map[string][]string{}
+	)
+
+	flag.StringVar(&releaseDir, "release-dir", ".", "Directory with the upstream manifest.json and rootfs image")
+	flag.StringVar(&arch, "arch", "", "Architecture of the image (required)")
+	flag.StringVar(&profile, "profile", "", "Upstream rootfs profile (default: the release default profile)")
+	flag.StringVar(&name, "name", "", "Name of the derived image, rootfs-<name>-<arch>.ext4 (required)")
+	flag.StringVar(&version, "version", "", "Derived manifest version (default: <upstream version>-<name>)")
+	flag.StringVar(&packages, "packages", "", "Comma separated packages to install")
+	flag.StringVar(&filesDirs, "files-dirs", "", "Comma separated directories copied over the image, in order")
+	flag.Func("ssh-key", "user=file SSH public keys or authorized_keys file given to an image user (repeatable)", func(v string) error {
+		user, file, ok := strings.Cut(v, "=")
+		if !ok || user == "" || file == "" {
+			return fmt.Errorf("want user=file")
+		}
+		sshKeys[user] = append(sshKeys[user], file)
+		return nil
+	})
+	flag.Int64Var(&sizeMiB, "size-mib", 0, "Size of the derived image (default: the upstream size plus 35%, at least 256 MiB more)")
+	flag.StringVar(&outDir, "out-dir", "build", "Directory to write the derived image and manifest.json to")
+	flag.StringVar(&script, "script", "scripts/provision-rootfs.sh", "Path to provision-rootfs.sh")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if arch == "" || name == "" {
+		return fmt.Errorf("-arch and -name are required")
+	}
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid -name %q", name)
+	}
+	if packages == "" && filesDirs == "" && len(sshKeys) == 0 {
+		return fmt.Errorf("nothing to provision: set -packages, -files-dirs or -ssh-key")
+	}
+
+	manifestPath := filepath.Join(releaseDir, "manifest.json")
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+	_, manifestDigest, err := digest.File(manifestPath)
+	if err != nil {
+		return err
+	}
+	a, ok := m.Artifacts[arch]
+	if !ok {
+		return fmt.Errorf("release %s has no %s artifacts", m.Version, arch)
+	}
+	upstream := a.Rootfs
+	if profile != "" && profile != upstream.Profile {
+		if upstream, ok = a.RootfsProfile(profile); !ok {
+			return fmt.Errorf("release %s has no %s rootfs profile for %s", m.Version, profile, arch)
+		}
+	}
+	if upstream.Definition != nil && upstream.Definition.Nix != nil {
+		return fmt.Errorf("rootfs profile %s is built by Nix, without a package manager to provision it with", upstream.Profile)
+	}
+
+	image := filepath.Join(releaseDir, upstream.File)
+	size, sum, err := digest.File(image)
+	if err != nil {
+		return err
+	}
+	if sum != upstream.SHA256 {
+		return fmt.Errorf("%s: sha256 %s does not match the manifest %s", upstream.File, sum, upstream.SHA256)
+	}
+	if sizeMiB == 0 {
+		sizeMiB = (size>>20)*(100+overheadPercent)/100 + 1
+		sizeMiB = max(sizeMiB, (size>>20)+minOverheadMiB)
+	}
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return err
+	}
+	work, err := os.MkdirTemp("", "sbx-provision-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	users := slices.Sorted(maps.Keys(sshKeys))
+	if len(users) > 0 {
+		if err := stageKeys(filepath.Join(work, "keys"), sshKeys); err != nil {
+			return err
+		}
+	}
+
+	// Manifests predating Debian support have no distro.
+	d, err := distro.Get(cmp.Or(upstream.Distro, "alpine"))
+	if err != nil {
+		return err
+	}
+	stem := name + "-" + arch
+	out := filepath.Join(outDir, manifest.RootfsImageFile(manifest.RootfsFilesystemExt4, stem))
+	args := []string{
+		"--image", image,
+		"--output", out,
+		"--distro", d.Name(),
+		"--size-mib", fmt.Sprint(sizeMiB),
+		"--ext4-options", upstream.MkfsOptions,
+	}
+	if packages != "" {
+		args = append(args, "--packages", packages)
+	}
+	if filesDirs != "" {
+		args = append(args, "--files-dirs", filesDirs)
+	}
+	if len(users) > 0 {
+		args = append(args, "--keys-dir", filepath.Join(work, "keys"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := runAsRoot(ctx, script, args...); err != nil {
+		return fmt.Errorf("provisioning %s: %w", upstream.File, err)
+	}
+
+	pkgdb := filepath.Join(outDir, distro.PackageDB(d, stem))
+	r, err := derivedRootfs(upstream, name, out, pkgdb, splitList(packages), splitList(filesDirs))
+	if err != nil {
+		return err
+	}
+	derived := m
+	derived.Version = cmp.Or(version, m.Version+"-"+name)
+	a.Rootfs, a.RootfsProfiles = r, nil
+	derived.Artifacts = map[string]manifest.ArchArtifacts{arch: a}
+	derived.Disks = nil
+	derived.Signing = nil
+	derived.Build = manifest.Build{Date: time.Now().UTC().Format(time.RFC3339)}
+	derived.Upstream = &manifest.Upstream{
+		Version:        m.Version,
+		ManifestSHA256: manifestDigest,
+		Rootfs:         upstream.File,
+		SHA256:         upstream.SHA256,
+		FilesDirs:      splitList(filesDirs),
+		Packages:       splitList(packages),
+		SSHUsers:       users,
+	}
+	manifestOut := filepath.Join(outDir, "manifest.json")
+	if err := manifest.Write(manifestOut, derived); err != nil {
+		return err
+	}
+
+	slog.Info("Provisioned image", "path", out, "release", m.Version, "from", upstream.File, "size_mib", r.SizeBytes>>20, "disk_usage_mib", r.DiskUsageBytes>>20)
+	slog.Info("Wrote manifest", "path", manifestOut)
+	return nil
+}
+
+// derivedRootfs returns the rootfs artifact of the derived image at path,
+// provisioned from upstream, with the package inventory of the pkgdb
+// package database exported by provision-rootfs.sh.
+func derivedRootfs(upstream manifest.RootfsArtifact, name, path, pkgdb string, packages, filesDirs []string) (manifest.RootfsArtifact, error) {
+	r := manifest.RootfsArtifact{
+		File:          filepath.Base(path),
+		Filesystem:    manifest.RootfsFilesystemExt4,
+		MkfsOptions:   upstream.MkfsOptions,
+		Distro:        upstream.Distro,
+		DistroVersion: upstream.DistroVersion,
+		Bootstrap:     upstream.Bootstrap,
+		SourceImage:   upstream.SourceImage,
+		Profile:       name,
+		BootArgs:      upstream.BootArgs,
+		Firstboot:     upstream.Firstboot,
+	}
+	if upstream.Definition != nil {
+		def := *upstream.Definition
+		def.Extends = append([]string{upstream.Profile}, def.Extends...)
+		def.Packages = append(slices.Clone(def.Packages), packages...)
+		def.FilesDirs = append(slices.Clone(def.FilesDirs), filesDirs...)
+		def.Filesystems, def.Overlay, def.Verity = nil, false, false
+		r.Definition = &def
+	}
+
+	var err error
+	if r.SizeBytes, r.SHA256, err = digest.File(path); err != nil {
+		return r, err
+	}
+	if r.DiskUsageBytes, err = sparse.DiskUsage(path); err != nil {
+		return r, err
+	}
+
+	pkgs, err := sbom.ReadInstalled(cmp.Or(r.Distro, "alpine"), pkgdb)
+	if err != nil {
+		return r, fmt.Errorf("package inventory of %s: %w", r.File, err)
+	}
+	h := sha256.New()
+	for _, p := range pkgs {
+		r.Packages = append(r.Packages, manifest.Package{Name: p.Name, Version: p.Version, License: p.License})
+		fmt.Fprintf(h, "%s %s\n", p.Name, p.Version)
+	}
+	r.PackagesSHA256 = hex.EncodeToString(h.Sum(nil))
+	r.Licenses = sbom.LicenseSummary(pkgs)
+	return r, nil
+}
+
+// stageKeys writes the authorized_keys file of each user to dir/<user>, for
+// provision-rootfs.sh --keys-dir.
+func stageKeys(dir string, keys map[string][]string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for user, files := range keys {
+		var b strings.Builder
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return fmt.Errorf("ssh keys of user %s: %w", user, err)
+			}
+			for line := range strings.SplitSeq(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					b.WriteString(line + "\n")
+				}
+			}
+		}
+		if b.Len() == 0 {
+			return fmt.Errorf("ssh keys of user %s: no keys in %s", user, strings.Join(files, ", "))
+		}
+		if err := os.WriteFile(filepath.Join(dir, user), []byte(b.String()), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated flag value.
+func splitList(s string) []string {
+	var list []string
+	for v := range strings.SplitSeq(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
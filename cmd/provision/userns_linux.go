@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runAsRoot runs a tool as root in its own mount and PID namespaces. Other
+// users run it as root of a new user namespace, their /etc/subuid and
+// /etc/subgid ranges mapped to the other IDs (newuidmap, newgidmap) so the
+// package managers can chown files, only their own ID mapped without them
+// (or the shadow ID mapping tools).
+func runAsRoot(ctx context.Context, name string, args ...string) error {
+	unshare := append([]string{"--mount", "--pid", "--fork", "--", name}, args...)
+	if os.Geteuid() == 0 {
+		cmd := exec.CommandContext(ctx, "unshare", unshare...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	uids, err := subIDs("/etc/subuid", u.Username, u.Uid)
+	if err != nil {
+		return err
+	}
+	gids, err := subIDs("/etc/subgid", u.Username, u.Uid)
+	if err != nil {
+		return err
+	}
+
+	// The tool waits on fd 3 for the ID mappings.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	cmd := exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", `read -r _ <&3 && exec 3<&- && exec unshare "$@"`, "sh"}, unshare...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+	_, uidErr := exec.LookPath("newuidmap")
+	_, gidErr := exec.LookPath("newgidmap")
+	if uids == nil || gids == nil || uidErr != nil || gidErr != nil {
+		slog.Warn("No /etc/subuid and /etc/subgid ranges or no newuidmap and newgidmap, only mapping the user to root: installing packages owning files by other users fails", "user", u.Username)
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+		uids = nil
+	}
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		return err
+	}
+	r.Close()
+
+	if uids != nil {
+		pid := strconv.Itoa(cmd.Process.Pid)
+		for _, m := range []struct {
+			tool string
+			id   int
+			sub  []string
+		}{{"newuidmap", os.Geteuid(), uids}, {"newgidmap", os.Getegid(), gids}} {
+			out, err := exec.CommandContext(ctx, m.tool, append([]string{pid, "0", strconv.Itoa(m.id), "1", "1"}, m.sub...)...).CombinedOutput()
+			if err != nil {
+				_ = cmd.Process.Kill()
+				_ = cmd.Wait()
+				return fmt.Errorf("%s: %w: %s", m.tool, err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	if _, err := w.WriteString("\n"); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// subIDs returns the start and count of the first subordinate ID range of
+// the user name or uid in file, nil without one.
+func subIDs(file, name, uid string) ([]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(strings.TrimSpace(s.Text()), ":")
+		if len(fields) == 3 && (fields[0] == name || fields[0] == uid) {
+			return fields[1:], nil
+		}
+	}
+	return nil, s.Err()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// runAsRoot needs Linux namespaces.
+func runAsRoot(context.Context, string, ...string) error {
+	return fmt.Errorf("provisioning images needs Linux")
+}
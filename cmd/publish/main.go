@@ -0,0 +1,262 @@
+// Command publish uploads a release to its distribution backends.
+//
+// Before uploading it checks the backend credentials and permissions, that the
+// release doesn't already exist, and verifies every artifact digest and
+// signature locally. With -dry-run it stops there and prints the exact assets
+// and URLs that would be created.
+//
+// With -o json the release plan, with whether each backend published it, is
+// printed to stdout as a JSON document instead of the text plan.
+//
+// Usage:
+//
+//	go run ./cmd/publish -build-dir build -repo slok/sbx-images -dry-run
+//	go run ./cmd/publish -build-dir build -repo slok/sbx-images
+//	go run ./cmd/publish -build-dir build -repo slok/sbx-images -dry-run -o json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/publish"
+	"github.com/slok/sbx-images/pkg/signer"
+	"github.com/slok/sbx-images/pkg/verify"
+)
+
+// Result is the JSON output of publish.
+type Result struct {
+	Tag      string          `json:"tag"`
+	DryRun   bool            `json:"dry_run"`
+	Releases []BackendResult `json:"releases"`
+}
+
+// BackendResult is the release on a backend.
+type BackendResult struct {
+	Backend   string        `json:"backend"`
+	Assets    []AssetResult `json:"assets"`
+	Published bool          `json:"published"`
+}
+
+// AssetResult is a release asset.
+type AssetResult struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		buildDir     string
+		manifestPath string
+		tag          string
+		backendList  string
+		repo         string
+		publicKey    string
+		dryRun       bool
+	)
+
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	flag.StringVar(&tag, "tag", "", "Release tag (default: manifest version)")
+	flag.StringVar(&backendList, "backends", "github", "Comma separated publish backends ("+strings.Join(publish.Backends, ", ")+")")
+	flag.StringVar(&repo, "repo", os.Getenv("GITHUB_REPOSITORY"), "GitHub repository (owner/name)")
+	flag.StringVar(&publicKey, "public-key", "", "Minisign public key path, to verify minisign signatures")
+	flag.BoolVar(&dryRun, "dry-run", false, "Run every check and print the release plan without uploading")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+	if tag == "" {
+		tag = m.Version
+	}
+	if tag != m.Version {
+		return fmt.Errorf("tag %s does not match manifest version %s", tag, m.Version)
+	}
+
+	token := os.Getenv("GH_TOKEN")
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+
+	var backends []publish.Backend
+	for name := range strings.SplitSeq(backendList, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		b, err := publish.New(name, publish.Options{
+			Repo:   repo,
+			Token:  token,
+			APIURL: os.Getenv("GITHUB_API_URL"),
+		})
+		if err != nil {
+			return err
+		}
+		backends = append(backends, b)
+	}
+	if len(backends) == 0 {
+		return fmt.Errorf("no publish backend selected")
+	}
+
+	rel, err := publish.ReleaseFiles(tag, m, manifestPath, buildDir)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, b := range backends {
+		if err := b.Check(ctx, rel); err != nil {
+			return fmt.Errorf("%s backend: %w", b.Name(), err)
+		}
+		slog.Info("Checked backend credentials and permissions", "backend", b.Name())
+	}
+
+	if err := verifyRelease(ctx, m, manifestPath, buildDir, publicKey); err != nil {
+		return err
+	}
+
+	result := Result{Tag: tag, DryRun: dryRun}
+	for _, b := range backends {
+		br := BackendResult{Backend: b.Name(), Assets: []AssetResult{}}
+		for _, a := range rel.Assets {
+			br.Assets = append(br.Assets, AssetResult{Name: a.Name, Size: a.Size, URL: b.URL(rel, a)})
+		}
+		result.Releases = append(result.Releases, br)
+	}
+	if !outFlags.JSON() {
+		for _, br := range result.Releases {
+			fmt.Printf("Release %s on %s:\n", tag, br.Backend)
+			for _, a := range br.Assets {
+				fmt.Printf("  %-32s %12d  %s\n", a.Name, a.Size, a.URL)
+			}
+		}
+	}
+
+	if dryRun {
+		slog.Info("Dry run, nothing was uploaded")
+		return printResult(outFlags, result)
+	}
+
+	for i, b := range backends {
+		if err := b.Publish(ctx, rel); err != nil {
+			// The releases already published are still reported.
+			if perr := printResult(outFlags, result); perr != nil {
+				return perr
+			}
+			return fmt.Errorf("%s backend: %w", b.Name(), err)
+		}
+		result.Releases[i].Published = true
+		slog.Info("Published release", "tag", tag, "backend", b.Name())
+	}
+	return printResult(outFlags, result)
+}
+
+// printResult prints the JSON result when requested.
+func printResult(f *output.Flags, r Result) error {
+	if !f.JSON() {
+		return nil
+	}
+	return output.Print(r)
+}
+
+// verifyRelease checks the artifact digests and, for signed releases, the
+// artifact and manifest signatures.
+func verifyRelease(ctx context.Context, m manifest.Manifest, manifestPath, buildDir, publicKey string) error {
+	archs := make([]string, 0, len(m.Artifacts))
+	for arch := range m.Artifacts {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	opts := verify.Options{Cache: verify.NewCache(buildDir)}
+	var signed [][2]string
+	for _, arch := range archs {
+		a := m.Artifacts[arch]
+		if _, err := verify.File(filepath.Join(buildDir, a.Kernel.File), a.Kernel.SHA256, opts); err != nil {
+			return fmt.Errorf("kernel artifact for %s: %w", arch, err)
+		}
+		signed = append(signed, [2]string{a.Kernel.File, a.Kernel.Signature})
+		for _, r := range a.Rootfses() {
+			if _, err := verify.File(filepath.Join(buildDir, r.File), r.SHA256, opts); err != nil {
+				return fmt.Errorf("rootfs artifact %s: %w", r.File, err)
+			}
+			signed = append(signed, [2]string{r.File, r.Signature})
+			for _, img := range r.ExtraImages() {
+				if _, err := verify.File(filepath.Join(buildDir, img.File), img.SHA256, opts); err != nil {
+					return fmt.Errorf("rootfs artifact %s: %w", img.File, err)
+				}
+				signed = append(signed, [2]string{img.File, img.Signature})
+			}
+		}
+		if a.Initramfs != nil {
+			if _, err := verify.File(filepath.Join(buildDir, a.Initramfs.File), a.Initramfs.SHA256, opts); err != nil {
+				return fmt.Errorf("initramfs artifact for %s: %w", arch, err)
+			}
+			signed = append(signed, [2]string{a.Initramfs.File, a.Initramfs.Signature})
+		}
+		for _, b := range a.Firecracker.Binaries() {
+			if _, err := verify.File(filepath.Join(buildDir, b.File), b.SHA256, opts); err != nil {
+				return fmt.Errorf("firecracker binary %s: %w", b.File, err)
+			}
+			signed = append(signed, [2]string{b.File, b.Signature})
+		}
+	}
+	for _, d := range m.Disks {
+		if _, err := verify.File(filepath.Join(buildDir, d.File), d.SHA256, opts); err != nil {
+			return fmt.Errorf("data disk %s: %w", d.File, err)
+		}
+		signed = append(signed, [2]string{d.File, d.Signature})
+	}
+	slog.Info("Verified artifact digests")
+
+	if m.Signing == nil {
+		slog.Warn("Release is not signed")
+		return nil
+	}
+
+	vopts := signer.VerifyOptions{Fingerprint: m.Signing.KeyFingerprint, PublicKey: publicKey}
+	for _, s := range signed {
+		if s[1] == "" {
+			return fmt.Errorf("%s has no signature in a signed release", s[0])
+		}
+		if err := signer.Verify(ctx, m.Signing.Backend, vopts, filepath.Join(buildDir, s[0]), filepath.Join(buildDir, s[1])); err != nil {
+			return exitcode.Wrap(exitcode.DigestMismatch, fmt.Errorf("verifying signature of %s: %w", s[0], err))
+		}
+	}
+	if err := signer.Verify(ctx, m.Signing.Backend, vopts, manifestPath, signer.SignatureFile(m.Signing.Backend, manifestPath)); err != nil {
+		return exitcode.Wrap(exitcode.DigestMismatch, fmt.Errorf("verifying manifest signature: %w", err))
+	}
+	slog.Info("Verified signatures", "backend", m.Signing.Backend, "key", m.Signing.KeyFingerprint)
+	return nil
+}
@@ -0,0 +1,123 @@
+// Command qemu-microvm boots a release kernel and rootfs image under QEMU's
+// microvm machine type, from the QEMU microvm boot metadata of the manifest
+// (config qemu.microvm), to test the images without Firecracker: under KVM
+// when available, emulated otherwise.
+//
+// The guest console is attached to the terminal (Ctrl-A X quits QEMU) and
+// the rootfs writes go to a temporary snapshot, the image is left
+// untouched. The kernel flavor and
+// rootfs profile are the defaults of the manifest unless -flavor and
+// -profile select others, -filesystem selects another rootfs image (e.g.
+// squashfs). With -dry-run the QEMU command line is printed instead.
+//
+// Usage:
+//
+//	go run ./cmd/qemu-microvm -build-dir build
+//	go run ./cmd/qemu-microvm -build-dir release -profile minimal -filesystem squashfs
+//	go run ./cmd/qemu-microvm -build-dir release -dry-run
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/export"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		buildDir     string
+		manifestPath string
+		flavor       string
+		profile      string
+		filesystem   string
+		dryRun       bool
+	)
+
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to the build (or release) directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	flag.StringVar(&flavor, "flavor", "", "Kernel flavor to boot (default: the default kernel)")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile to boot (default: the default rootfs)")
+	flag.StringVar(&filesystem, "filesystem", manifest.RootfsFilesystemExt4, "Rootfs image filesystem to boot")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the QEMU command line instead of running it")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+	// The machine type is x86_64 only.
+	src, err := export.Select(buildDir, m, "x86_64", flavor, profile)
+	if err != nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, err)
+	}
+	q := m.Artifacts[src.Arch].QEMUMicrovm
+	if q == nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, errors.New("no QEMU microvm boot metadata in manifest, enable qemu.microvm in config.yaml"))
+	}
+	if hv := src.Kernel.TargetHypervisor(); hv != manifest.HypervisorFirecracker {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("kernel flavor %s is built for %s, QEMU microvm boots the %s kernels", cmp.Or(src.Kernel.Flavor, manifest.DefaultFlavor), hv, manifest.HypervisorFirecracker))
+	}
+	kernel, err := vmconfig.SelectKernel(src.Kernel, src.Arch, []string{q.KernelFormat})
+	if err != nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, err)
+	}
+	img, ok := src.Rootfs.Image(filesystem)
+	if !ok {
+		return exitcode.Wrap(exitcode.MissingArtifact, fmt.Errorf("rootfs %s has no %s image", src.Rootfs.Profile, filesystem))
+	}
+
+	cmdline := src.Rootfs.BootArgsFor(img.BootArgs, manifest.HypervisorQEMUMicrovm)
+	args := q.Command(src.Path(kernel.File), src.Path(img.File), cmdline)
+	if dryRun {
+		quoted := make([]string, len(args))
+		for i, a := range args {
+			quoted[i] = shellQuote(a)
+		}
+		fmt.Println(strings.Join(quoted, " "))
+		return nil
+	}
+
+	slog.Info("Booting under QEMU microvm", "kernel", kernel.File, "rootfs", img.File, "cmdline", cmdline)
+	cmd := exec.CommandContext(context.Background(), args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell when it holds other characters than
+// the safe ones.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=,./:") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,203 @@
+// Command report builds reports across releases.
+//
+// The trends subcommand aggregates the manifests and boot-matrix reports of
+// every release into a time series of artifact sizes and boot times per
+// architecture and profile, to spot gradual regressions.
+//
+// Releases are read either from a directory holding one subdirectory per
+// release (each with manifest.json and optional boot-matrix-<arch>.json), or
+// from the GitHub releases of a repository.
+//
+// Usage:
+//
+//	go run ./cmd/report trends -releases-dir releases -format csv
+//	go run ./cmd/report trends -repo slok/sbx-images -format json -output trends.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/releases"
+	"github.com/slok/sbx-images/pkg/report"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	if len(os.Args) < 2 {
+		return fmt.Errorf("usage: report <trends> [flags]")
+	}
+
+	switch os.Args[1] {
+	case "trends":
+		return trends(os.Args[2:])
+	default:
+		return fmt.Errorf("unknown subcommand %q (supported: trends)", os.Args[1])
+	}
+}
+
+func trends(args []string) error {
+	fs := flag.NewFlagSet("trends", flag.ExitOnError)
+	releasesDir := fs.String("releases-dir", "", "Directory with one subdirectory per release")
+	repo := fs.String("repo", "", "GitHub repository (owner/name) to read the releases from")
+	format := fs.String("format", "csv", "Output format (csv, json)")
+	output := fs.String("output", "", "Output file path (default: stdout)")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if (*releasesDir == "") == (*repo == "") {
+		return fmt.Errorf("exactly one of -releases-dir or -repo is required")
+	}
+
+	var write func(io.Writer, []report.Point) error
+	switch *format {
+	case "csv":
+		write = report.WriteCSV
+	case "json":
+		write = report.WriteJSON
+	default:
+		return fmt.Errorf("unknown format %q (supported: csv, json)", *format)
+	}
+
+	var (
+		releases []report.Release
+		err      error
+	)
+	if *releasesDir != "" {
+		releases, err = readReleasesDir(*releasesDir)
+	} else {
+		releases, err = readGitHubReleases(context.Background(), *repo)
+	}
+	if err != nil {
+		return err
+	}
+
+	points, err := report.Trends(releases)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(w, points); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	if *output != "" {
+		slog.Info("Wrote trends report", "path", *output, "releases", len(releases), "points", len(points))
+	}
+	return nil
+}
+
+// readReleasesDir reads every <dir>/<release>/manifest.json with its boot
+// reports.
+func readReleasesDir(dir string) ([]report.Release, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "*", "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	releases := make([]report.Release, 0, len(manifests))
+	for _, mp := range manifests {
+		m, err := manifest.Read(mp)
+		if err != nil {
+			return nil, err
+		}
+		rel := report.Release{Manifest: m}
+
+		boots, err := filepath.Glob(filepath.Join(filepath.Dir(mp), "boot-matrix-*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, bp := range boots {
+			data, err := os.ReadFile(bp)
+			if err != nil {
+				return nil, err
+			}
+			var br report.BootReport
+			if err := json.Unmarshal(data, &br); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", bp, err)
+			}
+			rel.Boots = append(rel.Boots, br)
+		}
+		releases = append(releases, rel)
+	}
+	return releases, nil
+}
+
+// readGitHubReleases downloads the manifest and boot reports of every
+// published release of repo.
+func readGitHubReleases(ctx context.Context, repo string) ([]report.Release, error) {
+	client := releases.Client{APIURL: os.Getenv("GITHUB_API_URL"), Token: githubToken()}
+	rels, err := client.List(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []report.Release
+	for _, gr := range rels {
+		var (
+			rel         report.Release
+			hasManifest bool
+		)
+		for _, a := range gr.Assets {
+			switch {
+			case a.Name == "manifest.json":
+				if err := client.Fetch(ctx, a, &rel.Manifest); err != nil {
+					return nil, fmt.Errorf("release %s: %w", gr.Tag, err)
+				}
+				hasManifest = true
+			case matchBootReport(a.Name):
+				var br report.BootReport
+				if err := client.Fetch(ctx, a, &br); err != nil {
+					return nil, fmt.Errorf("release %s: %w", gr.Tag, err)
+				}
+				rel.Boots = append(rel.Boots, br)
+			}
+		}
+		if hasManifest {
+			out = append(out, rel)
+		}
+	}
+	return out, nil
+}
+
+func matchBootReport(name string) bool {
+	ok, _ := path.Match("boot-matrix-*.json", name)
+	return ok
+}
+
+func githubToken() string {
+	if t := os.Getenv("GH_TOKEN"); t != "" {
+		return t
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
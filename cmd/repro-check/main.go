@@ -0,0 +1,223 @@
+// Command repro-check rebuilds a release from its recorded inputs and
+// compares the artifact digests with the published manifest.
+//
+// It checks out the commit recorded in manifest.json in a temporary git
+// worktree, verifies config.yaml matches the recorded digest, and runs the
+// build with the recorded SOURCE_DATE_EPOCH and config overrides. Every
+// artifact is then reported as reproduced bit-for-bit or diverged, with the
+// likely causes (superblock UUIDs and timestamps, kernel banner, toolchain
+// versions) when the published files are available next to the manifest.
+//
+// The rootfs build needs root, so run the rebuild with sudo or compare a
+// rebuild made separately with -rebuilt-dir.
+//
+// With -o json the report written by -output is printed to stdout instead
+// of the text summary.
+//
+// Usage:
+//
+//	sudo go run ./cmd/repro-check -manifest releases/v0.1.0/manifest.json
+//	go run ./cmd/repro-check -manifest releases/v0.1.0/manifest.json -rebuilt-dir /tmp/rebuild/build -output repro.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/repro"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		manifestPath string
+		publishedDir string
+		rebuiltDir   string
+		workDir      string
+		targets      string
+		reportPath   string
+		keep         bool
+	)
+
+	flag.StringVar(&manifestPath, "manifest", "", "Path to the published manifest.json")
+	flag.StringVar(&publishedDir, "published-dir", "", "Directory with the published artifacts, for diagnosis (default: the manifest directory)")
+	flag.StringVar(&rebuiltDir, "rebuilt-dir", "", "Compare an existing rebuild's build dir instead of rebuilding")
+	flag.StringVar(&workDir, "work-dir", "", "Directory for the rebuild worktree (default: a temporary directory)")
+	flag.StringVar(&targets, "targets", "build manifest", "Make targets run for the rebuild")
+	flag.StringVar(&reportPath, "output", "", "Path to write the JSON report to")
+	flag.BoolVar(&keep, "keep", false, "Keep the rebuild worktree")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	if manifestPath == "" {
+		return fmt.Errorf("-manifest is required")
+	}
+	if publishedDir == "" {
+		publishedDir = filepath.Dir(manifestPath)
+	}
+
+	published, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+
+	if rebuiltDir == "" {
+		ctx := context.Background()
+		dir, cleanup, err := rebuild(ctx, published, workDir, strings.Fields(targets))
+		if cleanup != nil && !keep {
+			defer cleanup()
+		}
+		if err != nil {
+			return err
+		}
+		if keep {
+			slog.Info("Kept rebuild worktree", "path", filepath.Dir(dir))
+		}
+		rebuiltDir = dir
+	}
+
+	rebuilt, err := manifest.Read(filepath.Join(rebuiltDir, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("loading rebuilt manifest: %w", err)
+	}
+
+	r := repro.Compare(published, rebuilt, publishedDir, rebuiltDir)
+	if outFlags.JSON() {
+		if err := output.Print(r); err != nil {
+			return err
+		}
+	} else {
+		for _, res := range r.Results {
+			if res.Reproduced {
+				fmt.Printf("REPRODUCED  %s\n", res.Artifact)
+				continue
+			}
+			fmt.Printf("DIVERGED    %s: %s\n", res.Artifact, strings.Join(res.Reasons, ", "))
+		}
+		for _, t := range r.Toolchain {
+			fmt.Printf("Toolchain differs: %s\n", t)
+		}
+	}
+
+	if reportPath != "" {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling report: %w", err)
+		}
+		if err := os.WriteFile(reportPath, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+		slog.Info("Wrote reproducibility report", "path", reportPath)
+	}
+
+	if n := r.Diverged(); n > 0 {
+		return exitcode.Wrap(exitcode.DigestMismatch, fmt.Errorf("%d of %d artifacts diverge from release %s", n, len(r.Results), r.Version))
+	}
+	slog.Info("All artifacts reproduce bit-for-bit", "artifacts", len(r.Results), "release", r.Version)
+	return nil
+}
+
+// rebuild checks out the release commit in a worktree under workDir and runs
+// the make targets with the recorded inputs. It returns the rebuild's build
+// dir and a cleanup removing the worktree.
+func rebuild(ctx context.Context, m manifest.Manifest, workDir string, targets []string) (string, func(), error) {
+	commit := m.Build.Commit
+	if commit == "" || commit == "unknown" {
+		return "", nil, fmt.Errorf("manifest has no recorded build commit")
+	}
+
+	var tmp string
+	if workDir == "" {
+		var err error
+		tmp, err = os.MkdirTemp("", "sbx-repro-")
+		if err != nil {
+			return "", nil, err
+		}
+		workDir = filepath.Join(tmp, "src")
+	}
+
+	if err := runCmd(ctx, "", nil, "git", "worktree", "add", "--detach", workDir, commit); err != nil {
+		if tmp != "" {
+			_ = os.RemoveAll(tmp)
+		}
+		return "", nil, fmt.Errorf("checking out %s: %w", commit, err)
+	}
+	cleanup := func() {
+		_ = runCmd(context.Background(), "", nil, "git", "worktree", "remove", "--force", workDir)
+		if tmp != "" {
+			_ = os.RemoveAll(tmp)
+		}
+	}
+
+	if m.Build.ConfigSHA256 != "" {
+		_, got, err := digest.File(filepath.Join(workDir, "config.yaml"))
+		if err != nil {
+			return "", cleanup, err
+		}
+		if got != m.Build.ConfigSHA256 {
+			return "", cleanup, fmt.Errorf("config.yaml at %s has digest %s, the release recorded %s", commit, got, m.Build.ConfigSHA256)
+		}
+	}
+
+	// The build runs with the config overrides of the release only.
+	var env []string
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if !slices.ContainsFunc(config.EnvOverrides, func(o config.EnvOverride) bool { return o.Env == k }) {
+			env = append(env, kv)
+		}
+	}
+	for k, v := range m.Build.ConfigOverrides {
+		env = append(env, k+"="+v)
+	}
+	if m.Build.SourceDateEpoch != 0 {
+		env = append(env, "SOURCE_DATE_EPOCH="+strconv.FormatInt(m.Build.SourceDateEpoch, 10))
+	}
+	args := append(targets, "VERSION="+m.Version, "COMMIT="+commit)
+
+	slog.Info("Rebuilding release", "release", m.Version, "commit", commit, "source_date_epoch", m.Build.SourceDateEpoch)
+	if err := runCmd(ctx, workDir, env, "make", args...); err != nil {
+		return "", cleanup, fmt.Errorf("rebuilding: %w", err)
+	}
+	return filepath.Join(workDir, "build"), cleanup, nil
+}
+
+func runCmd(ctx context.Context, dir string, env []string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Command rootfs-buildkit builds the base rootfs tar archive of a rootfs
+// profile with BuildKit (rootfs.bootstrap: buildkit), for build-rootfs.sh
+// --base-tar.
+//
+// The rootfs is built from the rootfs.distro container image of
+// rootfs.distro_version (or -image), with a stage per profile of the extends
+// chain installing the packages the profile adds (pkg/rootfs/buildkit), so
+// repeated and sibling profile builds reuse the cached parent stages. The
+// package manager caches are BuildKit cache mounts and -cache-from and
+// -cache-to import and export the layer cache (BuildKit cache specs, e.g.
+// type=registry,ref=ghcr.io/acme/sbx-cache). The build runs with -builder
+// buildctl (BUILDKIT_HOST) or docker buildx, detected by default, its
+// output streamed to stderr. The builder version is written to <out without
+// .tar>.toolchain, appended to the image toolchain by build-rootfs.sh.
+//
+// Usage:
+//
+//	go run ./cmd/rootfs-buildkit -config config.yaml -profile dev -arch x86_64 -out build/rootfs-base-dev-x86_64.tar
+//	go run ./cmd/rootfs-buildkit -arch aarch64 -builder buildctl -cache-from type=local,src=/var/cache/sbx -cache-to type=local,dest=/var/cache/sbx,mode=max -out build/rootfs-base-aarch64.tar
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/rootfs/buildkit"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		profile    string
+		arch       string
+		out        string
+		image      string
+		builder    string
+		cacheFrom  string
+		cacheTo    string
+		proxy      string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile (default: rootfs.profile)")
+	flag.StringVar(&arch, "arch", "", "Architecture to build (required)")
+	flag.StringVar(&out, "out", "", "Base rootfs tar archive to write (required)")
+	flag.StringVar(&image, "image", "", "Distro container image (default: <distro>:<version>)")
+	flag.StringVar(&builder, "builder", buildkit.Auto, "BuildKit client: auto, buildctl or buildx")
+	flag.StringVar(&cacheFrom, "cache-from", os.Getenv("SBX_BUILDKIT_CACHE_FROM"), "BuildKit cache to import")
+	flag.StringVar(&cacheTo, "cache-to", os.Getenv("SBX_BUILDKIT_CACHE_TO"), "BuildKit cache to export")
+	flag.StringVar(&proxy, "proxy", os.Getenv("SBX_APT_PROXY"), "HTTP proxy of the package downloads")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if arch == "" || out == "" {
+		return fmt.Errorf("-arch and -out are required")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	d, err := distro.Get(cfg.Rootfs.Distro)
+	if err != nil {
+		return err
+	}
+	if image == "" {
+		if image, err = buildkit.Image(d, cfg.Rootfs.DistroVersion); err != nil {
+			return err
+		}
+	}
+
+	profiles := cfg.RootfsProfiles()
+	find := func(name string) (config.RootfsProfile, error) {
+		i := slices.IndexFunc(profiles, func(p config.RootfsProfile) bool { return p.Name == name })
+		if i < 0 {
+			return config.RootfsProfile{}, fmt.Errorf("unknown rootfs profile %q", name)
+		}
+		return profiles[i], nil
+	}
+	p := profiles[0]
+	if profile != "" {
+		if p, err = find(profile); err != nil {
+			return err
+		}
+	}
+
+	// The extends chain is nearest parent first, the layers root first.
+	var layers []buildkit.Layer
+	for _, name := range slices.Backward(p.Definition.Extends) {
+		parent, err := find(name)
+		if err != nil {
+			return err
+		}
+		layers = append(layers, buildkit.Layer{Name: parent.Name, Packages: d.Packages(parent.Definition.Packages)})
+	}
+	layers = append(layers, buildkit.Layer{Name: p.Name, Packages: d.Packages(p.Definition.Packages)})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	res, err := buildkit.Build(ctx, buildkit.Options{
+		Distro:    d,
+		Image:     image,
+		Arch:      arch,
+		Layers:    layers,
+		Builder:   builder,
+		CacheFrom: cacheFrom,
+		CacheTo:   cacheTo,
+		Proxy:     proxy,
+		Log:       os.Stderr,
+	}, out)
+	if err != nil {
+		return fmt.Errorf("%s profile for %s: %w", p.Name, arch, err)
+	}
+
+	toolchain := fmt.Sprintf("%s=%s\n", res.Tool, res.ToolVersion)
+	if err := os.WriteFile(strings.TrimSuffix(out, ".tar")+".toolchain", []byte(toolchain), 0o644); err != nil {
+		return err
+	}
+
+	slog.Info("Built base rootfs", "path", out, "arch", arch, "image", res.Image, "tool", res.Tool, "tool_version", res.ToolVersion, "packages", len(res.Packages), "stages", len(layers), "duration", res.Duration.Round(time.Second))
+	return nil
+}
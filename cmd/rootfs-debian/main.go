@@ -0,0 +1,138 @@
+// Command rootfs-debian builds the base rootfs tar archive of a Debian rootfs
+// profile (rootfs.distro: debian) with the rootfs.bootstrap tool, mmdebstrap
+// or debootstrap, for build-rootfs.sh --base-tar.
+//
+// The profile packages (rootfs.packages and the profile ones, with the base,
+// init and service packages renamed to their Debian names) are installed on
+// top of the minbase variant of the rootfs.distro_version suite. The tool
+// runs in a -runtime container (default: container_runtime, docker or
+// podman, rootless included, or "host" to run it on the host), apt
+// downloads go through -proxy (default: $SBX_APT_PROXY) and the build steps
+// output is streamed to stderr. The tool version is written to
+// <out without .tar>.toolchain, appended to the image toolchain by
+// build-rootfs.sh.
+//
+// Usage:
+//
+//	go run ./cmd/rootfs-debian -config config.yaml -profile dev -arch x86_64 -out build/rootfs-base-dev-x86_64.tar
+//	go run ./cmd/rootfs-debian -arch aarch64 -runtime podman -proxy http://apt-cache:3142 -out build/rootfs-base-aarch64.tar
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/container"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/rootfs/debian"
+)
+
+func main() {
+	if err := run(); err != nil {
+		args := []any{"err", err}
+		var stepErr *debian.Error
+		if errors.As(err, &stepErr) && stepErr.Output != "" {
+			args = append(args, "step", stepErr.Step, "exit_code", stepErr.ExitCode, "output", stepErr.Output)
+		}
+		slog.Error("failed", args...)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		profile    string
+		arch       string
+		out        string
+		runtime    string
+		image      string
+		mirror     string
+		proxy      string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile (default: rootfs.profile)")
+	flag.StringVar(&arch, "arch", "", "Architecture to build (required)")
+	flag.StringVar(&out, "out", "", "Base rootfs tar archive to write (required)")
+	flag.StringVar(&runtime, "runtime", "", `Container runtime running the bootstrap tool (auto, docker, podman or "host") (default: container_runtime)`)
+	flag.StringVar(&image, "image", "", "Container image of the bootstrap tool (default: debian:<suite>)")
+	flag.StringVar(&mirror, "mirror", debian.DefaultMirror, "Debian archive mirror")
+	flag.StringVar(&proxy, "proxy", os.Getenv("SBX_APT_PROXY"), "HTTP proxy of the apt downloads")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if arch == "" || out == "" {
+		return fmt.Errorf("-arch and -out are required")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if cfg.Rootfs.Distro != "debian" {
+		return fmt.Errorf("rootfs.distro is %q, not debian", cfg.Rootfs.Distro)
+	}
+	d, err := distro.Get(cfg.Rootfs.Distro)
+	if err != nil {
+		return err
+	}
+
+	profiles := cfg.RootfsProfiles()
+	p := profiles[0]
+	if profile != "" {
+		i := slices.IndexFunc(profiles, func(p config.RootfsProfile) bool { return p.Name == profile })
+		if i < 0 {
+			return fmt.Errorf("unknown rootfs profile %q", profile)
+		}
+		p = profiles[i]
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var rt container.Runtime
+	if runtime = cmp.Or(runtime, cfg.ContainerRuntime); runtime != "host" {
+		if rt, err = container.New(ctx, runtime); err != nil {
+			return err
+		}
+	}
+
+	res, err := debian.Build(ctx, debian.Options{
+		Bootstrap: cfg.Rootfs.Bootstrap,
+		Suite:     d.Release(cfg.Rootfs.DistroVersion),
+		Arch:      arch,
+		Mirror:    mirror,
+		Packages:  d.Packages(p.Definition.Packages),
+		Proxy:     proxy,
+		Runtime:   rt,
+		Image:     image,
+		Log:       os.Stderr,
+	}, out)
+	if err != nil {
+		return fmt.Errorf("%s profile for %s: %w", p.Name, arch, err)
+	}
+
+	toolchain := fmt.Sprintf("%s=%s\n", res.Tool, res.ToolVersion)
+	if err := os.WriteFile(strings.TrimSuffix(out, ".tar")+".toolchain", []byte(toolchain), 0o644); err != nil {
+		return err
+	}
+
+	slog.Info("Built base rootfs", "path", out, "suite", res.Suite, "arch", res.Arch, "tool", res.Tool, "tool_version", res.ToolVersion, "packages", len(res.Packages), "duration", res.Duration.Round(time.Second))
+	return nil
+}
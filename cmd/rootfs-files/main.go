@@ -0,0 +1,207 @@
+// Command rootfs-files stages the config.yaml files and users of a rootfs
+// profile (rootfs.files, rootfs.users and the profiles' ones, resolved through
+// the extends chain) for build-rootfs.sh.
+//
+// The contents are written to <out-dir>/<n>, in order, and listed in
+// <out-dir>/files.list with one n|dest|mode|owner line per file, which
+// build-rootfs.sh installs in the image with the mode and owner. The users are
+// listed in <out-dir>/users.list with one name|uid|shell|groups line per user
+// (uid empty when allocated, groups comma separated) and their SSH
+// authorized_keys are written to <out-dir>/keys/<name>.
+//
+// With an agent configured, the -arch agent binary is copied or downloaded
+// (and verified against its pinned digest) to <out-dir>/agent and described
+// in <out-dir>/agent.list with a name|dest|args line (args space separated),
+// which build-rootfs.sh installs and registers with the image init system.
+//
+// Profiles with an Ignition config get the -arch ignition binary staged to
+// <out-dir>/ignition and their config to <out-dir>/ignition.ign, run on the
+// first boot by the sbx-ignition service.
+//
+// Usage:
+//
+//	go run ./cmd/rootfs-files -config config.yaml -profile dev -arch x86_64 -out-dir build/rootfs-files/dev-x86_64
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		profile    string
+		arch       string
+		outDir     string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile (default: rootfs.profile)")
+	flag.StringVar(&arch, "arch", "", "Architecture of the staged agent binary (required with an agent)")
+	flag.StringVar(&outDir, "out-dir", "", "Staging directory, replaced")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if outDir == "" {
+		return fmt.Errorf("-out-dir is required")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	profiles := cfg.RootfsProfiles()
+	p := profiles[0]
+	if profile != "" {
+		i := slices.IndexFunc(profiles, func(p config.RootfsProfile) bool { return p.Name == profile })
+		if i < 0 {
+			return fmt.Errorf("unknown rootfs profile %q", profile)
+		}
+		p = profiles[i]
+	}
+
+	if err := os.RemoveAll(outDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return err
+	}
+
+	var list strings.Builder
+	for i, f := range p.EffectiveFiles {
+		data, err := f.Data()
+		if err != nil {
+			return fmt.Errorf("file %s: %w", f.Dest, err)
+		}
+		name := strconv.Itoa(i)
+		if err := os.WriteFile(filepath.Join(outDir, name), data, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "%s|%s|%s|%s\n", name, f.Dest, f.Mode, f.Owner)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "files.list"), []byte(list.String()), 0o644); err != nil {
+		return err
+	}
+
+	var users strings.Builder
+	if err := os.MkdirAll(filepath.Join(outDir, "keys"), 0o755); err != nil {
+		return err
+	}
+	for _, u := range p.EffectiveUsers {
+		keys, err := u.AuthorizedKeys()
+		if err != nil {
+			return err
+		}
+		if keys != "" {
+			if err := os.WriteFile(filepath.Join(outDir, "keys", u.Name), []byte(keys), 0o644); err != nil {
+				return err
+			}
+		}
+		uid := ""
+		if u.UID > 0 {
+			uid = strconv.Itoa(u.UID)
+		}
+		fmt.Fprintf(&users, "%s|%s|%s|%s\n", u.Name, uid, u.Shell, strings.Join(u.Groups, ","))
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "users.list"), []byte(users.String()), 0o644); err != nil {
+		return err
+	}
+
+	if cfg.Agent.Enabled() {
+		if err := stageAgent(context.Background(), cfg.Agent, arch, outDir); err != nil {
+			return fmt.Errorf("agent %s: %w", cfg.Agent.Name, err)
+		}
+	}
+
+	if p.EffectiveIgnition != "" {
+		if err := stageBinary(context.Background(), cfg.Ignition.Binaries, arch, filepath.Join(outDir, "ignition")); err != nil {
+			return fmt.Errorf("ignition: %w", err)
+		}
+		data, err := os.ReadFile(p.EffectiveIgnition)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(outDir, "ignition.ign"), data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Staged profile files and users", "profile", p.Name, "dir", outDir, "files", len(p.EffectiveFiles), "users", len(p.EffectiveUsers))
+	return nil
+}
+
+// stageAgent writes the agent binary of arch and its agent.list line to
+// outDir.
+func stageAgent(ctx context.Context, agent config.Agent, arch, outDir string) error {
+	if err := stageBinary(ctx, agent.Binaries, arch, filepath.Join(outDir, "agent")); err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s|%s|%s\n", agent.Name, agent.Dest, strings.Join(agent.Args, " "))
+	return os.WriteFile(filepath.Join(outDir, "agent.list"), []byte(line), 0o644)
+}
+
+// stageBinary writes the binary of arch to dst, verified against its pinned
+// digest.
+func stageBinary(ctx context.Context, binaries map[string]config.ArchBinary, arch, dst string) error {
+	b, ok := binaries[arch]
+	if !ok {
+		return fmt.Errorf("no binary for architecture %q (-arch)", arch)
+	}
+
+	data, err := fetchBinary(ctx, b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", b.Source(), err)
+	}
+	sum := sha256.Sum256(data)
+	if digest := hex.EncodeToString(sum[:]); b.SHA256 != "" && digest != b.SHA256 {
+		return fmt.Errorf("%s: sha256 mismatch: got %s, want %s", b.Source(), digest, b.SHA256)
+	}
+	return os.WriteFile(dst, data, 0o755)
+}
+
+func fetchBinary(ctx context.Context, b config.ArchBinary) ([]byte, error) {
+	if b.URL == "" {
+		return os.ReadFile(b.File)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %d", b.URL, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
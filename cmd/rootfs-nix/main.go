@@ -0,0 +1,178 @@
+// Command rootfs-nix builds the rootfs image of a Nix profile (rootfs
+// profiles nix: <flake directory>#<package>) with nix build.
+//
+// The flake package is built for the -arch system
+// (packages.<arch>-linux.<package>) from the locked flake inputs, so the
+// image is bit-reproducible, and must be the ext4 image itself or a
+// directory holding a single .img or .ext4 file (e.g. nixpkgs
+// make-ext4-fs). The image is copied sparse to -out (sparse.Copy, as image
+// downloads should be written) and the nix version written to
+// <out without .ext4>.toolchain. The flake.lock digest is published in the
+// manifest as rootfs.definition.nix and in the provenance.
+//
+// Usage:
+//
+//	go run ./cmd/rootfs-nix -config config.yaml -profile nix -arch x86_64 -out build/rootfs-nix-x86_64.ext4
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/sparse"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		profile    string
+		arch       string
+		out        string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile (default: rootfs.profile)")
+	flag.StringVar(&arch, "arch", "", "Architecture to build (required)")
+	flag.StringVar(&out, "out", "", "Rootfs image to write (required)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if arch == "" || out == "" {
+		return fmt.Errorf("-arch and -out are required")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	profiles := cfg.RootfsProfiles()
+	p := profiles[0]
+	if profile != "" {
+		i := slices.IndexFunc(profiles, func(p config.RootfsProfile) bool { return p.Name == profile })
+		if i < 0 {
+			return fmt.Errorf("unknown rootfs profile %q", profile)
+		}
+		p = profiles[i]
+	}
+	if p.Definition.Nix == nil {
+		return fmt.Errorf("rootfs profile %s is not a nix profile", p.Name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	version, err := nix(ctx, "--version")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(version)
+	version = fields[len(fields)-1]
+
+	installable := fmt.Sprintf("%s#packages.%s.%s", p.EffectiveNix, config.NixSystem(arch), p.Definition.Nix.Package)
+	paths, err := nix(ctx, "build", "--no-link", "--print-out-paths", installable)
+	if err != nil {
+		return err
+	}
+	image, err := imageFile(strings.TrimSpace(paths))
+	if err != nil {
+		return fmt.Errorf("%s: %w", installable, err)
+	}
+	if err := copyFile(image, out); err != nil {
+		return err
+	}
+
+	toolchain := fmt.Sprintf("nix=%s\n", version)
+	if err := os.WriteFile(strings.TrimSuffix(out, ".ext4")+".toolchain", []byte(toolchain), 0o644); err != nil {
+		return err
+	}
+
+	slog.Info("Built Nix rootfs", "path", out, "installable", installable, "flake_lock_sha256", p.Definition.Nix.LockSHA256, "nix_version", version)
+	return nil
+}
+
+// nix runs a nix command with flakes enabled, returning its standard output.
+// The build logs go to stderr.
+func nix(ctx context.Context, args ...string) (string, error) {
+	args = append([]string{"--extra-experimental-features", "nix-command flakes"}, args...)
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "nix", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("nix %s: %w", strings.Join(args[2:], " "), err)
+	}
+	return stdout.String(), nil
+}
+
+// imageFile returns the image of the store path out: out itself or its
+// single .img or .ext4 file.
+func imageFile(out string) (string, error) {
+	if strings.Contains(out, "\n") {
+		return "", fmt.Errorf("the package has several outputs")
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return out, nil
+	}
+
+	var images []string
+	for _, ext := range []string{"*.img", "*.ext4"} {
+		m, err := filepath.Glob(filepath.Join(out, ext))
+		if err != nil {
+			return "", err
+		}
+		images = append(images, m...)
+	}
+	if len(images) != 1 {
+		return "", fmt.Errorf("%s must hold a single .img or .ext4 image, found %d", out, len(images))
+	}
+	return images[0], nil
+}
+
+// copyFile copies the read-only store file src to a writable dst, sparse:
+// the store does not keep the image holes.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = sparse.Copy(f, in)
+	return err
+}
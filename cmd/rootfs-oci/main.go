@@ -0,0 +1,116 @@
+// Command rootfs-oci builds the base rootfs tar archive of a rootfs profile
+// built from an OCI container image (rootfs.image or the profile image), for
+// build-rootfs.sh --base-tar.
+//
+// The -arch platform image is pulled with the docker config credentials,
+// its layers flattened and fixed up (pkg/rootfs/oci). The pulled image is
+// recorded in <out without .tar>.source (image, digest and platform lines),
+// published in the manifest as rootfs.source_image, and the
+// go-containerregistry version in <out without .tar>.toolchain.
+//
+// Usage:
+//
+//	go run ./cmd/rootfs-oci -config config.yaml -profile app -arch x86_64 -out build/rootfs-base-app-x86_64.tar
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/rootfs/oci"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		profile    string
+		arch       string
+		out        string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile (default: rootfs.profile)")
+	flag.StringVar(&arch, "arch", "", "Architecture to pull (required)")
+	flag.StringVar(&out, "out", "", "Base rootfs tar archive to write (required)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if arch == "" || out == "" {
+		return fmt.Errorf("-arch and -out are required")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	profiles := cfg.RootfsProfiles()
+	p := profiles[0]
+	if profile != "" {
+		i := slices.IndexFunc(profiles, func(p config.RootfsProfile) bool { return p.Name == profile })
+		if i < 0 {
+			return fmt.Errorf("unknown rootfs profile %q", profile)
+		}
+		p = profiles[i]
+	}
+	if p.Definition.Image == "" {
+		return fmt.Errorf("rootfs profile %s has no image", p.Name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	img, src, err := oci.Pull(ctx, p.Definition.Image, arch)
+	if err != nil {
+		return err
+	}
+	if err := oci.Export(img, out); err != nil {
+		return fmt.Errorf("exporting %s: %w", src.Image, err)
+	}
+
+	base := strings.TrimSuffix(out, ".tar")
+	source := fmt.Sprintf("image=%s\ndigest=%s\nplatform=%s\n", src.Image, src.Digest, src.Platform)
+	if err := os.WriteFile(base+".source", []byte(source), 0o644); err != nil {
+		return err
+	}
+	toolchain := fmt.Sprintf("go-containerregistry=%s\n", moduleVersion("github.com/google/go-containerregistry"))
+	if err := os.WriteFile(base+".toolchain", []byte(toolchain), 0o644); err != nil {
+		return err
+	}
+
+	slog.Info("Exported image filesystem", "path", out, "image", src.Image, "digest", src.Digest, "platform", src.Platform)
+	return nil
+}
+
+// moduleVersion returns the version of the module path the command was
+// built with.
+func moduleVersion(path string) string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == path {
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
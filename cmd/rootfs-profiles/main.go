@@ -0,0 +1,172 @@
+// Command rootfs-profiles lists the rootfs profiles from config.yaml for
+// every architecture, for the Makefile rootfs build loop.
+//
+// Each profile and architecture pair is rendered with the -format template
+// (fields: Profile, Arch, Stem, Default, and the effective profile definition
+// resolved through the extends chain: Firstboot, Init, CloudInit, Timezone,
+// Locale, Packages (distro package names) and FilesDirs, the lists comma
+// separated, the rendered Hostname, empty without a hostname template, and
+// the Distro, its Release, the Bootstrap method, "oci" for the profiles built
+// from a container image and "nix" for the Nix profiles, the PackageDB file
+// name, the Nix Flake directory, relative to the config file, and the
+// Filesystems of the images published next to the ext4 one, comma
+// separated, the DiskFormats the images are converted to, comma
+// separated, the OverlayMiB size of the overlay disk template, empty
+// without overlay, the Verity filesystem of the image the dm-verity hash
+// tree is made for, empty without verity, the Ext4Options, the
+// rootfs.ext4 mkfs.ext4 options, and the SizeMiB of the ext4 image of the
+// architecture, empty when sized to its content), one per line. Stem is
+// the per arch file name part, <arch> for the default rootfs.profile and
+// <profile>-<arch> otherwise (rootfs-<stem>.ext4). Empty lines are skipped,
+// so templates can filter with {{if}}.
+//
+// Usage:
+//
+//	go run ./cmd/rootfs-profiles -config config.yaml
+//	go run ./cmd/rootfs-profiles -config config.yaml -arch x86_64 -format 'rootfs-{{.Stem}}.ext4'
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+)
+
+// defaultFormat is read by the Makefile with IFS='|'.
+const defaultFormat = "{{.Profile}}|{{.Arch}}|{{.Stem}}|{{.Firstboot}}|{{.Packages}}|{{.FilesDirs}}|{{.Init}}|{{.CloudInit}}|{{.Timezone}}|{{.Locale}}|{{.Hostname}}|{{.Distro}}|{{.Release}}|{{.Bootstrap}}|{{.PackageDB}}|{{.Flake}}|{{.Filesystems}}|{{.OverlayMiB}}|{{.Verity}}|{{.Ext4Options}}|{{.SizeMiB}}"
+
+// entry is a rootfs profile of an architecture.
+type entry struct {
+	Profile   string
+	Arch      string
+	Stem      string
+	Default   bool
+	Firstboot bool
+	Init      string
+	CloudInit bool
+	Timezone  string
+	Locale    string
+	Hostname  string
+	Distro    string
+	Release   string
+	Bootstrap string
+	PackageDB string
+	Flake     string
+	Packages  string
+	FilesDirs string
+	// Filesystems are the extra image filesystems.
+	Filesystems string
+	DiskFormats string
+	OverlayMiB  string
+	Verity      string
+	Ext4Options string
+	SizeMiB     string
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		arch       string
+		format     string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&arch, "arch", "", "Only list this architecture (default: every architecture)")
+	flag.StringVar(&format, "format", defaultFormat, "Go template rendered per profile and architecture")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return fmt.Errorf("parsing -format: %w", err)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	d, err := distro.Get(cfg.Rootfs.Distro)
+	if err != nil {
+		return err
+	}
+	for _, p := range cfg.RootfsProfiles() {
+		for _, a := range cfg.Architectures {
+			if arch != "" && a != arch {
+				continue
+			}
+
+			hostname, err := p.Hostname(a)
+			if err != nil {
+				return err
+			}
+			var flake, overlay, verity, size string
+			if p.Definition.Nix != nil {
+				flake = p.Definition.Nix.Flake
+			}
+			if p.Definition.Overlay {
+				overlay = strconv.Itoa(cfg.Rootfs.OverlaySizeMiB)
+			}
+			if p.Definition.Verity {
+				verity = cfg.ReadOnlyRoot(p)
+			}
+			if mib := cfg.RootfsSizeMiB(a); mib > 0 {
+				size = strconv.Itoa(mib)
+			}
+			e := entry{
+				Profile:     p.Name,
+				Arch:        a,
+				Stem:        p.Stem(a),
+				Default:     p.Default,
+				Firstboot:   p.Definition.Firstboot,
+				Init:        p.Definition.Init,
+				CloudInit:   p.Definition.CloudInit,
+				Timezone:    p.Definition.Timezone,
+				Locale:      p.Definition.Locale,
+				Hostname:    hostname,
+				Distro:      d.Name(),
+				Release:     d.Release(cfg.Rootfs.DistroVersion),
+				Bootstrap:   cfg.Bootstrap(p),
+				PackageDB:   distro.PackageDB(d, p.Stem(a)),
+				Flake:       flake,
+				Filesystems: strings.Join(cfg.RootfsFilesystems(p)[1:], ","),
+				DiskFormats: strings.Join(cfg.Rootfs.DiskFormats, ","),
+				OverlayMiB:  overlay,
+				Verity:      verity,
+				Ext4Options: cfg.Rootfs.Ext4.MkfsOptions(),
+				SizeMiB:     size,
+				Packages:    strings.Join(d.Packages(p.Definition.Packages), ","),
+				FilesDirs:   strings.Join(p.Definition.FilesDirs, ","),
+			}
+
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, e); err != nil {
+				return fmt.Errorf("rendering %s profile for %s: %w", p.Name, a, err)
+			}
+			if line := strings.TrimSpace(buf.String()); line != "" {
+				fmt.Println(line)
+			}
+		}
+	}
+	return nil
+}
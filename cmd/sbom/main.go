@@ -0,0 +1,135 @@
+// Command sbom generates SBOMs per rootfs image.
+//
+// It reads the package database exported next to each rootfs image by
+// build-rootfs.sh (rootfs-<stem>.apkdb, a copy of /lib/apk/db/installed, or
+// rootfs-<stem>.debdb, a copy of /var/lib/dpkg/status, for Debian) and
+// writes one document per selected format from the same package inventory:
+// rootfs-<stem>.spdx.json (SPDX) and rootfs-<stem>.cdx.json (CycloneDX), which
+// the manifest then references. The stem is <arch> for the default rootfs
+// profile and <profile>-<arch> for rootfs.profiles.
+//
+// Usage:
+//
+//	go run ./cmd/sbom -version v0.1.0 -config config.yaml -build-dir build
+//	go run ./cmd/sbom -version v0.1.0 -formats spdx,cyclonedx -build-dir build
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/sbom"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		version    string
+		configPath string
+		buildDir   string
+		formatList string
+	)
+
+	flag.StringVar(&version, "version", "", "Release version (e.g. v0.1.0)")
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&formatList, "formats", "spdx", "Comma separated SBOM formats ("+strings.Join(sbom.FormatNames(), ", ")+")")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if version == "" {
+		return fmt.Errorf("-version is required")
+	}
+
+	formats, err := sbom.ParseFormats(formatList)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	d, err := distro.Get(cfg.Rootfs.Distro)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range cfg.RootfsProfiles() {
+		// The Nix profile images have no distro package database.
+		if p.Definition.Nix != nil {
+			slog.Info("Skipping nix profile without package database", "profile", p.Name)
+			continue
+		}
+		for _, arch := range cfg.Architectures {
+			stem := p.Stem(arch)
+			rootfsFile := fmt.Sprintf("rootfs-%s.ext4", stem)
+
+			pkgs, err := sbom.ReadInstalled(cfg.Rootfs.Distro, filepath.Join(buildDir, distro.PackageDB(d, stem)))
+			if err != nil {
+				return fmt.Errorf("package database for %s: %w", stem, err)
+			}
+
+			_, sum, err := digest.File(filepath.Join(buildDir, rootfsFile))
+			if err != nil {
+				return fmt.Errorf("rootfs artifact for %s: %w", stem, err)
+			}
+
+			img := sbom.Image{
+				Name:          rootfsFile,
+				Version:       version,
+				Arch:          arch,
+				Distro:        cfg.Rootfs.Distro,
+				DistroVersion: cfg.Rootfs.DistroVersion,
+				Profile:       p.Name,
+				SHA256:        sum,
+				Created:       time.Now(),
+			}
+
+			for _, format := range formats {
+				outPath := filepath.Join(buildDir, fmt.Sprintf("rootfs-%s%s", stem, format.Extension))
+				if err := writeSBOM(outPath, format, img, pkgs); err != nil {
+					return err
+				}
+				slog.Info("Wrote SBOM", "format", format.Name, "path", outPath, "packages", len(pkgs))
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeSBOM(path string, format sbom.Format, img sbom.Image, pkgs []sbom.Package) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+
+	err = format.Write(f, img, pkgs)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Command scan checks the rootfs SBOMs for known vulnerabilities.
+//
+// It runs the scanner configured in config.yaml (grype or trivy) on every
+// rootfs-<stem> SBOM (see cmd/sbom), writes the findings to
+// rootfs-<stem>.vulns.json, which
+// the manifest then references, and fails when a finding is at or above the
+// scan.fail_on severity.
+//
+// With -o json the finding counts of every image and the failing findings
+// are printed to stdout as a JSON document, also when the scan fails.
+//
+// Usage:
+//
+//	go run ./cmd/scan -config config.yaml -build-dir build
+//	go run ./cmd/scan -config config.yaml -build-dir build -scanner trivy -fail-on high
+//	go run ./cmd/scan -config config.yaml -build-dir build -o json | jq '.images[].counts'
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/sbom"
+	"github.com/slok/sbx-images/pkg/scan"
+)
+
+// Result is the JSON output of scan.
+type Result struct {
+	Scanner        string        `json:"scanner"`
+	ScannerVersion string        `json:"scanner_version,omitempty"`
+	FailOn         string        `json:"fail_on,omitempty"`
+	Images         []ImageResult `json:"images"`
+	Failing        int           `json:"failing"`
+}
+
+// ImageResult is the scan of a rootfs image.
+type ImageResult struct {
+	Stem string `json:"stem"`
+	// Report is the written vulnerability report.
+	Report string         `json:"report"`
+	Counts map[string]int `json:"counts"`
+	// Failing are the findings at or above the fail_on severity.
+	Failing []scan.Finding `json:"failing"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath  string
+		buildDir    string
+		scannerName string
+		failOn      string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&scannerName, "scanner", "", "Scanner (grype, trivy) (default: scan.scanner from config)")
+	flag.StringVar(&failOn, "fail-on", "", "Minimum failing severity, none to only report (default: scan.fail_on from config)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if scannerName == "" {
+		scannerName = cfg.Scan.Scanner
+	}
+	switch failOn {
+	case "":
+		failOn = cfg.Scan.FailOn
+	case "none":
+		failOn = ""
+	}
+
+	s, err := scan.New(scannerName)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	version, err := s.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("getting %s version: %w", s.Name(), err)
+	}
+
+	var stems []string
+	for _, p := range cfg.RootfsProfiles() {
+		if p.Definition.Nix != nil {
+			slog.Info("Skipping nix profile without SBOM", "profile", p.Name)
+			continue
+		}
+		for _, arch := range cfg.Architectures {
+			stems = append(stems, p.Stem(arch))
+		}
+	}
+
+	result := Result{Scanner: s.Name(), ScannerVersion: version, FailOn: failOn, Images: []ImageResult{}}
+	var failing []string
+	for _, stem := range stems {
+		sbomFile, err := findSBOM(buildDir, stem)
+		if err != nil {
+			return err
+		}
+
+		findings, err := s.Scan(ctx, filepath.Join(buildDir, sbomFile))
+		if err != nil {
+			return fmt.Errorf("scanning %s: %w", sbomFile, err)
+		}
+
+		r := scan.NewReport(s.Name(), version, sbomFile, time.Now().UTC().Format(time.RFC3339), findings)
+		outPath := filepath.Join(buildDir, fmt.Sprintf("rootfs-%s.vulns.json", stem))
+		if err := scan.Write(outPath, r); err != nil {
+			return err
+		}
+		slog.Info("Wrote vulnerability report", "path", outPath, "findings", countsSummary(r.Counts))
+
+		img := ImageResult{Stem: stem, Report: outPath, Counts: r.Counts, Failing: []scan.Finding{}}
+		for _, f := range scan.AtOrAbove(r.Findings, failOn, cfg.Scan.Ignore) {
+			failing = append(failing, fmt.Sprintf("%s: %s %s in %s %s", stem, f.Severity, f.ID, f.Package, f.Version))
+			img.Failing = append(img.Failing, f)
+		}
+		result.Images = append(result.Images, img)
+	}
+	result.Failing = len(failing)
+
+	if outFlags.JSON() {
+		if err := output.Print(result); err != nil {
+			return err
+		}
+	}
+
+	if len(failing) > 0 {
+		for _, f := range failing {
+			slog.Error("Vulnerability at or above the fail_on severity", "finding", f)
+		}
+		return exitcode.Wrap(exitcode.Policy, fmt.Errorf("%d finding(s) at or above %s severity", len(failing), failOn))
+	}
+	return nil
+}
+
+// findSBOM returns the SBOM file of the rootfs-<stem> image, preferring
+// SPDX.
+func findSBOM(buildDir, stem string) (string, error) {
+	for _, name := range []string{"spdx", "cyclonedx"} {
+		f := fmt.Sprintf("rootfs-%s%s", stem, sbom.Formats[name].Extension)
+		if _, err := os.Stat(filepath.Join(buildDir, f)); err == nil {
+			return f, nil
+		}
+	}
+	return "", exitcode.Wrap(exitcode.MissingArtifact, fmt.Errorf("no SBOM found for %s, run make sbom first", stem))
+}
+
+func countsSummary(counts map[string]int) string {
+	var parts []string
+	for i := len(scan.Severities) - 1; i >= 0; i-- {
+		if n := counts[scan.Severities[i]]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, scan.Severities[i]))
+		}
+	}
+	if n := counts["unknown"]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%d unknown", n))
+	}
+	if len(parts) == 0 {
+		return "no findings"
+	}
+	return strings.Join(parts, ", ")
+}
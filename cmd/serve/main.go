@@ -0,0 +1,103 @@
+// Command serve serves a build directory (or a mirror of a release) over
+// HTTP, as a local release host for air-gapped labs and for integration tests
+// against a fake release host.
+//
+// Every file is served at its path in the directory, with Range requests
+// support (resumable and parallel downloads), next to a generated index of
+// the files at /index.json (marking manifest.json and the files it
+// references) and a /healthz health check. Directories are not listed and dot
+// prefixed paths, e.g. the build cache, are not served.
+//
+// Basic authentication is enabled with -user, the password being read from
+// SBX_SERVE_PASSWORD so it doesn't show in the process list.
+//
+// -usage-report reports the release kernel downloads as usage events (see
+// pkg/usage) to a JSON lines log file or an http(s) endpoint, aggregated by
+// cmd/usage.
+//
+// Usage:
+//
+//	go run ./cmd/serve -dir build
+//	go run ./cmd/serve -dir /srv/mirror -addr :8080
+//	SBX_SERVE_PASSWORD=secret go run ./cmd/serve -dir build -user lab
+//	go run ./cmd/serve -dir /srv/mirror -usage-report usage.jsonl
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/serve"
+	"github.com/slok/sbx-images/pkg/usage"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var dir, addr, user, usageReport string
+	flag.StringVar(&dir, "dir", "build", "Directory to serve (build directory or release mirror)")
+	flag.StringVar(&addr, "addr", "127.0.0.1:8080", "Address to listen on")
+	flag.StringVar(&user, "user", "", "Basic authentication user (password from SBX_SERVE_PASSWORD)")
+	flag.StringVar(&usageReport, "usage-report", "", "Report the release kernel downloads to this JSON lines log or http(s) endpoint")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("%s is not a directory", dir))
+	}
+	password := os.Getenv("SBX_SERVE_PASSWORD")
+	if user != "" && password == "" {
+		return exitcode.Wrap(exitcode.Usage, fmt.Errorf("-user requires SBX_SERVE_PASSWORD"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := serve.Server{Dir: dir, Username: user, Password: password}
+	if usageReport != "" {
+		s.Usage = usage.NewReporter(usageReport)
+	}
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving", "dir", dir, "url", "http://"+ln.Addr().String(), "auth", user != "", "usage_report", usageReport)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
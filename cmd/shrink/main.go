@@ -0,0 +1,309 @@
+// Command shrink minimizes the size of a built rootfs image and updates its
+// size, disk usage and digest in manifest.json.
+//
+// ext4 images are checked (e2fsck) and shrunk to their minimum size
+// (resize2fs -M), btrfs images are mounted and resized to their minimum
+// device size (btrfs inspect-internal min-dev-size, as root). The file is
+// truncated to the filesystem size, or re-grown to -size-mib when set (the
+// filesystem grown to fill it), and its unused blocks punched out. The
+// read-only squashfs and EROFS images are already minimal. Images with a
+// dm-verity hash tree cannot be shrunk without rebuilding it. The signature
+// and provenance of the shrunk image no longer match it and are dropped
+// from the manifest: sign and attest again.
+//
+// Usage:
+//
+//	go run ./cmd/shrink -build-dir build -image rootfs-x86_64.ext4
+//	sudo go run ./cmd/shrink -build-dir build -image rootfs-dev-x86_64.btrfs -size-mib 2048
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/sparse"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+// image is the manifest entry of the shrunk image.
+type image struct {
+	Filesystem     string
+	SizeBytes      *int64
+	DiskUsageBytes *int64
+	SHA256         *string
+	Signature      *string
+	Provenance     *string
+}
+
+func run() error {
+	var (
+		buildDir     string
+		manifestPath string
+		file         string
+		sizeMiB      int
+	)
+
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	flag.StringVar(&file, "image", "", "Image file name in the build directory (required)")
+	flag.IntVar(&sizeMiB, "size-mib", 0, "Size to re-grow the image to after shrinking (default: the minimum size)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if file == "" {
+		return fmt.Errorf("-image is required")
+	}
+	if sizeMiB < 0 {
+		return fmt.Errorf("-size-mib must not be negative")
+	}
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+	var (
+		arch string
+		a    manifest.ArchArtifacts
+		img  *image
+	)
+	for arch, a = range m.Artifacts {
+		if img, err = findImage(&a, file); err != nil {
+			return err
+		}
+		if img != nil {
+			break
+		}
+	}
+	if img == nil {
+		return fmt.Errorf("no rootfs image %s in manifest", file)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	path := filepath.Join(buildDir, file)
+	before := *img.SizeBytes
+	size := int64(sizeMiB) << 20
+	switch img.Filesystem {
+	case manifest.RootfsFilesystemExt4:
+		err = shrinkExt4(ctx, path, size)
+	case manifest.RootfsFilesystemBtrfs:
+		err = shrinkBtrfs(ctx, path, size)
+	case manifest.RootfsFilesystemSquashfs, manifest.RootfsFilesystemEROFS:
+		return fmt.Errorf("%s is a read-only %s image, already minimal", file, img.Filesystem)
+	default:
+		return fmt.Errorf("%s is not a filesystem image", file)
+	}
+	if err != nil {
+		return fmt.Errorf("shrinking %s: %w", file, err)
+	}
+	if err := sparse.DigHoles(path); err != nil {
+		return fmt.Errorf("punching holes in %s: %w", file, err)
+	}
+
+	if *img.SizeBytes, *img.SHA256, err = digest.File(path); err != nil {
+		return err
+	}
+	if *img.DiskUsageBytes, err = sparse.DiskUsage(path); err != nil {
+		return err
+	}
+	if *img.Signature != "" || *img.Provenance != "" {
+		slog.Warn("Dropped the stale signature and provenance, sign and attest it again", "file", file)
+		*img.Signature, *img.Provenance = "", ""
+	}
+	m.Artifacts[arch] = a
+	if err := manifest.Write(manifestPath, m); err != nil {
+		return err
+	}
+
+	slog.Info("Shrunk image", "file", file, "from_mib", before>>20, "to_mib", *img.SizeBytes>>20, "disk_usage_mib", *img.DiskUsageBytes>>20)
+	slog.Info("Wrote manifest", "path", manifestPath)
+	return nil
+}
+
+// findImage returns the manifest entry of the rootfs image file in a, nil
+// when a has none.
+func findImage(a *manifest.ArchArtifacts, file string) (*image, error) {
+	for _, r := range a.Rootfses() {
+		if r.Verity != nil && r.Verity.Root == file {
+			return nil, fmt.Errorf("%s has the dm-verity hash tree %s, rebuild the image instead", file, r.Verity.File)
+		}
+		if r.File == file {
+			return &image{
+				Filesystem:     manifest.RootfsFilesystemExt4,
+				SizeBytes:      &r.SizeBytes,
+				DiskUsageBytes: &r.DiskUsageBytes,
+				SHA256:         &r.SHA256,
+				Signature:      &r.Signature,
+				Provenance:     &r.Provenance,
+			}, nil
+		}
+		for _, img := range r.ExtraImages() {
+			if img.File == file && img.Format != "" {
+				return nil, fmt.Errorf("%s is converted from %s, shrink it and convert it again instead", file, img.Source)
+			}
+			if img.File == file {
+				return &image{
+					Filesystem:     img.Filesystem,
+					SizeBytes:      &img.SizeBytes,
+					DiskUsageBytes: &img.DiskUsageBytes,
+					SHA256:         &img.SHA256,
+					Signature:      &img.Signature,
+					Provenance:     &img.Provenance,
+				}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// shrinkExt4 shrinks the ext4 image at path to its minimum size, then grows
+// it to size when set.
+func shrinkExt4(ctx context.Context, path string, size int64) error {
+	// e2fsck exits 1 when it fixed errors.
+	if _, err := command(ctx, "e2fsck", "-fy", path); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() > 1 {
+			return err
+		}
+	}
+	if _, err := command(ctx, "resize2fs", "-M", path); err != nil {
+		return err
+	}
+	out, err := command(ctx, "dumpe2fs", "-h", path)
+	if err != nil {
+		return err
+	}
+	fields := map[string]int64{}
+	for line := range strings.SplitSeq(out, "\n") {
+		k, v, _ := strings.Cut(line, ":")
+		if k == "Block count" || k == "Block size" {
+			if fields[k], err = strconv.ParseInt(strings.TrimSpace(v), 10, 64); err != nil {
+				return fmt.Errorf("parsing dumpe2fs %s %q", k, v)
+			}
+		}
+	}
+	minSize := fields["Block count"] * fields["Block size"]
+	if minSize == 0 {
+		return fmt.Errorf("dumpe2fs reported no ext4 geometry")
+	}
+
+	if size == 0 {
+		return os.Truncate(path, minSize)
+	}
+	if size < minSize {
+		return fmt.Errorf("-size-mib is below the %d MiB minimum size", minSize>>20)
+	}
+	if err := os.Truncate(path, size); err != nil {
+		return err
+	}
+	_, err = command(ctx, "resize2fs", path)
+	return err
+}
+
+// shrinkBtrfs shrinks the btrfs image at path to its minimum device size,
+// then grows it to size when set. btrfs resizes mounted filesystems only.
+func shrinkBtrfs(ctx context.Context, path string, size int64) error {
+	dir, err := os.MkdirTemp("", "sbx-shrink-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dir)
+
+	if _, err := command(ctx, "mount", "-o", "loop", path, dir); err != nil {
+		return err
+	}
+	mounted := true
+	defer func() {
+		if mounted {
+			_, _ = command(context.Background(), "umount", dir)
+		}
+	}()
+
+	out, err := command(ctx, "btrfs", "inspect-internal", "min-dev-size", dir)
+	if err != nil {
+		return err
+	}
+	fs := strings.Fields(out)
+	if len(fs) == 0 {
+		return fmt.Errorf("btrfs reported no minimum device size")
+	}
+	minSize, err := strconv.ParseInt(fs[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing btrfs minimum device size %q: %w", fs[0], err)
+	}
+	if size != 0 && size < minSize {
+		return fmt.Errorf("-size-mib is below the %d MiB minimum size", minSize>>20)
+	}
+	target := minSize
+	if size != 0 {
+		target = size
+	}
+
+	// Grown after the file, shrunk before it.
+	if target > minSize {
+		if err := os.Truncate(path, target); err != nil {
+			return err
+		}
+		if _, err := command(ctx, "losetup", "-c", loopDevice(ctx, path)); err != nil {
+			return err
+		}
+	}
+	if _, err := command(ctx, "btrfs", "filesystem", "resize", strconv.FormatInt(target, 10), dir); err != nil {
+		return err
+	}
+	if _, err := command(ctx, "umount", dir); err != nil {
+		return err
+	}
+	mounted = false
+	return os.Truncate(path, target)
+}
+
+// loopDevice returns the loop device backing the file at path.
+func loopDevice(ctx context.Context, path string) string {
+	out, _ := command(ctx, "losetup", "-j", path)
+	dev, _, _ := strings.Cut(out, ":")
+	return dev
+}
+
+// command runs a tool, returning its standard output. The tool output is
+// included in the error.
+func command(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.String(), fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return stdout.String(), fmt.Errorf("%s: %w", name, err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,172 @@
+// Command sign produces detached signatures for release artifacts.
+//
+// It signs every artifact referenced by manifest.json with the selected
+// backend, records the signature files and key fingerprint in the manifest,
+// and finally signs the updated manifest itself.
+//
+// Usage:
+//
+//	go run ./cmd/sign -backend gpg -key releases@example.com -build-dir build
+//	go run ./cmd/sign -backend minisign -key minisign.key -public-key minisign.pub -build-dir build
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/signer"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		backend      string
+		key          string
+		publicKey    string
+		buildDir     string
+		manifestPath string
+	)
+
+	flag.StringVar(&backend, "backend", "gpg", "Signing backend (gpg, minisign)")
+	flag.StringVar(&key, "key", "", "GPG key ID or minisign secret key path")
+	flag.StringVar(&publicKey, "public-key", "", "Minisign public key path")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+
+	s, err := signer.New(backend, signer.Options{
+		Key:       key,
+		PublicKey: publicKey,
+		Password:  os.Getenv("MINISIGN_PASSWORD"),
+	})
+	if err != nil {
+		return err
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+
+	ctx := context.Background()
+	fingerprint, err := s.Fingerprint(ctx)
+	if err != nil {
+		return fmt.Errorf("resolving key fingerprint: %w", err)
+	}
+
+	archs := make([]string, 0, len(m.Artifacts))
+	for arch := range m.Artifacts {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	for _, arch := range archs {
+		a := m.Artifacts[arch]
+
+		for _, k := range a.Kernels() {
+			k.Signature, err = signFile(ctx, s, buildDir, k.File)
+			if err != nil {
+				return fmt.Errorf("kernel artifact %s: %w", k.File, err)
+			}
+			for i, img := range k.Images {
+				k.Images[i].Signature, err = signFile(ctx, s, buildDir, img.File)
+				if err != nil {
+					return fmt.Errorf("kernel artifact %s: %w", img.File, err)
+				}
+			}
+
+			if k.Modules.Shipped() {
+				k.Modules.Signature, err = signFile(ctx, s, buildDir, k.Modules.File)
+				if err != nil {
+					return fmt.Errorf("kernel modules %s: %w", k.Modules.File, err)
+				}
+			}
+		}
+
+		for _, r := range a.Rootfses() {
+			r.Signature, err = signFile(ctx, s, buildDir, r.File)
+			if err != nil {
+				return fmt.Errorf("rootfs artifact %s: %w", r.File, err)
+			}
+			for _, img := range r.ExtraImages() {
+				img.Signature, err = signFile(ctx, s, buildDir, img.File)
+				if err != nil {
+					return fmt.Errorf("rootfs artifact %s: %w", img.File, err)
+				}
+			}
+		}
+
+		if a.Initramfs != nil {
+			a.Initramfs.Signature, err = signFile(ctx, s, buildDir, a.Initramfs.File)
+			if err != nil {
+				return fmt.Errorf("initramfs artifact for %s: %w", arch, err)
+			}
+		}
+
+		for _, b := range a.Firecracker.Binaries() {
+			b.Signature, err = signFile(ctx, s, buildDir, b.File)
+			if err != nil {
+				return fmt.Errorf("firecracker binary %s: %w", b.File, err)
+			}
+		}
+
+		m.Artifacts[arch] = a
+	}
+
+	for i, d := range m.Disks {
+		m.Disks[i].Signature, err = signFile(ctx, s, buildDir, d.File)
+		if err != nil {
+			return fmt.Errorf("data disk %s: %w", d.File, err)
+		}
+	}
+
+	m.Signing = &manifest.Signing{
+		Backend:        s.Backend(),
+		KeyFingerprint: fingerprint,
+	}
+
+	if err := manifest.Write(manifestPath, m); err != nil {
+		return err
+	}
+
+	sigPath, err := s.Sign(ctx, manifestPath)
+	if err != nil {
+		return fmt.Errorf("signing manifest: %w", err)
+	}
+
+	slog.Info("Signed release", "backend", s.Backend(), "key", fingerprint)
+	slog.Info("Wrote manifest signature", "path", sigPath)
+	return nil
+}
+
+// signFile signs an artifact in the build dir and returns the signature file name.
+func signFile(ctx context.Context, s signer.Signer, buildDir, file string) (string, error) {
+	sigPath, err := s.Sign(ctx, filepath.Join(buildDir, file))
+	if err != nil {
+		return "", err
+	}
+	slog.Info("Signed artifact", "file", file)
+	return filepath.Base(sigPath), nil
+}
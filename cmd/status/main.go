@@ -0,0 +1,384 @@
+// Command status generates the signed release status document.
+//
+// It runs the release validation checks against the build dir (artifact
+// digests, signatures, step attestations, the vulnerability scan reports and
+// the boot-matrix reports), records each result with its timestamp in
+// status.json and signs the document. The release is validated only when
+// every required check passed; skipped checks leave it incomplete.
+//
+// Usage:
+//
+//	go run ./cmd/status -config config.yaml -build-dir build -backend gpg -key releases@example.com
+//	go run ./cmd/status -config config.yaml -build-dir build
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/attest"
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/scan"
+	"github.com/slok/sbx-images/pkg/signer"
+	"github.com/slok/sbx-images/pkg/status"
+	"github.com/slok/sbx-images/pkg/verify"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath   string
+		buildDir     string
+		manifestPath string
+		backend      string
+		key          string
+		publicKey    string
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	flag.StringVar(&backend, "backend", "gpg", "Signing backend (gpg, minisign)")
+	flag.StringVar(&key, "key", "", "GPG key ID or minisign secret key path (empty writes an unsigned document)")
+	flag.StringVar(&publicKey, "public-key", "", "Minisign public key path")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+
+	archs := make([]string, 0, len(m.Artifacts))
+	for arch := range m.Artifacts {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	ctx := context.Background()
+	c := checker{m: m, archs: archs, buildDir: buildDir, manifestPath: manifestPath, publicKey: publicKey}
+	checks := []status.Check{
+		c.digests(),
+		c.signatures(ctx),
+		c.attestations(),
+		c.scan(cfg.Scan),
+		c.boot(),
+	}
+	for _, ch := range checks {
+		fmt.Printf("%-8s %-13s %s\n", ch.Result, ch.Name, ch.Details)
+	}
+
+	doc := status.New(m.Version, m.Build.Commit, now(), checks)
+	path := filepath.Join(buildDir, status.FileName)
+
+	var s signer.Signer
+	if key != "" {
+		s, err = signer.New(backend, signer.Options{
+			Key:       key,
+			PublicKey: publicKey,
+			Password:  os.Getenv("MINISIGN_PASSWORD"),
+		})
+		if err != nil {
+			return err
+		}
+		fingerprint, err := s.Fingerprint(ctx)
+		if err != nil {
+			return fmt.Errorf("resolving key fingerprint: %w", err)
+		}
+		doc.Signing = &manifest.Signing{Backend: s.Backend(), KeyFingerprint: fingerprint}
+	}
+
+	if err := status.Write(path, doc); err != nil {
+		return err
+	}
+	slog.Info("Release status", "release", doc.Version, "status", doc.Status)
+	slog.Info("Wrote status", "path", path)
+
+	if s == nil {
+		slog.Warn("Status document is not signed")
+		return nil
+	}
+	sigPath, err := s.Sign(ctx, path)
+	if err != nil {
+		return fmt.Errorf("signing status: %w", err)
+	}
+	slog.Info("Wrote status signature", "path", sigPath)
+	return nil
+}
+
+type checker struct {
+	m            manifest.Manifest
+	archs        []string
+	buildDir     string
+	manifestPath string
+	publicKey    string
+}
+
+// digests verifies the artifacts against the manifest digests.
+func (c checker) digests() status.Check {
+	opts := verify.Options{Cache: verify.NewCache(c.buildDir)}
+	n := 0
+	for _, arch := range c.archs {
+		a := c.m.Artifacts[arch]
+		var files [][2]string
+		for _, r := range a.Rootfses() {
+			files = append(files, [2]string{r.File, r.SHA256})
+			for _, img := range r.ExtraImages() {
+				files = append(files, [2]string{img.File, img.SHA256})
+			}
+		}
+		if a.Initramfs != nil {
+			files = append(files, [2]string{a.Initramfs.File, a.Initramfs.SHA256})
+		}
+		for _, b := range a.Firecracker.Binaries() {
+			files = append(files, [2]string{b.File, b.SHA256})
+		}
+		for _, k := range a.Kernels() {
+			files = append(files, [2]string{k.File, k.SHA256})
+			for _, img := range k.Images {
+				files = append(files, [2]string{img.File, img.SHA256})
+			}
+			if k.Config != "" {
+				files = append(files, [2]string{k.Config, k.ConfigSHA256})
+			}
+			if k.Modules.Shipped() {
+				files = append(files, [2]string{k.Modules.File, k.Modules.SHA256})
+			}
+		}
+		for _, f := range files {
+			if _, err := verify.File(filepath.Join(c.buildDir, f[0]), f[1], opts); err != nil {
+				return failed("digests", fmt.Errorf("%s artifacts: %w", arch, err))
+			}
+			n++
+		}
+	}
+	for _, d := range c.m.Disks {
+		if _, err := verify.File(filepath.Join(c.buildDir, d.File), d.SHA256, opts); err != nil {
+			return failed("digests", fmt.Errorf("data disks: %w", err))
+		}
+		n++
+	}
+	return passed("digests", now(), fmt.Sprintf("%d files match manifest.json", n))
+}
+
+// signatures verifies the artifact and manifest signatures.
+func (c checker) signatures(ctx context.Context) status.Check {
+	if c.m.Signing == nil {
+		return skipped("signatures", "release is not signed")
+	}
+
+	opts := signer.VerifyOptions{Fingerprint: c.m.Signing.KeyFingerprint, PublicKey: c.publicKey}
+	files := [][2]string{{c.manifestPath, signer.SignatureFile(c.m.Signing.Backend, c.manifestPath)}}
+	for _, arch := range c.archs {
+		a := c.m.Artifacts[arch]
+		var signed [][2]string
+		for _, r := range a.Rootfses() {
+			signed = append(signed, [2]string{r.File, r.Signature})
+			for _, img := range r.ExtraImages() {
+				signed = append(signed, [2]string{img.File, img.Signature})
+			}
+		}
+		if a.Initramfs != nil {
+			signed = append(signed, [2]string{a.Initramfs.File, a.Initramfs.Signature})
+		}
+		for _, b := range a.Firecracker.Binaries() {
+			signed = append(signed, [2]string{b.File, b.Signature})
+		}
+		for _, k := range a.Kernels() {
+			signed = append(signed, [2]string{k.File, k.Signature})
+			for _, img := range k.Images {
+				signed = append(signed, [2]string{img.File, img.Signature})
+			}
+			if k.Modules.Shipped() {
+				signed = append(signed, [2]string{k.Modules.File, k.Modules.Signature})
+			}
+		}
+		for _, f := range signed {
+			if f[1] == "" {
+				return failed("signatures", fmt.Errorf("%s has no signature in a signed release", f[0]))
+			}
+			files = append(files, [2]string{filepath.Join(c.buildDir, f[0]), filepath.Join(c.buildDir, f[1])})
+		}
+	}
+	for _, d := range c.m.Disks {
+		if d.Signature == "" {
+			return failed("signatures", fmt.Errorf("%s has no signature in a signed release", d.File))
+		}
+		files = append(files, [2]string{filepath.Join(c.buildDir, d.File), filepath.Join(c.buildDir, d.Signature)})
+	}
+	for _, f := range files {
+		if err := signer.Verify(ctx, c.m.Signing.Backend, opts, f[0], f[1]); err != nil {
+			return failed("signatures", fmt.Errorf("verifying signature of %s: %w", filepath.Base(f[0]), err))
+		}
+	}
+	return passed("signatures", now(), fmt.Sprintf("%d %s signatures by %s", len(files), c.m.Signing.Backend, c.m.Signing.KeyFingerprint))
+}
+
+// attestations verifies the build step attestations against the manifest.
+func (c checker) attestations() status.Check {
+	sts, err := attest.ReadDir(c.buildDir)
+	if err != nil {
+		return failed("attestations", err)
+	}
+	if len(sts) == 0 {
+		return skipped("attestations", "no attestations found")
+	}
+
+	// Step products are recorded relative to the repository root, as in
+	// `attest verify`.
+	expected := map[string]string{}
+	for _, a := range c.m.Artifacts {
+		for _, k := range a.Kernels() {
+			expected[filepath.ToSlash(filepath.Join(c.buildDir, k.File))] = k.SHA256
+			for _, img := range k.Images {
+				expected[filepath.ToSlash(filepath.Join(c.buildDir, img.File))] = img.SHA256
+			}
+		}
+		for _, r := range a.Rootfses() {
+			expected[filepath.ToSlash(filepath.Join(c.buildDir, r.File))] = r.SHA256
+			for _, img := range r.ExtraImages() {
+				expected[filepath.ToSlash(filepath.Join(c.buildDir, img.File))] = img.SHA256
+			}
+		}
+		if a.Initramfs != nil {
+			expected[filepath.ToSlash(filepath.Join(c.buildDir, a.Initramfs.File))] = a.Initramfs.SHA256
+		}
+		for _, b := range a.Firecracker.Binaries() {
+			expected[filepath.ToSlash(filepath.Join(c.buildDir, b.File))] = b.SHA256
+		}
+	}
+	for _, d := range c.m.Disks {
+		expected[filepath.ToSlash(filepath.Join(c.buildDir, d.File))] = d.SHA256
+	}
+	if err := attest.Verify(sts, "", expected); err != nil {
+		return failed("attestations", err)
+	}
+	return passed("attestations", now(), fmt.Sprintf("%d attested steps", len(sts)))
+}
+
+// scan checks the vulnerability reports against the scan threshold.
+func (c checker) scan(cfg config.Scan) status.Check {
+	var (
+		details []string
+		date    string
+	)
+	for _, arch := range c.archs {
+		a := c.m.Artifacts[arch]
+		for i, rootfs := range a.Rootfses() {
+			name := arch
+			if i > 0 {
+				name = fmt.Sprintf("%s/%s", arch, rootfs.Profile)
+			}
+			if rootfs.Vulnerabilities == "" {
+				return skipped("scan", fmt.Sprintf("no vulnerability report for %s", name))
+			}
+			r, err := scan.Read(filepath.Join(c.buildDir, rootfs.Vulnerabilities))
+			if err != nil {
+				return failed("scan", err)
+			}
+			date = max(date, r.Date)
+
+			if cfg.FailOn == "" {
+				details = append(details, fmt.Sprintf("%s: %d findings", name, len(r.Findings)))
+				continue
+			}
+			if blocking := scan.AtOrAbove(r.Findings, cfg.FailOn, cfg.Ignore); len(blocking) > 0 {
+				return failed("scan", fmt.Errorf("%s: %d findings at or above %s", name, len(blocking), cfg.FailOn))
+			}
+			details = append(details, fmt.Sprintf("%s: no findings at or above %s", name, cfg.FailOn))
+		}
+	}
+	return passed("scan", date, strings.Join(details, ", "))
+}
+
+// bootReport is the part of a boot-matrix report the boot check needs.
+type bootReport struct {
+	Date    string `json:"date"`
+	Results []struct {
+		BootArgs string `json:"boot_args"`
+		Booted   bool   `json:"booted"`
+	} `json:"results"`
+}
+
+// boot checks every boot-matrix combination reached userspace.
+func (c checker) boot() status.Check {
+	var (
+		details []string
+		date    string
+	)
+	for _, arch := range c.archs {
+		a := c.m.Artifacts[arch]
+		for _, k := range a.Kernels() {
+			name := arch
+			if k.Flavor != "" {
+				name = fmt.Sprintf("%s/%s", arch, k.Flavor)
+			}
+			data, err := os.ReadFile(filepath.Join(c.buildDir, manifest.FlavorName("boot-matrix", k.Flavor, arch)+".json"))
+			if errors.Is(err, os.ErrNotExist) {
+				return skipped("boot", fmt.Sprintf("no boot-matrix report for %s", name))
+			}
+			if err != nil {
+				return failed("boot", err)
+			}
+			var r bootReport
+			if err := json.Unmarshal(data, &r); err != nil {
+				return failed("boot", fmt.Errorf("parsing boot-matrix report for %s: %w", name, err))
+			}
+			date = max(date, r.Date)
+
+			for _, res := range r.Results {
+				if !res.Booted {
+					return failed("boot", fmt.Errorf("%s: %q did not boot", name, res.BootArgs))
+				}
+			}
+			details = append(details, fmt.Sprintf("%s: %d combinations booted", name, len(r.Results)))
+		}
+	}
+	return passed("boot", date, strings.Join(details, ", "))
+}
+
+func passed(name, date, details string) status.Check {
+	return status.Check{Name: name, Result: status.Passed, Date: date, Details: details}
+}
+
+func failed(name string, err error) status.Check {
+	return status.Check{Name: name, Result: status.Failed, Date: now(), Details: err.Error()}
+}
+
+func skipped(name, details string) status.Check {
+	return status.Check{Name: name, Result: status.Skipped, Date: now(), Details: details}
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,255 @@
+// Command tuf manages TUF metadata for the release files.
+//
+// It generates role keys, publishes signed root/targets/snapshot/timestamp
+// metadata covering manifest.json and every file it references, refreshes
+// the timestamp, and verifies downloaded files as a TUF client would.
+//
+// Usage:
+//
+//	go run ./cmd/tuf keygen -keys-dir tuf-keys
+//	go run ./cmd/tuf publish -keys-dir tuf-keys -build-dir build -repo-dir build/tuf
+//	go run ./cmd/tuf timestamp -keys-dir tuf-keys -repo-dir build/tuf
+//	go run ./cmd/tuf verify -repo https://example.com/tuf -state-dir ~/.cache/sbx/tuf -root root.json -target manifest.json -file manifest.json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/tuf"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	if len(os.Args) < 2 {
+		return fmt.Errorf("usage: tuf <keygen|publish|timestamp|verify> [flags]")
+	}
+
+	switch os.Args[1] {
+	case "keygen":
+		return keygen(os.Args[2:])
+	case "publish":
+		return publish(os.Args[2:])
+	case "timestamp":
+		return timestamp(os.Args[2:])
+	case "verify":
+		return verify(os.Args[2:])
+	default:
+		return fmt.Errorf("unknown subcommand %q (expected keygen, publish, timestamp or verify)", os.Args[1])
+	}
+}
+
+func keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	keysDir := fs.String("keys-dir", "tuf-keys", "Directory to write the role keys to")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*keysDir, 0o700); err != nil {
+		return fmt.Errorf("creating %s: %w", *keysDir, err)
+	}
+	for _, role := range tuf.Roles {
+		path := keyPath(*keysDir, role)
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+
+		k, err := tuf.GenerateKey()
+		if err != nil {
+			return err
+		}
+		if err := tuf.WriteKey(path, k); err != nil {
+			return err
+		}
+		slog.Info("Wrote key", "role", role, "id", k.ID, "path", path)
+	}
+	return nil
+}
+
+func publish(args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	var (
+		keysDir      string
+		buildDir     string
+		repoDir      string
+		manifestPath string
+		prevRootKey  string
+	)
+	fs.StringVar(&keysDir, "keys-dir", "tuf-keys", "Directory holding the role keys")
+	fs.StringVar(&buildDir, "build-dir", "build", "Path to build output directory")
+	fs.StringVar(&repoDir, "repo-dir", "", "Directory holding the TUF metadata (default: <build-dir>/tuf)")
+	fs.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	fs.StringVar(&prevRootKey, "previous-root-key", "", "Previous root key, required when rotating the root key")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if repoDir == "" {
+		repoDir = filepath.Join(buildDir, "tuf")
+	}
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+
+	keys, err := loadKeys(keysDir, tuf.Roles...)
+	if err != nil {
+		return err
+	}
+	opts := tuf.PublishOptions{Dir: repoDir, Keys: keys}
+	if prevRootKey != "" {
+		if opts.PreviousRootKey, err = tuf.ReadKey(prevRootKey); err != nil {
+			return err
+		}
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+	opts.Targets = releaseTargets(m, manifestPath, buildDir)
+
+	if err := tuf.Publish(opts); err != nil {
+		return fmt.Errorf("publishing metadata: %w", err)
+	}
+
+	slog.Info("Wrote TUF metadata", "dir", repoDir, "targets", len(opts.Targets))
+	return nil
+}
+
+// releaseTargets returns manifest.json and every file it references.
+func releaseTargets(m manifest.Manifest, manifestPath, buildDir string) map[string]string {
+	targets := map[string]string{"manifest.json": manifestPath}
+	for _, name := range m.Files() {
+		targets[name] = filepath.Join(buildDir, name)
+	}
+	return targets
+}
+
+func timestamp(args []string) error {
+	fs := flag.NewFlagSet("timestamp", flag.ExitOnError)
+	keysDir := fs.String("keys-dir", "tuf-keys", "Directory holding the role keys")
+	repoDir := fs.String("repo-dir", "build/tuf", "Directory holding the TUF metadata")
+	expires := fs.Duration("expires", tuf.DefaultExpires[tuf.RoleTimestamp], "Timestamp metadata lifetime")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	keys, err := loadKeys(*keysDir, tuf.RoleTimestamp)
+	if err != nil {
+		return err
+	}
+	if err := tuf.RefreshTimestamp(*repoDir, keys[tuf.RoleTimestamp], *expires, time.Time{}); err != nil {
+		return fmt.Errorf("refreshing timestamp: %w", err)
+	}
+
+	slog.Info("Refreshed timestamp", "path", filepath.Join(*repoDir, "timestamp.json"))
+	return nil
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		repo     string
+		stateDir string
+		rootPath string
+		target   string
+		file     string
+	)
+	fs.StringVar(&repo, "repo", "", "TUF repository URL or local directory")
+	fs.StringVar(&stateDir, "state-dir", "", "Directory holding the trusted metadata")
+	fs.StringVar(&rootPath, "root", "", "Trusted root.json used to initialize an empty state dir")
+	fs.StringVar(&target, "target", "manifest.json", "Target name to verify")
+	fs.StringVar(&file, "file", "", "Local file to verify (default: the target name)")
+	logFlags := logging.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if repo == "" || stateDir == "" {
+		return fmt.Errorf("-repo and -state-dir are required")
+	}
+	if file == "" {
+		file = target
+	}
+
+	if _, err := os.Stat(filepath.Join(stateDir, "root.json")); errors.Is(err, os.ErrNotExist) {
+		if rootPath == "" {
+			return fmt.Errorf("no trusted root in %s, -root is required", stateDir)
+		}
+		if err := tuf.Bootstrap(stateDir, rootPath); err != nil {
+			return fmt.Errorf("initializing trusted state: %w", err)
+		}
+	}
+
+	var fetcher tuf.Fetcher = tuf.DirFetcher{Dir: repo}
+	if strings.HasPrefix(repo, "http://") || strings.HasPrefix(repo, "https://") {
+		fetcher = tuf.HTTPFetcher{BaseURL: repo}
+	}
+
+	client, err := tuf.NewClient(fetcher, stateDir)
+	if err != nil {
+		return err
+	}
+	if err := client.Update(context.Background()); err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", file, err)
+	}
+	defer f.Close()
+
+	if err := client.VerifyTarget(target, f); err != nil {
+		return err
+	}
+
+	slog.Info("Verified against TUF target", "file", file, "target", target)
+	return nil
+}
+
+func loadKeys(dir string, roles ...string) (map[string]*tuf.PrivateKey, error) {
+	keys := map[string]*tuf.PrivateKey{}
+	for _, role := range roles {
+		k, err := tuf.ReadKey(keyPath(dir, role))
+		if err != nil {
+			return nil, fmt.Errorf("loading %s key: %w", role, err)
+		}
+		keys[role] = k
+	}
+	return keys, nil
+}
+
+func keyPath(dir, role string) string {
+	return filepath.Join(dir, role+".key.json")
+}
@@ -0,0 +1,81 @@
+// Command usage aggregates image usage logs into per-version statistics.
+//
+// It reads JSON lines usage logs (as written by the usage reporters) and
+// prints how often and how recently each image version was booted, so old
+// releases can be pruned from mirrors once nobody uses them.
+//
+// Usage:
+//
+//	go run ./cmd/usage -log usage.jsonl -stale-after 720h
+//	go run ./cmd/usage -log usage.jsonl -o json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/usage"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		logPaths   string
+		staleAfter time.Duration
+	)
+
+	flag.StringVar(&logPaths, "log", "", "Comma separated usage JSON lines log paths")
+	flag.DurationVar(&staleAfter, "stale-after", 0, "Only list versions not booted within this duration (e.g. 720h)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	if logPaths == "" {
+		return fmt.Errorf("-log is required")
+	}
+
+	var events []usage.Event
+	for path := range strings.SplitSeq(logPaths, ",") {
+		e, err := usage.ReadLog(strings.TrimSpace(path))
+		if err != nil {
+			return fmt.Errorf("loading usage log: %w", err)
+		}
+		events = append(events, e...)
+	}
+
+	summary := usage.Summarize(events)
+	if staleAfter > 0 {
+		summary = usage.Stale(summary, time.Now().Add(-staleAfter))
+	}
+
+	if outFlags.JSON() {
+		return output.Print(summary)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tBOOTS\tHOSTS\tLAST SEEN")
+	for _, u := range summary {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", u.Version, u.Boots, u.Hosts, u.LastSeen.UTC().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,197 @@
+// Command verify checks release artifacts against the digests recorded in
+// manifest.json.
+//
+// Verified digests are cached in a user.sbx.verified extended attribute (or a
+// sidecar state file when the filesystem lacks xattr support) keyed by file
+// size and modification time, so re-running verify on unchanged multi-GB
+// images skips rehashing. Use -paranoid to force a full rehash.
+//
+// Every kernel flavor is verified unless -flavor selects one, and every
+// architecture unless -arch selects one, for consumers that only downloaded
+// the kernel flavor they boot or the artifact of their architecture (oci
+// pull).
+//
+// With -o json the result of every file is printed to stdout as a JSON
+// document, also when some fail verification.
+//
+// Usage:
+//
+//	go run ./cmd/verify -build-dir build
+//	go run ./cmd/verify -build-dir build -paranoid
+//	go run ./cmd/verify -build-dir build -flavor full
+//	go run ./cmd/verify -build-dir release -arch x86_64
+//	go run ./cmd/verify -build-dir build -o json | jq -e '.failed == 0'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/output"
+	"github.com/slok/sbx-images/pkg/verify"
+)
+
+// Report is the JSON output of verify.
+type Report struct {
+	Manifest string       `json:"manifest"`
+	Files    []FileResult `json:"files"`
+	Failed   int          `json:"failed"`
+}
+
+// FileResult is the verification of an artifact file.
+type FileResult struct {
+	File     string `json:"file"`
+	Verified bool   `json:"verified"`
+	Cached   bool   `json:"cached,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		buildDir     string
+		manifestPath string
+		paranoid     bool
+		noCache      bool
+		flavor       string
+		arch         string
+	)
+
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to directory containing the artifacts")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	flag.BoolVar(&paranoid, "paranoid", false, "Rehash every file, ignoring cached verifications")
+	flag.BoolVar(&noCache, "no-cache", false, "Do not read or record cached verifications")
+	flag.StringVar(&flavor, "flavor", "", "Only verify the kernel of this flavor (default: every flavor)")
+	flag.StringVar(&arch, "arch", "", "Only verify the artifacts of this architecture (default: every architecture)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	outFlags := output.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	if err := outFlags.Validate(); err != nil {
+		return err
+	}
+
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+
+	opts := verify.Options{Paranoid: paranoid}
+	if !noCache {
+		opts.Cache = verify.NewCache(buildDir)
+	}
+
+	archs := make([]string, 0, len(m.Artifacts))
+	for a := range m.Artifacts {
+		if arch == "" || a == arch {
+			archs = append(archs, a)
+		}
+	}
+	if len(archs) == 0 {
+		return fmt.Errorf("no %s artifacts in manifest", arch)
+	}
+	sort.Strings(archs)
+
+	report := Report{Manifest: manifestPath, Files: []FileResult{}}
+	// code is the exit code of the first failure.
+	code := exitcode.OK
+	check := func(file, sha256 string) {
+		res, err := verify.File(filepath.Join(buildDir, file), sha256, opts)
+		if err != nil {
+			slog.Error("Verification failed", "file", file, "err", err)
+			if code == exitcode.OK {
+				code = exitcode.Of(err)
+			}
+			report.Files = append(report.Files, FileResult{File: file, Error: err.Error()})
+			report.Failed++
+			return
+		}
+		slog.Info("Verified", "file", file, "cached", res.Cached)
+		report.Files = append(report.Files, FileResult{File: file, Verified: true, Cached: res.Cached})
+	}
+	for _, arch := range archs {
+		a := m.Artifacts[arch]
+		kernels := a.Kernels()
+		if flavor != "" {
+			k, ok := a.KernelFlavor(flavor)
+			if !ok {
+				return fmt.Errorf("no %s kernel flavor for %s in manifest", flavor, arch)
+			}
+			kernels = []*manifest.KernelArtifact{&k}
+		}
+
+		var (
+			files       []struct{ file, sha256 string }
+			postProcess []*manifest.PostProcess
+		)
+		for _, k := range kernels {
+			files = append(files, struct{ file, sha256 string }{k.File, k.SHA256})
+			for _, img := range k.Images {
+				files = append(files, struct{ file, sha256 string }{img.File, img.SHA256})
+			}
+			if k.Config != "" {
+				files = append(files, struct{ file, sha256 string }{k.Config, k.ConfigSHA256})
+			}
+			if k.Modules.Shipped() {
+				files = append(files, struct{ file, sha256 string }{k.Modules.File, k.Modules.SHA256})
+			}
+			postProcess = append(postProcess, k.PostProcess)
+		}
+		for _, r := range a.Rootfses() {
+			files = append(files, struct{ file, sha256 string }{r.File, r.SHA256})
+			for _, img := range r.ExtraImages() {
+				files = append(files, struct{ file, sha256 string }{img.File, img.SHA256})
+			}
+			postProcess = append(postProcess, r.PostProcess)
+		}
+		if a.Initramfs != nil {
+			files = append(files, struct{ file, sha256 string }{a.Initramfs.File, a.Initramfs.SHA256})
+		}
+		for _, b := range a.Firecracker.Binaries() {
+			files = append(files, struct{ file, sha256 string }{b.File, b.SHA256})
+		}
+		for _, pp := range postProcess {
+			if pp == nil {
+				continue
+			}
+			for _, f := range pp.Files {
+				files = append(files, struct{ file, sha256 string }{f.File, f.SHA256})
+			}
+		}
+		for _, f := range files {
+			check(f.file, f.sha256)
+		}
+	}
+	for _, d := range m.Disks {
+		check(d.File, d.SHA256)
+	}
+
+	if outFlags.JSON() {
+		if err := output.Print(report); err != nil {
+			return err
+		}
+	}
+	if report.Failed > 0 {
+		return exitcode.Wrap(code, fmt.Errorf("%d artifact(s) failed verification", report.Failed))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Command vmconfig generates a ready to use Firecracker VM configuration
+// (firecracker --config-file) booting a release kernel and rootfs image,
+// from the manifest, see pkg/vmconfig.
+//
+// The kernel flavor and rootfs profile are the defaults of the manifest
+// unless -flavor and -profile select others, -filesystem selects another
+// rootfs image (e.g. squashfs). The rootfs paired with an overlay disk or a
+// dm-verity hash tree get their drives and boot args; the overlay disk is
+// the VM's own copy of the template, -overlay, made (sparse) when missing.
+// -tap attaches a network interface and -vsock-cid a vsock device. The
+// config is printed to stdout unless -out is set.
+//
+// Usage:
+//
+//	go run ./cmd/vmconfig -build-dir build > vm.json && firecracker --no-api --config-file vm.json
+//	go run ./cmd/vmconfig -build-dir release -arch aarch64 -profile minimal -vcpus 4 -mem-mib 4096 -out vm.json
+//	go run ./cmd/vmconfig -build-dir release -tap tap0 -mac 06:00:ac:10:00:02 -vsock-cid 3 -vsock-uds /tmp/vm.vsock
+//	go run ./cmd/vmconfig -build-dir release -profile readonly -overlay /var/lib/vms/vm1/overlay.ext4
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/export"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/preflight"
+	"github.com/slok/sbx-images/pkg/progress"
+	"github.com/slok/sbx-images/pkg/sparse"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		buildDir     string
+		manifestPath string
+		arch         string
+		flavor       string
+		profile      string
+		out          string
+		vsockCID     uint
+		opts         vmconfig.Options
+	)
+
+	flag.StringVar(&buildDir, "build-dir", "build", "Path to the build (or release) directory")
+	flag.StringVar(&manifestPath, "manifest", "", "Path to manifest.json (default: <build-dir>/manifest.json)")
+	flag.StringVar(&arch, "arch", preflight.HostArch(), "Architecture to boot")
+	flag.StringVar(&flavor, "flavor", "", "Kernel flavor to boot (default: the default kernel)")
+	flag.StringVar(&profile, "profile", "", "Rootfs profile to boot (default: the default rootfs)")
+	flag.StringVar(&opts.Filesystem, "filesystem", "", "Rootfs image filesystem to boot (default: the overlay or verity root, ext4 otherwise)")
+	flag.StringVar(&opts.Overlay, "overlay", "", "Path of the VM's copy of the overlay disk template, copied when missing (required for the rootfs with an overlay)")
+	flag.IntVar(&opts.VCPUs, "vcpus", vmconfig.DefaultVCPUs, "Guest vCPUs")
+	flag.IntVar(&opts.MemMiB, "mem-mib", vmconfig.DefaultMemMiB, "Guest memory (MiB)")
+	flag.StringVar(&opts.TapDevice, "tap", "", "Host tap device of the guest network interface (default: no network)")
+	flag.StringVar(&opts.GuestMAC, "mac", "", "Guest MAC address of the network interface (default: Firecracker's)")
+	flag.UintVar(&vsockCID, "vsock-cid", 0, "Guest context ID of the vsock device, 3 or higher (default: no vsock)")
+	flag.StringVar(&opts.VsockUDSPath, "vsock-uds", "vsock.sock", "Host Unix socket of the vsock device")
+	flag.BoolVar(&opts.Entropy, "entropy", false, "Attach a virtio-rng entropy device")
+	flag.StringVar(&out, "out", "", "Path to write the config to (default: stdout)")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+	opts.VsockCID = uint32(vsockCID)
+
+	if manifestPath == "" {
+		manifestPath = filepath.Join(buildDir, "manifest.json")
+	}
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %w", err)
+	}
+	src, err := export.Select(buildDir, m, arch, flavor, profile)
+	if err != nil {
+		return exitcode.Wrap(exitcode.MissingArtifact, err)
+	}
+
+	cfg, err := vmconfig.ForRelease(buildDir, m.Artifacts[arch], arch, src.Kernel, src.Rootfs, opts)
+	if err != nil {
+		return exitcode.Wrap(exitcode.Usage, err)
+	}
+	if o := src.Rootfs.Overlay; o != nil {
+		if err := copyOverlay(src.Path(o.File), opts.Overlay); err != nil {
+			return fmt.Errorf("copying overlay disk template: %w", err)
+		}
+	}
+
+	if out != "" {
+		if err := vmconfig.Write(out, cfg); err != nil {
+			return err
+		}
+		slog.Info("Wrote VM config", "path", out, "kernel", cfg.BootSource.KernelImagePath, "rootfs", cfg.Drives[0].PathOnHost)
+		return nil
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling VM config: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// copyOverlay makes the VM's copy dst of the overlay disk template src,
+// keeping its holes, unless it exists: the copy holds the VM writes.
+func copyOverlay(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	p := progress.New("copying "+filepath.Base(src), info.Size())
+	defer p.Done()
+	if _, err := sparse.Copy(out, p.Reader(in)); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	slog.Info("Copied overlay disk template", "template", src, "path", dst)
+	return nil
+}
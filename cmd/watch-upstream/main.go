@@ -0,0 +1,112 @@
+// Command watch-upstream reports which version pins of config.yaml are
+// stale.
+//
+// It queries the Firecracker GitHub releases, the firecracker-ci kernel
+// bucket (ci versions and the kernels of each pinned ci_version, for every
+// architecture) and the Alpine release branches, and compares them with the
+// pinned firecracker.version, kernel.ci_version, kernel versions of the
+// downloaded kernel flavors and rootfs.distro_version. The report (JSON or
+// Markdown) is meant to drive an automated bump workflow with cmd/bump.
+//
+// GH_TOKEN (or GITHUB_TOKEN) raises the GitHub API rate limit.
+//
+// Usage:
+//
+//	go run ./cmd/watch-upstream -config config.yaml
+//	go run ./cmd/watch-upstream -config config.yaml -format markdown -fail-stale
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/logging"
+	"github.com/slok/sbx-images/pkg/releases"
+	"github.com/slok/sbx-images/pkg/upstream"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("failed", "err", err)
+		os.Exit(exitcode.Of(err))
+	}
+}
+
+func run() error {
+	var (
+		configPath string
+		format     string
+		output     string
+		failStale  bool
+	)
+
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to config.yaml")
+	flag.StringVar(&format, "format", "json", "Output format (json, markdown)")
+	flag.StringVar(&output, "output", "", "Output file path (default: stdout)")
+	flag.BoolVar(&failStale, "fail-stale", false, "Exit with an error when any pin is stale")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.Setup(); err != nil {
+		return err
+	}
+
+	var write func(io.Writer, []upstream.Pin) error
+	switch format {
+	case "json":
+		write = upstream.WriteJSON
+	case "markdown":
+		write = upstream.WriteMarkdown
+	default:
+		return fmt.Errorf("unknown format %q (supported: json, markdown)", format)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	src := upstream.HTTPSources{
+		GitHub: releases.Client{Token: githubToken()},
+	}
+	pins, err := upstream.Check(context.Background(), cfg, src)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(w, pins); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	stale := 0
+	for _, p := range pins {
+		if p.Stale {
+			stale++
+		}
+	}
+	if failStale && stale > 0 {
+		return fmt.Errorf("%d stale version pins", stale)
+	}
+	return nil
+}
+
+func githubToken() string {
+	if t := os.Getenv("GH_TOKEN"); t != "" {
+		return t
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
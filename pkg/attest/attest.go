@@ -0,0 +1,247 @@
+// Package attest records and verifies in-toto link attestations for the
+// individual build steps (kernel fetch, rootfs build...).
+//
+// Each step attestation is an in-toto statement whose subjects are the step
+// products and whose link predicate records the command and the digests of
+// the materials it consumed, so a policy engine can check that every
+// artifact was produced by the expected chain of steps.
+package attest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/provenance"
+)
+
+// PredicateType is the in-toto link predicate type.
+const PredicateType = "https://in-toto.io/attestation/link/v0.3"
+
+// FileSuffix is appended to the step name to form the attestation file name.
+const FileSuffix = ".link.json"
+
+// Statement is an in-toto statement carrying a link predicate.
+type Statement struct {
+	Type          string               `json:"_type"`
+	Subject       []provenance.Subject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     Link                 `json:"predicate"`
+}
+
+// Link describes a single executed build step.
+type Link struct {
+	Name        string                          `json:"name"`
+	Command     []string                        `json:"command"`
+	Materials   []provenance.ResourceDescriptor `json:"materials"`
+	Byproducts  map[string]any                  `json:"byproducts,omitempty"`
+	Environment map[string]any                  `json:"environment,omitempty"`
+}
+
+// Step is a build step to run and attest.
+type Step struct {
+	Name      string
+	Command   []string
+	Materials []string
+	Products  []string
+	// BaseDir is the directory material and product names are relative to.
+	BaseDir     string
+	Environment map[string]any
+}
+
+// Run hashes the step materials, executes its command, hashes the produced
+// files and returns the resulting attestation.
+func Run(ctx context.Context, s Step, stdout, stderr io.Writer) (Statement, error) {
+	if len(s.Command) == 0 {
+		return Statement{}, fmt.Errorf("step %s: command is required", s.Name)
+	}
+	return Func(s, func() ([]string, error) {
+		cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		cmd.Stdin = os.Stdin
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("running command: %w", err)
+		}
+		return s.Products, nil
+	})
+}
+
+// Func is Run for a step done in process by fn, s.Command being the
+// command running it. fn returns the produced files, for steps whose
+// products are only known once they ran (e.g. the parts of a split).
+func Func(s Step, fn func() ([]string, error)) (Statement, error) {
+	if s.Name == "" {
+		return Statement{}, fmt.Errorf("step name is required")
+	}
+
+	materials, err := digestFiles(s.BaseDir, s.Materials)
+	if err != nil {
+		return Statement{}, fmt.Errorf("step %s: hashing materials: %w", s.Name, err)
+	}
+
+	names, err := fn()
+	if err != nil {
+		return Statement{}, fmt.Errorf("step %s: %w", s.Name, err)
+	}
+
+	products, err := digestFiles(s.BaseDir, names)
+	if err != nil {
+		return Statement{}, fmt.Errorf("step %s: hashing products: %w", s.Name, err)
+	}
+
+	subjects := make([]provenance.Subject, 0, len(products))
+	for _, p := range products {
+		subjects = append(subjects, provenance.Subject{Name: p.URI, Digest: p.Digest})
+	}
+
+	return Statement{
+		Type:          provenance.StatementType,
+		Subject:       subjects,
+		PredicateType: PredicateType,
+		Predicate: Link{
+			Name:        s.Name,
+			Command:     s.Command,
+			Materials:   materials,
+			Byproducts:  map[string]any{"return-value": 0},
+			Environment: s.Environment,
+		},
+	}, nil
+}
+
+// Write stores the attestation in dir as <step name>.link.json and returns
+// the file path.
+func Write(dir string, st Statement) (string, error) {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshaling attestation: %w", err)
+	}
+
+	path := filepath.Join(dir, st.Predicate.Name+FileSuffix)
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("writing attestation: %w", err)
+	}
+	return path, nil
+}
+
+// Read loads an attestation file.
+func Read(path string) (Statement, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Statement{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var st Statement
+	if err := json.Unmarshal(data, &st); err != nil {
+		return Statement{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if st.PredicateType != PredicateType {
+		return Statement{}, fmt.Errorf("%s: unexpected predicate type %q", path, st.PredicateType)
+	}
+	return st, nil
+}
+
+// ReadDir loads all attestation files in dir, sorted by step name.
+func ReadDir(dir string) ([]Statement, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+FileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	sts := make([]Statement, 0, len(paths))
+	for _, p := range paths {
+		st, err := Read(p)
+		if err != nil {
+			return nil, err
+		}
+		sts = append(sts, st)
+	}
+	return sts, nil
+}
+
+// Verify checks a set of step attestations against the files in baseDir:
+//
+//   - every product still matches the digest recorded by its step.
+//   - every material produced by another step matches that step's product,
+//     so the steps form a consistent chain.
+//   - every file in expected was produced by some step with that digest.
+//
+// expected maps file names to their SHA256 digest, typically taken from the
+// release manifest.
+func Verify(sts []Statement, baseDir string, expected map[string]string) error {
+	var problems []string
+	products := map[string]string{}
+	producer := map[string]string{}
+
+	for _, st := range sts {
+		for _, sub := range st.Subject {
+			sum := sub.Digest["sha256"]
+			if prev, ok := producer[sub.Name]; ok && products[sub.Name] != sum {
+				problems = append(problems, fmt.Sprintf("%s produced with different digests by %s and %s", sub.Name, prev, st.Predicate.Name))
+			}
+			products[sub.Name] = sum
+			producer[sub.Name] = st.Predicate.Name
+
+			_, got, err := digest.File(filepath.Join(baseDir, sub.Name))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", st.Predicate.Name, err))
+				continue
+			}
+			if got != sum {
+				problems = append(problems, fmt.Sprintf("%s: product %s digest mismatch (recorded %s, got %s)", st.Predicate.Name, sub.Name, sum, got))
+			}
+		}
+	}
+
+	for _, st := range sts {
+		for _, m := range st.Predicate.Materials {
+			want, ok := products[m.URI]
+			if ok && want != m.Digest["sha256"] {
+				problems = append(problems, fmt.Sprintf("%s: material %s does not match the product of %s", st.Predicate.Name, m.URI, producer[m.URI]))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		got, ok := products[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: not produced by any attested step", name))
+		case got != expected[name]:
+			problems = append(problems, fmt.Sprintf("%s: attested digest %s does not match expected %s", name, got, expected[name]))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("attestation verification failed:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func digestFiles(baseDir string, names []string) ([]provenance.ResourceDescriptor, error) {
+	descs := make([]provenance.ResourceDescriptor, 0, len(names))
+	for _, name := range names {
+		_, sum, err := digest.File(filepath.Join(baseDir, name))
+		if err != nil {
+			return nil, err
+		}
+		descs = append(descs, provenance.ResourceDescriptor{
+			URI:    filepath.ToSlash(name),
+			Digest: map[string]string{"sha256": sum},
+		})
+	}
+	return descs, nil
+}
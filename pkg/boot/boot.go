@@ -0,0 +1,298 @@
+// Package boot boots kernel and rootfs images under Firecracker and watches
+// the serial console, for smoke and compatibility testing of built images.
+package boot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+// DefaultBootArgs are the standard boot args of a Firecracker guest.
+const DefaultBootArgs = "console=ttyS0 reboot=k panic=1 pci=off"
+
+// CloudHypervisorBootArgs are the standard boot args of a Cloud Hypervisor
+// guest, its virtio devices being PCI ones.
+const CloudHypervisorBootArgs = "console=ttyS0 reboot=k panic=1"
+
+// QEMUMicrovmBootArgs are the standard boot args of a QEMU microvm guest,
+// rebooting through a triple fault QEMU exits on, with the root device
+// Firecracker otherwise adds.
+const QEMUMicrovmBootArgs = "console=ttyS0 reboot=t panic=1 pci=off root=/dev/vda rw"
+
+// DefaultReadyPattern matches console output proving the kernel handed over
+// to userspace: the kernel init message (hidden by quiet) or the first
+// messages of OpenRC and sbx-init.
+var DefaultReadyPattern = regexp.MustCompile(`Run \S+ as init process|OpenRC .* is starting up|sbx-init`)
+
+// initrdReadyPattern is the default ready pattern of guests booting an
+// initrd, the kernel init message then announces the initramfs /init.
+var initrdReadyPattern = regexp.MustCompile(`OpenRC .* is starting up|sbx-init`)
+
+// panicPattern matches fatal kernel errors, ending the boot early.
+var panicPattern = regexp.MustCompile(`Kernel panic - not syncing|VFS: Unable to mount root fs`)
+
+// agentCID is the guest context ID of the vsock device, the first one
+// available to guests, and vsockFile its host socket in the work dir.
+const (
+	agentCID  = 3
+	vsockFile = "vsock.sock"
+)
+
+// Options configures a single boot.
+type Options struct {
+	// Firecracker is the firecracker binary (default: "firecracker" from PATH).
+	Firecracker string
+	Kernel      string
+	Rootfs      string
+	// Initrd is the optional initramfs booted before the rootfs.
+	Initrd   string
+	BootArgs string
+	VCPUs    int
+	MemMiB   int
+	Timeout  time.Duration
+	// Ready matches the console output that marks a successful boot
+	// (default: DefaultReadyPattern, without the kernel init message when
+	// booting an initrd).
+	Ready *regexp.Regexp
+	// Entropy attaches a virtio-rng entropy device to the guest.
+	Entropy bool
+	// AgentPort, when set, attaches a vsock device and the boot succeeds
+	// once the guest agent accepts a connection on this vsock port, after
+	// the ready pattern matched.
+	AgentPort uint32
+}
+
+// Result is the outcome of a boot.
+type Result struct {
+	Booted   bool
+	Duration time.Duration
+	// Reason explains a failed boot (timeout, kernel panic, VMM exit...).
+	Reason string
+	// ReadyAt is the host time the ready pattern matched.
+	ReadyAt time.Time
+	// AgentDuration is the time the guest agent took to answer on
+	// Options.AgentPort, zero when not waited for.
+	AgentDuration time.Duration
+	Console       []byte
+}
+
+// Available checks the host can run Firecracker guests.
+func Available(firecracker string) error {
+	if firecracker == "" {
+		firecracker = "firecracker"
+	}
+	if _, err := exec.LookPath(firecracker); err != nil {
+		return fmt.Errorf("firecracker binary not found: %w", err)
+	}
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("KVM is not available: %w", err)
+	}
+	return f.Close()
+}
+
+// Run boots the images once and waits until the guest reaches userspace
+// (and its agent answers, with Options.AgentPort), panics, exits or the
+// timeout expires. The rootfs is attached read-only so
+// the image under test is never modified.
+func Run(ctx context.Context, opts Options) (Result, error) {
+	opts = withDefaults(opts)
+
+	workDir, err := os.MkdirTemp("", "sbx-boot-*")
+	if err != nil {
+		return Result{}, fmt.Errorf("creating work dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	cfgPath := filepath.Join(workDir, "vm.json")
+	if err := writeVMConfig(cfgPath, opts); err != nil {
+		return Result{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	console := &consoleWatcher{ready: opts.Ready, done: make(chan string, 1)}
+	cmd := exec.CommandContext(ctx, opts.Firecracker, "--no-api", "--config-file", cfgPath, "--level", "Error")
+	cmd.Dir = workDir
+	cmd.Stdout = console
+	cmd.Stderr = console
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return Result{}, fmt.Errorf("starting firecracker: %w", err)
+	}
+
+	exited := make(chan struct{})
+	var waitErr error
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+
+	res := Result{}
+	select {
+	case reason := <-console.done:
+		res.Duration = time.Since(start)
+		if reason == "" {
+			res.Booted = true
+			res.ReadyAt = start.Add(res.Duration)
+		} else {
+			res.Reason = reason
+		}
+		if res.Booted && opts.AgentPort != 0 {
+			if err := waitAgent(ctx, filepath.Join(workDir, vsockFile), opts.AgentPort, exited); err != nil {
+				res.Booted, res.Reason = false, err.Error()
+			} else {
+				res.AgentDuration = time.Since(start)
+			}
+		}
+		cancel()
+		<-exited
+	case <-exited:
+		res.Duration = time.Since(start)
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			res.Reason = fmt.Sprintf("timed out after %s", opts.Timeout)
+		case waitErr != nil:
+			res.Reason = fmt.Sprintf("firecracker exited before userspace: %v", waitErr)
+		default:
+			res.Reason = "guest shut down before userspace"
+		}
+	}
+
+	// A guest reaching userspace right before the VMM exits still counts,
+	// unless its agent had to answer.
+	select {
+	case reason := <-console.done:
+		if reason == "" && !res.Booted && opts.AgentPort == 0 {
+			res.Booted, res.Reason, res.ReadyAt = true, "", start.Add(res.Duration)
+		}
+	default:
+	}
+
+	res.Console = console.Bytes()
+	return res, nil
+}
+
+func withDefaults(opts Options) Options {
+	if opts.Firecracker == "" {
+		opts.Firecracker = "firecracker"
+	}
+	if opts.BootArgs == "" {
+		opts.BootArgs = DefaultBootArgs
+	}
+	if opts.VCPUs == 0 {
+		opts.VCPUs = 1
+	}
+	if opts.MemMiB == 0 {
+		opts.MemMiB = 256
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.Ready == nil {
+		opts.Ready = DefaultReadyPattern
+		if opts.Initrd != "" {
+			opts.Ready = initrdReadyPattern
+		}
+	}
+	return opts
+}
+
+func writeVMConfig(path string, opts Options) error {
+	kernel, err := filepath.Abs(opts.Kernel)
+	if err != nil {
+		return err
+	}
+	rootfs, err := filepath.Abs(opts.Rootfs)
+	if err != nil {
+		return err
+	}
+
+	cfg := vmconfig.Config{
+		BootSource: vmconfig.BootSource{
+			KernelImagePath: kernel,
+			BootArgs:        opts.BootArgs,
+		},
+		Drives: []vmconfig.Drive{{
+			DriveID:      "rootfs",
+			PathOnHost:   rootfs,
+			IsRootDevice: true,
+			IsReadOnly:   true,
+		}},
+		MachineConfig: vmconfig.MachineConfig{
+			VCPUCount:  opts.VCPUs,
+			MemSizeMiB: opts.MemMiB,
+		},
+	}
+
+	if opts.Initrd != "" {
+		if cfg.BootSource.InitrdPath, err = filepath.Abs(opts.Initrd); err != nil {
+			return err
+		}
+	}
+	if opts.Entropy {
+		cfg.Entropy = &vmconfig.Entropy{}
+	}
+	if opts.AgentPort != 0 {
+		cfg.Vsock = &vmconfig.Vsock{GuestCID: agentCID, UDSPath: filepath.Join(filepath.Dir(path), vsockFile)}
+	}
+
+	return vmconfig.Write(path, cfg)
+}
+
+// consoleWatcher buffers the serial console and reports once when the boot
+// succeeded (empty reason) or failed.
+type consoleWatcher struct {
+	ready *regexp.Regexp
+	done  chan string
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	reported bool
+}
+
+var _ io.Writer = (*consoleWatcher)(nil)
+
+func (w *consoleWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	if w.reported {
+		return len(p), nil
+	}
+
+	switch out := w.buf.Bytes(); {
+	case w.ready.Match(out):
+		w.report("")
+	case panicPattern.Match(out):
+		w.report("kernel panic: " + string(panicPattern.Find(out)))
+	}
+	return len(p), nil
+}
+
+func (w *consoleWatcher) report(reason string) {
+	w.reported = true
+	w.done <- reason
+}
+
+// Bytes returns the console output captured so far.
+func (w *consoleWatcher) Bytes() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return bytes.Clone(w.buf.Bytes())
+}
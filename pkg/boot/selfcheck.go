@@ -0,0 +1,113 @@
+package boot
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SelfCheckArg enables the sbx-selfcheck guest service, which reports the
+// guest clock and entropy state on the console.
+const SelfCheckArg = "sbx.selfcheck=1"
+
+// SelfCheckReadyPattern matches the end of the self check output.
+var SelfCheckReadyPattern = regexp.MustCompile(`SBX-SELFCHECK done`)
+
+const (
+	// MaxClockSkew is the largest guest to host wall clock difference
+	// considered synced.
+	MaxClockSkew = 2 * time.Second
+	// MaxEntropyDelay is the longest a guest may take to seed its CRNG, TLS
+	// handshakes block on it right after boot.
+	MaxEntropyDelay = 5 * time.Second
+)
+
+// SelfCheck is the guest clock and entropy state reported by sbx-selfcheck.
+type SelfCheck struct {
+	Clocksource string `json:"clocksource"`
+	PTPKVM      bool   `json:"ptp_kvm"`
+	HWRNG       string `json:"hwrng"`
+	// ClockSkewMS is the guest minus host wall clock difference, nil when the
+	// guest didn't report its clock.
+	ClockSkewMS *int64 `json:"clock_skew_ms,omitempty"`
+	// CRNGReadyMS is the time since boot the kernel CRNG was seeded, nil
+	// when it wasn't seen.
+	CRNGReadyMS  *int64 `json:"crng_ready_ms,omitempty"`
+	RandomReadMS int64  `json:"random_read_ms"`
+	// Complete is false when the guest output ended before "done".
+	Complete bool `json:"complete"`
+}
+
+// ParseSelfCheck parses the sbx-selfcheck console output. host is the host
+// wall clock when the output was captured.
+func ParseSelfCheck(console []byte, host time.Time) SelfCheck {
+	var sc SelfCheck
+	s := bufio.NewScanner(bytes.NewReader(console))
+	for s.Scan() {
+		_, rest, ok := strings.Cut(s.Text(), "SBX-SELFCHECK ")
+		if !ok {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(rest), "=")
+		switch key {
+		case "clocksource":
+			sc.Clocksource = value
+		case "ptp_kvm":
+			sc.PTPKVM = value == "1"
+		case "hwrng":
+			sc.HWRNG = value
+		case "crng_ready_ms":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				sc.CRNGReadyMS = &v
+			}
+		case "random_read_ms":
+			sc.RandomReadMS, _ = strconv.ParseInt(value, 10, 64)
+		case "epoch_ns":
+			if skew, ok := clockSkew(value, host); ok {
+				ms := skew.Milliseconds()
+				sc.ClockSkewMS = &ms
+			}
+		case "done":
+			sc.Complete = true
+		}
+	}
+	return sc
+}
+
+// clockSkew parses a `date +%s%N` value. Without nanosecond support the
+// guest date prints seconds followed by a literal "N" or "%N".
+func clockSkew(value string, host time.Time) (time.Duration, bool) {
+	digits := strings.TrimRight(value, "%N")
+	v, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	guest := time.Unix(0, v)
+	if len(digits) <= 11 {
+		guest = time.Unix(v, 0)
+	}
+	return guest.Sub(host), true
+}
+
+// Capabilities returns the self check as capability flags.
+func (sc SelfCheck) Capabilities() map[string]bool {
+	clockSynced := sc.ClockSkewMS != nil && time.Duration(abs(*sc.ClockSkewMS))*time.Millisecond <= MaxClockSkew
+	crngReady := sc.CRNGReadyMS != nil && time.Duration(*sc.CRNGReadyMS)*time.Millisecond <= MaxEntropyDelay
+	return map[string]bool{
+		"kvm_clock":     sc.Clocksource == "kvm-clock",
+		"ptp_kvm":       sc.PTPKVM,
+		"clock_synced":  clockSynced,
+		"virtio_rng":    strings.HasPrefix(sc.HWRNG, "virtio_rng"),
+		"entropy_ready": sc.Complete && crngReady,
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
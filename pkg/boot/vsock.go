@@ -0,0 +1,64 @@
+package boot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DialVsock connects to the guest vsock port through uds, the host Unix
+// socket of the Firecracker vsock device (the "CONNECT <port>" handshake).
+func DialVsock(ctx context.Context, uds string, port uint32) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", uds)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The reply is read byte by byte, the guest data following it.
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) == 0 || line[len(line)-1] != '\n' {
+		if _, err := conn.Read(b); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("vsock port %d: %w", port, err)
+		}
+		line = append(line, b[0])
+	}
+	if !strings.HasPrefix(string(line), "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("vsock port %d: %s", port, strings.TrimSpace(string(line)))
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// waitAgent dials the guest agent port until it accepts a connection, the
+// guest exits or ctx is done.
+func waitAgent(ctx context.Context, uds string, port uint32, exited <-chan struct{}) error {
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		dialCtx, cancel := context.WithTimeout(ctx, time.Second)
+		conn, err := DialVsock(dialCtx, uds, port)
+		cancel()
+		if err == nil {
+			return conn.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("agent not answering on vsock port %d: %w", port, err)
+		case <-exited:
+			return fmt.Errorf("guest exited before the agent answered on vsock port %d", port)
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package boot
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeVsock listens on a Unix socket answering the Firecracker vsock
+// handshake: OK for port, an error reply otherwise, then echoing.
+func fakeVsock(t *testing.T, port string) string {
+	t.Helper()
+	uds := filepath.Join(t.TempDir(), "vsock.sock")
+	l, err := net.Listen("unix", uds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line != "CONNECT "+port+"\n" {
+					io.WriteString(conn, "ERR connection refused\n")
+					return
+				}
+				io.WriteString(conn, "OK 1073741824\nhello\n")
+				io.Copy(conn, r)
+			}()
+		}
+	}()
+	return uds
+}
+
+func TestDialVsock(t *testing.T) {
+	uds := fakeVsock(t, "1024")
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	conn, err := DialVsock(ctx, uds, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The guest data following the handshake reply is not consumed.
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("got %q (%v), want the guest data", line, err)
+	}
+
+	if _, err := DialVsock(ctx, uds, 52); err == nil {
+		t.Error("expected an error for a refused port")
+	}
+}
+
+func TestWaitAgent(t *testing.T) {
+	uds := fakeVsock(t, "1024")
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	if err := waitAgent(ctx, uds, 1024, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan struct{})
+	close(exited)
+	if err := waitAgent(ctx, uds, 52, exited); err == nil {
+		t.Error("expected an error once the guest exited")
+	}
+
+	short, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+	if err := waitAgent(short, filepath.Join(t.TempDir(), "missing.sock"), 1024, nil); err == nil {
+		t.Error("expected an error once the context is done")
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Agent is a guest agent binary embedded in every rootfs image and run as a
+// service of the image init system.
+type Agent struct {
+	// Name is the agent and service name, the agent is embedded when set.
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	// Protocol is the host to agent protocol the agent speaks (e.g.
+	// "sbx-agent/v1"), published for hosts.
+	Protocol string `yaml:"protocol"`
+	// Dest is the binary path in the image (default:
+	// /usr/local/bin/<name>).
+	Dest string `yaml:"dest"`
+	// Args are passed to the agent by its service.
+	Args []string `yaml:"args"`
+	// Binaries are the agent binaries per architecture.
+	Binaries map[string]ArchBinary `yaml:"binaries"`
+}
+
+// ArchBinary is a binary of an architecture embedded in the images, from a
+// local file or a URL.
+type ArchBinary struct {
+	// File is the binary path, relative to the config file.
+	File string `yaml:"file"`
+	URL  string `yaml:"url"`
+	// SHA256 pins the binary contents, required for URLs.
+	SHA256 string `yaml:"sha256"`
+}
+
+// Enabled reports whether an agent is embedded in the rootfs images.
+func (a Agent) Enabled() bool {
+	return a.Name != ""
+}
+
+// Source returns the URL or the file of b.
+func (b ArchBinary) Source() string {
+	if b.URL != "" {
+		return b.URL
+	}
+	return b.File
+}
+
+// resolve validates the agent for archs, resolves its files relative to dir
+// and sets the defaults.
+func (a *Agent) resolve(dir string, archs []string) error {
+	if !a.Enabled() {
+		return nil
+	}
+	if !flavorNameRe.MatchString(a.Name) {
+		return fmt.Errorf("agent.name must be lowercase alphanumeric words separated by dashes")
+	}
+	if a.Version == "" {
+		return fmt.Errorf("agent.version is required")
+	}
+	if a.Dest == "" {
+		a.Dest = "/usr/local/bin/" + a.Name
+	}
+	if !path.IsAbs(a.Dest) {
+		return fmt.Errorf("agent.dest must be an absolute path")
+	}
+	for _, arg := range a.Args {
+		if strings.ContainsAny(arg, " \t\n|'\"") {
+			return fmt.Errorf("agent.args: %q can't contain spaces, quotes or |", arg)
+		}
+	}
+	return resolveBinaries(a.Binaries, dir, "agent.binaries", archs)
+}
+
+// resolveBinaries validates the binaries at field, one per architecture of
+// archs, and resolves their files relative to dir.
+func resolveBinaries(binaries map[string]ArchBinary, dir, field string, archs []string) error {
+	for arch, b := range binaries {
+		field := field + "." + arch
+		if !slices.Contains(archs, arch) {
+			return fmt.Errorf("%s: unknown architecture", field)
+		}
+		if (b.File == "") == (b.URL == "") {
+			return fmt.Errorf("%s: exactly one of file or url is required", field)
+		}
+		if b.URL != "" && b.SHA256 == "" {
+			return fmt.Errorf("%s: sha256 is required for url binaries", field)
+		}
+		if b.File != "" && !filepath.IsAbs(b.File) {
+			b.File = filepath.Join(dir, b.File)
+			binaries[arch] = b
+		}
+	}
+	for _, arch := range archs {
+		if _, ok := binaries[arch]; !ok {
+			return fmt.Errorf("%s: no binary for %s", field, arch)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,891 @@
+// Package config loads and validates the build configuration from config.yaml.
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/slok/sbx-images/pkg/boot"
+	"github.com/slok/sbx-images/pkg/container"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/postprocess"
+	"github.com/slok/sbx-images/pkg/scan"
+)
+
+// Config represents the build configuration from config.yaml.
+type Config struct {
+	// Include lists config files merged under this one, relative to it
+	// (see loadDocument). The other relative paths of included files, like
+	// config_fragments, are relative to the loaded config file.
+	Include []string `yaml:"include"`
+	// Files are the config file and the files it includes.
+	Files []string `yaml:"-"`
+
+	Kernel struct {
+		// Kernel is the default kernel flavor.
+		Kernel `yaml:",inline"`
+		// Flavors are additional named kernels shipped next to the default
+		// kernel for every architecture.
+		Flavors []KernelFlavor `yaml:"flavors"`
+	} `yaml:"kernel"`
+	Firecracker struct {
+		Version string `yaml:"version"`
+		// MinVersion and MaxVersion are the inclusive range of Firecracker
+		// versions the release is tested with (default: Version).
+		MinVersion string `yaml:"min_version"`
+		MaxVersion string `yaml:"max_version"`
+		// Bundle ships the upstream firecracker and jailer binaries of
+		// Version with the release.
+		Bundle bool `yaml:"bundle"`
+	} `yaml:"firecracker"`
+	Rootfs struct {
+		// Distro is the registered distro the images are built from.
+		Distro        string `yaml:"distro"`
+		DistroVersion string `yaml:"distro_version"`
+		// Bootstrap is the distro bootstrap method (default: the distro
+		// default).
+		Bootstrap string `yaml:"bootstrap"`
+		// Profile, Packages and Firstboot define the default rootfs image.
+		Profile string `yaml:"profile"`
+		// Packages are the profile packages (default: the
+		// alpine/profiles/<profile>.txt package list).
+		Packages  []string `yaml:"packages"`
+		Firstboot bool     `yaml:"firstboot"`
+		// Init is the init system of the default image (default: openrc,
+		// systemd for Debian).
+		Init string `yaml:"init"`
+		// CloudInit enables cloud-init with the NoCloud datasource in the
+		// default image.
+		CloudInit bool `yaml:"cloud_init"`
+		// Ignition is the Ignition config applied on the first boot of the
+		// default image, relative to the config file.
+		Ignition string `yaml:"ignition"`
+		// Image is the OCI container image the default image is built from
+		// instead of the rootfs.bootstrap tree, based on rootfs.distro.
+		Image string `yaml:"image"`
+		// Squashfs also publishes a read-only squashfs image of every
+		// rootfs image, made from the same tree.
+		Squashfs bool `yaml:"squashfs"`
+		// Filesystems are the ImageFilesystems of the default image
+		// published next to the ext4 one.
+		Filesystems []string `yaml:"filesystems"`
+		// DiskFormats are the manifest.DiskFormats every rootfs image (the
+		// ext4 image and the Filesystems ones) is also published in,
+		// converted by make convert-images.
+		DiskFormats []string `yaml:"disk_formats"`
+		// Overlay pairs the default image, booted read-only, with an empty
+		// ext4 overlay disk template holding the writes (needs the
+		// initramfs).
+		Overlay bool `yaml:"overlay"`
+		// OverlaySizeMiB is the size of the overlay disk templates
+		// (default: DefaultOverlaySizeMiB).
+		OverlaySizeMiB int `yaml:"overlay_size_mib"`
+		// SizeMiB is the size of the ext4 images, zero to size them to
+		// their content plus an overhead.
+		SizeMiB int `yaml:"size_mib"`
+		// ArchOverrides override the rootfs settings per architecture.
+		ArchOverrides map[string]RootfsOverride `yaml:"arch_overrides"`
+		// Verity ships a dm-verity hash tree of the default image booted
+		// read-only, its root hash and salt in the manifest.
+		Verity bool `yaml:"verity"`
+		// Ext4 tunes the creation of the ext4 images.
+		Ext4 Ext4 `yaml:"ext4"`
+		// Timezone, Locale and HostnameTemplate set up the default image
+		// (default: UTC, C.UTF-8 and the distro hostname).
+		Timezone         string `yaml:"timezone"`
+		Locale           string `yaml:"locale"`
+		HostnameTemplate string `yaml:"hostname_template"`
+		// Profiles are additional rootfs images built next to the default
+		// image for every architecture.
+		Profiles []RootfsProfile `yaml:"profiles"`
+		// Files are installed in the default image.
+		Files []RootfsFile `yaml:"files"`
+		// Users are created in the default image.
+		Users []RootfsUser `yaml:"users"`
+		// Definition is the resolved definition of the default profile.
+		Definition manifest.RootfsDefinition `yaml:"-"`
+	} `yaml:"rootfs"`
+	Initramfs Initramfs `yaml:"initramfs"`
+	// Disks are the empty data disks published with the release.
+	Disks []Disk `yaml:"disks"`
+	// Agent is the guest agent embedded in the rootfs images.
+	Agent Agent `yaml:"agent"`
+	// Ignition is the Ignition binary of the images with an Ignition
+	// config.
+	Ignition Ignition `yaml:"ignition"`
+	// BootArgs are the recommended kernel cmdlines published in the manifest.
+	BootArgs BootArgs `yaml:"boot_args"`
+	// QEMU publishes the QEMU microvm boot metadata of the images.
+	QEMU          QEMU     `yaml:"qemu"`
+	Architectures []string `yaml:"architectures"`
+	Hooks         []Hook   `yaml:"hooks"`
+	BootTest      BootTest `yaml:"boot_test"`
+	Scan          Scan     `yaml:"scan"`
+	// ContainerRuntime is the container engine of the containerized build
+	// steps (container.Names, default: container.Auto).
+	ContainerRuntime string `yaml:"container_runtime"`
+	// PostProcess lists the post-processing stages per artifact kind
+	// (kernel, rootfs), run in order on the built artifacts.
+	PostProcess map[string][]PostProcessStage `yaml:"post_process"`
+	// SizeBudgets are the maximum sizes per artifact kind (BudgetKinds),
+	// e.g. "900MiB", checked when the manifest is generated.
+	SizeBudgets map[string]string `yaml:"size_budgets"`
+	// SizeBudgetBytes are the parsed SizeBudgets.
+	SizeBudgetBytes map[string]int64 `yaml:"-"`
+
+	// ExtensionsSchema is the path to a JSON Schema validating the extension
+	// fields, relative to the config file.
+	ExtensionsSchema string `yaml:"extensions_schema"`
+	// Extensions holds the top level "x-" prefixed fields, passed through to
+	// the manifest untouched.
+	Extensions map[string]any `yaml:",inline"`
+	// Overrides are the values overridden from the environment
+	// (EnvOverrides) per environment variable.
+	Overrides map[string]string `yaml:"-"`
+}
+
+// Kernel is the definition of a kernel flavor.
+type Kernel struct {
+	Version   string `yaml:"version"`
+	CIVersion string `yaml:"ci_version"`
+	// ConfigFragments are kconfig fragments merged in order on top of the
+	// firecracker-ci config, relative to the config file.
+	ConfigFragments []string `yaml:"config_fragments"`
+	// BuildFromSource builds the kernel from SourceRepo instead of
+	// downloading the firecracker-ci binary.
+	BuildFromSource bool `yaml:"build_from_source"`
+	// SourceRepo is the kernel git repository (default: linux stable).
+	SourceRepo string `yaml:"source_repo"`
+	// SourceRef is the branch or tag built (default: v<version>).
+	SourceRef string `yaml:"source_ref"`
+	// BaseConfig is the kernel config the fragments of source builds are
+	// merged on instead of the firecracker-ci config, relative to the
+	// config file, {arch} replaced by the architecture. Required for
+	// riscv64, firecracker-ci has no riscv64 config.
+	BaseConfig string `yaml:"base_config"`
+	// Patches are applied in order to the kernel source before building.
+	Patches []KernelPatch `yaml:"patches"`
+	// Modules installs the kernel modules archive in the rootfs
+	// (ModulesRootfs) or ships it with the release (ModulesSeparate).
+	// Source builds build it, downloaded kernels fetch it from ModulesURL.
+	Modules string `yaml:"modules"`
+	// ModulesURL is the modules-<arch>.tar.zst URL of downloaded kernels,
+	// {arch} is replaced by the architecture.
+	ModulesURL string `yaml:"modules_url"`
+	// BzImage also packages the compressed bzImage-<arch> of x86_64 source
+	// builds next to the vmlinux ELF. Other architectures ignore it.
+	BzImage bool `yaml:"bzimage"`
+	// ArchOverrides override the kernel per architecture (e.g. an older
+	// version for aarch64), see KernelFlavor.ForArch.
+	ArchOverrides map[string]KernelOverride `yaml:"arch_overrides"`
+	// Hypervisor is the hypervisor the kernel is built for
+	// (manifest.Hypervisors, default: manifest.HypervisorFirecracker).
+	// Kernels of other hypervisors are built from source, their config
+	// fragments enabling what the hypervisor needs.
+	Hypervisor string `yaml:"hypervisor"`
+}
+
+// KernelOverride is the kernel definition of an architecture, its set fields
+// replacing the flavor ones.
+type KernelOverride struct {
+	Version   string `yaml:"version"`
+	CIVersion string `yaml:"ci_version"`
+	// SourceRef defaults to v<version> when the version is overridden and
+	// the flavor has no source_ref.
+	SourceRef string `yaml:"source_ref"`
+	// ConfigFragments replace the flavor fragments, relative to the config
+	// file.
+	ConfigFragments []string `yaml:"config_fragments"`
+}
+
+// RootfsOverride is the rootfs settings of an architecture, its set fields
+// replacing the rootfs ones.
+type RootfsOverride struct {
+	SizeMiB int `yaml:"size_mib"`
+}
+
+// RootfsSizeMiB returns the size of the ext4 images of arch, zero when sized
+// to their content.
+func (c Config) RootfsSizeMiB(arch string) int {
+	return cmp.Or(c.Rootfs.ArchOverrides[arch].SizeMiB, c.Rootfs.SizeMiB)
+}
+
+// BaseConfigPath returns the BaseConfig of arch, empty without one.
+func (k Kernel) BaseConfigPath(arch string) string {
+	return strings.ReplaceAll(k.BaseConfig, "{arch}", arch)
+}
+
+// ImageFormats returns the kernel image formats packaged for arch, the
+// format of the vmlinux file first.
+func (k Kernel) ImageFormats(arch string) []string {
+	formats := []string{manifest.KernelFormat(arch)}
+	if k.BzImage && arch == "x86_64" {
+		formats = append(formats, manifest.KernelFormatBzImage)
+	}
+	return formats
+}
+
+// KernelFlavor is a named kernel definition.
+type KernelFlavor struct {
+	Name   string `yaml:"name"`
+	Kernel `yaml:",inline"`
+}
+
+// ForArch returns the effective flavor of arch, with its arch_overrides
+// applied.
+func (f KernelFlavor) ForArch(arch string) KernelFlavor {
+	o, ok := f.ArchOverrides[arch]
+	if !ok {
+		return f
+	}
+	f.Version = cmp.Or(o.Version, f.Version)
+	f.CIVersion = cmp.Or(o.CIVersion, f.CIVersion)
+	f.SourceRef = cmp.Or(o.SourceRef, f.SourceRef)
+	if o.ConfigFragments != nil {
+		f.ConfigFragments = o.ConfigFragments
+	}
+	return f
+}
+
+// ArtifactName returns the name of the flavor's per arch file with the given
+// prefix, see manifest.FlavorName.
+func (f KernelFlavor) ArtifactName(prefix, arch string) string {
+	return manifest.FlavorName(prefix, f.Name, arch)
+}
+
+// KernelFlavors returns the default kernel (named manifest.DefaultFlavor)
+// followed by the configured flavors.
+func (c Config) KernelFlavors() []KernelFlavor {
+	flavors := []KernelFlavor{{Name: manifest.DefaultFlavor, Kernel: c.Kernel.Kernel}}
+	return append(flavors, c.Kernel.Flavors...)
+}
+
+// Hypervisors returns the hypervisors the kernel flavors are built for, in
+// manifest.Hypervisors order.
+func (c Config) Hypervisors() []string {
+	var hypervisors []string
+	for _, hv := range manifest.Hypervisors {
+		if slices.ContainsFunc(c.KernelFlavors(), func(f KernelFlavor) bool { return f.Hypervisor == hv }) {
+			hypervisors = append(hypervisors, hv)
+		}
+	}
+	return hypervisors
+}
+
+// Initramfs configures the optional initramfs (static busybox and an init
+// script) run before the rootfs, for early boot setup such as a dm-verity
+// root.
+type Initramfs struct {
+	Enabled bool `yaml:"enabled"`
+	// Init is the /init script, relative to the config file (default:
+	// DefaultInitramfsInit).
+	Init string `yaml:"init"`
+}
+
+// Ext4 tunes mkfs.ext4 for the rootfs images, the mkfs.ext4 defaults when
+// unset.
+type Ext4 struct {
+	// InodeRatio is the bytes per inode (mkfs.ext4 -i).
+	InodeRatio int `yaml:"inode_ratio"`
+	// ReservedPercent is the percentage of blocks reserved for root
+	// (mkfs.ext4 -m, default 5).
+	ReservedPercent *int `yaml:"reserved_percent"`
+	// Journal disables the journal when false.
+	Journal *bool  `yaml:"journal"`
+	Label   string `yaml:"label"`
+	// UUID is the filesystem UUID of every image, random when unset.
+	UUID string `yaml:"uuid"`
+}
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func (e Ext4) check() error {
+	if e.InodeRatio != 0 && (e.InodeRatio < 1024 || e.InodeRatio > 1<<26) {
+		return fmt.Errorf("rootfs.ext4.inode_ratio must be between 1024 and 67108864")
+	}
+	if e.ReservedPercent != nil && (*e.ReservedPercent < 0 || *e.ReservedPercent > 50) {
+		return fmt.Errorf("rootfs.ext4.reserved_percent must be between 0 and 50")
+	}
+	if len(e.Label) > 16 || strings.ContainsAny(e.Label, " |") {
+		return fmt.Errorf("rootfs.ext4.label must be at most 16 characters, without spaces")
+	}
+	if e.UUID != "" && !uuidRe.MatchString(e.UUID) {
+		return fmt.Errorf("rootfs.ext4.uuid %q is not a UUID", e.UUID)
+	}
+	return nil
+}
+
+// MkfsOptions returns the mkfs.ext4 options of e.
+func (e Ext4) MkfsOptions() string {
+	var opts []string
+	if e.InodeRatio != 0 {
+		opts = append(opts, "-i", strconv.Itoa(e.InodeRatio))
+	}
+	if e.ReservedPercent != nil {
+		opts = append(opts, "-m", strconv.Itoa(*e.ReservedPercent))
+	}
+	if e.Journal != nil && !*e.Journal {
+		opts = append(opts, "-O", "^has_journal")
+	}
+	if e.Label != "" {
+		opts = append(opts, "-L", e.Label)
+	}
+	if e.UUID != "" {
+		opts = append(opts, "-U", strings.ToLower(e.UUID))
+	}
+	return strings.Join(opts, " ")
+}
+
+// BootArgs are the recommended kernel cmdlines of the rootfs profiles.
+type BootArgs struct {
+	// Default is the cmdline of profiles without their own (default:
+	// boot.DefaultBootArgs).
+	Default string `yaml:"default"`
+	// Profiles overrides the cmdline per rootfs profile.
+	Profiles map[string]string `yaml:"profiles"`
+	// Hypervisors are the cmdlines of the kernels of other hypervisors than
+	// Firecracker, Default and Profiles being Firecracker ones (default:
+	// DefaultHypervisorBootArgs).
+	Hypervisors map[string]string `yaml:"hypervisors"`
+}
+
+// QEMU configures the QEMU microvm compatibility metadata, to boot the
+// images without Firecracker.
+type QEMU struct {
+	// Microvm publishes the boot metadata of QEMU's microvm machine type
+	// for the x86_64 Firecracker kernels (manifest.QEMUMicrovm), the only
+	// architecture of the machine type.
+	Microvm bool `yaml:"microvm"`
+	// BootArgs is the cmdline of the images under microvm (default:
+	// boot.QEMUMicrovmBootArgs).
+	BootArgs string `yaml:"boot_args"`
+}
+
+// HypervisorBootArgs returns the recommended kernel cmdlines of a rootfs
+// profile for arch by hypervisor, Firecracker aside: the other hypervisors
+// of the kernel flavors and the QEMU microvm.
+func (c Config) HypervisorBootArgs(profile, arch string) map[string]string {
+	args := map[string]string{}
+	for _, hv := range c.Hypervisors() {
+		if hv != manifest.HypervisorFirecracker {
+			args[hv] = c.BootArgs.ForHypervisor(profile, hv)
+		}
+	}
+	if c.QEMU.Microvm && arch == "x86_64" {
+		args[manifest.HypervisorQEMUMicrovm] = c.QEMU.BootArgs
+	}
+	return args
+}
+
+// DefaultHypervisorBootArgs are the default cmdlines of the other
+// hypervisors than Firecracker.
+var DefaultHypervisorBootArgs = map[string]string{
+	manifest.HypervisorCloudHypervisor: boot.CloudHypervisorBootArgs,
+}
+
+// For returns the recommended kernel cmdline of a rootfs profile.
+func (b BootArgs) For(profile string) string {
+	if args, ok := b.Profiles[profile]; ok {
+		return args
+	}
+	return b.Default
+}
+
+// ForHypervisor returns the recommended kernel cmdline of a rootfs profile
+// for the kernels of hypervisor.
+func (b BootArgs) ForHypervisor(profile, hypervisor string) string {
+	if hypervisor == manifest.HypervisorFirecracker {
+		return b.For(profile)
+	}
+	return cmp.Or(b.Hypervisors[hypervisor], DefaultHypervisorBootArgs[hypervisor])
+}
+
+// Hook is a user provided script run on the build output after the
+// artifacts are built. Hooks run sandboxed with only the declared permissions.
+type Hook struct {
+	Name string `yaml:"name"`
+	// Script is the path to the executable, relative to the config file.
+	Script string `yaml:"script"`
+	// Network allows network access from the hook.
+	Network bool `yaml:"network"`
+	// ReadPaths are extra paths the hook may read, relative to the config file.
+	ReadPaths []string `yaml:"read_paths"`
+	// WritePaths are paths the hook may modify, relative to the config file.
+	WritePaths []string `yaml:"write_paths"`
+}
+
+// KernelPatch is a patch applied to the kernel source, from a local file or
+// a URL.
+type KernelPatch struct {
+	// File is the patch path, relative to the config file.
+	File string `yaml:"file"`
+	URL  string `yaml:"url"`
+	// SHA256 pins the patch contents, required for URLs.
+	SHA256 string `yaml:"sha256"`
+}
+
+// BootTest configures boot testing of the built images.
+type BootTest struct {
+	// Timeout is the maximum time a guest has to reach userspace.
+	Timeout time.Duration `yaml:"timeout"`
+	// Runs is the number of times boot-test boots each image, the boot
+	// time percentiles published in the manifest measured over them
+	// (default 1).
+	Runs int `yaml:"runs"`
+	// BaseArgs are appended to every kernel cmdline combination.
+	BaseArgs string `yaml:"base_args"`
+	// Matrix lists the cmdline axes whose combinations are booted.
+	Matrix []BootArgsAxis `yaml:"matrix"`
+}
+
+// BootArgsAxis is a named set of alternative cmdline fragments. An empty
+// value means the fragment is left out.
+type BootArgsAxis struct {
+	Name   string   `yaml:"name"`
+	Values []string `yaml:"values"`
+}
+
+// Scan configures vulnerability scanning of the rootfs SBOMs.
+type Scan struct {
+	// Scanner is the scanner tool (grype, trivy).
+	Scanner string `yaml:"scanner"`
+	// FailOn fails the scan when a finding has this severity or higher
+	// (negligible, low, medium, high, critical). Empty never fails.
+	FailOn string `yaml:"fail_on"`
+	// Ignore lists vulnerability IDs excluded from the threshold check.
+	Ignore []string `yaml:"ignore"`
+}
+
+// PostProcessStage is a post-processing pipeline stage (compress, encrypt,
+// split, sign) with its stage specific options.
+type PostProcessStage struct {
+	Stage   string            `yaml:"stage"`
+	Options map[string]string `yaml:",inline"`
+}
+
+// Kernel modules archive modes.
+const (
+	ModulesRootfs   = "rootfs"
+	ModulesSeparate = "separate"
+)
+
+// DefaultOverlaySizeMiB is the default size of the overlay disk templates,
+// sparse files until written.
+const DefaultOverlaySizeMiB = 1024
+
+// DefaultInitramfsInit is the initramfs /init script used when
+// initramfs.init is unset, relative to the config file.
+const DefaultInitramfsInit = "initramfs/init"
+
+// DefaultKernelRepo is the kernel repository source builds clone by default.
+const DefaultKernelRepo = "https://git.kernel.org/pub/scm/linux/kernel/git/stable/linux.git"
+
+// ArtifactKinds are the artifact kinds post-processing can be configured for.
+var ArtifactKinds = []string{"kernel", "rootfs"}
+
+// BudgetKinds are the artifact kinds size budgets can be configured for.
+var BudgetKinds = []string{"kernel", "modules", "rootfs", "initramfs"}
+
+// unknownFieldRe matches the yaml unknown field errors, naming the Go type.
+var unknownFieldRe = regexp.MustCompile(`^(line \d+): field (\S+) not found in type .*$`)
+
+// decode strictly decodes a config document: every unknown field (e.g. a
+// misspelled firecraker:) and mistyped value is reported at once with its
+// line, instead of being dropped.
+func decode(data []byte) (Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(&cfg)
+	if errors.Is(err, io.EOF) {
+		return cfg, nil
+	}
+	var problems []string
+	var terr *yaml.TypeError
+	switch {
+	case errors.As(err, &terr):
+		for _, p := range terr.Errors {
+			problems = append(problems, unknownFieldRe.ReplaceAllString(p, "$1: unknown field $2"))
+		}
+	case err != nil:
+		return Config{}, err
+	}
+
+	// The top level unknown fields land in the inline Extensions, only the
+	// x- prefixed ones are extensions.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Config{}, err
+	}
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		keys := doc.Content[0].Content
+		for i := 0; i < len(keys); i += 2 {
+			k := keys[i].Value
+			if _, ok := cfg.Extensions[k]; ok && !strings.HasPrefix(k, ExtensionPrefix) {
+				problems = append(problems, fmt.Sprintf("line %d: unknown field %s", keys[i].Line, k))
+				delete(cfg.Extensions, k)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool { return problemLine(problems[i]) < problemLine(problems[j]) })
+		return Config{}, fmt.Errorf("%d problem(s): %s", len(problems), strings.Join(problems, "; "))
+	}
+	return cfg, nil
+}
+
+// problemLine returns the line of a "line N: ..." decoding problem.
+func problemLine(problem string) int {
+	var n int
+	fmt.Sscanf(problem, "line %d:", &n)
+	return n
+}
+
+// Load reads and validates the config file at path. Its errors are of the
+// exitcode.Config class.
+func Load(path string) (Config, error) {
+	cfg, err := load(path)
+	if err != nil {
+		return Config{}, exitcode.Wrap(exitcode.Config, err)
+	}
+	return cfg, nil
+}
+
+func load(path string) (Config, error) {
+	doc, files, err := loadDocument(path, nil, nil)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := doc.Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	cfg.Files = files
+	if err := cfg.applyEnvOverrides(); err != nil {
+		return Config{}, err
+	}
+	if cfg.ExtensionsSchema != "" && !filepath.IsAbs(cfg.ExtensionsSchema) {
+		cfg.ExtensionsSchema = filepath.Join(filepath.Dir(path), cfg.ExtensionsSchema)
+	}
+
+	if cfg.Kernel.Version == "" {
+		return Config{}, fmt.Errorf("kernel.version is required in %s", path)
+	}
+	if err := cfg.Kernel.Kernel.resolve(filepath.Dir(path), "kernel"); err != nil {
+		return Config{}, fmt.Errorf("%w in %s", err, path)
+	}
+	names := map[string]bool{manifest.DefaultFlavor: true}
+	for i := range cfg.Kernel.Flavors {
+		f := &cfg.Kernel.Flavors[i]
+		field := fmt.Sprintf("kernel.flavors[%d]", i)
+		if !flavorNameRe.MatchString(f.Name) {
+			return Config{}, fmt.Errorf("%s: name must be lowercase alphanumeric words separated by dashes in %s", field, path)
+		}
+		if names[f.Name] {
+			return Config{}, fmt.Errorf("%s: duplicated flavor %q in %s", field, f.Name, path)
+		}
+		names[f.Name] = true
+		if f.Version == "" {
+			return Config{}, fmt.Errorf("%s: version is required in %s", field, path)
+		}
+		if f.CIVersion == "" {
+			f.CIVersion = cfg.Kernel.CIVersion
+		}
+		if err := f.resolve(filepath.Dir(path), field); err != nil {
+			return Config{}, fmt.Errorf("%w in %s", err, path)
+		}
+	}
+
+	d, err := distro.Get(cfg.Rootfs.Distro)
+	if err != nil {
+		return Config{}, fmt.Errorf("rootfs.distro: %w in %s", err, path)
+	}
+	if cfg.Rootfs.Bootstrap, err = distro.Bootstrap(d, cfg.Rootfs.Bootstrap); err != nil {
+		return Config{}, fmt.Errorf("rootfs.bootstrap: %w in %s", err, path)
+	}
+	if cfg.Rootfs.Profile == "" {
+		return Config{}, fmt.Errorf("rootfs.profile is required in %s", path)
+	}
+	if err := cfg.resolveRootfsProfiles(filepath.Dir(path)); err != nil {
+		return Config{}, fmt.Errorf("%w in %s", err, path)
+	}
+
+	if cfg.Rootfs.OverlaySizeMiB == 0 {
+		cfg.Rootfs.OverlaySizeMiB = DefaultOverlaySizeMiB
+	}
+	if cfg.Rootfs.OverlaySizeMiB < 0 {
+		return Config{}, fmt.Errorf("rootfs.overlay_size_mib must be positive in %s", path)
+	}
+	if cfg.Rootfs.SizeMiB < 0 {
+		return Config{}, fmt.Errorf("rootfs.size_mib must be positive in %s", path)
+	}
+	if cfg.BootTest.Runs == 0 {
+		cfg.BootTest.Runs = 1
+	}
+	if cfg.BootTest.Runs < 0 {
+		return Config{}, fmt.Errorf("boot_test.runs must be positive in %s", path)
+	}
+	if err := cfg.Rootfs.Ext4.check(); err != nil {
+		return Config{}, fmt.Errorf("%w in %s", err, path)
+	}
+	for _, format := range cfg.Rootfs.DiskFormats {
+		if !slices.Contains(manifest.DiskFormats, format) {
+			return Config{}, fmt.Errorf("rootfs.disk_formats: unknown disk format %q (supported: %s) in %s", format, strings.Join(manifest.DiskFormats, ", "), path)
+		}
+	}
+	for i := range cfg.Disks {
+		d := &cfg.Disks[i]
+		if err := d.resolve(fmt.Sprintf("disks[%d]", i)); err != nil {
+			return Config{}, fmt.Errorf("%w in %s", err, path)
+		}
+		if slices.ContainsFunc(cfg.Disks[:i], func(o Disk) bool { return o.Name == d.Name }) {
+			return Config{}, fmt.Errorf("disks[%d]: duplicate disk %q in %s", i, d.Name, path)
+		}
+	}
+	for _, p := range cfg.RootfsProfiles() {
+		if p.Definition.Overlay && !cfg.Initramfs.Enabled {
+			return Config{}, fmt.Errorf("rootfs profile %s: overlay requires initramfs.enabled, which sets up the overlay root, in %s", p.Name, path)
+		}
+	}
+
+	if cfg.Initramfs.Init == "" {
+		cfg.Initramfs.Init = DefaultInitramfsInit
+	}
+	if !filepath.IsAbs(cfg.Initramfs.Init) {
+		cfg.Initramfs.Init = filepath.Join(filepath.Dir(path), cfg.Initramfs.Init)
+	}
+
+	if cfg.BootArgs.Default == "" {
+		cfg.BootArgs.Default = boot.DefaultBootArgs
+	}
+	if cfg.QEMU.Microvm && !slices.Contains(cfg.Architectures, "x86_64") {
+		return Config{}, fmt.Errorf("qemu.microvm: the QEMU microvm machine type is x86_64 only, not in architectures, in %s", path)
+	}
+	if cfg.QEMU.BootArgs == "" {
+		cfg.QEMU.BootArgs = boot.QEMUMicrovmBootArgs
+	}
+	for hv := range cfg.BootArgs.Hypervisors {
+		if hv == manifest.HypervisorFirecracker || !slices.Contains(manifest.Hypervisors, hv) {
+			return Config{}, fmt.Errorf("boot_args.hypervisors.%s: unknown hypervisor (supported: %s), boot_args.default is the %s cmdline, in %s",
+				hv, strings.Join(manifest.Hypervisors[1:], ", "), manifest.HypervisorFirecracker, path)
+		}
+	}
+
+	for i, h := range cfg.Hooks {
+		if h.Name == "" || h.Script == "" {
+			return Config{}, fmt.Errorf("hooks[%d]: name and script are required in %s", i, path)
+		}
+	}
+
+	if cfg.ContainerRuntime == "" {
+		cfg.ContainerRuntime = container.Auto
+	}
+	if !slices.Contains(container.Names, cfg.ContainerRuntime) {
+		return Config{}, fmt.Errorf("container_runtime must be one of %s in %s", strings.Join(container.Names, ", "), path)
+	}
+
+	if cfg.Scan.FailOn != "" && !slices.Contains(scan.Severities, cfg.Scan.FailOn) {
+		return Config{}, fmt.Errorf("scan.fail_on must be one of %s in %s", strings.Join(scan.Severities, ", "), path)
+	}
+
+	for kind, stages := range cfg.PostProcess {
+		if !slices.Contains(ArtifactKinds, kind) {
+			return Config{}, fmt.Errorf("post_process.%s: unknown artifact kind (supported: %s) in %s", kind, strings.Join(ArtifactKinds, ", "), path)
+		}
+		for i, st := range stages {
+			if st.Stage == "" {
+				return Config{}, fmt.Errorf("post_process.%s[%d]: stage is required in %s", kind, i, path)
+			}
+		}
+	}
+
+	for kind, budget := range cfg.SizeBudgets {
+		if !slices.Contains(BudgetKinds, kind) {
+			return Config{}, fmt.Errorf("size_budgets.%s: unknown artifact kind (supported: %s) in %s", kind, strings.Join(BudgetKinds, ", "), path)
+		}
+		size, err := postprocess.ParseSize(budget)
+		if err != nil {
+			return Config{}, fmt.Errorf("size_budgets.%s: %w in %s", kind, err, path)
+		}
+		if cfg.SizeBudgetBytes == nil {
+			cfg.SizeBudgetBytes = map[string]int64{}
+		}
+		cfg.SizeBudgetBytes[kind] = size
+	}
+
+	if len(cfg.Architectures) == 0 {
+		return Config{}, fmt.Errorf("no architectures defined in %s", path)
+	}
+	for i, arch := range cfg.Architectures {
+		if !slices.Contains(manifest.Architectures, arch) {
+			return Config{}, fmt.Errorf("architectures[%d]: unsupported architecture %q (supported: %s) in %s", i, arch, strings.Join(manifest.Architectures, ", "), path)
+		}
+		if slices.Contains(cfg.Architectures[:i], arch) {
+			return Config{}, fmt.Errorf("architectures[%d]: duplicate architecture %q in %s", i, arch, path)
+		}
+	}
+	if err := cfg.checkArchOverrides(); err != nil {
+		return Config{}, fmt.Errorf("%w in %s", err, path)
+	}
+	// Neither firecracker-ci kernels and configs nor Firecracker releases
+	// are published for riscv64 yet.
+	if slices.Contains(cfg.Architectures, "riscv64") {
+		for _, f := range cfg.KernelFlavors() {
+			if !f.BuildFromSource || f.BaseConfig == "" {
+				return Config{}, fmt.Errorf("kernel flavor %s: riscv64 kernels require build_from_source and base_config, firecracker-ci publishes none, in %s", f.Name, path)
+			}
+		}
+		if cfg.Firecracker.Bundle {
+			return Config{}, fmt.Errorf("firecracker.bundle: no riscv64 Firecracker release to bundle in %s", path)
+		}
+	}
+	if err := cfg.Agent.resolve(filepath.Dir(path), cfg.Architectures); err != nil {
+		return Config{}, fmt.Errorf("%w in %s", err, path)
+	}
+	if err := cfg.Ignition.resolve(filepath.Dir(path), cfg.Architectures, cfg.RootfsProfiles()); err != nil {
+		return Config{}, fmt.Errorf("%w in %s", err, path)
+	}
+	if err := cfg.checkSettings(); err != nil {
+		return Config{}, fmt.Errorf("%w in %s", err, path)
+	}
+	if cfg.Firecracker.Version == "" {
+		return Config{}, fmt.Errorf("firecracker.version is required in %s", path)
+	}
+	if cfg.Firecracker.MinVersion == "" {
+		cfg.Firecracker.MinVersion = cfg.Firecracker.Version
+	}
+	if cfg.Firecracker.MaxVersion == "" {
+		cfg.Firecracker.MaxVersion = cfg.Firecracker.Version
+	}
+	fc := manifest.Firecracker{MinVersion: cfg.Firecracker.MinVersion, MaxVersion: cfg.Firecracker.MaxVersion}
+	if c, err := manifest.CompareVersions(fc.MinVersion, fc.MaxVersion); err != nil {
+		return Config{}, fmt.Errorf("firecracker: %w in %s", err, path)
+	} else if c > 0 {
+		return Config{}, fmt.Errorf("firecracker.min_version is newer than max_version in %s", path)
+	}
+	if err := fc.Compatible(cfg.Firecracker.Version); err != nil {
+		return Config{}, fmt.Errorf("firecracker.version outside of min_version and max_version: %w in %s", err, path)
+	}
+
+	return cfg, nil
+}
+
+var flavorNameRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// resolve validates the kernel definition at field, resolves its paths
+// relative to dir and sets the source defaults.
+func (k *Kernel) resolve(dir, field string) error {
+	for i, f := range k.ConfigFragments {
+		if !filepath.IsAbs(f) {
+			k.ConfigFragments[i] = filepath.Join(dir, f)
+		}
+	}
+
+	for i, p := range k.Patches {
+		if (p.File == "") == (p.URL == "") {
+			return fmt.Errorf("%s.patches[%d]: exactly one of file or url is required", field, i)
+		}
+		if p.URL != "" && p.SHA256 == "" {
+			return fmt.Errorf("%s.patches[%d]: sha256 is required for url patches", field, i)
+		}
+		if p.File != "" && !filepath.IsAbs(p.File) {
+			k.Patches[i].File = filepath.Join(dir, p.File)
+		}
+	}
+	if len(k.Patches) > 0 && !k.BuildFromSource {
+		return fmt.Errorf("%s.patches requires %s.build_from_source", field, field)
+	}
+
+	switch k.Modules {
+	case "", ModulesRootfs, ModulesSeparate:
+	default:
+		return fmt.Errorf("%s.modules must be %s or %s", field, ModulesRootfs, ModulesSeparate)
+	}
+	if k.Modules != "" && !k.BuildFromSource && k.ModulesURL == "" {
+		return fmt.Errorf("%s.modules requires %s.build_from_source or %s.modules_url", field, field, field)
+	}
+	if k.ModulesURL != "" && k.BuildFromSource {
+		return fmt.Errorf("%s.modules_url can't be used with %s.build_from_source", field, field)
+	}
+
+	if k.BzImage && !k.BuildFromSource {
+		return fmt.Errorf("%s.bzimage requires %s.build_from_source", field, field)
+	}
+	if k.BaseConfig != "" {
+		if !k.BuildFromSource {
+			return fmt.Errorf("%s.base_config requires %s.build_from_source", field, field)
+		}
+		if !filepath.IsAbs(k.BaseConfig) {
+			k.BaseConfig = filepath.Join(dir, k.BaseConfig)
+		}
+	}
+
+	for arch, o := range k.ArchOverrides {
+		for i, f := range o.ConfigFragments {
+			if !filepath.IsAbs(f) {
+				o.ConfigFragments[i] = filepath.Join(dir, f)
+			}
+		}
+		if o.Version != "" && o.SourceRef == "" && k.SourceRef == "" {
+			o.SourceRef = "v" + o.Version
+		}
+		k.ArchOverrides[arch] = o
+	}
+
+	if k.Hypervisor == "" {
+		k.Hypervisor = manifest.HypervisorFirecracker
+	}
+	if !slices.Contains(manifest.Hypervisors, k.Hypervisor) {
+		return fmt.Errorf("%s.hypervisor: unknown hypervisor %q (supported: %s)", field, k.Hypervisor, strings.Join(manifest.Hypervisors, ", "))
+	}
+	// The firecracker-ci kernels are built for Firecracker only.
+	if k.Hypervisor != manifest.HypervisorFirecracker && !k.BuildFromSource {
+		return fmt.Errorf("%s.hypervisor %s requires %s.build_from_source", field, k.Hypervisor, field)
+	}
+
+	if k.SourceRepo == "" {
+		k.SourceRepo = DefaultKernelRepo
+	}
+	if k.SourceRef == "" {
+		k.SourceRef = "v" + k.Version
+	}
+	return nil
+}
+
+// checkArchOverrides checks the arch_overrides are of built architectures.
+func (c Config) checkArchOverrides() error {
+	for i, f := range c.KernelFlavors() {
+		field := "kernel"
+		if i > 0 {
+			field = fmt.Sprintf("kernel.flavors[%d]", i-1)
+		}
+		for arch := range f.ArchOverrides {
+			if !slices.Contains(c.Architectures, arch) {
+				return fmt.Errorf("%s.arch_overrides.%s: %q is not in architectures", field, arch, arch)
+			}
+		}
+	}
+	for arch, o := range c.Rootfs.ArchOverrides {
+		if !slices.Contains(c.Architectures, arch) {
+			return fmt.Errorf("rootfs.arch_overrides.%s: %q is not in architectures", arch, arch)
+		}
+		if o.SizeMiB < 0 {
+			return fmt.Errorf("rootfs.arch_overrides.%s.size_mib must be positive", arch)
+		}
+	}
+	return nil
+}
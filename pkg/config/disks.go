@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// Disk is an empty pre-formatted data disk published with the release,
+// e.g. a scratch disk mounted at /workspace. It is the same for every
+// architecture.
+type Disk struct {
+	// Name is the disk name, the file is disk-<name>.<filesystem>.
+	Name    string `yaml:"name"`
+	SizeMiB int    `yaml:"size_mib"`
+	// Filesystem is one of DiskFilesystems (default: ext4).
+	Filesystem string `yaml:"filesystem"`
+	// Label is the filesystem label (default: the name).
+	Label string `yaml:"label"`
+	// MountPoint is where the guest should mount the disk, published in
+	// the manifest.
+	MountPoint string `yaml:"mount_point"`
+}
+
+// DiskFilesystems are the filesystems of the data disks.
+var DiskFilesystems = []string{manifest.RootfsFilesystemExt4, manifest.RootfsFilesystemBtrfs}
+
+// resolve validates the disk at field and sets the defaults.
+func (d *Disk) resolve(field string) error {
+	if !flavorNameRe.MatchString(d.Name) {
+		return fmt.Errorf("%s: invalid disk name %q", field, d.Name)
+	}
+	if d.SizeMiB <= 0 {
+		return fmt.Errorf("%s: size_mib must be positive", field)
+	}
+	if d.Filesystem == "" {
+		d.Filesystem = manifest.RootfsFilesystemExt4
+	}
+	if !slices.Contains(DiskFilesystems, d.Filesystem) {
+		return fmt.Errorf("%s: unknown filesystem %q (supported: ext4, btrfs)", field, d.Filesystem)
+	}
+	if d.Label == "" {
+		d.Label = d.Name
+	}
+	if len(d.Label) > 16 {
+		return fmt.Errorf("%s: label must be at most 16 characters", field)
+	}
+	if d.MountPoint != "" && (!path.IsAbs(d.MountPoint) || path.Clean(d.MountPoint) == "/") {
+		return fmt.Errorf("%s: mount_point must be an absolute path other than /", field)
+	}
+	return nil
+}
+
+// File returns the disk image file name.
+func (d Disk) File() string {
+	return manifest.DiskFile(d.Name, d.Filesystem)
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvOverride is a config value overridable from an environment variable.
+type EnvOverride struct {
+	// Env is the environment variable, e.g. SBX_KERNEL_VERSION.
+	Env string
+	// Field is the overridden config field, e.g. kernel.version.
+	Field string
+	set   func(c *Config, v string) error
+}
+
+// EnvOverrides are the config values overridable from the environment, so CI
+// can vary one field without templating config.yaml. A non-empty variable
+// replaces the config file value before the config is validated, an empty
+// one is ignored.
+var EnvOverrides = []EnvOverride{
+	{Env: "SBX_KERNEL_VERSION", Field: "kernel.version", set: func(c *Config, v string) error {
+		c.Kernel.Version = v
+		return nil
+	}},
+	{Env: "SBX_KERNEL_CI_VERSION", Field: "kernel.ci_version", set: func(c *Config, v string) error {
+		c.Kernel.CIVersion = v
+		return nil
+	}},
+	{Env: "SBX_KERNEL_SOURCE_REF", Field: "kernel.source_ref", set: func(c *Config, v string) error {
+		c.Kernel.SourceRef = v
+		return nil
+	}},
+	{Env: "SBX_KERNEL_BUILD_FROM_SOURCE", Field: "kernel.build_from_source", set: func(c *Config, v string) (err error) {
+		c.Kernel.BuildFromSource, err = strconv.ParseBool(v)
+		return err
+	}},
+	{Env: "SBX_FIRECRACKER_VERSION", Field: "firecracker.version", set: func(c *Config, v string) error {
+		c.Firecracker.Version = v
+		return nil
+	}},
+	{Env: "SBX_ROOTFS_DISTRO", Field: "rootfs.distro", set: func(c *Config, v string) error {
+		c.Rootfs.Distro = v
+		return nil
+	}},
+	{Env: "SBX_ROOTFS_DISTRO_VERSION", Field: "rootfs.distro_version", set: func(c *Config, v string) error {
+		c.Rootfs.DistroVersion = v
+		return nil
+	}},
+	{Env: "SBX_ROOTFS_PROFILE", Field: "rootfs.profile", set: func(c *Config, v string) error {
+		c.Rootfs.Profile = v
+		return nil
+	}},
+	{Env: "SBX_ROOTFS_SIZE_MIB", Field: "rootfs.size_mib", set: func(c *Config, v string) (err error) {
+		c.Rootfs.SizeMiB, err = strconv.Atoi(v)
+		return err
+	}},
+	{Env: "SBX_ARCHITECTURES", Field: "architectures", set: func(c *Config, v string) error {
+		c.Architectures = nil
+		for a := range strings.SplitSeq(v, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				c.Architectures = append(c.Architectures, a)
+			}
+		}
+		return nil
+	}},
+	{Env: "SBX_SCAN_FAIL_ON", Field: "scan.fail_on", set: func(c *Config, v string) error {
+		c.Scan.FailOn = v
+		return nil
+	}},
+}
+
+// applyEnvOverrides sets the config values overridden from the environment,
+// recording them in Overrides.
+func (c *Config) applyEnvOverrides() error {
+	for _, o := range EnvOverrides {
+		v := os.Getenv(o.Env)
+		if v == "" {
+			continue
+		}
+		if err := o.set(c, v); err != nil {
+			return fmt.Errorf("%s: invalid %s %q: %w", o.Env, o.Field, v, err)
+		}
+		if c.Overrides == nil {
+			c.Overrides = map[string]string{}
+		}
+		c.Overrides[o.Env] = v
+	}
+	return nil
+}
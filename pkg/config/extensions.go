@@ -0,0 +1,60 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// ExtensionPrefix is the namespace prefix of user defined extension fields.
+const ExtensionPrefix = "x-"
+
+// ValidateExtensions checks the extension fields against the configured JSON
+// Schema. Extensions without a schema are rejected so that unvalidated
+// metadata never reaches a release.
+func ValidateExtensions(cfg Config) error {
+	if len(cfg.Extensions) == 0 {
+		return nil
+	}
+	if cfg.ExtensionsSchema == "" {
+		return fmt.Errorf("extension fields are set but extensions_schema is not")
+	}
+
+	schemaData, err := os.ReadFile(cfg.ExtensionsSchema)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", cfg.ExtensionsSchema, err)
+	}
+	schemaDoc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schemaData))
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", cfg.ExtensionsSchema, err)
+	}
+
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(cfg.ExtensionsSchema, schemaDoc); err != nil {
+		return fmt.Errorf("loading %s: %w", cfg.ExtensionsSchema, err)
+	}
+	schema, err := c.Compile(cfg.ExtensionsSchema)
+	if err != nil {
+		return fmt.Errorf("compiling %s: %w", cfg.ExtensionsSchema, err)
+	}
+
+	// Round trip through JSON so YAML scalars get the types the schema sees
+	// in the manifest.
+	data, err := json.Marshal(cfg.Extensions)
+	if err != nil {
+		return fmt.Errorf("marshaling extensions: %w", err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decoding extensions: %w", err)
+	}
+
+	if err := schema.Validate(doc); err != nil {
+		return fmt.Errorf("invalid extensions: %w", err)
+	}
+
+	return nil
+}
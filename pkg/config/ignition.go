@@ -0,0 +1,89 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// IgnitionBootArgs are appended to the recommended kernel cmdline of the
+// images running Ignition, so it applies the configs on the first boot.
+const IgnitionBootArgs = "ignition.firstboot ignition.platform.id=metal"
+
+// Ignition is the Ignition binary embedded in the rootfs images of the
+// profiles with an Ignition config.
+type Ignition struct {
+	Version string `yaml:"version"`
+	// Binaries are the ignition binaries per architecture.
+	Binaries map[string]ArchBinary `yaml:"binaries"`
+}
+
+// ignitionSpecRe matches the supported Ignition config spec versions.
+var ignitionSpecRe = regexp.MustCompile(`^3\.[0-9]+\.[0-9]+(-experimental)?$`)
+
+// ignitionSections are the top level sections of an Ignition v3 config.
+var ignitionSections = map[string]bool{
+	"ignition":        true,
+	"kernelArguments": true,
+	"passwd":          true,
+	"storage":         true,
+	"systemd":         true,
+}
+
+// ignitionDefinition validates the Ignition config at path and returns its
+// manifest definition, with the config relative to dir.
+func ignitionDefinition(path, dir string) (*manifest.RootfsIgnition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("ignition config %s: %w", path, err)
+	}
+	for section := range doc {
+		if !ignitionSections[section] {
+			return nil, fmt.Errorf("ignition config %s: unknown section %q", path, section)
+		}
+	}
+	var meta struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(doc["ignition"], &meta); err != nil || !ignitionSpecRe.MatchString(meta.Version) {
+		return nil, fmt.Errorf("ignition config %s: ignition.version must be a 3.x.y spec version", path)
+	}
+
+	sum := sha256.Sum256(data)
+	def := &manifest.RootfsIgnition{Config: path, SpecVersion: meta.Version, SHA256: hex.EncodeToString(sum[:])}
+	if rel, err := filepath.Rel(dir, path); err == nil {
+		def.Config = filepath.ToSlash(rel)
+	}
+	return def, nil
+}
+
+// resolve validates the Ignition binaries for archs when a rootfs profile
+// uses Ignition, resolving them relative to dir.
+func (i *Ignition) resolve(dir string, archs []string, profiles []RootfsProfile) error {
+	used := false
+	for _, p := range profiles {
+		if p.EffectiveIgnition != "" {
+			used = true
+			if p.Definition.Init == InitSBX {
+				return fmt.Errorf("rootfs profile %s: ignition requires the %s or %s init system", p.Name, InitOpenRC, InitSystemd)
+			}
+		}
+	}
+	if !used {
+		return nil
+	}
+	if i.Version == "" {
+		return fmt.Errorf("ignition.version is required by the rootfs profiles with an ignition config")
+	}
+	return resolveBinaries(i.Binaries, dir, "ignition.binaries", archs)
+}
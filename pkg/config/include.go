@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadDocument reads the config file at path with its includes and returns
+// the merged document and the files read, path first. stack holds the
+// including files, to detect cycles.
+//
+// The includes are merged in order, then the including file on top of them:
+// mappings are merged key by key, any other value (scalars, sequences)
+// replaces the included one.
+//
+// With origins set, it maps every node of the merged document to the file
+// it was read from.
+func loadDocument(path string, stack []string, origins map[*yaml.Node]string) (*yaml.Node, []string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if slices.Contains(stack, abs) {
+		return nil, nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), abs)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	// Every file is checked on its own, for the lines of its problems.
+	cfg, err := decode(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if origins != nil {
+		setOrigin(origins, &doc, path)
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	files := []string{path}
+	for _, inc := range cfg.Include {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(path), inc)
+		}
+		n, incFiles, err := loadDocument(inc, append(stack, abs), origins)
+		if err != nil {
+			return nil, nil, fmt.Errorf("include %s of %s: %w", inc, path, err)
+		}
+		mergeNode(merged, n)
+		files = append(files, incFiles...)
+	}
+	if len(doc.Content) > 0 {
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return nil, nil, fmt.Errorf("parsing %s: the document is not a mapping", path)
+		}
+		if i := valueIndex(root, "include"); i >= 0 {
+			root.Content = slices.Delete(root.Content, i-1, i+1)
+		}
+		mergeNode(merged, root)
+	}
+	return merged, files, nil
+}
+
+// setOrigin maps n and its descendants to path.
+func setOrigin(origins map[*yaml.Node]string, n *yaml.Node, path string) {
+	origins[n] = path
+	for _, c := range n.Content {
+		setOrigin(origins, c, path)
+	}
+}
+
+// FieldFile returns the file the value of field (see FieldNode) of the
+// config file at path comes from: the file itself or one of its includes.
+func FieldFile(path, field string) (string, error) {
+	origins := map[*yaml.Node]string{}
+	doc, _, err := loadDocument(path, nil, origins)
+	if err != nil {
+		return "", err
+	}
+	n, err := FieldNode(doc, field)
+	if err != nil {
+		return "", err
+	}
+	return origins[n], nil
+}
+
+// fieldSegmentRe matches a field path segment, e.g. flavors[0].
+var fieldSegmentRe = regexp.MustCompile(`^([a-z0-9_]+)(?:\[(\d+)\])?$`)
+
+// FieldNode returns the value node of a dotted field path of the mapping
+// n, e.g. kernel.flavors[0].version.
+func FieldNode(n *yaml.Node, field string) (*yaml.Node, error) {
+	for seg := range strings.SplitSeq(field, ".") {
+		m := fieldSegmentRe.FindStringSubmatch(seg)
+		if m == nil || n.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s: field not found", field)
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == m[1] {
+				next = n.Content[i+1]
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s: field not found", field)
+		}
+		if m[2] != "" {
+			idx, _ := strconv.Atoi(m[2])
+			if next.Kind != yaml.SequenceNode || idx >= len(next.Content) {
+				return nil, fmt.Errorf("%s: field not found", field)
+			}
+			next = next.Content[idx]
+		}
+		n = next
+	}
+	return n, nil
+}
+
+// mergeNode merges the src mapping on top of the dst one.
+func mergeNode(dst, src *yaml.Node) {
+	for i := 0; i < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		j := valueIndex(dst, key.Value)
+		switch {
+		case j < 0:
+			dst.Content = append(dst.Content, key, value)
+		case dst.Content[j].Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNode(dst.Content[j], value)
+		default:
+			dst.Content[j] = value
+		}
+	}
+}
+
+// valueIndex returns the index of the value of key in the mapping m, -1
+// without key.
+func valueIndex(m *yaml.Node, key string) int {
+	for i := 0; i < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i + 1
+		}
+	}
+	return -1
+}
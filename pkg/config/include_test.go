@@ -0,0 +1,222 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMergeNode(t *testing.T) {
+	for name, tc := range map[string]struct {
+		dst, src, want string
+	}{
+		"new keys are added": {
+			dst:  "a: 1\n",
+			src:  "b: 2\n",
+			want: "a: 1\nb: 2\n",
+		},
+		"scalars are replaced": {
+			dst:  "a: 1\nb: 2\n",
+			src:  "a: 3\n",
+			want: "a: 3\nb: 2\n",
+		},
+		"mappings are merged key by key": {
+			dst:  "kernel:\n    version: 6.1.1\n    ci_version: v1.13\n",
+			src:  "kernel:\n    version: 6.1.2\n",
+			want: "kernel:\n    version: 6.1.2\n    ci_version: v1.13\n",
+		},
+		"sequences are replaced": {
+			dst:  "architectures:\n    - x86_64\n    - aarch64\n",
+			src:  "architectures:\n    - aarch64\n",
+			want: "architectures:\n    - aarch64\n",
+		},
+		"a scalar replaces a mapping": {
+			dst:  "a:\n    b: 1\n",
+			src:  "a: 2\n",
+			want: "a: 2\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst, src := parseMapping(t, tc.dst), parseMapping(t, tc.src)
+			mergeNode(dst, src)
+			got, err := yaml.Marshal(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadDocument(t *testing.T) {
+	for name, tc := range map[string]struct {
+		files map[string]string
+		// want is the merged document, or the expected error substring
+		// with wantErr.
+		want    string
+		wantErr bool
+		// wantFiles are the files read, relative to the directory.
+		wantFiles []string
+	}{
+		"no includes": {
+			files:     map[string]string{"config.yaml": "kernel:\n  version: 6.1.1\n"},
+			want:      "kernel:\n    version: 6.1.1\n",
+			wantFiles: []string{"config.yaml"},
+		},
+		"the including file wins": {
+			files: map[string]string{
+				"config.yaml": "include: [base.yaml]\nkernel:\n  version: 6.1.2\n",
+				"base.yaml":   "kernel:\n  version: 6.1.1\n  ci_version: v1.13\n",
+			},
+			want:      "kernel:\n    version: 6.1.2\n    ci_version: v1.13\n",
+			wantFiles: []string{"config.yaml", "base.yaml"},
+		},
+		"includes are merged in order": {
+			files: map[string]string{
+				"config.yaml": "include: [one.yaml, two.yaml]\n",
+				"one.yaml":    "architectures: [x86_64]\nfirecracker:\n  version: v1.13.0\n",
+				"two.yaml":    "architectures: [aarch64]\n",
+			},
+			want:      "architectures: [aarch64]\nfirecracker:\n    version: v1.13.0\n",
+			wantFiles: []string{"config.yaml", "one.yaml", "two.yaml"},
+		},
+		"nested includes are relative to their file": {
+			files: map[string]string{
+				"config.yaml":   "include: [sub/mid.yaml]\n",
+				"sub/mid.yaml":  "include: [leaf.yaml]\narchitectures: [x86_64]\n",
+				"sub/leaf.yaml": "kernel:\n  version: 6.1.1\n",
+			},
+			want:      "kernel:\n    version: 6.1.1\narchitectures: [x86_64]\n",
+			wantFiles: []string{"config.yaml", "sub/mid.yaml", "sub/leaf.yaml"},
+		},
+		"a file included twice is not a cycle": {
+			files: map[string]string{
+				"config.yaml": "include: [one.yaml, two.yaml]\n",
+				"one.yaml":    "include: [common.yaml]\n",
+				"two.yaml":    "include: [common.yaml]\n",
+				"common.yaml": "architectures: [x86_64]\n",
+			},
+			want:      "architectures: [x86_64]\n",
+			wantFiles: []string{"config.yaml", "one.yaml", "common.yaml", "two.yaml", "common.yaml"},
+		},
+		"self include": {
+			files:   map[string]string{"config.yaml": "include: [config.yaml]\n"},
+			want:    "include cycle",
+			wantErr: true,
+		},
+		"include cycle": {
+			files: map[string]string{
+				"config.yaml": "include: [a.yaml]\n",
+				"a.yaml":      "include: [b.yaml]\n",
+				"b.yaml":      "include: [a.yaml]\n",
+			},
+			want:    "include cycle",
+			wantErr: true,
+		},
+		"missing include": {
+			files:   map[string]string{"config.yaml": "include: [missing.yaml]\n"},
+			want:    "missing.yaml",
+			wantErr: true,
+		},
+		"unknown field in an include": {
+			files: map[string]string{
+				"config.yaml": "include: [base.yaml]\n",
+				"base.yaml":   "kernel:\n  verzion: 6.1.1\n",
+			},
+			want:    "line 2: unknown field verzion",
+			wantErr: true,
+		},
+		"not a mapping": {
+			files:   map[string]string{"config.yaml": "- a\n"},
+			want:    "parsing",
+			wantErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			doc, files, err := loadDocument(filepath.Join(dir, "config.yaml"), nil, nil)
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tc.want) {
+					t.Fatalf("got error %v, want one containing %q", err, tc.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := yaml.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+			var rel []string
+			for _, f := range files {
+				r, err := filepath.Rel(dir, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rel = append(rel, filepath.ToSlash(r))
+			}
+			if strings.Join(rel, ",") != strings.Join(tc.wantFiles, ",") {
+				t.Errorf("got files %q, want %q", rel, tc.wantFiles)
+			}
+		})
+	}
+}
+
+func TestFieldFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"config.yaml": "include: [kernel.yaml]\nfirecracker:\n  version: v1.14.1\n",
+		"kernel.yaml": "kernel:\n  version: 6.1.155\n  flavors:\n    - name: debug\n      version: 6.1.150\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := filepath.Join(dir, "config.yaml")
+
+	for field, want := range map[string]string{
+		"firecracker.version":       "config.yaml",
+		"kernel.version":            "kernel.yaml",
+		"kernel.flavors[0].version": "kernel.yaml",
+	} {
+		got, err := FieldFile(config, field)
+		if err != nil {
+			t.Errorf("%s: %v", field, err)
+			continue
+		}
+		if got != filepath.Join(dir, want) {
+			t.Errorf("%s: got %s, want %s", field, got, want)
+		}
+	}
+	if _, err := FieldFile(config, "kernel.ci_version"); err == nil {
+		t.Error("kernel.ci_version: got no error for a missing field")
+	}
+}
+
+func parseMapping(t *testing.T, s string) *yaml.Node {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Content[0]
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+var (
+	// kernelVersionRe matches the kernel release versions (6.1.155, 6.12).
+	kernelVersionRe = regexp.MustCompile(`^[0-9]+\.[0-9]+(\.[0-9]+)?$`)
+	// ciVersionRe matches the firecracker-ci bucket versions (v1.15).
+	ciVersionRe = regexp.MustCompile(`^v[0-9]+\.[0-9]+$`)
+	// releaseVersionRe matches the v-prefixed semver release versions
+	// (v1.14.1, v0.3.0-rc1).
+	releaseVersionRe = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?$`)
+)
+
+// Lint checks the semantic rules Load doesn't enforce on a loaded config,
+// those a config can break and still build but most likely by mistake:
+// version formats, empty or duplicated list entries. It returns every
+// problem as "<field>: <problem>".
+func Lint(cfg Config) []string {
+	var problems []string
+	check := func(field, value string, re *regexp.Regexp, example string) {
+		if value != "" && !re.MatchString(value) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a version like %s", field, value, example))
+		}
+	}
+
+	for i, f := range cfg.KernelFlavors() {
+		field := "kernel"
+		if i > 0 {
+			field = fmt.Sprintf("kernel.flavors[%d]", i-1)
+		}
+		check(field+".version", f.Version, kernelVersionRe, "6.1.155")
+		check(field+".ci_version", f.CIVersion, ciVersionRe, "v1.15")
+		for _, arch := range slices.Sorted(maps.Keys(f.ArchOverrides)) {
+			o := f.ArchOverrides[arch]
+			check(fmt.Sprintf("%s.arch_overrides.%s.version", field, arch), o.Version, kernelVersionRe, "6.1.155")
+			check(fmt.Sprintf("%s.arch_overrides.%s.ci_version", field, arch), o.CIVersion, ciVersionRe, "v1.15")
+		}
+	}
+	check("firecracker.version", cfg.Firecracker.Version, releaseVersionRe, "v1.14.1")
+	check("firecracker.min_version", cfg.Firecracker.MinVersion, releaseVersionRe, "v1.14.1")
+	check("firecracker.max_version", cfg.Firecracker.MaxVersion, releaseVersionRe, "v1.14.1")
+	if cfg.Agent.Enabled() {
+		check("agent.version", cfg.Agent.Version, releaseVersionRe, "v0.3.0")
+	}
+	check("ignition.version", cfg.Ignition.Version, releaseVersionRe, "v2.20.0")
+
+	// Cloud Hypervisor attaches virtio-pci devices, the root drive
+	// included.
+	if args := cfg.BootArgs.Hypervisors[manifest.HypervisorCloudHypervisor]; slices.Contains(strings.Fields(args), "pci=off") {
+		problems = append(problems, fmt.Sprintf("boot_args.hypervisors.%s: pci=off disables its virtio-pci devices", manifest.HypervisorCloudHypervisor))
+	}
+
+	list := func(field string, values []string) {
+		// A set but empty list is most likely a leftover: the field falls
+		// back to its default when removed.
+		if values != nil && len(values) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: empty list, remove it for the default", field))
+		}
+		seen := map[string]bool{}
+		for i, v := range values {
+			switch {
+			case v == "":
+				problems = append(problems, fmt.Sprintf("%s[%d]: empty entry", field, i))
+			case seen[v]:
+				problems = append(problems, fmt.Sprintf("%s[%d]: duplicated entry %q", field, i, v))
+			}
+			seen[v] = true
+		}
+	}
+	list("architectures", cfg.Architectures)
+	list("rootfs.packages", cfg.Rootfs.Packages)
+	list("rootfs.disk_formats", cfg.Rootfs.DiskFormats)
+	for i, p := range cfg.Rootfs.Profiles {
+		list(fmt.Sprintf("rootfs.profiles[%d].packages", i), p.Packages)
+		list(fmt.Sprintf("rootfs.profiles[%d].remove_packages", i), p.RemovePackages)
+	}
+	return problems
+}
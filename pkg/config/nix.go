@@ -0,0 +1,50 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// nixOutputRe matches the flake package names.
+var nixOutputRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// NixSystem returns the Nix system of a build architecture (e.g.
+// x86_64-linux), the flake packages.<system> set the image is built from.
+func NixSystem(arch string) string {
+	return arch + "-linux"
+}
+
+// nixDefinition validates the flake output reference ref, <flake
+// directory>#<package>, resolved relative to dir, and returns its manifest
+// definition, with the flake relative to dir, and the flake directory. The
+// flake must be locked for the image to be reproducible.
+func nixDefinition(ref, dir string) (*manifest.RootfsNix, string, error) {
+	flake, output, ok := strings.Cut(ref, "#")
+	if !ok || flake == "" || !nixOutputRe.MatchString(output) {
+		return nil, "", fmt.Errorf("%q must be a <flake directory>#<package> reference", ref)
+	}
+	if !filepath.IsAbs(flake) {
+		flake = filepath.Join(dir, flake)
+	}
+	if _, err := os.Stat(filepath.Join(flake, "flake.nix")); err != nil {
+		return nil, "", err
+	}
+	lock, err := os.ReadFile(filepath.Join(flake, "flake.lock"))
+	if err != nil {
+		return nil, "", fmt.Errorf("unlocked flake (run nix flake lock): %w", err)
+	}
+
+	sum := sha256.Sum256(lock)
+	def := &manifest.RootfsNix{Flake: flake, Package: output, LockSHA256: hex.EncodeToString(sum[:])}
+	if rel, err := filepath.Rel(dir, flake); err == nil {
+		def.Flake = filepath.ToSlash(rel)
+	}
+	return def, flake, nil
+}
@@ -0,0 +1,566 @@
+package config
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// ProfilesDir is the directory of the rootfs profile package lists
+// (<profile>.txt), relative to the config file.
+const ProfilesDir = "alpine/profiles"
+
+// BasePackages are installed in every rootfs image, build-rootfs.sh sets up
+// the OpenSSH server and resize2fs is needed to grow the filesystem.
+var BasePackages = []string{"openssh", "e2fsprogs-extra"}
+
+// Init systems of the rootfs images.
+const (
+	InitOpenRC  = "openrc"
+	InitSystemd = "systemd"
+	// InitSBX is busybox init running the sbx-rc service scripts, without a
+	// service manager.
+	InitSBX = "sbx"
+)
+
+// InitPackages are the packages installed in the images of each init
+// system. busybox, providing the sbx init, is in every Alpine image.
+var InitPackages = map[string][]string{
+	InitOpenRC:  {"openrc"},
+	InitSystemd: {"systemd"},
+	InitSBX:     nil,
+}
+
+// FirstbootPackages are installed in the images shipping the sbx-firstboot
+// service.
+var FirstbootPackages = []string{"curl", "jq"}
+
+// CloudInitPackages are installed in the cloud-init enabled images.
+var CloudInitPackages = []string{"cloud-init"}
+
+// RootfsProfile is a rootfs image built from its packages or the
+// alpine/profiles/<name>.txt package list, or inheriting the packages, files
+// and settings of another profile.
+type RootfsProfile struct {
+	Name string `yaml:"name"`
+	// Extends is the inherited profile, rootfs.profile or another
+	// rootfs.profiles entry.
+	Extends string `yaml:"extends"`
+	// Packages are installed on top of the inherited packages and the
+	// profile's package list, when it has one.
+	Packages []string `yaml:"packages"`
+	// RemovePackages are left out of the inherited packages.
+	RemovePackages []string `yaml:"remove_packages"`
+	// FilesDirs are copied over the image after the inherited ones,
+	// relative to the config file.
+	FilesDirs []string `yaml:"files_dirs"`
+	// Files are installed in the image, replacing the inherited files with
+	// the same destination.
+	Files []RootfsFile `yaml:"files"`
+	// Users are created in the image, replacing the inherited users with
+	// the same name.
+	Users []RootfsUser `yaml:"users"`
+	// Firstboot installs the sbx-firstboot service in the image (default:
+	// inherited).
+	Firstboot *bool `yaml:"firstboot"`
+	// Init is the init system of the image (default: inherited).
+	Init string `yaml:"init"`
+	// CloudInit enables cloud-init with the NoCloud datasource in the image
+	// (default: inherited).
+	CloudInit *bool `yaml:"cloud_init"`
+	// Ignition is the Ignition config applied on the first boot, relative
+	// to the config file (default: inherited).
+	Ignition string `yaml:"ignition"`
+	// Image is the OCI container image the image is built from, based on
+	// rootfs.distro (default: inherited).
+	Image string `yaml:"image"`
+	// Nix makes the profile a Nix profile: the image is the ext4 image
+	// built by the <flake directory>#<package> flake package for the
+	// architecture, relative to the config file, and the profile has no
+	// other settings.
+	Nix string `yaml:"nix"`
+	// Filesystems are the ImageFilesystems of the image published next to
+	// the ext4 one (default: inherited).
+	Filesystems []string `yaml:"filesystems"`
+	// Overlay pairs the image with an overlay disk template (default:
+	// inherited).
+	Overlay *bool `yaml:"overlay"`
+	// Verity ships a dm-verity hash tree of the read-only root image
+	// (default: inherited).
+	Verity *bool `yaml:"verity"`
+	// Timezone, Locale and HostnameTemplate set up the image (default:
+	// inherited).
+	Timezone         string `yaml:"timezone"`
+	Locale           string `yaml:"locale"`
+	HostnameTemplate string `yaml:"hostname_template"`
+
+	// Default is set for the rootfs.profile image.
+	Default bool `yaml:"-"`
+	// Definition is the effective profile definition resolved by Load.
+	Definition manifest.RootfsDefinition `yaml:"-"`
+	// EffectiveFiles are the files of Definition, with their content.
+	EffectiveFiles []RootfsFile `yaml:"-"`
+	// EffectiveUsers are the users of Definition, with their keys.
+	EffectiveUsers []RootfsUser `yaml:"-"`
+	// EffectiveIgnition is the Ignition config of Definition.
+	EffectiveIgnition string `yaml:"-"`
+	// EffectiveNix is the flake directory of a Nix profile.
+	EffectiveNix string `yaml:"-"`
+}
+
+// RootfsFile is a file installed in a rootfs image, copied from a host file
+// or written from inline content.
+type RootfsFile struct {
+	// Src is the host file, relative to the config file.
+	Src     string `yaml:"src"`
+	Content string `yaml:"content"`
+	// Dest is the absolute path in the image.
+	Dest string `yaml:"dest"`
+	// Mode is the octal file mode (default: 0644).
+	Mode string `yaml:"mode"`
+	// Owner is the user[:group] owning the file in the image (default:
+	// root:root).
+	Owner string `yaml:"owner"`
+}
+
+// Data returns the file contents.
+func (f RootfsFile) Data() ([]byte, error) {
+	if f.Src == "" {
+		return []byte(f.Content), nil
+	}
+	return os.ReadFile(f.Src)
+}
+
+// resolve validates the file at field, resolves Src relative to dir and sets
+// the defaults.
+func (f *RootfsFile) resolve(dir, field string) error {
+	if (f.Src == "") == (f.Content == "") {
+		return fmt.Errorf("%s: exactly one of src or content is required", field)
+	}
+	if !path.IsAbs(f.Dest) {
+		return fmt.Errorf("%s: dest must be an absolute path", field)
+	}
+	if f.Mode == "" {
+		f.Mode = "0644"
+	}
+	if _, err := strconv.ParseUint(f.Mode, 8, 32); err != nil {
+		return fmt.Errorf("%s: mode must be octal", field)
+	}
+	if f.Owner == "" {
+		f.Owner = "root:root"
+	}
+	if f.Src != "" && !filepath.IsAbs(f.Src) {
+		f.Src = filepath.Join(dir, f.Src)
+	}
+	return nil
+}
+
+// Stem returns the per arch file name part of the profile's image, <arch>
+// for the default profile and <name>-<arch> otherwise (rootfs-<stem>.ext4).
+func (p RootfsProfile) Stem(arch string) string {
+	if p.Default {
+		return arch
+	}
+	return p.Name + "-" + arch
+}
+
+// Bootstrap returns the bootstrap method of the profile's image,
+// distro.BootstrapNix for Nix profiles, distro.BootstrapOCI when built from
+// a container image and rootfs.bootstrap otherwise.
+func (c Config) Bootstrap(p RootfsProfile) string {
+	if p.Definition.Nix != nil {
+		return distro.BootstrapNix
+	}
+	if p.Definition.Image != "" {
+		return distro.BootstrapOCI
+	}
+	return c.Rootfs.Bootstrap
+}
+
+// ImageFilesystems are the filesystems rootfs images can be published in
+// next to ext4, made from the same image tree.
+var ImageFilesystems = []string{manifest.RootfsFilesystemSquashfs, manifest.RootfsFilesystemEROFS, manifest.RootfsFilesystemBtrfs}
+
+// RootfsFilesystems returns the filesystems the images of profile p are
+// published in, the filesystem of the rootfs-<stem>.ext4 file first, then
+// the definition filesystems and squashfs with rootfs.squashfs. Nix profile
+// images are only ext4.
+func (c Config) RootfsFilesystems(p RootfsProfile) []string {
+	filesystems := []string{manifest.RootfsFilesystemExt4}
+	if p.Definition.Nix != nil {
+		return filesystems
+	}
+	filesystems = append(filesystems, p.Definition.Filesystems...)
+	if c.Rootfs.Squashfs && !slices.Contains(filesystems, manifest.RootfsFilesystemSquashfs) {
+		filesystems = append(filesystems, manifest.RootfsFilesystemSquashfs)
+	}
+	return filesystems
+}
+
+// ReadOnlyRoot returns the filesystem of the image of profile p booted
+// read-only under the overlay or dm-verity: its first read-only
+// filesystem, or ext4.
+func (c Config) ReadOnlyRoot(p RootfsProfile) string {
+	for _, fs := range c.RootfsFilesystems(p) {
+		if manifest.RootfsFilesystemReadOnly(fs) {
+			return fs
+		}
+	}
+	return manifest.RootfsFilesystemExt4
+}
+
+// checkFilesystems validates the image filesystems of a profile.
+func checkFilesystems(filesystems []string) error {
+	for i, fs := range filesystems {
+		if !slices.Contains(ImageFilesystems, fs) {
+			return fmt.Errorf("unknown image filesystem %q (supported: %s)", fs, strings.Join(ImageFilesystems, ", "))
+		}
+		if slices.Contains(filesystems[:i], fs) {
+			return fmt.Errorf("duplicated image filesystem %q", fs)
+		}
+	}
+	return nil
+}
+
+// RootfsProfiles returns the default rootfs profile followed by the
+// configured profiles.
+func (c Config) RootfsProfiles() []RootfsProfile {
+	profiles := []RootfsProfile{{
+		Name:              c.Rootfs.Profile,
+		Files:             c.Rootfs.Files,
+		Users:             c.Rootfs.Users,
+		Default:           true,
+		Definition:        c.Rootfs.Definition,
+		EffectiveFiles:    c.Rootfs.Files,
+		EffectiveUsers:    c.Rootfs.Users,
+		EffectiveIgnition: c.Rootfs.Ignition,
+	}}
+	return append(profiles, c.Rootfs.Profiles...)
+}
+
+// resolveRootfsProfiles validates the rootfs profiles and resolves their
+// definitions through the extends chains, with the package lists, files
+// directories and files relative to dir. The requested packages of a
+// definition are BasePackages, the InitPackages of its init system,
+// FirstbootPackages when it installs the first boot service,
+// CloudInitPackages when it enables cloud-init, TimezonePackages for a
+// timezone other than UTC and the profile packages.
+func (c *Config) resolveRootfsProfiles(dir string) error {
+	d, err := distro.Get(c.Rootfs.Distro)
+	if err != nil {
+		return err
+	}
+	// The first init system of the distro is the default one.
+	defaultInit := d.Inits()[0]
+
+	pkgs := c.Rootfs.Packages
+	if len(pkgs) == 0 {
+		if pkgs, err = readPackageList(filepath.Join(dir, ProfilesDir, c.Rootfs.Profile+".txt")); err != nil {
+			return fmt.Errorf("rootfs.profile: no rootfs.packages: %w", err)
+		}
+	}
+	if c.Rootfs.Init == "" {
+		c.Rootfs.Init = defaultInit
+	}
+	if _, ok := InitPackages[c.Rootfs.Init]; !ok {
+		return fmt.Errorf("rootfs.init: unknown init system %q", c.Rootfs.Init)
+	}
+	c.Rootfs.Definition = manifest.RootfsDefinition{
+		Firstboot:        c.Rootfs.Firstboot,
+		Init:             c.Rootfs.Init,
+		CloudInit:        c.Rootfs.CloudInit,
+		Image:            c.Rootfs.Image,
+		Timezone:         cmp.Or(c.Rootfs.Timezone, "UTC"),
+		Locale:           cmp.Or(c.Rootfs.Locale, "C.UTF-8"),
+		HostnameTemplate: c.Rootfs.HostnameTemplate,
+		Filesystems:      c.Rootfs.Filesystems,
+		Overlay:          c.Rootfs.Overlay,
+		Verity:           c.Rootfs.Verity,
+	}
+	if err := checkCloudInit(c.Rootfs.Definition); err != nil {
+		return fmt.Errorf("rootfs: %w", err)
+	}
+	if err := checkFilesystems(c.Rootfs.Filesystems); err != nil {
+		return fmt.Errorf("rootfs.filesystems: %w", err)
+	}
+	for i := range c.Rootfs.Files {
+		if err := c.Rootfs.Files[i].resolve(dir, fmt.Sprintf("rootfs.files[%d]", i)); err != nil {
+			return err
+		}
+	}
+	if c.Rootfs.Definition.Files, err = fileDefinitions(c.Rootfs.Files, dir); err != nil {
+		return fmt.Errorf("rootfs.files: %w", err)
+	}
+	if err := resolveUsers(c.Rootfs.Users, dir, "rootfs.users"); err != nil {
+		return err
+	}
+	c.Rootfs.Definition.Users = userDefinitions(c.Rootfs.Users)
+	if c.Rootfs.Ignition != "" {
+		if !filepath.IsAbs(c.Rootfs.Ignition) {
+			c.Rootfs.Ignition = filepath.Join(dir, c.Rootfs.Ignition)
+		}
+		if c.Rootfs.Definition.Ignition, err = ignitionDefinition(c.Rootfs.Ignition, dir); err != nil {
+			return fmt.Errorf("rootfs.ignition: %w", err)
+		}
+	}
+
+	// packages are the profile packages of each profile, without the base
+	// and first boot packages.
+	packages := map[string][]string{c.Rootfs.Profile: pkgs}
+	index := map[string]int{c.Rootfs.Profile: -1}
+	for i := range c.Rootfs.Profiles {
+		p := &c.Rootfs.Profiles[i]
+		field := fmt.Sprintf("rootfs.profiles[%d]", i)
+		if !flavorNameRe.MatchString(p.Name) {
+			return fmt.Errorf("%s: name must be lowercase alphanumeric words separated by dashes", field)
+		}
+		if _, ok := index[p.Name]; ok {
+			return fmt.Errorf("%s: duplicated profile %q", field, p.Name)
+		}
+		index[p.Name] = i
+		if _, ok := InitPackages[p.Init]; p.Init != "" && !ok {
+			return fmt.Errorf("%s: unknown init system %q", field, p.Init)
+		}
+		for j, d := range p.FilesDirs {
+			if !filepath.IsAbs(d) {
+				p.FilesDirs[j] = filepath.Join(dir, d)
+			}
+		}
+		for j := range p.Files {
+			if err := p.Files[j].resolve(dir, fmt.Sprintf("%s.files[%d]", field, j)); err != nil {
+				return err
+			}
+		}
+		if err := resolveUsers(p.Users, dir, field+".users"); err != nil {
+			return err
+		}
+		if p.Ignition != "" && !filepath.IsAbs(p.Ignition) {
+			p.Ignition = filepath.Join(dir, p.Ignition)
+		}
+		if err := checkFilesystems(p.Filesystems); err != nil {
+			return fmt.Errorf("%s.filesystems: %w", field, err)
+		}
+	}
+
+	// resolve sets the definition of the i-th profile after its parent's,
+	// visiting tracks the chain being resolved to report cycles.
+	var resolve func(i int, visiting map[string]bool) error
+	resolve = func(i int, visiting map[string]bool) error {
+		p := &c.Rootfs.Profiles[i]
+		field := fmt.Sprintf("rootfs.profiles[%d]", i)
+		if _, ok := packages[p.Name]; ok {
+			return nil
+		}
+		if visiting[p.Name] {
+			return fmt.Errorf("%s: extends cycle through %q", field, p.Name)
+		}
+		visiting[p.Name] = true
+
+		if p.Nix != "" {
+			if p.Extends != "" || p.Image != "" || len(p.Packages) > 0 || len(p.FilesDirs) > 0 || len(p.Files) > 0 || len(p.Users) > 0 || p.Ignition != "" || len(p.Filesystems) > 0 || p.Overlay != nil || p.Verity != nil {
+				return fmt.Errorf("%s: nix profiles are built as is by their flake, without extends, image, packages, files, users, filesystems, overlay or verity", field)
+			}
+			def, flake, err := nixDefinition(p.Nix, dir)
+			if err != nil {
+				return fmt.Errorf("%s.nix: %w", field, err)
+			}
+			p.Definition = manifest.RootfsDefinition{Nix: def}
+			p.EffectiveNix = flake
+			packages[p.Name] = nil
+			return nil
+		}
+
+		var (
+			parent      manifest.RootfsDefinition
+			parentFiles []RootfsFile
+			parentUsers []RootfsUser
+			parentIgn   string
+		)
+		if p.Extends != "" {
+			j, ok := index[p.Extends]
+			if !ok {
+				return fmt.Errorf("%s: extends unknown profile %q", field, p.Extends)
+			}
+			parent, parentFiles, parentUsers, parentIgn = c.Rootfs.Definition, c.Rootfs.Files, c.Rootfs.Users, c.Rootfs.Ignition
+			if j >= 0 {
+				if err := resolve(j, visiting); err != nil {
+					return err
+				}
+				pp := c.Rootfs.Profiles[j]
+				if pp.Nix != "" {
+					return fmt.Errorf("%s: cannot extend the nix profile %q", field, p.Extends)
+				}
+				parent, parentFiles, parentUsers, parentIgn = pp.Definition, pp.EffectiveFiles, pp.EffectiveUsers, pp.EffectiveIgnition
+			}
+		}
+
+		own, err := readPackageList(filepath.Join(dir, ProfilesDir, p.Name+".txt"))
+		if errors.Is(err, os.ErrNotExist) && (p.Extends != "" || len(p.Packages) > 0) {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+
+		def := manifest.RootfsDefinition{
+			FilesDirs: append(slices.Clone(parent.FilesDirs), p.FilesDirs...),
+			Firstboot: parent.Firstboot,
+			Init:      parent.Init,
+			CloudInit: parent.CloudInit,
+			Ignition:  parent.Ignition,
+			Image:     cmp.Or(p.Image, parent.Image),
+
+			Timezone:         cmp.Or(p.Timezone, parent.Timezone, "UTC"),
+			Locale:           cmp.Or(p.Locale, parent.Locale, "C.UTF-8"),
+			HostnameTemplate: cmp.Or(p.HostnameTemplate, parent.HostnameTemplate),
+			Filesystems:      parent.Filesystems,
+			Overlay:          parent.Overlay,
+			Verity:           parent.Verity,
+		}
+		if p.Filesystems != nil {
+			def.Filesystems = p.Filesystems
+		}
+		if p.Overlay != nil {
+			def.Overlay = *p.Overlay
+		}
+		if p.Verity != nil {
+			def.Verity = *p.Verity
+		}
+		if p.Extends == "" {
+			def.Init = defaultInit
+		} else {
+			def.Extends = append([]string{p.Extends}, parent.Extends...)
+		}
+		if p.Firstboot != nil {
+			def.Firstboot = *p.Firstboot
+		}
+		if p.Init != "" {
+			def.Init = p.Init
+		}
+		if p.CloudInit != nil {
+			def.CloudInit = *p.CloudInit
+		}
+		if err := checkCloudInit(def); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		var pkgs []string
+		for _, pkg := range slices.Concat(packages[p.Extends], own, p.Packages) {
+			if !slices.Contains(pkgs, pkg) && !slices.Contains(p.RemovePackages, pkg) {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+		p.EffectiveFiles = mergeBy(parentFiles, p.Files, func(f RootfsFile) string { return f.Dest })
+		if def.Files, err = fileDefinitions(p.EffectiveFiles, dir); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		p.EffectiveUsers = mergeBy(parentUsers, p.Users, func(u RootfsUser) string { return u.Name })
+		def.Users = userDefinitions(p.EffectiveUsers)
+		p.EffectiveIgnition = parentIgn
+		if p.Ignition != "" {
+			p.EffectiveIgnition = p.Ignition
+			if def.Ignition, err = ignitionDefinition(p.Ignition, dir); err != nil {
+				return fmt.Errorf("%s.ignition: %w", field, err)
+			}
+		}
+
+		packages[p.Name] = pkgs
+		p.Definition = def
+		return nil
+	}
+	for i := range c.Rootfs.Profiles {
+		if err := resolve(i, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+
+	c.Rootfs.Definition.Packages = requestedPackages(c.Rootfs.Definition, packages[c.Rootfs.Profile])
+	for i := range c.Rootfs.Profiles {
+		p := &c.Rootfs.Profiles[i]
+		if p.Nix == "" {
+			p.Definition.Packages = requestedPackages(p.Definition, packages[p.Name])
+		}
+	}
+	return nil
+}
+
+// fileDefinitions returns the manifest definitions of files, with the
+// sources relative to dir.
+func fileDefinitions(files []RootfsFile, dir string) ([]manifest.RootfsFile, error) {
+	var defs []manifest.RootfsFile
+	for _, f := range files {
+		data, err := f.Data()
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		def := manifest.RootfsFile{Dest: f.Dest, Mode: f.Mode, Owner: f.Owner, SHA256: hex.EncodeToString(sum[:])}
+		if f.Src != "" {
+			def.Source = f.Src
+			if rel, err := filepath.Rel(dir, f.Src); err == nil {
+				def.Source = filepath.ToSlash(rel)
+			}
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// checkCloudInit checks that the init system of def can run cloud-init,
+// which needs a service manager.
+func checkCloudInit(def manifest.RootfsDefinition) error {
+	if def.CloudInit && def.Init == InitSBX {
+		return fmt.Errorf("cloud_init requires the %s or %s init system", InitOpenRC, InitSystemd)
+	}
+	return nil
+}
+
+// requestedPackages returns the packages installed in an image of def with
+// the given profile packages, without duplicates.
+func requestedPackages(def manifest.RootfsDefinition, profile []string) []string {
+	all := slices.Concat(BasePackages, InitPackages[def.Init])
+	if def.Firstboot {
+		all = append(all, FirstbootPackages...)
+	}
+	if def.CloudInit {
+		all = append(all, CloudInitPackages...)
+	}
+	if def.Timezone != "UTC" {
+		all = append(all, TimezonePackages...)
+	}
+	var pkgs []string
+	for _, pkg := range append(all, profile...) {
+		if !slices.Contains(pkgs, pkg) {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
+
+// readPackageList reads a profile package list, one package per line with
+// # comments.
+func readPackageList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []string
+	for line := range strings.SplitSeq(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			pkgs = append(pkgs, line)
+		}
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaID is the $id of the config.yaml JSON Schema.
+const SchemaID = "https://github.com/slok/sbx-images/config.schema.json"
+
+// Schema returns the JSON Schema of config.yaml, generated from the Config
+// fields so it never drifts from the parser: every field with its type,
+// unknown fields rejected and the x- prefixed extension fields allowed at
+// the top level. The semantic rules are checked by Lint.
+func Schema() map[string]any {
+	s := schemaOf(reflect.TypeFor[Config]())
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["$id"] = SchemaID
+	s["title"] = "sbx-images config.yaml"
+	return s
+}
+
+func schemaOf(t reflect.Type) map[string]any {
+	if t == reflect.TypeFor[time.Duration]() {
+		return map[string]any{"type": "string", "pattern": `^([0-9.]+(ns|us|µs|ms|s|m|h))+$`}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		// The parser reads any scalar into strings, e.g. distro_version: 12.
+		return map[string]any{"type": []string{"string", "number", "boolean"}}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := map[string]any{"type": "object", "additionalProperties": false}
+		addProperties(s, t)
+		return s
+	}
+	// Free-form values (any).
+	return map[string]any{}
+}
+
+// addProperties adds the yaml fields of the struct t to the object schema
+// s, flattening the inline ones.
+func addProperties(s map[string]any, t reflect.Type) {
+	props, _ := s["properties"].(map[string]any)
+	if props == nil {
+		props = map[string]any{}
+		s["properties"] = props
+	}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch {
+		case name == "-":
+		case opts == "inline" && f.Type.Kind() == reflect.Struct:
+			addProperties(s, f.Type)
+		case opts == "inline" && t == reflect.TypeFor[Config]():
+			// The top level inline map holds the extension fields.
+			s["patternProperties"] = map[string]any{"^" + ExtensionPrefix: map[string]any{}}
+		case opts == "inline":
+			s["additionalProperties"] = schemaOf(f.Type.Elem())
+		default:
+			props[yamlName(name, f.Name)] = schemaOf(f.Type)
+		}
+	}
+}
+
+// yamlName returns the yaml name of a field, the yaml default being its
+// lowercased Go name.
+func yamlName(tag, field string) string {
+	if tag != "" {
+		return tag
+	}
+	return strings.ToLower(field)
+}
+
+// ValidateSchema checks the config document data (YAML) against Schema,
+// returning every violation as "line <n>: <field>: <problem>", the unknown
+// fields as the loader reports them: "line <n>: unknown field <name>".
+func ValidateSchema(data []byte) ([]string, error) {
+	schemaData, err := json.Marshal(Schema())
+	if err != nil {
+		return nil, err
+	}
+	schemaDoc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schemaData))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(SchemaID, schemaDoc); err != nil {
+		return nil, err
+	}
+	schema, err := c.Compile(SchemaID)
+	if err != nil {
+		return nil, fmt.Errorf("compiling schema: %w", err)
+	}
+
+	// Round trip through JSON for the types the schema sees, as the
+	// extension fields.
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = map[string]any{}
+	}
+	data, err = json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var verr *jsonschema.ValidationError
+	if err := schema.Validate(doc); !errors.As(err, &verr) {
+		return nil, err
+	}
+	var root *yaml.Node
+	if len(node.Content) > 0 {
+		root = node.Content[0]
+	}
+	var problems []string
+	for _, u := range verr.BasicOutput().Errors {
+		if u.Error == nil {
+			continue
+		}
+		n := nodeAt(root, u.InstanceLocation)
+		if ap, ok := u.Error.Kind.(*kind.AdditionalProperties); ok && n != nil && n.Kind == yaml.MappingNode {
+			for _, prop := range ap.Properties {
+				line := n.Line
+				if i := valueIndex(n, prop); i > 0 {
+					line = n.Content[i-1].Line
+				}
+				problems = append(problems, fmt.Sprintf("line %d: unknown field %s", line, prop))
+			}
+			continue
+		}
+		var line int
+		if n != nil {
+			line = n.Line
+		}
+		problems = append(problems, fmt.Sprintf("line %d: %s: %s", line, fieldPath(u.InstanceLocation), u.Error))
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problemLine(problems[i]) < problemLine(problems[j]) })
+	return problems, nil
+}
+
+// nodeAt returns the node of the document root at the JSON pointer, nil when
+// there is none.
+func nodeAt(root *yaml.Node, pointer string) *yaml.Node {
+	n := root
+	if pointer == "" {
+		return n
+	}
+	for tok := range strings.SplitSeq(strings.TrimPrefix(pointer, "/"), "/") {
+		if n == nil {
+			return nil
+		}
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		switch n.Kind {
+		case yaml.MappingNode:
+			i := valueIndex(n, tok)
+			if i < 0 {
+				return nil
+			}
+			n = n.Content[i]
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i >= len(n.Content) {
+				return nil
+			}
+			n = n.Content[i]
+		default:
+			return nil
+		}
+	}
+	return n
+}
+
+// fieldPath returns the config field of a JSON pointer, e.g.
+// kernel.flavors[0].version for /kernel/flavors/0/version.
+func fieldPath(pointer string) string {
+	if pointer == "" {
+		return "(top level)"
+	}
+	var b strings.Builder
+	for tok := range strings.SplitSeq(strings.TrimPrefix(pointer, "/"), "/") {
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		if _, err := strconv.Atoi(tok); err == nil {
+			fmt.Fprintf(&b, "[%s]", tok)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(tok)
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data string
+		want []string
+	}{
+		"valid": {
+			data: "kernel:\n  version: 6.1.155\nx-team: sandbox\n",
+		},
+		"unknown fields have the loader lines": {
+			data: "kernel:\n  version: 6.1.155\n  verzion: 6.1.155\nfirecraker:\n  version: v1.14.1\n",
+			want: []string{"line 3: unknown field verzion", "line 4: unknown field firecraker"},
+		},
+		"mistyped values have their lines": {
+			data: "architectures: [x86_64]\nkernel:\n  flavors: 3\n",
+			want: []string{"line 3: kernel.flavors: got number, want array"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ValidateSchema([]byte(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/slok/sbx-images/pkg/distro"
+)
+
+// TimezonePackages are installed in the images with a timezone other than
+// UTC, providing the zoneinfo files.
+var TimezonePackages = []string{"tzdata"}
+
+var (
+	timezoneRe = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+	localeRe   = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+	hostnameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+)
+
+// HostnameData is the data of the hostname templates.
+type HostnameData struct {
+	Profile string
+	Arch    string
+	// Stem is the per arch file name part of the image (see
+	// RootfsProfile.Stem).
+	Stem string
+}
+
+// Hostname returns the hostname of the profile's image for arch, rendered
+// from the definition's hostname template (lowercased, underscores replaced
+// with dashes), empty without one.
+func (p RootfsProfile) Hostname(arch string) (string, error) {
+	return renderHostname(p.Definition.HostnameTemplate, HostnameData{Profile: p.Name, Arch: arch, Stem: p.Stem(arch)})
+}
+
+func renderHostname(tmpl string, data HostnameData) (string, error) {
+	if tmpl == "" {
+		return "", nil
+	}
+	t, err := template.New("hostname").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("hostname_template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("hostname_template: %w", err)
+	}
+	name := strings.ReplaceAll(strings.ToLower(buf.String()), "_", "-")
+	if len(name) > 253 || !hostnameRe.MatchString(name) {
+		return "", fmt.Errorf("hostname_template: %q is not a valid hostname", name)
+	}
+	return name, nil
+}
+
+// checkSettings validates the init system against the distro, the image
+// reference, timezone, locale and rendered hostnames of the rootfs profiles,
+// but the Nix ones.
+func (c Config) checkSettings() error {
+	d, err := distro.Get(c.Rootfs.Distro)
+	if err != nil {
+		return err
+	}
+	for _, p := range c.RootfsProfiles() {
+		if p.Definition.Nix != nil {
+			continue
+		}
+		if !slices.Contains(d.Inits(), p.Definition.Init) {
+			return fmt.Errorf("rootfs profile %s: init %q is not supported by %s (supported: %s)", p.Name, p.Definition.Init, d.Name(), strings.Join(d.Inits(), ", "))
+		}
+		if img := p.Definition.Image; img != "" {
+			if _, err := name.ParseReference(img); err != nil {
+				return fmt.Errorf("rootfs profile %s: image: %w", p.Name, err)
+			}
+		}
+		if tz := p.Definition.Timezone; tz != "" && !timezoneRe.MatchString(tz) {
+			return fmt.Errorf("rootfs profile %s: invalid timezone %q", p.Name, tz)
+		}
+		if l := p.Definition.Locale; l != "" && !localeRe.MatchString(l) {
+			return fmt.Errorf("rootfs profile %s: invalid locale %q", p.Name, l)
+		}
+		for _, arch := range c.Architectures {
+			if _, err := p.Hostname(arch); err != nil {
+				return fmt.Errorf("rootfs profile %s: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
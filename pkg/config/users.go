@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// RootfsUser is a user account created in a rootfs image at build time.
+// Existing accounts (e.g. root) get the shell, groups and keys.
+type RootfsUser struct {
+	Name string `yaml:"name"`
+	// UID is the user ID (default: allocated by adduser).
+	UID int `yaml:"uid"`
+	// Shell is the login shell (default: /bin/sh).
+	Shell string `yaml:"shell"`
+	// Groups are supplementary groups, created when missing.
+	Groups []string `yaml:"groups"`
+	// SSHAuthorizedKeys are public keys added to ~/.ssh/authorized_keys.
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
+	// SSHAuthorizedKeysFiles are authorized_keys files appended to the
+	// keys, relative to the config file.
+	SSHAuthorizedKeysFiles []string `yaml:"ssh_authorized_keys_files"`
+}
+
+var userNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// resolve validates the user at field, resolves the keys files relative to
+// dir and sets the defaults.
+func (u *RootfsUser) resolve(dir, field string) error {
+	if !userNameRe.MatchString(u.Name) {
+		return fmt.Errorf("%s: invalid user name %q", field, u.Name)
+	}
+	if u.UID < 0 {
+		return fmt.Errorf("%s: uid must be positive", field)
+	}
+	if u.Shell == "" {
+		u.Shell = "/bin/sh"
+	}
+	if !path.IsAbs(u.Shell) {
+		return fmt.Errorf("%s: shell must be an absolute path", field)
+	}
+	for _, g := range u.Groups {
+		if !userNameRe.MatchString(g) {
+			return fmt.Errorf("%s: invalid group name %q", field, g)
+		}
+	}
+	for i, f := range u.SSHAuthorizedKeysFiles {
+		if !filepath.IsAbs(f) {
+			u.SSHAuthorizedKeysFiles[i] = filepath.Join(dir, f)
+		}
+	}
+	return nil
+}
+
+// AuthorizedKeys returns the user's authorized_keys file contents, empty
+// without keys.
+func (u RootfsUser) AuthorizedKeys() (string, error) {
+	var b strings.Builder
+	for _, k := range u.SSHAuthorizedKeys {
+		b.WriteString(strings.TrimSpace(k) + "\n")
+	}
+	for _, f := range u.SSHAuthorizedKeysFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return "", fmt.Errorf("ssh keys of user %s: %w", u.Name, err)
+		}
+		for line := range strings.SplitSeq(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				b.WriteString(line + "\n")
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// resolveUsers validates the users at field, resolving them relative to dir.
+func resolveUsers(users []RootfsUser, dir, field string) error {
+	seen := map[string]bool{}
+	for i := range users {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if err := users[i].resolve(dir, f); err != nil {
+			return err
+		}
+		if seen[users[i].Name] {
+			return fmt.Errorf("%s: duplicated user %q", f, users[i].Name)
+		}
+		seen[users[i].Name] = true
+	}
+	return nil
+}
+
+// userDefinitions returns the manifest definitions of users, without their
+// keys.
+func userDefinitions(users []RootfsUser) []manifest.RootfsUser {
+	var defs []manifest.RootfsUser
+	for _, u := range users {
+		defs = append(defs, manifest.RootfsUser{Name: u.Name, UID: u.UID, Shell: u.Shell, Groups: u.Groups})
+	}
+	return defs
+}
+
+// mergeBy returns the inherited items not replaced by an own item with the
+// same key, followed by the own items.
+func mergeBy[T any](inherited, own []T, key func(T) string) []T {
+	var merged []T
+	for _, item := range inherited {
+		if !slices.ContainsFunc(own, func(o T) bool { return key(o) == key(item) }) {
+			merged = append(merged, item)
+		}
+	}
+	return append(merged, own...)
+}
@@ -0,0 +1,146 @@
+// Package container abstracts the container engines running the
+// containerized build steps (the Debian base rootfs bootstrap), Docker and
+// Podman, rootful or rootless.
+//
+// The engines share their command line but differ in how the container
+// processes map to the calling user: a rootless engine runs the container
+// root as that user (Podman keeps its ID with --userns=keep-id), a rootful
+// one runs containers as the requested host user.
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Runtime names.
+const (
+	// Auto detects the runtime, see Detect.
+	Auto   = "auto"
+	Docker = "docker"
+	Podman = "podman"
+)
+
+// Names are the supported runtime names.
+var Names = []string{Auto, Docker, Podman}
+
+// Runtime is a container engine.
+type Runtime interface {
+	// Name returns the engine command (docker or podman).
+	Name() string
+	// Rootless reports whether the engine runs unprivileged, its
+	// containers in a user namespace of the calling user.
+	Rootless() bool
+	// Volume returns the run flags bind mounting the host path at the
+	// container path.
+	Volume(host, path string, readOnly bool) []string
+	// User returns the run flags running the container processes as the
+	// calling user, the files they write to volumes owned by that user.
+	User() []string
+}
+
+// New returns the runtime named name, detected for Auto.
+func New(ctx context.Context, name string) (Runtime, error) {
+	switch name {
+	case Auto, "":
+		return Detect(ctx)
+	case Docker:
+		return newDocker(ctx)
+	case Podman:
+		return newPodman(ctx)
+	default:
+		return nil, fmt.Errorf("unknown container runtime %q (supported: %s)", name, strings.Join(Names, ", "))
+	}
+}
+
+// Detect returns the first usable runtime: Docker when its daemon answers,
+// then Podman.
+func Detect(ctx context.Context) (Runtime, error) {
+	if d, err := newDocker(ctx); err == nil {
+		return d, nil
+	}
+	if p, err := newPodman(ctx); err == nil {
+		return p, nil
+	}
+	return nil, fmt.Errorf("no container runtime found: install podman or start the docker daemon")
+}
+
+type docker struct{ rootless bool }
+
+func newDocker(ctx context.Context) (*docker, error) {
+	out, err := info(ctx, Docker, "{{json .SecurityOptions}}")
+	if err != nil {
+		return nil, err
+	}
+	return &docker{rootless: strings.Contains(out, "name=rootless")}, nil
+}
+
+func (*docker) Name() string     { return Docker }
+func (d *docker) Rootless() bool { return d.rootless }
+
+func (*docker) Volume(host, path string, readOnly bool) []string {
+	return []string{"--volume", volume(host, path, readOnly, "")}
+}
+
+func (d *docker) User() []string {
+	if d.rootless {
+		return nil
+	}
+	return []string{"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())}
+}
+
+type podman struct{ rootless bool }
+
+func newPodman(ctx context.Context) (*podman, error) {
+	out, err := info(ctx, Podman, "{{.Host.Security.Rootless}}")
+	if err != nil {
+		return nil, err
+	}
+	return &podman{rootless: out == "true"}, nil
+}
+
+func (*podman) Name() string     { return Podman }
+func (p *podman) Rootless() bool { return p.rootless }
+
+// Volume relabels the volumes (z) for SELinux hosts, a no-op elsewhere.
+func (*podman) Volume(host, path string, readOnly bool) []string {
+	return []string{"--volume", volume(host, path, readOnly, "z")}
+}
+
+func (p *podman) User() []string {
+	if p.rootless {
+		return []string{"--userns=keep-id"}
+	}
+	return []string{"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())}
+}
+
+func volume(host, path string, readOnly bool, label string) string {
+	var opts []string
+	if readOnly {
+		opts = append(opts, "ro")
+	}
+	if label != "" {
+		opts = append(opts, label)
+	}
+	v := host + ":" + path
+	if len(opts) > 0 {
+		v += ":" + strings.Join(opts, ",")
+	}
+	return v
+}
+
+// info returns the engine info field rendered by format, failing when the
+// engine is missing or unusable (e.g. no docker daemon).
+func info(ctx context.Context, name, format string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", err
+	}
+	out, err := exec.CommandContext(ctx, name, "info", "--format", format).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s info: %w", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
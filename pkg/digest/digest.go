@@ -0,0 +1,39 @@
+// Package digest hashes the build files: the artifacts, their materials and
+// the configs recorded in the manifest, attestations, caches and TUF
+// metadata.
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/slok/sbx-images/pkg/progress"
+)
+
+// File returns the size and hex encoded SHA256 digest of the file at path,
+// the SizeBytes and SHA256 of its manifest artifact. The hashing of large
+// files reports its progress.
+func File(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, "", fmt.Errorf("stat %s: %w", path, err)
+	}
+	p := progress.New("hashing "+filepath.Base(path), fi.Size())
+	defer p.Done()
+	h := sha256.New()
+	size, err := io.Copy(h, p.Reader(f))
+	if err != nil {
+		return 0, "", fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
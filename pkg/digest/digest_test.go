@@ -0,0 +1,27 @@
+package digest_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/digest"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	size, sum, err := digest.File(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; size != 3 || sum != want {
+		t.Errorf("got %d bytes sha256:%s, want 3 bytes sha256:%s", size, sum, want)
+	}
+
+	if _, _, err := digest.File(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
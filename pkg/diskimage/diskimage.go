@@ -0,0 +1,115 @@
+// Package diskimage converts rootfs images between the raw and qcow2 disk
+// image formats, for the hypervisors taking qcow2 disks, without qemu-img:
+// the images are streamed cluster by cluster, the all-zero ones left out of
+// the qcow2 images and written as holes in the raw ones, so converting a
+// multi-GB image only takes the memory of its L2 tables.
+//
+// The qcow2 images written are version 3 (compat 1.1, QEMU 1.1 and later)
+// with 64KiB clusters, uncompressed, readable by every qcow2 consumer.
+package diskimage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/progress"
+	"github.com/slok/sbx-images/pkg/sparse"
+)
+
+// Info describes a disk image file.
+type Info struct {
+	// Format is manifest.DiskFormatRaw or manifest.DiskFormatQCOW2.
+	Format string `json:"format"`
+	// VirtualSizeBytes is the size of the disk the image holds, the file
+	// size of raw images.
+	VirtualSizeBytes int64 `json:"virtual_size_bytes"`
+	// SizeBytes is the file size.
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+// Detect returns the Info of the image file at path, qcow2 when it starts
+// with the qcow2 magic, raw otherwise.
+func Detect(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	_, info, err := open(f)
+	return info, err
+}
+
+// Convert writes the disk of the image at src (raw or qcow2) to dst in
+// format, one of manifest.DiskFormats, and returns the Info of dst.
+func Convert(src, dst, format string) (Info, error) {
+	if !slices.Contains(manifest.DiskFormats, format) {
+		return Info{}, fmt.Errorf("unknown disk format %q (supported: %s)", format, strings.Join(manifest.DiskFormats, ", "))
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return Info{}, err
+	}
+	defer in.Close()
+	disk, srcInfo, err := open(in)
+	if err != nil {
+		return Info{}, fmt.Errorf("reading %s: %w", src, err)
+	}
+
+	// The image is written next to dst and renamed, dst is never left
+	// half written.
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	p := progress.New(fmt.Sprintf("converting %s to %s", filepath.Base(src), format), srcInfo.VirtualSizeBytes)
+	r := p.Reader(io.NewSectionReader(disk, 0, srcInfo.VirtualSizeBytes))
+	switch format {
+	case manifest.DiskFormatQCOW2:
+		err = writeQCOW2(out, r, srcInfo.VirtualSizeBytes)
+	default:
+		_, err = sparse.Copy(out, r)
+	}
+	p.Done()
+	if err != nil {
+		return Info{}, fmt.Errorf("converting %s: %w", src, err)
+	}
+	if err := out.Chmod(0o644); err != nil {
+		return Info{}, err
+	}
+	if err := out.Close(); err != nil {
+		return Info{}, err
+	}
+	if err := os.Rename(out.Name(), dst); err != nil {
+		return Info{}, err
+	}
+	return Detect(dst)
+}
+
+// open returns the virtual disk of the image f and its Info.
+func open(f *os.File) (io.ReaderAt, Info, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, Info{}, err
+	}
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return nil, Info{}, err
+	}
+	if binary.BigEndian.Uint32(magic) != qcow2Magic {
+		return f, Info{Format: manifest.DiskFormatRaw, VirtualSizeBytes: fi.Size(), SizeBytes: fi.Size()}, nil
+	}
+	img, err := openQCOW2(f)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return img, Info{Format: manifest.DiskFormatQCOW2, VirtualSizeBytes: img.Size(), SizeBytes: fi.Size()}, nil
+}
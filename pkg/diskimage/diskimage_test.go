@@ -0,0 +1,67 @@
+package diskimage_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/diskimage"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/testutil"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	img, err := testutil.Ext4(testutil.RootfsFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A disk spanning several clusters, most of them zero.
+	raw := make([]byte, 1<<20)
+	copy(raw, img)
+	copy(raw[len(raw)-len(img):], img)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "rootfs.ext4")
+	if err := os.WriteFile(src, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	qcow2 := filepath.Join(dir, "rootfs.ext4.qcow2")
+	info, err := diskimage.Convert(src, qcow2, manifest.DiskFormatQCOW2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != manifest.DiskFormatQCOW2 || info.VirtualSizeBytes != int64(len(raw)) {
+		t.Errorf("got %+v, want a qcow2 image of a %d bytes disk", info, len(raw))
+	}
+	if info.SizeBytes >= int64(len(raw)) {
+		t.Errorf("got a %d bytes qcow2 image, want the zero clusters left out", info.SizeBytes)
+	}
+	detected, err := diskimage.Detect(qcow2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detected != info {
+		t.Errorf("detected %+v, want %+v", detected, info)
+	}
+
+	back := filepath.Join(dir, "rootfs.raw")
+	if _, err := diskimage.Convert(qcow2, back, manifest.DiskFormatRaw); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Error("the raw image converted back differs from the source")
+	}
+}
+
+func TestConvertUnknownFormat(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := diskimage.Convert(filepath.Join(dir, "in"), filepath.Join(dir, "out"), "vmdk"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
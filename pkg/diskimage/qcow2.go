@@ -0,0 +1,301 @@
+package diskimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// qcow2 format constants, see the QEMU qcow2 specification
+// (docs/interop/qcow2.txt).
+const (
+	qcow2Magic   = 0x514649fb
+	qcow2Version = 3
+	// clusterBits are the 64KiB clusters qemu-img defaults to.
+	clusterBits = 16
+	clusterSize = 1 << clusterBits
+	// l2Entries is the number of clusters an L2 table maps (512MiB).
+	l2Entries = clusterSize / 8
+	// refcountOrder are 16 bit refcounts, the qemu-img default, a
+	// refcount block counting refcountEntries clusters.
+	refcountOrder   = 4
+	refcountEntries = clusterSize * 8 / (1 << refcountOrder)
+	headerLength    = 104
+
+	// copiedFlag marks the L1 and L2 entries of the clusters with a
+	// refcount of one, written in place.
+	copiedFlag = 1 << 63
+	// compressedFlag marks the compressed clusters of L2 entries.
+	compressedFlag = 1 << 62
+	// zeroFlag marks the L2 entries of the clusters reading as zeros.
+	zeroFlag = 1
+	// offsetMask is the host cluster offset of the L1 and L2 entries.
+	offsetMask = 0x00fffffffffffe00
+)
+
+// qcow2Header is the qcow2 version 3 header, big endian.
+type qcow2Header struct {
+	Magic                 uint32
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+	IncompatibleFeatures  uint64
+	CompatibleFeatures    uint64
+	AutoclearFeatures     uint64
+	RefcountOrder         uint32
+	HeaderLength          uint32
+}
+
+// Incompatible features the reader does not support.
+const (
+	incompatibleExternalData = 1 << 2
+	incompatibleExtendedL2   = 1 << 4
+)
+
+// writeQCOW2 writes the size bytes of r to f, a new file, as a qcow2 image
+// of virtual size size. The data clusters are appended as r is read, the
+// all-zero ones skipped, reading back as zeros; the L2 tables, kept in
+// memory, and the refcounts follow them, the header and L1 table at the
+// start of the file are written last.
+//
+// Layout: header, L1 table, data clusters, L2 tables, refcount blocks,
+// refcount table.
+func writeQCOW2(f *os.File, r io.Reader, size int64) error {
+	l1Size := ceilDiv(size, clusterSize*l2Entries)
+	l1Clusters := ceilDiv(l1Size*8, clusterSize)
+	next := (1 + l1Clusters) * clusterSize
+
+	l2 := make([][]uint64, l1Size)
+	buf := make([]byte, clusterSize)
+	for cluster := int64(0); cluster*clusterSize < size; cluster++ {
+		n, err := io.ReadFull(r, buf[:min(clusterSize, size-cluster*clusterSize)])
+		if err != nil {
+			return fmt.Errorf("reading cluster %d: %w", cluster, err)
+		}
+		if isZero(buf[:n]) {
+			continue
+		}
+		clear(buf[n:])
+		if _, err := f.WriteAt(buf, next); err != nil {
+			return err
+		}
+		table := l2[cluster/l2Entries]
+		if table == nil {
+			table = make([]uint64, l2Entries)
+			l2[cluster/l2Entries] = table
+		}
+		table[cluster%l2Entries] = uint64(next) | copiedFlag
+		next += clusterSize
+	}
+
+	l1 := make([]byte, l1Clusters*clusterSize)
+	for i, table := range l2 {
+		if table == nil {
+			continue
+		}
+		data := make([]byte, clusterSize)
+		for j, e := range table {
+			binary.BigEndian.PutUint64(data[j*8:], e)
+		}
+		if _, err := f.WriteAt(data, next); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(l1[i*8:], uint64(next)|copiedFlag)
+		next += clusterSize
+	}
+
+	// The refcount blocks count every cluster of the file, themselves and
+	// the refcount table included.
+	used := next / clusterSize
+	var blocks, tableClusters int64
+	for {
+		b := ceilDiv(used+blocks+tableClusters, refcountEntries)
+		t := ceilDiv(b*8, clusterSize)
+		if b == blocks && t == tableClusters {
+			break
+		}
+		blocks, tableClusters = b, t
+	}
+	total := used + blocks + tableClusters
+	refcounts := make([]byte, blocks*clusterSize)
+	for c := range total {
+		binary.BigEndian.PutUint16(refcounts[c*2:], 1)
+	}
+	if _, err := f.WriteAt(refcounts, next); err != nil {
+		return err
+	}
+	table := make([]byte, tableClusters*clusterSize)
+	for b := range blocks {
+		binary.BigEndian.PutUint64(table[b*8:], uint64(next+b*clusterSize))
+	}
+	tableOffset := next + blocks*clusterSize
+	if _, err := f.WriteAt(table, tableOffset); err != nil {
+		return err
+	}
+
+	if _, err := f.WriteAt(l1, clusterSize); err != nil {
+		return err
+	}
+	var header bytes.Buffer
+	if err := binary.Write(&header, binary.BigEndian, qcow2Header{
+		Magic:                 qcow2Magic,
+		Version:               qcow2Version,
+		ClusterBits:           clusterBits,
+		Size:                  uint64(size),
+		L1Size:                uint32(l1Size),
+		L1TableOffset:         clusterSize,
+		RefcountTableOffset:   uint64(tableOffset),
+		RefcountTableClusters: uint32(tableClusters),
+		RefcountOrder:         refcountOrder,
+		HeaderLength:          headerLength,
+	}); err != nil {
+		return err
+	}
+	// The header extensions end with an empty extension, the zeros
+	// following the header.
+	if _, err := f.WriteAt(header.Bytes(), 0); err != nil {
+		return err
+	}
+	return f.Truncate(total * clusterSize)
+}
+
+// qcow2Image reads the virtual disk of a qcow2 image. Images with a
+// backing file, encryption, an external data file, extended L2 entries or
+// compressed clusters are not supported.
+type qcow2Image struct {
+	r      io.ReaderAt
+	header qcow2Header
+	l1     []uint64
+	// l2 caches the last read L2 table, the disk being read sequentially.
+	l2       []uint64
+	l2Offset uint64
+}
+
+// openQCOW2 reads the header and L1 table of the qcow2 image r.
+func openQCOW2(r io.ReaderAt) (*qcow2Image, error) {
+	img := &qcow2Image{r: r}
+	if err := binary.Read(io.NewSectionReader(r, 0, headerLength), binary.BigEndian, &img.header); err != nil {
+		return nil, fmt.Errorf("reading qcow2 header: %w", err)
+	}
+	h := img.header
+	switch {
+	case h.Magic != qcow2Magic:
+		return nil, errors.New("not a qcow2 image")
+	case h.Version != 2 && h.Version != 3:
+		return nil, fmt.Errorf("unsupported qcow2 version %d", h.Version)
+	case h.ClusterBits < 9 || h.ClusterBits > 21:
+		return nil, fmt.Errorf("invalid qcow2 cluster bits %d", h.ClusterBits)
+	case h.BackingFileOffset != 0:
+		return nil, errors.New("qcow2 images with a backing file are not supported")
+	case h.CryptMethod != 0:
+		return nil, errors.New("encrypted qcow2 images are not supported")
+	case h.Version == 3 && h.IncompatibleFeatures&(incompatibleExternalData|incompatibleExtendedL2) != 0:
+		return nil, fmt.Errorf("unsupported qcow2 incompatible features %#x", h.IncompatibleFeatures)
+	}
+	if h.Version == 2 {
+		// Version 2 images have a 72 bytes header without features.
+		img.header.IncompatibleFeatures, img.header.CompatibleFeatures, img.header.AutoclearFeatures = 0, 0, 0
+	}
+
+	entries := uint64(1) << (h.ClusterBits - 3)
+	if need := ceilDiv(int64(h.Size), int64(entries)<<h.ClusterBits); int64(h.L1Size) < need {
+		return nil, fmt.Errorf("qcow2 L1 table of %d entries maps less than the %d bytes disk", h.L1Size, h.Size)
+	}
+	data := make([]byte, int64(h.L1Size)*8)
+	if _, err := r.ReadAt(data, int64(h.L1TableOffset)); err != nil {
+		return nil, fmt.Errorf("reading qcow2 L1 table: %w", err)
+	}
+	img.l1 = make([]uint64, h.L1Size)
+	for i := range img.l1 {
+		img.l1[i] = binary.BigEndian.Uint64(data[i*8:])
+	}
+	return img, nil
+}
+
+// Size returns the virtual disk size.
+func (img *qcow2Image) Size() int64 { return int64(img.header.Size) }
+
+// ReadAt reads the virtual disk at off.
+func (img *qcow2Image) ReadAt(p []byte, off int64) (int, error) {
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	cs := int64(1) << img.header.ClusterBits
+	n := 0
+	for n < len(p) && off < size {
+		in := off % cs
+		chunk := p[n:min(len(p), n+int(min(cs-in, size-off)))]
+		host, err := img.hostOffset(uint64(off / cs))
+		if err != nil {
+			return n, err
+		}
+		if host == 0 {
+			clear(chunk)
+		} else if _, err := img.r.ReadAt(chunk, int64(host)+in); err != nil {
+			return n, fmt.Errorf("reading qcow2 cluster at %d: %w", host, err)
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// hostOffset returns the file offset of the virtual disk cluster, zero for
+// the clusters reading as zeros.
+func (img *qcow2Image) hostOffset(cluster uint64) (uint64, error) {
+	entries := uint64(1) << (img.header.ClusterBits - 3)
+	l2Offset := img.l1[cluster/entries] & offsetMask
+	if l2Offset == 0 {
+		return 0, nil
+	}
+	if l2Offset != img.l2Offset {
+		data := make([]byte, entries*8)
+		if _, err := img.r.ReadAt(data, int64(l2Offset)); err != nil {
+			return 0, fmt.Errorf("reading qcow2 L2 table at %d: %w", l2Offset, err)
+		}
+		img.l2 = make([]uint64, entries)
+		for i := range img.l2 {
+			img.l2[i] = binary.BigEndian.Uint64(data[i*8:])
+		}
+		img.l2Offset = l2Offset
+	}
+	e := img.l2[cluster%entries]
+	switch {
+	case e&compressedFlag != 0:
+		return 0, errors.New("compressed qcow2 clusters are not supported")
+	case img.header.Version == 3 && e&zeroFlag != 0:
+		return 0, nil
+	}
+	return e & offsetMask, nil
+}
+
+// ceilDiv returns a divided by b, rounded up.
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// isZero reports whether b is all zeros.
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
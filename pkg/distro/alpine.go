@@ -0,0 +1,40 @@
+package distro
+
+func init() {
+	Register(alpine{})
+}
+
+// Alpine bootstrap methods.
+const (
+	// BootstrapAlpineMakeRootfs builds the rootfs with alpine-make-rootfs.
+	BootstrapAlpineMakeRootfs = "alpine-make-rootfs"
+	// BootstrapMinirootfs extracts the checksum verified Alpine minirootfs
+	// release archive and installs the packages with its apk, without the
+	// apk cache, for smaller images.
+	BootstrapMinirootfs = "minirootfs"
+)
+
+// alpine is Alpine Linux, with apk packages.
+type alpine struct{}
+
+func (alpine) Name() string        { return "alpine" }
+func (alpine) Supplier() string    { return "Alpine Linux" }
+func (alpine) PackageType() string { return "apk" }
+
+func (alpine) Release(version string) string {
+	return "v" + version
+}
+
+func (alpine) Repository(version string) string {
+	return "https://dl-cdn.alpinelinux.org/alpine/v" + version
+}
+
+func (alpine) Bootstraps() []string {
+	return []string{BootstrapAlpineMakeRootfs, BootstrapMinirootfs, BootstrapBuildKit}
+}
+
+func (alpine) Packages(names []string) []string { return names }
+
+func (alpine) Inits() []string {
+	return []string{"openrc", "systemd", "sbx"}
+}
@@ -0,0 +1,71 @@
+package distro
+
+import "cmp"
+
+func init() {
+	Register(debian{})
+}
+
+// Debian bootstrap methods, run by cmd/rootfs-debian (pkg/rootfs/debian).
+const (
+	// BootstrapMmdebstrap builds the base rootfs with mmdebstrap.
+	BootstrapMmdebstrap = "mmdebstrap"
+	// BootstrapDebootstrap builds the base rootfs with debootstrap, for
+	// hosts and mirrors mmdebstrap does not support.
+	BootstrapDebootstrap = "debootstrap"
+)
+
+// debianSuites are the suite names of the Debian release versions.
+var debianSuites = map[string]string{
+	"11": "bullseye",
+	"12": "bookworm",
+	"13": "trixie",
+	"14": "forky",
+}
+
+// debianPackages are the Debian packages of the config.yaml base, init and
+// service packages.
+var debianPackages = map[string]string{
+	"openssh":         "openssh-server",
+	"e2fsprogs-extra": "e2fsprogs",
+	// Provides /sbin/init, pulling systemd.
+	"systemd": "systemd-sysv",
+}
+
+// debian is Debian GNU/Linux, with deb packages.
+type debian struct{}
+
+func (debian) Name() string        { return "debian" }
+func (debian) Supplier() string    { return "Debian" }
+func (debian) PackageType() string { return "deb" }
+
+// Release returns the suite of version, version itself when it already is
+// a suite name (e.g. "sid").
+func (debian) Release(version string) string {
+	if suite, ok := debianSuites[version]; ok {
+		return suite
+	}
+	return version
+}
+
+func (debian) Repository(string) string {
+	return "https://deb.debian.org/debian"
+}
+
+func (debian) Bootstraps() []string {
+	return []string{BootstrapMmdebstrap, BootstrapDebootstrap, BootstrapBuildKit}
+}
+
+func (debian) Packages(names []string) []string {
+	pkgs := make([]string, 0, len(names))
+	for _, name := range names {
+		pkgs = append(pkgs, cmp.Or(debianPackages[name], name))
+	}
+	return pkgs
+}
+
+// Inits returns systemd only, the sbx services are not set up for the
+// Debian openrc and sysvinit packages and there is no busybox init.
+func (debian) Inits() []string {
+	return []string{"systemd"}
+}
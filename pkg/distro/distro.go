@@ -0,0 +1,102 @@
+// Package distro defines the distributions the rootfs images are built
+// from.
+//
+// Distributions are registered by their rootfs.distro name, so adding one
+// only needs a Distro implementation, a Register call and its bootstrap in
+// build-rootfs.sh.
+package distro
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Distro is a distribution the rootfs images are built from.
+type Distro interface {
+	// Name returns the rootfs.distro name (e.g. "alpine"), also the purl
+	// namespace of its packages.
+	Name() string
+	// PackageType returns the package format of the distribution (e.g.
+	// "apk"), the purl type of its packages and the extension part of the
+	// package database exported by build-rootfs.sh (see PackageDB).
+	PackageType() string
+	// Supplier returns the organization recorded as the package supplier in
+	// the SBOMs.
+	Supplier() string
+	// Release returns the release name of version passed to build-rootfs.sh
+	// (e.g. "v3.23").
+	Release(version string) string
+	// Repository returns the package repository URL of version, recorded in
+	// the provenance.
+	Repository(version string) string
+	// Bootstraps returns the bootstrap methods creating the base rootfs
+	// before the packages are installed, the first one being the default.
+	Bootstraps() []string
+	// Packages returns the distribution package names of names, the
+	// config.yaml base, init and service packages are named after the
+	// Alpine ones.
+	Packages(names []string) []string
+	// Inits returns the init systems (rootfs.init) the images can be built
+	// with.
+	Inits() []string
+}
+
+var distros = map[string]Distro{}
+
+// Register makes d available under its name.
+func Register(d Distro) {
+	distros[d.Name()] = d
+}
+
+// Names returns the registered distribution names sorted.
+func Names() []string {
+	names := make([]string, 0, len(distros))
+	for name := range distros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the named distribution.
+func Get(name string) (Distro, error) {
+	d, ok := distros[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown distro %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	return d, nil
+}
+
+// PackageDB returns the name of the package database file exported by
+// build-rootfs.sh for the image of stem (e.g. rootfs-x86_64.apkdb).
+func PackageDB(d Distro, stem string) string {
+	return fmt.Sprintf("rootfs-%s.%sdb", stem, d.PackageType())
+}
+
+// BootstrapOCI is the bootstrap method of the images built from an OCI
+// container image of the distribution (rootfs.image), whatever the
+// rootfs.bootstrap: the image is flattened and the packages added with the
+// distribution package manager.
+const BootstrapOCI = "oci"
+
+// BootstrapBuildKit is the bootstrap method building the base rootfs from
+// the distribution container image with BuildKit, a cached stage per
+// profile of the extends chain (cmd/rootfs-buildkit, pkg/rootfs/buildkit).
+const BootstrapBuildKit = "buildkit"
+
+// BootstrapNix is the bootstrap method of the Nix profile images, built as
+// is by a Nix flake package whatever the distribution.
+const BootstrapNix = "nix"
+
+// Bootstrap returns the bootstrap method of d, the default one when empty.
+func Bootstrap(d Distro, bootstrap string) (string, error) {
+	if bootstrap == "" {
+		return d.Bootstraps()[0], nil
+	}
+	if !slices.Contains(d.Bootstraps(), bootstrap) {
+		return "", fmt.Errorf("unknown %s bootstrap %q (supported: %s)", d.Name(), bootstrap, strings.Join(d.Bootstraps(), ", "))
+	}
+	return bootstrap, nil
+}
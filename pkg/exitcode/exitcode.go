@@ -0,0 +1,77 @@
+// Package exitcode defines the exit codes of the commands per failure class,
+// so wrappers (CI jobs, release scripts, other tools) can tell a bad config
+// from a corrupted artifact or a flaky network without parsing the logs.
+//
+// Errors carry their class by being wrapped with Wrap where it is known (the
+// config loading, the digest checks, the policy gates); the missing files and
+// network failures are recognized from the standard library errors. Every
+// command exits with Of(err).
+package exitcode
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+)
+
+// Exit codes.
+const (
+	// OK is a success.
+	OK = 0
+	// Failure is a failure without a more specific class.
+	Failure = 1
+	// Usage is an invalid command line, as exited by the flag package.
+	Usage = 2
+	// Config is an unreadable or invalid config.yaml.
+	Config = 3
+	// MissingArtifact is an artifact or input file that does not exist
+	// (e.g. not built yet).
+	MissingArtifact = 4
+	// DigestMismatch is an artifact whose digest or signature doesn't match
+	// the recorded one.
+	DigestMismatch = 5
+	// Network is a failed network request.
+	Network = 6
+	// Policy is a policy violation: vulnerabilities at or above the fail_on
+	// severity, artifacts over their size budget.
+	Policy = 7
+)
+
+// Error is an error of a failure class.
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap returns err classified with the exit code, nil when err is nil.
+func Wrap(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// Of returns the exit code of err: the code of its outermost Error,
+// MissingArtifact for a file that does not exist, Network for a network
+// error, Failure otherwise and OK when err is nil.
+func Of(err error) int {
+	var (
+		e    *Error
+		nerr net.Error
+	)
+	switch {
+	case err == nil:
+		return OK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, fs.ErrNotExist):
+		return MissingArtifact
+	case errors.As(err, &nerr):
+		return Network
+	}
+	return Failure
+}
@@ -0,0 +1,130 @@
+// Package export lays out the release artifacts of an architecture in the
+// naming and metadata other VM runtimes and tools expect, so their users
+// consume the releases without renaming and rewrapping files.
+//
+// Every format is an Exporter writing to an output directory from a Source,
+// the kernel flavor and rootfs profile selected in a release directory.
+package export
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/progress"
+	"github.com/slok/sbx-images/pkg/sparse"
+)
+
+// Source is the release artifacts an export is made from.
+type Source struct {
+	// Dir is the release (or build) directory holding the files.
+	Dir      string
+	Manifest manifest.Manifest
+	Arch     string
+	Kernel   manifest.KernelArtifact
+	Rootfs   manifest.RootfsArtifact
+}
+
+// Path returns the path of the release file name.
+func (s Source) Path(name string) string { return filepath.Join(s.Dir, name) }
+
+// Select returns the source of the kernel flavor and rootfs profile of arch
+// in m ("" selecting the defaults), with its files in dir.
+func Select(dir string, m manifest.Manifest, arch, flavor, profile string) (Source, error) {
+	a, ok := m.Artifacts[arch]
+	if !ok {
+		return Source{}, fmt.Errorf("no %s artifacts in manifest", arch)
+	}
+	k, ok := a.KernelFlavor(flavor)
+	if !ok {
+		return Source{}, fmt.Errorf("no %s kernel flavor for %s in manifest", flavor, arch)
+	}
+	r, ok := a.RootfsProfile(profile)
+	if !ok {
+		return Source{}, fmt.Errorf("no %s rootfs profile for %s in manifest", profile, arch)
+	}
+	return Source{Dir: dir, Manifest: m, Arch: arch, Kernel: k, Rootfs: r}, nil
+}
+
+// Result is an export.
+type Result struct {
+	Format string `json:"format"`
+	Dir    string `json:"dir"`
+	// Files are the written files, relative to Dir.
+	Files []string `json:"files"`
+}
+
+// Exporter writes a release in a format.
+type Exporter interface {
+	// Name returns the format name (e.g. "firecracker-containerd").
+	Name() string
+	// Export writes src to the outDir directory.
+	Export(ctx context.Context, src Source, outDir string) (Result, error)
+}
+
+// Options configures the exporters.
+type Options struct {
+	// RuntimeDir is the directory the firecracker-containerd runtime files
+	// are installed to (default DefaultFCContainerdRuntimeDir).
+	RuntimeDir string
+	// ImageName is the name of the exported container images (default
+	// DefaultImageName).
+	ImageName string
+	// KataPrefix is the Kata Containers installation prefix (default
+	// DefaultKataPrefix).
+	KataPrefix string
+	// VCPUs and MemMiB are the guest machine size of the exported VM specs
+	// (default DefaultVCPUs and DefaultMemMiB).
+	VCPUs  int
+	MemMiB int
+}
+
+// Formats are the supported format names.
+var Formats = []string{"firecracker-containerd", "flintlock", "ignite", "kata"}
+
+// New returns the exporter of format.
+func New(format string, opts Options) (Exporter, error) {
+	switch format {
+	case "firecracker-containerd":
+		return newFCContainerd(opts), nil
+	case "flintlock":
+		return newFlintlock(opts), nil
+	case "ignite":
+		return newIgnite(opts), nil
+	case "kata":
+		return newKata(opts), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q (supported: %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// copyFile copies the file src to dst sparse, replacing it.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	p := progress.New("copying "+filepath.Base(src), info.Size())
+	defer p.Done()
+	if _, err := sparse.Copy(out, p.Reader(in)); err != nil {
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,110 @@
+package export
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/boot"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+// DefaultFCContainerdRuntimeDir is the firecracker-containerd runtime
+// directory, where it looks for its default kernel and root drive.
+const DefaultFCContainerdRuntimeDir = "/var/lib/firecracker-containerd/runtime"
+
+// FCContainerdConfigFile is the firecracker-containerd runtime configuration
+// file name, installed to /etc/containerd.
+const FCContainerdConfigFile = "firecracker-runtime.json"
+
+// Firecracker-containerd runtime file names.
+const (
+	fcContainerdKernel    = "default-vmlinux.bin"
+	fcContainerdRootDrive = "default-rootfs.img"
+)
+
+// FCContainerdConfig is the firecracker-containerd runtime configuration
+// (firecracker-runtime.json).
+type FCContainerdConfig struct {
+	FirecrackerBinaryPath string   `json:"firecracker_binary_path"`
+	KernelImagePath       string   `json:"kernel_image_path"`
+	KernelArgs            string   `json:"kernel_args"`
+	RootDrive             string   `json:"root_drive"`
+	CPUTemplate           string   `json:"cpu_template,omitempty"`
+	LogLevels             []string `json:"log_levels"`
+}
+
+// fcContainerd exports the kernel and root drive of firecracker-containerd,
+// with its runtime configuration pointing to them.
+type fcContainerd struct {
+	runtimeDir string
+}
+
+func newFCContainerd(opts Options) fcContainerd {
+	return fcContainerd{runtimeDir: cmp.Or(opts.RuntimeDir, DefaultFCContainerdRuntimeDir)}
+}
+
+func (fcContainerd) Name() string { return "firecracker-containerd" }
+
+// Export writes default-vmlinux.bin, default-rootfs.img (the read-only root
+// drive, the squashfs or EROFS image when the rootfs has one), the bundled
+// firecracker binary and firecracker-runtime.json.
+func (e fcContainerd) Export(ctx context.Context, src Source, outDir string) (Result, error) {
+	kernel, err := vmconfig.SelectKernel(src.Kernel, src.Arch, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	root := rootDrive(src.Rootfs)
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return Result{}, err
+	}
+	res := Result{Format: e.Name(), Dir: outDir}
+	copies := [][2]string{{kernel.File, fcContainerdKernel}, {root.File, fcContainerdRootDrive}}
+	cfg := FCContainerdConfig{
+		FirecrackerBinaryPath: "/usr/local/bin/firecracker",
+		KernelImagePath:       path.Join(e.runtimeDir, fcContainerdKernel),
+		KernelArgs:            strings.TrimSpace(boot.DefaultBootArgs + " " + cmp.Or(root.BootArgs, src.Rootfs.BootArgs)),
+		RootDrive:             path.Join(e.runtimeDir, fcContainerdRootDrive),
+		LogLevels:             []string{"info"},
+	}
+	if fc := src.Manifest.Artifacts[src.Arch].Firecracker; fc != nil {
+		copies = append(copies, [2]string{fc.Firecracker.File, "firecracker"})
+		cfg.FirecrackerBinaryPath = path.Join(e.runtimeDir, "firecracker")
+	}
+	for _, c := range copies {
+		if err := copyFile(src.Path(c[0]), filepath.Join(outDir, c[1])); err != nil {
+			return Result{}, err
+		}
+		res.Files = append(res.Files, c[1])
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return Result{}, err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, FCContainerdConfigFile), append(data, '\n'), 0o644); err != nil {
+		return Result{}, fmt.Errorf("writing runtime config: %w", err)
+	}
+	res.Files = append(res.Files, FCContainerdConfigFile)
+	return res, nil
+}
+
+// rootDrive returns the read-only image of r when it ships one, as
+// firecracker-containerd attaches its root drive read-only, its File
+// otherwise.
+func rootDrive(r manifest.RootfsArtifact) manifest.RootfsImage {
+	for _, fs := range []string{manifest.RootfsFilesystemSquashfs, manifest.RootfsFilesystemEROFS} {
+		if img, ok := r.Image(fs); ok {
+			return img
+		}
+	}
+	img, _ := r.Image(cmp.Or(r.Filesystem, manifest.RootfsFilesystemExt4))
+	return img
+}
@@ -0,0 +1,146 @@
+package export
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/boot"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+// FlintlockSpecFile is the flintlock microVM spec file name.
+const FlintlockSpecFile = "flintlock-microvm.json"
+
+// Default guest machine size of the exported specs, the vmconfig ones.
+const (
+	DefaultVCPUs  = vmconfig.DefaultVCPUs
+	DefaultMemMiB = vmconfig.DefaultMemMiB
+)
+
+// flintlockNamespace is the namespace of the exported flintlock microVMs.
+const flintlockNamespace = "sbx"
+
+// FlintlockRequest is the flintlock CreateMicroVMRequest (API v1alpha1) in
+// its JSON form, as read by the flintlock clients (e.g. hammertime).
+type FlintlockRequest struct {
+	MicroVM FlintlockSpec `json:"microvm"`
+}
+
+// FlintlockSpec is a flintlock MicroVMSpec.
+type FlintlockSpec struct {
+	ID         string            `json:"id"`
+	Namespace  string            `json:"namespace"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	VCPU       int               `json:"vcpu"`
+	MemoryInMB int               `json:"memoryInMb"`
+	Kernel     FlintlockKernel   `json:"kernel"`
+	RootVolume FlintlockVolume   `json:"rootVolume"`
+	// Interfaces are left for the fleet to set, flintlock adds none by
+	// default.
+	Interfaces []json.RawMessage `json:"interfaces"`
+}
+
+// FlintlockKernel is the kernel of a flintlock microVM, the Filename file
+// of a container image.
+type FlintlockKernel struct {
+	Image            string            `json:"image"`
+	Filename         string            `json:"filename"`
+	Cmdline          map[string]string `json:"cmdline"`
+	AddNetworkConfig bool              `json:"addNetworkConfig"`
+}
+
+// FlintlockVolume is a flintlock volume sourced from a container image.
+type FlintlockVolume struct {
+	ID         string                `json:"id"`
+	IsReadOnly bool                  `json:"isReadOnly"`
+	Source     FlintlockVolumeSource `json:"source"`
+}
+
+// FlintlockVolumeSource is the source of a flintlock volume.
+type FlintlockVolumeSource struct {
+	ContainerSource string `json:"containerSource"`
+}
+
+// flintlock exports the flintlock (Liquid Metal) kernel and root volume
+// container images, with the microVM spec sourcing them.
+type flintlock struct {
+	imageName string
+	vcpus     int
+	memMiB    int
+}
+
+func newFlintlock(opts Options) flintlock {
+	return flintlock{
+		imageName: cmp.Or(opts.ImageName, DefaultImageName),
+		vcpus:     cmp.Or(opts.VCPUs, DefaultVCPUs),
+		memMiB:    cmp.Or(opts.MemMiB, DefaultMemMiB),
+	}
+}
+
+func (flintlock) Name() string { return "flintlock" }
+
+// Export writes the flintlock-rootfs.tar and flintlock-kernel.tar image
+// archives (the Ignite ones, see guestImages), to push to the registry the
+// flintlock hosts pull from, and flintlock-microvm.json, the microVM spec
+// booting them.
+func (e flintlock) Export(ctx context.Context, src Source, outDir string) (Result, error) {
+	images, err := guestImages(src, e.imageName, "flintlock-")
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Format: e.Name(), Dir: outDir}
+	res.Files, err = writeImages(images, outDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	rootfs, _ := src.Rootfs.Image(manifest.RootfsFilesystemExt4)
+	req := FlintlockRequest{MicroVM: FlintlockSpec{
+		ID:        "sbx-" + src.Arch,
+		Namespace: flintlockNamespace,
+		Labels: map[string]string{
+			"sbx-images/version": src.Manifest.Version,
+			"sbx-images/arch":    src.Arch,
+		},
+		VCPU:       e.vcpus,
+		MemoryInMB: e.memMiB,
+		Kernel: FlintlockKernel{
+			Image:            images[1].tag.String(),
+			Filename:         guestKernelFile,
+			Cmdline:          flintlockCmdline(boot.DefaultBootArgs + " " + cmp.Or(rootfs.BootArgs, src.Rootfs.BootArgs)),
+			AddNetworkConfig: true,
+		},
+		RootVolume: FlintlockVolume{
+			ID:     "root",
+			Source: FlintlockVolumeSource{ContainerSource: images[0].tag.String()},
+		},
+		Interfaces: []json.RawMessage{},
+	}}
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return Result{}, err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, FlintlockSpecFile), append(data, '\n'), 0o644); err != nil {
+		return Result{}, fmt.Errorf("writing microVM spec: %w", err)
+	}
+	res.Files = append(res.Files, FlintlockSpecFile)
+	return res, nil
+}
+
+// flintlockCmdline returns the boot args as the flintlock cmdline map, the
+// flags (e.g. rw) with an empty value. With addNetworkConfig flintlock adds
+// the ip args of the interfaces.
+func flintlockCmdline(args string) map[string]string {
+	cmdline := map[string]string{}
+	for _, arg := range strings.Fields(args) {
+		k, v, _ := strings.Cut(arg, "=")
+		cmdline[k] = v
+	}
+	return cmdline
+}
@@ -0,0 +1,217 @@
+package export
+
+import (
+	"archive/tar"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/slok/sbx-images/pkg/ext4"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/rootfs/oci"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+// DefaultImageName is the default name of the exported container images.
+const DefaultImageName = "localhost/sbx-images"
+
+// ignite exports the rootfs and kernel as the Weave Ignite OS and kernel
+// container images.
+type ignite struct {
+	imageName string
+}
+
+func newIgnite(opts Options) ignite {
+	return ignite{imageName: cmp.Or(opts.ImageName, DefaultImageName)}
+}
+
+func (ignite) Name() string { return "ignite" }
+
+// Export writes the ignite-rootfs.tar and ignite-kernel.tar image archives
+// (docker save format), see guestImages.
+func (e ignite) Export(ctx context.Context, src Source, outDir string) (Result, error) {
+	images, err := guestImages(src, e.imageName, "ignite-")
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Format: e.Name(), Dir: outDir}
+	res.Files, err = writeImages(images, outDir)
+	if err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// guestKernelFile is the kernel file name in the kernel image.
+const guestKernelFile = "boot/vmlinux"
+
+// guestImage is a container image of the guest, written as a docker save
+// archive.
+type guestImage struct {
+	file  string
+	tag   name.Tag
+	image v1.Image
+}
+
+// guestImages returns the OS and kernel images of src, the Ignite ones (also
+// sourced by flintlock), tagged <name>-rootfs:<version> and
+// <name>-kernel:<version>, to write to the <prefix>rootfs.tar and
+// <prefix>kernel.tar archives: the OS image holds the ext4 rootfs tree, the
+// kernel image /boot/vmlinux and the /lib/modules tree when the release
+// ships the modules archive.
+func guestImages(src Source, imageName, prefix string) ([]guestImage, error) {
+	rootfs, ok := src.Rootfs.Image(manifest.RootfsFilesystemExt4)
+	if !ok {
+		return nil, fmt.Errorf("rootfs %s has no ext4 image", src.Rootfs.Profile)
+	}
+	kernel, err := vmconfig.SelectKernel(src.Kernel, src.Arch, []string{manifest.KernelFormat(src.Arch)})
+	if err != nil {
+		return nil, err
+	}
+	platform, err := oci.Platform(src.Arch)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{
+		"org.opencontainers.image.version":  src.Manifest.Version,
+		"org.opencontainers.image.revision": src.Manifest.Build.Commit,
+	}
+
+	rootfsLayer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ext4Tar(src.Path(rootfs.File))
+	})
+	if err != nil {
+		return nil, err
+	}
+	kernelLayers := []v1.Layer{}
+	vmlinuxLayer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return fileTar(src.Path(kernel.File), guestKernelFile)
+	})
+	if err != nil {
+		return nil, err
+	}
+	kernelLayers = append(kernelLayers, vmlinuxLayer)
+	if src.Kernel.Modules.Shipped() {
+		// The modules archive is a zstd compressed tar of the lib tree,
+		// a layer as is.
+		l, err := tarball.LayerFromFile(src.Path(src.Kernel.Modules.File))
+		if err != nil {
+			return nil, err
+		}
+		kernelLayers = append(kernelLayers, l)
+	}
+
+	var images []guestImage
+	for _, img := range []struct {
+		kind   string
+		layers []v1.Layer
+	}{
+		{"rootfs", []v1.Layer{rootfsLayer}},
+		{"kernel", kernelLayers},
+	} {
+		tag, err := name.NewTag(imageName + "-" + img.kind + ":" + src.Manifest.Version)
+		if err != nil {
+			return nil, err
+		}
+		image, err := containerImage(img.layers, platform, labels)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, guestImage{file: prefix + img.kind + ".tar", tag: tag, image: image})
+	}
+	return images, nil
+}
+
+// writeImages writes the images archives to outDir, returning their file
+// names.
+func writeImages(images []guestImage, outDir string) ([]string, error) {
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, img := range images {
+		if err := tarball.WriteToFile(filepath.Join(outDir, img.file), img.tag, img.image); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", img.file, err)
+		}
+		files = append(files, img.file)
+	}
+	return files, nil
+}
+
+// containerImage returns the image of layers for platform, with labels.
+func containerImage(layers []v1.Layer, platform v1.Platform, labels map[string]string) (v1.Image, error) {
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture, cfg.Variant = platform.OS, platform.Architecture, platform.Variant
+	cfg.Config.Labels = labels
+	return mutate.ConfigFile(img, cfg)
+}
+
+// ext4Tar returns the tree of the ext4 image at imagePath as a tar stream.
+func ext4Tar(imagePath string) (io.ReadCloser, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := ext4.New(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %s: %w", imagePath, err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(fsys.WriteTar(pw))
+		f.Close()
+	}()
+	return pr, nil
+}
+
+// fileTar returns a tar stream holding the file at filePath as name, with its
+// parent directory.
+func fileTar(filePath, name string) (io.ReadCloser, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer f.Close()
+		tw := tar.NewWriter(pw)
+		write := func() error {
+			if err := tw.WriteHeader(&tar.Header{Name: path.Dir(name) + "/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+				return err
+			}
+			if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: info.Size()}); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, f); err != nil {
+				return err
+			}
+			return tw.Close()
+		}
+		pw.CloseWithError(write())
+	}()
+	return pr, nil
+}
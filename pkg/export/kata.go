@@ -0,0 +1,121 @@
+package export
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+// DefaultKataPrefix is the Kata Containers installation prefix of
+// kata-deploy.
+const DefaultKataPrefix = "/opt/kata"
+
+// Kata Containers bundle paths, relative to the prefix.
+const (
+	kataAssetsDir = "share/kata-containers"
+	kataConfigDir = "share/defaults/kata-containers/config.d"
+	kataKernel    = "vmlinux-sbx-images.container"
+	kataImage     = "sbx-images.img"
+	kataConfig    = "50-sbx-images.toml"
+)
+
+// kataHypervisors are the Kata configuration sections of the hypervisors.
+var kataHypervisors = map[string]string{
+	manifest.HypervisorFirecracker:     "firecracker",
+	manifest.HypervisorCloudHypervisor: "clh",
+}
+
+// kata exports the kernel and rootfs image as Kata Containers guest assets,
+// in the kata-deploy layout, with the configuration drop-in pointing the
+// hypervisor of the kernel (Firecracker or Cloud Hypervisor) to them.
+type kata struct {
+	prefix string
+	vcpus  int
+	memMiB int
+}
+
+func newKata(opts Options) kata {
+	return kata{
+		prefix: cmp.Or(opts.KataPrefix, DefaultKataPrefix),
+		vcpus:  cmp.Or(opts.VCPUs, DefaultVCPUs),
+		memMiB: cmp.Or(opts.MemMiB, DefaultMemMiB),
+	}
+}
+
+func (kata) Name() string { return "kata" }
+
+// Export writes the bundle to install to the Kata prefix as is:
+// share/kata-containers/vmlinux-sbx-images.container and sbx-images.img
+// (the EROFS image when the rootfs has one, the ext4 one otherwise), the
+// bundled firecracker binary as bin/firecracker (Firecracker kernels) and the
+// share/defaults/kata-containers/config.d/50-sbx-images.toml drop-in.
+//
+// The image is not partitioned as the osbuilder ones: the root= of the
+// kernel_params, appended to the Kata ones, points to the whole device.
+func (e kata) Export(ctx context.Context, src Source, outDir string) (Result, error) {
+	kernel, err := vmconfig.SelectKernel(src.Kernel, src.Arch, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	image, ok := src.Rootfs.Image(manifest.RootfsFilesystemEROFS)
+	if !ok {
+		if image, ok = src.Rootfs.Image(manifest.RootfsFilesystemExt4); !ok {
+			return Result{}, fmt.Errorf("rootfs %s has no EROFS or ext4 image", src.Rootfs.Profile)
+		}
+	}
+
+	res := Result{Format: e.Name(), Dir: outDir}
+	copies := [][2]string{
+		{kernel.File, path.Join(kataAssetsDir, kataKernel)},
+		{image.File, path.Join(kataAssetsDir, kataImage)},
+	}
+	hypervisor := src.Kernel.TargetHypervisor()
+	var firecracker string
+	if fc := src.Manifest.Artifacts[src.Arch].Firecracker; fc != nil && hypervisor == manifest.HypervisorFirecracker {
+		copies = append(copies, [2]string{fc.Firecracker.File, "bin/firecracker"})
+		firecracker = path.Join(e.prefix, "bin/firecracker")
+	}
+	for _, c := range copies {
+		dst := filepath.Join(outDir, filepath.FromSlash(c[1]))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return Result{}, err
+		}
+		if err := copyFile(src.Path(c[0]), dst); err != nil {
+			return Result{}, err
+		}
+		res.Files = append(res.Files, c[1])
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# sbx-images %s %s guest assets (kernel flavor %s, rootfs profile %s).\n",
+		src.Manifest.Version, src.Arch, cmp.Or(src.Kernel.Flavor, manifest.DefaultFlavor), src.Rootfs.Profile)
+	fmt.Fprintf(&b, "[hypervisor.%s]\n", kataHypervisors[hypervisor])
+	if firecracker != "" {
+		fmt.Fprintf(&b, "path = %s\n", strconv.Quote(firecracker))
+	}
+	fmt.Fprintf(&b, "kernel = %s\n", strconv.Quote(path.Join(e.prefix, kataAssetsDir, kataKernel)))
+	fmt.Fprintf(&b, "image = %s\n", strconv.Quote(path.Join(e.prefix, kataAssetsDir, kataImage)))
+	fmt.Fprintf(&b, "rootfs_type = %s\n", strconv.Quote(image.Filesystem))
+	fmt.Fprintf(&b, "kernel_params = %s\n", strconv.Quote(src.Rootfs.BootArgsFor(cmp.Or(image.BootArgs, src.Rootfs.BootArgs), hypervisor)))
+	fmt.Fprintf(&b, "default_vcpus = %d\n", e.vcpus)
+	fmt.Fprintf(&b, "default_memory = %d\n", e.memMiB)
+
+	config := path.Join(kataConfigDir, kataConfig)
+	dst := filepath.Join(outDir, filepath.FromSlash(config))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return Result{}, err
+	}
+	if err := os.WriteFile(dst, []byte(b.String()), 0o644); err != nil {
+		return Result{}, fmt.Errorf("writing configuration drop-in: %w", err)
+	}
+	res.Files = append(res.Files, config)
+	return res, nil
+}
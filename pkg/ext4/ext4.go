@@ -0,0 +1,694 @@
+// Package ext4 reads files out of ext2, ext3 and ext4 filesystem images in
+// userspace, without root or mounts.
+//
+// An FS is a read-only io/fs.FS of the image: files are read through their
+// extent tree (or the ext2/ext3 block map), directories through their linear
+// entries (htree indexed ones included, the index blocks holding no entries)
+// and symlinks are resolved inside the image, absolute targets from its
+// root. Journaled changes that were never checkpointed are not replayed, and
+// files with inline data larger than the inode are not supported.
+//
+// Images may be untrusted: the sizes of the directories and symlinks read
+// whole are bounded by the filesystem and their mapped blocks, and the
+// extent and block map blocks are read once, so corrupted images fail
+// instead of exhausting the memory or looping.
+package ext4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Superblock layout.
+const (
+	superblockOffset = 1024
+	superblockSize   = 1024
+	magic            = 0xef53
+)
+
+// Feature and inode flags.
+const (
+	incompatMetaBG = 0x10
+	incompat64Bit  = 0x80
+
+	flagExtents    = 0x80000
+	flagInlineData = 0x10000000
+)
+
+const (
+	rootInode = 2
+	// maxBlockSizeLog bounds the block size, 1024 << 6 = 64 KiB.
+	maxBlockSizeLog = 6
+	// maxReadAll bounds the directories and symlinks read whole, far
+	// above real directories, so a corrupted size fails instead of
+	// exhausting the memory.
+	maxReadAll = 64 << 20
+	// maxSymlinks bounds the symlinks followed resolving a path, as the
+	// kernel does.
+	maxSymlinks = 40
+	// iBlockSize is the size of the inode block map, holding the extent
+	// tree root, fast symlinks and inline data.
+	iBlockSize = 60
+)
+
+// ErrUnsupported is returned for filesystem features the reader lacks.
+var ErrUnsupported = errors.New("unsupported ext4 feature")
+
+// FS is a read-only ext4 filesystem image.
+type FS struct {
+	r              io.ReaderAt
+	blockSize      int64
+	inodeSize      int64
+	inodesPerGroup uint32
+	inodeCount     uint32
+	descSize       int64
+	descStart      int64
+	is64Bit        bool
+	// size is the filesystem size, blocks count × block size.
+	size int64
+}
+
+// New reads the superblock of the ext4 image r.
+func New(r io.ReaderAt) (*FS, error) {
+	sb := make([]byte, superblockSize)
+	if _, err := r.ReadAt(sb, superblockOffset); err != nil {
+		return nil, fmt.Errorf("reading superblock: %w", err)
+	}
+	le := binary.LittleEndian
+	if le.Uint16(sb[0x38:]) != magic {
+		return nil, fmt.Errorf("no ext4 superblock")
+	}
+
+	if le.Uint32(sb[0x18:]) > maxBlockSizeLog {
+		return nil, fmt.Errorf("corrupted superblock: block size 1024 << %d", le.Uint32(sb[0x18:]))
+	}
+	fsys := &FS{
+		r:              r,
+		blockSize:      1024 << le.Uint32(sb[0x18:]),
+		inodeSize:      128,
+		inodesPerGroup: le.Uint32(sb[0x28:]),
+		inodeCount:     le.Uint32(sb[0x00:]),
+		descSize:       32,
+	}
+	// Revision 0 filesystems have fixed 128 byte inodes.
+	if le.Uint32(sb[0x4c:]) >= 1 {
+		fsys.inodeSize = int64(le.Uint16(sb[0x58:]))
+	}
+	incompat := le.Uint32(sb[0x60:])
+	if incompat&incompatMetaBG != 0 {
+		return nil, fmt.Errorf("meta_bg group descriptors: %w", ErrUnsupported)
+	}
+	blocks := int64(le.Uint32(sb[0x04:]))
+	if incompat&incompat64Bit != 0 {
+		fsys.is64Bit = true
+		fsys.descSize = int64(le.Uint16(sb[0xfe:]))
+		blocks |= int64(le.Uint32(sb[0x150:])&0xffff) << 32
+	}
+	fsys.size = blocks * fsys.blockSize
+	if fsys.inodesPerGroup == 0 || fsys.inodeSize < 128 || fsys.descSize < 32 {
+		return nil, fmt.Errorf("corrupted superblock")
+	}
+	// The group descriptors follow the block holding the superblock.
+	fsys.descStart = (int64(le.Uint32(sb[0x14:])) + 1) * fsys.blockSize
+	return fsys, nil
+}
+
+// inode is a decoded on-disk inode.
+type inode struct {
+	num   uint32
+	mode  uint16
+	uid   uint32
+	gid   uint32
+	size  int64
+	mtime int64
+	flags uint32
+	block [iBlockSize]byte
+}
+
+// readInode reads inode num.
+func (fsys *FS) readInode(num uint32) (*inode, error) {
+	if num == 0 || num > fsys.inodeCount {
+		return nil, fmt.Errorf("inode %d out of range", num)
+	}
+	le := binary.LittleEndian
+	group, index := int64((num-1)/fsys.inodesPerGroup), int64((num-1)%fsys.inodesPerGroup)
+
+	desc := make([]byte, fsys.descSize)
+	if _, err := fsys.r.ReadAt(desc, fsys.descStart+group*fsys.descSize); err != nil {
+		return nil, fmt.Errorf("reading group %d descriptor: %w", group, err)
+	}
+	table := int64(le.Uint32(desc[0x08:]))
+	if fsys.is64Bit && fsys.descSize >= 64 {
+		table |= int64(le.Uint32(desc[0x28:])) << 32
+	}
+
+	raw := make([]byte, 128)
+	if _, err := fsys.r.ReadAt(raw, table*fsys.blockSize+index*fsys.inodeSize); err != nil {
+		return nil, fmt.Errorf("reading inode %d: %w", num, err)
+	}
+	in := &inode{
+		num:   num,
+		mode:  le.Uint16(raw[0x00:]),
+		uid:   uint32(le.Uint16(raw[0x02:])) | uint32(le.Uint16(raw[0x78:]))<<16,
+		gid:   uint32(le.Uint16(raw[0x18:])) | uint32(le.Uint16(raw[0x7a:]))<<16,
+		size:  int64(le.Uint32(raw[0x04:])) | int64(le.Uint32(raw[0x6c:]))<<32,
+		mtime: int64(le.Uint32(raw[0x10:])),
+		flags: le.Uint32(raw[0x20:]),
+	}
+	copy(in.block[:], raw[0x28:])
+	return in, nil
+}
+
+// extent maps length blocks of a file from logical to physical, zero
+// filled when unwritten.
+type extent struct {
+	logical   int64
+	physical  int64
+	length    int64
+	unwritten bool
+}
+
+// extents returns the block mapping of in, sorted by logical block.
+func (fsys *FS) extents(in *inode) ([]extent, error) {
+	// A block is read once: the index and indirect blocks of a corrupted
+	// inode may point back to themselves.
+	visited := map[int64]bool{}
+	if in.flags&flagExtents != 0 {
+		var exts []extent
+		if err := fsys.extentTree(in.block[:], &exts, 0, visited); err != nil {
+			return nil, fmt.Errorf("inode %d extent tree: %w", in.num, err)
+		}
+		return exts, nil
+	}
+
+	// The ext2/ext3 block map: 12 direct blocks, then a single, double and
+	// triple indirect one.
+	le := binary.LittleEndian
+	var (
+		exts    []extent
+		logical int64
+	)
+	add := func(block int64) {
+		if n := len(exts); n > 0 && block != 0 && exts[n-1].physical+exts[n-1].length == block && exts[n-1].logical+exts[n-1].length == logical {
+			exts[n-1].length++
+		} else if block != 0 {
+			exts = append(exts, extent{logical: logical, physical: block, length: 1})
+		}
+		logical++
+	}
+	blocks := (in.size + fsys.blockSize - 1) / fsys.blockSize
+	for i := 0; i < 12 && logical < blocks; i++ {
+		add(int64(le.Uint32(in.block[i*4:])))
+	}
+	perBlock := fsys.blockSize / 4
+	span := perBlock
+	for level := 1; level <= 3 && logical < blocks; level++ {
+		block := int64(le.Uint32(in.block[(11+level)*4:]))
+		if block == 0 {
+			logical += span
+		} else if err := fsys.indirect(block, level, blocks, &logical, add, visited); err != nil {
+			return nil, fmt.Errorf("inode %d block map: %w", in.num, err)
+		}
+		span *= perBlock
+	}
+	return exts, nil
+}
+
+// extentTree appends the leaf extents of the extent tree node b to exts,
+// reading the child nodes not visited yet.
+func (fsys *FS) extentTree(b []byte, exts *[]extent, depth int, visited map[int64]bool) error {
+	le := binary.LittleEndian
+	if len(b) < 12 || le.Uint16(b) != 0xf30a {
+		return fmt.Errorf("bad extent header")
+	}
+	if depth > 5 {
+		return fmt.Errorf("extent tree too deep")
+	}
+	entries, nodeDepth := int(le.Uint16(b[2:])), le.Uint16(b[6:])
+	if 12+entries*12 > len(b) {
+		return fmt.Errorf("extent node overflows")
+	}
+	for i := range entries {
+		e := b[12+i*12:]
+		if nodeDepth == 0 {
+			length, unwritten := int64(le.Uint16(e[4:])), false
+			if length > 32768 {
+				length, unwritten = length-32768, true
+			}
+			*exts = append(*exts, extent{
+				logical:   int64(le.Uint32(e)),
+				physical:  int64(le.Uint16(e[6:]))<<32 | int64(le.Uint32(e[8:])),
+				length:    length,
+				unwritten: unwritten,
+			})
+			continue
+		}
+		child := make([]byte, fsys.blockSize)
+		leaf := int64(le.Uint16(e[8:]))<<32 | int64(le.Uint32(e[4:]))
+		if visited[leaf] {
+			return fmt.Errorf("extent node %d referenced twice", leaf)
+		}
+		visited[leaf] = true
+		if _, err := fsys.r.ReadAt(child, leaf*fsys.blockSize); err != nil {
+			return err
+		}
+		if err := fsys.extentTree(child, exts, depth+1, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indirect walks the block map indirect block of level, calling add for each
+// data block until blocks, reading the indirect blocks not visited yet.
+func (fsys *FS) indirect(block int64, level int, blocks int64, logical *int64, add func(int64), visited map[int64]bool) error {
+	if visited[block] {
+		return fmt.Errorf("indirect block %d referenced twice", block)
+	}
+	visited[block] = true
+	buf := make([]byte, fsys.blockSize)
+	if _, err := fsys.r.ReadAt(buf, block*fsys.blockSize); err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	span := int64(1)
+	for range level - 1 {
+		span *= fsys.blockSize / 4
+	}
+	for i := int64(0); i < fsys.blockSize/4 && *logical < blocks; i++ {
+		b := int64(le.Uint32(buf[i*4:]))
+		switch {
+		case level == 1:
+			add(b)
+		case b == 0:
+			*logical += span
+		default:
+			if err := fsys.indirect(b, level-1, blocks, logical, add, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inline reports whether the contents of in live in its block map: fast
+// symlinks and inline data.
+func (in *inode) inline() bool {
+	if in.flags&flagInlineData != 0 {
+		return true
+	}
+	return in.mode&0xf000 == 0xa000 && in.flags&flagExtents == 0 && in.size < iBlockSize
+}
+
+// readAll reads the whole contents of in, for directories and symlinks. Their
+// size is checked first, unlike the one of regular files, which may be
+// sparse: it must fit the filesystem, maxReadAll and the mapped blocks.
+func (fsys *FS) readAll(in *inode) ([]byte, error) {
+	if in.size < 0 || in.size > fsys.size || in.size > maxReadAll {
+		return nil, fmt.Errorf("inode %d: corrupted size %d", in.num, in.size)
+	}
+	f, err := fsys.newFile(in, "")
+	if err != nil {
+		return nil, err
+	}
+	if !in.inline() {
+		var mapped int64
+		for _, e := range f.exts {
+			mapped = max(mapped, (e.logical+e.length)*fsys.blockSize)
+		}
+		if in.size > mapped {
+			return nil, fmt.Errorf("inode %d: size %d beyond its %d mapped bytes", in.num, in.size, mapped)
+		}
+	}
+	data := make([]byte, in.size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// entry is a directory entry.
+type entry struct {
+	name  string
+	inode uint32
+}
+
+// readDir returns the entries of the directory in, without "." and "..".
+func (fsys *FS) readDir(in *inode) ([]entry, error) {
+	data, err := fsys.readAll(in)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	var entries []entry
+	for off := 0; off+8 <= len(data); {
+		num, recLen, nameLen := le.Uint32(data[off:]), int(le.Uint16(data[off+4:])), int(data[off+6])
+		if recLen < 8 || off+recLen > len(data) || 8+nameLen > recLen {
+			return nil, fmt.Errorf("inode %d: corrupted directory entry at %d", in.num, off)
+		}
+		// Unused entries, htree index blocks and checksum tails have no
+		// inode.
+		if name := string(data[off+8 : off+8+nameLen]); num != 0 && name != "." && name != ".." {
+			entries = append(entries, entry{name: name, inode: num})
+		}
+		off += recLen
+	}
+	return entries, nil
+}
+
+// lookup resolves name to its inode, following the symlinks of its
+// directories, and of name itself when follow is set.
+func (fsys *FS) lookup(op, name string, follow bool) (*inode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	in, err := fsys.readInode(rootInode)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	var (
+		parts    = splitPath(name)
+		dir      []string
+		symlinks int
+	)
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if in.mode&0xf000 != 0x4000 {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("%s is not a directory", path.Join(dir...))}
+		}
+		entries, err := fsys.readDir(in)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		var found uint32
+		for _, e := range entries {
+			if e.name == part {
+				found = e.inode
+				break
+			}
+		}
+		if found == 0 {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		next, err := fsys.readInode(found)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+
+		if next.mode&0xf000 != 0xa000 || (len(parts) == 0 && !follow) {
+			in = next
+			dir = append(dir, part)
+			continue
+		}
+		if symlinks++; symlinks > maxSymlinks {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+		target, err := fsys.readAll(next)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		// Resolved again from the root, the target joined to the
+		// directory holding the link.
+		t := string(target)
+		if !strings.HasPrefix(t, "/") {
+			t = path.Join(append(dir, t)...)
+		}
+		parts = append(splitPath(path.Clean("/"+t)), parts...)
+		dir = nil
+		if in, err = fsys.readInode(rootInode); err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	return in, nil
+}
+
+// splitPath splits a slash separated path, dropping empty and "." elements
+// and applying "..", never above the root.
+func splitPath(p string) []string {
+	var parts []string
+	for part := range strings.SplitSeq(p, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(parts) > 0 {
+				parts = parts[:len(parts)-1]
+			}
+		default:
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// Open opens the named file, following symlinks.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	in, err := fsys.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fsys.newFile(in, path.Base(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// Stat returns the file info of the named file, following symlinks.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	in, err := fsys.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), in: in}, nil
+}
+
+// Lstat returns the file info of the named file, without following a final
+// symlink.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	in, err := fsys.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), in: in}, nil
+}
+
+// ReadLink returns the target of the named symlink.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	in, err := fsys.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if in.mode&0xf000 != 0xa000 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	target, err := fsys.readAll(in)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return string(target), nil
+}
+
+// ReadDir returns the sorted entries of the named directory.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, ok := f.(*file)
+	if !ok || !d.fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := d.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+// file is an open file of an FS.
+type file struct {
+	fsys *FS
+	fi   *fileInfo
+	exts []extent
+	off  int64
+	// dir holds the directory entries left to ReadDir, loaded on the first
+	// call.
+	dir []entry
+	// dirRead is set once dir is loaded.
+	dirRead bool
+}
+
+func (fsys *FS) newFile(in *inode, name string) (*file, error) {
+	f := &file{fsys: fsys, fi: &fileInfo{name: name, in: in}}
+	if in.inline() {
+		if in.size > iBlockSize {
+			return nil, fmt.Errorf("inode %d: inline data beyond the inode: %w", in.num, ErrUnsupported)
+		}
+		return f, nil
+	}
+	exts, err := fsys.extents(in)
+	if err != nil {
+		return nil, err
+	}
+	f.exts = exts
+	return f, nil
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.fi, nil }
+
+func (f *file) Close() error { return nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+// ReadAt reads the file contents at off, zero filled in holes.
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	in := f.fi.in
+	if off >= in.size {
+		return 0, io.EOF
+	}
+	if remaining := in.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	if in.inline() {
+		return copy(p, in.block[off:in.size]), eofAt(off+int64(len(p)), in.size)
+	}
+
+	bs := f.fsys.blockSize
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		block, within := pos/bs, pos%bs
+		chunk := p[n:]
+
+		var ext *extent
+		for i := range f.exts {
+			e := &f.exts[i]
+			if block >= e.logical && block < e.logical+e.length {
+				ext = e
+				break
+			}
+		}
+		if ext == nil || ext.unwritten {
+			// A hole up to the next mapped extent, or the end.
+			end := in.size
+			for _, e := range f.exts {
+				if e.logical > block && e.logical*bs < end {
+					end = e.logical * bs
+				}
+			}
+			if ext != nil {
+				end = min(end, (ext.logical+ext.length)*bs)
+			}
+			chunk = chunk[:min(int64(len(chunk)), end-pos)]
+			clear(chunk)
+			n += len(chunk)
+			continue
+		}
+		chunk = chunk[:min(int64(len(chunk)), (ext.logical+ext.length)*bs-pos)]
+		physical := (ext.physical+block-ext.logical)*bs + within
+		m, err := f.fsys.r.ReadAt(chunk, physical)
+		n += m
+		if err != nil {
+			return n, fmt.Errorf("inode %d: %w", in.num, err)
+		}
+	}
+	return n, eofAt(off+int64(n), in.size)
+}
+
+func eofAt(pos, size int64) error {
+	if pos >= size {
+		return io.EOF
+	}
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, all of them when
+// n <= 0.
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.fi.IsDir() {
+		return nil, errors.New("not a directory")
+	}
+	if !f.dirRead {
+		entries, err := f.fsys.readDir(f.fi.in)
+		if err != nil {
+			return nil, err
+		}
+		f.dir, f.dirRead = entries, true
+	}
+	count := len(f.dir)
+	if n > 0 && n < count {
+		count = n
+	}
+	if n > 0 && count == 0 {
+		return nil, io.EOF
+	}
+	out := make([]fs.DirEntry, 0, count)
+	for _, e := range f.dir[:count] {
+		in, err := f.fsys.readInode(e.inode)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, fs.FileInfoToDirEntry(&fileInfo{name: e.name, in: in}))
+	}
+	f.dir = f.dir[count:]
+	return out, nil
+}
+
+// fileInfo is the fs.FileInfo of an inode.
+type fileInfo struct {
+	name string
+	in   *inode
+}
+
+// Owner is the Sys value of the FS file infos.
+type Owner struct {
+	UID, GID uint32
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.in.size }
+func (fi *fileInfo) ModTime() time.Time { return time.Unix(fi.in.mtime, 0).UTC() }
+func (fi *fileInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi *fileInfo) Sys() any           { return Owner{UID: fi.in.uid, GID: fi.in.gid} }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(fi.in.mode & 0o777)
+	switch fi.in.mode & 0xf000 {
+	case 0x4000:
+		mode |= fs.ModeDir
+	case 0xa000:
+		mode |= fs.ModeSymlink
+	case 0x2000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0x6000:
+		mode |= fs.ModeDevice
+	case 0x1000:
+		mode |= fs.ModeNamedPipe
+	case 0xc000:
+		mode |= fs.ModeSocket
+	}
+	if fi.in.mode&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if fi.in.mode&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if fi.in.mode&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,109 @@
+package ext4_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/ext4"
+	"github.com/slok/sbx-images/pkg/testutil"
+)
+
+// rootInodeOffset is the offset of the root directory inode in the
+// testutil.Ext4 images: inode 2 of the table at block 5, 128 byte inodes.
+const rootInodeOffset = 5*1024 + 128
+
+func fixture(t testing.TB) []byte {
+	t.Helper()
+	data, err := testutil.Ext4(testutil.RootfsFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFS(t *testing.T) {
+	fsys, err := ext4.New(bytes.NewReader(fixture(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		got = append(got, p)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "bin", "bin/sh", "etc", "etc/hostname", "etc/os-release", "lost+found", "root", "sbin", "sbin/init", "var", "var/lib", "var/lib/sbx", "var/lib/sbx/.ok"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got tree %q, want %q", got, want)
+	}
+	for name, f := range testutil.RootfsFiles {
+		if f.Mode.IsRegular() {
+			if data, err := fs.ReadFile(fsys, name); err != nil || !bytes.Equal(data, f.Data) {
+				t.Errorf("%s: got %q (%v), want %q", name, data, err, f.Data)
+			}
+		}
+	}
+	if target, err := fsys.ReadLink("bin/sh"); err != nil || target != "busybox" {
+		t.Errorf("got bin/sh -> %q (%v), want busybox", target, err)
+	}
+}
+
+func TestCorruptedDirectorySize(t *testing.T) {
+	for name, size := range map[string][2]uint32{
+		"beyond the filesystem": {0, 1},
+		"beyond the extents":    {64 * 1024, 0},
+	} {
+		data := fixture(t)
+		binary.LittleEndian.PutUint32(data[rootInodeOffset+0x04:], size[0])
+		binary.LittleEndian.PutUint32(data[rootInodeOffset+0x6c:], size[1])
+		fsys, err := ext4.New(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.ReadDir(fsys, "."); err == nil {
+			t.Errorf("%s: expected an error reading the root directory", name)
+		}
+	}
+}
+
+// fuzzedBytes is the fuzzed head of the testutil.Ext4 images: the
+// superblock, group descriptor, bitmaps, inode table and first directory
+// blocks. The rest of the image is kept, fuzzing it all is much slower.
+const fuzzedBytes = 24 * 1024
+
+// FuzzFS reads every file of corrupted testutil.Ext4 images, which must fail
+// or succeed without panics, hangs or unbounded allocations.
+func FuzzFS(f *testing.F) {
+	image := fixture(f)
+	f.Add(image[:fuzzedBytes])
+	f.Fuzz(func(t *testing.T, head []byte) {
+		data := append(bytes.Clone(head), image[min(len(head), len(image)):]...)
+		fsys, err := ext4.New(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		// Corrupted entries may loop back to a parent directory.
+		walked := 0
+		_ = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if walked++; err != nil || walked > 1000 {
+				return fs.SkipAll
+			}
+			switch {
+			case d.Type()&fs.ModeSymlink != 0:
+				_, _ = fsys.ReadLink(p)
+			case d.Type().IsRegular():
+				if f, err := fsys.Open(p); err == nil {
+					_, _ = io.Copy(io.Discard, io.LimitReader(f, 1<<20))
+					f.Close()
+				}
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,71 @@
+package ext4
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+)
+
+// WriteTar writes the tree of fsys as a tar archive to w, with the modes,
+// owners and modification times of the image, for container images of a
+// rootfs image. Device nodes are skipped (the reader lacks their numbers,
+// devtmpfs providing them at boot) and hard links are written as separate
+// files.
+func (fsys *FS) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		fi, err := fsys.Lstat(name)
+		if err != nil {
+			return err
+		}
+		var link string
+		switch mode := fi.Mode(); {
+		case mode&fs.ModeDevice != 0, mode&fs.ModeSocket != 0:
+			slog.Debug("Skipping special file", "path", name, "mode", mode)
+			return nil
+		case mode&fs.ModeSymlink != 0:
+			if link, err = fsys.ReadLink(name); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		hdr.Name = name
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		owner := fi.Sys().(Owner)
+		hdr.Uid, hdr.Gid = int(owner.UID), int(owner.GID)
+		hdr.Format = tar.FormatPAX
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
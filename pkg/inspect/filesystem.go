@@ -0,0 +1,173 @@
+package inspect
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// FilesystemInfo is the superblock of an inspected filesystem image.
+type FilesystemInfo struct {
+	// Type is a manifest.RootfsFilesystem* name, or ext2/ext3.
+	Type  string `json:"type"`
+	UUID  string `json:"uuid,omitempty"`
+	Label string `json:"label,omitempty"`
+	// BlockSize and BlockCount are the filesystem geometry, FreeBlocks
+	// the unused blocks of the writable filesystems.
+	BlockSize  int64 `json:"block_size"`
+	BlockCount int64 `json:"block_count"`
+	FreeBlocks int64 `json:"free_blocks,omitempty"`
+	// SizeBytes is the size the superblock records, larger than the file
+	// of truncated images.
+	SizeBytes int64 `json:"size_bytes"`
+	// Compression is the squashfs compressor.
+	Compression string `json:"compression,omitempty"`
+	// Created is the mkfs (ext4) or build (squashfs, EROFS) time.
+	Created   *time.Time `json:"created,omitempty"`
+	LastWrite *time.Time `json:"last_write,omitempty"`
+	// LastMount and LastMountedOn are the last ext4 mount, unset for
+	// never mounted images.
+	LastMount     *time.Time `json:"last_mount,omitempty"`
+	LastMountedOn string     `json:"last_mounted_on,omitempty"`
+	MountCount    int        `json:"mount_count,omitempty"`
+}
+
+// Superblock offsets and magic numbers.
+const (
+	ext4SuperblockOffset  = 1024
+	ext4Magic             = 0xef53
+	erofsSuperblockOffset = 1024
+	erofsMagic            = 0xe0f5e1e2
+	squashfsMagic         = 0x73717368
+	btrfsSuperblockOffset = 0x10000
+	btrfsMagic            = "_BHRfS_M"
+)
+
+// ext4 feature flags telling ext2, ext3 and ext4 apart.
+const (
+	ext4CompatHasJournal = 0x4
+	ext4IncompatExtents  = 0x40
+	ext4Incompat64Bit    = 0x80
+)
+
+var squashfsCompressors = map[uint16]string{1: "gzip", 2: "lzma", 3: "lzo", 4: "xz", 5: "lz4", 6: "zstd"}
+
+// Filesystem inspects the superblock of the filesystem image at path.
+func Filesystem(path string) (FilesystemInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FilesystemInfo{}, err
+	}
+	defer f.Close()
+
+	// The btrfs superblock is the furthest one.
+	sb := make([]byte, btrfsSuperblockOffset+4096)
+	n, err := io.ReadFull(f, sb)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return FilesystemInfo{}, fmt.Errorf("%s: %w", path, err)
+	}
+	sb = sb[:n]
+
+	le := binary.LittleEndian
+	switch {
+	case len(sb) >= 0x30 && le.Uint32(sb) == squashfsMagic:
+		return FilesystemInfo{
+			Type:        manifest.RootfsFilesystemSquashfs,
+			BlockSize:   int64(le.Uint32(sb[0x0c:])),
+			BlockCount:  ceilDiv(int64(le.Uint64(sb[0x28:])), int64(le.Uint32(sb[0x0c:]))),
+			SizeBytes:   int64(le.Uint64(sb[0x28:])),
+			Compression: squashfsCompressors[le.Uint16(sb[0x14:])],
+			Created:     unixTime(int64(le.Uint32(sb[0x08:]))),
+		}, nil
+	case len(sb) >= ext4SuperblockOffset+0x160 && le.Uint16(sb[ext4SuperblockOffset+0x38:]) == ext4Magic:
+		fs := ext4(sb[ext4SuperblockOffset:])
+		fs.SizeBytes = fs.BlockCount * fs.BlockSize
+		return fs, nil
+	case len(sb) >= erofsSuperblockOffset+0x50 && le.Uint32(sb[erofsSuperblockOffset:]) == erofsMagic:
+		s := sb[erofsSuperblockOffset:]
+		fs := FilesystemInfo{
+			Type:       manifest.RootfsFilesystemEROFS,
+			UUID:       uuid(s[0x30:0x40]),
+			Label:      cString(s[0x40:0x50]),
+			BlockSize:  1 << s[0x0c],
+			BlockCount: int64(le.Uint32(s[0x24:])),
+			Created:    unixTime(int64(le.Uint64(s[0x18:]))),
+		}
+		fs.SizeBytes = fs.BlockCount * fs.BlockSize
+		return fs, nil
+	case len(sb) >= btrfsSuperblockOffset+0x22b && string(sb[btrfsSuperblockOffset+0x40:btrfsSuperblockOffset+0x48]) == btrfsMagic:
+		s := sb[btrfsSuperblockOffset:]
+		sector := int64(le.Uint32(s[0x90:]))
+		total, used := int64(le.Uint64(s[0x70:])), int64(le.Uint64(s[0x78:]))
+		return FilesystemInfo{
+			Type:       manifest.RootfsFilesystemBtrfs,
+			UUID:       uuid(s[0x20:0x30]),
+			Label:      cString(s[0x12b:0x22b]),
+			BlockSize:  sector,
+			BlockCount: total / sector,
+			FreeBlocks: (total - used) / sector,
+			SizeBytes:  total,
+		}, nil
+	}
+	return FilesystemInfo{}, fmt.Errorf("%s: %w: no ext4, squashfs, EROFS or btrfs superblock", path, ErrUnknownFormat)
+}
+
+// ext4 parses the ext2/3/4 superblock s.
+func ext4(s []byte) FilesystemInfo {
+	le := binary.LittleEndian
+	fs := FilesystemInfo{
+		Type:          manifest.RootfsFilesystemExt4,
+		UUID:          uuid(s[0x68:0x78]),
+		Label:         cString(s[0x78:0x88]),
+		BlockSize:     1024 << le.Uint32(s[0x18:]),
+		BlockCount:    int64(le.Uint32(s[0x04:])),
+		FreeBlocks:    int64(le.Uint32(s[0x0c:])),
+		Created:       unixTime(int64(le.Uint32(s[0x108:]))),
+		LastWrite:     unixTime(int64(le.Uint32(s[0x30:]))),
+		LastMount:     unixTime(int64(le.Uint32(s[0x2c:]))),
+		LastMountedOn: cString(s[0x88:0xc8]),
+		MountCount:    int(le.Uint16(s[0x34:])),
+	}
+	compat, incompat := le.Uint32(s[0x5c:]), le.Uint32(s[0x60:])
+	if incompat&ext4Incompat64Bit != 0 {
+		fs.BlockCount |= int64(le.Uint32(s[0x150:])) << 32
+		fs.FreeBlocks |= int64(le.Uint32(s[0x158:])) << 32
+	}
+	switch {
+	case incompat&(ext4IncompatExtents|ext4Incompat64Bit) != 0:
+	case compat&ext4CompatHasJournal != 0:
+		fs.Type = "ext3"
+	default:
+		fs.Type = "ext2"
+	}
+	return fs
+}
+
+// uuid formats a binary UUID, empty when zero.
+func uuid(b []byte) string {
+	if strings.Trim(string(b), "\x00") == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// unixTime returns the time of a superblock timestamp, nil when unset.
+func unixTime(sec int64) *time.Time {
+	if sec == 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0).UTC()
+	return &t
+}
+
+func ceilDiv(a, b int64) int64 {
+	if b == 0 {
+		return 0
+	}
+	return (a + b - 1) / b
+}
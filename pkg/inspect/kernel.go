@@ -0,0 +1,168 @@
+// Package inspect reads the internals of the release artifacts without
+// running or mounting them: the architecture, version banner and embedded
+// config of kernel images (ELF vmlinux, arm64 Image and bzImage) and the
+// superblock of rootfs and disk images (ext4, squashfs, EROFS, btrfs).
+package inspect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// ErrUnknownFormat is returned for files in none of the known formats.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// KernelInfo is an inspected kernel image.
+type KernelInfo struct {
+	// Format is manifest.KernelFormatELF, KernelFormatPE or
+	// KernelFormatBzImage.
+	Format string `json:"format"`
+	// Arch is the build architecture name (x86_64, aarch64).
+	Arch string `json:"arch"`
+	// Version is the "Linux version" banner, the version string of the
+	// setup header for bzImages.
+	Version string `json:"version,omitempty"`
+	// PVH is set for the x86_64 ELFs with a PVH entry point (the Xen
+	// PHYS32_ENTRY note), the ones Cloud Hypervisor boots.
+	PVH bool `json:"pvh,omitempty"`
+	// Config is the embedded .config (CONFIG_IKCONFIG), empty without one.
+	Config string `json:"config,omitempty"`
+}
+
+// arm64ImageMagic is the "ARM\x64" magic of the arm64 Image header.
+const arm64ImageMagic = 0x644d5241
+
+// xenElfnotePhys32Entry is the XEN_ELFNOTE_PHYS32_ENTRY note type, the PVH
+// entry point.
+const xenElfnotePhys32Entry = 18
+
+// riscvImageMagic is the "RSC\x05" magic of the riscv64 Image header, at the
+// same offset.
+const riscvImageMagic = 0x05435352
+
+var (
+	bannerRe = regexp.MustCompile(`Linux version \d[^\x00\n]*`)
+	// ikconfigStart marks the embedded config, followed by its gzip magic.
+	ikconfigStart = []byte("IKCFG_ST\x1f\x8b")
+)
+
+// Kernel inspects the kernel image at path.
+func Kernel(path string) (KernelInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return KernelInfo{}, err
+	}
+
+	var k KernelInfo
+	switch {
+	case bytes.HasPrefix(data, []byte(elf.ELFMAG)):
+		f, err := elf.NewFile(bytes.NewReader(data))
+		if err != nil {
+			return KernelInfo{}, fmt.Errorf("%s: %w", path, err)
+		}
+		k.Format = manifest.KernelFormatELF
+		switch f.Machine {
+		case elf.EM_X86_64:
+			k.Arch = "x86_64"
+			k.PVH = hasPVHNote(f)
+		case elf.EM_AARCH64:
+			k.Arch = "aarch64"
+		case elf.EM_RISCV:
+			k.Arch = "riscv64"
+		default:
+			return KernelInfo{}, fmt.Errorf("%s: unsupported ELF machine %s", path, f.Machine)
+		}
+	case len(data) > 0x40 && binary.LittleEndian.Uint32(data[0x38:]) == arm64ImageMagic:
+		k.Format, k.Arch = manifest.KernelFormatPE, "aarch64"
+	case len(data) > 0x40 && binary.LittleEndian.Uint32(data[0x38:]) == riscvImageMagic:
+		k.Format, k.Arch = manifest.KernelFormatPE, "riscv64"
+	case len(data) > 0x210 && string(data[0x202:0x206]) == "HdrS":
+		k.Format, k.Arch = manifest.KernelFormatBzImage, "x86_64"
+		// The version string pointer is relative to the setup header.
+		if off := int(binary.LittleEndian.Uint16(data[0x20e:])) + 0x200; off < len(data) {
+			k.Version = cString(data[off:])
+		}
+	default:
+		return KernelInfo{}, fmt.Errorf("%s: %w: no ELF, arm64 or riscv64 Image or bzImage header", path, ErrUnknownFormat)
+	}
+
+	// The banner and config are compressed in bzImages.
+	if k.Format != manifest.KernelFormatBzImage {
+		k.Version = string(bannerRe.Find(data))
+		// The first marker starting a valid stream, the kernel code
+		// referencing it can hold a copy.
+		for rest := data; k.Config == ""; {
+			i := bytes.Index(rest, ikconfigStart)
+			if i < 0 {
+				break
+			}
+			rest = rest[i+len(ikconfigStart)-2:]
+			k.Config, _ = gunzip(rest)
+		}
+	}
+	return k, nil
+}
+
+// hasPVHNote reports whether a note segment of the ELF f holds the Xen
+// PHYS32_ENTRY note.
+func hasPVHNote(f *elf.File) bool {
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := io.ReadAll(p.Open())
+		if err != nil {
+			continue
+		}
+		// Notes are a name size, desc size and type header followed by the
+		// name and desc, each 4 bytes aligned.
+		for len(data) >= 12 {
+			namesz := int(f.ByteOrder.Uint32(data))
+			descsz := int(f.ByteOrder.Uint32(data[4:]))
+			typ := f.ByteOrder.Uint32(data[8:])
+			data = data[12:]
+			end := align4(namesz) + align4(descsz)
+			if end > len(data) {
+				break
+			}
+			if typ == xenElfnotePhys32Entry && cString(data[:namesz]) == "Xen" {
+				return true
+			}
+			data = data[end:]
+		}
+	}
+	return false
+}
+
+func align4(n int) int { return (n + 3) &^ 3 }
+
+// cString returns the string at the start of b, up to a NUL or newline.
+func cString(b []byte) string {
+	if i := bytes.IndexAny(b, "\x00\n"); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// gunzip decompresses the gzip stream at the start of b.
+func gunzip(b []byte) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	zr.Multistream(false)
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,163 @@
+// Package jailer lays out the chroot the Firecracker jailer runs a VM in:
+// <base>/<exec file name>/<id>/root, holding the kernel, drives and VM
+// config at the paths the config names inside the chroot.
+//
+// The read-only files are hard linked from the release directory when on
+// the same filesystem and readable by the jailed user, copied otherwise; the
+// writable drives are always copied, sparse, and owned by the jailed user,
+// the release files are never written through the chroot.
+package jailer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/progress"
+	"github.com/slok/sbx-images/pkg/sparse"
+)
+
+// DefaultChrootBase is the jailer default --chroot-base-dir.
+const DefaultChrootBase = "/srv/jailer"
+
+// Chroot is the chroot of a jailed Firecracker VM.
+type Chroot struct {
+	// Base is the --chroot-base-dir (default DefaultChrootBase).
+	Base string
+	// ExecFile is the firecracker binary the jailer copies into the chroot,
+	// its file name naming the chroot directory.
+	ExecFile string
+	// ID is the VM id (--id).
+	ID string
+	// UID and GID are the user and group Firecracker runs as.
+	UID int
+	GID int
+}
+
+// Root returns the chroot directory: <base>/<exec file name>/<id>/root.
+func (c Chroot) Root() string {
+	base := c.Base
+	if base == "" {
+		base = DefaultChrootBase
+	}
+	return filepath.Join(base, filepath.Base(c.ExecFile), c.ID, "root")
+}
+
+// Path returns the host path of the chroot path p (e.g. /vmlinux).
+func (c Chroot) Path(p string) string {
+	return filepath.Join(c.Root(), filepath.FromSlash(p))
+}
+
+// Validate checks the chroot fields.
+func (c Chroot) Validate() error {
+	switch {
+	case c.ID == "":
+		return errors.New("VM id is required")
+	case len(c.ID) > 64 || strings.Trim(c.ID, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-") != "":
+		return fmt.Errorf("VM id %q must be 1 to 64 alphanumeric characters or hyphens", c.ID)
+	case c.ExecFile == "":
+		return errors.New("firecracker binary is required")
+	case c.UID <= 0 || c.GID <= 0:
+		return fmt.Errorf("uid %d and gid %d must be an unprivileged user and group", c.UID, c.GID)
+	}
+	return nil
+}
+
+// Create makes the chroot directory, owned by root as the jailer expects.
+func (c Chroot) Create() error {
+	return os.MkdirAll(c.Root(), 0o755)
+}
+
+// Mkdir makes the chroot directory p owned by the jailed user, for the
+// sockets Firecracker creates (e.g. /run).
+func (c Chroot) Mkdir(p string) error {
+	dir := c.Path(p)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	return os.Chown(dir, c.UID, c.GID)
+}
+
+// Place puts the file src at the chroot path p: a copy owned by the jailed
+// user when writable, a hard link when possible otherwise. An existing
+// writable file is kept, it holds the writes of the VM.
+func (c Chroot) Place(src, p string, writable bool) error {
+	dst := c.Path(p)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if writable {
+		if _, err := os.Stat(dst); err == nil {
+			return os.Chown(dst, c.UID, c.GID)
+		}
+		if err := copyFile(src, dst, 0o600); err != nil {
+			return err
+		}
+		return os.Chown(dst, c.UID, c.GID)
+	}
+
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// Hard links share the mode and owner of the release file, readable
+	// by everyone.
+	if info.Mode().Perm()&0o004 != 0 && os.Link(src, dst) == nil {
+		return nil
+	}
+	if err := copyFile(src, dst, 0o400); err != nil {
+		return err
+	}
+	return os.Chown(dst, c.UID, c.GID)
+}
+
+// Command returns the jailer invocation of the chroot, the firecracker
+// args following "--".
+func (c Chroot) Command(jailer string, firecrackerArgs ...string) []string {
+	args := []string{
+		jailer,
+		"--id", c.ID,
+		"--exec-file", c.ExecFile,
+		"--uid", strconv.Itoa(c.UID),
+		"--gid", strconv.Itoa(c.GID),
+	}
+	if c.Base != "" && c.Base != DefaultChrootBase {
+		args = append(args, "--chroot-base-dir", c.Base)
+	}
+	if len(firecrackerArgs) > 0 {
+		args = append(args, "--")
+		args = append(args, firecrackerArgs...)
+	}
+	return args
+}
+
+// copyFile copies the file src to dst with perm, keeping its holes.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	p := progress.New("copying "+filepath.Base(src), info.Size())
+	defer p.Done()
+	if _, err := sparse.Copy(out, p.Reader(in)); err != nil {
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,52 @@
+package jailer_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/jailer"
+)
+
+func TestChroot(t *testing.T) {
+	c := jailer.Chroot{ExecFile: "/usr/local/bin/firecracker", ID: "vm1", UID: 1234, GID: 1234}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Root(), "/srv/jailer/firecracker/vm1/root"; got != want {
+		t.Errorf("got root %s, want %s", got, want)
+	}
+	if got, want := c.Path("/run/firecracker.socket"), "/srv/jailer/firecracker/vm1/root/run/firecracker.socket"; got != want {
+		t.Errorf("got path %s, want %s", got, want)
+	}
+
+	got := c.Command("/usr/local/bin/jailer", "--api-sock", "/run/firecracker.socket")
+	want := []string{
+		"/usr/local/bin/jailer", "--id", "vm1", "--exec-file", "/usr/local/bin/firecracker",
+		"--uid", "1234", "--gid", "1234", "--", "--api-sock", "/run/firecracker.socket",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got command %q, want %q", got, want)
+	}
+
+	c.Base = "/var/lib/jailer"
+	if got := c.Command("jailer"); !slices.Contains(got, "--chroot-base-dir") || slices.Contains(got, "--") {
+		t.Errorf("got command %q, want --chroot-base-dir and no firecracker args", got)
+	}
+}
+
+func TestChrootValidate(t *testing.T) {
+	valid := jailer.Chroot{ExecFile: "firecracker", ID: "vm-1", UID: 1000, GID: 1000}
+	for name, mutate := range map[string]func(*jailer.Chroot){
+		"no id":      func(c *jailer.Chroot) { c.ID = "" },
+		"invalid id": func(c *jailer.Chroot) { c.ID = "vm_1/../x" },
+		"no binary":  func(c *jailer.Chroot) { c.ExecFile = "" },
+		"root user":  func(c *jailer.Chroot) { c.UID = 0 },
+		"root group": func(c *jailer.Chroot) { c.GID = 0 },
+	} {
+		c := valid
+		mutate(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package kconfig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// KernelArch returns the kernel ARCH for a build architecture name.
+func KernelArch(arch string) (string, error) {
+	switch arch {
+	case "x86_64":
+		return "x86", nil
+	case "aarch64":
+		return "arm64", nil
+	case "riscv64":
+		return "riscv", nil
+	default:
+		return "", fmt.Errorf("unsupported kernel architecture %q", arch)
+	}
+}
+
+// Resolve runs the kernel build system on c in the kernel source tree at
+// srcDir: `make listnewconfig` returns the symbols c leaves undecided (they
+// would silently get their defaults), and `make olddefconfig` returns the
+// resolved config.
+func Resolve(ctx context.Context, srcDir, arch string, c *Config) (*Config, []string, error) {
+	karch, err := KernelArch(arch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outDir, err := os.MkdirTemp("", "sbx-kconfig-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(outDir)
+
+	configPath := filepath.Join(outDir, ".config")
+	if err := c.WriteFile(configPath); err != nil {
+		return nil, nil, err
+	}
+
+	out, err := runMake(ctx, srcDir, outDir, karch, "listnewconfig")
+	if err != nil {
+		return nil, nil, err
+	}
+	var newSymbols []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if sym, _, _ := strings.Cut(line, "="); strings.HasPrefix(sym, "CONFIG_") {
+			newSymbols = append(newSymbols, sym)
+		}
+	}
+
+	if _, err := runMake(ctx, srcDir, outDir, karch, "olddefconfig"); err != nil {
+		return nil, nil, err
+	}
+	resolved, err := Load(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resolved, newSymbols, nil
+}
+
+func runMake(ctx context.Context, srcDir, outDir, karch, target string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "make", "-s", "-C", srcDir, "O="+outDir, "ARCH="+karch, target)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("make %s: %w: %s", target, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
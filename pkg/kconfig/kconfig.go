@@ -0,0 +1,229 @@
+// Package kconfig reads, merges and validates kernel .config files.
+//
+// Merging follows scripts/kconfig/merge_config.sh: fragments are applied in
+// order on top of the base config and later values override earlier ones.
+package kconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// NotSet is the value of symbols disabled with "# CONFIG_FOO is not set".
+const NotSet = "n"
+
+var (
+	setRe    = regexp.MustCompile(`^(CONFIG_[A-Za-z0-9_]+)=(.*)$`)
+	notSetRe = regexp.MustCompile(`^# (CONFIG_[A-Za-z0-9_]+) is not set$`)
+)
+
+// Config is a kernel config: symbol values in file order.
+type Config struct {
+	symbols []string
+	values  map[string]string
+}
+
+// New returns an empty config.
+func New() *Config {
+	return &Config{values: map[string]string{}}
+}
+
+// Parse reads a .config or fragment. Comments and blank lines are dropped.
+func Parse(r io.Reader) (*Config, error) {
+	c := New()
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if m := setRe.FindStringSubmatch(line); m != nil {
+			c.Set(m[1], m[2])
+			continue
+		}
+		if m := notSetRe.FindStringSubmatch(line); m != nil {
+			c.Set(m[1], NotSet)
+			continue
+		}
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return nil, fmt.Errorf("line %d: invalid config entry %q", n, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load parses the config file at path.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Get returns the value of symbol, NotSet for disabled symbols.
+func (c *Config) Get(symbol string) (string, bool) {
+	v, ok := c.values[symbol]
+	return v, ok
+}
+
+// Set sets the value of symbol, NotSet to disable it.
+func (c *Config) Set(symbol, value string) {
+	if _, ok := c.values[symbol]; !ok {
+		c.symbols = append(c.symbols, symbol)
+	}
+	c.values[symbol] = value
+}
+
+// Symbols returns the symbols in file order.
+func (c *Config) Symbols() []string {
+	return c.symbols
+}
+
+// Write writes the config in .config syntax.
+func (c *Config) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range c.symbols {
+		if v := c.values[s]; v == NotSet {
+			fmt.Fprintf(bw, "# %s is not set\n", s)
+		} else {
+			fmt.Fprintf(bw, "%s=%s\n", s, v)
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteFile writes the config to path.
+func (c *Config) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// Fragment is a named config fragment.
+type Fragment struct {
+	Name   string
+	Config *Config
+}
+
+// Override is a base or earlier fragment value redefined by a fragment.
+type Override struct {
+	Symbol   string
+	Fragment string
+	Old      string
+	New      string
+}
+
+func (o Override) String() string {
+	return fmt.Sprintf("%s redefined by %s: %s -> %s", o.Symbol, o.Fragment, o.Old, o.New)
+}
+
+// Merge applies fragments in order on top of base and returns the merged
+// config with the values the fragments redefined.
+func Merge(base *Config, fragments []Fragment) (*Config, []Override) {
+	merged := New()
+	for _, s := range base.symbols {
+		merged.Set(s, base.values[s])
+	}
+
+	var overrides []Override
+	for _, f := range fragments {
+		for _, s := range f.Config.symbols {
+			v := f.Config.values[s]
+			if old, ok := merged.values[s]; ok && old != v {
+				overrides = append(overrides, Override{Symbol: s, Fragment: f.Name, Old: old, New: v})
+			}
+			merged.Set(s, v)
+		}
+	}
+	return merged, overrides
+}
+
+// Validate checks the values requested by the fragments are consistent with
+// the merged config: values are well formed, and modules (=m) are only
+// requested when CONFIG_MODULES is enabled.
+func Validate(merged *Config, fragments []Fragment) []error {
+	var errs []error
+	modules, _ := merged.Get("CONFIG_MODULES")
+	for _, f := range fragments {
+		for _, s := range f.Config.symbols {
+			v := f.Config.values[s]
+			if !validValue(v) {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q for %s", f.Name, v, s))
+			}
+			if v == "m" && modules != "y" {
+				errs = append(errs, fmt.Errorf("%s: %s=m requires CONFIG_MODULES=y", f.Name, s))
+			}
+		}
+	}
+	return errs
+}
+
+var valueRe = regexp.MustCompile(`^(y|m|n|-?[0-9]+|0x[0-9a-fA-F]+|"([^"\\]|\\.)*")$`)
+
+func validValue(v string) bool {
+	return valueRe.MatchString(v)
+}
+
+// Mismatch is a fragment value the kernel build system did not keep, usually
+// because the symbol's dependencies are not enabled.
+type Mismatch struct {
+	Symbol    string
+	Fragment  string
+	Requested string
+	Actual    string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: requested %s=%s but resolved to %s", m.Fragment, m.Symbol, m.Requested, orNotSet(m.Actual))
+}
+
+func orNotSet(v string) string {
+	if v == "" || v == NotSet {
+		return "not set"
+	}
+	return v
+}
+
+// Mismatches returns the fragment values that differ in the resolved config
+// (e.g. after make olddefconfig). Later fragments win over earlier ones.
+func Mismatches(resolved *Config, fragments []Fragment) []Mismatch {
+	requested := map[string]Mismatch{}
+	var order []string
+	for _, f := range fragments {
+		for _, s := range f.Config.symbols {
+			if _, ok := requested[s]; !ok {
+				order = append(order, s)
+			}
+			requested[s] = Mismatch{Symbol: s, Fragment: f.Name, Requested: f.Config.values[s]}
+		}
+	}
+
+	var out []Mismatch
+	for _, s := range order {
+		m := requested[s]
+		actual, _ := resolved.Get(s)
+		if actual == m.Requested || (actual == "" && m.Requested == NotSet) {
+			continue
+		}
+		m.Actual = actual
+		out = append(out, m)
+	}
+	return out
+}
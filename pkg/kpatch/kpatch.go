@@ -0,0 +1,106 @@
+// Package kpatch prepares the kernel patch series applied before source
+// kernel builds.
+//
+// Patches are fetched (URLs) or copied (files), checked against their pinned
+// digests and written to a patches directory as NNNN-<name>.patch, the order
+// build-kernel.sh applies them in.
+package kpatch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/slok/sbx-images/pkg/config"
+)
+
+// Patch is a prepared patch of the series.
+type Patch struct {
+	// File is the patch file name in the patches directory.
+	File string
+	// Source is the patch URL or config relative file path.
+	Source string
+	SHA256 string
+}
+
+// FileName returns the file name of the i-th (0 based) patch of the series.
+func FileName(i int, p config.KernelPatch) string {
+	name := filepath.Base(p.File)
+	if p.URL != "" {
+		name = "patch"
+		if u, err := url.Parse(p.URL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+			name = path.Base(u.Path)
+		}
+	}
+	if filepath.Ext(name) != ".patch" {
+		name += ".patch"
+	}
+	return fmt.Sprintf("%04d-%s", i+1, name)
+}
+
+// Source returns the source of p recorded in the manifest.
+func Source(p config.KernelPatch) string {
+	if p.URL != "" {
+		return p.URL
+	}
+	return filepath.ToSlash(p.File)
+}
+
+// Prepare writes the patch series to dir, replacing any previous series,
+// and returns the prepared patches.
+func Prepare(ctx context.Context, patches []config.KernelPatch, dir string) ([]Patch, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	out := make([]Patch, 0, len(patches))
+	for i, p := range patches {
+		data, err := fetch(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("patch %s: %w", Source(p), err)
+		}
+
+		sum := sha256.Sum256(data)
+		digest := hex.EncodeToString(sum[:])
+		if p.SHA256 != "" && digest != p.SHA256 {
+			return nil, fmt.Errorf("patch %s: sha256 mismatch: got %s, want %s", Source(p), digest, p.SHA256)
+		}
+
+		name := FileName(i, p)
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return nil, err
+		}
+		out = append(out, Patch{File: name, Source: Source(p), SHA256: digest})
+	}
+	return out, nil
+}
+
+func fetch(ctx context.Context, p config.KernelPatch) ([]byte, error) {
+	if p.URL == "" {
+		return os.ReadFile(p.File)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %d", p.URL, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,80 @@
+// Package logging sets up the log/slog logger of the commands from their
+// -log-level and -log-format flags.
+//
+// The commands log what they do (files written, steps run, warnings) to
+// stderr through the default slog logger, their data output (listings,
+// reports, JSON documents) staying on stdout. The text format is the
+// readable slog text without timestamps, for interactive use; the json
+// format is one JSON object per line, for CI log filtering.
+package logging
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Formats are the supported log formats.
+var Formats = []string{FormatText, FormatJSON}
+
+// Flags are the logging flags of a command.
+type Flags struct {
+	Level  string
+	Format string
+}
+
+// AddFlags registers -log-level and -log-format on fs, defaulting to the
+// SBX_LOG_LEVEL and SBX_LOG_FORMAT environment variables, then info and
+// text.
+func AddFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Level, "log-level", cmp.Or(os.Getenv("SBX_LOG_LEVEL"), "info"), "Log level: debug, info, warn or error")
+	fs.StringVar(&f.Format, "log-format", cmp.Or(os.Getenv("SBX_LOG_FORMAT"), FormatText), "Log format: text or json")
+	return f
+}
+
+// Setup sets the default slog logger to log to stderr as configured by the
+// flags.
+func (f *Flags) Setup() error {
+	l, err := New(os.Stderr, f.Level, f.Format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(l)
+	return nil
+}
+
+// New returns a logger writing to w records of level and above in format.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("-log-level: unknown level %q (supported: debug, info, warn, error)", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case FormatText:
+		// The time is noise in a terminal, and CI runners timestamp every
+		// line already.
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("-log-format: unknown format %q (supported: %s)", format, strings.Join(Formats, ", "))
+}
@@ -0,0 +1,73 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Compatible checks that a Firecracker version (e.g. v1.14.1) is in the
+// range the release was tested with. Manifests without a range accept any
+// version.
+func (f Firecracker) Compatible(version string) error {
+	if f.MinVersion != "" {
+		c, err := CompareVersions(version, f.MinVersion)
+		if err != nil {
+			return err
+		}
+		if c < 0 {
+			return fmt.Errorf("firecracker %s is older than the minimum supported %s", version, f.MinVersion)
+		}
+	}
+	if f.MaxVersion != "" {
+		c, err := CompareVersions(version, f.MaxVersion)
+		if err != nil {
+			return err
+		}
+		if c > 0 {
+			return fmt.Errorf("firecracker %s is newer than the maximum supported %s", version, f.MaxVersion)
+		}
+	}
+	return nil
+}
+
+// CompareVersions compares two vMAJOR.MINOR.PATCH versions ("v" and the
+// patch are optional), returning -1, 0 or 1. Pre-release and build suffixes
+// are ignored.
+func CompareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range va {
+		switch {
+		case va[i] < vb[i]:
+			return -1, nil
+		case va[i] > vb[i]:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func parseVersion(s string) ([3]int, error) {
+	var v [3]int
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "-")
+	core, _, _ = strings.Cut(core, "+")
+	parts := strings.Split(core, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
@@ -0,0 +1,936 @@
+// Package manifest defines the release manifest format written to manifest.json.
+package manifest
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Manifest is the release manifest written to manifest.json.
+type Manifest struct {
+	SchemaVersion int                      `json:"schema_version"`
+	Version       string                   `json:"version"`
+	Artifacts     map[string]ArchArtifacts `json:"artifacts"`
+	Firecracker   Firecracker              `json:"firecracker"`
+	Disks         []DiskArtifact           `json:"disks,omitempty"`
+	Build         Build                    `json:"build"`
+	Signing       *Signing                 `json:"signing,omitempty"`
+	// Upstream is the release the rootfs of a derived manifest was
+	// provisioned from (cmd/provision).
+	Upstream *Upstream `json:"upstream,omitempty"`
+	// Extensions carries the "x-" prefixed fields from config.yaml.
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// DefaultFlavor is the name of the default kernel flavor.
+const DefaultFlavor = "default"
+
+// ArchArtifacts contains per-architecture artifact metadata.
+type ArchArtifacts struct {
+	// Kernel is the default kernel flavor.
+	Kernel KernelArtifact `json:"kernel"`
+	// KernelFlavors are the additional kernel flavors, in config order.
+	KernelFlavors []KernelArtifact `json:"kernel_flavors,omitempty"`
+	// Rootfs is the default rootfs profile image.
+	Rootfs RootfsArtifact `json:"rootfs"`
+	// RootfsProfiles are the additional rootfs profile images, in config
+	// order.
+	RootfsProfiles []RootfsArtifact `json:"rootfs_profiles,omitempty"`
+	// Initramfs is the early boot initramfs, when the release ships one.
+	Initramfs *InitramfsArtifact `json:"initramfs,omitempty"`
+	// Firecracker are the bundled upstream Firecracker binaries, when the
+	// release ships them.
+	Firecracker *FirecrackerArtifact `json:"firecracker,omitempty"`
+	// Agent is the guest agent embedded in the rootfs images, when they
+	// have one.
+	Agent *AgentArtifact `json:"agent,omitempty"`
+	// Capabilities are the guest capability flags verified by the boot
+	// self check (kvm_clock, clock_synced, virtio_rng, entropy_ready...).
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+	// QEMUMicrovm is the QEMU microvm boot metadata of the images, when
+	// the release publishes it (x86_64 only).
+	QEMUMicrovm *QEMUMicrovm `json:"qemu_microvm,omitempty"`
+}
+
+// KernelArtifact describes the kernel binary.
+type KernelArtifact struct {
+	// Flavor is the kernel flavor name, empty for the default kernel.
+	Flavor string `json:"flavor,omitempty"`
+	File   string `json:"file"`
+	// Format is the image format of File (KernelFormatELF on x86_64,
+	// KernelFormatPE on aarch64 and riscv64).
+	Format string `json:"format,omitempty"`
+	// Hypervisor is the hypervisor the kernel is built for, empty for
+	// HypervisorFirecracker.
+	Hypervisor string `json:"hypervisor,omitempty"`
+	Version    string `json:"version"`
+	Source     string `json:"source"`
+	// SourceRef and SourceCommit are the git ref and commit of kernels built
+	// from source, Source being the repository.
+	SourceRef    string `json:"source_ref,omitempty"`
+	SourceCommit string `json:"source_commit,omitempty"`
+	// UpstreamSHA256 is the digest published upstream for downloaded
+	// kernels, verified at download time.
+	UpstreamSHA256 string `json:"upstream_sha256,omitempty"`
+	SizeBytes      int64  `json:"size_bytes"`
+	SHA256         string `json:"sha256"`
+	Signature      string `json:"signature,omitempty"`
+	Provenance     string `json:"provenance,omitempty"`
+	// Patches are the patches applied to the kernel source, in order.
+	Patches []KernelPatch `json:"patches,omitempty"`
+	// Config is the kernel .config file the kernel was built with.
+	Config string `json:"config,omitempty"`
+	// ConfigSHA256 is the digest of the kernel .config file.
+	ConfigSHA256 string `json:"config_sha256,omitempty"`
+	// Modules is the kernel modules archive, when the kernel has one.
+	Modules *ModulesArtifact `json:"modules,omitempty"`
+	// Images are the same kernel packaged in other formats (e.g. a
+	// compressed bzImage), in addition to File.
+	Images []KernelImage `json:"images,omitempty"`
+	// PostProcess describes the post-processed files of the kernel.
+	PostProcess *PostProcess `json:"post_process,omitempty"`
+}
+
+// Kernel image formats.
+const (
+	// KernelFormatELF is the uncompressed vmlinux ELF, booted directly or
+	// through its PVH entry point on x86_64.
+	KernelFormatELF = "elf"
+	// KernelFormatPE is the arm64 or riscv64 Image.
+	KernelFormatPE = "pe"
+	// KernelFormatBzImage is the compressed x86_64 bzImage.
+	KernelFormatBzImage = "bzimage"
+)
+
+// Hypervisors the kernels are built for.
+const (
+	// HypervisorFirecracker is the default hypervisor, booting the kernels
+	// with virtio-mmio devices (pci=off).
+	HypervisorFirecracker = "firecracker"
+	// HypervisorCloudHypervisor is Cloud Hypervisor, booting the vmlinux
+	// through its PVH entry point on x86_64, with virtio-pci devices.
+	HypervisorCloudHypervisor = "cloud-hypervisor"
+)
+
+// HypervisorQEMUMicrovm is QEMU's microvm machine type, booting the
+// Firecracker kernels with virtio-mmio devices: a boot target only, no
+// kernel is built for it.
+const HypervisorQEMUMicrovm = "qemu-microvm"
+
+// Hypervisors are the supported hypervisor names.
+var Hypervisors = []string{HypervisorFirecracker, HypervisorCloudHypervisor}
+
+// TargetHypervisor returns the hypervisor k is built for.
+func (k KernelArtifact) TargetHypervisor() string {
+	return cmp.Or(k.Hypervisor, HypervisorFirecracker)
+}
+
+// Architectures are the supported build architecture names, the keys of
+// Manifest.Artifacts.
+var Architectures = []string{"x86_64", "aarch64", "riscv64"}
+
+// KernelFormat returns the format of the vmlinux kernel file of arch.
+func KernelFormat(arch string) string {
+	if arch == "aarch64" || arch == "riscv64" {
+		return KernelFormatPE
+	}
+	return KernelFormatELF
+}
+
+// KernelImageFile returns the name of a kernel flavor's file in format for
+// arch: vmlinux-<arch> for KernelFormat(arch) and bzImage-<arch> for
+// KernelFormatBzImage (see FlavorName).
+func KernelImageFile(format, flavor, arch string) string {
+	if format == KernelFormatBzImage {
+		return FlavorName("bzImage", flavor, arch)
+	}
+	return FlavorName("vmlinux", flavor, arch)
+}
+
+// KernelImage is a kernel file in a given format.
+type KernelImage struct {
+	Format     string `json:"format"`
+	File       string `json:"file"`
+	SizeBytes  int64  `json:"size_bytes"`
+	SHA256     string `json:"sha256"`
+	Signature  string `json:"signature,omitempty"`
+	Provenance string `json:"provenance,omitempty"`
+}
+
+// Image returns the kernel file of the given format, File being in Format
+// (KernelFormat of the arch for manifests without one).
+func (k KernelArtifact) Image(format, arch string) (KernelImage, bool) {
+	if format == cmp.Or(k.Format, KernelFormat(arch)) {
+		return KernelImage{
+			Format:     format,
+			File:       k.File,
+			SizeBytes:  k.SizeBytes,
+			SHA256:     k.SHA256,
+			Signature:  k.Signature,
+			Provenance: k.Provenance,
+		}, true
+	}
+	for _, img := range k.Images {
+		if img.Format == format {
+			return img, true
+		}
+	}
+	return KernelImage{}, false
+}
+
+// KernelPatch is a patch applied to the kernel source.
+type KernelPatch struct {
+	// Source is the patch URL or repository relative file path.
+	Source string `json:"source"`
+	SHA256 string `json:"sha256"`
+}
+
+// ModulesArtifact describes the kernel modules archive (lib/modules tree).
+type ModulesArtifact struct {
+	File string `json:"file"`
+	// Installed is set when the modules are installed in the rootfs image,
+	// the archive is then not shipped with the release.
+	Installed bool   `json:"installed"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Shipped reports whether the archive is a release file.
+func (a *ModulesArtifact) Shipped() bool {
+	return a != nil && !a.Installed
+}
+
+// QEMUMicrovm is the boot metadata of QEMU's microvm machine type for the
+// Firecracker kernels and the rootfs images, under KVM or emulated, their
+// boot args being the HypervisorQEMUMicrovm ones (see
+// RootfsArtifact.BootArgsFor).
+type QEMUMicrovm struct {
+	// Binary is the QEMU system emulator (qemu-system-x86_64).
+	Binary string `json:"binary"`
+	// Machine is the -machine value.
+	Machine string `json:"machine"`
+	// KernelFormat is the format of the kernel image booted with -kernel,
+	// the vmlinux ELF through its PVH entry point.
+	KernelFormat string `json:"kernel_format"`
+	// Args are the emulator arguments, {kernel}, {rootfs} and {cmdline}
+	// standing for the kernel, rootfs image and boot args.
+	Args []string `json:"args"`
+}
+
+// Command returns the emulator command line booting the kernel and rootfs
+// image files with cmdline.
+func (q QEMUMicrovm) Command(kernel, rootfs, cmdline string) []string {
+	// The rootfs is a value of the -drive options, its commas doubled.
+	r := strings.NewReplacer("{kernel}", kernel, "{rootfs}", strings.ReplaceAll(rootfs, ",", ",,"), "{cmdline}", cmdline)
+	cmd := []string{q.Binary}
+	for _, arg := range q.Args {
+		cmd = append(cmd, r.Replace(arg))
+	}
+	return cmd
+}
+
+// InitramfsArtifact describes the initramfs (gzip compressed newc cpio
+// archive) booted as the Firecracker initrd before the rootfs.
+type InitramfsArtifact struct {
+	File string `json:"file"`
+	// BusyboxVersion is the version of the Alpine busybox-static package the
+	// initramfs runs, downloaded from Source.
+	BusyboxVersion string `json:"busybox_version"`
+	Source         string `json:"source"`
+	// InitSHA256 is the digest of the /init script.
+	InitSHA256 string `json:"init_sha256"`
+	SizeBytes  int64  `json:"size_bytes"`
+	SHA256     string `json:"sha256"`
+	Signature  string `json:"signature,omitempty"`
+	Provenance string `json:"provenance,omitempty"`
+}
+
+// FirecrackerArtifact describes the firecracker and jailer binaries of the
+// upstream Firecracker release bundled with the images.
+type FirecrackerArtifact struct {
+	Version string `json:"version"`
+	// Source is the upstream release archive the binaries were extracted
+	// from, SourceSHA256 its upstream published digest.
+	Source       string         `json:"source"`
+	SourceSHA256 string         `json:"source_sha256"`
+	Firecracker  BinaryArtifact `json:"firecracker"`
+	Jailer       BinaryArtifact `json:"jailer"`
+}
+
+// Binaries returns the firecracker and jailer binaries, to iterate or
+// update them in place.
+func (a *FirecrackerArtifact) Binaries() []*BinaryArtifact {
+	if a == nil {
+		return nil
+	}
+	return []*BinaryArtifact{&a.Firecracker, &a.Jailer}
+}
+
+// AgentArtifact describes the guest agent binary embedded in the rootfs
+// images of an architecture and run by their init system, so hosts know
+// which agent protocol to speak.
+type AgentArtifact struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Protocol string `json:"protocol,omitempty"`
+	// Path is the binary path in the images.
+	Path string `json:"path"`
+	// Source is the download URL or the config relative file the binary was
+	// taken from.
+	Source string `json:"source"`
+	SHA256 string `json:"sha256"`
+}
+
+// BinaryArtifact describes a bundled executable.
+type BinaryArtifact struct {
+	File       string `json:"file"`
+	SizeBytes  int64  `json:"size_bytes"`
+	SHA256     string `json:"sha256"`
+	Signature  string `json:"signature,omitempty"`
+	Provenance string `json:"provenance,omitempty"`
+}
+
+// RootfsArtifact describes the rootfs image.
+type RootfsArtifact struct {
+	File string `json:"file"`
+	// Filesystem is the filesystem of File (RootfsFilesystemExt4 for
+	// manifests without one).
+	Filesystem string `json:"filesystem,omitempty"`
+	// MkfsOptions are the mkfs.ext4 options File was created with, empty
+	// for the mkfs.ext4 defaults.
+	MkfsOptions   string `json:"mkfs_options,omitempty"`
+	Distro        string `json:"distro"`
+	DistroVersion string `json:"distro_version"`
+	// Bootstrap is the distro bootstrap method the image was built with,
+	// "oci" for the images built from a container image.
+	Bootstrap string `json:"bootstrap,omitempty"`
+	// SourceImage is the container image the image was built from.
+	SourceImage *SourceImage `json:"source_image,omitempty"`
+	Profile     string       `json:"profile"`
+	// Definition is the effective profile definition the image was built
+	// from.
+	Definition *RootfsDefinition `json:"definition,omitempty"`
+	// BootArgs is the recommended kernel cmdline of the image's profile.
+	BootArgs string `json:"boot_args,omitempty"`
+	// HypervisorBootArgs are the BootArgs of the kernels of other
+	// hypervisors than Firecracker, by hypervisor, see BootArgsFor.
+	HypervisorBootArgs map[string]string `json:"hypervisor_boot_args,omitempty"`
+	// SizeBytes is the apparent size of the sparse image, DiskUsageBytes
+	// the bytes allocated to it, its unused blocks being holes.
+	SizeBytes      int64  `json:"size_bytes"`
+	DiskUsageBytes int64  `json:"disk_usage_bytes,omitempty"`
+	SHA256         string `json:"sha256"`
+	Signature      string `json:"signature,omitempty"`
+	Provenance     string `json:"provenance,omitempty"`
+	// SBOM is the SPDX JSON SBOM file of the image.
+	SBOM string `json:"sbom,omitempty"`
+	// SBOMs are the SBOM files of the image by format (spdx, cyclonedx).
+	SBOMs map[string]string `json:"sboms,omitempty"`
+	// Vulnerabilities is the vulnerability scan report file of the image.
+	Vulnerabilities string `json:"vulnerabilities,omitempty"`
+	// Firstboot is set when the image ships the sbx-firstboot service.
+	Firstboot *Firstboot `json:"firstboot,omitempty"`
+	// Packages is the installed package inventory, sorted by name.
+	Packages []Package `json:"packages,omitempty"`
+	// PackagesSHA256 is the digest of the inventory, one "name version" line
+	// per package, for quick comparison between releases.
+	PackagesSHA256 string `json:"packages_sha256,omitempty"`
+	// Licenses counts the installed packages per declared license identifier.
+	Licenses map[string]int `json:"licenses,omitempty"`
+	// Images are the same image tree in other filesystems (e.g. a read-only
+	// squashfs), in addition to File.
+	Images []RootfsImage `json:"images,omitempty"`
+	// Converted are File and Images converted to other disk image formats
+	// (e.g. qcow2), see ConvertedFile.
+	Converted []RootfsImage `json:"converted,omitempty"`
+	// Overlay is the empty overlay disk template the image is paired with,
+	// see RootfsOverlay.
+	Overlay *RootfsOverlay `json:"overlay,omitempty"`
+	// Verity is the dm-verity hash tree of the read-only root image, see
+	// RootfsVerity.
+	Verity *RootfsVerity `json:"verity,omitempty"`
+	// PostProcess describes the post-processed files of the image.
+	PostProcess *PostProcess `json:"post_process,omitempty"`
+}
+
+// Rootfs image filesystems.
+const (
+	// RootfsFilesystemExt4 is the writable ext4 image.
+	RootfsFilesystemExt4 = "ext4"
+	// RootfsFilesystemSquashfs is a read-only zstd compressed squashfs
+	// image, smaller to distribute.
+	RootfsFilesystemSquashfs = "squashfs"
+	// RootfsFilesystemEROFS is a read-only lz4hc compressed EROFS image,
+	// faster than squashfs on random reads.
+	RootfsFilesystemEROFS = "erofs"
+	// RootfsFilesystemBtrfs is a writable btrfs image with transparent zstd
+	// compression, for snapshots in the guest.
+	RootfsFilesystemBtrfs = "btrfs"
+)
+
+// RootfsFilesystemReadOnly reports whether images of filesystem must be
+// attached as read-only drives.
+func RootfsFilesystemReadOnly(filesystem string) bool {
+	return filesystem == RootfsFilesystemSquashfs || filesystem == RootfsFilesystemEROFS
+}
+
+// RootfsImageFile returns the name of the rootfs image file of stem in
+// filesystem: rootfs-<stem>.<filesystem>.
+func RootfsImageFile(filesystem, stem string) string {
+	return fmt.Sprintf("rootfs-%s.%s", stem, filesystem)
+}
+
+// Rootfs disk image formats, the File and Images being raw.
+const (
+	DiskFormatRaw   = "raw"
+	DiskFormatQCOW2 = "qcow2"
+)
+
+// DiskFormats are the disk image formats rootfs images are converted to.
+var DiskFormats = []string{DiskFormatRaw, DiskFormatQCOW2}
+
+// ConvertedFile returns the name of the image file converted to format:
+// <file>.<format>, e.g. rootfs-x86_64.ext4.qcow2.
+func ConvertedFile(file, format string) string {
+	return file + "." + format
+}
+
+// RootfsImage is a rootfs image file in a given filesystem.
+type RootfsImage struct {
+	// Filesystem is empty for the dm-verity hash tree.
+	Filesystem string `json:"filesystem,omitempty"`
+	// ReadOnly is set for the read-only filesystems, attached as read-only
+	// drives.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// BootArgs is the recommended kernel cmdline of the image, the rootfs
+	// boot args with its root filesystem type.
+	BootArgs string `json:"boot_args,omitempty"`
+	// MkfsOptions are the options the filesystem was created with.
+	MkfsOptions string `json:"mkfs_options,omitempty"`
+	// MountOptions are the options the image was filled with, to mount it
+	// with (rootflags=) for the same behavior, e.g. the btrfs compression.
+	MountOptions string `json:"mount_options,omitempty"`
+	File         string `json:"file"`
+	// SizeBytes and DiskUsageBytes are the apparent and allocated sizes,
+	// as for RootfsArtifact.
+	SizeBytes      int64  `json:"size_bytes"`
+	DiskUsageBytes int64  `json:"disk_usage_bytes,omitempty"`
+	SHA256         string `json:"sha256"`
+	Signature      string `json:"signature,omitempty"`
+	Provenance     string `json:"provenance,omitempty"`
+	// Format is the disk image format of the Converted images, converted
+	// from the Source image file, their disk of VirtualSizeBytes.
+	Format           string `json:"format,omitempty"`
+	Source           string `json:"source,omitempty"`
+	VirtualSizeBytes int64  `json:"virtual_size_bytes,omitempty"`
+}
+
+// RootfsOverlay is an empty ext4 disk template holding the writes of a
+// rootfs image booted read-only, shared by every VM: each VM gets its own
+// copy of the template as its second drive (OverlayDevice) and the
+// initramfs mounts an overlayfs of both as the root. BootArgs boot the pair.
+type RootfsOverlay struct {
+	RootfsImage
+	// Root is the image file the overlay goes on top of.
+	Root string `json:"root"`
+}
+
+// OverlayDevice is the block device of the overlay disk, the drive attached
+// after the root drive.
+const OverlayDevice = "/dev/vdb"
+
+// OverlayFile returns the name of the overlay disk template of stem.
+func OverlayFile(stem string) string {
+	return fmt.Sprintf("rootfs-%s.overlay.ext4", stem)
+}
+
+// RootfsVerity is the dm-verity hash tree of a rootfs image booted
+// read-only, attached as the drive after the root one (and the overlay
+// disk), VerityDevice. BootArgs open the verified root with the kernel
+// dm-mod.create parameter (CONFIG_DM_INIT and CONFIG_DM_VERITY), hosts
+// enforcing verified boot pin RootHash.
+type RootfsVerity struct {
+	RootfsImage
+	// Root is the image file the hash tree verifies.
+	Root          string `json:"root"`
+	RootHash      string `json:"root_hash"`
+	Salt          string `json:"salt"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	DataBlockSize int    `json:"data_block_size"`
+	HashBlockSize int    `json:"hash_block_size"`
+	DataBlocks    int64  `json:"data_blocks"`
+}
+
+// VerityDevice returns the block device of the dm-verity hash tree drive,
+// after the overlay disk when the image has one.
+func VerityDevice(overlay bool) string {
+	if overlay {
+		return "/dev/vdc"
+	}
+	return "/dev/vdb"
+}
+
+// VerityFile returns the name of the dm-verity hash tree of the image file.
+func VerityFile(image string) string {
+	return image + ".verity"
+}
+
+// ExtraImages returns the Images followed by the Overlay template, the
+// Verity hash tree and the Converted images, to iterate or update them in
+// place.
+func (r *RootfsArtifact) ExtraImages() []*RootfsImage {
+	var images []*RootfsImage
+	for i := range r.Images {
+		images = append(images, &r.Images[i])
+	}
+	if r.Overlay != nil {
+		images = append(images, &r.Overlay.RootfsImage)
+	}
+	if r.Verity != nil {
+		images = append(images, &r.Verity.RootfsImage)
+	}
+	for i := range r.Converted {
+		images = append(images, &r.Converted[i])
+	}
+	return images
+}
+
+// Image returns the rootfs image file in the given filesystem, File being
+// in Filesystem.
+func (r RootfsArtifact) Image(filesystem string) (RootfsImage, bool) {
+	if filesystem == cmp.Or(r.Filesystem, RootfsFilesystemExt4) {
+		return RootfsImage{
+			Filesystem: filesystem,
+			BootArgs:   r.BootArgs,
+			File:       r.File,
+			SizeBytes:  r.SizeBytes,
+			SHA256:     r.SHA256,
+			Signature:  r.Signature,
+			Provenance: r.Provenance,
+		}, true
+	}
+	for _, img := range r.Images {
+		if img.Filesystem == filesystem {
+			return img, true
+		}
+	}
+	return RootfsImage{}, false
+}
+
+// BootArgsFor returns args, the boot args of r or of one of its images,
+// overlay or verity root, for the kernels of hypervisor: the BootArgs they
+// start with replaced by the hypervisor ones.
+func (r RootfsArtifact) BootArgsFor(args, hypervisor string) string {
+	hvArgs, ok := r.HypervisorBootArgs[hypervisor]
+	if !ok {
+		return args
+	}
+	rest, ok := strings.CutPrefix(args, r.BootArgs)
+	if !ok {
+		return args
+	}
+	return strings.TrimSpace(hvArgs + rest)
+}
+
+// RootfsNix is the Nix flake package building a rootfs image.
+type RootfsNix struct {
+	// Flake is the flake directory, relative to the config file.
+	Flake string `json:"flake"`
+	// Package is the flake package, built for the architecture's system
+	// (packages.<arch>-linux.<package>).
+	Package string `json:"package"`
+	// LockSHA256 is the digest of the flake.lock pinning the inputs.
+	LockSHA256 string `json:"lock_sha256"`
+}
+
+// SourceImage is a pulled OCI container image.
+type SourceImage struct {
+	// Image is the reference from the definition.
+	Image string `json:"image"`
+	// Digest is the pulled platform image manifest digest.
+	Digest   string `json:"digest"`
+	Platform string `json:"platform"`
+}
+
+// RootfsDefinition is the effective definition of a rootfs profile, resolved
+// through its extends chain.
+type RootfsDefinition struct {
+	// Extends is the inheritance chain, from the profile's parent to the
+	// root profile.
+	Extends []string `json:"extends,omitempty"`
+	// Packages are the requested packages, in install order.
+	Packages []string `json:"packages"`
+	// FilesDirs are the directories copied over the image, in order,
+	// relative to the config file.
+	FilesDirs []string `json:"files_dirs,omitempty"`
+	// Files are the files installed from config.yaml.
+	Files []RootfsFile `json:"files,omitempty"`
+	// Users are the user accounts set up at build time.
+	Users     []RootfsUser `json:"users,omitempty"`
+	Firstboot bool         `json:"firstboot"`
+	// Init is the init system: openrc, systemd or sbx (busybox init running
+	// the sbx-rc service scripts).
+	Init string `json:"init"`
+	// CloudInit is set for images running cloud-init with the NoCloud
+	// datasource, configured from a cidata seed drive.
+	CloudInit bool `json:"cloud_init,omitempty"`
+	// Ignition is the Ignition config applied on the first boot, when the
+	// image runs Ignition.
+	Ignition *RootfsIgnition `json:"ignition,omitempty"`
+	// Image is the OCI container image reference the image was built from,
+	// its flattened layers replacing the distro bootstrap.
+	Image string `json:"image,omitempty"`
+	// Nix is the flake package the image was built by, as is, when the
+	// profile is a Nix profile.
+	Nix      *RootfsNix `json:"nix,omitempty"`
+	Timezone string     `json:"timezone,omitempty"`
+	Locale   string     `json:"locale,omitempty"`
+	// HostnameTemplate renders the image hostname from the profile and
+	// architecture, empty when the image keeps the distro hostname.
+	HostnameTemplate string `json:"hostname_template,omitempty"`
+	// Filesystems are the filesystems of the images published next to the
+	// ext4 one.
+	Filesystems []string `json:"filesystems,omitempty"`
+	// Overlay is set for images paired with an overlay disk template.
+	Overlay bool `json:"overlay,omitempty"`
+	// Verity is set for images shipping a dm-verity hash tree.
+	Verity bool `json:"verity,omitempty"`
+	// SizeMiB is the configured size of the ext4 image of the architecture,
+	// zero when sized to its content.
+	SizeMiB int `json:"size_mib,omitempty"`
+}
+
+// RootfsIgnition is the Ignition config embedded in a rootfs image, applied
+// with the ignition binary of Version on the first boot (the boot args carry
+// ignition.firstboot).
+type RootfsIgnition struct {
+	// Config is the config.yaml relative Ignition config.
+	Config      string `json:"config"`
+	SpecVersion string `json:"spec_version"`
+	SHA256      string `json:"sha256"`
+	// Version is the version of the embedded ignition binary.
+	Version string `json:"version,omitempty"`
+}
+
+// RootfsUser is a user account set up in a rootfs image, its SSH keys are
+// not recorded.
+type RootfsUser struct {
+	Name string `json:"name"`
+	// UID is the requested user ID, zero when allocated at build time.
+	UID    int      `json:"uid,omitempty"`
+	Shell  string   `json:"shell"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// RootfsFile is a file installed in a rootfs image.
+type RootfsFile struct {
+	// Dest is the path in the image.
+	Dest string `json:"dest"`
+	// Source is the host file relative to the config file, empty for
+	// inline content.
+	Source string `json:"source,omitempty"`
+	Mode   string `json:"mode"`
+	Owner  string `json:"owner"`
+	SHA256 string `json:"sha256"`
+}
+
+// PostProcess records the post-processing pipeline run on an artifact and
+// the files it produced, in order (e.g. split parts).
+type PostProcess struct {
+	Stages []PostProcessStage `json:"stages"`
+	Files  []ProcessedFile    `json:"files"`
+}
+
+// PostProcessStage is a pipeline stage and its options.
+type PostProcessStage struct {
+	Stage   string            `json:"stage"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// ProcessedFile is a file produced by post-processing.
+type ProcessedFile struct {
+	File      string `json:"file"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Package is an installed rootfs package.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// License is the license expression declared by the package manager.
+	License string `json:"license,omitempty"`
+}
+
+// Firstboot describes the first boot service baked into the rootfs.
+type Firstboot struct {
+	Version string `json:"version"`
+}
+
+// Firecracker describes the expected Firecracker version.
+type Firecracker struct {
+	Version string `json:"version"`
+	Source  string `json:"source"`
+	// MinVersion and MaxVersion are the inclusive range of Firecracker
+	// versions the release was tested with, see Compatible.
+	MinVersion string `json:"min_version,omitempty"`
+	MaxVersion string `json:"max_version,omitempty"`
+}
+
+// Build contains build metadata.
+type Build struct {
+	Date   string `json:"date"`
+	Commit string `json:"commit"`
+	// Attestations lists the in-toto link attestation files of the build steps.
+	Attestations []string `json:"attestations,omitempty"`
+	// SourceDateEpoch is the SOURCE_DATE_EPOCH the build ran with.
+	SourceDateEpoch int64 `json:"source_date_epoch,omitempty"`
+	// ConfigSHA256 is the digest of the config.yaml the build used.
+	ConfigSHA256 string `json:"config_sha256,omitempty"`
+	// ConfigOverrides are the config values the build overrode from the
+	// environment, per environment variable (e.g. SBX_KERNEL_VERSION).
+	ConfigOverrides map[string]string `json:"config_overrides,omitempty"`
+	// Toolchain are the versions of the tools that produced the artifacts.
+	Toolchain map[string]string `json:"toolchain,omitempty"`
+	// BootTimes are the boot times of the rootfs images measured by the boot
+	// test, per architecture and rootfs profile.
+	BootTimes []BootTime `json:"boot_times,omitempty"`
+}
+
+// BootTime is the boot time of a rootfs profile image with the default
+// kernel under Firecracker, over Runs boots.
+type BootTime struct {
+	Arch    string `json:"arch"`
+	Profile string `json:"profile"`
+	Runs    int    `json:"runs"`
+	// TimeToInit is the time until the guest init started.
+	TimeToInit Percentiles `json:"time_to_init"`
+	// TimeToAgent is the time until the guest agent answered on vsock, when
+	// measured.
+	TimeToAgent *Percentiles `json:"time_to_agent,omitempty"`
+}
+
+// Percentiles are the median and 95th percentile of a duration.
+type Percentiles struct {
+	P50MS int64 `json:"p50_ms"`
+	P95MS int64 `json:"p95_ms"`
+}
+
+// Upstream is the release a derived rootfs image was provisioned from, and
+// the customizations applied to its image.
+type Upstream struct {
+	Version string `json:"version"`
+	// ManifestSHA256 is the digest of the upstream manifest.json.
+	ManifestSHA256 string `json:"manifest_sha256"`
+	// Rootfs is the upstream rootfs image file, SHA256 its digest.
+	Rootfs string `json:"rootfs"`
+	SHA256 string `json:"sha256"`
+	// FilesDirs are the directories copied over the image, in order.
+	FilesDirs []string `json:"files_dirs,omitempty"`
+	// Packages are the installed packages, in install order.
+	Packages []string `json:"packages,omitempty"`
+	// SSHUsers are the users given SSH authorized keys.
+	SSHUsers []string `json:"ssh_users,omitempty"`
+}
+
+// Signing describes how the release artifacts were signed.
+type Signing struct {
+	Backend        string `json:"backend"`
+	KeyFingerprint string `json:"key_fingerprint"`
+}
+
+// FlavorName returns the name of a kernel flavor's per arch file with the
+// given prefix: vmlinux-x86_64 for the default flavor and vmlinux-full-x86_64
+// for the "full" flavor.
+func FlavorName(prefix, flavor, arch string) string {
+	if flavor == "" || flavor == DefaultFlavor {
+		return prefix + "-" + arch
+	}
+	return prefix + "-" + flavor + "-" + arch
+}
+
+// Kernels returns the default kernel followed by the kernel flavors, to
+// iterate or update them in place.
+func (a *ArchArtifacts) Kernels() []*KernelArtifact {
+	kernels := []*KernelArtifact{&a.Kernel}
+	for i := range a.KernelFlavors {
+		kernels = append(kernels, &a.KernelFlavors[i])
+	}
+	return kernels
+}
+
+// KernelFlavor returns the kernel of the named flavor, "" and DefaultFlavor
+// select the default kernel.
+func (a ArchArtifacts) KernelFlavor(name string) (KernelArtifact, bool) {
+	if name == "" || name == DefaultFlavor {
+		return a.Kernel, true
+	}
+	for _, k := range a.KernelFlavors {
+		if k.Flavor == name {
+			return k, true
+		}
+	}
+	return KernelArtifact{}, false
+}
+
+// Rootfses returns the default rootfs image followed by the rootfs profile
+// images, to iterate or update them in place.
+func (a *ArchArtifacts) Rootfses() []*RootfsArtifact {
+	rootfses := []*RootfsArtifact{&a.Rootfs}
+	for i := range a.RootfsProfiles {
+		rootfses = append(rootfses, &a.RootfsProfiles[i])
+	}
+	return rootfses
+}
+
+// RootfsProfile returns the rootfs image of the named profile, "" selects
+// the default image.
+func (a ArchArtifacts) RootfsProfile(name string) (RootfsArtifact, bool) {
+	if name == "" || name == a.Rootfs.Profile {
+		return a.Rootfs, true
+	}
+	for _, r := range a.RootfsProfiles {
+		if r.Profile == name {
+			return r, true
+		}
+	}
+	return RootfsArtifact{}, false
+}
+
+// DiskArtifact is an empty pre-formatted data disk, attached next to the
+// rootfs (e.g. a scratch disk mounted at MountPoint).
+type DiskArtifact struct {
+	Name       string `json:"name"`
+	File       string `json:"file"`
+	Filesystem string `json:"filesystem"`
+	Label      string `json:"label"`
+	MountPoint string `json:"mount_point,omitempty"`
+	// MkfsOptions are the options the filesystem was created with.
+	MkfsOptions string `json:"mkfs_options,omitempty"`
+	// SizeBytes and DiskUsageBytes are the apparent and allocated sizes of
+	// the sparse disk.
+	SizeBytes      int64  `json:"size_bytes"`
+	DiskUsageBytes int64  `json:"disk_usage_bytes,omitempty"`
+	SHA256         string `json:"sha256"`
+	Signature      string `json:"signature,omitempty"`
+	Provenance     string `json:"provenance,omitempty"`
+}
+
+// DiskFile returns the name of the data disk file: disk-<name>.<filesystem>.
+func DiskFile(name, filesystem string) string {
+	return fmt.Sprintf("disk-%s.%s", name, filesystem)
+}
+
+// Read loads a manifest from a manifest.json file.
+func Read(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return m, nil
+}
+
+// Files returns the names of every release file the manifest references,
+// sorted and without duplicates. manifest.json itself is not included.
+func (m Manifest) Files() []string {
+	return collectNames(func(add func(names ...string)) {
+		for _, a := range m.Artifacts {
+			a.addFiles(add)
+		}
+		for _, d := range m.Disks {
+			add(d.File, d.Signature, d.Provenance)
+		}
+		add(m.Build.Attestations...)
+	})
+}
+
+// Files returns the names of the release files of the architecture, sorted
+// and without duplicates: the files of Manifest.Files but the disks and
+// attestations.
+func (a ArchArtifacts) Files() []string {
+	return collectNames(a.addFiles)
+}
+
+func (a *ArchArtifacts) addFiles(add func(names ...string)) {
+	var postProcess []*PostProcess
+	for _, k := range a.Kernels() {
+		add(k.File, k.Signature, k.Provenance, k.Config)
+		for _, img := range k.Images {
+			add(img.File, img.Signature, img.Provenance)
+		}
+		if k.Modules.Shipped() {
+			add(k.Modules.File, k.Modules.Signature)
+		}
+		postProcess = append(postProcess, k.PostProcess)
+	}
+	for _, r := range a.Rootfses() {
+		add(r.File, r.Signature, r.Provenance, r.SBOM, r.Vulnerabilities)
+		for _, img := range r.ExtraImages() {
+			add(img.File, img.Signature, img.Provenance)
+		}
+		for _, f := range r.SBOMs {
+			add(f)
+		}
+		postProcess = append(postProcess, r.PostProcess)
+	}
+	if a.Initramfs != nil {
+		add(a.Initramfs.File, a.Initramfs.Signature, a.Initramfs.Provenance)
+	}
+	for _, b := range a.Firecracker.Binaries() {
+		add(b.File, b.Signature, b.Provenance)
+	}
+	for _, pp := range postProcess {
+		if pp == nil {
+			continue
+		}
+		for _, f := range pp.Files {
+			add(f.File, f.Signature)
+		}
+	}
+}
+
+// collectNames returns the non-empty names added by collect, sorted and
+// without duplicates.
+func collectNames(collect func(add func(names ...string))) []string {
+	seen := map[string]bool{}
+	collect(func(names ...string) {
+		for _, name := range names {
+			if name != "" {
+				seen[name] = true
+			}
+		}
+	})
+
+	files := make([]string, 0, len(seen))
+	for name := range seen {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Write stores the manifest as indented JSON at path.
+func Write(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling manifest: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	return nil
+}
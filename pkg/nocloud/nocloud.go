@@ -0,0 +1,121 @@
+// Package nocloud builds cloud-init NoCloud seed images: a small vfat or
+// ISO 9660 filesystem labelled cidata holding the user-data, meta-data and
+// optional network-config files, attached to a sandbox as a read-only drive.
+package nocloud
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Label is the filesystem label cloud-init looks for.
+const Label = "cidata"
+
+// Seed image formats.
+const (
+	FormatVFAT = "vfat"
+	FormatISO  = "iso"
+)
+
+// Formats are the supported seed image formats.
+var Formats = []string{FormatVFAT, FormatISO}
+
+// Seed is the content of a seed image.
+type Seed struct {
+	UserData []byte
+	MetaData []byte
+	// NetworkConfig is the optional network-config file.
+	NetworkConfig []byte
+}
+
+// MetaData renders a meta-data document with the instance ID and the
+// optional hostname.
+func MetaData(instanceID, hostname string) []byte {
+	data := fmt.Sprintf("instance-id: %s\n", instanceID)
+	if hostname != "" {
+		data += fmt.Sprintf("local-hostname: %s\n", hostname)
+	}
+	return []byte(data)
+}
+
+// Build writes s as a seed image in format to out, with the upstream tools
+// (mkfs.vfat and mcopy for vfat, genisoimage, mkisofs or xorriso for ISO).
+func Build(ctx context.Context, s Seed, format, out string) error {
+	dir, err := os.MkdirTemp("", "sbx-nocloud-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{"user-data": s.UserData, "meta-data": s.MetaData}
+	if s.NetworkConfig != nil {
+		files["network-config"] = s.NetworkConfig
+	}
+	var (
+		paths []string
+		size  int
+	)
+	for _, name := range []string{"user-data", "meta-data", "network-config"} {
+		data, ok := files[name]
+		if !ok {
+			continue
+		}
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		size += len(data)
+	}
+
+	if err := os.Remove(out); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	switch format {
+	case FormatVFAT:
+		return buildVFAT(ctx, paths, size, out)
+	case FormatISO:
+		return buildISO(ctx, paths, out)
+	default:
+		return fmt.Errorf("unknown seed format %q", format)
+	}
+}
+
+// buildVFAT formats a FAT image with room for the files and copies them in.
+func buildVFAT(ctx context.Context, paths []string, size int, out string) error {
+	// 1 MiB covers the FAT metadata, sizes are in KiB.
+	kib := 1024 + (size+1023)/1024
+	if err := run(ctx, "mkfs.vfat", "-n", Label, "-C", out, fmt.Sprint(kib)); err != nil {
+		return err
+	}
+	args := append([]string{"-i", out}, paths...)
+	return run(ctx, "mcopy", append(args, "::")...)
+}
+
+// buildISO writes a Joliet and Rock Ridge ISO 9660 image with the first
+// ISO tool found.
+func buildISO(ctx context.Context, paths []string, out string) error {
+	args := append([]string{"-output", out, "-volid", Label, "-joliet", "-rock", "-quiet"}, paths...)
+	for _, tool := range []string{"genisoimage", "mkisofs"} {
+		if _, err := exec.LookPath(tool); err == nil {
+			return run(ctx, tool, args...)
+		}
+	}
+	return run(ctx, "xorriso", append([]string{"-as", "mkisofs"}, args...)...)
+}
+
+func run(ctx context.Context, tool string, args ...string) error {
+	if _, err := exec.LookPath(tool); err != nil {
+		return fmt.Errorf("%s is required: %w", tool, err)
+	}
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", tool, err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package ociartifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// AnnotationRefName is the OCI image layout annotation naming the images of
+// an archive.
+const AnnotationRefName = "org.opencontainers.image.ref.name"
+
+// WriteArchive writes rel as an OCI image layout tar archive (oci-archive) to
+// w, setting its digests: the release index named after the release tag and
+// the artifacts after theirs.
+func WriteArchive(rel *Release, w io.Writer) error {
+	enc, err := encode(rel)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	add := func(name string, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, Typeflag: tar.TypeReg, Format: tar.FormatPAX}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	}
+	addData := func(name string, data []byte) error {
+		return add(name, int64(len(data)), bytes.NewReader(data))
+	}
+	written := map[string]bool{}
+	addBlob := func(digest string, size int64, open func() (io.ReadCloser, error)) error {
+		if written[digest] {
+			return nil
+		}
+		written[digest] = true
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return add("blobs/"+strings.Replace(digest, ":", "/", 1), size, r)
+	}
+	addBlobData := func(data []byte) (v1.Hash, error) {
+		digest, _, err := v1.SHA256(bytes.NewReader(data))
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		return digest, addBlob(digest.String(), int64(len(data)), func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		})
+	}
+
+	if err := addData("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return err
+	}
+	if _, err := addBlobData(configData); err != nil {
+		return err
+	}
+	layout := v1.IndexManifest{SchemaVersion: 2, MediaType: types.OCIImageIndex}
+	for i, art := range rel.Artifacts {
+		for _, f := range art.Files {
+			if err := addBlob(f.Digest, f.Size, fileLayer{f}.Compressed); err != nil {
+				return fmt.Errorf("archiving %s: %w", f.Name, err)
+			}
+		}
+		digest, err := addBlobData(enc.manifests[i])
+		if err != nil {
+			return err
+		}
+		layout.Manifests = append(layout.Manifests, v1.Descriptor{
+			MediaType:    types.OCIManifestSchema1,
+			Size:         int64(len(enc.manifests[i])),
+			Digest:       digest,
+			ArtifactType: ArtifactType,
+			Annotations:  map[string]string{AnnotationRefName: art.Tag, AnnotationArch: art.Arch},
+		})
+	}
+	digest, err := addBlobData(enc.index)
+	if err != nil {
+		return err
+	}
+	layout.Manifests = append(layout.Manifests, v1.Descriptor{
+		MediaType:   types.OCIImageIndex,
+		Size:        int64(len(enc.index)),
+		Digest:      digest,
+		Annotations: map[string]string{AnnotationRefName: rel.Tag},
+	})
+
+	data, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+	if err := addData("index.json", data); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ContainerdOptions configures ImportContainerd.
+type ContainerdOptions struct {
+	// Address is the containerd socket (default: the ctr default).
+	Address string
+	// Namespace is the containerd namespace.
+	Namespace string
+	// Name is the image name the release and artifact tags are appended
+	// to, e.g. localhost/sbx-images.
+	Name string
+}
+
+// ImportContainerd imports rel into the content store of a local containerd
+// with ctr, as images named <name>:<tag> (the release index) and
+// <name>:<tag>-<arch> (the artifacts), setting its digests. The images are
+// the garbage collection roots of the release content, labeled with the
+// release annotations. The layers are raw files, not unpacked into
+// snapshots.
+func ImportContainerd(ctx context.Context, rel *Release, opts ContainerdOptions) error {
+	if _, err := exec.LookPath("ctr"); err != nil {
+		return fmt.Errorf("ctr is required: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(WriteArchive(rel, pw)) }()
+	args := []string{"images", "import", "--base-name", opts.Name, "--all-platforms", "--no-unpack", "-"}
+	if err := ctr(ctx, opts, pr, args...); err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("importing release: %w", err)
+	}
+
+	images := []string{opts.Name + ":" + rel.Tag}
+	for _, art := range rel.Artifacts {
+		images = append(images, opts.Name+":"+art.Tag)
+	}
+	for _, img := range images {
+		args := []string{"images", "label", img}
+		for _, k := range slices.Sorted(maps.Keys(rel.Annotations)) {
+			args = append(args, k+"="+rel.Annotations[k])
+		}
+		if err := ctr(ctx, opts, nil, args...); err != nil {
+			return fmt.Errorf("labeling %s: %w", img, err)
+		}
+	}
+	return nil
+}
+
+func ctr(ctx context.Context, opts ContainerdOptions, stdin io.Reader, args ...string) error {
+	var global []string
+	if opts.Address != "" {
+		global = append(global, "--address", opts.Address)
+	}
+	if opts.Namespace != "" {
+		global = append(global, "--namespace", opts.Namespace)
+	}
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, "ctr", append(global, args...)...)
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running ctr %s: %w: %s", strings.Join(args[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
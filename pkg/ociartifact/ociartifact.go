@@ -0,0 +1,343 @@
+// Package ociartifact distributes releases as OCI artifacts, pushed to and
+// pulled from any OCI registry the way ORAS does, for registry-native
+// distribution and garbage collection.
+//
+// A release is an OCI image index tagged with the release version, with an
+// artifact per architecture (an OCI image manifest tagged <version>-<arch>).
+// Every artifact holds manifest.json, the files of its architecture and the
+// architecture independent files (disks, attestations, status document) as
+// layers: the raw files, with a media type per kind and their name in the
+// org.opencontainers.image.title annotation, so `oras pull` writes them back
+// as files.
+package ociartifact
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/progress"
+	"github.com/slok/sbx-images/pkg/publish"
+	"github.com/slok/sbx-images/pkg/rootfs/oci"
+)
+
+// ArtifactType is the artifact type of the release artifacts, the media type
+// of their (empty) config.
+const ArtifactType = "application/vnd.sbx-images.release.v1+json"
+
+// Layer media types.
+const (
+	MediaTypeManifest  = "application/vnd.sbx-images.manifest.v1+json"
+	MediaTypeKernel    = "application/vnd.sbx-images.kernel.v1"
+	MediaTypeRootfs    = "application/vnd.sbx-images.rootfs.v1"
+	MediaTypeInitramfs = "application/vnd.sbx-images.initramfs.v1"
+	MediaTypeBinary    = "application/vnd.sbx-images.binary.v1"
+	MediaTypeDisk      = "application/vnd.sbx-images.disk.v1"
+	// MediaTypeFile is the media type of the other files: signatures,
+	// provenance, SBOMs...
+	MediaTypeFile = "application/vnd.sbx-images.file.v1"
+)
+
+// Annotations.
+const (
+	AnnotationTitle    = "org.opencontainers.image.title"
+	AnnotationVersion  = "org.opencontainers.image.version"
+	AnnotationCreated  = "org.opencontainers.image.created"
+	AnnotationRevision = "org.opencontainers.image.revision"
+	// AnnotationArch is the build architecture name (x86_64, aarch64,
+	// riscv64) of an artifact.
+	AnnotationArch = "io.github.slok.sbx-images.arch"
+)
+
+// File is a release file, a layer of the artifacts.
+type File struct {
+	Name      string `json:"name"`
+	Path      string `json:"-"`
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+	// Digest is set once pushed.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Artifact is the artifact of an architecture.
+type Artifact struct {
+	Arch  string `json:"arch"`
+	Tag   string `json:"tag"`
+	Files []File `json:"files"`
+	// Digest is the artifact manifest digest, set once pushed.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Release is the artifacts of a release, referenced by the index tagged Tag.
+type Release struct {
+	Tag         string            `json:"tag"`
+	Annotations map[string]string `json:"annotations"`
+	Artifacts   []Artifact        `json:"artifacts"`
+	// Digest is the index digest, set once pushed.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Plan returns the release artifacts of the build dir files of m, the
+// release files of publish.ReleaseFiles.
+func Plan(tag string, m manifest.Manifest, manifestPath, buildDir string) (Release, error) {
+	files, err := publish.ReleaseFiles(tag, m, manifestPath, buildDir)
+	if err != nil {
+		return Release{}, err
+	}
+
+	rel := Release{Tag: tag, Annotations: map[string]string{AnnotationVersion: m.Version}}
+	if m.Build.Date != "" {
+		rel.Annotations[AnnotationCreated] = m.Build.Date
+	}
+	if m.Build.Commit != "" {
+		rel.Annotations[AnnotationRevision] = m.Build.Commit
+	}
+
+	// The files of no architecture go in every artifact.
+	archOf := map[string]string{}
+	for arch, a := range m.Artifacts {
+		for _, f := range a.Files() {
+			archOf[f] = arch
+		}
+	}
+	for _, arch := range manifest.Architectures {
+		a, ok := m.Artifacts[arch]
+		if !ok {
+			continue
+		}
+		kinds := mediaTypes(a)
+		for _, d := range m.Disks {
+			kinds[d.File] = MediaTypeDisk
+		}
+		art := Artifact{Arch: arch, Tag: tag + "-" + arch}
+		for _, asset := range files.Assets {
+			if owner, ok := archOf[asset.Name]; ok && owner != arch {
+				continue
+			}
+			art.Files = append(art.Files, File{
+				Name:      asset.Name,
+				Path:      asset.Path,
+				MediaType: cmp.Or(kinds[asset.Name], MediaTypeFile),
+				Size:      asset.Size,
+			})
+		}
+		rel.Artifacts = append(rel.Artifacts, art)
+	}
+	if len(rel.Artifacts) == 0 {
+		return Release{}, fmt.Errorf("the manifest has no supported architecture")
+	}
+	return rel, nil
+}
+
+// mediaTypes returns the layer media types of the architecture files but
+// MediaTypeFile ones, by name.
+func mediaTypes(a manifest.ArchArtifacts) map[string]string {
+	kinds := map[string]string{"manifest.json": MediaTypeManifest}
+	for _, k := range a.Kernels() {
+		kinds[k.File] = MediaTypeKernel
+		for _, img := range k.Images {
+			kinds[img.File] = MediaTypeKernel
+		}
+	}
+	for _, r := range a.Rootfses() {
+		kinds[r.File] = MediaTypeRootfs
+		for _, img := range r.ExtraImages() {
+			kinds[img.File] = MediaTypeRootfs
+		}
+	}
+	if a.Initramfs != nil {
+		kinds[a.Initramfs.File] = MediaTypeInitramfs
+	}
+	for _, b := range a.Firecracker.Binaries() {
+		kinds[b.File] = MediaTypeBinary
+	}
+	return kinds
+}
+
+// configData is the empty config of the artifacts.
+var configData = []byte("{}")
+
+// encoded is a release encoded as OCI manifests.
+type encoded struct {
+	// manifests are the artifact manifests, in Release.Artifacts order.
+	manifests [][]byte
+	index     []byte
+}
+
+// encode hashes the files of rel and encodes its artifact manifests and
+// index, setting their digests.
+func encode(rel *Release) (encoded, error) {
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(configData))
+	if err != nil {
+		return encoded{}, err
+	}
+	config := v1.Descriptor{MediaType: ArtifactType, Size: configSize, Digest: configDigest}
+
+	var enc encoded
+	index := v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Annotations:   rel.Annotations,
+	}
+	// The files shared by the artifacts are hashed once.
+	digests := map[string]string{}
+	for i := range rel.Artifacts {
+		art := &rel.Artifacts[i]
+		m := v1.Manifest{
+			SchemaVersion: 2,
+			MediaType:     types.OCIManifestSchema1,
+			Config:        config,
+			Annotations:   artifactAnnotations(rel.Annotations, art.Arch),
+		}
+		for j := range art.Files {
+			f := &art.Files[j]
+			if digests[f.Path] == "" {
+				_, sum, err := digest.File(f.Path)
+				if err != nil {
+					return encoded{}, err
+				}
+				digests[f.Path] = "sha256:" + sum
+			}
+			f.Digest = digests[f.Path]
+			digest, err := v1.NewHash(f.Digest)
+			if err != nil {
+				return encoded{}, err
+			}
+			m.Layers = append(m.Layers, v1.Descriptor{
+				MediaType:   types.MediaType(f.MediaType),
+				Size:        f.Size,
+				Digest:      digest,
+				Annotations: map[string]string{AnnotationTitle: f.Name},
+			})
+		}
+
+		data, desc, err := marshal(m, m.MediaType)
+		if err != nil {
+			return encoded{}, err
+		}
+		art.Digest = desc.Digest.String()
+		platform, err := oci.Platform(art.Arch)
+		if err != nil {
+			return encoded{}, err
+		}
+		desc.Platform = &platform
+		desc.ArtifactType = ArtifactType
+		desc.Annotations = map[string]string{AnnotationArch: art.Arch}
+		index.Manifests = append(index.Manifests, desc)
+		enc.manifests = append(enc.manifests, data)
+	}
+
+	data, desc, err := marshal(index, index.MediaType)
+	if err != nil {
+		return encoded{}, err
+	}
+	rel.Digest = desc.Digest.String()
+	enc.index = data
+	return enc, nil
+}
+
+func artifactAnnotations(release map[string]string, arch string) map[string]string {
+	anns := map[string]string{AnnotationArch: arch}
+	for k, v := range release {
+		anns[k] = v
+	}
+	return anns
+}
+
+// marshal encodes the manifest or index v, returning its descriptor.
+func marshal(v any, mediaType types.MediaType) ([]byte, v1.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+	digest, size, err := v1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+	return data, v1.Descriptor{MediaType: mediaType, Size: size, Digest: digest}, nil
+}
+
+// Push uploads rel to repo: the layers missing in the registry, the
+// artifacts and the index, setting their digests.
+func Push(ctx context.Context, repo name.Repository, rel *Release, opts ...remote.Option) error {
+	enc, err := encode(rel)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, remote.WithContext(ctx))
+
+	if err := remote.WriteLayer(repo, static.NewLayer(configData, ArtifactType), opts...); err != nil {
+		return fmt.Errorf("pushing config: %w", err)
+	}
+	for i, art := range rel.Artifacts {
+		for _, f := range art.Files {
+			if err := remote.WriteLayer(repo, fileLayer{f}, opts...); err != nil {
+				return fmt.Errorf("pushing %s: %w", f.Name, err)
+			}
+		}
+		raw := rawManifest{data: enc.manifests[i], mediaType: types.OCIManifestSchema1}
+		if err := remote.Put(repo.Tag(art.Tag), raw, opts...); err != nil {
+			return fmt.Errorf("pushing %s artifact: %w", art.Arch, err)
+		}
+	}
+	raw := rawManifest{data: enc.index, mediaType: types.OCIImageIndex}
+	if err := remote.Put(repo.Tag(rel.Tag), raw, opts...); err != nil {
+		return fmt.Errorf("pushing index: %w", err)
+	}
+	return nil
+}
+
+// rawManifest is a manifest or index to upload as is.
+type rawManifest struct {
+	data      []byte
+	mediaType types.MediaType
+}
+
+func (m rawManifest) RawManifest() ([]byte, error)        { return m.data, nil }
+func (m rawManifest) MediaType() (types.MediaType, error) { return m.mediaType, nil }
+
+// fileLayer is a hashed release file uploaded as is, without the memory copy
+// of the static layers, with upload progress.
+type fileLayer struct {
+	f File
+}
+
+var _ v1.Layer = fileLayer{}
+
+func (l fileLayer) Digest() (v1.Hash, error)             { return v1.NewHash(l.f.Digest) }
+func (l fileLayer) DiffID() (v1.Hash, error)             { return l.Digest() }
+func (l fileLayer) Size() (int64, error)                 { return l.f.Size, nil }
+func (l fileLayer) MediaType() (types.MediaType, error)  { return types.MediaType(l.f.MediaType), nil }
+func (l fileLayer) Uncompressed() (io.ReadCloser, error) { return l.Compressed() }
+
+func (l fileLayer) Compressed() (io.ReadCloser, error) {
+	f, err := os.Open(l.f.Path)
+	if err != nil {
+		return nil, err
+	}
+	p := progress.New("uploading "+l.f.Name, l.f.Size)
+	return progressReadCloser{Reader: p.Reader(f), f: f, p: p}, nil
+}
+
+type progressReadCloser struct {
+	io.Reader
+	f *os.File
+	p *progress.Reporter
+}
+
+func (r progressReadCloser) Close() error {
+	r.p.Done()
+	return r.f.Close()
+}
@@ -0,0 +1,173 @@
+package ociartifact
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/progress"
+	"github.com/slok/sbx-images/pkg/signer"
+)
+
+// PullOptions configures Pull.
+type PullOptions struct {
+	// Arch is the architecture artifact pulled from a release index.
+	Arch string
+	// Cosign verifies the cosign signature of the artifact before pulling
+	// it, when set.
+	Cosign *signer.CosignOptions
+	// Remote are the registry options (e.g. the credentials).
+	Remote []remote.Option
+}
+
+// Pulled is a pulled artifact.
+type Pulled struct {
+	// Ref is the artifact reference, by digest.
+	Ref   string `json:"ref"`
+	Arch  string `json:"arch"`
+	Files []File `json:"files"`
+}
+
+// Pull resolves ref (a tag or digest of a release index or of an
+// architecture artifact) to the artifact of opts.Arch and writes its files to
+// dir, the build directory layout: manifest.json and the release files by
+// name. Every layer is verified against its digest, the files already in dir
+// with the layer digest are not downloaded again.
+func Pull(ctx context.Context, ref name.Reference, dir string, opts PullOptions) (Pulled, error) {
+	ropts := append(slices.Clone(opts.Remote), remote.WithContext(ctx))
+	digest, m, err := resolve(ref, opts.Arch, ropts)
+	if err != nil {
+		return Pulled{}, err
+	}
+	if m.Config.MediaType != ArtifactType {
+		return Pulled{}, fmt.Errorf("%s is not a release artifact (artifact type %s)", ref, m.Config.MediaType)
+	}
+	if opts.Arch != "" && m.Annotations[AnnotationArch] != opts.Arch {
+		return Pulled{}, fmt.Errorf("%s is the %s artifact, not %s", ref, m.Annotations[AnnotationArch], opts.Arch)
+	}
+
+	pulled := Pulled{Ref: digest.String(), Arch: m.Annotations[AnnotationArch]}
+	if opts.Cosign != nil {
+		if err := signer.VerifyCosign(ctx, pulled.Ref, *opts.Cosign); err != nil {
+			return Pulled{}, exitcode.Wrap(exitcode.DigestMismatch, err)
+		}
+		slog.Info("Verified cosign signature", "ref", pulled.Ref)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Pulled{}, err
+	}
+	for _, l := range m.Layers {
+		f := File{Name: l.Annotations[AnnotationTitle], MediaType: string(l.MediaType), Size: l.Size, Digest: l.Digest.String()}
+		// The names come from the registry, they must stay in dir.
+		if f.Name == "" || f.Name != filepath.Base(f.Name) || strings.HasPrefix(f.Name, ".") {
+			return Pulled{}, fmt.Errorf("layer %s has an invalid file name %q", l.Digest, f.Name)
+		}
+		f.Path = filepath.Join(dir, f.Name)
+		if err := pullLayer(digest.Context().Digest(l.Digest.String()), f, ropts); err != nil {
+			return Pulled{}, fmt.Errorf("pulling %s: %w", f.Name, err)
+		}
+		pulled.Files = append(pulled.Files, f)
+	}
+	return pulled, nil
+}
+
+// resolve returns the artifact manifest of ref, the arch one of an index.
+func resolve(ref name.Reference, arch string, opts []remote.Option) (name.Digest, v1.Manifest, error) {
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return name.Digest{}, v1.Manifest{}, err
+	}
+	repo := ref.Context()
+	if desc.MediaType == types.OCIImageIndex {
+		idx, err := v1.ParseIndexManifest(bytes.NewReader(desc.Manifest))
+		if err != nil {
+			return name.Digest{}, v1.Manifest{}, err
+		}
+		if arch == "" {
+			return name.Digest{}, v1.Manifest{}, fmt.Errorf("%s is a release index, an architecture is required", ref)
+		}
+		var found *v1.Descriptor
+		for i, d := range idx.Manifests {
+			if d.Annotations[AnnotationArch] == arch {
+				found = &idx.Manifests[i]
+			}
+		}
+		if found == nil {
+			return name.Digest{}, v1.Manifest{}, exitcode.Wrap(exitcode.MissingArtifact, fmt.Errorf("%s has no %s artifact", ref, arch))
+		}
+		if desc, err = remote.Get(repo.Digest(found.Digest.String()), opts...); err != nil {
+			return name.Digest{}, v1.Manifest{}, err
+		}
+	}
+	if desc.MediaType != types.OCIManifestSchema1 {
+		return name.Digest{}, v1.Manifest{}, fmt.Errorf("%s is a %s, not an OCI artifact", ref, desc.MediaType)
+	}
+	m, err := v1.ParseManifest(bytes.NewReader(desc.Manifest))
+	if err != nil {
+		return name.Digest{}, v1.Manifest{}, err
+	}
+	return repo.Digest(desc.Digest.String()), *m, nil
+}
+
+// pullLayer downloads the layer blob to f.Path, through a temporary file
+// renamed once verified.
+func pullLayer(ref name.Digest, f File, opts []remote.Option) error {
+	if _, sum, err := digest.File(f.Path); err == nil && "sha256:"+sum == f.Digest {
+		slog.Info("File up to date", "file", f.Name)
+		return nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	l, err := remote.Layer(ref, opts...)
+	if err != nil {
+		return err
+	}
+	rc, err := l.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), "."+f.Name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	p := progress.New("downloading "+f.Name, f.Size)
+	defer p.Done()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), p.Reader(rc))
+	if err != nil {
+		return err
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != f.Digest || n != f.Size {
+		return exitcode.Wrap(exitcode.DigestMismatch, fmt.Errorf("layer digest mismatch (expected %s of %d bytes, got %s of %d bytes)", f.Digest, f.Size, got, n))
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
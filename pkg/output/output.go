@@ -0,0 +1,62 @@
+// Package output handles the -o flag of the commands with structured
+// results (verify, inspect, scan, publish, repro-check, doctor, usage...).
+//
+// The text format is the readable output of each command; the json one is
+// a single JSON document on stdout, with the logs staying on stderr, so the
+// commands compose in pipelines and CI gates:
+//
+//	go run ./cmd/verify -o json | jq -e '.failed == 0'
+//
+// A failing command still writes its JSON document before exiting with a
+// non-zero status.
+package output
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Formats are the supported output formats.
+var Formats = []string{FormatText, FormatJSON}
+
+// Flags are the output flags of a command.
+type Flags struct {
+	Format string
+}
+
+// AddFlags registers -o on fs, defaulting to the SBX_OUTPUT environment
+// variable, then text.
+func AddFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Format, "o", cmp.Or(os.Getenv("SBX_OUTPUT"), FormatText), "Output format: text or json")
+	return f
+}
+
+// Validate checks the flags hold a supported format.
+func (f *Flags) Validate() error {
+	if !slices.Contains(Formats, f.Format) {
+		return fmt.Errorf("-o: unknown format %q (supported: %s)", f.Format, strings.Join(Formats, ", "))
+	}
+	return nil
+}
+
+// JSON returns whether the output is JSON.
+func (f *Flags) JSON() bool { return f.Format == FormatJSON }
+
+// Print writes v as an indented JSON document to stdout.
+func Print(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,322 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/progress"
+)
+
+// Cache makes the attested steps incremental: a step whose inputs (command,
+// config section, materials and SOURCE_DATE_EPOCH) hash to the key of its
+// stamp file and whose products and attestation are still there is not run
+// again. With a Remote the step products are also restored from, and
+// in read-write mode stored to, a cache shared between builders.
+type Cache struct {
+	// Dir is the directory of the stamp files, <step>.stamp.json.
+	Dir string
+	// BuildDir is the build directory, the only one the stamped products
+	// and attestations are restored to.
+	BuildDir string
+	// ConfigFiles are the configuration file and its includes, left out of
+	// the key of the steps keyed on their config section.
+	ConfigFiles []string
+	// Remote is the shared cache, nil for none.
+	Remote Remote
+	// ReadWrite stores the step runs to Remote, which is otherwise only
+	// read.
+	ReadWrite bool
+}
+
+// ErrNotFound is returned by Remote.Get for missing entries.
+var ErrNotFound = errors.New("not found")
+
+// Remote is a cache shared between builders (e.g. CI runners), storing the
+// stamp (stamp.json), products and attestation files of the step runs by
+// step name and inputs key.
+type Remote interface {
+	// Get writes the file name of the step run with key to w.
+	Get(ctx context.Context, step, key, name string, w io.Writer) error
+	// Put stores the size bytes of r as the file name of the step run
+	// with key.
+	Put(ctx context.Context, step, key, name string, r io.Reader, size int64) error
+}
+
+// remoteStamp is the Remote file name of the stamps.
+const remoteStamp = "stamp.json"
+
+// Stamp records the inputs key, the products and the attestation of a step
+// run.
+type Stamp struct {
+	Step        string        `json:"step"`
+	Key         string        `json:"key"`
+	Products    []StampedFile `json:"products"`
+	Attestation StampedFile   `json:"attestation"`
+}
+
+// StampedFile is a step product or attestation.
+type StampedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (st Stamp) files() []StampedFile { return append(slices.Clone(st.Products), st.Attestation) }
+
+// Key returns the inputs key of s, its config section standing for the
+// configuration files when it has one.
+func (c *Cache) Key(s Step) (string, error) {
+	files, err := dirFiles(s.MaterialDirs)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "step %s\n", s.Name)
+	command, _ := json.Marshal(s.Command)
+	fmt.Fprintf(h, "command %s\n", command)
+	if s.Config != nil {
+		config, err := json.Marshal(s.Config)
+		if err != nil {
+			return "", fmt.Errorf("hashing config section: %w", err)
+		}
+		fmt.Fprintf(h, "config %s\n", config)
+	}
+	fmt.Fprintf(h, "epoch %s\n", os.Getenv("SOURCE_DATE_EPOCH"))
+	for _, m := range slices.Concat(s.Materials, files) {
+		if s.Config != nil && slices.Contains(c.ConfigFiles, m) {
+			continue
+		}
+		_, sum, err := digest.File(m)
+		if err != nil {
+			return "", fmt.Errorf("hashing material: %w", err)
+		}
+		fmt.Fprintf(h, "material %s %s\n", m, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Hit reports whether the stamp of s has key and its products and
+// attestation exist with their recorded sizes.
+func (c *Cache) Hit(s Step, key, attestation string) bool {
+	st, err := c.read(s.Name)
+	if err != nil || c.check(st, s, key, attestation) != nil {
+		return false
+	}
+	for _, f := range st.files() {
+		if fi, err := os.Stat(f.Path); err != nil || fi.Size() != f.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// check checks the stamp st is the run of s with key attested in the
+// attestation file: the paths of a stamp, possibly read from a shared
+// Remote, are only trusted when they are the ones of the local step, in the
+// build directory.
+func (c *Cache) check(st Stamp, s Step, key, attestation string) error {
+	if st.Step != s.Name || st.Key != key || len(st.Products) != len(s.Products) {
+		return fmt.Errorf("stamp is not the run of step %s with key %s", s.Name, key)
+	}
+	for i, p := range st.Products {
+		if p.Path != s.Products[i] {
+			return fmt.Errorf("stamp product %q is not the step product %q", p.Path, s.Products[i])
+		}
+	}
+	if st.Attestation.Path != attestation {
+		return fmt.Errorf("stamp attestation %q is not the step attestation %q", st.Attestation.Path, attestation)
+	}
+	for _, f := range st.files() {
+		if !inDir(c.BuildDir, f.Path) {
+			return fmt.Errorf("stamp file %q is outside the build directory %s", f.Path, c.BuildDir)
+		}
+	}
+	return nil
+}
+
+// inDir reports whether path is in dir, lexically.
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != "." && filepath.IsLocal(rel)
+}
+
+// Store writes the stamp of s, run with the inputs key and attested in the
+// attestation file.
+func (c *Cache) Store(s Step, key, attestation string) error {
+	st := Stamp{Step: s.Name, Key: key}
+	for _, p := range s.Products {
+		f, err := stampFile(p)
+		if err != nil {
+			return err
+		}
+		st.Products = append(st.Products, f)
+	}
+	var err error
+	if st.Attestation, err = stampFile(attestation); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling stamp: %w", err)
+	}
+	return c.write(s.Name, append(data, '\n'))
+}
+
+// Push stores the stamped run of step to the Remote.
+func (c *Cache) Push(ctx context.Context, step string) error {
+	data, err := os.ReadFile(c.path(step))
+	if err != nil {
+		return err
+	}
+	var st Stamp
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("parsing %s: %w", c.path(step), err)
+	}
+
+	// The stamp is stored last: the files of a run are only used once it
+	// is complete.
+	for _, f := range st.files() {
+		if err := c.put(ctx, st.Step, st.Key, f.Path); err != nil {
+			return fmt.Errorf("storing %s: %w", f.Path, err)
+		}
+	}
+	if err := c.Remote.Put(ctx, st.Step, st.Key, remoteStamp, bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("storing the stamp: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) put(ctx context.Context, step, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	p := progress.New("uploading "+filepath.Base(path), fi.Size())
+	defer p.Done()
+	return c.Remote.Put(ctx, step, key, filepath.Base(path), p.Reader(f), fi.Size())
+}
+
+// Fetch restores the products and attestation (the attestation file) of s
+// run with key from the Remote, returning ErrNotFound when it has no such
+// run. The remote stamp must name the local paths of the step, and the files
+// are verified against it before replacing the local ones.
+func (c *Cache) Fetch(ctx context.Context, s Step, key, attestation string) error {
+	var buf bytes.Buffer
+	if err := c.Remote.Get(ctx, s.Name, key, remoteStamp, &buf); err != nil {
+		return err
+	}
+	var st Stamp
+	if err := json.Unmarshal(buf.Bytes(), &st); err != nil {
+		return fmt.Errorf("parsing remote stamp: %w", err)
+	}
+	if err := c.check(st, s, key, attestation); err != nil {
+		return fmt.Errorf("remote stamp: %w", err)
+	}
+
+	var fetched []string
+	defer func() {
+		for _, tmp := range fetched {
+			os.Remove(tmp)
+		}
+	}()
+	for _, f := range st.files() {
+		tmp, err := c.fetch(ctx, s.Name, key, f)
+		if tmp != "" {
+			fetched = append(fetched, tmp)
+		}
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", f.Path, err)
+		}
+	}
+	for i, f := range st.files() {
+		if err := os.Rename(fetched[i], f.Path); err != nil {
+			return err
+		}
+	}
+	return c.write(s.Name, buf.Bytes())
+}
+
+// fetch downloads the remote file f next to its path and verifies it,
+// returning the downloaded file.
+func (c *Cache) fetch(ctx context.Context, step, key string, f StampedFile) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), ".fetch-*")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	h := sha256.New()
+	p := progress.New("downloading "+filepath.Base(f.Path), f.Size)
+	defer p.Done()
+	if err := c.Remote.Get(ctx, step, key, filepath.Base(f.Path), p.Writer(io.MultiWriter(tmp, h))); err != nil {
+		return tmp.Name(), err
+	}
+	fi, err := tmp.Stat()
+	if err != nil {
+		return tmp.Name(), err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); fi.Size() != f.Size || sum != f.SHA256 {
+		return tmp.Name(), fmt.Errorf("integrity check failed: got %d bytes sha256:%s, stamp has %d bytes sha256:%s", fi.Size(), sum, f.Size, f.SHA256)
+	}
+	return tmp.Name(), os.Chmod(tmp.Name(), 0o644)
+}
+
+// Invalidate removes the stamp of step, before it runs again.
+func (c *Cache) Invalidate(step string) error {
+	if err := os.Remove(c.path(step)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (c *Cache) write(step string, data []byte) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return fmt.Errorf("creating %s: %w", c.Dir, err)
+	}
+	if err := os.WriteFile(c.path(step), data, 0o644); err != nil {
+		return fmt.Errorf("writing stamp: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) read(step string) (Stamp, error) {
+	data, err := os.ReadFile(c.path(step))
+	if err != nil {
+		return Stamp{}, err
+	}
+	var st Stamp
+	if err := json.Unmarshal(data, &st); err != nil {
+		return Stamp{}, fmt.Errorf("parsing %s: %w", c.path(step), err)
+	}
+	return st, nil
+}
+
+func (c *Cache) path(step string) string { return filepath.Join(c.Dir, step+".stamp.json") }
+
+func stampFile(path string) (StampedFile, error) {
+	size, sum, err := digest.File(path)
+	if err != nil {
+		return StampedFile{}, err
+	}
+	return StampedFile{Path: path, Size: size, SHA256: sum}, nil
+}
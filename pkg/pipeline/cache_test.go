@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memRemote is a Remote keeping the files in memory.
+type memRemote struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (r *memRemote) Get(_ context.Context, step, key, name string, w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.files[step+"/"+key+"/"+name]
+	if !ok {
+		return ErrNotFound
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func (r *memRemote) Put(_ context.Context, step, key, name string, rd io.Reader, _ int64) error {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[step+"/"+key+"/"+name] = data
+	return nil
+}
+
+func TestCacheRemote(t *testing.T) {
+	remote := &memRemote{files: map[string][]byte{}}
+	build := t.TempDir()
+	s := Step{Name: "step", Products: []string{filepath.Join(build, "out")}}
+	attestation := filepath.Join(build, "step.link.json")
+	for path, data := range map[string]string{s.Products[0]: "product", attestation: "{}"} {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &Cache{Dir: filepath.Join(build, ".cache"), BuildDir: build, Remote: remote, ReadWrite: true}
+	if err := c.Store(s, "key", attestation); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Push(t.Context(), s.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another builder restores the run.
+	other := t.TempDir()
+	s2 := Step{Name: "step", Products: []string{filepath.Join(other, "out")}}
+	c2 := &Cache{Dir: filepath.Join(other, ".cache"), BuildDir: other, Remote: remote}
+	// The stamp names the paths of the first builder.
+	if err := c2.Fetch(t.Context(), s2, "key", filepath.Join(other, "step.link.json")); err == nil {
+		t.Error("expected an error for a stamp of other paths")
+	}
+	if err := c.Fetch(t.Context(), s, "key", attestation); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Hit(s, "key", attestation) {
+		t.Error("expected a hit once fetched")
+	}
+	if c.Hit(s, "key", filepath.Join(build, "other.link.json")) {
+		t.Error("expected a miss for another attestation")
+	}
+}
+
+func TestCacheRemoteTamperedStamp(t *testing.T) {
+	build := t.TempDir()
+	s := Step{Name: "step", Products: []string{filepath.Join(build, "out")}}
+	attestation := filepath.Join(build, "step.link.json")
+
+	for _, evil := range []string{
+		filepath.Join(build, "..", "evil"),
+		"/tmp/evil",
+		filepath.Join(build, "other.link.json"),
+	} {
+		st := Stamp{
+			Step:        s.Name,
+			Key:         "key",
+			Products:    []StampedFile{{Path: s.Products[0], Size: 1}},
+			Attestation: StampedFile{Path: evil, Size: 1},
+		}
+		data, _ := json.Marshal(st)
+		remote := &memRemote{files: map[string][]byte{
+			"step/key/" + remoteStamp:         data,
+			"step/key/out":                    []byte("x"),
+			"step/key/" + filepath.Base(evil): []byte("x"),
+		}}
+		c := &Cache{Dir: filepath.Join(build, ".cache"), BuildDir: build, Remote: remote}
+		err := c.Fetch(t.Context(), s, "key", attestation)
+		if err == nil || !strings.Contains(err.Error(), "attestation") {
+			t.Errorf("%s: got %v, want an attestation path error", evil, err)
+		}
+		if _, err := os.Stat(evil); err == nil {
+			t.Errorf("%s was written", evil)
+		}
+	}
+
+	// A step product outside the build directory is never restored.
+	out := Step{Name: "step", Products: []string{filepath.Join(build, "..", "out")}}
+	st := Stamp{Step: out.Name, Key: "key", Products: []StampedFile{{Path: out.Products[0]}}, Attestation: StampedFile{Path: attestation}}
+	c := &Cache{BuildDir: build}
+	if err := c.check(st, out, "key", attestation); err == nil {
+		t.Error("expected an error for a product outside the build directory")
+	}
+}
@@ -0,0 +1,603 @@
+// Package pipeline plans and runs the release build pipeline of config.yaml
+// for cmd/build, which the Makefile build, convert-images, hooks, sbom,
+// manifest and postprocess targets wrap: the build scripts and commands,
+// under their step attestations.
+//
+// Plan returns the steps in pipeline order with their dependencies, Select
+// filters them by kind or name, and Runner.Run runs them, independent steps
+// (architectures, profiles) concurrently, reporting each step's result and
+// stopping at the first failure.
+package pipeline
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/kpatch"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// Step kinds, in pipeline order.
+const (
+	KindKernel      = "kernel"
+	KindRootfs      = "rootfs"
+	KindConvert     = "convert"
+	KindInitramfs   = "initramfs"
+	KindFirecracker = "firecracker"
+	KindDisks       = "disks"
+	KindHooks       = "hooks"
+	KindSBOM        = "sbom"
+	KindManifest    = "manifest"
+	KindPostProcess = "postprocess"
+)
+
+// Kinds are the step kinds, in pipeline order.
+var Kinds = []string{KindKernel, KindRootfs, KindConvert, KindInitramfs, KindFirecracker, KindDisks, KindHooks, KindSBOM, KindManifest, KindPostProcess}
+
+// Step is a pipeline step, a command run from the repository root.
+type Step struct {
+	// Name identifies the step, the attestation step name of attested
+	// steps (e.g. rootfs-build-dev-x86_64).
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Arch is the architecture the step builds for, empty for the
+	// architecture independent steps.
+	Arch    string   `json:"arch,omitempty"`
+	Command []string `json:"command"`
+	// After are the names of the steps that must succeed before this one
+	// runs, when selected.
+	After []string `json:"after,omitempty"`
+	// Config is the config section of the step, keying its cache stamp
+	// instead of the whole configuration file.
+	Config any `json:"-"`
+	// Attested steps run under a step attestation (pkg/attest) of their
+	// Materials, the files under MaterialDirs when the step runs, and
+	// Products.
+	Attested     bool     `json:"attested,omitempty"`
+	Materials    []string `json:"materials,omitempty"`
+	MaterialDirs []string `json:"material_dirs,omitempty"`
+	Products     []string `json:"products,omitempty"`
+}
+
+// Options are the pipeline settings, the Makefile variables.
+type Options struct {
+	ConfigPath  string
+	BuildDir    string
+	ScriptsDir  string
+	FilesDir    string
+	ProfilesDir string
+	// Version and Commit are recorded in the manifest.
+	Version string
+	Commit  string
+	// SBOMFormats are the comma separated cmd/sbom formats.
+	SBOMFormats string
+	// KernelSrc is the kernel tree validating the merged kernel config,
+	// empty to skip the validation.
+	KernelSrc string
+	// ContainerRuntime is the build-kernel.sh --runtime of the kernel
+	// source builds (default: container_runtime).
+	ContainerRuntime string
+	// DebianRuntime and APTProxy are the cmd/rootfs-debian -runtime
+	// (default: container_runtime) and -proxy.
+	DebianRuntime string
+	APTProxy      string
+	// BuildKitCacheFrom and BuildKitCacheTo are the cmd/rootfs-buildkit
+	// -cache-from and -cache-to.
+	BuildKitCacheFrom string
+	BuildKitCacheTo   string
+}
+
+// Plan returns the pipeline steps of cfg, in order.
+func Plan(cfg config.Config, opts Options) ([]Step, error) {
+	p := planner{cfg: cfg, opts: opts}
+	for _, plan := range []func() error{p.kernels, p.rootfses, p.initramfses, p.firecrackers, p.disks, p.release} {
+		if err := plan(); err != nil {
+			return nil, err
+		}
+	}
+	return p.steps, nil
+}
+
+type planner struct {
+	cfg   config.Config
+	opts  Options
+	steps []Step
+	// modules are the kernel steps producing the rootfs modules of each
+	// architecture.
+	modules map[string][]string
+}
+
+func (p *planner) add(s Step) { p.steps = append(p.steps, s) }
+
+// names returns the names of the planned steps.
+func (p *planner) names() []string {
+	names := make([]string, 0, len(p.steps))
+	for _, s := range p.steps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// build returns the path of a build directory file.
+func (p *planner) build(name string) string { return filepath.Join(p.opts.BuildDir, name) }
+
+// materials returns the configuration file and its includes (Options.
+// ConfigPath for a config not read by config.Load) followed by files, the
+// materials of a step.
+func (p *planner) materials(files ...string) []string {
+	if len(p.cfg.Files) == 0 {
+		return append([]string{p.opts.ConfigPath}, files...)
+	}
+	return append(slices.Clone(p.cfg.Files), files...)
+}
+
+// script returns the path of a script.
+func (p *planner) script(name string) string { return filepath.Join(p.opts.ScriptsDir, name) }
+
+// goRun returns the command running a repository command.
+func goRun(cmd string, args ...string) []string {
+	return append([]string{"go", "run", "./cmd/" + cmd}, args...)
+}
+
+// kernels plans the kernel downloads, or the config merge, patch series
+// and source builds of the flavors built from source.
+func (p *planner) kernels() error {
+	p.modules = make(map[string][]string)
+	var fromSource []Step
+	var bases []string
+	for _, f := range p.cfg.KernelFlavors() {
+		for _, arch := range p.cfg.Architectures {
+			f := f.ForArch(arch)
+			stem := strings.TrimPrefix(f.ArtifactName("", arch), "-")
+			vmlinux := p.build("vmlinux-" + stem)
+			var modules []string
+			if f.Modules != "" {
+				modules = []string{p.build("modules-" + stem + ".tar.zst")}
+			}
+			download := []string{
+				p.script("download-kernel.sh"),
+				"--arch", arch,
+				"--flavor", f.Name,
+				"--kernel-version", f.Version,
+				"--ci-version", f.CIVersion,
+			}
+
+			if !f.BuildFromSource {
+				if f.Modules == config.ModulesRootfs {
+					p.modules[arch] = append(p.modules[arch], "kernel-fetch-"+stem)
+				}
+				cmd := append(download, "--output-dir", p.opts.BuildDir)
+				if f.Modules != "" {
+					cmd = append(cmd, "--modules-url", strings.ReplaceAll(f.ModulesURL, "{arch}", arch))
+				}
+				p.add(Step{
+					Name:      "kernel-fetch-" + stem,
+					Kind:      KindKernel,
+					Arch:      arch,
+					Command:   cmd,
+					Config:    f,
+					Attested:  true,
+					Materials: p.materials(p.script("download-kernel.sh")),
+					Products:  append([]string{vmlinux, vmlinux + ".config", vmlinux + ".upstream"}, modules...),
+				})
+				continue
+			}
+
+			if f.Modules == config.ModulesRootfs {
+				p.modules[arch] = append(p.modules[arch], "kernel-build-"+stem)
+			}
+			// The firecracker-ci config is the base of the merged config,
+			// unless the flavor has its own.
+			if f.BaseConfig == "" {
+				bases = append(bases, "kernel-base-"+stem)
+				p.add(Step{
+					Name:    "kernel-base-" + stem,
+					Kind:    KindKernel,
+					Arch:    arch,
+					Command: append(download, "--output-dir", p.build("firecracker-ci")),
+				})
+			}
+			config := p.build("kernel-" + stem + ".config")
+			patchesDir := p.build(filepath.Join("kernel-patches", f.Name))
+			materials := p.materials(p.script("build-kernel.sh"), "kernel/Dockerfile", config)
+			for i, patch := range f.Patches {
+				materials = append(materials, filepath.Join(patchesDir, kpatch.FileName(i, patch)))
+			}
+			products := append([]string{vmlinux, vmlinux + ".config", vmlinux + ".source"}, modules...)
+			cmd := []string{
+				p.script("build-kernel.sh"),
+				"--arch", arch,
+				"--flavor", f.Name,
+				"--repo", f.SourceRepo,
+				"--ref", f.SourceRef,
+				"--config", config,
+				"--patches-dir", patchesDir,
+				"--output-dir", p.opts.BuildDir,
+				"--runtime", cmp.Or(p.opts.ContainerRuntime, p.cfg.ContainerRuntime),
+			}
+			if f.Modules != "" {
+				cmd = append(cmd, "--modules")
+			}
+			if f.BzImage && arch == "x86_64" {
+				cmd = append(cmd, "--bzimage")
+				products = append(products, p.build("bzImage-"+stem))
+			}
+			fromSource = append(fromSource, Step{
+				Name:      "kernel-build-" + stem,
+				Kind:      KindKernel,
+				Arch:      arch,
+				Command:   cmd,
+				After:     []string{"kernel-config", "kernel-patches"},
+				Config:    f,
+				Attested:  true,
+				Materials: materials,
+				Products:  products,
+			})
+		}
+	}
+	if len(fromSource) == 0 {
+		return nil
+	}
+
+	merge := goRun("kernel-config", "-config", p.opts.ConfigPath, "-build-dir", p.opts.BuildDir)
+	if p.opts.KernelSrc != "" {
+		merge = append(merge, "-kernel-src", p.opts.KernelSrc)
+	}
+	p.add(Step{Name: "kernel-config", Kind: KindKernel, Command: merge, After: bases})
+	p.add(Step{
+		Name:    "kernel-patches",
+		Kind:    KindKernel,
+		Command: goRun("kernel-patches", "-config", p.opts.ConfigPath, "-patches-dir", p.build("kernel-patches")),
+	})
+	p.steps = append(p.steps, fromSource...)
+	return nil
+}
+
+// rootfses plans the rootfs images of every profile and architecture, with
+// their staged files and base rootfs archives.
+func (p *planner) rootfses() error {
+	d, err := distro.Get(p.cfg.Rootfs.Distro)
+	if err != nil {
+		return err
+	}
+	for _, prof := range p.cfg.RootfsProfiles() {
+		def := prof.Definition
+		for _, arch := range p.cfg.Architectures {
+			stem := prof.Stem(arch)
+			image := p.build("rootfs-" + stem + ".ext4")
+			toolchain := p.build("rootfs-" + stem + ".toolchain")
+			bootstrap := p.cfg.Bootstrap(prof)
+			// The rootfs steps are keyed on the profile and rootfs settings.
+			rootfs := []any{prof, p.cfg.Rootfs}
+
+			if def.Nix != nil {
+				p.add(Step{
+					Name:         "rootfs-build-" + stem,
+					Kind:         KindRootfs,
+					Arch:         arch,
+					Command:      goRun("rootfs-nix", "-config", p.opts.ConfigPath, "-profile", prof.Name, "-arch", arch, "-out", image),
+					Config:       prof,
+					Attested:     true,
+					Materials:    p.materials(),
+					MaterialDirs: []string{def.Nix.Flake},
+					Products:     []string{image, toolchain},
+				})
+				p.convert(prof, arch, stem)
+				continue
+			}
+
+			stage := p.build(filepath.Join("rootfs-files", stem))
+			p.add(Step{
+				Name:    "rootfs-files-" + stem,
+				Kind:    KindRootfs,
+				Arch:    arch,
+				Command: goRun("rootfs-files", "-config", p.opts.ConfigPath, "-profile", prof.Name, "-arch", arch, "-out-dir", stage),
+			})
+
+			var base string
+			switch {
+			case bootstrap == distro.BootstrapOCI:
+				base = p.build("rootfs-base-" + stem + ".tar")
+				p.add(Step{
+					Name:      "rootfs-base-" + stem,
+					Kind:      KindRootfs,
+					Arch:      arch,
+					Command:   goRun("rootfs-oci", "-config", p.opts.ConfigPath, "-profile", prof.Name, "-arch", arch, "-out", base),
+					Config:    rootfs,
+					Attested:  true,
+					Materials: p.materials(),
+					Products:  []string{base, p.build("rootfs-base-" + stem + ".source"), p.build("rootfs-base-" + stem + ".toolchain")},
+				})
+			case bootstrap == distro.BootstrapBuildKit:
+				base = p.build("rootfs-base-" + stem + ".tar")
+				cmd := goRun("rootfs-buildkit", "-config", p.opts.ConfigPath, "-profile", prof.Name, "-arch", arch,
+					"-cache-from", p.opts.BuildKitCacheFrom, "-cache-to", p.opts.BuildKitCacheTo, "-out", base)
+				if d.Name() == "debian" {
+					cmd = append(cmd, "-proxy", p.opts.APTProxy)
+				}
+				p.add(Step{
+					Name:      "rootfs-base-" + stem,
+					Kind:      KindRootfs,
+					Arch:      arch,
+					Command:   cmd,
+					Config:    rootfs,
+					Attested:  true,
+					Materials: p.materials(),
+					Products:  []string{base, p.build("rootfs-base-" + stem + ".toolchain")},
+				})
+			case d.Name() == "debian":
+				base = p.build("rootfs-base-" + stem + ".tar")
+				p.add(Step{
+					Name: "rootfs-base-" + stem,
+					Kind: KindRootfs,
+					Arch: arch,
+					Command: goRun("rootfs-debian", "-config", p.opts.ConfigPath, "-profile", prof.Name, "-arch", arch,
+						"-runtime", p.opts.DebianRuntime, "-proxy", p.opts.APTProxy, "-out", base),
+					Config:    rootfs,
+					Attested:  true,
+					Materials: p.materials(),
+					Products:  []string{base, p.build("rootfs-base-" + stem + ".toolchain")},
+				})
+			}
+
+			hostname, err := prof.Hostname(arch)
+			if err != nil {
+				return err
+			}
+			var modules []string
+			for _, f := range p.cfg.KernelFlavors() {
+				if f.Modules == config.ModulesRootfs {
+					modules = append(modules, p.build(f.ArtifactName("modules", arch)+".tar.zst"))
+				}
+			}
+			products := []string{image, image + ".mkfs", p.build(distro.PackageDB(d, stem)), toolchain}
+			filesystems := p.cfg.RootfsFilesystems(prof)[1:]
+			for _, fs := range filesystems {
+				img := p.build("rootfs-" + stem + "." + fs)
+				products = append(products, img, img+".mkfs")
+			}
+
+			cmd := []string{
+				p.script("build-rootfs.sh"),
+				"--arch", arch,
+				"--stem", stem,
+				"--profile", prof.Name,
+				"--packages", strings.Join(d.Packages(def.Packages), ","),
+				"--init", def.Init,
+				"--timezone", def.Timezone,
+				"--locale", def.Locale,
+				"--files-stage", stage,
+				"--distro", d.Name(),
+				"--branch", d.Release(p.cfg.Rootfs.DistroVersion),
+				"--bootstrap", bootstrap,
+				"--files-dir", p.opts.FilesDir,
+				"--output-dir", p.opts.BuildDir,
+			}
+			if hostname != "" {
+				cmd = append(cmd, "--hostname", hostname)
+			}
+			if len(def.FilesDirs) > 0 {
+				cmd = append(cmd, "--files-dirs", strings.Join(def.FilesDirs, ","))
+			}
+			if base != "" {
+				cmd = append(cmd, "--base-tar", base)
+			}
+			if len(filesystems) > 0 {
+				cmd = append(cmd, "--filesystems", strings.Join(filesystems, ","))
+			}
+			if def.Overlay {
+				overlay := p.build("rootfs-" + stem + ".overlay.ext4")
+				cmd = append(cmd, "--overlay-size-mib", strconv.Itoa(p.cfg.Rootfs.OverlaySizeMiB))
+				products = append(products, overlay, overlay+".mkfs")
+			}
+			if def.Verity {
+				root := p.build("rootfs-" + stem + "." + p.cfg.ReadOnlyRoot(prof))
+				cmd = append(cmd, "--verity", p.cfg.ReadOnlyRoot(prof))
+				products = append(products, root+".verity", root+".verity.info")
+			}
+			if opts := p.cfg.Rootfs.Ext4.MkfsOptions(); opts != "" {
+				cmd = append(cmd, "--ext4-options", opts)
+			}
+			if size := p.cfg.RootfsSizeMiB(arch); size > 0 {
+				cmd = append(cmd, "--size-mib", strconv.Itoa(size))
+			}
+			if def.Firstboot {
+				cmd = append(cmd, "--firstboot")
+			}
+			if def.CloudInit {
+				cmd = append(cmd, "--cloud-init")
+			}
+			if len(modules) > 0 {
+				cmd = append(cmd, "--modules", strings.Join(modules, ","))
+			}
+
+			materials := append(p.materials(p.script("build-rootfs.sh")), modules...)
+			after := append([]string{"rootfs-files-" + stem}, p.modules[arch]...)
+			if base != "" {
+				materials = append(materials, base)
+				after = append(after, "rootfs-base-"+stem)
+			}
+			p.add(Step{
+				Name:         "rootfs-build-" + stem,
+				Kind:         KindRootfs,
+				Arch:         arch,
+				Command:      cmd,
+				After:        after,
+				Config:       rootfs,
+				Attested:     true,
+				Materials:    materials,
+				MaterialDirs: slices.Concat([]string{p.opts.ProfilesDir, p.opts.FilesDir}, def.FilesDirs, []string{stage}),
+				Products:     products,
+			})
+			p.convert(prof, arch, stem)
+		}
+	}
+	return nil
+}
+
+// convert plans the conversion of every image of the rootfs-build-<stem>
+// step to the rootfs.disk_formats, as make convert-images.
+func (p *planner) convert(prof config.RootfsProfile, arch, stem string) {
+	for _, fs := range p.cfg.RootfsFilesystems(prof) {
+		image := p.build("rootfs-" + stem + "." + fs)
+		for _, format := range p.cfg.Rootfs.DiskFormats {
+			p.add(Step{
+				Name:      "rootfs-convert-" + stem + "-" + fs + "-" + format,
+				Kind:      KindConvert,
+				Arch:      arch,
+				Command:   goRun("convert", "-in", image, "-format", format),
+				After:     []string{"rootfs-build-" + stem},
+				Attested:  true,
+				Materials: []string{image},
+				Products:  []string{manifest.ConvertedFile(image, format)},
+			})
+		}
+	}
+}
+
+// initramfses plans the initramfs of every architecture, when enabled.
+func (p *planner) initramfses() error {
+	if !p.cfg.Initramfs.Enabled {
+		return nil
+	}
+	for _, arch := range p.cfg.Architectures {
+		out := p.build("initramfs-" + arch + ".cpio.gz")
+		p.add(Step{
+			Name: "initramfs-build-" + arch,
+			Kind: KindInitramfs,
+			Arch: arch,
+			Command: []string{
+				p.script("build-initramfs.sh"),
+				"--arch", arch,
+				"--branch", "v" + p.cfg.Rootfs.DistroVersion,
+				"--init", p.cfg.Initramfs.Init,
+				"--output-dir", p.opts.BuildDir,
+			},
+			Config:    p.cfg.Initramfs,
+			Attested:  true,
+			Materials: p.materials(p.script("build-initramfs.sh"), p.cfg.Initramfs.Init),
+			Products:  []string{out, p.build("initramfs-" + arch + ".source")},
+		})
+	}
+	return nil
+}
+
+// firecrackers plans the bundled Firecracker downloads, when enabled.
+func (p *planner) firecrackers() error {
+	if !p.cfg.Firecracker.Bundle {
+		return nil
+	}
+	for _, arch := range p.cfg.Architectures {
+		p.add(Step{
+			Name: "firecracker-fetch-" + arch,
+			Kind: KindFirecracker,
+			Arch: arch,
+			Command: []string{
+				p.script("download-firecracker.sh"),
+				"--arch", arch,
+				"--version", p.cfg.Firecracker.Version,
+				"--output-dir", p.opts.BuildDir,
+			},
+			Config:    p.cfg.Firecracker,
+			Attested:  true,
+			Materials: p.materials(p.script("download-firecracker.sh")),
+			Products:  []string{p.build("firecracker-" + arch), p.build("jailer-" + arch), p.build("firecracker-" + arch + ".source")},
+		})
+	}
+	return nil
+}
+
+// disks plans the empty data disks.
+func (p *planner) disks() error {
+	for _, d := range p.cfg.Disks {
+		out := p.build(d.File())
+		p.add(Step{
+			Name: "disk-build-" + d.Name,
+			Kind: KindDisks,
+			Command: []string{
+				p.script("build-disk.sh"),
+				"--name", d.Name,
+				"--filesystem", d.Filesystem,
+				"--size-mib", strconv.Itoa(d.SizeMiB),
+				"--label", d.Label,
+				"--output", out,
+			},
+			Config:    d,
+			Attested:  true,
+			Materials: p.materials(p.script("build-disk.sh")),
+			Products:  []string{out, out + ".mkfs"},
+		})
+	}
+	return nil
+}
+
+// release plans the hooks, SBOMs, manifest and post-processing of the
+// built artifacts, each after every previous step.
+func (p *planner) release() error {
+	if len(p.cfg.Hooks) > 0 {
+		p.add(Step{
+			Name:    "hooks",
+			Kind:    KindHooks,
+			Command: goRun("hooks", "-config", p.opts.ConfigPath, "-build-dir", p.opts.BuildDir),
+			After:   p.names(),
+		})
+	}
+	p.add(Step{
+		Name:    "sbom",
+		Kind:    KindSBOM,
+		Command: goRun("sbom", "-version", p.opts.Version, "-formats", p.opts.SBOMFormats, "-config", p.opts.ConfigPath, "-build-dir", p.opts.BuildDir),
+		After:   p.names(),
+	})
+	p.add(Step{
+		Name:    "manifest",
+		Kind:    KindManifest,
+		Command: goRun("manifest", "-version", p.opts.Version, "-config", p.opts.ConfigPath, "-build-dir", p.opts.BuildDir, "-commit", p.opts.Commit),
+		After:   p.names(),
+	})
+	if len(p.cfg.PostProcess) > 0 {
+		// The products of the post-processing (e.g. the parts of a split)
+		// are only known once it ran, so cmd/postprocess attests each
+		// artifact pipeline itself.
+		p.add(Step{
+			Name:    "postprocess",
+			Kind:    KindPostProcess,
+			Command: goRun("postprocess", "-config", p.opts.ConfigPath, "-build-dir", p.opts.BuildDir),
+			After:   p.names(),
+		})
+	}
+	return nil
+}
+
+// Select returns the steps matching one of the only patterns (every step
+// without them) and none of the skip patterns. A pattern is a step kind or
+// a path.Match pattern of step names (e.g. rootfs-build-*-x86_64).
+func Select(steps []Step, only, skip []string) ([]Step, error) {
+	for _, pattern := range slices.Concat(only, skip) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad step pattern %q: %w", pattern, err)
+		}
+	}
+	matches := func(s Step, patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, s.Name); ok || pattern == s.Kind {
+				return true
+			}
+		}
+		return false
+	}
+
+	var selected []Step
+	for _, s := range steps {
+		if (len(only) == 0 || matches(s, only)) && !matches(s, skip) {
+			selected = append(selected, s)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,306 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/attest"
+)
+
+// Step result statuses.
+const (
+	StatusOK      = "ok"
+	StatusCached  = "cached"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Result is the outcome of a step run.
+type Result struct {
+	Step     string        `json:"step"`
+	Kind     string        `json:"kind"`
+	Arch     string        `json:"arch,omitempty"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration_ns"`
+	// Attestation is the attestation file written by attested steps.
+	Attestation string `json:"attestation,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Runner runs pipeline steps.
+type Runner struct {
+	// BuildDir is the directory the step attestations are written to.
+	BuildDir string
+	// Jobs is the number of steps run concurrently, one when unset. The
+	// output lines of concurrent steps are prefixed by the step name.
+	Jobs int
+	// Cache, when set, skips the attested steps whose products were built
+	// from the same inputs.
+	Cache *Cache
+	// Stdout and Stderr receive the command output.
+	Stdout, Stderr io.Writer
+	// Log receives the step events (started, finished, cache hits),
+	// slog.Default() when unset.
+	Log *slog.Logger
+}
+
+func (r Runner) log() *slog.Logger {
+	if r.Log == nil {
+		return slog.Default()
+	}
+	return r.Log
+}
+
+// Run runs the steps in order, each once the selected steps it comes after
+// succeeded, up to Jobs at a time. At the first failure no other step is
+// started: the errors of the failed steps are returned and the steps not
+// started are reported skipped. Every step has a result, also on failure.
+func (r Runner) Run(ctx context.Context, steps []Step) ([]Result, error) {
+	const (
+		pending = iota
+		running
+		finished
+	)
+	state := make([]int, len(steps))
+	index := make(map[string]int, len(steps))
+	results := make([]Result, len(steps))
+	for i, s := range steps {
+		index[s.Name] = i
+		results[i] = Result{Step: s.Name, Kind: s.Kind, Arch: s.Arch, Status: StatusSkipped}
+	}
+	ready := func(s Step) bool {
+		for _, name := range s.After {
+			if i, ok := index[name]; ok && state[i] != finished {
+				return false
+			}
+		}
+		return true
+	}
+
+	out := &output{}
+	done := make(chan int)
+	errs := make([]error, len(steps))
+	active, started, failed := 0, 0, false
+	for {
+		for i, s := range steps {
+			if active >= max(r.Jobs, 1) || failed || ctx.Err() != nil {
+				break
+			}
+			if state[i] != pending || !ready(s) {
+				continue
+			}
+
+			state[i] = running
+			active++
+			started++
+			r.log().Info("Step started", "step", s.Name, "n", started, "total", len(steps))
+			go func(n int) {
+				stdout, stderr := r.Stdout, r.Stderr
+				if r.Jobs > 1 {
+					stdout = out.prefixed(r.Stdout, s.Name)
+					stderr = out.prefixed(r.Stderr, s.Name)
+				}
+				start := time.Now()
+				attestation, cached, err := r.runStep(ctx, s, stdout, stderr)
+				flush(stdout, stderr)
+
+				res := &results[i]
+				res.Duration = time.Since(start).Round(time.Millisecond)
+				res.Attestation = attestation
+				res.Status = StatusOK
+				if cached {
+					res.Status = StatusCached
+				}
+				if err != nil {
+					res.Status, res.Error = StatusFailed, err.Error()
+					errs[i] = err
+				}
+				level := slog.LevelInfo
+				if err != nil {
+					level = slog.LevelError
+				}
+				r.log().Log(ctx, level, "Step finished", "step", s.Name, "n", n, "total", len(steps), "status", res.Status, "duration", res.Duration)
+				done <- i
+			}(started)
+		}
+		if active == 0 {
+			break
+		}
+		i := <-done
+		state[i] = finished
+		failed = failed || errs[i] != nil
+		active--
+	}
+	return results, errors.Join(errs...)
+}
+
+// output serializes the writes of concurrent steps.
+type output struct{ mu sync.Mutex }
+
+// prefixed returns a writer writing whole lines to w prefixed by the step
+// name, its last unterminated line written by flush.
+func (o *output) prefixed(w io.Writer, step string) io.Writer {
+	return &prefixWriter{out: o, w: w, prefix: "[" + step + "] "}
+}
+
+type prefixWriter struct {
+	out    *output
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	if err := p.writeLines(p.buf[:i+1]); err != nil {
+		return 0, err
+	}
+	p.buf = p.buf[i+1:]
+	return len(b), nil
+}
+
+func (p *prefixWriter) writeLines(lines []byte) error {
+	var b bytes.Buffer
+	for line := range bytes.Lines(lines) {
+		b.WriteString(p.prefix)
+		b.Write(line)
+	}
+	p.out.mu.Lock()
+	defer p.out.mu.Unlock()
+	_, err := p.w.Write(b.Bytes())
+	return err
+}
+
+// flush writes the unterminated last lines of prefixed writers.
+func flush(writers ...io.Writer) {
+	for _, w := range writers {
+		if p, ok := w.(*prefixWriter); ok && len(p.buf) > 0 {
+			p.writeLines(append(p.buf, '\n'))
+			p.buf = nil
+		}
+	}
+}
+
+// runStep runs the command of s, under an attestation for attested steps,
+// and returns the attestation file path and whether the step was cached.
+func (r Runner) runStep(ctx context.Context, s Step, stdout, stderr io.Writer) (string, bool, error) {
+	if !s.Attested {
+		cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		cmd.Stdin = os.Stdin
+		if err := cmd.Run(); err != nil {
+			return "", false, fmt.Errorf("step %s: running command: %w", s.Name, err)
+		}
+		return "", false, nil
+	}
+
+	var key string
+	if r.Cache != nil {
+		// Steps whose inputs cannot be hashed yet (e.g. a missing
+		// material) run uncached.
+		var err error
+		if key, err = r.Cache.Key(s); err != nil {
+			r.log().Warn("Not caching step", "step", s.Name, "err", err)
+		}
+		attestation := filepath.Join(r.BuildDir, s.Name+attest.FileSuffix)
+		if key != "" && r.Cache.Hit(s, key, attestation) {
+			r.log().Info("Step is up to date", "step", s.Name)
+			return attestation, true, nil
+		}
+		if key != "" && r.Cache.Remote != nil {
+			// Remote cache failures are not build failures, the step
+			// just runs.
+			switch err := r.Cache.Fetch(ctx, s, key, attestation); {
+			case err == nil:
+				r.log().Info("Step restored from the remote cache", "step", s.Name)
+				return attestation, true, nil
+			case !errors.Is(err, ErrNotFound):
+				r.log().Warn("Remote cache miss", "step", s.Name, "err", err)
+			}
+		}
+		if err := r.Cache.Invalidate(s.Name); err != nil {
+			return "", false, err
+		}
+	}
+
+	path, err := r.runAttested(ctx, s, stdout, stderr)
+	if err != nil {
+		return "", false, err
+	}
+	if key == "" {
+		return path, false, nil
+	}
+	if err := r.Cache.Store(s, key, path); err != nil {
+		return "", false, fmt.Errorf("step %s: %w", s.Name, err)
+	}
+	if r.Cache.Remote != nil && r.Cache.ReadWrite {
+		if err := r.Cache.Push(ctx, s.Name); err != nil {
+			r.log().Warn("Not storing step to the remote cache", "step", s.Name, "err", err)
+		}
+	}
+	return path, false, nil
+}
+
+// runAttested runs the command of s under an attestation, written to the
+// build directory.
+func (r Runner) runAttested(ctx context.Context, s Step, stdout, stderr io.Writer) (string, error) {
+	files, err := dirFiles(s.MaterialDirs)
+	if err != nil {
+		return "", err
+	}
+	st, err := attest.Run(ctx, attest.Step{
+		Name:      s.Name,
+		Command:   s.Command,
+		Materials: slices.Concat(s.Materials, files),
+		Products:  s.Products,
+	}, stdout, stderr)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(r.BuildDir, 0o755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", r.BuildDir, err)
+	}
+	return attest.Write(r.BuildDir, st)
+}
+
+// dirFiles returns the regular files under dirs, sorted, skipping .git
+// directories. Missing directories (e.g. no alpine/files) have no files.
+func dirFiles(dirs []string) ([]string, error) {
+	var files []string
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			switch {
+			case errors.Is(err, fs.ErrNotExist) && path == dir:
+				return nil
+			case err != nil:
+				return err
+			case d.IsDir() && d.Name() == ".git":
+				return filepath.SkipDir
+			case d.Type().IsRegular():
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
@@ -0,0 +1,131 @@
+package postprocess
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/slok/sbx-images/pkg/progress"
+)
+
+func init() {
+	Register("compress", newCompress)
+}
+
+// compress compresses every file with gzip (in process) or zstd/xz (with
+// the upstream tool).
+type compress struct {
+	format string
+	level  int
+}
+
+var compressExtensions = map[string]string{"gzip": ".gz", "zstd": ".zst", "xz": ".xz"}
+
+func newCompress(opts map[string]string) (Stage, error) {
+	if err := checkOptions(opts, "format", "level"); err != nil {
+		return nil, err
+	}
+
+	c := compress{format: option(opts, "format", "zstd")}
+	if _, ok := compressExtensions[c.format]; !ok {
+		return nil, fmt.Errorf("unknown format %q (supported: gzip, zstd, xz)", c.format)
+	}
+	if l := opts["level"]; l != "" {
+		level, err := strconv.Atoi(l)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level %q: %w", l, err)
+		}
+		c.level = level
+	}
+	return c, nil
+}
+
+func (compress) Name() string { return "compress" }
+
+func (c compress) Run(ctx context.Context, in []Output) ([]Output, error) {
+	out := make([]Output, 0, len(in))
+	for _, f := range in {
+		dst := f.Path + compressExtensions[c.format]
+		var err error
+		if c.format == "gzip" {
+			err = c.gzip(f.Path, dst)
+		} else {
+			err = c.tool(ctx, f.Path, dst)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("compressing %s: %w", f.Path, err)
+		}
+		out = append(out, Output{Path: dst})
+	}
+	return out, nil
+}
+
+func (c compress) gzip(src, dst string) error {
+	level := gzip.BestCompression
+	if c.level != 0 {
+		level = c.level
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	p := progress.New("compressing "+filepath.Base(src), fi.Size())
+	defer p.Done()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	zw, err := gzip.NewWriterLevel(out, level)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(zw, p.Reader(in)); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (c compress) tool(ctx context.Context, src, dst string) error {
+	args := []string{"-q", "-f", "-k", "-T0"}
+	if c.level != 0 {
+		args = append(args, fmt.Sprintf("-%d", c.level))
+	}
+	if c.format == "zstd" && c.level > 19 {
+		args = append(args, "--ultra")
+	}
+	args = append(args, "-c", src)
+
+	if _, err := exec.LookPath(c.format); err != nil {
+		return fmt.Errorf("%s is required: %w", c.format, err)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, c.format, args...)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	Register("encrypt", newEncrypt)
+}
+
+// encrypt encrypts every file to a public key recipient with age or gpg, so
+// no secret ever needs to be available to the build.
+type encrypt struct {
+	tool      string
+	recipient string
+}
+
+func newEncrypt(opts map[string]string) (Stage, error) {
+	if err := checkOptions(opts, "tool", "recipient"); err != nil {
+		return nil, err
+	}
+
+	e := encrypt{tool: option(opts, "tool", "age"), recipient: opts["recipient"]}
+	if e.tool != "age" && e.tool != "gpg" {
+		return nil, fmt.Errorf("unknown tool %q (supported: age, gpg)", e.tool)
+	}
+	if e.recipient == "" {
+		return nil, fmt.Errorf("recipient is required")
+	}
+	return e, nil
+}
+
+func (encrypt) Name() string { return "encrypt" }
+
+func (e encrypt) Run(ctx context.Context, in []Output) ([]Output, error) {
+	out := make([]Output, 0, len(in))
+	for _, f := range in {
+		var (
+			dst  string
+			args []string
+		)
+		switch e.tool {
+		case "age":
+			dst = f.Path + ".age"
+			args = []string{"--recipient", e.recipient, "--output", dst, f.Path}
+		case "gpg":
+			dst = f.Path + ".gpg"
+			args = []string{"--batch", "--yes", "--trust-model", "always", "--encrypt", "--recipient", e.recipient, "--output", dst, f.Path}
+		}
+
+		if _, err := exec.LookPath(e.tool); err != nil {
+			return nil, fmt.Errorf("%s is required: %w", e.tool, err)
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, e.tool, args...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("encrypting %s: %w: %s", f.Path, err, strings.TrimSpace(stderr.String()))
+		}
+		out = append(out, Output{Path: dst})
+	}
+	return out, nil
+}
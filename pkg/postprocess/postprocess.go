@@ -0,0 +1,104 @@
+// Package postprocess runs configurable transformation pipelines (compress,
+// encrypt, split, sign...) on built artifacts.
+//
+// Stages are registered by name and built from their config.yaml options, so
+// adding a transformation only needs a new Register call.
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Output is a file flowing through the pipeline.
+type Output struct {
+	Path string
+	// Signature is the detached signature path, set by the sign stage.
+	Signature string
+}
+
+// Stage is a post-processing pipeline stage.
+type Stage interface {
+	// Name returns the stage name (e.g. "compress").
+	Name() string
+	// Run transforms the input files and returns the resulting files.
+	Run(ctx context.Context, in []Output) ([]Output, error)
+}
+
+// Factory builds a stage from its options.
+type Factory func(opts map[string]string) (Stage, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a stage available under name.
+func Register(name string, f Factory) {
+	factories[name] = f
+}
+
+// Stages returns the registered stage names sorted.
+func Stages() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds the named stage.
+func New(name string, opts map[string]string) (Stage, error) {
+	f, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown post-processing stage %q (supported: %s)", name, strings.Join(Stages(), ", "))
+	}
+	st, err := f(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s stage: %w", name, err)
+	}
+	return st, nil
+}
+
+// Pipeline is an ordered list of stages.
+type Pipeline []Stage
+
+// Run runs the stages in order on the file at path and returns the final
+// files. Intermediate files are removed, the input file is kept.
+func (p Pipeline) Run(ctx context.Context, path string) ([]Output, error) {
+	files := []Output{{Path: path}}
+	for _, st := range p {
+		out, err := st.Run(ctx, files)
+		if err != nil {
+			return nil, fmt.Errorf("%s stage: %w", st.Name(), err)
+		}
+
+		for _, f := range files {
+			if f.Path != path && !slices.ContainsFunc(out, func(o Output) bool { return o.Path == f.Path }) {
+				_ = os.Remove(f.Path)
+			}
+		}
+		files = out
+	}
+	return files, nil
+}
+
+// option returns opts[key] or def when unset.
+func option(opts map[string]string, key, def string) string {
+	if v, ok := opts[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// checkOptions fails on options not in known, catching typos in config.yaml.
+func checkOptions(opts map[string]string, known ...string) error {
+	for k := range opts {
+		if !slices.Contains(known, k) {
+			return fmt.Errorf("unknown option %q (supported: %s)", k, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/slok/sbx-images/pkg/signer"
+)
+
+func init() {
+	Register("sign", newSign)
+}
+
+// sign writes a detached signature for every file. The minisign key
+// password is read from MINISIGN_PASSWORD.
+type sign struct {
+	signer signer.Signer
+}
+
+func newSign(opts map[string]string) (Stage, error) {
+	if err := checkOptions(opts, "backend", "key", "public_key"); err != nil {
+		return nil, err
+	}
+
+	s, err := signer.New(option(opts, "backend", "gpg"), signer.Options{
+		Key:       opts["key"],
+		PublicKey: opts["public_key"],
+		Password:  os.Getenv("MINISIGN_PASSWORD"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sign{signer: s}, nil
+}
+
+func (sign) Name() string { return "sign" }
+
+func (s sign) Run(ctx context.Context, in []Output) ([]Output, error) {
+	out := make([]Output, 0, len(in))
+	for _, f := range in {
+		sig, err := s.signer.Sign(ctx, f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("signing %s: %w", f.Path, err)
+		}
+		out = append(out, Output{Path: f.Path, Signature: sig})
+	}
+	return out, nil
+}
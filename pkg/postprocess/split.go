@@ -0,0 +1,106 @@
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("split", newSplit)
+}
+
+// split cuts every file into parts of at most size bytes, named
+// <file>.part000, <file>.part001..., e.g. to stay under release asset limits.
+type split struct {
+	size int64
+}
+
+func newSplit(opts map[string]string) (Stage, error) {
+	if err := checkOptions(opts, "size"); err != nil {
+		return nil, err
+	}
+
+	size, err := ParseSize(option(opts, "size", "2GiB"))
+	if err != nil {
+		return nil, err
+	}
+	return split{size: size}, nil
+}
+
+func (split) Name() string { return "split" }
+
+func (s split) Run(_ context.Context, in []Output) ([]Output, error) {
+	var out []Output
+	for _, f := range in {
+		parts, err := s.splitFile(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("splitting %s: %w", f.Path, err)
+		}
+		out = append(out, parts...)
+	}
+	return out, nil
+}
+
+func (s split) splitFile(path string) ([]Output, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var parts []Output
+	for i := 0; ; i++ {
+		dst := fmt.Sprintf("%s.part%03d", path, i)
+		f, err := os.Create(dst)
+		if err != nil {
+			return nil, err
+		}
+		n, copyErr := io.CopyN(f, src, s.size)
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		if copyErr != nil && !errors.Is(copyErr, io.EOF) {
+			return nil, copyErr
+		}
+
+		// The previous part ended exactly at the end of the file.
+		if n == 0 && i > 0 {
+			_ = os.Remove(dst)
+			break
+		}
+		parts = append(parts, Output{Path: dst})
+		if copyErr != nil {
+			break
+		}
+	}
+	return parts, nil
+}
+
+// ParseSize parses sizes like 512MiB, 2GiB, 100M or plain bytes.
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000},
+	}
+
+	num, mult := strings.TrimSpace(s), int64(1)
+	for _, u := range units {
+		if v, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, mult = strings.TrimSpace(v), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
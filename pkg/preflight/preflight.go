@@ -0,0 +1,309 @@
+// Package preflight checks the host prerequisites of the builds before they
+// start: the tools the build scripts run, the container runtime, KVM and,
+// for the images of a foreign architecture (e.g. aarch64 on x86_64), the
+// qemu-user binfmt handler running its binaries in the rootfs chroot.
+//
+// Every check reports what was found and, when it fails, how to fix it, so a
+// missing prerequisite is reported up front instead of in the middle of a
+// build.
+package preflight
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/container"
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/rootfs/buildkit"
+)
+
+// Check statuses.
+const (
+	StatusOK = "ok"
+	// StatusWarn is a missing prerequisite of optional steps (e.g. KVM for
+	// the boot tests).
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Check is the result of a prerequisite check.
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Detail is what was found, or missing.
+	Detail string `json:"detail"`
+	// Fix is how to fix a failed or warned check.
+	Fix string `json:"fix,omitempty"`
+}
+
+func (c Check) Error() string {
+	msg := fmt.Sprintf("%s: %s", c.Name, c.Detail)
+	if c.Fix != "" {
+		msg += " (fix: " + c.Fix + ")"
+	}
+	return msg
+}
+
+// Failed returns the failed checks.
+func Failed(checks []Check) []Check {
+	var failed []Check
+	for _, c := range checks {
+		if c.Status == StatusFail {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// hostArches are the build architecture names of the Go architectures.
+var hostArches = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"riscv64": "riscv64",
+}
+
+// binfmtPlatforms are the tonistiigi/binfmt platform names of the build
+// architectures.
+var binfmtPlatforms = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"riscv64": "riscv64",
+}
+
+// HostArch returns the build architecture name of the host.
+func HostArch() string {
+	if arch, ok := hostArches[runtime.GOARCH]; ok {
+		return arch
+	}
+	return runtime.GOARCH
+}
+
+// BinfmtDir is where the binfmt_misc handlers are registered.
+var BinfmtDir = "/proc/sys/fs/binfmt_misc"
+
+// Binfmt checks the binaries of arch run on the host: natively or through an
+// enabled qemu-<arch> binfmt_misc handler with the F (fix binary) flag, its
+// interpreter opened at registration so it also runs in chroots and
+// containers.
+func Binfmt(arch string) Check {
+	c := Check{Name: "binfmt " + arch, Status: StatusOK}
+	if arch == HostArch() {
+		c.Detail = "native"
+		return c
+	}
+
+	install := fmt.Sprintf("install qemu-user-static (apt-get install qemu-user-static binfmt-support) or run docker run --privileged --rm tonistiigi/binfmt --install %s",
+		cmp.Or(binfmtPlatforms[arch], arch))
+	if _, err := os.Stat(BinfmtDir + "/status"); err != nil {
+		c.Status, c.Detail = StatusFail, "binfmt_misc is not mounted"
+		c.Fix = "mount -t binfmt_misc binfmt_misc " + BinfmtDir + ", then " + install
+		return c
+	}
+	handler := BinfmtDir + "/qemu-" + arch
+	f, err := os.Open(handler)
+	if err != nil {
+		c.Status, c.Detail, c.Fix = StatusFail, "no qemu-"+arch+" binfmt handler", install
+		return c
+	}
+	defer f.Close()
+
+	var enabled bool
+	var interpreter, flags string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "enabled":
+			enabled = true
+		case strings.HasPrefix(line, "interpreter "):
+			interpreter = strings.TrimPrefix(line, "interpreter ")
+		case strings.HasPrefix(line, "flags: "):
+			flags = strings.TrimPrefix(line, "flags: ")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		c.Status, c.Detail = StatusFail, fmt.Sprintf("reading %s: %v", handler, err)
+		return c
+	}
+	switch {
+	case !enabled:
+		c.Status, c.Detail, c.Fix = StatusFail, "qemu-"+arch+" binfmt handler is disabled", "echo 1 > "+handler
+	case !strings.Contains(flags, "F"):
+		c.Status, c.Detail = StatusFail, fmt.Sprintf("qemu-%s binfmt handler (%s) lacks the F flag, its interpreter is not found in chroots", arch, interpreter)
+		c.Fix = "re-register it with the F flag: " + install
+	default:
+		c.Detail = fmt.Sprintf("%s (flags %s)", interpreter, flags)
+	}
+	return c
+}
+
+// KVM checks /dev/kvm can be opened, needed to boot the images (boot
+// matrix and boot tests), not to build them.
+func KVM() Check {
+	c := Check{Name: "kvm", Status: StatusOK, Detail: "/dev/kvm"}
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if err != nil {
+		c.Status, c.Detail = StatusWarn, fmt.Sprintf("KVM is not available: %v", err)
+		c.Fix = "enable virtualization and load kvm_intel or kvm_amd, and add the user to the kvm group"
+		return c
+	}
+	f.Close()
+	return c
+}
+
+// Tool checks the command name is installed, from the package pkg.
+func Tool(name, pkg string, required bool) Check {
+	c := Check{Name: name, Status: StatusOK}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		c.Status, c.Detail, c.Fix = StatusFail, "not found", "install "+pkg
+		if !required {
+			c.Status = StatusWarn
+		}
+		return c
+	}
+	c.Detail = path
+	return c
+}
+
+// Loop checks loop devices can be set up, the images are mounted through
+// them.
+func Loop() Check {
+	c := Tool("losetup", "util-linux", true)
+	if c.Status != StatusOK {
+		return c
+	}
+	if _, err := os.Stat("/dev/loop-control"); err != nil {
+		c.Status, c.Detail, c.Fix = StatusFail, "no /dev/loop-control", "modprobe loop"
+	}
+	return c
+}
+
+// Root checks the builds run as root, needed to build the rootfs images.
+func Root() Check {
+	c := Check{Name: "root", Status: StatusOK, Detail: "running as root"}
+	if os.Geteuid() != 0 {
+		c.Status, c.Detail, c.Fix = StatusWarn, "not running as root, the rootfs builds need it", "run the builds with sudo"
+	}
+	return c
+}
+
+// Host returns the checks of the prerequisites of building cfg for archs.
+func Host(ctx context.Context, cfg config.Config, archs []string) []Check {
+	checks := []Check{
+		Root(),
+		Tool("tar", "tar", true),
+		Tool("curl", "curl", true),
+		Tool("sha256sum", "coreutils", true),
+		Tool("chroot", "coreutils", true),
+		Tool("mount", "util-linux", true),
+		Loop(),
+		Tool("mkfs.ext4", "e2fsprogs", true),
+		Tool("e2fsck", "e2fsprogs", true),
+		Tool("resize2fs", "e2fsprogs", true),
+		Tool("fallocate", "util-linux", false),
+	}
+
+	var needZstd, needGit, needRuntime bool
+	for _, f := range cfg.KernelFlavors() {
+		if f.BuildFromSource {
+			needZstd, needGit, needRuntime = true, true, true
+		}
+		if f.Modules == config.ModulesRootfs {
+			needZstd = true
+		}
+	}
+	// The non Nix images are set up in a chroot of their architecture.
+	var filesystems []string
+	var verity, needNix, needBuildKit, chroot bool
+	for _, p := range cfg.RootfsProfiles() {
+		for _, fs := range cfg.RootfsFilesystems(p)[1:] {
+			if !slices.Contains(filesystems, fs) {
+				filesystems = append(filesystems, fs)
+			}
+		}
+		verity = verity || p.Definition.Verity
+		switch cfg.Bootstrap(p) {
+		case distro.BootstrapNix:
+			needNix = true
+			continue
+		case distro.BootstrapAlpineMakeRootfs:
+			needGit = true
+		case distro.BootstrapBuildKit:
+			needBuildKit = true
+		case distro.BootstrapMmdebstrap, distro.BootstrapDebootstrap:
+			needRuntime = true
+		}
+		chroot = true
+	}
+	if needNix {
+		checks = append(checks, Tool("nix", "nix", true))
+	}
+	if needZstd {
+		checks = append(checks, Tool("zstd", "zstd", true))
+	}
+	if needGit {
+		checks = append(checks, Tool("git", "git", true))
+	}
+	for _, fs := range filesystems {
+		switch fs {
+		case "squashfs":
+			checks = append(checks, Tool("mksquashfs", "squashfs-tools", true))
+		case "erofs":
+			checks = append(checks, Tool("mkfs.erofs", "erofs-utils", true))
+		case "btrfs":
+			checks = append(checks, Tool("mkfs.btrfs", "btrfs-progs", true))
+		}
+	}
+	if verity {
+		checks = append(checks, Tool("veritysetup", "cryptsetup", true))
+	}
+	if needRuntime {
+		checks = append(checks, Runtime(ctx, cfg.ContainerRuntime))
+	}
+	if needBuildKit {
+		checks = append(checks, BuildKit())
+	}
+	if chroot {
+		for _, arch := range archs {
+			checks = append(checks, Binfmt(arch))
+		}
+	}
+	return append(checks, KVM())
+}
+
+// Runtime checks the container runtime name (or auto) is usable.
+func Runtime(ctx context.Context, name string) Check {
+	c := Check{Name: "container runtime", Status: StatusOK}
+	rt, err := container.New(ctx, name)
+	if err != nil {
+		c.Status, c.Detail, c.Fix = StatusFail, err.Error(), "install podman or start the docker daemon"
+		return c
+	}
+	c.Detail = rt.Name()
+	if rt.Rootless() {
+		c.Detail += " (rootless)"
+	}
+	return c
+}
+
+// BuildKit checks a BuildKit client is installed, for the buildkit rootfs
+// bootstrap.
+func BuildKit() Check {
+	c := Check{Name: "buildkit", Status: StatusOK}
+	builder, err := buildkit.Builder(buildkit.Auto)
+	if err != nil {
+		c.Status, c.Detail, c.Fix = StatusFail, err.Error(), "install docker with the buildx plugin"
+		return c
+	}
+	c.Detail = builder
+	return c
+}
@@ -0,0 +1,191 @@
+// Package progress reports the progress of long operations on multi-GB
+// files (downloads, uploads, hashing, compression) so they don't look
+// frozen: a progress bar redrawn in place when stderr is a terminal, a
+// percentage log line every Interval otherwise (CI logs).
+//
+// A Reporter counts the bytes going through the Reader or Writer it wraps:
+//
+//	p := progress.New("hashing "+name, size)
+//	defer p.Done()
+//	io.Copy(h, p.Reader(f))
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Interval is how often the progress is logged when stderr is not a
+	// terminal.
+	Interval = 10 * time.Second
+	// MinSize is the size below which operations are not reported, they
+	// don't last long enough.
+	MinSize int64 = 64 << 20
+)
+
+// redraw is how often the progress bar is redrawn.
+const redraw = 200 * time.Millisecond
+
+const barWidth = 30
+
+// Reporter reports the progress of an operation on a number of bytes. It is
+// safe for concurrent use.
+type Reporter struct {
+	name  string
+	total int64
+	tty   bool
+	quiet bool
+	start time.Time
+
+	mu     sync.Mutex
+	done   int64
+	last   time.Time
+	logged bool
+}
+
+// New starts reporting the progress of the operation name (e.g. "hashing
+// rootfs-x86_64.ext4") on total bytes, zero when unknown.
+func New(name string, total int64) *Reporter {
+	now := time.Now()
+	return &Reporter{
+		name:  name,
+		total: total,
+		tty:   isTerminal(os.Stderr),
+		quiet: total > 0 && total < MinSize,
+		start: now,
+		last:  now,
+	}
+}
+
+// Add records n more bytes processed.
+func (r *Reporter) Add(n int64) {
+	if r.quiet {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done += n
+
+	now := time.Now()
+	switch {
+	case r.tty && now.Sub(r.last) >= redraw:
+		r.draw(now)
+	case !r.tty && now.Sub(r.last) >= Interval:
+		r.log(now)
+	default:
+		return
+	}
+	r.last = now
+}
+
+// Done ends the report: the bar is completed, or the end is logged when the
+// progress was.
+func (r *Reporter) Done() {
+	if r.quiet {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.tty {
+		if r.done > 0 {
+			r.draw(now)
+			fmt.Fprintln(os.Stderr)
+		}
+		return
+	}
+	if r.logged {
+		r.log(now)
+	}
+}
+
+// Reader returns rd counting the bytes read.
+func (r *Reporter) Reader(rd io.Reader) io.Reader {
+	return &reader{r: rd, p: r}
+}
+
+// Writer returns w counting the bytes written.
+func (r *Reporter) Writer(w io.Writer) io.Writer {
+	return &writer{w: w, p: r}
+}
+
+func (r *Reporter) draw(now time.Time) {
+	line := fmt.Sprintf("%s %s", r.name, Bytes(r.done))
+	if r.total > 0 {
+		filled := int(min(r.done, r.total) * barWidth / r.total)
+		line = fmt.Sprintf("%s [%s%s] %3d%% %s/%s", r.name,
+			strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+			r.percent(), Bytes(r.done), Bytes(r.total))
+	}
+	fmt.Fprintf(os.Stderr, "\r%s %s/s\033[K", line, Bytes(r.rate(now)))
+}
+
+func (r *Reporter) log(now time.Time) {
+	args := []any{"op", r.name, "bytes", r.done}
+	if r.total > 0 {
+		args = append(args, "total", r.total, "percent", r.percent())
+	}
+	args = append(args, "rate", Bytes(r.rate(now))+"/s", "elapsed", now.Sub(r.start).Round(time.Second))
+	slog.Info("Progress", args...)
+	r.logged = true
+}
+
+func (r *Reporter) percent() int64 {
+	return min(r.done, r.total) * 100 / r.total
+}
+
+// rate returns the bytes processed per second.
+func (r *Reporter) rate(now time.Time) int64 {
+	elapsed := now.Sub(r.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(r.done) / elapsed)
+}
+
+// Bytes formats n bytes in binary units (e.g. 1.5 GiB).
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type reader struct {
+	r io.Reader
+	p *Reporter
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.Add(int64(n))
+	return n, err
+}
+
+type writer struct {
+	w io.Writer
+	p *Reporter
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.p.Add(int64(n))
+	return n, err
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
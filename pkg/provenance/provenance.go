@@ -0,0 +1,130 @@
+// Package provenance generates SLSA v1 provenance as in-toto statements.
+//
+// See https://slsa.dev/spec/v1.0/provenance for the predicate format.
+package provenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// StatementType is the in-toto statement type.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType is the SLSA provenance predicate type.
+	PredicateType = "https://slsa.dev/provenance/v1"
+	// BuildType identifies the sbx-images build process.
+	BuildType = "https://github.com/slok/sbx-images/build@v1"
+)
+
+// Statement is an in-toto statement carrying a SLSA provenance predicate.
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// Subject is an artifact the statement is about.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Predicate is the SLSA v1 provenance predicate.
+type Predicate struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes the inputs of the build.
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   map[string]any       `json:"externalParameters"`
+	InternalParameters   map[string]any       `json:"internalParameters,omitempty"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// ResourceDescriptor identifies a build material.
+type ResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+	Name   string            `json:"name,omitempty"`
+}
+
+// RunDetails describes the build execution.
+type RunDetails struct {
+	Builder  Builder       `json:"builder"`
+	Metadata BuildMetadata `json:"metadata"`
+}
+
+// Builder identifies the entity that ran the build.
+type Builder struct {
+	ID string `json:"id"`
+}
+
+// BuildMetadata contains metadata of a build run.
+type BuildMetadata struct {
+	InvocationID string `json:"invocationId,omitempty"`
+	StartedOn    string `json:"startedOn,omitempty"`
+	FinishedOn   string `json:"finishedOn,omitempty"`
+}
+
+// Options are the inputs used to generate a provenance statement.
+type Options struct {
+	BuilderID            string
+	InvocationID         string
+	Parameters           map[string]any
+	InternalParameters   map[string]any
+	ResolvedDependencies []ResourceDescriptor
+	StartedOn            time.Time
+	FinishedOn           time.Time
+}
+
+// New returns a provenance statement for subject.
+func New(subject Subject, opts Options) Statement {
+	return Statement{
+		Type:          StatementType,
+		Subject:       []Subject{subject},
+		PredicateType: PredicateType,
+		Predicate: Predicate{
+			BuildDefinition: BuildDefinition{
+				BuildType:            BuildType,
+				ExternalParameters:   opts.Parameters,
+				InternalParameters:   opts.InternalParameters,
+				ResolvedDependencies: opts.ResolvedDependencies,
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{ID: opts.BuilderID},
+				Metadata: BuildMetadata{
+					InvocationID: opts.InvocationID,
+					StartedOn:    formatTime(opts.StartedOn),
+					FinishedOn:   formatTime(opts.FinishedOn),
+				},
+			},
+		},
+	}
+}
+
+// Write stores the statement as JSON at path.
+func Write(path string, s Statement) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling provenance: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing provenance: %w", err)
+	}
+
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,201 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/progress"
+)
+
+const defaultGitHubAPIURL = "https://api.github.com"
+
+type github struct {
+	repo   string
+	token  string
+	apiURL string
+	client *http.Client
+}
+
+func newGitHub(opts Options) (*github, error) {
+	if opts.Repo == "" || !strings.Contains(opts.Repo, "/") {
+		return nil, fmt.Errorf("github backend needs the repository as owner/name, got %q", opts.Repo)
+	}
+	apiURL := opts.APIURL
+	if apiURL == "" {
+		apiURL = defaultGitHubAPIURL
+	}
+	return &github{
+		repo:   opts.Repo,
+		token:  opts.Token,
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		client: http.DefaultClient,
+	}, nil
+}
+
+func (*github) Name() string { return "github" }
+
+func (g *github) URL(rel Release, asset Asset) string {
+	return fmt.Sprintf("https://github.com/%s/releases/download/%s/%s", g.repo, url.PathEscape(rel.Tag), url.PathEscape(asset.Name))
+}
+
+func (g *github) Check(ctx context.Context, rel Release) error {
+	if g.token == "" {
+		return fmt.Errorf("github token is required (GH_TOKEN or GITHUB_TOKEN)")
+	}
+
+	var repo struct {
+		Permissions *struct {
+			Push bool `json:"push"`
+		} `json:"permissions"`
+	}
+	status, err := g.do(ctx, http.MethodGet, g.apiURL+"/repos/"+g.repo, nil, &repo)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return fmt.Errorf("github token was rejected")
+	case http.StatusNotFound:
+		return fmt.Errorf("repository %s not found or not visible with the token", g.repo)
+	default:
+		return fmt.Errorf("getting repository %s: unexpected status %d", g.repo, status)
+	}
+	// App installation tokens (e.g. Actions GITHUB_TOKEN) don't report
+	// repository permissions, creating the release is the only real check.
+	if repo.Permissions != nil && !repo.Permissions.Push {
+		return fmt.Errorf("github token has no write access to %s", g.repo)
+	}
+
+	status, err = g.do(ctx, http.MethodGet, g.apiURL+"/repos/"+g.repo+"/releases/tags/"+url.PathEscape(rel.Tag), nil, nil)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusNotFound:
+	case http.StatusOK:
+		return fmt.Errorf("release %s already exists in %s", rel.Tag, g.repo)
+	default:
+		return fmt.Errorf("getting release %s: unexpected status %d", rel.Tag, status)
+	}
+
+	return nil
+}
+
+// Publish creates a draft release, uploads every asset and then publishes
+// the release, so consumers never see a partially uploaded release.
+func (g *github) Publish(ctx context.Context, rel Release) error {
+	var created struct {
+		ID        int64  `json:"id"`
+		UploadURL string `json:"upload_url"`
+	}
+	body := map[string]any{
+		"tag_name":               rel.Tag,
+		"name":                   rel.Tag,
+		"draft":                  true,
+		"generate_release_notes": true,
+	}
+	status, err := g.do(ctx, http.MethodPost, g.apiURL+"/repos/"+g.repo+"/releases", body, &created)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated {
+		return fmt.Errorf("creating release %s: unexpected status %d", rel.Tag, status)
+	}
+
+	// upload_url is a URI template: https://uploads.github.com/.../assets{?name,label}
+	uploadURL, _, _ := strings.Cut(created.UploadURL, "{")
+	for _, a := range rel.Assets {
+		if err := g.upload(ctx, uploadURL, a); err != nil {
+			return fmt.Errorf("uploading %s (draft release %s left in place): %w", a.Name, rel.Tag, err)
+		}
+	}
+
+	releaseURL := fmt.Sprintf("%s/repos/%s/releases/%d", g.apiURL, g.repo, created.ID)
+	status, err = g.do(ctx, http.MethodPatch, releaseURL, map[string]any{"draft": false}, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("publishing draft release %s: unexpected status %d", rel.Tag, status)
+	}
+	return nil
+}
+
+func (g *github) upload(ctx context.Context, uploadURL string, a Asset) error {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p := progress.New("uploading "+a.Name, a.Size)
+	defer p.Done()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL+"?name="+url.QueryEscape(a.Name), p.Reader(f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = a.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	g.authorize(req)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// do sends a JSON API request and decodes a successful response into out.
+func (g *github) do(ctx context.Context, method, u string, in, out any) (int, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return 0, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	g.authorize(req)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s %s: %w", method, u, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("decoding %s response: %w", u, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (g *github) authorize(req *http.Request) {
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+}
@@ -0,0 +1,109 @@
+// Package publish uploads release files to distribution backends.
+//
+// Every backend supports a preflight Check that validates credentials,
+// permissions and that the release can be created without uploading
+// anything, which backs `publish -dry-run`.
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/signer"
+	"github.com/slok/sbx-images/pkg/status"
+)
+
+// Asset is a file attached to a release.
+type Asset struct {
+	Name string
+	Path string
+	Size int64
+}
+
+// Release is a set of assets published under a tag.
+type Release struct {
+	Tag    string
+	Assets []Asset
+}
+
+// Backend publishes releases to a distribution target.
+type Backend interface {
+	// Name returns the backend name (e.g. "github").
+	Name() string
+	// Check validates credentials, permissions and that rel can be
+	// published, without modifying the target.
+	Check(ctx context.Context, rel Release) error
+	// URL returns the download URL asset will be published at.
+	URL(rel Release, asset Asset) string
+	// Publish uploads rel.
+	Publish(ctx context.Context, rel Release) error
+}
+
+// Options configures the publish backends.
+type Options struct {
+	// Repo is the GitHub repository (owner/name).
+	Repo string
+	// Token is the GitHub API token.
+	Token string
+	// APIURL is the GitHub API base URL (default https://api.github.com).
+	APIURL string
+}
+
+// Backends are the supported backend names.
+var Backends = []string{"github"}
+
+// New returns the backend named name.
+func New(name string, opts Options) (Backend, error) {
+	switch name {
+	case "github":
+		return newGitHub(opts)
+	default:
+		return nil, fmt.Errorf("unknown publish backend %q (supported: %s)", name, strings.Join(Backends, ", "))
+	}
+}
+
+// ReleaseFiles returns the release of manifest.json, its signature, every
+// file the manifest references and the release status document with its
+// signature when the build dir has one.
+func ReleaseFiles(tag string, m manifest.Manifest, manifestPath, buildDir string) (Release, error) {
+	files := [][2]string{{"manifest.json", manifestPath}}
+	if m.Signing != nil {
+		sig := signer.SignatureFile(m.Signing.Backend, manifestPath)
+		files = append(files, [2]string{filepath.Base(sig), sig})
+	}
+	for _, name := range m.Files() {
+		files = append(files, [2]string{name, filepath.Join(buildDir, name)})
+	}
+
+	statusPath := filepath.Join(buildDir, status.FileName)
+	doc, err := status.Read(statusPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return Release{}, fmt.Errorf("loading status: %w", err)
+	default:
+		if doc.Version != m.Version {
+			return Release{}, fmt.Errorf("status document is for %s, not %s", doc.Version, m.Version)
+		}
+		files = append(files, [2]string{status.FileName, statusPath})
+		if doc.Signing != nil {
+			sig := signer.SignatureFile(doc.Signing.Backend, statusPath)
+			files = append(files, [2]string{filepath.Base(sig), sig})
+		}
+	}
+
+	rel := Release{Tag: tag}
+	for _, f := range files {
+		info, err := os.Stat(f[1])
+		if err != nil {
+			return Release{}, fmt.Errorf("release file %s: %w", f[0], err)
+		}
+		rel.Assets = append(rel.Assets, Asset{Name: f[0], Path: f[1], Size: info.Size()})
+	}
+	return rel, nil
+}
@@ -0,0 +1,98 @@
+// Package releases reads the published releases of a GitHub repository.
+package releases
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Asset is a file attached to a published release.
+type Asset struct {
+	Name string `json:"name"`
+	// URL is the API URL the asset is downloaded from.
+	URL string `json:"url"`
+}
+
+// Release is a published GitHub release.
+type Release struct {
+	Tag         string  `json:"tag_name"`
+	Draft       bool    `json:"draft"`
+	PublishedAt string  `json:"published_at"`
+	Assets      []Asset `json:"assets"`
+}
+
+// Asset returns the asset named name.
+func (r Release) Asset(name string) (Asset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Client reads releases from the GitHub API.
+type Client struct {
+	// APIURL is the GitHub API base URL (default https://api.github.com).
+	APIURL string
+	// Token is the optional GitHub API token.
+	Token string
+}
+
+// List returns the published (non draft) releases of repo, newest first.
+func (c Client) List(ctx context.Context, repo string) ([]Release, error) {
+	apiURL := c.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.github.com"
+	}
+
+	var releases []Release
+	for page := 1; ; page++ {
+		var rels []Release
+		u := fmt.Sprintf("%s/repos/%s/releases?per_page=100&page=%d", strings.TrimSuffix(apiURL, "/"), repo, page)
+		if err := c.get(ctx, u, "application/vnd.github+json", &rels); err != nil {
+			return nil, err
+		}
+		if len(rels) == 0 {
+			break
+		}
+		for _, rel := range rels {
+			if !rel.Draft {
+				releases = append(releases, rel)
+			}
+		}
+	}
+	return releases, nil
+}
+
+// Fetch downloads asset and decodes it as JSON into out.
+func (c Client) Fetch(ctx context.Context, asset Asset, out any) error {
+	return c.get(ctx, asset.URL, "application/octet-stream", out)
+}
+
+func (c Client) get(ctx context.Context, u, accept string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", accept)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", u, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s: %w", u, err)
+	}
+	return nil
+}
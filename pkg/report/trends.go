@@ -0,0 +1,168 @@
+// Package report aggregates release metadata across releases.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// Release is the metadata of one release used to build trends.
+type Release struct {
+	Manifest manifest.Manifest
+	// Boots are boot timing reports of the release artifacts.
+	Boots []BootReport
+}
+
+// BootReport is a boot timing report for one architecture, as written by
+// boot-matrix (boot-matrix-<arch>.json).
+type BootReport struct {
+	Arch    string `json:"arch"`
+	Results []struct {
+		Booted     bool  `json:"booted"`
+		DurationMS int64 `json:"duration_ms"`
+	} `json:"results"`
+}
+
+// Point is one release, architecture and profile sample of the time series.
+type Point struct {
+	Version         string    `json:"version"`
+	Date            time.Time `json:"date"`
+	Arch            string    `json:"arch"`
+	Profile         string    `json:"profile"`
+	KernelSizeBytes int64     `json:"kernel_size_bytes"`
+	RootfsSizeBytes int64     `json:"rootfs_size_bytes"`
+	Packages        int       `json:"packages,omitempty"`
+	// BootMS is the median time to userspace of the successful boots, zero
+	// when the release has no boot report for the architecture.
+	BootMS int64 `json:"boot_ms,omitempty"`
+	// InitP50MS and InitP95MS are the boot times to init published in the
+	// manifest (build.boot_times), AgentP50MS and AgentP95MS the times to
+	// the agent answering, zero when not measured.
+	InitP50MS  int64 `json:"init_p50_ms,omitempty"`
+	InitP95MS  int64 `json:"init_p95_ms,omitempty"`
+	AgentP50MS int64 `json:"agent_p50_ms,omitempty"`
+	AgentP95MS int64 `json:"agent_p95_ms,omitempty"`
+}
+
+// Trends returns the time series of artifact sizes and boot times of the
+// releases, ordered by build date, architecture and profile.
+func Trends(releases []Release) ([]Point, error) {
+	var points []Point
+	for _, r := range releases {
+		m := r.Manifest
+		date, err := time.Parse(time.RFC3339, m.Build.Date)
+		if err != nil {
+			return nil, fmt.Errorf("release %s: invalid build date %q: %w", m.Version, m.Build.Date, err)
+		}
+
+		for arch, a := range m.Artifacts {
+			for i, rootfs := range a.Rootfses() {
+				p := Point{
+					Version:         m.Version,
+					Date:            date,
+					Arch:            arch,
+					Profile:         rootfs.Profile,
+					KernelSizeBytes: a.Kernel.SizeBytes,
+					RootfsSizeBytes: rootfs.SizeBytes,
+					Packages:        len(rootfs.Packages),
+				}
+				// The boot reports boot the default profile.
+				if i == 0 {
+					p.BootMS = medianBootMS(r.Boots, arch)
+				}
+				for _, bt := range m.Build.BootTimes {
+					if bt.Arch != arch || bt.Profile != rootfs.Profile {
+						continue
+					}
+					p.InitP50MS, p.InitP95MS = bt.TimeToInit.P50MS, bt.TimeToInit.P95MS
+					if bt.TimeToAgent != nil {
+						p.AgentP50MS, p.AgentP95MS = bt.TimeToAgent.P50MS, bt.TimeToAgent.P95MS
+					}
+				}
+				points = append(points, p)
+			}
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		pi, pj := points[i], points[j]
+		if !pi.Date.Equal(pj.Date) {
+			return pi.Date.Before(pj.Date)
+		}
+		if pi.Arch != pj.Arch {
+			return pi.Arch < pj.Arch
+		}
+		return pi.Profile < pj.Profile
+	})
+	return points, nil
+}
+
+func medianBootMS(reports []BootReport, arch string) int64 {
+	var durations []int64
+	for _, r := range reports {
+		if r.Arch != arch {
+			continue
+		}
+		for _, res := range r.Results {
+			if res.Booted {
+				durations = append(durations, res.DurationMS)
+			}
+		}
+	}
+	if len(durations) == 0 {
+		return 0
+	}
+	slices.Sort(durations)
+	return durations[len(durations)/2]
+}
+
+// WriteJSON writes the points as an indented JSON array.
+func WriteJSON(w io.Writer, points []Point) error {
+	if points == nil {
+		points = []Point{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(points)
+}
+
+// WriteCSV writes the points as CSV with a header row.
+func WriteCSV(w io.Writer, points []Point) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"version", "date", "arch", "profile", "kernel_size_bytes", "rootfs_size_bytes", "packages", "boot_ms",
+		"init_p50_ms", "init_p95_ms", "agent_p50_ms", "agent_p95_ms"})
+	for _, p := range points {
+		_ = cw.Write([]string{
+			p.Version,
+			p.Date.UTC().Format(time.RFC3339),
+			p.Arch,
+			p.Profile,
+			strconv.FormatInt(p.KernelSizeBytes, 10),
+			strconv.FormatInt(p.RootfsSizeBytes, 10),
+			strconv.Itoa(p.Packages),
+			optionalMS(p.BootMS),
+			optionalMS(p.InitP50MS),
+			optionalMS(p.InitP95MS),
+			optionalMS(p.AgentP50MS),
+			optionalMS(p.AgentP95MS),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// optionalMS formats a time in milliseconds, empty when not measured.
+func optionalMS(ms int64) string {
+	if ms <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(ms, 10)
+}
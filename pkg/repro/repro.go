@@ -0,0 +1,309 @@
+// Package repro compares rebuilt release artifacts with the published ones
+// and explains why diverging artifacts differ.
+package repro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// Result is the comparison of a published and a rebuilt artifact.
+type Result struct {
+	Artifact        string `json:"artifact"`
+	Reproduced      bool   `json:"reproduced"`
+	PublishedSHA256 string `json:"published_sha256"`
+	RebuiltSHA256   string `json:"rebuilt_sha256,omitempty"`
+	// Reasons explain why a diverged artifact differs, when known.
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Report is the reproducibility report of a release.
+type Report struct {
+	Version         string   `json:"version"`
+	Commit          string   `json:"commit"`
+	SourceDateEpoch int64    `json:"source_date_epoch,omitempty"`
+	Results         []Result `json:"results"`
+	// Toolchain lists the tools whose versions differ between the builds.
+	Toolchain []string `json:"toolchain,omitempty"`
+}
+
+// Diverged returns the number of artifacts that did not reproduce.
+func (r Report) Diverged() int {
+	n := 0
+	for _, res := range r.Results {
+		if !res.Reproduced {
+			n++
+		}
+	}
+	return n
+}
+
+// Compare compares the artifacts of the published manifest with the rebuilt
+// one. Diverged artifacts are diagnosed when both files are available in
+// publishedDir and rebuiltDir.
+func Compare(published, rebuilt manifest.Manifest, publishedDir, rebuiltDir string) Report {
+	r := Report{
+		Version:         published.Version,
+		Commit:          published.Build.Commit,
+		SourceDateEpoch: published.Build.SourceDateEpoch,
+		Toolchain:       ToolchainDiff(published.Build.Toolchain, rebuilt.Build.Toolchain),
+	}
+
+	// compare adds the result of a published file, digest and rebuilt
+	// digest triple, ok when the rebuilt manifest has its artifacts.
+	compare := func(p [3]string, ok bool) {
+		res := Result{Artifact: p[0], PublishedSHA256: p[1], RebuiltSHA256: p[2]}
+		switch {
+		case !ok || p[2] == "":
+			res.Reasons = []string{"not rebuilt"}
+		case p[1] == p[2]:
+			res.Reproduced = true
+		default:
+			reasons, err := Diagnose(filepath.Join(publishedDir, p[0]), filepath.Join(rebuiltDir, p[0]))
+			if err != nil {
+				reasons = []string{fmt.Sprintf("not diagnosed: %v", err)}
+			}
+			res.Reasons = reasons
+		}
+		r.Results = append(r.Results, res)
+	}
+
+	archs := make([]string, 0, len(published.Artifacts))
+	for arch := range published.Artifacts {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	for _, arch := range archs {
+		pa := published.Artifacts[arch]
+		ra, ok := rebuilt.Artifacts[arch]
+		var pairs [][3]string
+		for _, k := range pa.Kernels() {
+			rk, _ := ra.KernelFlavor(k.Flavor)
+			pairs = append(pairs, [3]string{k.File, k.SHA256, rk.SHA256})
+			for _, img := range k.Images {
+				rimg, _ := rk.Image(img.Format, arch)
+				pairs = append(pairs, [3]string{img.File, img.SHA256, rimg.SHA256})
+			}
+		}
+		for _, rootfs := range pa.Rootfses() {
+			rr, _ := ra.RootfsProfile(rootfs.Profile)
+			pairs = append(pairs, [3]string{rootfs.File, rootfs.SHA256, rr.SHA256})
+			for _, img := range rootfs.ExtraImages() {
+				var rebuilt string
+				for _, rimg := range rr.ExtraImages() {
+					if rimg.File == img.File {
+						rebuilt = rimg.SHA256
+					}
+				}
+				pairs = append(pairs, [3]string{img.File, img.SHA256, rebuilt})
+			}
+		}
+		if pa.Initramfs != nil {
+			var rebuilt string
+			if ra.Initramfs != nil {
+				rebuilt = ra.Initramfs.SHA256
+			}
+			pairs = append(pairs, [3]string{pa.Initramfs.File, pa.Initramfs.SHA256, rebuilt})
+		}
+		for _, p := range pairs {
+			compare(p, ok)
+		}
+	}
+	for _, d := range published.Disks {
+		var rebuiltSHA256 string
+		for _, rd := range rebuilt.Disks {
+			if rd.File == d.File {
+				rebuiltSHA256 = rd.SHA256
+			}
+		}
+		compare([3]string{d.File, d.SHA256, rebuiltSHA256}, true)
+	}
+	return r
+}
+
+// ToolchainDiff returns the tools whose recorded versions differ, as
+// "tool: published -> rebuilt".
+func ToolchainDiff(published, rebuilt map[string]string) []string {
+	tools := map[string]bool{}
+	for t := range published {
+		tools[t] = true
+	}
+	for t := range rebuilt {
+		tools[t] = true
+	}
+
+	var diff []string
+	for t := range tools {
+		if published[t] != rebuilt[t] {
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", t, orNone(published[t]), orNone(rebuilt[t])))
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func orNone(v string) string {
+	if v == "" {
+		return "(none)"
+	}
+	return v
+}
+
+// ext4 superblock layout (the superblock starts at byte 1024).
+const (
+	superblockOffset = 1024
+	ext4Magic        = 0xEF53
+	blockSize        = 4096
+)
+
+var ext4Timestamps = []struct {
+	name   string
+	offset int
+}{
+	{"mount time", 0x2C},
+	{"write time", 0x30},
+	{"last check time", 0x40},
+	{"creation time", 0x108},
+}
+
+// Diagnose explains why the files at published and rebuilt differ: size,
+// ext4 superblock fields (UUID, hash seed, timestamps), the kernel version
+// banner and how many 4 KiB blocks differ.
+func Diagnose(published, rebuilt string) ([]string, error) {
+	pf, err := os.Open(published)
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+	rf, err := os.Open(rebuilt)
+	if err != nil {
+		return nil, err
+	}
+	defer rf.Close()
+
+	var reasons []string
+	pi, err := pf.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ri, err := rf.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if pi.Size() != ri.Size() {
+		reasons = append(reasons, fmt.Sprintf("size differs (%d -> %d bytes)", pi.Size(), ri.Size()))
+	}
+
+	isExt4 := false
+	diffBlocks, blocks := 0, 0
+	pb, rb := make([]byte, blockSize), make([]byte, blockSize)
+	for {
+		pn, perr := io.ReadFull(pf, pb)
+		rn, rerr := io.ReadFull(rf, rb)
+		if pn == 0 && rn == 0 {
+			break
+		}
+		if blocks == 0 {
+			_, isExt4 = superblock(pb[:pn])
+			reasons = append(reasons, superblockDiff(pb[:pn], rb[:rn])...)
+		}
+		if !bytes.Equal(pb[:pn], rb[:rn]) {
+			diffBlocks++
+		}
+		blocks++
+		if err := readErr(perr); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", published, err)
+		}
+		if err := readErr(rerr); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", rebuilt, err)
+		}
+	}
+
+	// Kernels are small enough to search whole for the version banner.
+	if !isExt4 {
+		pBanner, err := fileBanner(published)
+		if err != nil {
+			return nil, err
+		}
+		rBanner, err := fileBanner(rebuilt)
+		if err != nil {
+			return nil, err
+		}
+		if pBanner != nil && !bytes.Equal(pBanner, rBanner) {
+			reasons = append(reasons, fmt.Sprintf("kernel version banner differs (%q -> %q)", pBanner, rBanner))
+		}
+	}
+	reasons = append(reasons, fmt.Sprintf("%d of %d 4 KiB blocks differ", diffBlocks, blocks))
+	return reasons, nil
+}
+
+func readErr(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	return err
+}
+
+// superblockDiff compares the ext4 superblocks in the first blocks of two
+// images.
+func superblockDiff(published, rebuilt []byte) []string {
+	ps, ok := superblock(published)
+	if !ok {
+		return nil
+	}
+	rs, ok := superblock(rebuilt)
+	if !ok {
+		return nil
+	}
+
+	var reasons []string
+	if !bytes.Equal(ps[0x68:0x78], rs[0x68:0x78]) {
+		reasons = append(reasons, "filesystem UUID differs")
+	}
+	if !bytes.Equal(ps[0xEC:0xFC], rs[0xEC:0xFC]) {
+		reasons = append(reasons, "directory hash seed differs")
+	}
+	for _, ts := range ext4Timestamps {
+		pt := binary.LittleEndian.Uint32(ps[ts.offset:])
+		rt := binary.LittleEndian.Uint32(rs[ts.offset:])
+		if pt != rt {
+			reasons = append(reasons, fmt.Sprintf("superblock %s differs (%d -> %d)", ts.name, pt, rt))
+		}
+	}
+	return reasons
+}
+
+func superblock(block []byte) ([]byte, bool) {
+	if len(block) < superblockOffset+1024 {
+		return nil, false
+	}
+	sb := block[superblockOffset : superblockOffset+1024]
+	return sb, binary.LittleEndian.Uint16(sb[0x38:]) == ext4Magic
+}
+
+// fileBanner returns the "Linux version ..." line of the kernel at path, if
+// any.
+func fileBanner(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.Index(b, []byte("Linux version "))
+	if i < 0 {
+		return nil, nil
+	}
+	line := b[i:]
+	if end := bytes.IndexAny(line, "\n\x00"); end >= 0 {
+		line = line[:end]
+	}
+	return line, nil
+}
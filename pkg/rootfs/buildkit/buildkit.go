@@ -0,0 +1,320 @@
+// Package buildkit builds the base rootfs of images with BuildKit, the
+// profile packages included, as a tar archive that build-rootfs.sh turns
+// into the image (rootfs.bootstrap: buildkit).
+//
+// The build is a Dockerfile, solved by the BuildKit dockerfile frontend into
+// LLB: a stage from the distribution container image, then one stage per
+// profile of the extends chain installing the packages it adds to its
+// parent (and removing the ones it drops). The profiles of a chain share
+// their parent stages, so rebuilding a profile or building a sibling only
+// runs its own package stage, and profiles built concurrently against the
+// same BuildKit daemon (cmd/build -jobs) solve their common stages once and
+// install their own packages in parallel. The package manager caches are
+// BuildKit cache mounts, kept by the daemon between builds, and the layer
+// cache can be imported and exported (CacheFrom, CacheTo) to share it
+// between builders, e.g. through a registry.
+//
+// The builder is buildctl against a BuildKit daemon (BUILDKIT_HOST) or
+// docker buildx. The exported filesystem is fixed up like the OCI images
+// base rootfs (pkg/rootfs/oci).
+package buildkit
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/slok/sbx-images/pkg/distro"
+	"github.com/slok/sbx-images/pkg/rootfs/oci"
+)
+
+// Builders.
+const (
+	// Auto uses buildctl when BUILDKIT_HOST is set, docker buildx
+	// otherwise.
+	Auto     = "auto"
+	Buildctl = "buildctl"
+	Buildx   = "buildx"
+)
+
+// Builders are the supported builder names.
+var Builders = []string{Auto, Buildctl, Buildx}
+
+// Layer is a profile of the extends chain, built as its own stage.
+type Layer struct {
+	// Name is the profile name.
+	Name string
+	// Packages are the distribution package names of the profile
+	// definition.
+	Packages []string
+}
+
+// Options configures a base rootfs build.
+type Options struct {
+	// Distro is the distribution, its package manager installs the
+	// packages.
+	Distro distro.Distro
+	// Image is the distribution container image the rootfs is built from,
+	// see Image.
+	Image string
+	// Arch is the build architecture name (e.g. "x86_64"). Foreign
+	// architectures need qemu-user binfmt handlers on the BuildKit host.
+	Arch string
+	// Layers is the extends chain of the profile, the root profile first
+	// and the built profile last.
+	Layers []Layer
+	// Builder is the BuildKit client (default: Auto).
+	Builder string
+	// CacheFrom and CacheTo are the BuildKit cache import and export specs
+	// (e.g. type=registry,ref=ghcr.io/org/sbx-cache), none when empty.
+	CacheFrom string
+	CacheTo   string
+	// Proxy is the HTTP proxy of the package downloads, passed as the
+	// http_proxy build argument, not kept in the image.
+	Proxy string
+	// Log receives the build output (default: discarded).
+	Log io.Writer
+}
+
+// Result is a built base rootfs.
+type Result struct {
+	// Tool and ToolVersion are the BuildKit client that built the rootfs.
+	Tool        string
+	ToolVersion string
+	Image       string
+	Packages    []string
+	Duration    time.Duration
+}
+
+// Image returns the container image of version of d.
+func Image(d distro.Distro, version string) (string, error) {
+	switch d.Name() {
+	case "alpine":
+		return "alpine:" + version, nil
+	case "debian":
+		return "debian:" + d.Release(version), nil
+	default:
+		return "", fmt.Errorf("no container image for distro %s", d.Name())
+	}
+}
+
+// Stage returns the Dockerfile stage name of a profile layer.
+func Stage(profile string) string { return "profile-" + profile }
+
+// Dockerfile returns the Dockerfile building the last of layers from image,
+// each layer a stage on top of the previous one.
+func Dockerfile(d distro.Distro, image, arch string, layers []Layer) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by pkg/rootfs/buildkit for the %s %s profile.\n\n", arch, layers[len(layers)-1].Name)
+	fmt.Fprintf(&b, "FROM %s AS base\n", image)
+	if d.PackageType() == "deb" {
+		// The image cleans the apt cache after every install, kept in a
+		// cache mount instead.
+		b.WriteString("RUN rm -f /etc/apt/apt.conf.d/docker-clean\n")
+	}
+
+	parent, installed := "base", []string(nil)
+	for _, l := range layers {
+		var add, del []string
+		for _, p := range l.Packages {
+			if !slices.Contains(installed, p) {
+				add = append(add, p)
+			}
+		}
+		for _, p := range installed {
+			if !slices.Contains(l.Packages, p) {
+				del = append(del, p)
+			}
+		}
+		fmt.Fprintf(&b, "\nFROM %s AS %s\n", parent, Stage(l.Name))
+		if len(add) > 0 || len(del) > 0 {
+			run, err := install(d, arch, add, del)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(run + "\n")
+		}
+		parent, installed = Stage(l.Name), l.Packages
+	}
+	return b.String(), nil
+}
+
+// install returns the RUN instruction adding and removing packages, the
+// package manager cache a cache mount shared by the builds of arch.
+func install(d distro.Distro, arch string, add, del []string) (string, error) {
+	var mounts, cmds []string
+	switch d.PackageType() {
+	case "apk":
+		mounts = []string{"--mount=type=cache,id=sbx-apk-" + arch + ",target=/var/cache/apk,sharing=locked"}
+		if len(add) > 0 {
+			cmds = append(cmds, "apk add --cache-dir /var/cache/apk "+strings.Join(add, " "))
+		}
+		if len(del) > 0 {
+			cmds = append(cmds, "apk del "+strings.Join(del, " "))
+		}
+	case "deb":
+		mounts = []string{
+			"--mount=type=cache,id=sbx-apt-" + arch + ",target=/var/cache/apt,sharing=locked",
+			"--mount=type=cache,id=sbx-apt-lists-" + arch + ",target=/var/lib/apt/lists,sharing=locked",
+		}
+		if len(add) > 0 {
+			cmds = append(cmds, "apt-get update -q",
+				"DEBIAN_FRONTEND=noninteractive apt-get install -q -y --no-install-recommends "+strings.Join(add, " "))
+		}
+		if len(del) > 0 {
+			cmds = append(cmds, "DEBIAN_FRONTEND=noninteractive apt-get purge -q -y --autoremove "+strings.Join(del, " "))
+		}
+	default:
+		return "", fmt.Errorf("unsupported package type %s", d.PackageType())
+	}
+	return "RUN " + strings.Join(mounts, " \\\n    ") + " \\\n    " + strings.Join(cmds, " \\\n    && "), nil
+}
+
+// Build builds the base rootfs described by opts into the tar archive out.
+func Build(ctx context.Context, opts Options, out string) (*Result, error) {
+	start := time.Now()
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+	if len(opts.Layers) == 0 {
+		return nil, fmt.Errorf("no profile layers")
+	}
+	platform, err := oci.Platform(opts.Arch)
+	if err != nil {
+		return nil, err
+	}
+	builder, err := Builder(opts.Builder)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerfile, err := Dockerfile(opts.Distro, opts.Image, opts.Arch, opts.Layers)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "sbx-rootfs-buildkit-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0o644); err != nil {
+		return nil, err
+	}
+
+	res := &Result{
+		Tool:     builder,
+		Image:    opts.Image,
+		Packages: opts.Layers[len(opts.Layers)-1].Packages,
+	}
+	if res.ToolVersion, err = version(ctx, builder); err != nil {
+		return nil, err
+	}
+
+	// The exported filesystem is fixed up into out.
+	exported := filepath.Join(dir, "rootfs.tar")
+	target := Stage(opts.Layers[len(opts.Layers)-1].Name)
+	var args []string
+	switch builder {
+	case Buildctl:
+		args = []string{"buildctl", "build", "--progress", "plain", "--frontend", "dockerfile.v0",
+			"--local", "context=" + dir, "--local", "dockerfile=" + dir,
+			"--opt", "target=" + target, "--opt", "platform=" + platform.String(),
+			"--output", "type=tar,dest=" + exported}
+		if opts.Proxy != "" {
+			args = append(args, "--opt", "build-arg:http_proxy="+opts.Proxy)
+		}
+		if opts.CacheFrom != "" {
+			args = append(args, "--import-cache", opts.CacheFrom)
+		}
+		if opts.CacheTo != "" {
+			args = append(args, "--export-cache", opts.CacheTo)
+		}
+	case Buildx:
+		args = []string{"docker", "buildx", "build", "--progress", "plain",
+			"--file", filepath.Join(dir, "Dockerfile"), "--target", target, "--platform", platform.String(),
+			"--output", "type=tar,dest=" + exported}
+		if opts.Proxy != "" {
+			args = append(args, "--build-arg", "http_proxy="+opts.Proxy)
+		}
+		if opts.CacheFrom != "" {
+			args = append(args, "--cache-from", opts.CacheFrom)
+		}
+		if opts.CacheTo != "" {
+			args = append(args, "--cache-to", opts.CacheTo)
+		}
+		args = append(args, dir)
+	}
+	fmt.Fprintf(opts.Log, "%s\n", strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = opts.Log
+	cmd.Stderr = opts.Log
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("building %s with %s: %w", target, builder, err)
+	}
+
+	layer, err := tarball.LayerFromFile(exported)
+	if err != nil {
+		return nil, err
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		return nil, err
+	}
+	if err := oci.Export(img, out); err != nil {
+		return nil, fmt.Errorf("exporting %s: %w", target, err)
+	}
+
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// Builder returns the BuildKit client of name, detected for Auto.
+func Builder(name string) (string, error) {
+	switch cmp.Or(name, Auto) {
+	case Auto:
+		if _, err := exec.LookPath("buildctl"); err == nil && os.Getenv("BUILDKIT_HOST") != "" {
+			return Buildctl, nil
+		}
+		if _, err := exec.LookPath("docker"); err == nil {
+			return Buildx, nil
+		}
+		return "", fmt.Errorf("no BuildKit client found: install docker buildx, or buildctl and set BUILDKIT_HOST")
+	case Buildctl, Buildx:
+		return name, nil
+	default:
+		return "", fmt.Errorf("unknown BuildKit builder %q (supported: %s)", name, strings.Join(Builders, ", "))
+	}
+}
+
+// version returns the version of the builder client, the first v<digit>
+// field of its version output.
+func version(ctx context.Context, builder string) (string, error) {
+	args := []string{"buildctl", "--version"}
+	if builder == Buildx {
+		args = []string{"docker", "buildx", "version"}
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w", strings.Join(args, " "), err)
+	}
+	for _, f := range strings.Fields(out.String()) {
+		if len(f) > 1 && f[0] == 'v' && f[1] >= '0' && f[1] <= '9' {
+			return f, nil
+		}
+	}
+	return "unknown", nil
+}
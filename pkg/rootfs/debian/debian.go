@@ -0,0 +1,331 @@
+// Package debian builds the base rootfs of Debian images with mmdebstrap or
+// debootstrap, the packages of the profile included, as a tar archive that
+// build-rootfs.sh turns into the image.
+//
+// The bootstrap tool runs in a throwaway container (pkg/container, Docker
+// or Podman) of the suite being built, so the host needs neither the tool nor the Debian
+// archive keyring, or on the host without a container runtime. Each build
+// step reports a structured Error with the command, exit code and the tail
+// of its output, which is also streamed to the Options log.
+package debian
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/container"
+	"github.com/slok/sbx-images/pkg/distro"
+)
+
+// DefaultMirror is the Debian archive the packages are installed from. It is
+// plain HTTP (the packages are verified with the archive keyring) so caching
+// apt proxies can serve it.
+const DefaultMirror = "http://deb.debian.org/debian"
+
+// DefaultVariant is the package selection the requested packages are added
+// to: the essential and required packages only.
+const DefaultVariant = "minbase"
+
+// Arch returns the Debian architecture of a build architecture name.
+func Arch(arch string) (string, error) {
+	switch arch {
+	case "x86_64":
+		return "amd64", nil
+	case "aarch64":
+		return "arm64", nil
+	case "riscv64":
+		// An official architecture since trixie (13), ports before.
+		return "riscv64", nil
+	default:
+		return "", fmt.Errorf("unsupported Debian architecture %q", arch)
+	}
+}
+
+// MergePackages merges package lists in order, dropping the duplicates. The
+// lists are Debian package names (see distro.Distro.Packages).
+func MergePackages(lists ...[]string) []string {
+	var merged []string
+	for _, list := range lists {
+		for _, p := range list {
+			if p != "" && !slices.Contains(merged, p) {
+				merged = append(merged, p)
+			}
+		}
+	}
+	return merged
+}
+
+// Options configures a base rootfs build.
+type Options struct {
+	// Bootstrap is the tool building the rootfs: distro.BootstrapMmdebstrap
+	// (default) or distro.BootstrapDebootstrap.
+	Bootstrap string
+	// Suite is the Debian suite (e.g. "bookworm").
+	Suite string
+	// Arch is the build architecture name (e.g. "x86_64"). Foreign
+	// architectures need qemu-user binfmt handlers on the host.
+	Arch string
+	// Mirror is the Debian archive (default: DefaultMirror).
+	Mirror string
+	// Variant is the bootstrap package selection (default: DefaultVariant).
+	Variant string
+	// Packages are installed on top of the variant, see MergePackages.
+	Packages []string
+	// Proxy is the HTTP proxy apt downloads through (e.g. an apt-cacher-ng
+	// URL). It is only used by the build, the image apt configuration does
+	// not keep it.
+	Proxy string
+	// Runtime is the container engine running the tool, nil to run it on
+	// the host.
+	Runtime container.Runtime
+	// Image is the container image the tool is installed in (default:
+	// debian:<suite>).
+	Image string
+	// Log receives the output of the build steps (default: discarded).
+	Log io.Writer
+}
+
+// Result is a built base rootfs.
+type Result struct {
+	// Tool and ToolVersion are the bootstrap tool that built the rootfs.
+	Tool        string
+	ToolVersion string
+	Suite       string
+	// Arch is the Debian architecture.
+	Arch     string
+	Packages []string
+	Duration time.Duration
+}
+
+// Error is a failed build step.
+type Error struct {
+	// Step is the build step: "start", "prepare", "version", "bootstrap" or
+	// "archive".
+	Step    string
+	Command []string
+	// ExitCode is the exit code of the command, -1 when it did not exit.
+	ExitCode int
+	// Output is the tail of the command output.
+	Output string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s step: %s: %v", e.Step, e.Command[0], e.Err)
+	if e.Output != "" {
+		msg += ": " + e.Output[strings.LastIndex(e.Output, "\n")+1:]
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// outputTailLines is the number of output lines kept in Error.Output.
+const outputTailLines = 20
+
+// Build builds the base rootfs described by opts into the tar archive out.
+func Build(ctx context.Context, opts Options, out string) (*Result, error) {
+	start := time.Now()
+	opts.Bootstrap = cmp.Or(opts.Bootstrap, distro.BootstrapMmdebstrap)
+	opts.Mirror = cmp.Or(opts.Mirror, DefaultMirror)
+	opts.Variant = cmp.Or(opts.Variant, DefaultVariant)
+	opts.Image = cmp.Or(opts.Image, "debian:"+opts.Suite)
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+	if opts.Suite == "" {
+		return nil, fmt.Errorf("a suite is required")
+	}
+	if opts.Bootstrap != distro.BootstrapMmdebstrap && opts.Bootstrap != distro.BootstrapDebootstrap {
+		return nil, fmt.Errorf("unknown bootstrap %q", opts.Bootstrap)
+	}
+	arch, err := Arch(opts.Arch)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(out); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	r := &runner{opts: opts}
+	if opts.Runtime != nil {
+		if err := r.start(ctx, filepath.Dir(out)); err != nil {
+			return nil, err
+		}
+		defer r.stop()
+		out = "/out/" + filepath.Base(out)
+	}
+
+	res := &Result{
+		Tool:     opts.Bootstrap,
+		Suite:    opts.Suite,
+		Arch:     arch,
+		Packages: MergePackages(opts.Packages),
+	}
+	if res.ToolVersion, err = r.version(ctx); err != nil {
+		return nil, err
+	}
+
+	switch opts.Bootstrap {
+	case distro.BootstrapMmdebstrap:
+		args := []string{"mmdebstrap", "--variant=" + opts.Variant, "--architectures=" + arch}
+		if len(res.Packages) > 0 {
+			args = append(args, "--include="+strings.Join(res.Packages, ","))
+		}
+		if opts.Proxy != "" {
+			args = append(args, fmt.Sprintf("--aptopt=Acquire::http::Proxy %q", opts.Proxy))
+		}
+		args = append(args, opts.Suite, out, opts.Mirror)
+		if _, err := r.run(ctx, "bootstrap", args...); err != nil {
+			return nil, err
+		}
+	case distro.BootstrapDebootstrap:
+		// debootstrap only writes directories, archived afterwards.
+		dir := out + ".d"
+		args := []string{"debootstrap", "--variant=" + opts.Variant, "--arch=" + arch}
+		if len(res.Packages) > 0 {
+			args = append(args, "--include="+strings.Join(res.Packages, ","))
+		}
+		args = append(args, opts.Suite, dir, opts.Mirror)
+		if _, err := r.run(ctx, "bootstrap", args...); err != nil {
+			return nil, err
+		}
+		if _, err := r.run(ctx, "archive", "sh", "-c", fmt.Sprintf("tar --numeric-owner --xattrs -C %q -cf %q . && rm -rf %q", dir, out, dir)); err != nil {
+			return nil, err
+		}
+	}
+
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// runner runs the build steps on the host or in the build container.
+type runner struct {
+	opts      Options
+	container string
+}
+
+// start starts the build container with outDir mounted at /out and installs
+// the bootstrap tool.
+func (r *runner) start(ctx context.Context, outDir string) error {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	name := "sbx-rootfs-debian-" + hex.EncodeToString(id)
+
+	// Privileged, the tools mount proc and create device nodes in the tree.
+	args := append([]string{r.opts.Runtime.Name(), "run", "--detach", "--rm", "--privileged", "--name", name}, r.opts.Runtime.Volume(outDir, "/out", false)...)
+	for _, env := range r.proxyEnv() {
+		args = append(args, "--env", env)
+	}
+	args = append(args, "--entrypoint", "sleep", r.opts.Image, "infinity")
+	if _, err := r.exec(ctx, "start", args); err != nil {
+		return err
+	}
+	r.container = name
+
+	_, err := r.run(ctx, "prepare", "sh", "-c",
+		"apt-get update -q && DEBIAN_FRONTEND=noninteractive apt-get install -q -y --no-install-recommends ca-certificates "+r.opts.Bootstrap)
+	return err
+}
+
+// stop removes the build container.
+func (r *runner) stop() {
+	if r.container == "" {
+		return
+	}
+	_ = exec.Command(r.opts.Runtime.Name(), "rm", "--force", r.container).Run()
+}
+
+// version returns the bootstrap tool version.
+func (r *runner) version(ctx context.Context) (string, error) {
+	var out string
+	var err error
+	if r.opts.Bootstrap == distro.BootstrapMmdebstrap {
+		out, err = r.run(ctx, "version", "mmdebstrap", "--version")
+	} else {
+		// debootstrap has no version flag, its package has.
+		out, err = r.run(ctx, "version", "sh", "-c", "dpkg-query -W -f '${Version}' debootstrap 2>/dev/null || echo unknown")
+	}
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "unknown", nil
+	}
+	return fields[len(fields)-1], nil
+}
+
+// run runs a step command, in the build container when started.
+func (r *runner) run(ctx context.Context, step string, args ...string) (string, error) {
+	if r.container != "" {
+		return r.exec(ctx, step, append([]string{r.opts.Runtime.Name(), "exec", r.container}, args...))
+	}
+	env := []string{}
+	if step == "bootstrap" {
+		env = r.proxyEnv()
+	}
+	return r.execEnv(ctx, step, args, env)
+}
+
+func (r *runner) exec(ctx context.Context, step string, args []string) (string, error) {
+	return r.execEnv(ctx, step, args, nil)
+}
+
+// execEnv runs args with the extra environment, streaming the output to the
+// log.
+func (r *runner) execEnv(ctx context.Context, step string, args, env []string) (string, error) {
+	fmt.Fprintf(r.opts.Log, "[%s] %s\n", step, strings.Join(args, " "))
+
+	var output bytes.Buffer
+	w := io.MultiWriter(r.opts.Log, &output)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		e := &Error{Step: step, Command: args, ExitCode: -1, Output: tail(output.String(), outputTailLines), Err: err}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			e.ExitCode = exitErr.ExitCode()
+		}
+		return "", e
+	}
+	return output.String(), nil
+}
+
+// proxyEnv returns the proxy environment of the tools and apt.
+func (r *runner) proxyEnv() []string {
+	if r.opts.Proxy == "" {
+		return nil
+	}
+	return []string{"http_proxy=" + r.opts.Proxy, "HTTP_PROXY=" + r.opts.Proxy}
+}
+
+// tail returns the last n lines of s.
+func tail(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
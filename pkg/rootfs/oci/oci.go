@@ -0,0 +1,166 @@
+// Package oci builds the base rootfs of images from OCI container images:
+// the platform image is pulled, its layers flattened (whiteouts applied) and
+// fixed up for booting as a sandbox root filesystem, into a tar archive that
+// build-rootfs.sh turns into the image.
+//
+// The container runtime leftovers are dropped (/.dockerenv and the resolver
+// configuration bind mounted at run time), /etc/fstab mounts the root device
+// and the mount points the init system needs are created. The init system
+// and the other requested packages are installed afterwards by
+// build-rootfs.sh with the distro package manager.
+package oci
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Fstab is the /etc/fstab of the images, the root filesystem is the first
+// virtio block device.
+const Fstab = "# Managed by sbx.\n/dev/vda\t/\text4\tdefaults\t0\t1\n"
+
+// droppedFiles are the container runtime files removed from the images.
+var droppedFiles = []string{".dockerenv", ".dockerinit", "etc/resolv.conf", "etc/fstab"}
+
+// mountPoints are created when the image lacks them, with their mode.
+var mountPoints = []struct {
+	Path string
+	Mode int64
+}{
+	{"dev", 0o755},
+	{"proc", 0o555},
+	{"sys", 0o555},
+	{"run", 0o755},
+	{"tmp", 0o1777},
+}
+
+// Platform returns the OCI platform of a build architecture name.
+func Platform(arch string) (v1.Platform, error) {
+	switch arch {
+	case "x86_64":
+		return v1.Platform{OS: "linux", Architecture: "amd64"}, nil
+	case "aarch64":
+		return v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, nil
+	case "riscv64":
+		return v1.Platform{OS: "linux", Architecture: "riscv64"}, nil
+	default:
+		return v1.Platform{}, fmt.Errorf("unsupported OCI platform architecture %q", arch)
+	}
+}
+
+// Source is a pulled container image.
+type Source struct {
+	// Image is the pulled reference.
+	Image string
+	// Digest is the platform image manifest digest.
+	Digest   string
+	Platform string
+}
+
+// Pull fetches the arch image of ref, with the registry credentials of the
+// docker config (docker login).
+func Pull(ctx context.Context, ref, arch string) (v1.Image, Source, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, Source{}, err
+	}
+	platform, err := Platform(arch)
+	if err != nil {
+		return nil, Source{}, err
+	}
+
+	img, err := remote.Image(r, remote.WithContext(ctx), remote.WithPlatform(platform), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return nil, Source{}, fmt.Errorf("pulling %s: %w", ref, err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, Source{}, fmt.Errorf("pulling %s: %w", ref, err)
+	}
+	return img, Source{Image: ref, Digest: digest.String(), Platform: platform.String()}, nil
+}
+
+// Export writes the flattened and fixed up filesystem of img to the tar
+// archive out.
+func Export(img v1.Image, out string) (err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(out)
+		}
+	}()
+
+	fs := mutate.Extract(img)
+	defer fs.Close()
+
+	tr := tar.NewReader(fs)
+	tw := tar.NewWriter(f)
+	seen := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading image layers: %w", err)
+		}
+		p := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if p == "" || slices.Contains(droppedFiles, p) {
+			continue
+		}
+		seen[p] = true
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	// The added files are dated SOURCE_DATE_EPOCH, for reproducible images.
+	now := time.Unix(0, 0)
+	if sec, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		now = time.Unix(sec, 0)
+	}
+	for _, m := range mountPoints {
+		if seen[m.Path] {
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: m.Path + "/", Mode: m.Mode, ModTime: now}); err != nil {
+			return err
+		}
+	}
+	if !seen["etc"] {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0o755, ModTime: now}); err != nil {
+			return err
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "etc/fstab", Mode: 0o644, Size: int64(len(Fstab)), ModTime: now}); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(tw, Fstab); err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,82 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// apiClient talks to the Firecracker API on its Unix socket, the calls the
+// firecracker-go-sdk handlers make to configure and start a machine.
+type apiClient struct {
+	http *http.Client
+}
+
+func newAPIClient(socket string) *apiClient {
+	return &apiClient{http: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+		Timeout: 10 * time.Second,
+	}}
+}
+
+// put sends body as JSON to the API path, failing on the fault responses.
+func (c *apiClient) put(ctx context.Context, path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://localhost"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("PUT %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var fault struct {
+			FaultMessage string `json:"fault_message"`
+		}
+		msg, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(msg, &fault) == nil && fault.FaultMessage != "" {
+			msg = []byte(fault.FaultMessage)
+		}
+		return fmt.Errorf("PUT %s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// action is a Firecracker instance action (InstanceStart, SendCtrlAltDel).
+type action struct {
+	ActionType string `json:"action_type"`
+}
+
+// waitSocket waits until the API socket accepts connections.
+func waitSocket(ctx context.Context, socket string, exited <-chan struct{}) error {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			return conn.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for the API socket: %w", ctx.Err())
+		case <-exited:
+			return fmt.Errorf("firecracker exited before serving its API")
+		case <-tick.C:
+		}
+	}
+}
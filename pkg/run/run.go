@@ -0,0 +1,285 @@
+// Package run boots release images as Firecracker microVMs from Go, for
+// hosts using the releases as a sandbox toolkit: a Launcher boots a release
+// selection (export.Select), the drives, boot args, initramfs and vsock
+// device wired from the manifest metadata as for cmd/vmconfig.
+//
+// The machine is configured through the Firecracker API socket the way the
+// firecracker-go-sdk handlers do it (boot source, drives, machine config,
+// network interfaces, vsock and entropy devices, then InstanceStart). The
+// SDK itself is deliberately not a dependency: it pins its own Firecracker
+// API models and pulls the CNI and containerd module trees, while the
+// handful of PUTs above are covered by the vmconfig types the releases
+// already publish. Before starting, the firecracker binary version is
+// checked against the range the release was tested with
+// (manifest.Firecracker.Compatible).
+package run
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/boot"
+	"github.com/slok/sbx-images/pkg/export"
+	"github.com/slok/sbx-images/pkg/sparse"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+// Work directory files of a machine.
+const (
+	apiSocketFile   = "firecracker.socket"
+	vsockSocketFile = "vsock.sock"
+	overlayFile     = "overlay.ext4"
+)
+
+// Options configures the machines of a Launcher.
+type Options struct {
+	// Firecracker is the firecracker binary (default: the one bundled with
+	// the release, "firecracker" from PATH otherwise).
+	Firecracker string
+	// WorkDir holds the API and vsock sockets and the overlay disk copy of
+	// the machine (default: a temporary directory, removed once the machine
+	// exits).
+	WorkDir string
+	// VM are the guest resources and devices, the Overlay and VsockUDSPath
+	// being set in WorkDir.
+	VM vmconfig.Options
+	// Console receives the guest serial console (default: discarded).
+	Console io.Writer
+}
+
+// Launcher boots release selections as Firecracker microVMs.
+type Launcher struct {
+	opts Options
+}
+
+// NewLauncher returns a Launcher booting machines with opts.
+func NewLauncher(opts Options) *Launcher {
+	return &Launcher{opts: opts}
+}
+
+// Machine is a running Firecracker microVM.
+type Machine struct {
+	// Config is the configuration the machine was booted with.
+	Config vmconfig.Config
+	// WorkDir is the machine work directory.
+	WorkDir string
+	// Arch is the guest architecture.
+	Arch string
+
+	cmd     *exec.Cmd
+	api     *apiClient
+	tempDir bool
+	exited  chan struct{}
+	waitErr error
+	stop    sync.Once
+}
+
+// Launch boots the kernel and rootfs of src and returns once the guest
+// started: the guest boot is not waited for, watch the Console or dial the
+// guest over vsock for that.
+func (l *Launcher) Launch(ctx context.Context, src export.Source) (*Machine, error) {
+	opts := l.opts.VM
+	m := &Machine{WorkDir: l.opts.WorkDir, Arch: src.Arch, exited: make(chan struct{})}
+	if m.WorkDir == "" {
+		dir, err := os.MkdirTemp("", "sbx-run-*")
+		if err != nil {
+			return nil, fmt.Errorf("creating work dir: %w", err)
+		}
+		m.WorkDir, m.tempDir = dir, true
+	} else if err := os.MkdirAll(m.WorkDir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating work dir: %w", err)
+	}
+	launched := false
+	defer func() {
+		if !launched && m.tempDir {
+			os.RemoveAll(m.WorkDir)
+		}
+	}()
+
+	a := src.Manifest.Artifacts[src.Arch]
+	if src.Rootfs.Overlay != nil {
+		opts.Overlay = filepath.Join(m.WorkDir, overlayFile)
+	}
+	if opts.VsockCID != 0 {
+		opts.VsockUDSPath = filepath.Join(m.WorkDir, vsockSocketFile)
+		// Firecracker fails binding a stale socket.
+		os.Remove(opts.VsockUDSPath)
+	}
+	cfg, err := vmconfig.ForRelease(src.Dir, a, src.Arch, src.Kernel, src.Rootfs, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.Config = cfg
+	if o := src.Rootfs.Overlay; o != nil {
+		if err := copyOverlay(src.Path(o.File), opts.Overlay); err != nil {
+			return nil, fmt.Errorf("copying overlay disk template: %w", err)
+		}
+	}
+
+	firecracker := l.opts.Firecracker
+	if firecracker == "" && a.Firecracker != nil {
+		firecracker = src.Path(a.Firecracker.Firecracker.File)
+	}
+	firecracker = cmp.Or(firecracker, "firecracker")
+	version, err := firecrackerVersion(ctx, firecracker)
+	if err != nil {
+		return nil, err
+	}
+	if err := src.Manifest.Firecracker.Compatible(version); err != nil {
+		return nil, err
+	}
+
+	socket := filepath.Join(m.WorkDir, apiSocketFile)
+	os.Remove(socket)
+	m.cmd = exec.Command(firecracker, "--api-sock", socket, "--level", "Error")
+	m.cmd.Dir = m.WorkDir
+	m.cmd.Stdout = cmp.Or[io.Writer](l.opts.Console, io.Discard)
+	m.cmd.Stderr = m.cmd.Stdout
+	m.cmd.WaitDelay = time.Second
+	if err := m.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting firecracker: %w", err)
+	}
+	launched = true
+	go func() {
+		m.waitErr = m.cmd.Wait()
+		if m.tempDir {
+			os.RemoveAll(m.WorkDir)
+		}
+		close(m.exited)
+	}()
+
+	if err := m.start(ctx, socket); err != nil {
+		m.Stop()
+		return nil, err
+	}
+	return m, nil
+}
+
+// firecrackerVersion returns the version of the firecracker binary, from
+// the "Firecracker v1.14.1" first line of its --version output.
+func firecrackerVersion(ctx context.Context, firecracker string) (string, error) {
+	out, err := exec.CommandContext(ctx, firecracker, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("getting %s version: %w", firecracker, err)
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != "Firecracker" {
+		return "", fmt.Errorf("unexpected %s --version output %q", firecracker, line)
+	}
+	return fields[1], nil
+}
+
+// start configures the machine through its API socket and starts it.
+func (m *Machine) start(ctx context.Context, socket string) error {
+	if err := waitSocket(ctx, socket, m.exited); err != nil {
+		return err
+	}
+	m.api = newAPIClient(socket)
+	cfg := m.Config
+	if err := m.api.put(ctx, "/boot-source", cfg.BootSource); err != nil {
+		return err
+	}
+	for _, d := range cfg.Drives {
+		if err := m.api.put(ctx, "/drives/"+d.DriveID, d); err != nil {
+			return err
+		}
+	}
+	if err := m.api.put(ctx, "/machine-config", cfg.MachineConfig); err != nil {
+		return err
+	}
+	for _, iface := range cfg.NetworkInterfaces {
+		if err := m.api.put(ctx, "/network-interfaces/"+iface.IfaceID, iface); err != nil {
+			return err
+		}
+	}
+	if cfg.Vsock != nil {
+		if err := m.api.put(ctx, "/vsock", cfg.Vsock); err != nil {
+			return err
+		}
+	}
+	if cfg.Entropy != nil {
+		if err := m.api.put(ctx, "/entropy", cfg.Entropy); err != nil {
+			return err
+		}
+	}
+	return m.api.put(ctx, "/actions", action{ActionType: "InstanceStart"})
+}
+
+// Exited is closed once the firecracker process exited.
+func (m *Machine) Exited() <-chan struct{} {
+	return m.exited
+}
+
+// Wait waits for the machine to exit (the guest shutting down or
+// rebooting, reboot=k) and returns the firecracker exit error.
+func (m *Machine) Wait() error {
+	<-m.exited
+	return m.waitErr
+}
+
+// Shutdown asks the guest to shut down (Ctrl+Alt+Del, x86_64 guests only)
+// and waits for the machine to exit, stopping it when ctx is done first.
+func (m *Machine) Shutdown(ctx context.Context) error {
+	if m.Arch != "x86_64" {
+		return m.Stop()
+	}
+	if err := m.api.put(ctx, "/actions", action{ActionType: "SendCtrlAltDel"}); err != nil {
+		return errors.Join(err, m.Stop())
+	}
+	select {
+	case <-m.exited:
+		return nil
+	case <-ctx.Done():
+		return m.Stop()
+	}
+}
+
+// Stop kills the machine and waits for it to exit.
+func (m *Machine) Stop() error {
+	m.stop.Do(func() {
+		if m.cmd.Process != nil {
+			m.cmd.Process.Kill()
+		}
+	})
+	<-m.exited
+	return nil
+}
+
+// DialVsock connects to the guest vsock port through the host Unix socket
+// of the vsock device (the Firecracker "CONNECT <port>" handshake).
+func (m *Machine) DialVsock(ctx context.Context, port uint32) (net.Conn, error) {
+	if m.Config.Vsock == nil {
+		return nil, errors.New("machine has no vsock device")
+	}
+	return boot.DialVsock(ctx, m.Config.Vsock.UDSPath, port)
+}
+
+// copyOverlay makes the fresh machine copy dst of the overlay disk template
+// src, keeping its holes.
+func copyOverlay(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := sparse.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
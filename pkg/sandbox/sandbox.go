@@ -0,0 +1,115 @@
+// Package sandbox runs untrusted build hooks with restricted privileges.
+//
+// A sandboxed command is started by re-executing the current binary, which
+// applies the policy to itself (no network namespace, landlock filesystem
+// rules and a seccomp syscall denylist) and then execs the real command.
+// Binaries using Command must call Init at the very start of main.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// policyEnv carries the encoded policy to the re-executed sandbox helper.
+const policyEnv = "SBX_SANDBOX_POLICY"
+
+// Policy declares the permissions granted to a sandboxed command.
+type Policy struct {
+	// Network allows network access. Without it the command runs in an
+	// empty network namespace.
+	Network bool `json:"network" yaml:"network"`
+	// ReadPaths are paths the command may read and execute from, on top of
+	// the base system directories.
+	ReadPaths []string `json:"read_paths,omitempty" yaml:"read_paths"`
+	// WritePaths are paths the command may read and modify.
+	WritePaths []string `json:"write_paths,omitempty" yaml:"write_paths"`
+}
+
+// systemReadPaths are readable by every sandboxed command so that shells and
+// common tools work.
+var systemReadPaths = []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc", "/proc", "/sys", "/dev"}
+
+// systemWritePaths are writable by every sandboxed command.
+var systemWritePaths = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/tty"}
+
+// passEnv are the variables of the calling process a sandboxed command
+// inherits. Everything else, credentials such as AWS_SECRET_ACCESS_KEY or
+// GH_TOKEN in particular, is dropped; callers add what the command needs.
+var passEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LANGUAGE", "TZ", "SOURCE_DATE_EPOCH"}
+
+// environ returns the entries of env allowed by passEnv, plus the LC_*
+// locale settings.
+func environ(env []string) []string {
+	var kept []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "LC_") || slices.Contains(passEnv, name) {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// Command returns a command running name with args under policy p. The
+// command gets a minimal environment, see passEnv, which callers extend
+// through cmd.Env.
+func Command(ctx context.Context, p Policy, name string, args ...string) (*exec.Cmd, error) {
+	if err := supported(); err != nil {
+		return nil, err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("resolving sandbox helper: %w", err)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("encoding sandbox policy: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, self, append([]string{name}, args...)...)
+	cmd.Env = append(environ(os.Environ()), policyEnv+"="+string(data))
+	cmd.SysProcAttr = sysProcAttr(p)
+
+	return cmd, nil
+}
+
+// Init turns the process into the sandbox helper when it was started by
+// Command. In that case it never returns: it applies the policy and execs
+// the target command, or exits with status 126 on failure.
+func Init() {
+	raw, ok := os.LookupEnv(policyEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(policyEnv)
+
+	if err := enter(raw); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+}
+
+func enter(raw string) error {
+	var p Policy
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		return fmt.Errorf("decoding policy: %w", err)
+	}
+	if len(os.Args) < 2 {
+		return fmt.Errorf("missing command")
+	}
+
+	path, err := exec.LookPath(os.Args[1])
+	if err != nil {
+		return err
+	}
+
+	return restrictAndExec(p, path, os.Args[1:], os.Environ())
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+func supported() error {
+	return fmt.Errorf("sandboxed commands are not supported on %s", runtime.GOOS)
+}
+
+func sysProcAttr(Policy) *syscall.SysProcAttr { return nil }
+
+func restrictAndExec(Policy, string, []string, []string) error { return supported() }
@@ -0,0 +1,25 @@
+package sandbox
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEnviron(t *testing.T) {
+	env := []string{
+		"PATH=/usr/bin:/bin",
+		"HOME=/home/builder",
+		"LC_ALL=C.UTF-8",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"MINISIGN_PASSWORD=secret",
+		"GH_TOKEN=secret",
+		"SBX_SERVE_PASSWORD=secret",
+		"PATHS=/opt",
+	}
+
+	got := environ(env)
+	want := []string{"PATH=/usr/bin:/bin", "HOME=/home/builder", "LC_ALL=C.UTF-8"}
+	if !slices.Equal(got, want) {
+		t.Errorf("environ() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type               string           `json:"type"`
+	BOMRef             string           `json:"bom-ref,omitempty"`
+	Supplier           *cdxOrganization `json:"supplier,omitempty"`
+	Name               string           `json:"name"`
+	Version            string           `json:"version,omitempty"`
+	Description        string           `json:"description,omitempty"`
+	Hashes             []cdxHash        `json:"hashes,omitempty"`
+	Licenses           []cdxLicense     `json:"licenses,omitempty"`
+	PURL               string           `json:"purl,omitempty"`
+	ExternalReferences []cdxExternalRef `json:"externalReferences,omitempty"`
+	Properties         []cdxProperty    `json:"properties,omitempty"`
+}
+
+type cdxOrganization struct {
+	Name string `json:"name"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// cdxLicense is either an SPDX expression or a free form license name.
+type cdxLicense struct {
+	Expression string          `json:"expression,omitempty"`
+	License    *cdxLicenseName `json:"license,omitempty"`
+}
+
+type cdxLicenseName struct {
+	Name string `json:"name"`
+}
+
+type cdxExternalRef struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// WriteCycloneDX writes a CycloneDX 1.5 JSON document describing img and its
+// packages.
+func WriteCycloneDX(w io.Writer, img Image, pkgs []Package) error {
+	created := img.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	// Derive the serial number from the content so rebuilding the same image
+	// yields the same document identity.
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", img.Name, img.Version, img.SHA256)
+	for _, p := range pkgs {
+		fmt.Fprintf(h, "%s %s\n", p.Name, p.Version)
+	}
+	sum := h.Sum(nil)
+	sum[6] = (sum[6] & 0x0f) | 0x80 // RFC 9562 version 8 (SHA-256 name based).
+	sum[8] = (sum[8] & 0x3f) | 0x80
+
+	image := cdxComponent{
+		Type:        "operating-system",
+		BOMRef:      "image:" + img.Name,
+		Name:        img.Name,
+		Version:     img.Version,
+		Description: fmt.Sprintf("%s %s rootfs (%s profile) for %s", img.Distro, img.DistroVersion, img.Profile, img.Arch),
+	}
+	if img.SHA256 != "" {
+		image.Hashes = []cdxHash{{Alg: "SHA-256", Content: img.SHA256}}
+	}
+
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: created.UTC().Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: "sbx-images-sbom"}}},
+			Component: image,
+		},
+		Components: []cdxComponent{},
+	}
+
+	var supplier *cdxOrganization
+	if name := supplierName(img.Distro); name != "" {
+		supplier = &cdxOrganization{Name: name}
+	}
+
+	deps := cdxDependency{Ref: image.BOMRef, DependsOn: []string{}}
+	for _, p := range pkgs {
+		ref := purl(p, img.Distro, img.DistroVersion)
+		c := cdxComponent{
+			Type:        "library",
+			BOMRef:      ref,
+			Supplier:    supplier,
+			Name:        p.Name,
+			Version:     p.Version,
+			Description: p.Description,
+			Licenses:    cdxLicenses(p.License),
+			PURL:        ref,
+		}
+		if p.URL != "" {
+			c.ExternalReferences = []cdxExternalRef{{Type: "website", URL: p.URL}}
+		}
+		if p.Origin != "" && p.Origin != p.Name {
+			c.Properties = []cdxProperty{{Name: "sbx-images:source-package", Value: p.Origin}}
+		}
+		doc.Components = append(doc.Components, c)
+		deps.DependsOn = append(deps.DependsOn, ref)
+	}
+	doc.Dependencies = []cdxDependency{deps}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("encoding CycloneDX document: %w", err)
+	}
+	return nil
+}
+
+// cdxLicenses returns license as an SPDX expression when possible, or as a
+// free form name otherwise.
+func cdxLicenses(license string) []cdxLicense {
+	if license == "" {
+		return nil
+	}
+	if expr := spdxLicense(license); expr != noAssertion {
+		return []cdxLicense{{Expression: expr}}
+	}
+	return []cdxLicense{{License: &cdxLicenseName{Name: license}}}
+}
@@ -0,0 +1,58 @@
+package sbom
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Format is an SBOM document format.
+type Format struct {
+	// Name is the format identifier used on the command line and in the
+	// manifest (e.g. spdx).
+	Name string
+	// Extension is appended to the image base name to build the SBOM file
+	// name (e.g. .spdx.json).
+	Extension string
+	// Write writes the SBOM document of img and its packages.
+	Write func(w io.Writer, img Image, pkgs []Package) error
+}
+
+// Formats are the supported SBOM formats by name.
+var Formats = map[string]Format{
+	"spdx":      {Name: "spdx", Extension: ".spdx.json", Write: WriteSPDX},
+	"cyclonedx": {Name: "cyclonedx", Extension: ".cdx.json", Write: WriteCycloneDX},
+}
+
+// FormatNames returns the supported format names sorted.
+func FormatNames() []string {
+	names := make([]string, 0, len(Formats))
+	for name := range Formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseFormats parses a comma separated list of format names.
+func ParseFormats(list string) ([]Format, error) {
+	var formats []Format
+	seen := map[string]bool{}
+	for name := range strings.SplitSeq(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		f, ok := Formats[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown SBOM format %q (supported: %s)", name, strings.Join(FormatNames(), ", "))
+		}
+		seen[name] = true
+		formats = append(formats, f)
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no SBOM format selected")
+	}
+	return formats, nil
+}
@@ -0,0 +1,53 @@
+package sbom
+
+import (
+	"sort"
+	"strings"
+)
+
+// LicenseSummary returns how many packages declare each license identifier.
+// License expressions are split into their identifiers, so a package under
+// "MIT AND BSD-2-Clause" counts for both. Packages without a license count
+// as "NOASSERTION".
+func LicenseSummary(pkgs []Package) map[string]int {
+	summary := map[string]int{}
+	for _, p := range pkgs {
+		ids := licenseIDs(p.License)
+		if len(ids) == 0 {
+			ids = []string{noAssertion}
+		}
+		for _, id := range ids {
+			summary[id]++
+		}
+	}
+	return summary
+}
+
+// licenseIDs returns the unique identifiers of a license expression, sorted.
+// Licenses that aren't SPDX expressions are kept whole.
+func licenseIDs(license string) []string {
+	license = strings.TrimSpace(license)
+	if license == "" {
+		return nil
+	}
+	if spdxLicense(license) == noAssertion {
+		return []string{license}
+	}
+	license = strings.NewReplacer("(", " ", ")", " ").Replace(license)
+
+	seen := map[string]bool{}
+	for _, tok := range licenseOpsRe.Split(" "+license+" ", -1) {
+		for id := range strings.FieldsSeq(tok) {
+			if id != "AND" && id != "OR" && id != "WITH" {
+				seen[id] = true
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
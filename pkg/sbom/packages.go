@@ -0,0 +1,198 @@
+// Package sbom builds software bills of materials for rootfs images from
+// their package manager database.
+package sbom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/distro"
+)
+
+// Package is an installed package.
+type Package struct {
+	Name        string
+	Version     string
+	Arch        string
+	License     string
+	Description string
+	URL         string
+	// Origin is the source package the package was built from.
+	Origin string
+	// Checksum is the package manager checksum of the package control data.
+	Checksum string
+}
+
+// ParseAPKInstalled parses an apk installed database (/lib/apk/db/installed).
+func ParseAPKInstalled(r io.Reader) ([]Package, error) {
+	var (
+		pkgs []Package
+		cur  Package
+	)
+	flush := func() {
+		if cur.Name != "" {
+			pkgs = append(pkgs, cur)
+		}
+		cur = Package{}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+
+		value := line[2:]
+		switch line[0] {
+		case 'P':
+			cur.Name = value
+		case 'V':
+			cur.Version = value
+		case 'A':
+			cur.Arch = value
+		case 'L':
+			cur.License = value
+		case 'T':
+			cur.Description = value
+		case 'U':
+			cur.URL = value
+		case 'o':
+			cur.Origin = value
+		case 'C':
+			cur.Checksum = value
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading apk database: %w", err)
+	}
+	flush()
+
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+// ParseDpkgStatus parses a dpkg status database (/var/lib/dpkg/status),
+// skipping the packages that are not installed.
+func ParseDpkgStatus(r io.Reader) ([]Package, error) {
+	var (
+		pkgs      []Package
+		cur       Package
+		installed bool
+	)
+	flush := func() {
+		if cur.Name != "" && installed {
+			pkgs = append(pkgs, cur)
+		}
+		cur, installed = Package{}, false
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		// Continuation lines of multiline fields (descriptions, conffiles).
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch field {
+		case "Package":
+			cur.Name = value
+		case "Version":
+			cur.Version = value
+		case "Architecture":
+			cur.Arch = value
+		case "Description":
+			cur.Description = value
+		case "Homepage":
+			cur.URL = value
+		case "Source":
+			// "name (version)" when the source version differs.
+			cur.Origin, _, _ = strings.Cut(value, " ")
+		case "Status":
+			installed = strings.HasSuffix(value, " installed")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading dpkg status: %w", err)
+	}
+	flush()
+
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+// ReadAPKInstalled parses the apk installed database file at path.
+func ReadAPKInstalled(path string) ([]Package, error) {
+	return readDB(path, ParseAPKInstalled)
+}
+
+// ReadInstalled parses the package database file at path exported from an
+// image of the distro named name (see distro.PackageDB).
+func ReadInstalled(name, path string) ([]Package, error) {
+	d, err := distro.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	switch d.PackageType() {
+	case "apk":
+		return readDB(path, ParseAPKInstalled)
+	case "deb":
+		return readDB(path, ParseDpkgStatus)
+	default:
+		return nil, fmt.Errorf("unsupported %s package type %q", d.Name(), d.PackageType())
+	}
+}
+
+func readDB(path string, parse func(io.Reader) ([]Package, error)) ([]Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	pkgs, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pkgs, nil
+}
+
+// purl returns the package URL of a distro package, typed by the distro
+// package format (apk when unknown).
+func purl(p Package, distroName, distroVersion string) string {
+	q := []string{}
+	if p.Arch != "" {
+		q = append(q, "arch="+p.Arch)
+	}
+	if distroName != "" {
+		q = append(q, "distro="+distroName+"-"+distroVersion)
+	}
+
+	typ := "apk"
+	if d, err := distro.Get(distroName); err == nil {
+		typ = d.PackageType()
+	}
+	s := fmt.Sprintf("pkg:%s/%s/%s@%s", typ, distroName, url.QueryEscape(p.Name), url.QueryEscape(p.Version))
+	if len(q) > 0 {
+		s += "?" + strings.Join(q, "&")
+	}
+	return s
+}
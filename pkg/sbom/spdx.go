@@ -0,0 +1,233 @@
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/distro"
+)
+
+// Image describes the rootfs image an SBOM is generated for.
+type Image struct {
+	// Name is the artifact file name (e.g. rootfs-x86_64.ext4).
+	Name          string
+	Version       string
+	Arch          string
+	Distro        string
+	DistroVersion string
+	Profile       string
+	SHA256        string
+	Created       time.Time
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+	// ExtractedLicenses declares the LicenseRef- licenses of packages whose
+	// license isn't an SPDX expression.
+	ExtractedLicenses []spdxExtractedLicense `json:"hasExtractedLicensingInfos,omitempty"`
+}
+
+type spdxExtractedLicense struct {
+	LicenseID     string `json:"licenseId"`
+	ExtractedText string `json:"extractedText"`
+	Name          string `json:"name"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	Supplier         string            `json:"supplier,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	Homepage         string            `json:"homepage,omitempty"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	Description      string            `json:"description,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+const (
+	noAssertion = "NOASSERTION"
+	imageID     = "SPDXRef-Image"
+)
+
+var (
+	spdxIDInvalid  = regexp.MustCompile(`[^A-Za-z0-9.\-]+`)
+	licenseExprRe  = regexp.MustCompile(`^[A-Za-z0-9.+\-() ]+$`)
+	licenseOpsRe   = regexp.MustCompile(`\s+(AND|OR|WITH)\s+`)
+	licenseTokenRe = regexp.MustCompile(`^[A-Za-z0-9.\-]+\+?$`)
+)
+
+// WriteSPDX writes an SPDX 2.3 JSON document describing img and its packages.
+func WriteSPDX(w io.Writer, img Image, pkgs []Package) error {
+	created := img.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	// The namespace must be unique per document, derive it from the content.
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", img.Name, img.Version, img.SHA256)
+	for _, p := range pkgs {
+		fmt.Fprintf(h, "%s %s\n", p.Name, p.Version)
+	}
+
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              img.Name,
+		DocumentNamespace: fmt.Sprintf("https://github.com/slok/sbx-images/spdx/%s/%s-%s", img.Version, img.Name, hex.EncodeToString(h.Sum(nil))[:16]),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: sbx-images-sbom", "Organization: sbx-images"},
+		},
+	}
+
+	image := spdxPackage{
+		Name:             img.Name,
+		SPDXID:           imageID,
+		VersionInfo:      img.Version,
+		DownloadLocation: noAssertion,
+		LicenseConcluded: noAssertion,
+		LicenseDeclared:  noAssertion,
+		CopyrightText:    noAssertion,
+		Description:      fmt.Sprintf("%s %s rootfs (%s profile) for %s", img.Distro, img.DistroVersion, img.Profile, img.Arch),
+		PrimaryPurpose:   "OPERATING-SYSTEM",
+	}
+	if img.SHA256 != "" {
+		image.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: img.SHA256}}
+	}
+	doc.Packages = append(doc.Packages, image)
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		SPDXElementID: doc.SPDXID, RelationshipType: "DESCRIBES", RelatedSPDXElement: imageID,
+	})
+
+	licenseRefs := map[string]bool{}
+	for _, p := range pkgs {
+		id := "SPDXRef-Package-" + spdxIDInvalid.ReplaceAllString(p.Name, "-")
+		declared := spdxLicense(p.License)
+		if declared == noAssertion && strings.TrimSpace(p.License) != "" {
+			declared = licenseRef(p.License)
+			if !licenseRefs[declared] {
+				licenseRefs[declared] = true
+				doc.ExtractedLicenses = append(doc.ExtractedLicenses, spdxExtractedLicense{
+					LicenseID:     declared,
+					ExtractedText: "Declared by the package manager as: " + p.License,
+					Name:          p.License,
+				})
+			}
+		}
+		sp := spdxPackage{
+			Name:             p.Name,
+			SPDXID:           id,
+			VersionInfo:      p.Version,
+			Supplier:         supplier(img.Distro),
+			DownloadLocation: noAssertion,
+			FilesAnalyzed:    false,
+			Homepage:         p.URL,
+			LicenseConcluded: noAssertion,
+			LicenseDeclared:  declared,
+			CopyrightText:    noAssertion,
+			Description:      p.Description,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  purl(p, img.Distro, img.DistroVersion),
+			}},
+		}
+		if p.Origin != "" && p.Origin != p.Name {
+			sp.SourceInfo = "built from source package " + p.Origin
+		}
+		doc.Packages = append(doc.Packages, sp)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID: imageID, RelationshipType: "CONTAINS", RelatedSPDXElement: id,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("encoding SPDX document: %w", err)
+	}
+	return nil
+}
+
+// spdxLicense returns license as an SPDX expression or NOASSERTION when it
+// can't be represented as one.
+func spdxLicense(license string) string {
+	license = strings.TrimSpace(license)
+	if license == "" || !licenseExprRe.MatchString(license) {
+		return noAssertion
+	}
+
+	for _, tok := range licenseOpsRe.Split(strings.NewReplacer("(", " ", ")", " ").Replace(license), -1) {
+		tok = strings.TrimSpace(tok)
+		if tok == "" || !licenseTokenRe.MatchString(tok) {
+			return noAssertion
+		}
+	}
+	return license
+}
+
+// licenseRef returns the LicenseRef- identifier of a non SPDX license.
+func licenseRef(license string) string {
+	return "LicenseRef-" + strings.Trim(spdxIDInvalid.ReplaceAllString(strings.TrimSpace(license), "-"), "-")
+}
+
+func supplier(distro string) string {
+	name := supplierName(distro)
+	if name == "" {
+		return noAssertion
+	}
+	return "Organization: " + name
+}
+
+func supplierName(name string) string {
+	d, err := distro.Get(name)
+	if err != nil {
+		return name
+	}
+	return d.Supplier()
+}
@@ -0,0 +1,63 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type grype struct{}
+
+func (grype) Name() string { return "grype" }
+
+func (grype) Version(ctx context.Context) (string, error) {
+	out, err := runTool(ctx, "grype", "version", "-o", "json")
+	if err != nil {
+		return "", err
+	}
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		return "", fmt.Errorf("parsing grype version: %w", err)
+	}
+	return v.Version, nil
+}
+
+func (grype) Scan(ctx context.Context, path string) ([]Finding, error) {
+	out, err := runTool(ctx, "grype", "sbom:"+path, "-o", "json", "-q")
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Matches []struct {
+			Vulnerability struct {
+				ID       string `json:"id"`
+				Severity string `json:"severity"`
+				Fix      struct {
+					Versions []string `json:"versions"`
+				} `json:"fix"`
+			} `json:"vulnerability"`
+			Artifact struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"artifact"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return nil, fmt.Errorf("parsing grype output: %w", err)
+	}
+
+	findings := make([]Finding, 0, len(doc.Matches))
+	for _, m := range doc.Matches {
+		findings = append(findings, Finding{
+			ID:       m.Vulnerability.ID,
+			Package:  m.Artifact.Name,
+			Version:  m.Artifact.Version,
+			Severity: normalizeSeverity(m.Vulnerability.Severity),
+			FixedIn:  m.Vulnerability.Fix.Versions,
+		})
+	}
+	return findings, nil
+}
@@ -0,0 +1,168 @@
+// Package scan checks rootfs SBOMs for known vulnerabilities.
+//
+// Scanning shells out to an upstream scanner (grype or trivy) that reads the
+// SBOM, so the vulnerability databases stay managed by the tool.
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Severities are the finding severities from lowest to highest.
+var Severities = []string{"negligible", "low", "medium", "high", "critical"}
+
+// Finding is a vulnerability affecting an installed package.
+type Finding struct {
+	ID       string `json:"id"`
+	Package  string `json:"package"`
+	Version  string `json:"version"`
+	Severity string `json:"severity"`
+	// FixedIn lists the package versions fixing the vulnerability.
+	FixedIn []string `json:"fixed_in,omitempty"`
+}
+
+// Report is the vulnerability report artifact of a rootfs image.
+type Report struct {
+	Scanner string `json:"scanner"`
+	// ScannerVersion is the scanner tool version.
+	ScannerVersion string `json:"scanner_version,omitempty"`
+	// SBOM is the scanned SBOM file.
+	SBOM string `json:"sbom"`
+	Date string `json:"date"`
+	// Counts are the number of findings per severity.
+	Counts   map[string]int `json:"counts"`
+	Findings []Finding      `json:"findings"`
+}
+
+// Scanner is a vulnerability scanner backend.
+type Scanner interface {
+	// Name returns the scanner name (e.g. "grype").
+	Name() string
+	// Version returns the scanner tool version.
+	Version(ctx context.Context) (string, error)
+	// Scan returns the findings of the SBOM at path.
+	Scan(ctx context.Context, path string) ([]Finding, error)
+}
+
+// New returns the scanner named name.
+func New(name string) (Scanner, error) {
+	switch name {
+	case "", "grype":
+		return grype{}, nil
+	case "trivy":
+		return trivy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown scanner %q (supported: grype, trivy)", name)
+	}
+}
+
+// NewReport returns the report of findings, sorted by severity (highest
+// first), package and ID.
+func NewReport(scanner, scannerVersion, sbom, date string, findings []Finding) Report {
+	findings = slices.Clone(findings)
+	sort.Slice(findings, func(i, j int) bool {
+		fi, fj := findings[i], findings[j]
+		if ri, rj := rank(fi.Severity), rank(fj.Severity); ri != rj {
+			return ri > rj
+		}
+		if fi.Package != fj.Package {
+			return fi.Package < fj.Package
+		}
+		return fi.ID < fj.ID
+	})
+
+	counts := map[string]int{}
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+	if findings == nil {
+		findings = []Finding{}
+	}
+	return Report{
+		Scanner:        scanner,
+		ScannerVersion: scannerVersion,
+		SBOM:           sbom,
+		Date:           date,
+		Counts:         counts,
+		Findings:       findings,
+	}
+}
+
+// AtOrAbove returns the findings with severity failOn or higher, excluding
+// the ignored vulnerability IDs.
+func AtOrAbove(findings []Finding, failOn string, ignore []string) []Finding {
+	threshold := rank(failOn)
+	if threshold < 0 {
+		return nil
+	}
+
+	var out []Finding
+	for _, f := range findings {
+		if rank(f.Severity) >= threshold && !slices.Contains(ignore, f.ID) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Read loads a report from path.
+func Read(path string) (Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Report{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Write stores the report as indented JSON at path.
+func Write(path string, r Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
+
+// rank returns the position of severity in Severities, -1 if unknown.
+func rank(severity string) int {
+	return slices.Index(Severities, severity)
+}
+
+// normalizeSeverity maps scanner severities to Severities, or "unknown".
+func normalizeSeverity(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if rank(s) < 0 {
+		return "unknown"
+	}
+	return s
+}
+
+func runTool(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, fmt.Errorf("%s is required: %w", name, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
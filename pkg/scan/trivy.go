@@ -0,0 +1,68 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type trivy struct{}
+
+func (trivy) Name() string { return "trivy" }
+
+func (trivy) Version(ctx context.Context) (string, error) {
+	out, err := runTool(ctx, "trivy", "version", "--format", "json")
+	if err != nil {
+		return "", err
+	}
+	var v struct {
+		Version string `json:"Version"`
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		return "", fmt.Errorf("parsing trivy version: %w", err)
+	}
+	return v.Version, nil
+}
+
+func (trivy) Scan(ctx context.Context, path string) ([]Finding, error) {
+	out, err := runTool(ctx, "trivy", "sbom", "--quiet", "--format", "json", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string `json:"VulnerabilityID"`
+				PkgName          string `json:"PkgName"`
+				InstalledVersion string `json:"InstalledVersion"`
+				FixedVersion     string `json:"FixedVersion"`
+				Severity         string `json:"Severity"`
+			} `json:"Vulnerabilities"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return nil, fmt.Errorf("parsing trivy output: %w", err)
+	}
+
+	var findings []Finding
+	for _, r := range doc.Results {
+		for _, v := range r.Vulnerabilities {
+			f := Finding{
+				ID:       v.VulnerabilityID,
+				Package:  v.PkgName,
+				Version:  v.InstalledVersion,
+				Severity: normalizeSeverity(v.Severity),
+			}
+			// FixedVersion is a comma separated list.
+			for fixed := range strings.SplitSeq(v.FixedVersion, ",") {
+				if fixed = strings.TrimSpace(fixed); fixed != "" {
+					f.FixedIn = append(f.FixedIn, fixed)
+				}
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,277 @@
+// Package serve serves a release directory (a build dir or a mirror of a
+// release) over HTTP, as a local release host.
+package serve
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/usage"
+)
+
+// IndexFile is the name the generated index is served at.
+const IndexFile = "index.json"
+
+// Index lists the files served.
+type Index struct {
+	// Manifest is set when the directory holds a manifest.json.
+	Manifest bool        `json:"manifest"`
+	Files    []IndexItem `json:"files"`
+}
+
+// IndexItem is a served file.
+type IndexItem struct {
+	// Name is the slash separated path of the file in the directory.
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Release is set for manifest.json and the files it references.
+	Release bool `json:"release,omitempty"`
+}
+
+// Server serves the files of Dir: the files at their path (with Range
+// support), the generated index at /index.json and a health check at
+// /healthz. Directories are not listed.
+//
+// With Usage set, every download of a kernel of the release in Dir (a GET
+// from its first byte) is reported as a use of the release version on the
+// kernel architecture by the client host. The kernels are looked up in the
+// manifest, read again only when it changes.
+type Server struct {
+	// Dir is the served directory.
+	Dir string
+	// Username and Password enable the basic authentication of every
+	// endpoint but the health check, when Username is set.
+	Username string
+	Password string
+	// Usage receives the usage events, nil for none.
+	Usage usage.Reporter
+
+	kernels *releaseKernels
+}
+
+// Handler returns the HTTP handler of the server.
+func (s Server) Handler() http.Handler {
+	s.kernels = &releaseKernels{path: filepath.Join(s.Dir, "manifest.json")}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.Handle("GET /"+IndexFile, s.auth(http.HandlerFunc(s.serveIndex)))
+	mux.Handle("GET /", s.auth(http.HandlerFunc(s.serveFile)))
+	return logRequests(mux)
+}
+
+func (s Server) auth(next http.Handler) http.Handler {
+	if s.Username == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(s.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="sbx-images"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	idx, err := BuildIndex(s.Dir)
+	if err != nil {
+		slog.Error("Building index", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(idx)
+}
+
+func (s Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" || hidden(name) {
+		http.NotFound(w, r)
+		return
+	}
+	// os.Root keeps the symlinks of the directory from escaping it.
+	root, err := os.OpenRoot(s.Dir)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer root.Close()
+	f, err := root.Open(filepath.FromSlash(name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	if path.Ext(name) == ".json" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	// ServeContent handles Range, If-Modified-Since and HEAD.
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(sw, r, name, info.ModTime(), f)
+	if s.Usage != nil && r.Method == http.MethodGet && sw.status < 300 && fromStart(r) {
+		s.reportUsage(r, name)
+	}
+}
+
+// reportUsage reports the download of name when it is a release kernel.
+// The errors are logged, they don't fail the download.
+func (s Server) reportUsage(r *http.Request, name string) {
+	version, arch, ok := s.kernels.lookup(name)
+	if !ok {
+		return
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	e := usage.Event{Time: time.Now().UTC(), Version: version, Arch: arch, Host: host, Source: "serve"}
+	if err := s.Usage.Report(context.WithoutCancel(r.Context()), e); err != nil {
+		slog.Warn("Reporting usage", "file", name, "err", err)
+	}
+}
+
+// releaseKernels maps the kernel files of the manifest at path to their
+// architecture, parsing the manifest again when its size or modification
+// time change.
+type releaseKernels struct {
+	path string
+
+	mu      sync.Mutex
+	size    int64
+	modTime time.Time
+	version string
+	arch    map[string]string
+}
+
+// lookup returns the release version and the architecture of the kernel
+// file name, ok false when it is not a release kernel.
+func (k *releaseKernels) lookup(name string) (version, arch string, ok bool) {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return "", "", false
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.arch == nil || info.Size() != k.size || !info.ModTime().Equal(k.modTime) {
+		m, err := manifest.Read(k.path)
+		if err != nil {
+			return "", "", false
+		}
+		k.size, k.modTime, k.version = info.Size(), info.ModTime(), m.Version
+		k.arch = map[string]string{}
+		for a, artifacts := range m.Artifacts {
+			for _, kernel := range artifacts.Kernels() {
+				k.arch[kernel.File] = a
+			}
+		}
+	}
+	arch, ok = k.arch[name]
+	return k.version, arch, ok
+}
+
+// fromStart reports whether r requests a file from its first byte: no
+// Range, or a range starting at 0.
+func fromStart(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng == "" || strings.HasPrefix(rng, "bytes=0-")
+}
+
+// BuildIndex lists the regular files of dir, sorted, marking manifest.json
+// and the files it references.
+func BuildIndex(dir string) (Index, error) {
+	idx := Index{Files: []IndexItem{}}
+	release := map[string]bool{}
+	if m, err := manifest.Read(filepath.Join(dir, "manifest.json")); err == nil {
+		idx.Manifest = true
+		release["manifest.json"] = true
+		for _, f := range m.Files() {
+			release[f] = true
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return Index{}, err
+	}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		switch {
+		case name == ".":
+			return nil
+		case hidden(name) && d.IsDir():
+			return filepath.SkipDir
+		case hidden(name) || !d.Type().IsRegular():
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		idx.Files = append(idx.Files, IndexItem{Name: name, Size: info.Size(), ModTime: info.ModTime().UTC(), Release: release[name]})
+		return nil
+	})
+	if err != nil {
+		return Index{}, err
+	}
+	slices.SortFunc(idx.Files, func(a, b IndexItem) int { return strings.Compare(a.Name, b.Name) })
+	return idx, nil
+}
+
+// hidden reports whether a slash separated path has a dot prefixed element,
+// e.g. the build cache (.cache), which is not served.
+func hidden(name string) bool {
+	return slices.ContainsFunc(strings.Split(name, "/"), func(e string) bool { return strings.HasPrefix(e, ".") })
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		slog.Debug("Request", "method", r.Method, "path", r.URL.Path, "range", r.Header.Get("Range"), "status", sw.status, "duration", time.Since(start))
+	})
+}
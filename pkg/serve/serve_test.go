@@ -0,0 +1,155 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/serve"
+	"github.com/slok/sbx-images/pkg/testutil"
+	"github.com/slok/sbx-images/pkg/usage"
+)
+
+func TestServer(t *testing.T) {
+	dir, m := testutil.NewRelease(t, testutil.Release{})
+	if err := os.MkdirAll(filepath.Join(dir, ".cache"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".cache", "state"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := testutil.NewReleaseServer(t, dir)
+
+	get := func(name string, header http.Header) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/"+name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	for name, want := range map[string]int{
+		"healthz":                         http.StatusOK,
+		"manifest.json":                   http.StatusOK,
+		m.Artifacts["x86_64"].Kernel.File: http.StatusOK,
+		".cache/state":                    http.StatusNotFound,
+		"missing":                         http.StatusNotFound,
+	} {
+		if got := get(name, nil).StatusCode; got != want {
+			t.Errorf("%s: got status %d, want %d", name, got, want)
+		}
+	}
+
+	resp := get(m.Artifacts["x86_64"].Rootfs.File, http.Header{"Range": {"bytes=1080-1081"}})
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "\x53\xef" {
+		t.Errorf("got status %d and %x, want the ext4 magic", resp.StatusCode, body)
+	}
+
+	var idx serve.Index
+	if err := json.NewDecoder(get(serve.IndexFile, nil).Body).Decode(&idx); err != nil {
+		t.Fatal(err)
+	}
+	if !idx.Manifest || len(idx.Files) != 3 {
+		t.Errorf("got index %+v, want the manifest and the 2 release files", idx)
+	}
+	for _, f := range idx.Files {
+		if !f.Release {
+			t.Errorf("%s is not marked as a release file", f.Name)
+		}
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	dir, _ := testutil.NewRelease(t, testutil.Release{})
+	h := serve.Server{Dir: dir, Username: "sbx", Password: "secret"}.Handler()
+
+	for _, tc := range []struct {
+		path, user, pass string
+		want             int
+	}{
+		{"/healthz", "", "", http.StatusOK},
+		{"/manifest.json", "", "", http.StatusUnauthorized},
+		{"/manifest.json", "sbx", "wrong", http.StatusUnauthorized},
+		{"/manifest.json", "sbx", "secret", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.pass)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s as %q: got status %d, want %d", tc.path, tc.user, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestServerUsage(t *testing.T) {
+	dir, m := testutil.NewRelease(t, testutil.Release{Architectures: []string{"x86_64", "aarch64"}})
+	log := filepath.Join(t.TempDir(), "usage.jsonl")
+	h := serve.Server{Dir: dir, Usage: usage.NewReporter(log)}.Handler()
+
+	for _, tc := range []struct {
+		method, name, rng string
+	}{
+		{http.MethodGet, m.Artifacts["aarch64"].Kernel.File, ""},
+		{http.MethodGet, m.Artifacts["x86_64"].Kernel.File, "bytes=0-63"},
+		// Not reported: resumed downloads, HEAD, other files.
+		{http.MethodGet, m.Artifacts["x86_64"].Kernel.File, "bytes=64-"},
+		{http.MethodHead, m.Artifacts["x86_64"].Kernel.File, ""},
+		{http.MethodGet, m.Artifacts["x86_64"].Rootfs.File, ""},
+		{http.MethodGet, "manifest.json", ""},
+	} {
+		req := httptest.NewRequest(tc.method, "/"+tc.name, nil)
+		if tc.rng != "" {
+			req.Header.Set("Range", tc.rng)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code >= 300 {
+			t.Fatalf("%s %s: got status %d", tc.method, tc.name, rec.Code)
+		}
+	}
+
+	events, err := usage.ReadLog(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(events), events)
+	}
+	for i, arch := range []string{"aarch64", "x86_64"} {
+		e := events[i]
+		if e.Version != m.Version || e.Arch != arch || e.Source != "serve" || e.Host == "" {
+			t.Errorf("got event %+v, want a %s %s serve event", e, m.Version, arch)
+		}
+	}
+
+	// A new release in the directory is picked up.
+	m.Version += "-rebuilt"
+	if err := manifest.Write(filepath.Join(dir, "manifest.json"), m); err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+m.Artifacts["x86_64"].Kernel.File, nil))
+	if events, err = usage.ReadLog(log); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].Version != m.Version {
+		t.Errorf("got events %+v, want a third %s event", events, m.Version)
+	}
+}
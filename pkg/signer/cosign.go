@@ -0,0 +1,40 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+)
+
+// CosignOptions configures the verification of cosign signatures of OCI
+// artifacts, with a public key or keyless (Fulcio certificate identity).
+type CosignOptions struct {
+	// Key is the cosign public key path or KMS URI.
+	Key string
+	// Identity and Issuer are the expected certificate identity and OIDC
+	// issuer of keyless signatures, when Key is empty.
+	Identity string
+	Issuer   string
+	// Insecure allows plain HTTP registries.
+	Insecure bool
+}
+
+// VerifyCosign checks the OCI artifact ref (repository@digest) has a valid
+// cosign signature with cosign verify.
+func VerifyCosign(ctx context.Context, ref string, opts CosignOptions) error {
+	args := []string{"verify", "--output", "text"}
+	switch {
+	case opts.Key != "":
+		args = append(args, "--key", opts.Key)
+	case opts.Identity != "" && opts.Issuer != "":
+		args = append(args, "--certificate-identity", opts.Identity, "--certificate-oidc-issuer", opts.Issuer)
+	default:
+		return fmt.Errorf("cosign verification requires a key or a certificate identity and issuer")
+	}
+	if opts.Insecure {
+		args = append(args, "--allow-insecure-registry", "--allow-http-registry")
+	}
+	if _, err := runTool(ctx, "", "cosign", append(args, ref)...); err != nil {
+		return fmt.Errorf("verifying cosign signature of %s: %w", ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type gpgSigner struct {
+	key string
+}
+
+func (gpgSigner) Backend() string { return "gpg" }
+
+func (s gpgSigner) Fingerprint(ctx context.Context) (string, error) {
+	out, err := runTool(ctx, "", "gpg", "--batch", "--with-colons", "--fingerprint", s.key)
+	if err != nil {
+		return "", err
+	}
+
+	// The first fpr record after the primary key is its fingerprint.
+	for line := range strings.SplitSeq(out, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 9 && fields[0] == "fpr" {
+			return fields[9], nil
+		}
+	}
+
+	return "", fmt.Errorf("no fingerprint found for gpg key %q", s.key)
+}
+
+func (s gpgSigner) Sign(ctx context.Context, path string) (string, error) {
+	sigPath := SignatureFile(s.Backend(), path)
+	_, err := runTool(ctx, "", "gpg", "--batch", "--yes", "--local-user", s.key,
+		"--armor", "--detach-sign", "--output", sigPath, path)
+	if err != nil {
+		return "", err
+	}
+
+	return sigPath, nil
+}
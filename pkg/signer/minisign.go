@@ -0,0 +1,64 @@
+package signer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type minisignSigner struct {
+	secretKey string
+	publicKey string
+	password  string
+}
+
+func (minisignSigner) Backend() string { return "minisign" }
+
+// Fingerprint returns the minisign key ID as printed by `minisign -G`.
+func (s minisignSigner) Fingerprint(_ context.Context) (string, error) {
+	f, err := os.Open(s.publicKey)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", s.publicKey, err)
+	}
+	defer f.Close()
+
+	// Public key files hold an untrusted comment line followed by the
+	// base64 encoded key: 2 bytes algorithm, 8 bytes key ID, 32 bytes key.
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(raw) != 42 {
+			return "", fmt.Errorf("invalid minisign public key in %s", s.publicKey)
+		}
+		return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(raw[2:10])), nil
+	}
+	if err := sc.Err(); err != nil {
+		return "", fmt.Errorf("reading %s: %w", s.publicKey, err)
+	}
+
+	return "", fmt.Errorf("no minisign public key found in %s", s.publicKey)
+}
+
+func (s minisignSigner) Sign(ctx context.Context, path string) (string, error) {
+	sigPath := SignatureFile(s.Backend(), path)
+	stdin := ""
+	if s.password != "" {
+		stdin = s.password + "\n"
+	}
+
+	_, err := runTool(ctx, stdin, "minisign", "-S", "-s", s.secretKey, "-m", path, "-x", sigPath)
+	if err != nil {
+		return "", err
+	}
+
+	return sigPath, nil
+}
@@ -0,0 +1,83 @@
+// Package signer produces detached signatures for release artifacts.
+//
+// Each backend shells out to its upstream tool so keys stay in the tool's own
+// keyring or key files and never pass through this process.
+package signer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Signer signs files with a specific key and backend.
+type Signer interface {
+	// Backend returns the backend name recorded in the manifest (e.g. "gpg").
+	Backend() string
+	// Fingerprint returns the identifier of the signing key.
+	Fingerprint(ctx context.Context) (string, error)
+	// Sign writes a detached signature next to path and returns the signature path.
+	Sign(ctx context.Context, path string) (string, error)
+}
+
+// Options configures a signer backend.
+type Options struct {
+	// Key is the GPG key ID/fingerprint or the minisign secret key path.
+	Key string
+	// PublicKey is the minisign public key path, used to derive the key ID.
+	PublicKey string
+	// Password is piped to minisign when the secret key is encrypted.
+	Password string
+}
+
+// New returns the signer for the named backend.
+func New(backend string, opts Options) (Signer, error) {
+	if opts.Key == "" {
+		return nil, fmt.Errorf("signing key is required for %s backend", backend)
+	}
+
+	switch backend {
+	case "gpg":
+		return gpgSigner{key: opts.Key}, nil
+	case "minisign":
+		if opts.PublicKey == "" {
+			return nil, fmt.Errorf("public key is required for minisign backend")
+		}
+		return minisignSigner{secretKey: opts.Key, publicKey: opts.PublicKey, password: opts.Password}, nil
+	default:
+		return nil, fmt.Errorf("unknown signing backend %q (supported: gpg, minisign)", backend)
+	}
+}
+
+// SignatureFile returns the detached signature path the backend writes for
+// path.
+func SignatureFile(backend, path string) string {
+	switch backend {
+	case "minisign":
+		return path + ".minisig"
+	default:
+		return path + ".asc"
+	}
+}
+
+func runTool(ctx context.Context, stdin string, name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", fmt.Errorf("%s is required: %w", name, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
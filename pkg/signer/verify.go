@@ -0,0 +1,70 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// VerifyOptions configures signature verification.
+type VerifyOptions struct {
+	// Fingerprint is the expected signing key identifier, as recorded in the
+	// manifest. Empty accepts any valid signature.
+	Fingerprint string
+	// PublicKey is the minisign public key path.
+	PublicKey string
+}
+
+// Verify checks sigPath is a valid detached signature of path made by the
+// backend with the expected key.
+func Verify(ctx context.Context, backend string, opts VerifyOptions, path, sigPath string) error {
+	switch backend {
+	case "gpg":
+		return verifyGPG(ctx, opts, path, sigPath)
+	case "minisign":
+		return verifyMinisign(ctx, opts, path, sigPath)
+	default:
+		return fmt.Errorf("unknown signing backend %q (supported: gpg, minisign)", backend)
+	}
+}
+
+func verifyGPG(ctx context.Context, opts VerifyOptions, path, sigPath string) error {
+	out, err := runTool(ctx, "", "gpg", "--batch", "--status-fd", "1", "--verify", sigPath, path)
+	if err != nil {
+		return err
+	}
+
+	// VALIDSIG carries the signing key fingerprint, followed by the primary
+	// key fingerprint in the last field.
+	for line := range strings.SplitSeq(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+		if opts.Fingerprint == "" || strings.EqualFold(fields[2], opts.Fingerprint) || strings.EqualFold(fields[len(fields)-1], opts.Fingerprint) {
+			return nil
+		}
+		return fmt.Errorf("%s is signed by %s, expected %s", path, fields[2], opts.Fingerprint)
+	}
+
+	return fmt.Errorf("no valid gpg signature for %s", path)
+}
+
+func verifyMinisign(ctx context.Context, opts VerifyOptions, path, sigPath string) error {
+	if opts.PublicKey == "" {
+		return fmt.Errorf("public key is required to verify minisign signatures")
+	}
+
+	if opts.Fingerprint != "" {
+		id, err := minisignSigner{publicKey: opts.PublicKey}.Fingerprint(ctx)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(id, opts.Fingerprint) {
+			return fmt.Errorf("public key %s has key ID %s, expected %s", opts.PublicKey, id, opts.Fingerprint)
+		}
+	}
+
+	_, err := runTool(ctx, "", "minisign", "-V", "-q", "-p", opts.PublicKey, "-m", path, "-x", sigPath)
+	return err
+}
@@ -0,0 +1,46 @@
+package sparse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// DigHoles punches holes for the all-zero blocks of the file at path,
+// keeping its size, as fallocate --dig-holes.
+func DigHoles(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Runs of zero blocks are punched at once.
+	buf := make([]byte, BlockSize)
+	var off, hole int64
+	punch := func() error {
+		if off == hole {
+			return nil
+		}
+		return unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, hole, off-hole)
+	}
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n < BlockSize || !bytes.Equal(buf, zeroBlock) {
+			if err := punch(); err != nil {
+				return err
+			}
+			hole = off + int64(n)
+		}
+		off += int64(n)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return punch()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Package sparse writes and measures sparse files. Rootfs images are mostly
+// unused blocks: written with Copy their all-zero blocks become holes, so an
+// image keeps its small disk usage when copied or downloaded, and DiskUsage
+// reports the bytes actually allocated next to the apparent size.
+package sparse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// BlockSize is the granularity holes are detected at, the filesystem block
+// size of the images.
+const BlockSize = 4096
+
+var zeroBlock = make([]byte, BlockSize)
+
+// Copy writes the content of r to f from its current offset, seeking over
+// the all-zero blocks instead of writing them, and returns the number of
+// bytes copied. f is truncated to the copied size, so trailing holes are
+// kept. f must be a new or empty file for the skipped blocks to read back
+// as zeros.
+func Copy(f *os.File, r io.Reader) (int64, error) {
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, BlockSize)
+	var n int64
+	for {
+		read, err := io.ReadFull(r, buf)
+		if read > 0 {
+			block := buf[:read]
+			if bytes.Equal(block, zeroBlock[:read]) {
+				if _, err := f.Seek(int64(read), io.SeekCurrent); err != nil {
+					return n, err
+				}
+			} else if _, err := f.Write(block); err != nil {
+				return n, err
+			}
+			n += int64(read)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, f.Truncate(start + n)
+}
+
+// DiskUsage returns the bytes allocated on disk to the file at path, less
+// than its size when it has holes.
+func DiskUsage(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return allocated(fi), nil
+}
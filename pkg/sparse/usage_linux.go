@@ -0,0 +1,14 @@
+package sparse
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocated returns the allocated bytes of fi, st_blocks are 512 bytes.
+func allocated(fi os.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return fi.Size()
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package sparse
+
+import "os"
+
+// allocated returns the size of fi, the allocated size is not known.
+func allocated(fi os.FileInfo) int64 { return fi.Size() }
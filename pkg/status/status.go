@@ -0,0 +1,113 @@
+// Package status builds the release status document: a small, signed
+// summary of the validation checks a release went through before it was
+// published, so consumers can tell a fully validated version from a manual
+// upload.
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// FileName is the status document file name in the build dir and release.
+const FileName = "status.json"
+
+// Check results.
+const (
+	Passed  = "passed"
+	Failed  = "failed"
+	Skipped = "skipped"
+)
+
+// Release statuses.
+const (
+	// Validated releases passed every required check.
+	Validated = "validated"
+	// Incomplete releases skipped at least one required check.
+	Incomplete = "incomplete"
+	// Rejected releases failed at least one check.
+	Rejected = "failed"
+	// Unverified releases have no status document (e.g. manual uploads).
+	Unverified = "unverified"
+)
+
+// Required are the checks a release must pass to be validated.
+var Required = []string{"digests", "attestations", "scan", "boot"}
+
+// Check is the outcome of a validation check.
+type Check struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	// Date is when the check ran (RFC 3339).
+	Date    string `json:"date"`
+	Details string `json:"details,omitempty"`
+}
+
+// Document is the release status document.
+type Document struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+	// Status is the overall release status (validated, incomplete, failed).
+	Status string  `json:"status"`
+	Checks []Check `json:"checks"`
+	// Signing records the key the document is signed with.
+	Signing *manifest.Signing `json:"signing,omitempty"`
+}
+
+// New returns the document of checks with its overall status.
+func New(version, commit, date string, checks []Check) Document {
+	return Document{
+		Version: version,
+		Commit:  commit,
+		Date:    date,
+		Status:  Summarize(checks),
+		Checks:  checks,
+	}
+}
+
+// Summarize returns the release status of checks: failed when any check
+// failed, incomplete when a required check is skipped or missing, and
+// validated otherwise.
+func Summarize(checks []Check) string {
+	results := map[string]string{}
+	for _, c := range checks {
+		if c.Result == Failed {
+			return Rejected
+		}
+		results[c.Name] = c.Result
+	}
+	if slices.ContainsFunc(Required, func(name string) bool { return results[name] != Passed }) {
+		return Incomplete
+	}
+	return Validated
+}
+
+// Read loads a status document from path.
+func Read(path string) (Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Document{}, err
+	}
+	var d Document
+	if err := json.Unmarshal(data, &d); err != nil {
+		return Document{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// Write stores the document as indented JSON at path.
+func Write(path string, d Document) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling status: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package testutil
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"testing/fstest"
+)
+
+// RootfsFiles is the tree of the minimal rootfs fixture: an init script
+// printing the sbx-init boot marker, an os-release and a few directories.
+var RootfsFiles = fstest.MapFS{
+	"sbin/init":       {Data: []byte("#!/bin/sh\necho sbx-init\nexec /bin/sh\n"), Mode: 0o755},
+	"etc/os-release":  {Data: []byte("ID=sbx-test\nNAME=\"sbx-images test fixture\"\n"), Mode: 0o644},
+	"etc/hostname":    {Data: []byte("sbx-test\n"), Mode: 0o644},
+	"bin/sh":          {Data: []byte("busybox"), Mode: fs.ModeSymlink | 0o777},
+	"root":            {Mode: fs.ModeDir | 0o700},
+	"var/lib/sbx/.ok": {Data: nil, Mode: 0o644},
+}
+
+// ext4 fixture geometry: a single group of 1 KiB blocks, so at most 8 MiB.
+const (
+	ext4BlockSize      = 1024
+	ext4MaxBlocks      = 8 * ext4BlockSize
+	ext4InodeSize      = 128
+	ext4FirstInode     = 11
+	ext4RootInode      = 2
+	ext4SuperblockAt   = 1024
+	ext4InodeTableAt   = 5
+	ext4FreeBlocks     = 16
+	ext4FastSymlinkMax = 60
+	// Feature flags: directory entry file types and extents.
+	ext4IncompatFiletype = 0x2
+	ext4IncompatExtents  = 0x40
+	ext4InodeFlagExtents = 0x80000
+	ext4ExtentMagic      = 0xf30a
+)
+
+// ext4Node is a file, directory or symlink of the fixture tree.
+type ext4Node struct {
+	name     string
+	mode     fs.FileMode
+	data     []byte
+	children []*ext4Node
+	inode    uint32
+	start    uint32
+	blocks   uint32
+}
+
+// Ext4 returns an ext4 image (extents, no journal) holding the regular
+// files, directories and symlinks of fsys and a lost+found, small enough to
+// build in a test: up to 8 MiB, symlink targets under 60 bytes. The image is
+// deterministic, its UUID derived from the tree, and passes e2fsck.
+func Ext4(fsys fs.FS) ([]byte, error) {
+	root := &ext4Node{mode: fs.ModeDir | 0o755}
+	dirs := map[string]*ext4Node{".": root}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		n := &ext4Node{name: d.Name(), mode: info.Mode()}
+		switch {
+		case d.IsDir():
+			dirs[p] = n
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := fs.ReadLink(fsys, p)
+			if err != nil {
+				return err
+			}
+			if len(target) >= ext4FastSymlinkMax {
+				return fmt.Errorf("%s: symlink target longer than %d bytes", p, ext4FastSymlinkMax-1)
+			}
+			n.data = []byte(target)
+		case d.Type().IsRegular():
+			if n.data, err = fs.ReadFile(fsys, p); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unsupported file type %s", p, d.Type())
+		}
+		parent := dirs[path.Dir(p)]
+		parent.children = append(parent.children, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := dirs["lost+found"]; !ok {
+		root.children = append(root.children, &ext4Node{name: "lost+found", mode: fs.ModeDir | 0o700})
+	}
+
+	// Inodes and data blocks are allocated depth first, in name order.
+	var nodes []*ext4Node
+	var walk func(n *ext4Node)
+	walk = func(n *ext4Node) {
+		nodes = append(nodes, n)
+		sort.Slice(n.children, func(i, j int) bool { return n.children[i].name < n.children[j].name })
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	inodes := uint32(max(32, (ext4FirstInode+len(nodes)+7)/8*8))
+	tableBlocks := inodes * ext4InodeSize / ext4BlockSize
+	next := uint32(ext4InodeTableAt) + tableBlocks
+	ino := uint32(ext4FirstInode)
+	dirCount := uint32(0)
+	for _, n := range nodes {
+		if n == root {
+			n.inode = ext4RootInode
+		} else {
+			n.inode = ino
+			ino++
+		}
+		if n.mode.IsDir() {
+			dirCount++
+		}
+	}
+	for _, n := range nodes {
+		if n.mode.IsDir() {
+			n.data = dirBlock(n, nodes)
+			if len(n.data) > ext4BlockSize {
+				return nil, fmt.Errorf("directory %q has too many entries", n.name)
+			}
+		}
+		if n.mode&fs.ModeSymlink == 0 && len(n.data) > 0 {
+			n.start = next
+			n.blocks = uint32((len(n.data) + ext4BlockSize - 1) / ext4BlockSize)
+			next += n.blocks
+		}
+	}
+	total := max(next+ext4FreeBlocks, 64)
+	if total > ext4MaxBlocks {
+		return nil, fmt.Errorf("tree needs %d KiB, more than the %d KiB of the fixture", next, ext4MaxBlocks)
+	}
+
+	img := make([]byte, int(total)*ext4BlockSize)
+	le := binary.LittleEndian
+	block := func(n uint32) []byte { return img[n*ext4BlockSize : (n+1)*ext4BlockSize] }
+
+	// Superblock.
+	id := sha256.New()
+	sb := img[ext4SuperblockAt : ext4SuperblockAt+1024]
+	le.PutUint32(sb[0x00:], inodes)
+	le.PutUint32(sb[0x04:], total)
+	le.PutUint32(sb[0x0c:], total-next)
+	le.PutUint32(sb[0x10:], inodes-ino+1)
+	le.PutUint32(sb[0x14:], 1)
+	le.PutUint32(sb[0x20:], ext4MaxBlocks)
+	le.PutUint32(sb[0x24:], ext4MaxBlocks)
+	le.PutUint32(sb[0x28:], inodes)
+	le.PutUint16(sb[0x36:], 0xffff)
+	le.PutUint16(sb[0x38:], 0xef53)
+	le.PutUint16(sb[0x3a:], 1)
+	le.PutUint16(sb[0x3c:], 1)
+	le.PutUint32(sb[0x4c:], 1)
+	le.PutUint32(sb[0x54:], ext4FirstInode)
+	le.PutUint16(sb[0x58:], ext4InodeSize)
+	le.PutUint32(sb[0x60:], ext4IncompatFiletype|ext4IncompatExtents)
+	copy(sb[0x78:], "rootfs")
+
+	// Group descriptor, bitmaps and inodes.
+	gd := block(2)
+	le.PutUint32(gd[0x00:], 3)
+	le.PutUint32(gd[0x04:], 4)
+	le.PutUint32(gd[0x08:], ext4InodeTableAt)
+	le.PutUint16(gd[0x0c:], uint16(total-next))
+	le.PutUint16(gd[0x0e:], uint16(inodes-ino+1))
+	le.PutUint16(gd[0x10:], uint16(dirCount))
+	// The block bitmap starts at the first data block, block 1; the bits
+	// past the end of the filesystem are set.
+	setBits(block(3), 0, next-1)
+	setBits(block(3), total-1, ext4BlockSize*8)
+	setBits(block(4), 0, ino-1)
+	setBits(block(4), inodes, ext4BlockSize*8)
+
+	for _, n := range nodes {
+		raw := img[ext4InodeTableAt*ext4BlockSize+(n.inode-1)*ext4InodeSize:][:ext4InodeSize]
+		le.PutUint16(raw[0x00:], unixMode(n.mode))
+		le.PutUint32(raw[0x04:], uint32(len(n.data)))
+		links := uint16(1)
+		if n.mode.IsDir() {
+			links = 2
+			for _, c := range n.children {
+				if c.mode.IsDir() {
+					links++
+				}
+			}
+		}
+		le.PutUint16(raw[0x1a:], links)
+		if n.mode&fs.ModeSymlink != 0 {
+			copy(raw[0x28:], n.data)
+		} else {
+			le.PutUint32(raw[0x1c:], n.blocks*ext4BlockSize/512)
+			le.PutUint32(raw[0x20:], ext4InodeFlagExtents)
+			ext := raw[0x28:]
+			le.PutUint16(ext[0:], ext4ExtentMagic)
+			le.PutUint16(ext[4:], 4)
+			if n.blocks > 0 {
+				le.PutUint16(ext[2:], 1)
+				le.PutUint16(ext[16:], uint16(n.blocks))
+				le.PutUint32(ext[20:], n.start)
+				copy(img[n.start*ext4BlockSize:], n.data)
+			}
+		}
+		fmt.Fprintf(id, "%d %o %x\n", n.inode, n.mode, n.data)
+	}
+	copy(sb[0x68:0x78], id.Sum(nil))
+	return img, nil
+}
+
+// dirBlock returns the linear directory entries of dir, the last one
+// spanning the rest of the block.
+func dirBlock(dir *ext4Node, nodes []*ext4Node) []byte {
+	parent := uint32(ext4RootInode)
+	for _, n := range nodes {
+		for _, c := range n.children {
+			if c == dir {
+				parent = n.inode
+			}
+		}
+	}
+	type entry struct {
+		inode uint32
+		name  string
+		typ   byte
+	}
+	entries := []entry{{dir.inode, ".", 2}, {parent, "..", 2}}
+	for _, c := range dir.children {
+		typ := byte(1)
+		switch {
+		case c.mode.IsDir():
+			typ = 2
+		case c.mode&fs.ModeSymlink != 0:
+			typ = 7
+		}
+		entries = append(entries, entry{c.inode, c.name, typ})
+	}
+
+	le := binary.LittleEndian
+	var data []byte
+	for i, e := range entries {
+		size := (8 + len(e.name) + 3) / 4 * 4
+		recLen := size
+		if i == len(entries)-1 {
+			recLen = max(ext4BlockSize-len(data), size)
+		}
+		rec := make([]byte, recLen)
+		le.PutUint32(rec[0:], e.inode)
+		le.PutUint16(rec[4:], uint16(recLen))
+		rec[6] = byte(len(e.name))
+		rec[7] = e.typ
+		copy(rec[8:], e.name)
+		data = append(data, rec...)
+	}
+	return data
+}
+
+// unixMode returns the inode mode of mode.
+func unixMode(mode fs.FileMode) uint16 {
+	m := uint16(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= 0o040000
+	case mode&fs.ModeSymlink != 0:
+		m |= 0o120000
+	default:
+		m |= 0o100000
+	}
+	return m
+}
+
+// setBits sets the bits [from, to) of bitmap.
+func setBits(bitmap []byte, from, to uint32) {
+	for i := from; i < to; i++ {
+		bitmap[i/8] |= 1 << (i % 8)
+	}
+}
@@ -0,0 +1,155 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/export"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/serve"
+	"github.com/slok/sbx-images/pkg/verify"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+// NewReleaseServer serves dir over HTTP as cmd/serve does, until the end of
+// the test. The release files are at <URL>/<name>.
+func NewReleaseServer(t testing.TB, dir string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(serve.Server{Dir: dir}.Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Download fetches manifest.json and the release files of arch (of every
+// architecture when empty) from the release at baseURL to dir, and returns
+// the manifest.
+func Download(ctx context.Context, baseURL, dir, arch string) (manifest.Manifest, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return manifest.Manifest{}, err
+	}
+	manifestPath := filepath.Join(dir, "manifest.json")
+	if err := download(ctx, baseURL, "manifest.json", manifestPath); err != nil {
+		return manifest.Manifest{}, err
+	}
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return manifest.Manifest{}, err
+	}
+
+	files := m.Files()
+	if arch != "" {
+		a, ok := m.Artifacts[arch]
+		if !ok {
+			return manifest.Manifest{}, fmt.Errorf("no %s artifacts in manifest", arch)
+		}
+		files = a.Files()
+	}
+	for _, name := range files {
+		if err := download(ctx, baseURL, name, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return manifest.Manifest{}, err
+		}
+	}
+	return m, nil
+}
+
+func download(ctx context.Context, baseURL, name, path string) error {
+	u := strings.TrimSuffix(baseURL, "/") + "/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", u, resp.StatusCode)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("downloading %s: %w", name, err)
+	}
+	return f.Close()
+}
+
+// Verify checks the kernels, rootfs images, initramfs and bundled binaries
+// of arch in dir against the manifest digests, as cmd/verify does. A
+// mismatch is an error of the exitcode.DigestMismatch class.
+func Verify(dir string, m manifest.Manifest, arch string) error {
+	a, ok := m.Artifacts[arch]
+	if !ok {
+		return fmt.Errorf("no %s artifacts in manifest", arch)
+	}
+
+	digests := map[string]string{}
+	for _, k := range a.Kernels() {
+		digests[k.File] = k.SHA256
+		for _, img := range k.Images {
+			digests[img.File] = img.SHA256
+		}
+	}
+	for _, r := range a.Rootfses() {
+		digests[r.File] = r.SHA256
+		for _, img := range r.ExtraImages() {
+			digests[img.File] = img.SHA256
+		}
+	}
+	if a.Initramfs != nil {
+		digests[a.Initramfs.File] = a.Initramfs.SHA256
+	}
+	for _, b := range a.Firecracker.Binaries() {
+		digests[b.File] = b.SHA256
+	}
+	for file, sha256 := range digests {
+		if _, err := verify.File(filepath.Join(dir, file), sha256, verify.Options{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VMConfig returns the Firecracker VM config booting the default kernel and
+// rootfs of arch in dir, as cmd/vmconfig does.
+func VMConfig(dir string, m manifest.Manifest, arch string, opts vmconfig.Options) (vmconfig.Config, error) {
+	src, err := export.Select(dir, m, arch, "", "")
+	if err != nil {
+		return vmconfig.Config{}, err
+	}
+	return vmconfig.ForRelease(dir, m.Artifacts[arch], arch, src.Kernel, src.Rootfs, opts)
+}
+
+// Flow downloads the arch release files from baseURL to a temporary
+// directory of the test, verifies them and returns the VM config booting
+// them, failing the test on errors.
+func Flow(t testing.TB, baseURL, arch string, opts vmconfig.Options) vmconfig.Config {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := Download(t.Context(), baseURL, dir, arch)
+	if err != nil {
+		t.Fatalf("downloading release: %v", err)
+	}
+	if err := Verify(dir, m, arch); err != nil {
+		t.Fatalf("verifying release: %v", err)
+	}
+	cfg, err := VMConfig(dir, m, arch, opts)
+	if err != nil {
+		t.Fatalf("generating VM config: %v", err)
+	}
+	return cfg
+}
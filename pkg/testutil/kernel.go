@@ -0,0 +1,71 @@
+package testutil
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// Kernel image header magics, as pkg/inspect reads them.
+const (
+	arm64ImageMagic = 0x644d5241
+	riscvImageMagic = 0x05435352
+	riscvMagic      = "RISCV\x00\x00\x00"
+	imageHeaderSize = 64
+	kernelLoadAddr  = 0x1000000
+)
+
+// Kernel returns a minimal kernel image of arch in manifest.KernelFormat(arch)
+// carrying the "Linux version <version>" banner: an ELF vmlinux for x86_64,
+// an arm64 or riscv64 Image otherwise. It is well-formed for pkg/inspect and
+// the release tooling but does not boot.
+func Kernel(arch, version string) ([]byte, error) {
+	banner := []byte(fmt.Sprintf("Linux version %s (testutil@sbx-images) #1 SMP\n\x00", version))
+	le := binary.LittleEndian
+
+	switch arch {
+	case "x86_64":
+		const ehsize, phentsize = 64, 56
+		data := make([]byte, ehsize+phentsize, ehsize+phentsize+len(banner))
+		copy(data, elf.ELFMAG)
+		data[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+		data[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+		data[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+		le.PutUint16(data[16:], uint16(elf.ET_EXEC))
+		le.PutUint16(data[18:], uint16(elf.EM_X86_64))
+		le.PutUint32(data[20:], uint32(elf.EV_CURRENT))
+		le.PutUint64(data[24:], kernelLoadAddr)
+		le.PutUint64(data[32:], ehsize)
+		le.PutUint16(data[52:], ehsize)
+		le.PutUint16(data[54:], phentsize)
+		le.PutUint16(data[56:], 1)
+		le.PutUint16(data[58:], 64)
+
+		// A single loadable segment holding the banner.
+		ph := data[ehsize:]
+		le.PutUint32(ph[0:], uint32(elf.PT_LOAD))
+		le.PutUint32(ph[4:], uint32(elf.PF_R|elf.PF_X))
+		le.PutUint64(ph[8:], ehsize+phentsize)
+		le.PutUint64(ph[16:], kernelLoadAddr)
+		le.PutUint64(ph[24:], kernelLoadAddr)
+		le.PutUint64(ph[32:], uint64(len(banner)))
+		le.PutUint64(ph[40:], uint64(len(banner)))
+		le.PutUint64(ph[48:], 1)
+		return append(data, banner...), nil
+
+	case "aarch64", "riscv64":
+		data := make([]byte, imageHeaderSize, imageHeaderSize+len(banner))
+		le.PutUint64(data[16:], uint64(imageHeaderSize+len(banner)))
+		if arch == "aarch64" {
+			// Little endian kernel, 4K pages.
+			le.PutUint64(data[24:], 0xa)
+			le.PutUint32(data[56:], arm64ImageMagic)
+		} else {
+			le.PutUint32(data[32:], 2)
+			copy(data[48:], riscvMagic)
+			le.PutUint32(data[56:], riscvImageMagic)
+		}
+		return append(data, banner...), nil
+	}
+	return nil, fmt.Errorf("unsupported architecture %q", arch)
+}
@@ -0,0 +1,158 @@
+// Package testutil helps testing against the release formats without the
+// real multi-GB artifacts: minimal kernel and rootfs fixtures (Kernel, Ext4),
+// fake releases of them with a valid manifest.json (WriteRelease) served
+// over HTTP as cmd/serve does (NewReleaseServer), and the download, verify
+// and vmconfig steps of a release consumer (Download, Verify, VMConfig).
+//
+// The fixtures are well-formed for the release tooling (pkg/inspect,
+// pkg/ext4, e2fsck) but the kernels do not boot.
+package testutil
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/boot"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// Release defaults.
+const (
+	DefaultVersion       = "v0.0.0-test"
+	DefaultKernelVersion = "6.1.0"
+	DefaultProfile       = "base"
+)
+
+// Release is a fake release written by WriteRelease.
+type Release struct {
+	// Version is the release version (default DefaultVersion).
+	Version string
+	// Architectures are the release architectures (default x86_64).
+	Architectures []string
+	// Profiles are the rootfs profiles, the first one being the default
+	// (default DefaultProfile).
+	Profiles []string
+	// Files is the rootfs tree of every profile (default RootfsFiles).
+	Files fs.FS
+	// KernelVersion is the version of the kernel banner (default
+	// DefaultKernelVersion).
+	KernelVersion string
+}
+
+// WriteRelease writes the default kernel and the ext4 rootfs image of every
+// profile of every architecture of r to dir, with the manifest.json
+// describing them, and returns the manifest.
+func WriteRelease(dir string, r Release) (manifest.Manifest, error) {
+	r.Version = cmp.Or(r.Version, DefaultVersion)
+	r.KernelVersion = cmp.Or(r.KernelVersion, DefaultKernelVersion)
+	if len(r.Architectures) == 0 {
+		r.Architectures = []string{"x86_64"}
+	}
+	if len(r.Profiles) == 0 {
+		r.Profiles = []string{DefaultProfile}
+	}
+	if r.Files == nil {
+		r.Files = RootfsFiles
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return manifest.Manifest{}, err
+	}
+
+	rootfs, err := Ext4(r.Files)
+	if err != nil {
+		return manifest.Manifest{}, fmt.Errorf("rootfs fixture: %w", err)
+	}
+	write := func(name string, data []byte) (int64, string, error) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return 0, "", err
+		}
+		sum := sha256.Sum256(data)
+		return int64(len(data)), hex.EncodeToString(sum[:]), nil
+	}
+
+	m := manifest.Manifest{
+		SchemaVersion: 1,
+		Version:       r.Version,
+		Artifacts:     map[string]manifest.ArchArtifacts{},
+		Firecracker:   manifest.Firecracker{Version: "v1.12.0", Source: "github.com/firecracker-microvm/firecracker"},
+		Build:         manifest.Build{Date: "2026-01-01T00:00:00Z", Commit: "testutil"},
+	}
+	for _, arch := range r.Architectures {
+		kernel, err := Kernel(arch, r.KernelVersion)
+		if err != nil {
+			return manifest.Manifest{}, err
+		}
+		k := manifest.KernelArtifact{
+			File:    manifest.KernelImageFile(manifest.KernelFormat(arch), manifest.DefaultFlavor, arch),
+			Format:  manifest.KernelFormat(arch),
+			Version: r.KernelVersion,
+			Source:  "testutil",
+		}
+		if k.SizeBytes, k.SHA256, err = write(k.File, kernel); err != nil {
+			return manifest.Manifest{}, err
+		}
+
+		a := manifest.ArchArtifacts{Kernel: k}
+		for i, profile := range r.Profiles {
+			// The default profile images are named after the
+			// architecture only, as config.RootfsProfile.Stem does.
+			stem := arch
+			if i > 0 {
+				stem = profile + "-" + arch
+			}
+			img := manifest.RootfsArtifact{
+				File:          manifest.RootfsImageFile(manifest.RootfsFilesystemExt4, stem),
+				Filesystem:    manifest.RootfsFilesystemExt4,
+				Distro:        "testutil",
+				DistroVersion: r.Version,
+				Profile:       profile,
+				BootArgs:      boot.DefaultBootArgs,
+			}
+			if img.SizeBytes, img.SHA256, err = write(img.File, rootfs); err != nil {
+				return manifest.Manifest{}, err
+			}
+			if i == 0 {
+				a.Rootfs = img
+			} else {
+				a.RootfsProfiles = append(a.RootfsProfiles, img)
+			}
+		}
+		m.Artifacts[arch] = a
+	}
+	if err := manifest.Write(filepath.Join(dir, "manifest.json"), m); err != nil {
+		return manifest.Manifest{}, err
+	}
+	return m, nil
+}
+
+// NewRelease writes the release r to a temporary directory of the test and
+// returns the directory and manifest.
+func NewRelease(t testing.TB, r Release) (string, manifest.Manifest) {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := WriteRelease(dir, r)
+	if err != nil {
+		t.Fatalf("writing release: %v", err)
+	}
+	return dir, m
+}
+
+// Corrupt flips the last byte of the file at path, for the tests of digest
+// mismatches.
+func Corrupt(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	data[len(data)-1] ^= 0xff
+	return os.WriteFile(path, data, 0o644)
+}
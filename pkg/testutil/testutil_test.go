@@ -0,0 +1,149 @@
+package testutil_test
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/exitcode"
+	"github.com/slok/sbx-images/pkg/ext4"
+	"github.com/slok/sbx-images/pkg/inspect"
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/testutil"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+func TestKernel(t *testing.T) {
+	for _, arch := range manifest.Architectures {
+		t.Run(arch, func(t *testing.T) {
+			data, err := testutil.Kernel(arch, "6.1.99")
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "vmlinux")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			k, err := inspect.Kernel(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.Arch != arch || k.Format != manifest.KernelFormat(arch) {
+				t.Errorf("got %s %s kernel, want %s %s", k.Arch, k.Format, arch, manifest.KernelFormat(arch))
+			}
+			if want := "Linux version 6.1.99 "; !strings.HasPrefix(k.Version, want) {
+				t.Errorf("got version %q, want %q prefix", k.Version, want)
+			}
+		})
+	}
+
+	if _, err := testutil.Kernel("mips", "6.1.99"); err == nil {
+		t.Error("expected an error for an unsupported architecture")
+	}
+}
+
+func TestExt4(t *testing.T) {
+	data, err := testutil.Ext4(testutil.RootfsFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "rootfs.ext4")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := inspect.Filesystem(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != manifest.RootfsFilesystemExt4 || info.Label != "rootfs" {
+		t.Errorf("got %s filesystem labeled %q, want ext4 labeled rootfs", info.Type, info.Label)
+	}
+
+	fsys, err := ext4.New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range testutil.RootfsFiles {
+		if !f.Mode.IsRegular() {
+			continue
+		}
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("reading %s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, f.Data) {
+			t.Errorf("%s: got %q, want %q", name, got, f.Data)
+		}
+	}
+	if info, err := fs.Stat(fsys, "sbin/init"); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("sbin/init: got %v (%v), want mode 0755", info, err)
+	}
+
+	again, err := testutil.Ext4(testutil.RootfsFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Error("the image is not deterministic")
+	}
+
+	if _, err := exec.LookPath("e2fsck"); err != nil {
+		t.Skip("e2fsck not available")
+	}
+	if out, err := exec.Command("e2fsck", "-fn", path).CombinedOutput(); err != nil {
+		t.Errorf("e2fsck: %v\n%s", err, out)
+	}
+}
+
+func TestFlow(t *testing.T) {
+	dir, m := testutil.NewRelease(t, testutil.Release{
+		Architectures: []string{"x86_64", "aarch64"},
+		Profiles:      []string{"base", "minimal"},
+	})
+	if got := len(m.Files()); got != 6 {
+		t.Errorf("got %d release files, want 6", got)
+	}
+	srv := testutil.NewReleaseServer(t, dir)
+
+	cfg := testutil.Flow(t, srv.URL, "aarch64", vmconfig.Options{VsockCID: 3, VsockUDSPath: "vsock.sock"})
+	if got := filepath.Base(cfg.BootSource.KernelImagePath); got != "vmlinux-aarch64" {
+		t.Errorf("got kernel %s, want vmlinux-aarch64", got)
+	}
+	if len(cfg.Drives) != 1 || filepath.Base(cfg.Drives[0].PathOnHost) != "rootfs-aarch64.ext4" || !cfg.Drives[0].IsRootDevice {
+		t.Errorf("got drives %+v, want the rootfs-aarch64.ext4 root drive", cfg.Drives)
+	}
+	if cfg.Vsock == nil || cfg.Vsock.GuestCID != 3 {
+		t.Errorf("got vsock %+v, want guest CID 3", cfg.Vsock)
+	}
+	if cfg.BootSource.BootArgs == "" {
+		t.Error("got no boot args")
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	dir, m := testutil.NewRelease(t, testutil.Release{})
+	srv := testutil.NewReleaseServer(t, dir)
+
+	dl := t.TempDir()
+	if _, err := testutil.Download(t.Context(), srv.URL, dl, "x86_64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.Corrupt(filepath.Join(dl, m.Artifacts["x86_64"].Rootfs.File)); err != nil {
+		t.Fatal(err)
+	}
+	err := testutil.Verify(dl, m, "x86_64")
+	if got := exitcode.Of(err); got != exitcode.DigestMismatch {
+		t.Errorf("got exit code %d (%v), want %d", got, err, exitcode.DigestMismatch)
+	}
+
+	if _, err := testutil.Download(t.Context(), srv.URL, t.TempDir(), "riscv64"); err == nil {
+		t.Error("expected an error downloading a missing architecture")
+	}
+}
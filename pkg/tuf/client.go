@@ -0,0 +1,420 @@
+package tuf
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by fetchers when a metadata file does not exist.
+var ErrNotFound = errors.New("not found")
+
+const (
+	maxRootRotations = 32
+	maxMetadataBytes = 4 << 20
+)
+
+// Fetcher retrieves remote repository files.
+type Fetcher interface {
+	Fetch(ctx context.Context, name string, maxBytes int64) ([]byte, error)
+}
+
+// HTTPFetcher fetches files below a base URL.
+type HTTPFetcher struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// Fetch downloads name, failing when it is larger than maxBytes.
+func (f HTTPFetcher) Fetch(ctx context.Context, name string, maxBytes int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(f.BaseURL, "/")+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("fetching %s: %w", name, ErrNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", name, resp.Status)
+	}
+	return readLimited(resp.Body, name, maxBytes)
+}
+
+// DirFetcher reads files from a local directory.
+type DirFetcher struct {
+	Dir string
+}
+
+// Fetch reads name, failing when it is larger than maxBytes.
+func (f DirFetcher) Fetch(_ context.Context, name string, maxBytes int64) ([]byte, error) {
+	file, err := os.Open(filepath.Join(f.Dir, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readLimited(file, name, maxBytes)
+}
+
+func readLimited(r io.Reader, name string, maxBytes int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%s exceeds %d bytes", name, maxBytes)
+	}
+	return data, nil
+}
+
+// Client updates and verifies repository metadata following the TUF client
+// workflow. Trusted metadata is persisted in a local state directory, which
+// is what provides rollback protection across runs.
+type Client struct {
+	fetcher  Fetcher
+	stateDir string
+	now      func() time.Time
+
+	root      Root
+	timestamp *Timestamp
+	snapshot  *Snapshot
+	targets   *Targets
+}
+
+// NewClient returns a client using the trusted metadata in stateDir, which
+// must hold at least a root.json obtained out of band (see Bootstrap).
+func NewClient(f Fetcher, stateDir string) (*Client, error) {
+	c := &Client{fetcher: f, stateDir: stateDir, now: time.Now}
+
+	data, err := os.ReadFile(filepath.Join(stateDir, RoleRoot+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("reading trusted root: %w", err)
+	}
+	root, err := verifyRootSelf(data)
+	if err != nil {
+		return nil, fmt.Errorf("trusted root: %w", err)
+	}
+	c.root = root
+
+	// Previously trusted metadata only feeds the rollback checks; it is
+	// re-verified against the current root before use.
+	c.timestamp = loadTrusted[Timestamp](stateDir, RoleTimestamp, root)
+	c.snapshot = loadTrusted[Snapshot](stateDir, RoleSnapshot, root)
+
+	return c, nil
+}
+
+// Bootstrap initializes stateDir with a trusted root.json.
+func Bootstrap(stateDir, rootPath string) error {
+	data, err := os.ReadFile(rootPath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", rootPath, err)
+	}
+	if _, err := verifyRootSelf(data); err != nil {
+		return fmt.Errorf("%s: %w", rootPath, err)
+	}
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return fmt.Errorf("creating %s: %w", stateDir, err)
+	}
+	return os.WriteFile(filepath.Join(stateDir, RoleRoot+".json"), data, 0o644)
+}
+
+// Update refreshes all top level metadata from the repository.
+func (c *Client) Update(ctx context.Context) error {
+	if err := c.updateRoot(ctx); err != nil {
+		return fmt.Errorf("updating root: %w", err)
+	}
+	if err := c.updateTimestamp(ctx); err != nil {
+		return fmt.Errorf("updating timestamp: %w", err)
+	}
+	if err := c.updateSnapshot(ctx); err != nil {
+		return fmt.Errorf("updating snapshot: %w", err)
+	}
+	if err := c.updateTargets(ctx); err != nil {
+		return fmt.Errorf("updating targets: %w", err)
+	}
+	return nil
+}
+
+// Target returns the trusted description of a target. Update must be called first.
+func (c *Client) Target(name string) (TargetFile, error) {
+	if c.targets == nil {
+		return TargetFile{}, fmt.Errorf("targets metadata not loaded")
+	}
+	tf, ok := c.targets.Targets[name]
+	if !ok {
+		return TargetFile{}, fmt.Errorf("target %s is not listed in targets metadata", name)
+	}
+	return tf, nil
+}
+
+// VerifyTarget checks r has the trusted length and hashes of target name.
+func (c *Client) VerifyTarget(name string, r io.Reader) error {
+	tf, err := c.Target(name)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, io.LimitReader(r, tf.Length+1))
+	if err != nil {
+		return fmt.Errorf("hashing %s: %w", name, err)
+	}
+	if n != tf.Length {
+		return fmt.Errorf("target %s: length mismatch (expected %d, got at least %d)", name, tf.Length, n)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != tf.Hashes["sha256"] {
+		return fmt.Errorf("target %s: sha256 mismatch (expected %s, got %s)", name, tf.Hashes["sha256"], got)
+	}
+	return nil
+}
+
+func (c *Client) updateRoot(ctx context.Context) error {
+	for range maxRootRotations {
+		next := c.root.Version + 1
+		data, err := c.fetcher.Fetch(ctx, fmt.Sprintf("%d.%s.json", next, RoleRoot), maxMetadataBytes)
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		env, err := parseEnvelope(data)
+		if err != nil {
+			return err
+		}
+		// A new root must be signed by the old and the new root keys.
+		var newRoot Root
+		if err := VerifyRole(env, c.root, RoleRoot, &newRoot); err != nil {
+			return fmt.Errorf("version %d not trusted by version %d: %w", next, c.root.Version, err)
+		}
+		if err := VerifyRole(env, newRoot, RoleRoot, &newRoot); err != nil {
+			return fmt.Errorf("version %d: %w", next, err)
+		}
+		if newRoot.Version != next {
+			return fmt.Errorf("expected root version %d, got %d", next, newRoot.Version)
+		}
+
+		// Rotated timestamp or snapshot keys invalidate the trusted state
+		// they signed (fast forward attack recovery).
+		if !sameRoleKeys(c.root.Roles[RoleTimestamp], newRoot.Roles[RoleTimestamp]) {
+			c.timestamp = nil
+			c.snapshot = nil
+		}
+		if !sameRoleKeys(c.root.Roles[RoleSnapshot], newRoot.Roles[RoleSnapshot]) {
+			c.snapshot = nil
+		}
+
+		c.root = newRoot
+		if err := c.persist(RoleRoot, data); err != nil {
+			return err
+		}
+	}
+
+	return c.checkExpired(c.root.Common)
+}
+
+func (c *Client) updateTimestamp(ctx context.Context) error {
+	data, err := c.fetcher.Fetch(ctx, RoleTimestamp+".json", maxMetadataBytes)
+	if err != nil {
+		return err
+	}
+
+	var ts Timestamp
+	if err := c.verify(data, RoleTimestamp, &ts, &ts.Common); err != nil {
+		return err
+	}
+	if c.timestamp != nil {
+		if ts.Version < c.timestamp.Version {
+			return fmt.Errorf("rollback detected: version %d is older than trusted %d", ts.Version, c.timestamp.Version)
+		}
+		if ts.Meta[RoleSnapshot+".json"].Version < c.timestamp.Meta[RoleSnapshot+".json"].Version {
+			return fmt.Errorf("rollback detected: snapshot version went backwards")
+		}
+	}
+	if err := c.checkExpired(ts.Common); err != nil {
+		return err
+	}
+
+	c.timestamp = &ts
+	return c.persist(RoleTimestamp, data)
+}
+
+func (c *Client) updateSnapshot(ctx context.Context) error {
+	meta, ok := c.timestamp.Meta[RoleSnapshot+".json"]
+	if !ok {
+		return fmt.Errorf("timestamp does not reference snapshot.json")
+	}
+
+	data, err := c.fetchMeta(ctx, RoleSnapshot+".json", meta)
+	if err != nil {
+		return err
+	}
+
+	var snap Snapshot
+	if err := c.verify(data, RoleSnapshot, &snap, &snap.Common); err != nil {
+		return err
+	}
+	if snap.Version != meta.Version {
+		return fmt.Errorf("expected version %d from timestamp, got %d", meta.Version, snap.Version)
+	}
+	if c.snapshot != nil {
+		for name, old := range c.snapshot.Meta {
+			cur, ok := snap.Meta[name]
+			if !ok {
+				return fmt.Errorf("%s removed from snapshot", name)
+			}
+			if cur.Version < old.Version {
+				return fmt.Errorf("rollback detected: %s version %d is older than trusted %d", name, cur.Version, old.Version)
+			}
+		}
+	}
+	if err := c.checkExpired(snap.Common); err != nil {
+		return err
+	}
+
+	c.snapshot = &snap
+	return c.persist(RoleSnapshot, data)
+}
+
+func (c *Client) updateTargets(ctx context.Context) error {
+	meta, ok := c.snapshot.Meta[RoleTargets+".json"]
+	if !ok {
+		return fmt.Errorf("snapshot does not reference targets.json")
+	}
+
+	data, err := c.fetchMeta(ctx, RoleTargets+".json", meta)
+	if err != nil {
+		return err
+	}
+
+	var targets Targets
+	if err := c.verify(data, RoleTargets, &targets, &targets.Common); err != nil {
+		return err
+	}
+	if targets.Version != meta.Version {
+		return fmt.Errorf("expected version %d from snapshot, got %d", meta.Version, targets.Version)
+	}
+	if err := c.checkExpired(targets.Common); err != nil {
+		return err
+	}
+
+	c.targets = &targets
+	return c.persist(RoleTargets, data)
+}
+
+// fetchMeta downloads a metadata file checking the length and hashes listed
+// by the referencing role, when present.
+func (c *Client) fetchMeta(ctx context.Context, name string, meta MetaFile) ([]byte, error) {
+	limit := int64(maxMetadataBytes)
+	if meta.Length > 0 {
+		limit = meta.Length
+	}
+
+	data, err := c.fetcher.Fetch(ctx, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	if want, ok := meta.Hashes["sha256"]; ok {
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			return nil, fmt.Errorf("%s: sha256 mismatch (expected %s, got %s)", name, want, got)
+		}
+	}
+	return data, nil
+}
+
+func (c *Client) verify(data []byte, role string, dst any, common *Common) error {
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return err
+	}
+	if err := VerifyRole(env, c.root, role, dst); err != nil {
+		return err
+	}
+	return checkType(*common, role)
+}
+
+func (c *Client) checkExpired(m Common) error {
+	if !c.now().Before(m.Expires) {
+		return fmt.Errorf("%s metadata version %d expired at %s", m.Type, m.Version, m.Expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (c *Client) persist(role string, data []byte) error {
+	tmp := filepath.Join(c.stateDir, "."+role+".json.tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("persisting %s: %w", role, err)
+	}
+	return os.Rename(tmp, filepath.Join(c.stateDir, role+".json"))
+}
+
+func parseEnvelope(data []byte) (Envelope, error) {
+	var env Envelope
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&env); err != nil {
+		return Envelope{}, fmt.Errorf("parsing metadata: %w", err)
+	}
+	return env, nil
+}
+
+func verifyRootSelf(data []byte) (Root, error) {
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return Root{}, err
+	}
+
+	var root Root
+	if err := json.Unmarshal(env.Signed, &root); err != nil {
+		return Root{}, fmt.Errorf("decoding root: %w", err)
+	}
+	if err := VerifyRole(env, root, RoleRoot, &root); err != nil {
+		return Root{}, err
+	}
+	if err := checkType(root.Common, RoleRoot); err != nil {
+		return Root{}, err
+	}
+	return root, nil
+}
+
+func loadTrusted[T any](stateDir, role string, root Root) *T {
+	data, err := os.ReadFile(filepath.Join(stateDir, role+".json"))
+	if err != nil {
+		return nil
+	}
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return nil
+	}
+	var v T
+	if err := VerifyRole(env, root, role, &v); err != nil {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,350 @@
+package tuf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixture is a repository published to dir with a client state trusting
+// its first root.
+type fixture struct {
+	t        *testing.T
+	dir      string
+	stateDir string
+	target   string
+	keys     map[string]*PrivateKey
+	expires  map[string]time.Duration
+	now      time.Time
+}
+
+func newFixture(t *testing.T, expires map[string]time.Duration) *fixture {
+	t.Helper()
+	f := &fixture{
+		t:        t,
+		dir:      t.TempDir(),
+		stateDir: t.TempDir(),
+		target:   filepath.Join(t.TempDir(), "rootfs.ext4"),
+		keys:     map[string]*PrivateKey{},
+		expires:  expires,
+		now:      time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, role := range Roles {
+		f.keys[role] = f.newKey()
+	}
+	if err := os.WriteFile(f.target, []byte("rootfs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f.publish()
+	if err := Bootstrap(f.stateDir, filepath.Join(f.dir, RoleRoot+".json")); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f *fixture) newKey() *PrivateKey {
+	f.t.Helper()
+	k, err := GenerateKey()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return k
+}
+
+// publish publishes a new version of the repository.
+func (f *fixture) publish() {
+	f.t.Helper()
+	err := Publish(PublishOptions{
+		Dir:     f.dir,
+		Keys:    f.keys,
+		Targets: map[string]string{"rootfs.ext4": f.target},
+		Expires: f.expires,
+		Now:     f.now,
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// write signs meta with keys into the repository file name.
+func (f *fixture) write(dir, name string, meta any, keys ...*PrivateKey) {
+	f.t.Helper()
+	env, err := Sign(meta, keys...)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if _, err := writeEnvelope(filepath.Join(dir, name), env); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) read(name string) []byte {
+	f.t.Helper()
+	data, err := os.ReadFile(filepath.Join(f.dir, name))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return data
+}
+
+func (f *fixture) restore(name string, data []byte) {
+	f.t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, name), data, 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// update runs a client update at now.
+func (f *fixture) update(now time.Time) (*Client, error) {
+	f.t.Helper()
+	c, err := NewClient(DirFetcher{Dir: f.dir}, f.stateDir)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+	return c, c.Update(context.Background())
+}
+
+func (f *fixture) root() Root {
+	f.t.Helper()
+	var root Root
+	mustReadUnverified(f.t, f.dir, RoleRoot, &root)
+	return root
+}
+
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v, want one containing %q", err, want)
+	}
+}
+
+func TestClientUpdate(t *testing.T) {
+	f := newFixture(t, nil)
+	c, err := f.update(f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.VerifyTarget("rootfs.ext4", strings.NewReader("rootfs")); err != nil {
+		t.Error(err)
+	}
+	checkErr(t, c.VerifyTarget("rootfs.ext4", strings.NewReader("tampered")), "length mismatch")
+	checkErr(t, c.VerifyTarget("rootfs.ext4", strings.NewReader("rootfz")), "sha256 mismatch")
+	checkErr(t, c.VerifyTarget("kernel", strings.NewReader("")), "not listed")
+
+	// The trusted metadata is persisted.
+	for _, role := range Roles {
+		repo, state := f.read(role+".json"), mustRead(t, filepath.Join(f.stateDir, role+".json"))
+		if !bytes.Equal(repo, state) {
+			t.Errorf("%s.json was not persisted", role)
+		}
+	}
+}
+
+func TestClientUpdateRollback(t *testing.T) {
+	t.Run("timestamp", func(t *testing.T) {
+		f := newFixture(t, nil)
+		old := f.read(RoleTimestamp + ".json")
+		f.publish()
+		if _, err := f.update(f.now); err != nil {
+			t.Fatal(err)
+		}
+
+		f.restore(RoleTimestamp+".json", old)
+		_, err := f.update(f.now)
+		checkErr(t, err, "rollback detected: version 1 is older than trusted 2")
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		f := newFixture(t, nil)
+		if _, err := f.update(f.now); err != nil {
+			t.Fatal(err)
+		}
+
+		// A newer timestamp, validly signed, pointing to an older snapshot
+		// version.
+		f.write(f.dir, RoleTimestamp+".json", Timestamp{
+			Common: Common{Type: RoleTimestamp, SpecVersion: SpecVersion, Version: 2, Expires: f.now.Add(time.Hour)},
+			Meta:   map[string]MetaFile{RoleSnapshot + ".json": {Version: 0}},
+		}, f.keys[RoleTimestamp])
+		_, err := f.update(f.now)
+		checkErr(t, err, "rollback detected: snapshot version went backwards")
+	})
+
+	t.Run("targets", func(t *testing.T) {
+		f := newFixture(t, nil)
+		oldTargets, oldSnapshot := f.read(RoleTargets+".json"), f.read(RoleSnapshot+".json")
+		f.publish()
+		if _, err := f.update(f.now); err != nil {
+			t.Fatal(err)
+		}
+
+		// The current snapshot version with the old targets version.
+		var snap Snapshot
+		mustReadUnverified(t, f.dir, RoleSnapshot, &snap)
+		f.restore(RoleTargets+".json", oldTargets)
+		f.restore(RoleSnapshot+".json", oldSnapshot)
+		var old Snapshot
+		mustReadUnverified(t, f.dir, RoleSnapshot, &old)
+		old.Version = snap.Version + 1
+		f.write(f.dir, RoleSnapshot+".json", old, f.keys[RoleSnapshot])
+		if err := RefreshTimestamp(f.dir, f.keys[RoleTimestamp], 0, f.now); err != nil {
+			t.Fatal(err)
+		}
+		_, err := f.update(f.now)
+		checkErr(t, err, "rollback detected: targets.json version 1 is older than trusted 2")
+	})
+}
+
+func TestClientUpdateTimestampKeyRotation(t *testing.T) {
+	// A compromised timestamp key fast forwarded the client to version 100.
+	fastForward := func(f *fixture) {
+		f.t.Helper()
+		if _, err := f.update(f.now); err != nil {
+			f.t.Fatal(err)
+		}
+		var ts Timestamp
+		mustReadUnverified(f.t, f.dir, RoleTimestamp, &ts)
+		ts.Version = 100
+		f.write(f.stateDir, RoleTimestamp+".json", ts, f.keys[RoleTimestamp])
+	}
+
+	t.Run("without rotation the fast forward sticks", func(t *testing.T) {
+		f := newFixture(t, nil)
+		fastForward(f)
+		f.publish()
+		_, err := f.update(f.now)
+		checkErr(t, err, "rollback detected: version 2 is older than trusted 100")
+	})
+
+	t.Run("rotating the key recovers", func(t *testing.T) {
+		f := newFixture(t, nil)
+		fastForward(f)
+		f.keys[RoleTimestamp] = f.newKey()
+		f.publish()
+		c, err := f.update(f.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.root.Version != 2 || c.timestamp.Version != 2 {
+			t.Errorf("got root version %d and timestamp version %d, want 2 and 2", c.root.Version, c.timestamp.Version)
+		}
+	})
+
+	t.Run("the old key is no longer trusted", func(t *testing.T) {
+		f := newFixture(t, nil)
+		old := f.keys[RoleTimestamp]
+		f.keys[RoleTimestamp] = f.newKey()
+		f.publish()
+		if err := RefreshTimestamp(f.dir, old, 0, f.now); err != nil {
+			t.Fatal(err)
+		}
+		_, err := f.update(f.now)
+		checkErr(t, err, "timestamp metadata has 0 valid signatures, threshold is 1")
+	})
+}
+
+func TestClientUpdateExpired(t *testing.T) {
+	for _, role := range []string{RoleRoot, RoleTimestamp, RoleSnapshot, RoleTargets} {
+		t.Run(role, func(t *testing.T) {
+			// Only role expires within the day.
+			expires := map[string]time.Duration{}
+			for _, r := range Roles {
+				expires[r] = 30 * 24 * time.Hour
+			}
+			expires[role] = time.Hour
+			f := newFixture(t, expires)
+
+			if _, err := f.update(f.now.Add(time.Hour - time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := f.update(f.now.Add(time.Hour))
+			checkErr(t, err, fmt.Sprintf("updating %s: %s metadata version 1 expired", role, role))
+		})
+	}
+}
+
+func TestClientUpdateThreshold(t *testing.T) {
+	t.Run("timestamp below threshold", func(t *testing.T) {
+		f := newFixture(t, nil)
+		// Root version 2 requires two timestamp signatures.
+		second := f.newKey()
+		root := f.root()
+		root.Version = 2
+		root.Keys[second.ID] = second.Public
+		root.Roles[RoleTimestamp] = RoleKeys{KeyIDs: []string{f.keys[RoleTimestamp].ID, second.ID}, Threshold: 2}
+		f.write(f.dir, "2.root.json", root, f.keys[RoleRoot])
+
+		_, err := f.update(f.now)
+		checkErr(t, err, "timestamp metadata has 1 valid signatures, threshold is 2")
+
+		// Both signatures meet it.
+		var ts Timestamp
+		mustReadUnverified(t, f.dir, RoleTimestamp, &ts)
+		f.write(f.dir, RoleTimestamp+".json", ts, f.keys[RoleTimestamp], second)
+		if _, err := f.update(f.now); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("duplicated signatures count once", func(t *testing.T) {
+		f := newFixture(t, nil)
+		root := f.root()
+		root.Version = 2
+		root.Roles[RoleTimestamp] = RoleKeys{KeyIDs: []string{f.keys[RoleTimestamp].ID}, Threshold: 2}
+		f.write(f.dir, "2.root.json", root, f.keys[RoleRoot])
+		var ts Timestamp
+		mustReadUnverified(t, f.dir, RoleTimestamp, &ts)
+		f.write(f.dir, RoleTimestamp+".json", ts, f.keys[RoleTimestamp], f.keys[RoleTimestamp])
+
+		_, err := f.update(f.now)
+		checkErr(t, err, "timestamp metadata has 1 valid signatures, threshold is 2")
+	})
+
+	t.Run("root not signed by the trusted root", func(t *testing.T) {
+		f := newFixture(t, nil)
+		attacker := f.newKey()
+		root := f.root()
+		root.Version = 2
+		root.Keys[attacker.ID] = attacker.Public
+		root.Roles[RoleRoot] = RoleKeys{KeyIDs: []string{attacker.ID}, Threshold: 1}
+		f.write(f.dir, "2.root.json", root, attacker)
+
+		_, err := f.update(f.now)
+		checkErr(t, err, "version 2 not trusted by version 1: root metadata has 0 valid signatures")
+	})
+
+	t.Run("root not signed by its own keys", func(t *testing.T) {
+		f := newFixture(t, nil)
+		next := f.newKey()
+		root := f.root()
+		root.Version = 2
+		root.Keys[next.ID] = next.Public
+		root.Roles[RoleRoot] = RoleKeys{KeyIDs: []string{next.ID}, Threshold: 1}
+		f.write(f.dir, "2.root.json", root, f.keys[RoleRoot])
+
+		_, err := f.update(f.now)
+		checkErr(t, err, "version 2: root metadata has 0 valid signatures")
+	})
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func mustReadUnverified(t *testing.T, dir, role string, dst any) {
+	t.Helper()
+	if _, err := readUnverified(filepath.Join(dir, role+".json"), dst); err != nil {
+		t.Fatalf("%s: %v", role, err)
+	}
+}
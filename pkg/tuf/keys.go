@@ -0,0 +1,85 @@
+package tuf
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// PrivateKey is an ed25519 signing key.
+type PrivateKey struct {
+	ID      string
+	Private ed25519.PrivateKey
+	Public  Key
+}
+
+// keyFile is the on disk format of a private key.
+type keyFile struct {
+	KeyType string `json:"keytype"`
+	Scheme  string `json:"scheme"`
+	KeyVal  struct {
+		Public  string `json:"public"`
+		Private string `json:"private"`
+	} `json:"keyval"`
+}
+
+// GenerateKey creates a new ed25519 signing key.
+func GenerateKey() (*PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+	return newPrivateKey(priv)
+}
+
+func newPrivateKey(priv ed25519.PrivateKey) (*PrivateKey, error) {
+	pub := PublicKey(priv.Public().(ed25519.PublicKey))
+	id, err := KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{ID: id, Private: priv, Public: pub}, nil
+}
+
+// WriteKey stores a private key file readable only by its owner.
+func WriteKey(path string, k *PrivateKey) error {
+	var kf keyFile
+	kf.KeyType = k.Public.KeyType
+	kf.Scheme = k.Public.Scheme
+	kf.KeyVal.Public = k.Public.KeyVal.Public
+	kf.KeyVal.Private = hex.EncodeToString(k.Private.Seed())
+
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling key: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadKey loads a private key file.
+func ReadKey(path string) (*PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if kf.KeyType != "ed25519" {
+		return nil, fmt.Errorf("%s: unsupported key type %q", path, kf.KeyType)
+	}
+
+	seed, err := hex.DecodeString(kf.KeyVal.Private)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: invalid ed25519 private key", path)
+	}
+	return newPrivateKey(ed25519.NewKeyFromSeed(seed))
+}
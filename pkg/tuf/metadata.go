@@ -0,0 +1,225 @@
+// Package tuf generates and verifies The Update Framework metadata for
+// release files, giving clients rollback and freeze attack protection.
+//
+// Metadata follows the TUF 1.0 specification with ed25519 keys and without
+// consistent snapshots: root.json (plus versioned N.root.json files for key
+// rotation), targets.json, snapshot.json and timestamp.json.
+package tuf
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SpecVersion is the TUF specification version of the generated metadata.
+const SpecVersion = "1.0.31"
+
+// Role names.
+const (
+	RoleRoot      = "root"
+	RoleTargets   = "targets"
+	RoleSnapshot  = "snapshot"
+	RoleTimestamp = "timestamp"
+)
+
+// Roles lists all top level roles.
+var Roles = []string{RoleRoot, RoleTargets, RoleSnapshot, RoleTimestamp}
+
+// Envelope is signed metadata as stored on disk.
+type Envelope struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []Signature     `json:"signatures"`
+}
+
+// Signature is a signature over the canonical form of the signed metadata.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Key is a public key as listed in root metadata.
+type Key struct {
+	KeyType string `json:"keytype"`
+	Scheme  string `json:"scheme"`
+	KeyVal  KeyVal `json:"keyval"`
+}
+
+// KeyVal holds the hex encoded key material.
+type KeyVal struct {
+	Public string `json:"public"`
+}
+
+// RoleKeys lists the keys trusted for a role and the signature threshold.
+type RoleKeys struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// Common holds the fields every metadata type has.
+type Common struct {
+	Type        string    `json:"_type"`
+	SpecVersion string    `json:"spec_version"`
+	Version     int       `json:"version"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Root is the root role metadata.
+type Root struct {
+	Common
+	ConsistentSnapshot bool                `json:"consistent_snapshot"`
+	Keys               map[string]Key      `json:"keys"`
+	Roles              map[string]RoleKeys `json:"roles"`
+}
+
+// Targets is the targets role metadata.
+type Targets struct {
+	Common
+	Targets map[string]TargetFile `json:"targets"`
+}
+
+// TargetFile describes a released file.
+type TargetFile struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+// Snapshot is the snapshot role metadata.
+type Snapshot struct {
+	Common
+	Meta map[string]MetaFile `json:"meta"`
+}
+
+// Timestamp is the timestamp role metadata.
+type Timestamp struct {
+	Common
+	Meta map[string]MetaFile `json:"meta"`
+}
+
+// MetaFile references another metadata file.
+type MetaFile struct {
+	Version int               `json:"version"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+// PublicKey returns the TUF representation of an ed25519 public key.
+func PublicKey(pub ed25519.PublicKey) Key {
+	return Key{KeyType: "ed25519", Scheme: "ed25519", KeyVal: KeyVal{Public: hex.EncodeToString(pub)}}
+}
+
+// KeyID returns the TUF key ID: the SHA256 of the canonical key.
+func KeyID(k Key) (string, error) {
+	data, err := Canonical(k)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Canonical returns the canonical JSON encoding of v: sorted object keys,
+// no insignificant whitespace and no HTML escaping.
+func Canonical(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Round trip through generic values so all object keys get sorted.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Sign wraps metadata in an envelope signed by keys.
+func Sign(meta any, keys ...*PrivateKey) (Envelope, error) {
+	data, err := Canonical(meta)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("encoding metadata: %w", err)
+	}
+
+	env := Envelope{Signed: data}
+	for _, k := range keys {
+		env.Signatures = append(env.Signatures, Signature{
+			KeyID: k.ID,
+			Sig:   hex.EncodeToString(ed25519.Sign(k.Private, data)),
+		})
+	}
+	return env, nil
+}
+
+// VerifyRole checks env carries at least the threshold of valid signatures
+// from the keys trusted for role by root, then decodes it into dst.
+func VerifyRole(env Envelope, root Root, role string, dst any) error {
+	rk, ok := root.Roles[role]
+	if !ok {
+		return fmt.Errorf("role %s not defined in root", role)
+	}
+	if rk.Threshold < 1 {
+		return fmt.Errorf("role %s has invalid threshold %d", role, rk.Threshold)
+	}
+
+	data, err := Canonical(env.Signed)
+	if err != nil {
+		return fmt.Errorf("encoding %s metadata: %w", role, err)
+	}
+
+	trusted := map[string]bool{}
+	for _, id := range rk.KeyIDs {
+		trusted[id] = true
+	}
+
+	valid := map[string]bool{}
+	for _, sig := range env.Signatures {
+		if !trusted[sig.KeyID] || valid[sig.KeyID] {
+			continue
+		}
+		key, ok := root.Keys[sig.KeyID]
+		if !ok || key.KeyType != "ed25519" {
+			continue
+		}
+		pub, err := hex.DecodeString(key.KeyVal.Public)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			continue
+		}
+		raw, err := hex.DecodeString(sig.Sig)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(pub, data, raw) {
+			valid[sig.KeyID] = true
+		}
+	}
+
+	if len(valid) < rk.Threshold {
+		return fmt.Errorf("%s metadata has %d valid signatures, threshold is %d", role, len(valid), rk.Threshold)
+	}
+
+	if err := json.Unmarshal(env.Signed, dst); err != nil {
+		return fmt.Errorf("decoding %s metadata: %w", role, err)
+	}
+	return nil
+}
+
+func checkType(c Common, role string) error {
+	if c.Type != role {
+		return fmt.Errorf("expected %s metadata, got %q", role, c.Type)
+	}
+	return nil
+}
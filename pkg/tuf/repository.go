@@ -0,0 +1,268 @@
+package tuf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/slok/sbx-images/pkg/digest"
+)
+
+// DefaultExpires are the default metadata lifetimes per role. The short
+// timestamp lifetime bounds how long a freeze attack can go unnoticed, so
+// timestamp.json must be refreshed regularly with RefreshTimestamp.
+var DefaultExpires = map[string]time.Duration{
+	RoleRoot:      365 * 24 * time.Hour,
+	RoleTargets:   90 * 24 * time.Hour,
+	RoleSnapshot:  30 * 24 * time.Hour,
+	RoleTimestamp: 7 * 24 * time.Hour,
+}
+
+// PublishOptions configures the generation of repository metadata.
+type PublishOptions struct {
+	// Dir is the directory holding the metadata files.
+	Dir string
+	// Keys are the signing keys per role.
+	Keys map[string]*PrivateKey
+	// PreviousRootKey signs a new root version when the root key is
+	// rotated, so clients trusting the old root accept the new one.
+	PreviousRootKey *PrivateKey
+	// Targets maps target names to the local files they are read from.
+	Targets map[string]string
+	// Expires overrides DefaultExpires per role.
+	Expires map[string]time.Duration
+	// Now is the reference time for expirations (default: time.Now).
+	Now time.Time
+}
+
+// Publish writes a new version of the repository metadata for the targets
+// in opts, bumping the targets, snapshot and timestamp versions. Root is only
+// re-issued when its keys change or it is about to expire.
+func Publish(opts PublishOptions) error {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	for _, role := range Roles {
+		if opts.Keys[role] == nil {
+			return fmt.Errorf("missing %s signing key", role)
+		}
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return fmt.Errorf("creating %s: %w", opts.Dir, err)
+	}
+
+	if err := publishRoot(opts); err != nil {
+		return fmt.Errorf("root: %w", err)
+	}
+
+	var prevTargets Targets
+	if _, err := readUnverified(filepath.Join(opts.Dir, RoleTargets+".json"), &prevTargets); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	targets := Targets{
+		Common:  newCommon(RoleTargets, prevTargets.Version+1, opts),
+		Targets: map[string]TargetFile{},
+	}
+	for name, path := range opts.Targets {
+		tf, err := describeFile(path)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", name, err)
+		}
+		targets.Targets[name] = tf
+	}
+	targetsMeta, err := writeSigned(opts.Dir, RoleTargets, targets, opts.Keys[RoleTargets])
+	if err != nil {
+		return err
+	}
+
+	var prevSnapshot Snapshot
+	if _, err := readUnverified(filepath.Join(opts.Dir, RoleSnapshot+".json"), &prevSnapshot); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	snapshot := Snapshot{
+		Common: newCommon(RoleSnapshot, prevSnapshot.Version+1, opts),
+		Meta:   map[string]MetaFile{RoleTargets + ".json": {Version: targets.Version, Length: targetsMeta.Length, Hashes: targetsMeta.Hashes}},
+	}
+	if _, err := writeSigned(opts.Dir, RoleSnapshot, snapshot, opts.Keys[RoleSnapshot]); err != nil {
+		return err
+	}
+
+	return RefreshTimestamp(opts.Dir, opts.Keys[RoleTimestamp], opts.Expires[RoleTimestamp], opts.Now)
+}
+
+// RefreshTimestamp re-signs timestamp.json for the current snapshot with a
+// new version and expiration. Run it periodically to keep clients updating.
+func RefreshTimestamp(dir string, key *PrivateKey, expires time.Duration, now time.Time) error {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if expires == 0 {
+		expires = DefaultExpires[RoleTimestamp]
+	}
+
+	var snapshot Snapshot
+	snapshotMeta, err := readUnverified(filepath.Join(dir, RoleSnapshot+".json"), &snapshot)
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	var prev Timestamp
+	if _, err := readUnverified(filepath.Join(dir, RoleTimestamp+".json"), &prev); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	ts := Timestamp{
+		Common: Common{
+			Type:        RoleTimestamp,
+			SpecVersion: SpecVersion,
+			Version:     prev.Version + 1,
+			Expires:     now.Add(expires).UTC().Truncate(time.Second),
+		},
+		Meta: map[string]MetaFile{RoleSnapshot + ".json": {Version: snapshot.Version, Length: snapshotMeta.Length, Hashes: snapshotMeta.Hashes}},
+	}
+	_, err = writeSigned(dir, RoleTimestamp, ts, key)
+	return err
+}
+
+func publishRoot(opts PublishOptions) error {
+	root := Root{
+		Keys:  map[string]Key{},
+		Roles: map[string]RoleKeys{},
+	}
+	for _, role := range Roles {
+		k := opts.Keys[role]
+		root.Keys[k.ID] = k.Public
+		root.Roles[role] = RoleKeys{KeyIDs: []string{k.ID}, Threshold: 1}
+	}
+
+	path := filepath.Join(opts.Dir, RoleRoot+".json")
+	var prev Root
+	_, err := readUnverified(path, &prev)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		renewAt := prev.Expires.Add(-expiresFor(RoleRoot, opts) / 4)
+		if sameRoles(prev.Roles, root.Roles) && opts.Now.Before(renewAt) {
+			return nil
+		}
+	}
+
+	root.Common = newCommon(RoleRoot, prev.Version+1, opts)
+	root.ConsistentSnapshot = false
+
+	signers := []*PrivateKey{opts.Keys[RoleRoot]}
+	if prev.Version > 0 && !slices.Contains(prev.Roles[RoleRoot].KeyIDs, opts.Keys[RoleRoot].ID) {
+		if opts.PreviousRootKey == nil {
+			return fmt.Errorf("root key rotated, the previous root key is required to sign the new root")
+		}
+		signers = append(signers, opts.PreviousRootKey)
+	}
+
+	env, err := Sign(root, signers...)
+	if err != nil {
+		return err
+	}
+	if _, err := writeEnvelope(filepath.Join(opts.Dir, fmt.Sprintf("%d.%s.json", root.Version, RoleRoot)), env); err != nil {
+		return err
+	}
+	_, err = writeEnvelope(path, env)
+	return err
+}
+
+func sameRoles(a, b map[string]RoleKeys) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for role, ka := range a {
+		kb, ok := b[role]
+		if !ok || !sameRoleKeys(ka, kb) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameRoleKeys(a, b RoleKeys) bool {
+	if a.Threshold != b.Threshold {
+		return false
+	}
+	x, y := slices.Clone(a.KeyIDs), slices.Clone(b.KeyIDs)
+	slices.Sort(x)
+	slices.Sort(y)
+	return slices.Equal(x, y)
+}
+
+func newCommon(role string, version int, opts PublishOptions) Common {
+	return Common{
+		Type:        role,
+		SpecVersion: SpecVersion,
+		Version:     version,
+		Expires:     opts.Now.Add(expiresFor(role, opts)).UTC().Truncate(time.Second),
+	}
+}
+
+func expiresFor(role string, opts PublishOptions) time.Duration {
+	if d, ok := opts.Expires[role]; ok && d > 0 {
+		return d
+	}
+	return DefaultExpires[role]
+}
+
+func writeSigned(dir, role string, meta any, key *PrivateKey) (MetaFile, error) {
+	env, err := Sign(meta, key)
+	if err != nil {
+		return MetaFile{}, err
+	}
+	return writeEnvelope(filepath.Join(dir, role+".json"), env)
+}
+
+// writeEnvelope writes signed metadata and returns its length and hashes.
+func writeEnvelope(path string, env Envelope) (MetaFile, error) {
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return MetaFile{}, fmt.Errorf("marshaling %s: %w", filepath.Base(path), err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return MetaFile{}, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return describeBytes(data), nil
+}
+
+// readUnverified decodes local metadata written by this repository.
+func readUnverified(path string, dst any) (MetaFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return MetaFile{}, err
+	}
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return MetaFile{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := json.Unmarshal(env.Signed, dst); err != nil {
+		return MetaFile{}, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return describeBytes(data), nil
+}
+
+func describeFile(path string) (TargetFile, error) {
+	n, sum, err := digest.File(path)
+	if err != nil {
+		return TargetFile{}, err
+	}
+	return TargetFile{Length: n, Hashes: map[string]string{"sha256": sum}}, nil
+}
+
+func describeBytes(data []byte) MetaFile {
+	sum := sha256.Sum256(data)
+	return MetaFile{Length: int64(len(data)), Hashes: map[string]string{"sha256": hex.EncodeToString(sum[:])}}
+}
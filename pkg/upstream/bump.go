@@ -0,0 +1,132 @@
+package upstream
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// Bump policies, the largest version change a bump may make.
+const (
+	PolicyPatch = "patch"
+	PolicyMinor = "minor"
+	PolicyAny   = "any"
+)
+
+// Policies are the supported bump policies.
+var Policies = []string{PolicyPatch, PolicyMinor, PolicyAny}
+
+// Bump is a version pin update.
+type Bump struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// Select returns the bumps of the stale pins allowed by policy: the newest
+// version with the same major and minor (patch), the same major (minor) or
+// any newer version. Two part versions (e.g. 3.23) only bump with minor and
+// any.
+func Select(pins []Pin, policy string) ([]Bump, error) {
+	var bumps []Bump
+	for _, p := range pins {
+		cur, err := versionParts(p.Current)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Field, err)
+		}
+
+		to := ""
+		for _, v := range p.Newer {
+			next, err := versionParts(v)
+			if err != nil {
+				continue
+			}
+			var ok bool
+			switch policy {
+			case PolicyPatch:
+				ok = len(cur) == 3 && next[0] == cur[0] && next[1] == cur[1]
+			case PolicyMinor:
+				ok = next[0] == cur[0]
+			case PolicyAny:
+				ok = true
+			default:
+				return nil, fmt.Errorf("unknown bump policy %q (supported: %s)", policy, strings.Join(Policies, ", "))
+			}
+			if ok {
+				// Newer is sorted oldest first.
+				to = v
+			}
+		}
+		if to != "" {
+			bumps = append(bumps, Bump{Field: p.Field, From: p.Current, To: to})
+		}
+	}
+	return bumps, nil
+}
+
+func versionParts(v string) ([]int, error) {
+	if _, err := manifest.CompareVersions(v, v); err != nil {
+		return nil, err
+	}
+	core, _, _ := strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	var parts []int
+	for p := range strings.SplitSeq(core, ".") {
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// Apply rewrites the bumped fields of the config.yaml data. The fields are
+// located with the YAML node tree and only their values are replaced in the
+// original text, keeping the comments and formatting untouched.
+func Apply(data []byte, bumps []Bump) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("empty config")
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	for _, b := range bumps {
+		n, err := config.FieldNode(doc.Content[0], b.Field)
+		if err != nil {
+			return nil, err
+		}
+		if n.Kind != yaml.ScalarNode || n.Value != b.From {
+			return nil, fmt.Errorf("%s: expected %q, found %q", b.Field, b.From, n.Value)
+		}
+
+		from, to := b.From, b.To
+		switch n.Style {
+		case yaml.DoubleQuotedStyle:
+			from, to = strconv.Quote(from), strconv.Quote(to)
+		case yaml.SingleQuotedStyle:
+			from, to = "'"+from+"'", "'"+to+"'"
+		}
+		line, col := n.Line-1, n.Column-1
+		if line >= len(lines) || !bytes.HasPrefix(lines[line][col:], []byte(from)) {
+			return nil, fmt.Errorf("%s: value not found at line %d", b.Field, n.Line)
+		}
+		lines[line] = append(append(slices.Clone(lines[line][:col]), to...), lines[line][col+len(from):]...)
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// Changelog returns a Markdown list of the bumps for the PR body.
+func Changelog(bumps []Bump) string {
+	var b strings.Builder
+	for _, bump := range bumps {
+		fmt.Fprintf(&b, "- `%s`: %s → %s\n", bump.Field, bump.From, bump.To)
+	}
+	return b.String()
+}
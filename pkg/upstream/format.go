@@ -0,0 +1,35 @@
+package upstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteJSON writes the pins as an indented JSON array.
+func WriteJSON(w io.Writer, pins []Pin) error {
+	if pins == nil {
+		pins = []Pin{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(pins)
+}
+
+// WriteMarkdown writes the pins as a Markdown table, for issue and PR
+// bodies.
+func WriteMarkdown(w io.Writer, pins []Pin) error {
+	var b strings.Builder
+	b.WriteString("| Pin | Current | Latest | Status |\n")
+	b.WriteString("|-----|---------|--------|--------|\n")
+	for _, p := range pins {
+		status := "up to date"
+		if p.Stale {
+			status = fmt.Sprintf("stale (%d newer)", len(p.Newer))
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", p.Field, p.Current, p.Latest, status)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,181 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/releases"
+)
+
+// firecrackerRepo is the upstream Firecracker GitHub repository.
+const firecrackerRepo = "firecracker-microvm/firecracker"
+
+// ciBucketURL is the firecracker-ci S3 bucket.
+const ciBucketURL = "https://s3.amazonaws.com/spec.ccfc.min"
+
+// alpineReleasesURL lists the Alpine release branches.
+const alpineReleasesURL = "https://alpinelinux.org/releases.json"
+
+// HTTPSources reads the upstream versions from GitHub, the firecracker-ci
+// bucket and alpinelinux.org.
+type HTTPSources struct {
+	// GitHub reads the Firecracker releases.
+	GitHub releases.Client
+}
+
+var _ Sources = HTTPSources{}
+
+// FirecrackerVersions returns the Firecracker release tags, without
+// pre-releases.
+func (s HTTPSources) FirecrackerVersions(ctx context.Context) ([]string, error) {
+	rels, err := s.GitHub.List(ctx, firecrackerRepo)
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, r := range rels {
+		if !strings.Contains(r.Tag, "-") {
+			versions = append(versions, r.Tag)
+		}
+	}
+	return versions, nil
+}
+
+// CIVersions returns the firecracker-ci/<version>/ prefixes of the bucket.
+func (s HTTPSources) CIVersions(ctx context.Context) ([]string, error) {
+	res, err := listBucket(ctx, "firecracker-ci/", "/")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, p := range res.prefixes {
+		versions = append(versions, strings.TrimSuffix(strings.TrimPrefix(p, "firecracker-ci/"), "/"))
+	}
+	return versions, nil
+}
+
+var ciKernelRe = regexp.MustCompile(`/vmlinux-(\d+\.\d+\.\d+)$`)
+
+// KernelVersions returns the vmlinux-<version> kernels of a firecracker-ci
+// version for arch.
+func (s HTTPSources) KernelVersions(ctx context.Context, ciVersion, arch string) ([]string, error) {
+	res, err := listBucket(ctx, fmt.Sprintf("firecracker-ci/%s/%s/vmlinux-", ciVersion, arch), "")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, k := range res.keys {
+		if m := ciKernelRe.FindStringSubmatch(k); m != nil {
+			versions = append(versions, m[1])
+		}
+	}
+	return versions, nil
+}
+
+// AlpineVersions returns the Alpine release branches, e.g. 3.23.
+func (s HTTPSources) AlpineVersions(ctx context.Context) ([]string, error) {
+	var rels struct {
+		ReleaseBranches []struct {
+			RelBranch string `json:"rel_branch"`
+		} `json:"release_branches"`
+	}
+	if err := getJSON(ctx, alpineReleasesURL, &rels); err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, b := range rels.ReleaseBranches {
+		if v, ok := strings.CutPrefix(b.RelBranch, "v"); ok {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+type bucketListing struct {
+	keys     []string
+	prefixes []string
+}
+
+// listBucket lists the firecracker-ci bucket keys (and common prefixes with
+// delimiter) under prefix, following the continuation tokens.
+func listBucket(ctx context.Context, prefix, delimiter string) (bucketListing, error) {
+	var (
+		res   bucketListing
+		token string
+	)
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			q.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			CommonPrefixes []struct {
+				Prefix string `xml:"Prefix"`
+			} `xml:"CommonPrefixes"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		body, err := get(ctx, ciBucketURL+"?"+q.Encode())
+		if err != nil {
+			return res, err
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return res, fmt.Errorf("decoding bucket listing: %w", err)
+		}
+		for _, c := range page.Contents {
+			res.keys = append(res.keys, c.Key)
+		}
+		for _, p := range page.CommonPrefixes {
+			res.prefixes = append(res.prefixes, p.Prefix)
+		}
+		if !page.IsTruncated {
+			return res, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func getJSON(ctx context.Context, u string, out any) error {
+	body, err := get(ctx, u)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding %s: %w", u, err)
+	}
+	return nil
+}
+
+func get(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %d", u, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", u, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,131 @@
+// Package upstream looks up the versions published upstream for the pinned
+// versions of config.yaml: Firecracker releases, firecracker-ci kernels and
+// Alpine releases.
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/slok/sbx-images/pkg/config"
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// Pin is a version pinned in config.yaml and the newer upstream versions.
+type Pin struct {
+	// Field is the config.yaml field path, e.g. kernel.version.
+	Field   string `json:"field"`
+	Current string `json:"current"`
+	// Latest is the newest upstream version, Current when up to date.
+	Latest string `json:"latest"`
+	// Newer are the upstream versions newer than Current, oldest first.
+	Newer []string `json:"newer,omitempty"`
+	Stale bool     `json:"stale"`
+}
+
+// Sources queries the upstream version feeds.
+type Sources interface {
+	// FirecrackerVersions returns the published Firecracker releases.
+	FirecrackerVersions(ctx context.Context) ([]string, error)
+	// CIVersions returns the firecracker-ci bucket versions.
+	CIVersions(ctx context.Context) ([]string, error)
+	// KernelVersions returns the kernel versions of a firecracker-ci
+	// version for arch.
+	KernelVersions(ctx context.Context, ciVersion, arch string) ([]string, error)
+	// AlpineVersions returns the Alpine release branches (e.g. 3.23).
+	AlpineVersions(ctx context.Context) ([]string, error)
+}
+
+// Check compares the pinned versions of cfg with the upstream versions.
+// Downloaded kernels are compared with the kernels of their ci_version
+// built for every architecture.
+func Check(ctx context.Context, cfg config.Config, src Sources) ([]Pin, error) {
+	var pins []Pin
+
+	fc, err := src.FirecrackerVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("firecracker releases: %w", err)
+	}
+	pins = append(pins, NewPin("firecracker.version", cfg.Firecracker.Version, fc))
+
+	ci, err := src.CIVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("firecracker-ci versions: %w", err)
+	}
+	pins = append(pins, NewPin("kernel.ci_version", cfg.Kernel.CIVersion, ci))
+
+	for i, f := range cfg.KernelFlavors() {
+		field := "kernel"
+		if i > 0 {
+			field = fmt.Sprintf("kernel.flavors[%d]", i-1)
+		}
+		if f.BuildFromSource {
+			continue
+		}
+
+		// Only the kernels built for every architecture can be pinned, the
+		// versions overridden per architecture are pinned on their own.
+		var kernels []string
+		shared := 0
+		for _, arch := range cfg.Architectures {
+			af := f.ForArch(arch)
+			vs, err := src.KernelVersions(ctx, af.CIVersion, arch)
+			if err != nil {
+				return nil, fmt.Errorf("firecracker-ci %s kernels for %s: %w", af.CIVersion, arch, err)
+			}
+			if f.ArchOverrides[arch].Version != "" {
+				pins = append(pins, NewPin(fmt.Sprintf("%s.arch_overrides.%s.version", field, arch), af.Version, vs))
+				continue
+			}
+			if shared++; shared == 1 {
+				kernels = vs
+				continue
+			}
+			kernels = slices.DeleteFunc(kernels, func(v string) bool { return !slices.Contains(vs, v) })
+		}
+		if shared > 0 {
+			pins = append(pins, NewPin(field+".version", f.Version, kernels))
+		}
+	}
+
+	alpine, err := src.AlpineVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("alpine releases: %w", err)
+	}
+	pins = append(pins, NewPin("rootfs.distro_version", cfg.Rootfs.DistroVersion, alpine))
+
+	return pins, nil
+}
+
+// NewPin returns the pin of field at current given the upstream versions.
+// Versions that can't be parsed are ignored.
+func NewPin(field, current string, versions []string) Pin {
+	p := Pin{Field: field, Current: current, Latest: current}
+	for _, v := range sortVersions(versions) {
+		if c, err := manifest.CompareVersions(v, current); err == nil && c > 0 {
+			p.Newer = append(p.Newer, v)
+		}
+	}
+	if len(p.Newer) > 0 {
+		p.Latest = p.Newer[len(p.Newer)-1]
+		p.Stale = true
+	}
+	return p
+}
+
+// sortVersions returns the parseable versions sorted oldest first, without
+// duplicates.
+func sortVersions(versions []string) []string {
+	var sorted []string
+	for _, v := range versions {
+		if _, err := manifest.CompareVersions(v, v); err == nil && !slices.Contains(sorted, v) {
+			sorted = append(sorted, v)
+		}
+	}
+	slices.SortFunc(sorted, func(a, b string) int {
+		c, _ := manifest.CompareVersions(a, b)
+		return c
+	})
+	return sorted
+}
@@ -0,0 +1,199 @@
+// Package usage records which image versions hosts actually boot.
+//
+// Events are reported either to a local JSON lines log or to an HTTP
+// endpoint, and can be aggregated later to decide which releases are no
+// longer in use and are safe to prune from mirrors.
+//
+// The only reporter in this repository is cmd/serve (pkg/serve), from the
+// kernel downloads of the releases it serves. There is no prefetch daemon
+// here to aggregate the state of: hosts prefetching images report their
+// boots through NewReporter themselves.
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a single observed use of an image version.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Version string    `json:"version"`
+	Arch    string    `json:"arch,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	// Source identifies the reporter of the event (e.g. "serve").
+	Source string `json:"source"`
+}
+
+// Reporter sends usage events to a backend.
+type Reporter interface {
+	Report(ctx context.Context, e Event) error
+}
+
+// NewReporter returns a reporter for target, which is either an http(s) URL
+// or a local file path. An empty target disables reporting.
+func NewReporter(target string) Reporter {
+	switch {
+	case target == "":
+		return noopReporter{}
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		return &HTTPReporter{URL: target, Client: &http.Client{Timeout: 10 * time.Second}}
+	default:
+		return &FileReporter{Path: target}
+	}
+}
+
+type noopReporter struct{}
+
+func (noopReporter) Report(context.Context, Event) error { return nil }
+
+// FileReporter appends events as JSON lines to a local file.
+type FileReporter struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Report appends e to the log file.
+func (r *FileReporter) Report(_ context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshaling usage event: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", r.Path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing %s: %w", r.Path, err)
+	}
+	return nil
+}
+
+// HTTPReporter POSTs each event as JSON to an endpoint.
+type HTTPReporter struct {
+	URL    string
+	Client *http.Client
+}
+
+// Report sends e to the endpoint.
+func (r *HTTPReporter) Report(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshaling usage event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting usage event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("posting usage event: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// ReadLog loads all events from a JSON lines log.
+func ReadLog(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var events []Event
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("parsing %s:%d: %w", path, line, err)
+		}
+		events = append(events, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return events, nil
+}
+
+// VersionUsage aggregates the events of a single image version.
+type VersionUsage struct {
+	Version  string    `json:"version"`
+	Boots    int       `json:"boots"`
+	Hosts    int       `json:"hosts"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Summarize aggregates events per version, most recently used first.
+func Summarize(events []Event) []VersionUsage {
+	byVersion := map[string]*VersionUsage{}
+	hosts := map[string]map[string]struct{}{}
+
+	for _, e := range events {
+		u, ok := byVersion[e.Version]
+		if !ok {
+			u = &VersionUsage{Version: e.Version}
+			byVersion[e.Version] = u
+			hosts[e.Version] = map[string]struct{}{}
+		}
+		u.Boots++
+		if e.Time.After(u.LastSeen) {
+			u.LastSeen = e.Time
+		}
+		if e.Host != "" {
+			hosts[e.Version][e.Host] = struct{}{}
+		}
+	}
+
+	summary := make([]VersionUsage, 0, len(byVersion))
+	for v, u := range byVersion {
+		u.Hosts = len(hosts[v])
+		summary = append(summary, *u)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if !summary[i].LastSeen.Equal(summary[j].LastSeen) {
+			return summary[i].LastSeen.After(summary[j].LastSeen)
+		}
+		return summary[i].Version < summary[j].Version
+	})
+
+	return summary
+}
+
+// Stale returns the versions from summary not seen since cutoff.
+func Stale(summary []VersionUsage, cutoff time.Time) []VersionUsage {
+	var stale []VersionUsage
+	for _, u := range summary {
+		if u.LastSeen.Before(cutoff) {
+			stale = append(stale, u)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,144 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Cache remembers the digests of verified files. Entries are only valid
+// while the file size and modification time are unchanged.
+type Cache interface {
+	Lookup(path string, info os.FileInfo) (string, bool)
+	Store(path string, info os.FileInfo, digest string) error
+}
+
+// xattrName is the extended attribute holding the cached verification.
+const xattrName = "user.sbx.verified"
+
+// StateFileName is the sidecar state file used when the filesystem does not
+// support extended attributes.
+const StateFileName = ".sbx-verify-state.json"
+
+// NewCache returns an extended attribute cache when dir supports user
+// xattrs, or a sidecar state file cache in dir otherwise.
+func NewCache(dir string) Cache {
+	if xattrSupported(dir) {
+		return XattrCache{}
+	}
+	return &StateFileCache{Path: filepath.Join(dir, StateFileName)}
+}
+
+// entry is a cached verification.
+type entry struct {
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	MtimeNano int64  `json:"mtime_ns"`
+}
+
+func newEntry(info os.FileInfo, digest string) entry {
+	return entry{SHA256: digest, Size: info.Size(), MtimeNano: info.ModTime().UnixNano()}
+}
+
+func (e entry) matches(info os.FileInfo) bool {
+	return e.SHA256 != "" && e.Size == info.Size() && e.MtimeNano == info.ModTime().UnixNano()
+}
+
+// encode returns the compact xattr value: "v1 <sha256> <size> <mtime ns>".
+func (e entry) encode() string {
+	return fmt.Sprintf("v1 %s %d %d", e.SHA256, e.Size, e.MtimeNano)
+}
+
+func decodeEntry(s string) (entry, bool) {
+	f := strings.Fields(s)
+	if len(f) != 4 || f[0] != "v1" {
+		return entry{}, false
+	}
+	size, err1 := strconv.ParseInt(f[2], 10, 64)
+	mtime, err2 := strconv.ParseInt(f[3], 10, 64)
+	if err1 != nil || err2 != nil {
+		return entry{}, false
+	}
+	return entry{SHA256: f[1], Size: size, MtimeNano: mtime}, true
+}
+
+// XattrCache stores verifications in an extended attribute of each file.
+type XattrCache struct{}
+
+// Lookup returns the cached digest of path when still valid.
+func (XattrCache) Lookup(path string, info os.FileInfo) (string, bool) {
+	v, err := getXattr(path, xattrName)
+	if err != nil {
+		return "", false
+	}
+	e, ok := decodeEntry(v)
+	if !ok || !e.matches(info) {
+		return "", false
+	}
+	return e.SHA256, true
+}
+
+// Store records a verified digest of path.
+func (XattrCache) Store(path string, info os.FileInfo, digest string) error {
+	return setXattr(path, xattrName, newEntry(info, digest).encode())
+}
+
+// StateFileCache stores verifications in a JSON file keyed by file path.
+type StateFileCache struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Lookup returns the cached digest of path when still valid.
+func (c *StateFileCache) Lookup(path string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.load()
+	e, ok := state[absPath(path)]
+	if !ok || !e.matches(info) {
+		return "", false
+	}
+	return e.SHA256, true
+}
+
+// Store records a verified digest of path.
+func (c *StateFileCache) Store(path string, info os.FileInfo, digest string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.load()
+	state[absPath(path)] = newEntry(info, digest)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.Path)
+}
+
+func (c *StateFileCache) load() map[string]entry {
+	state := map[string]entry{}
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		return state
+	}
+	_ = json.Unmarshal(data, &state)
+	return state
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,60 @@
+// Package verify checks downloaded or built artifacts against the digests in
+// the release manifest.
+//
+// Hashing multi-GB images is slow, so after a successful verification the
+// digest is cached together with the file size and modification time. Later
+// verifications of an unchanged file reuse the cached digest unless
+// Options.Paranoid is set.
+package verify
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/slok/sbx-images/pkg/digest"
+	"github.com/slok/sbx-images/pkg/exitcode"
+)
+
+// Options configures a file verification.
+type Options struct {
+	// Cache stores verified digests, nil disables caching.
+	Cache Cache
+	// Paranoid always rehashes the file, ignoring the cache.
+	Paranoid bool
+}
+
+// Result is the outcome of a successful verification.
+type Result struct {
+	SHA256 string
+	// Cached is true when the digest came from the cache.
+	Cached bool
+}
+
+// File checks the file at path has the SHA256 digest want. A different
+// digest is an error of the exitcode.DigestMismatch class.
+func File(path, want string, opts Options) (Result, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Result{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	if opts.Cache != nil && !opts.Paranoid {
+		if digest, ok := opts.Cache.Lookup(path, info); ok && digest == want {
+			return Result{SHA256: digest, Cached: true}, nil
+		}
+	}
+
+	_, got, err := digest.File(path)
+	if err != nil {
+		return Result{}, err
+	}
+	if got != want {
+		return Result{}, exitcode.Wrap(exitcode.DigestMismatch, fmt.Errorf("%s: sha256 mismatch (expected %s, got %s)", path, want, got))
+	}
+
+	if opts.Cache != nil {
+		// The cache is an optimization, failing to store is not an error.
+		_ = opts.Cache.Store(path, info, got)
+	}
+	return Result{SHA256: got}, nil
+}
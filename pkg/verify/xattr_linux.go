@@ -0,0 +1,32 @@
+package verify
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func getXattr(path, name string) (string, error) {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(path, name, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+func setXattr(path, name, value string) error {
+	return unix.Setxattr(path, name, []byte(value), 0)
+}
+
+// xattrSupported probes dir by setting a user xattr on a temporary file.
+func xattrSupported(dir string) bool {
+	f, err := os.CreateTemp(dir, ".sbx-xattr-probe-*")
+	if err != nil {
+		return false
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	return setXattr(f.Name(), xattrName, "probe") == nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package verify
+
+import "errors"
+
+var errNoXattr = errors.New("extended attributes not supported")
+
+func getXattr(string, string) (string, error) { return "", errNoXattr }
+
+func setXattr(string, string, string) error { return errNoXattr }
+
+func xattrSupported(string) bool { return false }
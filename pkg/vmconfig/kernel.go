@@ -0,0 +1,32 @@
+package vmconfig
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// DefaultKernelFormats are the kernel formats Firecracker boots, in
+// preference order: the vmlinux ELF (PVH entry point on x86_64) or arm64 or
+// riscv64 Image, then the compressed bzImage.
+var DefaultKernelFormats = []string{manifest.KernelFormatELF, manifest.KernelFormatPE, manifest.KernelFormatBzImage}
+
+// SelectKernel returns the image of kernel k for arch in the first of
+// formats it ships (default: DefaultKernelFormats), for VMMs supporting only
+// some of them.
+func SelectKernel(k manifest.KernelArtifact, arch string, formats []string) (manifest.KernelImage, error) {
+	if !slices.Contains(manifest.Architectures, arch) {
+		return manifest.KernelImage{}, fmt.Errorf("unsupported architecture %q (supported: %s)", arch, strings.Join(manifest.Architectures, ", "))
+	}
+	if len(formats) == 0 {
+		formats = DefaultKernelFormats
+	}
+	for _, format := range formats {
+		if img, ok := k.Image(format, arch); ok {
+			return img, nil
+		}
+	}
+	return manifest.KernelImage{}, fmt.Errorf("kernel %s has no %s image", k.File, strings.Join(formats, " or "))
+}
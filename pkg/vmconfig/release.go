@@ -0,0 +1,166 @@
+package vmconfig
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+)
+
+// Default guest machine size of the generated configs.
+const (
+	DefaultVCPUs  = 2
+	DefaultMemMiB = 2048
+)
+
+// Options are the guest resources and devices of a release VM config.
+type Options struct {
+	// Filesystem selects the rootfs image booted (default: the overlay or
+	// verity root of the rootfs, its File otherwise).
+	Filesystem string
+	// Overlay is the path of the VM's own copy of the overlay disk
+	// template, required for the rootfs paired with one.
+	Overlay string
+	// VCPUs and MemMiB are the guest machine size (default DefaultVCPUs
+	// and DefaultMemMiB).
+	VCPUs  int
+	MemMiB int
+	// TapDevice attaches a network interface backed by the host tap
+	// device, with the GuestMAC address when set.
+	TapDevice string
+	GuestMAC  string
+	// VsockCID attaches a vsock device with the guest context ID, the
+	// host end being the VsockUDSPath Unix socket.
+	VsockCID     uint32
+	VsockUDSPath string
+	// Entropy attaches a virtio-rng entropy device.
+	Entropy bool
+}
+
+// ForRelease returns the config booting the kernel k and rootfs r of the
+// arch artifacts a, their files in dir. The paths are absolute, the config
+// is used from any directory. The rootfs paired with an overlay disk or a
+// dm-verity hash tree boots its read-only root with them attached in the
+// drive order of its boot args, and with the initramfs mounting the
+// overlay.
+func ForRelease(dir string, a manifest.ArchArtifacts, arch string, k manifest.KernelArtifact, r manifest.RootfsArtifact, opts Options) (Config, error) {
+	if hv := k.TargetHypervisor(); hv != manifest.HypervisorFirecracker {
+		return Config{}, fmt.Errorf("kernel flavor %s is built for %s, not %s", cmp.Or(k.Flavor, manifest.DefaultFlavor), hv, manifest.HypervisorFirecracker)
+	}
+	kernel, err := SelectKernel(k, arch, nil)
+	if err != nil {
+		return Config{}, err
+	}
+	if opts.VsockCID != 0 && opts.VsockCID < 3 {
+		return Config{}, fmt.Errorf("vsock guest CID %d is reserved, use 3 or higher", opts.VsockCID)
+	}
+
+	root, _ := r.Image(cmp.Or(r.Filesystem, manifest.RootfsFilesystemExt4))
+	paired := r.Overlay != nil || r.Verity != nil
+	switch {
+	case opts.Filesystem != "":
+		img, ok := r.Image(opts.Filesystem)
+		if !ok {
+			return Config{}, fmt.Errorf("rootfs %s has no %s image", r.Profile, opts.Filesystem)
+		}
+		if paired && img.File != rootFile(r) {
+			return Config{}, fmt.Errorf("rootfs %s boots its %s image read-only with its overlay or verity drives, not %s", r.Profile, rootFile(r), img.File)
+		}
+		root = img
+	case paired:
+		img, ok := imageFile(r, rootFile(r))
+		if !ok {
+			return Config{}, fmt.Errorf("rootfs %s has no %s image", r.Profile, rootFile(r))
+		}
+		root = img
+	}
+
+	abs := func(name string) (string, error) { return filepath.Abs(filepath.Join(dir, name)) }
+	cfg := Config{
+		BootSource: BootSource{BootArgs: root.BootArgs},
+		MachineConfig: MachineConfig{
+			VCPUCount:  cmp.Or(opts.VCPUs, DefaultVCPUs),
+			MemSizeMiB: cmp.Or(opts.MemMiB, DefaultMemMiB),
+		},
+	}
+	if cfg.BootSource.KernelImagePath, err = abs(kernel.File); err != nil {
+		return Config{}, err
+	}
+	rootDrive := Drive{DriveID: "rootfs", IsRootDevice: true, IsReadOnly: root.ReadOnly || paired}
+	if rootDrive.PathOnHost, err = abs(root.File); err != nil {
+		return Config{}, err
+	}
+	cfg.Drives = append(cfg.Drives, rootDrive)
+
+	if r.Overlay != nil {
+		if opts.Overlay == "" {
+			return Config{}, fmt.Errorf("rootfs %s is paired with the overlay disk template %s, set the path of the VM's copy", r.Profile, r.Overlay.File)
+		}
+		if a.Initramfs == nil {
+			return Config{}, fmt.Errorf("rootfs %s overlay is mounted by the initramfs, the release has none", r.Profile)
+		}
+		overlay, err := filepath.Abs(opts.Overlay)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Drives = append(cfg.Drives, Drive{DriveID: "overlay", PathOnHost: overlay})
+		if cfg.BootSource.InitrdPath, err = abs(a.Initramfs.File); err != nil {
+			return Config{}, err
+		}
+		cfg.BootSource.BootArgs = r.Overlay.BootArgs
+	}
+	if r.Verity != nil {
+		// The verified root is opened by dm-mod.create, Firecracker must not
+		// append its own root=.
+		cfg.Drives[0].IsRootDevice = false
+		drive := Drive{DriveID: "verity", IsReadOnly: true}
+		if drive.PathOnHost, err = abs(r.Verity.File); err != nil {
+			return Config{}, err
+		}
+		cfg.Drives = append(cfg.Drives, drive)
+		if r.Overlay == nil {
+			cfg.BootSource.BootArgs = r.Verity.BootArgs
+		}
+	}
+
+	if opts.TapDevice != "" {
+		cfg.NetworkInterfaces = []NetworkInterface{{IfaceID: "eth0", GuestMAC: opts.GuestMAC, HostDevName: opts.TapDevice}}
+	}
+	if opts.VsockCID != 0 {
+		uds, err := filepath.Abs(opts.VsockUDSPath)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Vsock = &Vsock{GuestCID: opts.VsockCID, UDSPath: uds}
+	}
+	if opts.Entropy {
+		cfg.Entropy = &Entropy{}
+	}
+	return cfg, nil
+}
+
+// rootFile returns the image file the overlay or verity drives of r go
+// with.
+func rootFile(r manifest.RootfsArtifact) string {
+	if r.Verity != nil {
+		return r.Verity.Root
+	}
+	if r.Overlay != nil {
+		return r.Overlay.Root
+	}
+	return r.File
+}
+
+// imageFile returns the rootfs image of r named file.
+func imageFile(r manifest.RootfsArtifact, file string) (manifest.RootfsImage, bool) {
+	if img, ok := r.Image(cmp.Or(r.Filesystem, manifest.RootfsFilesystemExt4)); ok && img.File == file {
+		return img, true
+	}
+	for _, img := range r.Images {
+		if img.File == file {
+			return img, true
+		}
+	}
+	return manifest.RootfsImage{}, false
+}
@@ -0,0 +1,94 @@
+package vmconfig_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/slok/sbx-images/pkg/manifest"
+	"github.com/slok/sbx-images/pkg/testutil"
+	"github.com/slok/sbx-images/pkg/vmconfig"
+)
+
+func TestForRelease(t *testing.T) {
+	dir, m := testutil.NewRelease(t, testutil.Release{})
+	a := m.Artifacts["x86_64"]
+
+	cfg, err := vmconfig.ForRelease(dir, a, "x86_64", a.Kernel, a.Rootfs, vmconfig.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MachineConfig.VCPUCount != vmconfig.DefaultVCPUs || cfg.MachineConfig.MemSizeMiB != vmconfig.DefaultMemMiB {
+		t.Errorf("got machine config %+v, want the defaults", cfg.MachineConfig)
+	}
+	if want := filepath.Join(dir, a.Kernel.File); cfg.BootSource.KernelImagePath != want {
+		t.Errorf("got kernel %s, want %s", cfg.BootSource.KernelImagePath, want)
+	}
+	if len(cfg.Drives) != 1 || cfg.Drives[0].IsReadOnly || !cfg.Drives[0].IsRootDevice {
+		t.Errorf("got drives %+v, want a writable root drive", cfg.Drives)
+	}
+	if cfg.Vsock != nil || cfg.NetworkInterfaces != nil || cfg.Entropy != nil {
+		t.Error("got devices that were not requested")
+	}
+}
+
+func TestForReleaseOverlay(t *testing.T) {
+	dir, m := testutil.NewRelease(t, testutil.Release{})
+	a := m.Artifacts["x86_64"]
+	r := a.Rootfs
+	r.Overlay = &manifest.RootfsOverlay{
+		RootfsImage: manifest.RootfsImage{File: manifest.OverlayFile("x86_64"), BootArgs: r.BootArgs + " sbx.overlay=/dev/vdb"},
+		Root:        r.File,
+	}
+
+	if _, err := vmconfig.ForRelease(dir, a, "x86_64", a.Kernel, r, vmconfig.Options{}); err == nil {
+		t.Error("expected an error without the overlay copy path")
+	}
+	opts := vmconfig.Options{Overlay: filepath.Join(dir, "vm1.overlay.ext4")}
+	if _, err := vmconfig.ForRelease(dir, a, "x86_64", a.Kernel, r, opts); err == nil {
+		t.Error("expected an error without an initramfs")
+	}
+
+	a.Initramfs = &manifest.InitramfsArtifact{File: "initramfs-x86_64.cpio.gz"}
+	cfg, err := vmconfig.ForRelease(dir, a, "x86_64", a.Kernel, r, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Drives) != 2 || !cfg.Drives[0].IsReadOnly || cfg.Drives[1].PathOnHost != opts.Overlay {
+		t.Errorf("got drives %+v, want the read-only root and the overlay copy", cfg.Drives)
+	}
+	if cfg.BootSource.BootArgs != r.Overlay.BootArgs || cfg.BootSource.InitrdPath == "" {
+		t.Errorf("got boot source %+v, want the overlay boot args and the initramfs", cfg.BootSource)
+	}
+}
+
+func TestForReleaseDevices(t *testing.T) {
+	dir, m := testutil.NewRelease(t, testutil.Release{})
+	a := m.Artifacts["x86_64"]
+
+	if _, err := vmconfig.ForRelease(dir, a, "x86_64", a.Kernel, a.Rootfs, vmconfig.Options{VsockCID: 2}); err == nil {
+		t.Error("expected an error for a reserved vsock CID")
+	}
+
+	cfg, err := vmconfig.ForRelease(dir, a, "x86_64", a.Kernel, a.Rootfs, vmconfig.Options{
+		TapDevice:    "tap0",
+		GuestMAC:     "06:00:ac:10:00:02",
+		VsockCID:     3,
+		VsockUDSPath: filepath.Join(dir, "vsock.sock"),
+		Entropy:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.NetworkInterfaces) != 1 || cfg.NetworkInterfaces[0].HostDevName != "tap0" {
+		t.Errorf("got network interfaces %+v, want tap0", cfg.NetworkInterfaces)
+	}
+	if cfg.Vsock == nil || cfg.Vsock.GuestCID != 3 || cfg.Entropy == nil {
+		t.Errorf("got vsock %+v and entropy %+v, want both", cfg.Vsock, cfg.Entropy)
+	}
+
+	k := a.Kernel
+	k.Hypervisor = manifest.HypervisorCloudHypervisor
+	if _, err := vmconfig.ForRelease(dir, a, "x86_64", k, a.Rootfs, vmconfig.Options{}); err == nil {
+		t.Error("expected an error for a kernel of another hypervisor")
+	}
+}
@@ -0,0 +1,71 @@
+// Package vmconfig defines the Firecracker VM configuration file
+// (firecracker --config-file) booting the release images.
+package vmconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config is a Firecracker VM configuration.
+type Config struct {
+	BootSource    BootSource    `json:"boot-source"`
+	Drives        []Drive       `json:"drives"`
+	MachineConfig MachineConfig `json:"machine-config"`
+	// Entropy attaches a virtio-rng entropy device when set.
+	Entropy           *Entropy           `json:"entropy,omitempty"`
+	NetworkInterfaces []NetworkInterface `json:"network-interfaces,omitempty"`
+	Vsock             *Vsock             `json:"vsock,omitempty"`
+}
+
+// BootSource is the guest kernel, its cmdline and the optional initrd.
+type BootSource struct {
+	KernelImagePath string `json:"kernel_image_path"`
+	BootArgs        string `json:"boot_args,omitempty"`
+	InitrdPath      string `json:"initrd_path,omitempty"`
+}
+
+// Drive is a guest block device.
+type Drive struct {
+	DriveID      string `json:"drive_id"`
+	PathOnHost   string `json:"path_on_host"`
+	IsRootDevice bool   `json:"is_root_device"`
+	IsReadOnly   bool   `json:"is_read_only"`
+}
+
+// MachineConfig is the guest vCPU and memory size.
+type MachineConfig struct {
+	VCPUCount  int `json:"vcpu_count"`
+	MemSizeMiB int `json:"mem_size_mib"`
+}
+
+// Entropy is the virtio-rng device configuration.
+type Entropy struct{}
+
+// NetworkInterface is a guest virtio-net device backed by a host tap
+// device.
+type NetworkInterface struct {
+	IfaceID     string `json:"iface_id"`
+	GuestMAC    string `json:"guest_mac,omitempty"`
+	HostDevName string `json:"host_dev_name"`
+}
+
+// Vsock is the guest virtio-vsock device, its host end the UDSPath Unix
+// socket.
+type Vsock struct {
+	GuestCID uint32 `json:"guest_cid"`
+	UDSPath  string `json:"uds_path"`
+}
+
+// Write stores the config as indented JSON at path.
+func Write(path string, c Config) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling VM config: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing VM config: %w", err)
+	}
+	return nil
+}
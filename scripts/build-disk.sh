@@ -0,0 +1,61 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Builds an empty pre-formatted data disk (config.yaml disks), a sparse file
+# of --size-mib formatted with --filesystem (ext4 or btrfs) and labelled
+# --label. The filesystem UUID is derived from the disk name and ext4 disks
+# are dated SOURCE_DATE_EPOCH, so the disk is reproducible. The mkfs
+# options are written to <output>.mkfs for the manifest. No root needed.
+#
+# Usage:
+#   ./scripts/build-disk.sh --name workspace --filesystem ext4 --size-mib 2048 --label workspace --output build/disk-workspace.ext4
+
+NAME=""
+FILESYSTEM="ext4"
+SIZE_MB=""
+LABEL=""
+OUTPUT_PATH=""
+
+log() { printf '[INFO] %s\n' "$*"; }
+die() { printf '[ERROR] %s\n' "$*" >&2; exit 1; }
+
+while [[ $# -gt 0 ]]; do
+  case "$1" in
+    --name)       NAME="$2";        shift 2 ;;
+    --filesystem) FILESYSTEM="$2";  shift 2 ;;
+    --size-mib)   SIZE_MB="$2";     shift 2 ;;
+    --label)      LABEL="$2";       shift 2 ;;
+    --output)     OUTPUT_PATH="$2"; shift 2 ;;
+    *) die "Unknown argument: $1" ;;
+  esac
+done
+
+[[ -n "${NAME}" ]]        || die "--name is required"
+[[ -n "${SIZE_MB}" ]]     || die "--size-mib is required"
+[[ -n "${OUTPUT_PATH}" ]] || die "--output is required"
+LABEL="${LABEL:-${NAME}}"
+
+uuid="$(printf '%s' "sbx-disk-${NAME}" | sha256sum | sed -E 's/^(.{8})(.{4})(.{4})(.{4})(.{12}).*/\1-\2-\3-\4-\5/')"
+case "${FILESYSTEM}" in
+  ext4)
+    command -v mkfs.ext4 >/dev/null 2>&1 || die "mkfs.ext4 (e2fsprogs) is required"
+    mkfs_options="-L ${LABEL} -U ${uuid} -E hash_seed=${uuid},lazy_itable_init=0"
+    ;;
+  btrfs)
+    command -v mkfs.btrfs >/dev/null 2>&1 || die "mkfs.btrfs (btrfs-progs) is required"
+    mkfs_options="--label ${LABEL} --uuid ${uuid}"
+    ;;
+  *) die "Unknown disk filesystem: ${FILESYSTEM}" ;;
+esac
+
+mkdir -p "$(dirname "${OUTPUT_PATH}")"
+log "Creating ${SIZE_MB} MB ${FILESYSTEM} data disk: ${OUTPUT_PATH}"
+rm -f "${OUTPUT_PATH}"
+truncate -s "${SIZE_MB}M" "${OUTPUT_PATH}"
+case "${FILESYSTEM}" in
+  ext4)  E2FSPROGS_FAKE_TIME="${SOURCE_DATE_EPOCH:-0}" mkfs.ext4 -q ${mkfs_options} "${OUTPUT_PATH}" ;;
+  btrfs) mkfs.btrfs -q ${mkfs_options} "${OUTPUT_PATH}" ;;
+esac
+printf 'mkfs_options=%s\nmount_options=\n' "${mkfs_options}" >"${OUTPUT_PATH}.mkfs"
+
+log "Built disk: ${OUTPUT_PATH}"
@@ -0,0 +1,82 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Builds the initramfs booted as the Firecracker initrd: a static busybox
+# (Alpine busybox-static package) and the /init script, packed as a gzip
+# compressed newc cpio archive (initramfs-<arch>.cpio.gz). The busybox
+# package version is written to initramfs-<arch>.source for the manifest.
+#
+# Usage:
+#   ./scripts/build-initramfs.sh --arch x86_64 --branch v3.23 --init initramfs/init --output-dir build
+
+ARCH=""
+ALPINE_BRANCH=""
+INIT=""
+OUTPUT_DIR=""
+
+ALPINE_MIRROR="${ALPINE_MIRROR:-https://dl-cdn.alpinelinux.org/alpine}"
+
+log() { printf '[INFO] %s\n' "$*"; }
+die() { printf '[ERROR] %s\n' "$*" >&2; exit 1; }
+
+while [[ $# -gt 0 ]]; do
+  case "$1" in
+    --arch)       ARCH="$2";          shift 2 ;;
+    --branch)     ALPINE_BRANCH="$2"; shift 2 ;;
+    --init)       INIT="$2";          shift 2 ;;
+    --output-dir) OUTPUT_DIR="$2";    shift 2 ;;
+    *) die "Unknown argument: $1" ;;
+  esac
+done
+
+[[ -n "${ARCH}" ]]          || die "--arch is required"
+[[ -n "${ALPINE_BRANCH}" ]] || die "--branch is required"
+[[ -n "${INIT}" ]]          || die "--init is required"
+[[ -n "${OUTPUT_DIR}" ]]    || die "--output-dir is required"
+[[ -f "${INIT}" ]]          || die "Missing init script: ${INIT}"
+
+for tool in curl tar cpio gzip; do
+  command -v "${tool}" >/dev/null 2>&1 || die "${tool} is required"
+done
+
+WORKDIR="$(mktemp -d -t sbx-initramfs-XXXXXX)"
+trap 'rm -rf "${WORKDIR}"' EXIT
+STAGE_DIR="${WORKDIR}/initramfs"
+REPO_URL="${ALPINE_MIRROR}/${ALPINE_BRANCH}/main/${ARCH}"
+OUTPUT_PATH="${OUTPUT_DIR}/initramfs-${ARCH}.cpio.gz"
+
+mkdir -p "${OUTPUT_DIR}"
+
+# --- Fetch busybox ---
+
+log "Resolving busybox-static in ${REPO_URL}"
+curl --fail --silent --show-error --location --output "${WORKDIR}/APKINDEX.tar.gz" "${REPO_URL}/APKINDEX.tar.gz"
+BUSYBOX_VERSION="$(tar -xzOf "${WORKDIR}/APKINDEX.tar.gz" APKINDEX \
+  | awk -F: '/^P:/ { pkg = $2 } /^V:/ && pkg == "busybox-static" { print $2; exit }')"
+[[ -n "${BUSYBOX_VERSION}" ]] || die "busybox-static not found in ${REPO_URL}"
+
+APK_URL="${REPO_URL}/busybox-static-${BUSYBOX_VERSION}.apk"
+log "Downloading ${APK_URL}"
+curl --fail --silent --show-error --location --output "${WORKDIR}/busybox-static.apk" "${APK_URL}"
+
+# An apk is concatenated gzip tar streams (signature, control and data).
+mkdir -p "${WORKDIR}/apk"
+tar -xzf "${WORKDIR}/busybox-static.apk" --ignore-zeros -C "${WORKDIR}/apk" 2>/dev/null || true
+[[ -f "${WORKDIR}/apk/bin/busybox.static" ]] || die "No bin/busybox.static in ${APK_URL}"
+
+# --- Pack ---
+
+mkdir -p "${STAGE_DIR}"/{bin,dev,proc,sys,run,tmp,newroot}
+install -m 0755 "${WORKDIR}/apk/bin/busybox.static" "${STAGE_DIR}/bin/busybox"
+install -m 0755 "${INIT}" "${STAGE_DIR}/init"
+
+# Sorted entries, fixed owners and mtimes keep the archive reproducible.
+SOURCE_EPOCH="${SOURCE_DATE_EPOCH:-0}"
+find "${STAGE_DIR}" -exec touch -h -d "@${SOURCE_EPOCH}" {} +
+(cd "${STAGE_DIR}" && find . -mindepth 1 -print0 | LC_ALL=C sort -z \
+  | cpio --null --create --format=newc --owner=0:0 --reproducible --quiet) \
+  | gzip -9 -n > "${OUTPUT_PATH}"
+
+printf 'package=busybox-static\nversion=%s\nurl=%s\n' "${BUSYBOX_VERSION}" "${APK_URL}" > "${OUTPUT_DIR}/initramfs-${ARCH}.source"
+
+log "Built initramfs: ${OUTPUT_PATH} ($(du -h "${OUTPUT_PATH}" | cut -f1), busybox ${BUSYBOX_VERSION})"
@@ -0,0 +1,232 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Builds a Linux kernel from source for Firecracker, as an alternative to the
+# Firecracker CI binaries. The kernel tree is cloned at the given ref and
+# compiled (cross-compiled for foreign architectures) inside a builder
+# container with the given (merged) kernel config.
+#
+# The *.patch files in --patches-dir (prepared by cmd/kernel-patches) are
+# applied in name order before building.
+#
+# Writes vmlinux-<arch>, the resolved vmlinux-<arch>.config and
+# vmlinux-<arch>.source (repo, ref and commit) to the output dir. With
+# --modules the loadable modules are built too and packed (lib/modules tree)
+# into modules-<arch>.tar.zst, which requires CONFIG_MODULES=y. With
+# --bzimage (x86_64 only) the compressed bzImage is packaged too, as
+# bzImage-<arch>.
+#
+# Files of named kernel flavors (--flavor) are named <name>-<flavor>-<arch>.
+#
+# The builder container runs with --runtime (default: $CONTAINER_RUNTIME or
+# auto, the docker daemon when it answers, podman otherwise), rootless
+# engines included, as pkg/container.
+#
+# Usage:
+#   ./scripts/build-kernel.sh --arch x86_64 --repo https://git.kernel.org/.../linux.git \
+#     --ref v6.1.155 --config build/kernel-x86_64.config --output-dir build \
+#     [--flavor full] [--patches-dir build/kernel-patches/default] [--modules] [--bzimage] \
+#     [--runtime podman]
+
+ARCH=""
+REPO=""
+REF=""
+CONFIG=""
+OUTPUT_DIR=""
+PATCHES_DIR=""
+FLAVOR="default"
+MODULES="false"
+BZIMAGE="false"
+
+CONTAINER_RUNTIME="${CONTAINER_RUNTIME:-auto}"
+BUILDER_IMAGE="${KERNEL_BUILDER_IMAGE:-sbx-kernel-builder}"
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+BUILDER_DIR="${SCRIPT_DIR}/../kernel"
+
+log() { printf '[INFO] %s\n' "$*"; }
+die() { printf '[ERROR] %s\n' "$*" >&2; exit 1; }
+
+while [[ $# -gt 0 ]]; do
+  case "$1" in
+    --arch)        ARCH="$2";        shift 2 ;;
+    --repo)        REPO="$2";        shift 2 ;;
+    --ref)         REF="$2";         shift 2 ;;
+    --config)      CONFIG="$2";      shift 2 ;;
+    --output-dir)  OUTPUT_DIR="$2";  shift 2 ;;
+    --patches-dir) PATCHES_DIR="$2"; shift 2 ;;
+    --flavor)      FLAVOR="$2";      shift 2 ;;
+    --modules)     MODULES="true";   shift ;;
+    --bzimage)     BZIMAGE="true";   shift ;;
+    --runtime)     CONTAINER_RUNTIME="$2"; shift 2 ;;
+    *) die "Unknown argument: $1" ;;
+  esac
+done
+
+[[ -n "${ARCH}" ]]       || die "--arch is required"
+[[ -n "${REPO}" ]]       || die "--repo is required"
+[[ -n "${REF}" ]]        || die "--ref is required"
+[[ -n "${CONFIG}" ]]     || die "--config is required"
+[[ -n "${OUTPUT_DIR}" ]] || die "--output-dir is required"
+[[ -f "${CONFIG}" ]]     || die "Missing kernel config: ${CONFIG} (run make kernel-config)"
+
+command -v git >/dev/null 2>&1                  || die "git is required"
+if [[ "${MODULES}" == "true" ]]; then
+  command -v zstd >/dev/null 2>&1               || die "zstd is required to pack the kernel modules"
+fi
+
+if [[ "${CONTAINER_RUNTIME}" == "auto" ]]; then
+  if docker info >/dev/null 2>&1; then
+    CONTAINER_RUNTIME="docker"
+  elif podman info >/dev/null 2>&1; then
+    CONTAINER_RUNTIME="podman"
+  else
+    die "No container runtime found: install podman or start the docker daemon"
+  fi
+fi
+# The build writes the object dir as the calling user: rootless engines run
+# the container root as that user (podman keeps its ID), rootful ones run
+# the container as it.
+case "${CONTAINER_RUNTIME}" in
+  docker)
+    security="$(docker info --format '{{json .SecurityOptions}}' 2>/dev/null)" || die "docker daemon not reachable"
+    if [[ "${security}" == *name=rootless* ]]; then
+      RUN_USER=()
+    else
+      RUN_USER=(--user "$(id -u):$(id -g)")
+    fi
+    VOLUME_LABEL=""
+    ;;
+  podman)
+    rootless="$(podman info --format '{{.Host.Security.Rootless}}' 2>/dev/null)" || die "podman not usable"
+    if [[ "${rootless}" == "true" ]]; then
+      RUN_USER=(--userns=keep-id)
+    else
+      RUN_USER=(--user "$(id -u):$(id -g)")
+    fi
+    # SELinux relabeling, a no-op elsewhere.
+    VOLUME_LABEL="z"
+    ;;
+  *) die "Unsupported container runtime: ${CONTAINER_RUNTIME} (docker, podman or auto)" ;;
+esac
+
+# Firecracker boots the uncompressed ELF vmlinux on x86_64 and the Image on
+# aarch64 and riscv64.
+case "${ARCH}" in
+  x86_64)  KARCH="x86";   CROSS_PREFIX="x86_64-linux-gnu-";  TARGET="vmlinux"; KERNEL_IMAGE="vmlinux" ;;
+  aarch64) KARCH="arm64"; CROSS_PREFIX="aarch64-linux-gnu-"; TARGET="Image";   KERNEL_IMAGE="arch/arm64/boot/Image" ;;
+  riscv64) KARCH="riscv"; CROSS_PREFIX="riscv64-linux-gnu-"; TARGET="Image";   KERNEL_IMAGE="arch/riscv/boot/Image" ;;
+  *) die "Unsupported architecture: ${ARCH}" ;;
+esac
+if [[ "$(uname -m)" == "${ARCH}" ]]; then
+  CROSS_PREFIX=""
+fi
+if [[ "${BZIMAGE}" == "true" && "${ARCH}" != "x86_64" ]]; then
+  die "--bzimage is only supported on x86_64"
+fi
+
+shopt -s nullglob
+PATCHES=()
+if [[ -n "${PATCHES_DIR}" ]]; then
+  [[ -d "${PATCHES_DIR}" ]] || die "Missing patches directory: ${PATCHES_DIR} (run cmd/kernel-patches)"
+  for patch in "${PATCHES_DIR}"/*.patch; do
+    PATCHES+=("$(cd "$(dirname "${patch}")" && pwd)/$(basename "${patch}")")
+  done
+fi
+
+STEM="${ARCH}"
+if [[ "${FLAVOR}" != "default" ]]; then
+  STEM="${FLAVOR}-${ARCH}"
+fi
+
+mkdir -p "${OUTPUT_DIR}"
+OUTPUT_DIR="$(cd "${OUTPUT_DIR}" && pwd)"
+SRC_DIR="${OUTPUT_DIR}/kernel-src"
+OBJ_DIR="${OUTPUT_DIR}/kernel-obj-${STEM}"
+
+# --- Fetch the kernel tree ---
+
+# The tree is shared between architectures and flavors, only the requested
+# ref is fetched.
+if [[ ! -d "${SRC_DIR}/.git" ]]; then
+  git init -q "${SRC_DIR}"
+fi
+log "Fetching ${REPO} ${REF}"
+git -C "${SRC_DIR}" fetch -q --depth 1 "${REPO}" "${REF}"
+# Drop the patches applied by a previous build before checking out.
+git -C "${SRC_DIR}" reset -q --hard
+git -C "${SRC_DIR}" clean -q -fdx
+git -C "${SRC_DIR}" checkout -q --detach FETCH_HEAD
+COMMIT="$(git -C "${SRC_DIR}" rev-parse HEAD)"
+log "Kernel source commit: ${COMMIT}"
+
+for patch in "${PATCHES[@]}"; do
+  log "Applying patch: $(basename "${patch}")"
+  git -C "${SRC_DIR}" apply "${patch}" || die "Patch does not apply: ${patch}"
+done
+
+# --- Build ---
+
+log "Building builder image: ${BUILDER_IMAGE}"
+"${CONTAINER_RUNTIME}" build -q -t "${BUILDER_IMAGE}" "${BUILDER_DIR}" >/dev/null
+
+mkdir -p "${OBJ_DIR}"
+cp "${CONFIG}" "${OBJ_DIR}/.config"
+
+# Fixed build user, host and timestamp keep the kernel banner reproducible.
+SOURCE_EPOCH="${SOURCE_DATE_EPOCH:-$(git -C "${SRC_DIR}" log -1 --format=%ct)}"
+BUILD_TIMESTAMP="$(date -u -d "@${SOURCE_EPOCH}")"
+
+TARGETS=(olddefconfig "${TARGET}")
+if [[ "${BZIMAGE}" == "true" ]]; then
+  TARGETS+=(bzImage)
+fi
+if [[ "${MODULES}" == "true" ]]; then
+  TARGETS+=(modules)
+fi
+
+# kbuild runs make in the builder container with the kernel tree and object
+# dir mounted.
+kbuild() {
+  "${CONTAINER_RUNTIME}" run --rm \
+    "${RUN_USER[@]}" \
+    -v "${SRC_DIR}:/src:ro${VOLUME_LABEL:+,${VOLUME_LABEL}}" \
+    -v "${OBJ_DIR}:/obj${VOLUME_LABEL:+:${VOLUME_LABEL}}" \
+    -e KBUILD_BUILD_USER=sbx \
+    -e KBUILD_BUILD_HOST=sbx-images \
+    -e KBUILD_BUILD_TIMESTAMP="${BUILD_TIMESTAMP}" \
+    "${BUILDER_IMAGE}" \
+    make -C /src O=/obj ARCH="${KARCH}" CROSS_COMPILE="${CROSS_PREFIX}" -j"$(nproc)" "$@"
+}
+
+log "Building kernel ${REF} for ${ARCH} (ARCH=${KARCH} CROSS_COMPILE=${CROSS_PREFIX:-native})"
+kbuild "${TARGETS[@]}"
+
+cp "${OBJ_DIR}/${KERNEL_IMAGE}" "${OUTPUT_DIR}/vmlinux-${STEM}"
+cp "${OBJ_DIR}/.config" "${OUTPUT_DIR}/vmlinux-${STEM}.config"
+printf 'repo=%s\nref=%s\ncommit=%s\n' "${REPO}" "${REF}" "${COMMIT}" > "${OUTPUT_DIR}/vmlinux-${STEM}.source"
+
+log "Built kernel: ${OUTPUT_DIR}/vmlinux-${STEM} ($(du -h "${OUTPUT_DIR}/vmlinux-${STEM}" | cut -f1))"
+
+if [[ "${BZIMAGE}" == "true" ]]; then
+  cp "${OBJ_DIR}/arch/x86/boot/bzImage" "${OUTPUT_DIR}/bzImage-${STEM}"
+  log "Built kernel bzImage: ${OUTPUT_DIR}/bzImage-${STEM} ($(du -h "${OUTPUT_DIR}/bzImage-${STEM}" | cut -f1))"
+fi
+
+# --- Modules ---
+
+if [[ "${MODULES}" == "true" ]]; then
+  grep -q '^CONFIG_MODULES=y$' "${OBJ_DIR}/.config" || die "--modules requires CONFIG_MODULES=y in the kernel config"
+
+  MODULES_FILE="${OUTPUT_DIR}/modules-${STEM}.tar.zst"
+  rm -rf "${OBJ_DIR}/modules-install"
+  log "Installing kernel modules"
+  kbuild INSTALL_MOD_PATH=/obj/modules-install INSTALL_MOD_STRIP=1 modules_install
+
+  # The build and source links point into the builder container.
+  rm -f "${OBJ_DIR}"/modules-install/lib/modules/*/{build,source}
+
+  # Sorted entries, fixed owners and mtimes keep the archive reproducible.
+  tar --sort=name --mtime="@${SOURCE_EPOCH}" --owner=0 --group=0 --numeric-owner \
+    -C "${OBJ_DIR}/modules-install" -cf - lib | zstd -q -19 -f -o "${MODULES_FILE}"
+  log "Packed kernel modules: ${MODULES_FILE} ($(du -h "${MODULES_FILE}" | cut -f1))"
+fi
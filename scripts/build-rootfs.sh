@@ -6,19 +6,96 @@ set -euo pipefail
 #
 # Usage:
 #   sudo ./scripts/build-rootfs.sh --arch x86_64 --profile balanced --branch v3.23 \
-#     --profiles-dir alpine/profiles --files-dir alpine/files --output-dir build
+#     [--bootstrap alpine-make-rootfs] [--distro debian --base-tar build/rootfs-base-x86_64.tar] \
+#     --packages openssh,openrc,e2fsprogs-extra,bash,git [--init openrc] \
+#     [--timezone Europe/Madrid] [--locale C.UTF-8] [--hostname sbx-dev] \
+#     --files-dir alpine/files --output-dir build [--firstboot] [--cloud-init] \
+#     [--modules build/modules-x86_64.tar.zst,build/modules-full-x86_64.tar.zst] \
+#     [--stem minimal-x86_64] [--files-dirs profiles/dev/files] [--files-stage build/rootfs-files/dev] \
+#     [--filesystems squashfs] [--overlay-size-mib 1024] [--verity ext4] \
+#     [--ext4-options "-i 16384 -m 0 -O ^has_journal"] [--size-mib 2048]
+#
+# The outputs are named rootfs-<stem>.{ext4,apkdb,toolchain}, the stem
+# defaults to the architecture (rootfs-<stem>.debdb, the dpkg status, instead
+# of the apk database for Debian). --packages is the complete package set
+# requested by the profile definition, rendered by cmd/rootfs-profiles from
+# config.yaml (including the base, init and first boot packages).
+# --bootstrap is how the Alpine tree is created: alpine-make-rootfs (default)
+# or minirootfs, the checksum verified release minirootfs tarball of the
+# branch with the packages added by its own apk, without a package cache
+# (smaller images, no alpine-make-rootfs needed). Debian images (--distro
+# debian, --branch is then the suite) are built from the --base-tar archive
+# made by cmd/rootfs-debian with the --bootstrap tool (mmdebstrap or
+# debootstrap), the packages included. --bootstrap oci builds the image from
+# the --base-tar container image filesystem exported by cmd/rootfs-oci, based
+# on --distro, the packages added with its package manager. --bootstrap
+# buildkit builds it from the --base-tar exported by cmd/rootfs-buildkit,
+# the packages included. --init is
+# the init system the SBX services and the ttyS0 serial console are set up
+# for: openrc, systemd (generated units) or sbx (busybox init running the
+# generated /etc/sbx/rc.d scripts with sbx-rc). --timezone (a zoneinfo name,
+# tzdata must be requested unless UTC), --locale (LANG of login shells and
+# systemd) and --hostname set up the image, which keeps the distro hostname
+# without --hostname. --files-dirs are copied over the image in order after
+# the SBX files, then the config.yaml users staged by cmd/rootfs-files are
+# created with their SSH keys and the staged files are installed with their
+# mode and owner. The staged agent is
+# installed and registered as a daemon with the init system. --cloud-init
+# enables the installed cloud-init services, limited to the NoCloud
+# datasource (cidata seed drives rendered by cmd/nocloud-seed). A staged
+# Ignition binary and config are installed with the sbx-ignition first boot
+# service. --filesystems also writes the finished tree as
+# rootfs-<stem>.<filesystem> images: squashfs (read-only, zstd compressed),
+# erofs (read-only, lz4hc compressed, faster random reads) and btrfs
+# (writable, zstd transparent compression, for snapshots in the guest). The
+# mkfs and mount options of each are written to
+# rootfs-<stem>.<filesystem>.mkfs for the manifest. --overlay-size-mib also
+# writes rootfs-<stem>.overlay.ext4, the empty (sparse) ext4 overlay disk
+# template of the image booted read-only, with a UUID derived from the stem
+# and dated SOURCE_DATE_EPOCH so it is reproducible. --verity <filesystem>
+# runs veritysetup format over the rootfs-<stem>.<filesystem> image booted
+# read-only, writing the rootfs-<stem>.<filesystem>.verity hash tree and its
+# root hash, salt (the image digest, for reproducibility) and geometry to
+# rootfs-<stem>.<filesystem>.verity.info for the manifest. The ext4 and
+# btrfs images are sparse, their unused blocks punched out with fallocate
+# --dig-holes: the manifest records their size and disk usage.
+# --ext4-options are passed to mkfs.ext4 for the ext4 image (inode ratio,
+# reserved blocks, journal, label and UUID from rootfs.ext4) and written
+# to rootfs-<stem>.ext4.mkfs, as for the other filesystems. --size-mib
+# makes the ext4 image that size, not shrunk, instead of the tree size plus
+# the overhead, failing when the tree does not fit.
 
 ARCH=""
+STEM=""
 PROFILE=""
 ALPINE_BRANCH=""
-PROFILES_DIR=""
+DISTRO="alpine"
+BOOTSTRAP="alpine-make-rootfs"
+BASE_TAR=""
 FILES_DIR=""
 OUTPUT_DIR=""
 OVERHEAD_PERCENT="35"
 MIN_OVERHEAD_MB="256"
 SHRINK_IMAGE="true"
-
-REQUIRED_PACKAGES=(openssh openrc e2fsprogs-extra)
+INSTALL_FIRSTBOOT="false"
+CLOUD_INIT="false"
+INIT="openrc"
+TIMEZONE="UTC"
+LOCALE="C.UTF-8"
+HOSTNAME_=""
+MODULES_FILES=()
+PACKAGES=()
+FILES_DIRS=()
+FILES_STAGE=""
+FILESYSTEMS=()
+SQUASHFS_MKFS_OPTIONS="-noappend -comp zstd"
+EROFS_MKFS_OPTIONS="-zlz4hc"
+BTRFS_MKFS_OPTIONS="--label rootfs --metadata single"
+BTRFS_MOUNT_OPTIONS="compress=zstd:3"
+OVERLAY_SIZE_MB=""
+EXT4_MKFS_OPTIONS=""
+VERITY=""
+IMAGE_SIZE_MB=""
 
 log() { printf '[INFO] %s\n' "$*"; }
 warn() { printf '[WARN] %s\n' "$*"; }
@@ -28,13 +105,31 @@ while [[ $# -gt 0 ]]; do
   case "$1" in
     --arch)            ARCH="$2";           shift 2 ;;
     --profile)         PROFILE="$2";        shift 2 ;;
+    --stem)            STEM="$2";           shift 2 ;;
     --branch)          ALPINE_BRANCH="$2";  shift 2 ;;
-    --profiles-dir)    PROFILES_DIR="$2";   shift 2 ;;
+    --distro)          DISTRO="$2";         shift 2 ;;
+    --bootstrap)       BOOTSTRAP="$2";      shift 2 ;;
+    --base-tar)        BASE_TAR="$2";       shift 2 ;;
     --files-dir)       FILES_DIR="$2";      shift 2 ;;
     --output-dir)      OUTPUT_DIR="$2";     shift 2 ;;
     --overhead-percent) OVERHEAD_PERCENT="$2"; shift 2 ;;
     --min-overhead-mb) MIN_OVERHEAD_MB="$2"; shift 2 ;;
     --no-shrink)       SHRINK_IMAGE="false"; shift ;;
+    --firstboot)       INSTALL_FIRSTBOOT="true"; shift ;;
+    --cloud-init)      CLOUD_INIT="true";   shift ;;
+    --init)            INIT="$2";           shift 2 ;;
+    --timezone)        TIMEZONE="$2";       shift 2 ;;
+    --locale)          LOCALE="$2";         shift 2 ;;
+    --hostname)        HOSTNAME_="$2";      shift 2 ;;
+    --modules)         IFS=, read -ra MODULES_FILES <<< "$2"; shift 2 ;;
+    --packages)        IFS=, read -ra PACKAGES <<< "$2"; shift 2 ;;
+    --files-dirs)      IFS=, read -ra FILES_DIRS <<< "$2"; shift 2 ;;
+    --files-stage)     FILES_STAGE="$2";    shift 2 ;;
+    --filesystems)     IFS=, read -ra FILESYSTEMS <<< "$2"; shift 2 ;;
+    --overlay-size-mib) OVERLAY_SIZE_MB="$2"; shift 2 ;;
+    --ext4-options)    EXT4_MKFS_OPTIONS="$2"; shift 2 ;;
+    --verity)          VERITY="$2";         shift 2 ;;
+    --size-mib)        IMAGE_SIZE_MB="$2";  shift 2 ;;
     *) die "Unknown argument: $1" ;;
   esac
 done
@@ -42,25 +137,91 @@ done
 [[ -n "${ARCH}" ]]         || die "--arch is required"
 [[ -n "${PROFILE}" ]]      || die "--profile is required"
 [[ -n "${ALPINE_BRANCH}" ]] || die "--branch is required"
-[[ -n "${PROFILES_DIR}" ]] || die "--profiles-dir is required"
+[[ ${#PACKAGES[@]} -gt 0 ]] || die "--packages is required"
 [[ -n "${FILES_DIR}" ]]    || die "--files-dir is required"
 [[ -n "${OUTPUT_DIR}" ]]   || die "--output-dir is required"
+case "${DISTRO}:${BOOTSTRAP}" in
+  alpine:alpine-make-rootfs|alpine:minirootfs) ;;
+  debian:mmdebstrap|debian:debootstrap|alpine:oci|debian:oci|alpine:buildkit|debian:buildkit)
+    [[ -f "${BASE_TAR}" ]] || die "--base-tar is required with the ${BOOTSTRAP} bootstrap (see cmd/rootfs-debian, cmd/rootfs-oci and cmd/rootfs-buildkit)"
+    ;;
+  *) die "Unknown ${DISTRO} bootstrap: ${BOOTSTRAP}" ;;
+esac
+case "${INIT}" in
+  openrc|systemd|sbx) ;;
+  *) die "Unknown init system: ${INIT}" ;;
+esac
+if [[ "${CLOUD_INIT}" == "true" && "${INIT}" == "sbx" ]]; then
+  die "--cloud-init requires the openrc or systemd init system"
+fi
+
+STEM="${STEM:-${ARCH}}"
 
-PROFILE_FILE="${PROFILES_DIR}/${PROFILE}.txt"
-[[ -f "${PROFILE_FILE}" ]] || die "Unknown profile '${PROFILE}'. Expected file: ${PROFILE_FILE}"
 [[ -d "${FILES_DIR}" ]]    || die "Missing files directory: ${FILES_DIR}"
+for d in "${FILES_DIRS[@]}"; do
+  [[ -d "${d}" ]] || die "Missing profile files directory: ${d}"
+done
+if [[ -n "${FILES_STAGE}" ]]; then
+  [[ -f "${FILES_STAGE}/files.list" ]] || die "Missing staged files list: ${FILES_STAGE}/files.list"
+  [[ -f "${FILES_STAGE}/users.list" ]] || die "Missing staged users list: ${FILES_STAGE}/users.list"
+fi
+for fs in "${FILESYSTEMS[@]}"; do
+  case "${fs}" in
+    squashfs) command -v mksquashfs >/dev/null 2>&1 || die "mksquashfs (squashfs-tools) is required for squashfs images" ;;
+    erofs)    command -v mkfs.erofs >/dev/null 2>&1 || die "mkfs.erofs (erofs-utils) is required for erofs images" ;;
+    btrfs)    command -v mkfs.btrfs >/dev/null 2>&1 || die "mkfs.btrfs (btrfs-progs) is required for btrfs images" ;;
+    *) die "Unknown image filesystem: ${fs}" ;;
+  esac
+done
+if [[ -n "${VERITY}" ]]; then
+  [[ "${VERITY}" == "ext4" || " ${FILESYSTEMS[*]} " == *" ${VERITY} "* ]] || die "--verity ${VERITY} image is not built"
+  command -v veritysetup >/dev/null 2>&1 || die "veritysetup (cryptsetup) is required for --verity"
+fi
+for f in "${MODULES_FILES[@]}"; do
+  [[ -f "${f}" ]] || die "Missing kernel modules archive: ${f}"
+  command -v zstd >/dev/null 2>&1 || die "zstd is required to install the kernel modules"
+done
 
-IMAGE_NAME="rootfs-${ARCH}.ext4"
+# A foreign architecture tree is set up in a chroot through the qemu-user
+# binfmt handler of the architecture, which needs the F (fix binary) flag
+# for its interpreter to be found in the chroot. See go run ./cmd/doctor.
+if [[ "${ARCH}" != "$(uname -m)" ]]; then
+  case "${ARCH}" in
+    x86_64)  binfmt_platform="amd64" ;;
+    aarch64) binfmt_platform="arm64" ;;
+    *)       binfmt_platform="${ARCH}" ;;
+  esac
+  binfmt_install="install qemu-user-static (apt-get install qemu-user-static binfmt-support) or run: docker run --privileged --rm tonistiigi/binfmt --install ${binfmt_platform}"
+  binfmt="/proc/sys/fs/binfmt_misc/qemu-${ARCH}"
+  [[ -f "${binfmt}" ]] || die "Building ${ARCH} on $(uname -m) needs the qemu-${ARCH} binfmt handler: ${binfmt_install}"
+  grep -qx enabled "${binfmt}" || die "The qemu-${ARCH} binfmt handler is disabled: echo 1 > ${binfmt}"
+  grep -q '^flags:.*F' "${binfmt}" || die "The qemu-${ARCH} binfmt handler lacks the F flag, its interpreter is not found in the chroot: ${binfmt_install}"
+fi
+
+IMAGE_NAME="rootfs-${STEM}.ext4"
 WORKDIR="$(mktemp -d -t sbx-rootfs-XXXXXX)"
 MOUNT_DIR="${WORKDIR}/mnt"
+BTRFS_MOUNT_DIR="${WORKDIR}/btrfs"
 ROOTFS_DIR="${WORKDIR}/rootfs"
 EXT4_PATH="${WORKDIR}/${IMAGE_NAME}"
 OUTPUT_PATH="${OUTPUT_DIR}/${IMAGE_NAME}"
+if [[ "${DISTRO}" == "debian" ]]; then
+  PKGDB="var/lib/dpkg/status"
+  PKGDB_PATH="${OUTPUT_DIR}/rootfs-${STEM}.debdb"
+else
+  PKGDB="lib/apk/db/installed"
+  PKGDB_PATH="${OUTPUT_DIR}/rootfs-${STEM}.apkdb"
+fi
+TOOLCHAIN_PATH="${OUTPUT_DIR}/rootfs-${STEM}.toolchain"
 
 cleanup() {
-  if mountpoint -q "${MOUNT_DIR}" 2>/dev/null; then
-    umount "${MOUNT_DIR}" >/dev/null 2>&1 || true
-  fi
+  local m
+  for m in "${MOUNT_DIR}" "${BTRFS_MOUNT_DIR}" "${ROOTFS_DIR}/proc" "${ROOTFS_DIR}/dev"; do
+    if mountpoint -q "${m}" 2>/dev/null; then
+      # Never remove the work directory through a host /dev bind mount.
+      umount "${m}" >/dev/null 2>&1 || return
+    fi
+  done
   rm -rf "${WORKDIR}" 2>/dev/null || true
 }
 trap cleanup EXIT
@@ -90,18 +251,96 @@ resolve_alpine_make_rootfs() {
   printf '%s' "${tool_dir}/alpine-make-rootfs"
 }
 
-# --- Read profile packages ---
-
-read_profile_packages() {
-  local file="$1"
-  local packages=()
-  while IFS= read -r line || [[ -n "$line" ]]; do
-    line="${line%%#*}"
-    line="${line//[$'\t\r\n ']/}"
-    [[ -z "${line}" ]] && continue
-    packages+=("${line}")
-  done <"${file}"
-  printf '%s\n' "${packages[@]}"
+# --- Bootstrap from the minirootfs ---
+
+ALPINE_MIRROR="${ALPINE_MIRROR:-https://dl-cdn.alpinelinux.org/alpine}"
+MINIROOTFS_VERSION=""
+
+# Extracts the latest minirootfs release of the branch into the rootfs, checked
+# against the release list digest, and adds the packages with its own apk.
+bootstrap_minirootfs() {
+  local base="${ALPINE_MIRROR}/${ALPINE_BRANCH}/releases/${ARCH}"
+  local releases="${WORKDIR}/latest-releases.yaml"
+  local file="" sha256="" version=""
+
+  log "Fetching ${base}/latest-releases.yaml"
+  curl -fsSL -o "${releases}" "${base}/latest-releases.yaml" || die "Failed to fetch the ${ALPINE_BRANCH} release list"
+  read -r file sha256 version < <(awk '
+    function emit() { if (flavor == "alpine-minirootfs") print file, sha256, version }
+    /^-/ { emit(); flavor = file = sha256 = version = ""; next }
+    $1 == "flavor:" { flavor = $2 }
+    $1 == "file:" { file = $2 }
+    $1 == "sha256:" { sha256 = $2 }
+    $1 == "version:" { version = $2 }
+    END { emit() }
+  ' "${releases}") || true
+  [[ -n "${file}" && "${sha256}" =~ ^[0-9a-f]{64}$ ]] || die "No minirootfs release for ${ARCH} in ${ALPINE_BRANCH}"
+
+  log "Downloading ${file}"
+  curl -fsSL -o "${WORKDIR}/${file}" "${base}/${file}" || die "Failed to download ${file}"
+  [[ "$(sha256sum "${WORKDIR}/${file}" | awk '{ print $1 }')" == "${sha256}" ]] || die "sha256 mismatch for ${file}"
+  tar -xzf "${WORKDIR}/${file}" -C "${ROOTFS_DIR}"
+  MINIROOTFS_VERSION="${version}"
+
+  printf '%s/%s/main\n%s/%s/community\n' \
+    "${ALPINE_MIRROR}" "${ALPINE_BRANCH}" "${ALPINE_MIRROR}" "${ALPINE_BRANCH}" >"${ROOTFS_DIR}/etc/apk/repositories"
+  install_packages
+}
+
+# Adds the packages to the extracted rootfs with its own package manager,
+# without keeping the package caches and indexes.
+install_packages() {
+  cp -L /etc/resolv.conf "${ROOTFS_DIR}/etc/resolv.conf"
+  mount --bind /dev "${ROOTFS_DIR}/dev"
+  mount -t proc proc "${ROOTFS_DIR}/proc"
+  # shellcheck disable=SC2086 # one word per package.
+  if [[ "${DISTRO}" == "debian" ]]; then
+    chroot "${ROOTFS_DIR}" /bin/sh -c 'apt-get update -q && DEBIAN_FRONTEND=noninteractive apt-get install -q -y --no-install-recommends "$@"' sh ${PACKAGES_STR}
+    chroot "${ROOTFS_DIR}" apt-get clean
+    rm -rf "${ROOTFS_DIR}/var/lib/apt/lists/"*
+  else
+    chroot "${ROOTFS_DIR}" /sbin/apk add --no-cache ${PACKAGES_STR}
+    rm -rf "${ROOTFS_DIR}/var/cache/apk/"*
+  fi
+  umount "${ROOTFS_DIR}/proc"
+  umount "${ROOTFS_DIR}/dev"
+  rm -f "${ROOTFS_DIR}/etc/resolv.conf"
+}
+
+# --- Record toolchain versions ---
+
+# Writes name=version lines for the host tools shaping the image, recorded in
+# the manifest build section for reproducibility audits.
+record_toolchain() {
+  local amr_version
+  if [[ "${BOOTSTRAP}" == "alpine-make-rootfs" ]]; then
+    amr_version="$("${ALPINE_MAKE_ROOTFS}" --version 2>/dev/null | awk 'NR == 1 { print $NF }' || true)"
+    if [[ -z "${amr_version}" ]]; then
+      amr_version="$(git -C "$(dirname "${ALPINE_MAKE_ROOTFS}")" rev-parse HEAD 2>/dev/null || echo unknown)"
+    fi
+  fi
+
+  {
+    case "${BOOTSTRAP}" in
+      alpine-make-rootfs) printf 'alpine-make-rootfs=%s\n' "${amr_version}" ;;
+      minirootfs)         printf 'alpine-minirootfs=%s\n' "${MINIROOTFS_VERSION}" ;;
+      # Written by cmd/rootfs-debian, cmd/rootfs-oci or cmd/rootfs-buildkit
+      # next to the archive.
+      *) cat "${BASE_TAR%.tar}.toolchain" 2>/dev/null || printf '%s=unknown\n' "${BOOTSTRAP}" ;;
+    esac
+    printf 'mkfs.ext4=%s\n' "$(mkfs.ext4 -V 2>&1 | awk 'NR == 1 { print $2 }')"
+    for fs in "${FILESYSTEMS[@]}"; do
+      case "${fs}" in
+        squashfs) printf 'mksquashfs=%s\n' "$(mksquashfs -version 2>&1 | awk 'NR == 1 { print $3 }')" ;;
+        erofs)    printf 'mkfs.erofs=%s\n' "$(mkfs.erofs --version 2>&1 | awk 'NR == 1 { print $2 }')" ;;
+        btrfs)    printf 'mkfs.btrfs=%s\n' "$(mkfs.btrfs --version 2>&1 | awk 'NR == 1 { sub(/^v/, "", $NF); print $NF }')" ;;
+      esac
+    done
+    if [[ -n "${VERITY}" ]]; then
+      printf 'veritysetup=%s\n' "$(veritysetup --version 2>&1 | awk 'NR == 1 { print $2 }')"
+    fi
+    printf 'bash=%s\n' "${BASH_VERSION}"
+  } >"${TOOLCHAIN_PATH}"
 }
 
 # --- Helper functions ---
@@ -125,6 +364,114 @@ install_image_file() {
   install -m "${mode}" "${src}" "${dst}"
 }
 
+# enable_service <name> <order> <kind> <description> <command> registers a
+# oneshot or daemon service with the image init system. OpenRC and systemd
+# enable the image's /etc/init.d/<name> script or unit, generated to run the
+# command when missing. The sbx init runs oneshot commands from the
+# /etc/sbx/rc.d/<order>-<name> script, run by sbx-rc in order, and respawns
+# daemons from inittab.
+enable_service() {
+  local name="$1"
+  local order="$2"
+  local kind="$3"
+  local description="$4"
+  local command="$5"
+
+  case "${INIT}" in
+    openrc)
+      if [[ ! -e "${MOUNT_DIR}/etc/init.d/${name}" ]]; then
+        if [[ "${kind}" == "daemon" ]]; then
+          cat >"${MOUNT_DIR}/etc/init.d/${name}" <<EOF
+#!/sbin/openrc-run
+# Managed by sbx.
+
+description="${description}"
+supervisor="supervise-daemon"
+command="${command%% *}"
+command_args="$([[ "${command}" == *" "* ]] && printf '%s' "${command#* }")"
+
+depend() {
+    need localmount
+    after sbx-firstboot
+}
+EOF
+        else
+          cat >"${MOUNT_DIR}/etc/init.d/${name}" <<EOF
+#!/sbin/openrc-run
+# Managed by sbx.
+
+description="${description}"
+
+depend() {
+    need localmount
+}
+
+start() {
+    ${command}
+}
+EOF
+        fi
+        chmod 0755 "${MOUNT_DIR}/etc/init.d/${name}"
+      fi
+      chroot "${MOUNT_DIR}" rc-update add "${name}" default >/dev/null
+      ;;
+    systemd)
+      if [[ ! -e "${MOUNT_DIR}/usr/lib/systemd/system/${name}.service" && ! -e "${MOUNT_DIR}/lib/systemd/system/${name}.service" ]]; then
+        local service='Type=oneshot
+RemainAfterExit=yes'
+        [[ "${kind}" == "daemon" ]] && service='Type=simple
+Restart=always'
+        cat >"${MOUNT_DIR}/etc/systemd/system/${name}.service" <<EOF
+# Managed by sbx.
+[Unit]
+Description=${description}
+After=local-fs.target network.target
+
+[Service]
+${service}
+ExecStart=${command}
+
+[Install]
+WantedBy=multi-user.target
+EOF
+      fi
+      chroot "${MOUNT_DIR}" systemctl enable "${name}.service" >/dev/null 2>&1
+      ;;
+    sbx)
+      if [[ "${kind}" == "daemon" ]]; then
+        printf '::respawn:%s\n' "${command}" >>"${MOUNT_DIR}/etc/inittab"
+        return
+      fi
+      install -d -m 0755 "${MOUNT_DIR}/etc/sbx/rc.d"
+      printf '#!/bin/sh\n# Managed by sbx: %s.\nexec %s\n' "${description}" "${command}" >"${MOUNT_DIR}/etc/sbx/rc.d/${order}-${name}"
+      chmod 0755 "${MOUNT_DIR}/etc/sbx/rc.d/${order}-${name}"
+      ;;
+  esac
+}
+
+# setup_serial_console starts a getty on the ttyS0 Firecracker serial console.
+setup_serial_console() {
+  local getty='ttyS0::respawn:/sbin/getty -L 0 ttyS0 vt100'
+  case "${INIT}" in
+    openrc)
+      sed -i 's|^#\(ttyS0::respawn:.*\)$|\1|' "${MOUNT_DIR}/etc/inittab"
+      append_if_missing '^ttyS0::' "${getty}" "${MOUNT_DIR}/etc/inittab"
+      ;;
+    systemd)
+      chroot "${MOUNT_DIR}" systemctl enable serial-getty@ttyS0.service >/dev/null 2>&1
+      ;;
+    sbx)
+      cat >"${MOUNT_DIR}/etc/inittab" <<EOF
+# Managed by sbx: busybox init without a service manager.
+::sysinit:/usr/sbin/sbx-rc
+${getty}
+::ctrlaltdel:/sbin/reboot
+::shutdown:/bin/umount -a -r
+EOF
+      ;;
+  esac
+}
+
 maybe_shrink_image() {
   local image_path="$1"
   if [[ "${SHRINK_IMAGE}" != "true" ]]; then
@@ -159,32 +506,62 @@ maybe_shrink_image() {
   log "Shrunk image size to $((final_bytes / 1024 / 1024)) MB"
 }
 
-# --- Main build ---
+# Punches holes for the all-zero blocks of an image (the unused filesystem
+# blocks), which keeps its size but not its disk usage.
+dig_holes() {
+  local image_path="$1"
+  if ! command -v fallocate >/dev/null 2>&1; then
+    warn "Skipping hole punching (missing fallocate), ${image_path} is not sparse"
+    return
+  fi
+  fallocate --dig-holes "${image_path}"
+}
 
-ALPINE_MAKE_ROOTFS="$(resolve_alpine_make_rootfs)"
+# --- Main build ---
 
-mapfile -t PROFILE_PACKAGES < <(read_profile_packages "${PROFILE_FILE}")
+if [[ "${BOOTSTRAP}" == "alpine-make-rootfs" ]]; then
+  ALPINE_MAKE_ROOTFS="$(resolve_alpine_make_rootfs)"
+fi
 
-declare -A seen=()
-ALL_PACKAGES=()
-for p in "${REQUIRED_PACKAGES[@]}" "${PROFILE_PACKAGES[@]}"; do
-  if [[ -z "${seen[$p]:-}" ]]; then
-    seen[$p]=1
-    ALL_PACKAGES+=("$p")
-  fi
-done
-PACKAGES_STR="${ALL_PACKAGES[*]}"
+PACKAGES_STR="${PACKAGES[*]}"
 
 log "Profile: ${PROFILE}"
-log "Alpine branch: ${ALPINE_BRANCH}"
+log "Distro: ${DISTRO} ${ALPINE_BRANCH}"
+log "Bootstrap: ${BOOTSTRAP}"
 log "Arch: ${ARCH}"
+log "Init system: ${INIT}"
+log "First boot service: ${INSTALL_FIRSTBOOT}"
+log "cloud-init: ${CLOUD_INIT}"
+log "Timezone: ${TIMEZONE}, locale: ${LOCALE}, hostname: ${HOSTNAME_:-distro default}"
+log "Kernel modules: ${MODULES_FILES[*]:-none}"
+log "Profile files: ${FILES_DIRS[*]:-none}"
 log "Output: ${OUTPUT_PATH}"
-log "Using alpine-make-rootfs: ${ALPINE_MAKE_ROOTFS}"
+if [[ "${BOOTSTRAP}" == "alpine-make-rootfs" ]]; then
+  log "Using alpine-make-rootfs: ${ALPINE_MAKE_ROOTFS}"
+fi
 
 mkdir -p "${ROOTFS_DIR}" "${MOUNT_DIR}" "${OUTPUT_DIR}"
 
-log "Building rootfs with alpine-make-rootfs"
-"${ALPINE_MAKE_ROOTFS}" --branch "${ALPINE_BRANCH}" --packages "${PACKAGES_STR}" "${ROOTFS_DIR}"
+log "Building rootfs with ${BOOTSTRAP}"
+if [[ "${BOOTSTRAP}" == "minirootfs" ]]; then
+  bootstrap_minirootfs
+elif [[ -n "${BASE_TAR}" ]]; then
+  tar -xf "${BASE_TAR}" --numeric-owner -C "${ROOTFS_DIR}"
+  if [[ "${BOOTSTRAP}" == "oci" ]]; then
+    log "Installing the packages in the container image rootfs"
+    install_packages
+  fi
+else
+  "${ALPINE_MAKE_ROOTFS}" --branch "${ALPINE_BRANCH}" --packages "${PACKAGES_STR}" "${ROOTFS_DIR}"
+fi
+
+# Installed before sizing the image, every kernel flavor has its own
+# lib/modules/<release> tree. /lib may be a symlink into /usr on merged-usr
+# releases, keep it.
+for f in "${MODULES_FILES[@]}"; do
+  log "Installing kernel modules from ${f}"
+  zstd -q -d -c "${f}" | tar -x --keep-directory-symlink -C "${ROOTFS_DIR}"
+done
 
 SIZE_MB="$(du -sm "${ROOTFS_DIR}" | cut -f1)"
 EXTRA_MB=$((SIZE_MB * OVERHEAD_PERCENT / 100))
@@ -194,20 +571,39 @@ fi
 TOTAL_MB=$((SIZE_MB + EXTRA_MB))
 
 log "Rootfs size: ${SIZE_MB} MB"
-log "Image overhead: ${EXTRA_MB} MB (${OVERHEAD_PERCENT}%, min ${MIN_OVERHEAD_MB} MB)"
+if [[ -n "${IMAGE_SIZE_MB}" ]]; then
+  (( IMAGE_SIZE_MB > SIZE_MB )) || die "Rootfs (${SIZE_MB} MB) does not fit in --size-mib ${IMAGE_SIZE_MB}"
+  TOTAL_MB="${IMAGE_SIZE_MB}"
+  SHRINK_IMAGE="false"
+else
+  log "Image overhead: ${EXTRA_MB} MB (${OVERHEAD_PERCENT}%, min ${MIN_OVERHEAD_MB} MB)"
+fi
 log "Creating ext4 image (${TOTAL_MB} MB)"
 dd if=/dev/zero of="${EXT4_PATH}" bs=1M count="${TOTAL_MB}" status=none
-mkfs.ext4 -q "${EXT4_PATH}"
+mkfs.ext4 -q ${EXT4_MKFS_OPTIONS} "${EXT4_PATH}"
 
 log "Copying rootfs into ext4 image"
 mount "${EXT4_PATH}" "${MOUNT_DIR}"
 cp -a "${ROOTFS_DIR}"/. "${MOUNT_DIR}/"
 
-log "Configuring OpenSSH and SBX hook directories"
-chroot "${MOUNT_DIR}" rc-update add sshd default >/dev/null
+log "Configuring OpenSSH, the ${INIT} services and SBX hook directories"
+if [[ "${INIT}" == "systemd" ]]; then
+  [[ -x "${MOUNT_DIR}/usr/lib/systemd/systemd" || -x "${MOUNT_DIR}/lib/systemd/systemd" ]] || die "systemd not found in built rootfs"
+fi
+if [[ "${INIT}" == "sbx" ]]; then
+  install_image_file "${FILES_DIR}/usr/sbin/sbx-rc" "usr/sbin/sbx-rc" 0755
+fi
+setup_serial_console
+if [[ "${DISTRO}" == "debian" ]]; then
+  # The package generated host keys at build time and enabled its ssh
+  # service, every sandbox gets its own keys from the sshd service instead.
+  rm -f "${MOUNT_DIR}"/etc/ssh/ssh_host_*
+  chroot "${MOUNT_DIR}" systemctl disable ssh.service ssh.socket >/dev/null 2>&1 || true
+fi
+enable_service sshd 50 oneshot "OpenSSH server" "/bin/sh -c 'mkdir -p /run/sshd && ssh-keygen -A && /usr/sbin/sshd'"
 chroot "${MOUNT_DIR}" passwd -d root >/dev/null
 
-if ! chroot "${MOUNT_DIR}" /bin/sh -c 'command -v apk >/dev/null 2>&1'; then
+if [[ "${DISTRO}" == "alpine" ]] && ! chroot "${MOUNT_DIR}" /bin/sh -c 'command -v apk >/dev/null 2>&1'; then
   die "apk not found in built rootfs. Ensure apk-tools is available in selected profile."
 fi
 
@@ -231,11 +627,196 @@ install_image_file "${FILES_DIR}/root/.ssh/rc" "root/.ssh/rc" 0700
 install_image_file "${FILES_DIR}/usr/local/bin/sbx-start-hooks" "usr/local/bin/sbx-start-hooks" 0755
 mkdir -p "${MOUNT_DIR}/etc/sbx/hooks/start.d"
 
+# Inert unless booted with sbx.selfcheck=1 (boot-matrix self check boot).
+install_image_file "${FILES_DIR}/usr/sbin/sbx-selfcheck" "usr/sbin/sbx-selfcheck" 0755
+install_image_file "${FILES_DIR}/etc/init.d/sbx-selfcheck" "etc/init.d/sbx-selfcheck" 0755
+enable_service sbx-selfcheck 60 oneshot "SBX boot test clock and entropy self check" /usr/sbin/sbx-selfcheck
+
+if [[ "${INSTALL_FIRSTBOOT}" == "true" ]]; then
+  log "Installing SBX first boot service"
+  install_image_file "${FILES_DIR}/usr/sbin/sbx-firstboot" "usr/sbin/sbx-firstboot" 0755
+  install_image_file "${FILES_DIR}/etc/init.d/sbx-firstboot" "etc/init.d/sbx-firstboot" 0755
+  mkdir -p "${MOUNT_DIR}/etc/sbx/firstboot.d"
+  enable_service sbx-firstboot 10 oneshot "SBX first boot configuration" /usr/sbin/sbx-firstboot
+fi
+
+log "Configuring the timezone, locale and hostname"
+if [[ "${TIMEZONE}" == "UTC" ]]; then
+  ln -sf /usr/share/zoneinfo/UTC "${MOUNT_DIR}/etc/localtime"
+else
+  [[ -f "${MOUNT_DIR}/usr/share/zoneinfo/${TIMEZONE}" ]] || die "Unknown timezone (is tzdata installed?): ${TIMEZONE}"
+  # Copied so the zoneinfo database could be removed from the image.
+  cp "${MOUNT_DIR}/usr/share/zoneinfo/${TIMEZONE}" "${MOUNT_DIR}/etc/localtime"
+fi
+printf '%s\n' "${TIMEZONE}" >"${MOUNT_DIR}/etc/timezone"
+printf 'LANG=%s\n' "${LOCALE}" >"${MOUNT_DIR}/etc/locale.conf"
+printf '# Managed by sbx.\nexport LANG=%s\nexport LC_ALL=%s\n' "${LOCALE}" "${LOCALE}" >"${MOUNT_DIR}/etc/profile.d/sbx-locale.sh"
+if [[ -n "${HOSTNAME_}" ]]; then
+  printf '%s\n' "${HOSTNAME_}" >"${MOUNT_DIR}/etc/hostname"
+  append_if_missing "[[:space:]]${HOSTNAME_//./\\.}([[:space:]]|$)" "127.0.1.1 ${HOSTNAME_}" "${MOUNT_DIR}/etc/hosts"
+fi
+
+if [[ "${CLOUD_INIT}" == "true" ]]; then
+  log "Enabling cloud-init with the NoCloud datasource"
+  if [[ "${INIT}" == "openrc" ]]; then
+    chroot "${MOUNT_DIR}" setup-cloud-init >/dev/null
+  else
+    chroot "${MOUNT_DIR}" systemctl enable cloud-init-local.service cloud-init.service cloud-config.service cloud-final.service >/dev/null 2>&1
+  fi
+  mkdir -p "${MOUNT_DIR}/etc/cloud/cloud.cfg.d"
+  printf '# Managed by sbx.\ndatasource_list: [NoCloud, None]\n' >"${MOUNT_DIR}/etc/cloud/cloud.cfg.d/90-sbx-nocloud.cfg"
+fi
+
+# Profile files are copied last so they override the SBX files, owned by
+# root.
+for d in "${FILES_DIRS[@]}"; do
+  log "Copying profile files from ${d}"
+  cp -r --preserve=mode,timestamps "${d}"/. "${MOUNT_DIR}/"
+done
+
+if [[ -n "${FILES_STAGE}" && -f "${FILES_STAGE}/ignition" ]]; then
+  log "Installing Ignition and the SBX Ignition first boot service"
+  install_image_file "${FILES_STAGE}/ignition" "usr/bin/ignition" 0755
+  install_image_file "${FILES_STAGE}/ignition.ign" "usr/lib/ignition/base.d/sbx.ign" 0644
+  install_image_file "${FILES_DIR}/usr/sbin/sbx-ignition" "usr/sbin/sbx-ignition" 0755
+  enable_service sbx-ignition 05 oneshot "SBX Ignition first boot configuration" /usr/sbin/sbx-ignition
+fi
+
+# The agent is registered last, after the first boot service wrote its
+# configuration.
+if [[ -n "${FILES_STAGE}" && -f "${FILES_STAGE}/agent.list" ]]; then
+  IFS='|' read -r agent_name agent_dest agent_args <"${FILES_STAGE}/agent.list"
+  log "Installing the ${agent_name} agent at ${agent_dest}"
+  install_image_file "${FILES_STAGE}/agent" "${agent_dest#/}" 0755
+  enable_service "${agent_name}" 90 daemon "${agent_name} guest agent" "${agent_dest}${agent_args:+ ${agent_args}}"
+fi
+
+# Users are set up before the staged files, which may be owned by them.
+# Existing users (e.g. root) keep their UID and get the shell, groups and
+# keys.
+if [[ -n "${FILES_STAGE}" ]]; then
+  while IFS='|' read -r user uid shell groups; do
+    [[ -n "${user}" ]] || continue
+    [[ -x "${MOUNT_DIR}${shell}" ]] || die "Shell of user ${user} not found in image: ${shell}"
+    if chroot "${MOUNT_DIR}" id "${user}" >/dev/null 2>&1; then
+      log "Configuring user ${user} (${shell})"
+      sed -i "s|^\(${user}:\([^:]*:\)\{5\}\)[^:]*$|\1${shell}|" "${MOUNT_DIR}/etc/passwd"
+    else
+      log "Creating user ${user} (${shell})"
+      if [[ "${DISTRO}" == "debian" ]]; then
+        chroot "${MOUNT_DIR}" useradd -m -s "${shell}" ${uid:+-u "${uid}"} "${user}" >/dev/null
+      else
+        chroot "${MOUNT_DIR}" adduser -D -s "${shell}" ${uid:+-u "${uid}"} "${user}" >/dev/null
+      fi
+      # No password but not locked, sshd refuses key logins of locked users.
+      sed -i "s|^${user}:!:|${user}:*:|" "${MOUNT_DIR}/etc/shadow"
+    fi
+    for group in ${groups//,/ }; do
+      if [[ "${DISTRO}" == "debian" ]]; then
+        chroot "${MOUNT_DIR}" getent group "${group}" >/dev/null || chroot "${MOUNT_DIR}" groupadd "${group}" >/dev/null
+        chroot "${MOUNT_DIR}" usermod -a -G "${group}" "${user}" >/dev/null
+      else
+        chroot "${MOUNT_DIR}" getent group "${group}" >/dev/null || chroot "${MOUNT_DIR}" addgroup "${group}" >/dev/null
+        chroot "${MOUNT_DIR}" addgroup "${user}" "${group}" >/dev/null
+      fi
+    done
+    if [[ -s "${FILES_STAGE}/keys/${user}" ]]; then
+      IFS=: read -r _ _ user_uid user_gid _ home _ < <(chroot "${MOUNT_DIR}" getent passwd "${user}")
+      install_image_file "${FILES_STAGE}/keys/${user}" "${home#/}/.ssh/authorized_keys" 0600
+      chmod 0700 "${MOUNT_DIR}${home}/.ssh"
+      chown -R "${user_uid}:${user_gid}" "${MOUNT_DIR}${home}/.ssh"
+    fi
+  done <"${FILES_STAGE}/users.list"
+fi
+
+# Owners are resolved against the image users, so the files are chowned
+# inside the chroot.
+if [[ -n "${FILES_STAGE}" ]]; then
+  while IFS='|' read -r name dest mode owner; do
+    [[ -n "${name}" ]] || continue
+    log "Installing ${dest} (${mode} ${owner})"
+    install_image_file "${FILES_STAGE}/${name}" "${dest#/}" "${mode}"
+    chroot "${MOUNT_DIR}" chown "${owner}" "${dest}"
+  done <"${FILES_STAGE}/files.list"
+fi
+
+record_toolchain
+
+log "Exporting package database for SBOM generation"
+cp "${MOUNT_DIR}/${PKGDB}" "${PKGDB_PATH}"
+
+# Made from the finished tree, the ext4 lost+found left out. mksquashfs
+# dates the image SOURCE_DATE_EPOCH when set, mkfs.erofs is given it. The
+# btrfs image has the ext4 image size and is filled through a compressing
+# mount, the guest keeps compressing with the same mount options.
+for fs in "${FILESYSTEMS[@]}"; do
+  image_path="${OUTPUT_DIR}/rootfs-${STEM}.${fs}"
+  mount_options=""
+  rm -f "${image_path}"
+  log "Creating ${fs} image: ${image_path}"
+  case "${fs}" in
+    squashfs)
+      mkfs_options="${SQUASHFS_MKFS_OPTIONS}"
+      mksquashfs "${MOUNT_DIR}" "${image_path}" ${mkfs_options} -e lost+found -no-progress >/dev/null
+      ;;
+    erofs)
+      mkfs_options="${EROFS_MKFS_OPTIONS}"
+      mkfs.erofs ${mkfs_options} --exclude-path=lost+found ${SOURCE_DATE_EPOCH:+-T "${SOURCE_DATE_EPOCH}"} \
+        "${image_path}" "${MOUNT_DIR}" >/dev/null
+      ;;
+    btrfs)
+      mkfs_options="${BTRFS_MKFS_OPTIONS}"
+      mount_options="${BTRFS_MOUNT_OPTIONS}"
+      truncate -s "${TOTAL_MB}M" "${image_path}"
+      mkfs.btrfs -q ${mkfs_options} "${image_path}"
+      mkdir -p "${BTRFS_MOUNT_DIR}"
+      mount -o "${mount_options}" "${image_path}" "${BTRFS_MOUNT_DIR}"
+      tar -C "${MOUNT_DIR}" --exclude=./lost+found --xattrs --numeric-owner -cf - . | tar -C "${BTRFS_MOUNT_DIR}" --xattrs --numeric-owner -xf -
+      umount "${BTRFS_MOUNT_DIR}"
+      dig_holes "${image_path}"
+      ;;
+  esac
+  printf 'mkfs_options=%s\nmount_options=%s\n' "${mkfs_options}" "${mount_options}" >"${image_path}.mkfs"
+done
+
 umount "${MOUNT_DIR}"
 
 maybe_shrink_image "${EXT4_PATH}"
+dig_holes "${EXT4_PATH}"
 
 mv "${EXT4_PATH}" "${OUTPUT_PATH}"
+printf 'mkfs_options=%s\nmount_options=\n' "${EXT4_MKFS_OPTIONS}" >"${OUTPUT_PATH}.mkfs"
+
+if [[ -n "${OVERLAY_SIZE_MB}" ]]; then
+  overlay_path="${OUTPUT_DIR}/rootfs-${STEM}.overlay.ext4"
+  overlay_uuid="$(printf '%s' "sbx-overlay-${STEM}" | sha256sum | sed -E 's/^(.{8})(.{4})(.{4})(.{4})(.{12}).*/\1-\2-\3-\4-\5/')"
+  mkfs_options="-L sbx-overlay -U ${overlay_uuid} -E hash_seed=${overlay_uuid},lazy_itable_init=0"
+  log "Creating ${OVERLAY_SIZE_MB} MB overlay disk template: ${overlay_path}"
+  rm -f "${overlay_path}"
+  truncate -s "${OVERLAY_SIZE_MB}M" "${overlay_path}"
+  E2FSPROGS_FAKE_TIME="${SOURCE_DATE_EPOCH:-0}" mkfs.ext4 -q ${mkfs_options} "${overlay_path}"
+  printf 'mkfs_options=%s\nmount_options=\n' "${mkfs_options}" >"${overlay_path}.mkfs"
+fi
+
+if [[ -n "${VERITY}" ]]; then
+  verity_root="${OUTPUT_DIR}/rootfs-${STEM}.${VERITY}"
+  verity_path="${verity_root}.verity"
+  verity_salt="$(sha256sum "${verity_root}" | cut -d' ' -f1)"
+  verity_uuid="$(printf '%s' "sbx-verity-${STEM}" | sha256sum | sed -E 's/^(.{8})(.{4})(.{4})(.{4})(.{12}).*/\1-\2-\3-\4-\5/')"
+  log "Creating dm-verity hash tree: ${verity_path}"
+  rm -f "${verity_path}"
+  veritysetup format --hash=sha256 --data-block-size=4096 --hash-block-size=4096 \
+    --salt="${verity_salt}" --uuid="${verity_uuid}" "${verity_root}" "${verity_path}" |
+    awk -F':[[:space:]]*' '
+      $1 == "Root hash"       { print "root_hash=" $2 }
+      $1 == "Salt"            { print "salt=" $2 }
+      $1 == "Hash algorithm"  { print "hash_algorithm=" $2 }
+      $1 == "Data block size" { print "data_block_size=" $2 }
+      $1 == "Hash block size" { print "hash_block_size=" $2 }
+      $1 == "Data blocks"     { print "data_blocks=" $2 }
+    ' >"${verity_path}.info"
+  log "dm-verity root hash: $(sed -n 's/^root_hash=//p' "${verity_path}.info")"
+fi
 
 log "Built image: ${OUTPUT_PATH}"
 log "Done"
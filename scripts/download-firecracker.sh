@@ -0,0 +1,66 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Downloads the upstream Firecracker release archive of an architecture,
+# verifies it against the upstream published SHA256 file and extracts the
+# firecracker and jailer binaries (firecracker-<arch>, jailer-<arch>). The
+# archive URL and upstream digest are written to firecracker-<arch>.source
+# for the manifest.
+#
+# Usage:
+#   ./scripts/download-firecracker.sh --arch x86_64 --version v1.14.1 --output-dir build
+
+ARCH=""
+VERSION=""
+OUTPUT_DIR=""
+
+RELEASES_URL="${FIRECRACKER_RELEASES_URL:-https://github.com/firecracker-microvm/firecracker/releases/download}"
+
+log() { printf '[INFO] %s\n' "$*"; }
+die() { printf '[ERROR] %s\n' "$*" >&2; exit 1; }
+
+while [[ $# -gt 0 ]]; do
+  case "$1" in
+    --arch)       ARCH="$2";       shift 2 ;;
+    --version)    VERSION="$2";    shift 2 ;;
+    --output-dir) OUTPUT_DIR="$2"; shift 2 ;;
+    *) die "Unknown argument: $1" ;;
+  esac
+done
+
+[[ -n "${ARCH}" ]]       || die "--arch is required"
+[[ -n "${VERSION}" ]]    || die "--version is required"
+[[ -n "${OUTPUT_DIR}" ]] || die "--output-dir is required"
+
+for tool in curl tar sha256sum; do
+  command -v "${tool}" >/dev/null 2>&1 || die "${tool} is required"
+done
+
+ARCHIVE="firecracker-${VERSION}-${ARCH}.tgz"
+ARCHIVE_URL="${RELEASES_URL}/${VERSION}/${ARCHIVE}"
+WORKDIR="$(mktemp -d -t sbx-firecracker-XXXXXX)"
+trap 'rm -rf "${WORKDIR}"' EXIT
+
+mkdir -p "${OUTPUT_DIR}"
+
+log "Downloading ${ARCHIVE_URL}"
+curl --fail --silent --show-error --location --output "${WORKDIR}/${ARCHIVE}" "${ARCHIVE_URL}"
+curl --fail --silent --show-error --location --output "${WORKDIR}/${ARCHIVE}.sha256.txt" "${ARCHIVE_URL}.sha256.txt"
+
+UPSTREAM_SHA256="$(awk '{print $1; exit}' "${WORKDIR}/${ARCHIVE}.sha256.txt")"
+[[ "${UPSTREAM_SHA256}" =~ ^[0-9a-f]{64}$ ]] || die "No SHA256 digest in ${ARCHIVE_URL}.sha256.txt"
+ACTUAL_SHA256="$(sha256sum "${WORKDIR}/${ARCHIVE}" | awk '{print $1}')"
+[[ "${ACTUAL_SHA256}" == "${UPSTREAM_SHA256}" ]] \
+  || die "Checksum mismatch for ${ARCHIVE}: upstream ${UPSTREAM_SHA256}, downloaded ${ACTUAL_SHA256}"
+log "Verified ${ARCHIVE} against the upstream checksum"
+
+tar -xzf "${WORKDIR}/${ARCHIVE}" -C "${WORKDIR}"
+RELEASE_DIR="${WORKDIR}/release-${VERSION}-${ARCH}"
+for bin in firecracker jailer; do
+  [[ -f "${RELEASE_DIR}/${bin}-${VERSION}-${ARCH}" ]] || die "No ${bin} binary in ${ARCHIVE}"
+  install -m 0755 "${RELEASE_DIR}/${bin}-${VERSION}-${ARCH}" "${OUTPUT_DIR}/${bin}-${ARCH}"
+done
+
+printf 'version=%s\nurl=%s\nsha256=%s\n' "${VERSION}" "${ARCHIVE_URL}" "${UPSTREAM_SHA256}" > "${OUTPUT_DIR}/firecracker-${ARCH}.source"
+
+log "Extracted firecracker and jailer ${VERSION}: ${OUTPUT_DIR}/{firecracker,jailer}-${ARCH}"
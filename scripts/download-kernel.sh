@@ -1,15 +1,28 @@
 #!/usr/bin/env bash
 set -euo pipefail
 
-# Downloads a Linux kernel binary from the Firecracker CI S3 bucket.
+# Downloads a Linux kernel binary from the Firecracker CI S3 bucket, along
+# with the kernel .config it was built with (vmlinux-<arch>.config). The
+# config is fetched from the bucket, falling back to the config embedded in
+# the kernel (CONFIG_IKCONFIG). With --modules-url the kernel modules archive
+# is downloaded to modules-<arch>.tar.zst.
+#
+# The kernel is verified against the SHA256 file published next to it in the
+# bucket (vmlinux-<version>.sha256), failing on mismatch. The upstream URL and
+# digest are written to vmlinux-<arch>.upstream for the provenance.
+#
+# Files of named kernel flavors (--flavor) are named <name>-<flavor>-<arch>.
 #
 # Usage:
-#   ./scripts/download-kernel.sh --arch x86_64 --kernel-version 6.1.155 --ci-version v1.15 --output-dir build
+#   ./scripts/download-kernel.sh --arch x86_64 --kernel-version 6.1.155 --ci-version v1.15 --output-dir build \
+#     [--flavor full] [--modules-url https://example.com/modules-x86_64.tar.zst]
 
 ARCH=""
 KERNEL_VERSION=""
 CI_VERSION=""
 OUTPUT_DIR=""
+FLAVOR="default"
+MODULES_URL=""
 
 log() { printf '[INFO] %s\n' "$*"; }
 die() { printf '[ERROR] %s\n' "$*" >&2; exit 1; }
@@ -20,6 +33,8 @@ while [[ $# -gt 0 ]]; do
     --kernel-version) KERNEL_VERSION="$2"; shift 2 ;;
     --ci-version)    CI_VERSION="$2";     shift 2 ;;
     --output-dir)    OUTPUT_DIR="$2";     shift 2 ;;
+    --flavor)        FLAVOR="$2";         shift 2 ;;
+    --modules-url)   MODULES_URL="$2";    shift 2 ;;
     *) die "Unknown argument: $1" ;;
   esac
 done
@@ -29,19 +44,78 @@ done
 [[ -n "${CI_VERSION}" ]]     || die "--ci-version is required"
 [[ -n "${OUTPUT_DIR}" ]]     || die "--output-dir is required"
 
-command -v curl >/dev/null 2>&1 || die "curl is required"
+for tool in curl sha256sum; do
+  command -v "${tool}" >/dev/null 2>&1 || die "${tool} is required"
+done
 
 S3_URL="https://s3.amazonaws.com/spec.ccfc.min/firecracker-ci/${CI_VERSION}/${ARCH}/vmlinux-${KERNEL_VERSION}"
-OUTPUT_FILE="${OUTPUT_DIR}/vmlinux-${ARCH}"
+STEM="${ARCH}"
+if [[ "${FLAVOR}" != "default" ]]; then
+  STEM="${FLAVOR}-${ARCH}"
+fi
+OUTPUT_FILE="${OUTPUT_DIR}/vmlinux-${STEM}"
+CONFIG_FILE="${OUTPUT_FILE}.config"
+UPSTREAM_FILE="${OUTPUT_FILE}.upstream"
+MODULES_FILE="${OUTPUT_DIR}/modules-${STEM}.tar.zst"
 
 mkdir -p "${OUTPUT_DIR}"
 
+# extract_ikconfig writes the gzip compressed config following the IKCFG_ST
+# marker of the kernel image to stdout. zcat complains about the trailing
+# kernel data after the gzip stream, so only the output is checked.
+extract_ikconfig() {
+  local offset
+  offset="$(grep -abo -m1 'IKCFG_ST' "$1" | cut -d: -f1)" || return 1
+  [[ -n "${offset}" ]] || return 1
+  tail -c "+$((offset + 9))" "$1" | { zcat 2>/dev/null || true; }
+}
+
 if [[ -f "${OUTPUT_FILE}" ]]; then
   log "Kernel already exists: ${OUTPUT_FILE}"
+else
+  log "Downloading kernel: ${S3_URL}"
+  curl --fail --silent --show-error --location --output "${OUTPUT_FILE}" "${S3_URL}"
+  log "Downloaded kernel: ${OUTPUT_FILE} ($(du -h "${OUTPUT_FILE}" | cut -f1))"
+fi
+
+# Previously downloaded kernels are verified too.
+UPSTREAM_SHA256="$(curl --fail --silent --show-error --location "${S3_URL}.sha256" | awk '{print $1; exit}')" \
+  || die "No upstream checksum published for ${S3_URL}"
+[[ "${UPSTREAM_SHA256}" =~ ^[0-9a-f]{64}$ ]] || die "No SHA256 digest in ${S3_URL}.sha256"
+ACTUAL_SHA256="$(sha256sum "${OUTPUT_FILE}" | awk '{print $1}')"
+if [[ "${ACTUAL_SHA256}" != "${UPSTREAM_SHA256}" ]]; then
+  rm -f "${OUTPUT_FILE}"
+  die "Checksum mismatch for ${S3_URL}: upstream ${UPSTREAM_SHA256}, downloaded ${ACTUAL_SHA256}"
+fi
+printf 'url=%s\nsha256=%s\n' "${S3_URL}" "${UPSTREAM_SHA256}" > "${UPSTREAM_FILE}"
+log "Verified kernel against the upstream checksum"
+
+if [[ -n "${MODULES_URL}" ]]; then
+  if [[ -f "${MODULES_FILE}" ]]; then
+    log "Kernel modules already exist: ${MODULES_FILE}"
+  else
+    log "Downloading kernel modules: ${MODULES_URL}"
+    curl --fail --silent --show-error --location --output "${MODULES_FILE}" "${MODULES_URL}"
+    log "Downloaded kernel modules: ${MODULES_FILE} ($(du -h "${MODULES_FILE}" | cut -f1))"
+  fi
+fi
+
+if [[ -f "${CONFIG_FILE}" ]]; then
+  log "Kernel config already exists: ${CONFIG_FILE}"
+  exit 0
+fi
+
+log "Downloading kernel config: ${S3_URL}.config"
+if curl --fail --silent --show-error --location --output "${CONFIG_FILE}" "${S3_URL}.config"; then
+  log "Downloaded kernel config: ${CONFIG_FILE}"
   exit 0
 fi
 
-log "Downloading kernel: ${S3_URL}"
-curl --fail --silent --show-error --location --output "${OUTPUT_FILE}" "${S3_URL}"
+log "Kernel config not published, extracting the embedded config"
+if extract_ikconfig "${OUTPUT_FILE}" > "${CONFIG_FILE}" && [[ -s "${CONFIG_FILE}" ]]; then
+  log "Extracted kernel config: ${CONFIG_FILE}"
+  exit 0
+fi
 
-log "Downloaded kernel: ${OUTPUT_FILE} ($(du -h "${OUTPUT_FILE}" | cut -f1))"
+rm -f "${CONFIG_FILE}"
+die "No kernel config found for ${OUTPUT_FILE} (not published and CONFIG_IKCONFIG is disabled)"
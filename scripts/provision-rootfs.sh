@@ -0,0 +1,140 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Provisions a derived ext4 rootfs image from a released one, for
+# cmd/provision. The --image tree is extracted (debugfs rdump, owners and
+# modes kept, device nodes dropped), the --files-dirs are copied over it in
+# order, the --packages installed with the --distro package manager in a
+# chroot and the authorized_keys files of --keys-dir (one per user, named
+# after it) installed in the home of their existing user. The tree is then
+# written to --output with mkfs.ext4 --ext4-options, --size-mib large,
+# sparse. The package database is exported next to it as
+# <output without .ext4>.{apkdb,debdb}.
+#
+# Runs as root, or as root of a user namespace (cmd/provision sets one up
+# for other users) with its own mount and PID namespaces for /dev and /proc.
+#
+# Usage:
+#   ./scripts/provision-rootfs.sh --image releases/v0.1.0/rootfs-x86_64.ext4 --distro alpine \
+#     --output build/rootfs-ci-x86_64.ext4 --size-mib 1024 [--packages git,make] \
+#     [--files-dirs ci/files] [--keys-dir build/provision/keys] [--ext4-options "-m 0"]
+
+IMAGE=""
+OUTPUT_PATH=""
+DISTRO="alpine"
+PACKAGES=""
+FILES_DIRS=()
+KEYS_DIR=""
+SIZE_MB=""
+EXT4_OPTIONS=""
+
+log() { printf '[INFO] %s\n' "$*"; }
+warn() { printf '[WARN] %s\n' "$*"; }
+die() { printf '[ERROR] %s\n' "$*" >&2; exit 1; }
+
+while [[ $# -gt 0 ]]; do
+  case "$1" in
+    --image)        IMAGE="$2";        shift 2 ;;
+    --output)       OUTPUT_PATH="$2";  shift 2 ;;
+    --distro)       DISTRO="$2";       shift 2 ;;
+    --packages)     PACKAGES="$2";     shift 2 ;;
+    --files-dirs)   IFS=, read -ra FILES_DIRS <<< "$2"; shift 2 ;;
+    --keys-dir)     KEYS_DIR="$2";     shift 2 ;;
+    --size-mib)     SIZE_MB="$2";      shift 2 ;;
+    --ext4-options) EXT4_OPTIONS="$2"; shift 2 ;;
+    *) die "Unknown argument: $1" ;;
+  esac
+done
+
+[[ -f "${IMAGE}" ]]       || die "--image not found: ${IMAGE}"
+[[ -n "${OUTPUT_PATH}" ]] || die "--output is required"
+[[ -n "${SIZE_MB}" ]]     || die "--size-mib is required"
+[[ "$(id -u)" == "0" ]]   || die "Must run as root (or root of a user namespace)"
+for d in "${FILES_DIRS[@]}"; do
+  [[ -d "${d}" ]] || die "Files directory not found: ${d}"
+done
+for tool in debugfs mkfs.ext4; do
+  command -v "${tool}" >/dev/null 2>&1 || die "Missing ${tool} (e2fsprogs)"
+done
+
+case "${DISTRO}" in
+  debian)
+    PKGDB="var/lib/dpkg/status"
+    PKGDB_PATH="${OUTPUT_PATH%.ext4}.debdb"
+    ;;
+  alpine)
+    PKGDB="lib/apk/db/installed"
+    PKGDB_PATH="${OUTPUT_PATH%.ext4}.apkdb"
+    ;;
+  *) die "Unsupported distro: ${DISTRO}" ;;
+esac
+
+WORKDIR="$(mktemp -d)"
+ROOTFS_DIR="${WORKDIR}/rootfs"
+cleanup() {
+  umount "${ROOTFS_DIR}/proc" 2>/dev/null || true
+  umount "${ROOTFS_DIR}/dev" 2>/dev/null || true
+  rm -rf "${WORKDIR}"
+}
+trap cleanup EXIT
+
+log "Extracting ${IMAGE}"
+mkdir -p "${ROOTFS_DIR}"
+debugfs -R "rdump / ${ROOTFS_DIR}" "${IMAGE}" >/dev/null 2>&1 || die "Failed to extract ${IMAGE}"
+[[ -f "${ROOTFS_DIR}/${PKGDB}" ]] || die "${IMAGE} has no ${DISTRO} package database (${PKGDB})"
+rm -rf "${ROOTFS_DIR}/lost+found"
+mkdir -p "${ROOTFS_DIR}/dev" "${ROOTFS_DIR}/proc"
+
+for d in "${FILES_DIRS[@]}"; do
+  log "Copying files from ${d}"
+  cp -r --preserve=mode,timestamps "${d}"/. "${ROOTFS_DIR}/"
+done
+
+if [[ -n "${PACKAGES}" ]]; then
+  log "Installing packages: ${PACKAGES}"
+  cp -L /etc/resolv.conf "${ROOTFS_DIR}/etc/resolv.conf"
+  mount --rbind /dev "${ROOTFS_DIR}/dev"
+  mount -t proc proc "${ROOTFS_DIR}/proc"
+  # shellcheck disable=SC2086 # one word per package.
+  if [[ "${DISTRO}" == "debian" ]]; then
+    chroot "${ROOTFS_DIR}" /bin/sh -c 'apt-get update -q && DEBIAN_FRONTEND=noninteractive apt-get install -q -y --no-install-recommends "$@"' sh ${PACKAGES//,/ }
+    chroot "${ROOTFS_DIR}" apt-get clean
+    rm -rf "${ROOTFS_DIR}/var/lib/apt/lists/"*
+  else
+    chroot "${ROOTFS_DIR}" /sbin/apk add --no-cache ${PACKAGES//,/ }
+    rm -rf "${ROOTFS_DIR}/var/cache/apk/"*
+  fi
+  umount "${ROOTFS_DIR}/proc"
+  umount -l "${ROOTFS_DIR}/dev"
+  rm -f "${ROOTFS_DIR}/etc/resolv.conf"
+fi
+
+if [[ -n "${KEYS_DIR}" ]]; then
+  for keys in "${KEYS_DIR}"/*; do
+    [[ -s "${keys}" ]] || continue
+    user="$(basename "${keys}")"
+    entry="$(chroot "${ROOTFS_DIR}" getent passwd "${user}")" || die "User ${user} not found in image"
+    IFS=: read -r _ _ user_uid user_gid _ home _ <<< "${entry}"
+    log "Installing SSH authorized keys of ${user}"
+    install -D -m 0600 "${keys}" "${ROOTFS_DIR}${home}/.ssh/authorized_keys"
+    chmod 0700 "${ROOTFS_DIR}${home}/.ssh"
+    chown -R "${user_uid}:${user_gid}" "${ROOTFS_DIR}${home}/.ssh"
+  done
+fi
+
+log "Exporting package database for the manifest"
+cp "${ROOTFS_DIR}/${PKGDB}" "${PKGDB_PATH}"
+
+log "Writing ${OUTPUT_PATH} (${SIZE_MB} MiB)"
+rm -f "${OUTPUT_PATH}"
+truncate -s "${SIZE_MB}M" "${OUTPUT_PATH}"
+# shellcheck disable=SC2086 # one word per option.
+mkfs.ext4 -q -F ${EXT4_OPTIONS} -d "${ROOTFS_DIR}" "${OUTPUT_PATH}" ||
+  die "Failed to write ${OUTPUT_PATH}, the tree may not fit in ${SIZE_MB} MiB"
+if command -v fallocate >/dev/null 2>&1; then
+  fallocate --dig-holes "${OUTPUT_PATH}"
+else
+  warn "Skipping hole punching (missing fallocate), ${OUTPUT_PATH} is not sparse"
+fi
+
+log "Provisioned ${OUTPUT_PATH}"